@@ -0,0 +1,67 @@
+package influxdb
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/influxdb/influxdb/influxql"
+)
+
+// APIToken represents a long-lived credential for a user that is limited to
+// privileges on specific databases. Only a hash of the token's secret is stored.
+type APIToken struct {
+	ID         string                        `json:"id"`
+	Username   string                        `json:"username"`
+	Hash       string                        `json:"hash"`
+	Privileges map[string]influxql.Privilege `json:"privileges"` // db name to privilege
+	CreatedAt  time.Time                     `json:"createdAt"`
+
+	// The time the token expires. A zero value never expires.
+	ExpiresAt time.Time `json:"expiresAt,omitempty"`
+}
+
+// expired returns true if the token has expired at now.
+func (t *APIToken) expired(now time.Time) bool {
+	return !t.ExpiresAt.IsZero() && !now.Before(t.ExpiresAt)
+}
+
+// matches returns true if secret is the token's secret.
+func (t *APIToken) matches(secret string) bool {
+	return hmac.Equal([]byte(t.Hash), []byte(apiTokenHash(secret)))
+}
+
+// apiTokens represents a list of API tokens, sortable by id.
+type apiTokens []*APIToken
+
+func (p apiTokens) Len() int           { return len(p) }
+func (p apiTokens) Less(i, j int) bool { return p[i].ID < p[j].ID }
+func (p apiTokens) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+// newAPITokenSecret returns a random id & secret for a new API token.
+func newAPITokenSecret() (id, secret string, err error) {
+	b := make([]byte, 8+32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	return hex.EncodeToString(b[:8]), hex.EncodeToString(b[8:]), nil
+}
+
+// apiTokenHash returns the stored hash of an API token's secret.
+func apiTokenHash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// splitAPIToken splits an API token into its id & secret.
+// Returns false if the token is not an API token.
+func splitAPIToken(token string) (id, secret string, ok bool) {
+	a := strings.Split(token, ".")
+	if len(a) != 2 || a[0] == "" || a[1] == "" {
+		return "", "", false
+	}
+	return a[0], a[1], true
+}
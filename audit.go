@@ -0,0 +1,112 @@
+package influxdb
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/influxdb/influxdb/influxql"
+)
+
+// AuditEvent represents an administrative or data-access operation performed
+// by a user.
+type AuditEvent struct {
+	Time      time.Time `json:"time"`
+	Username  string    `json:"username,omitempty"`
+	Action    string    `json:"action"`
+	Database  string    `json:"database,omitempty"`
+	Statement string    `json:"statement"`
+	Err       string    `json:"error,omitempty"`
+}
+
+// AuditSink represents an object that records audit events.
+type AuditSink interface {
+	WriteAuditEvent(e *AuditEvent) error
+}
+
+// AuditWriter writes audit events to a writer, such as a file, as one JSON
+// object per line. It is safe for concurrent use.
+type AuditWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewAuditWriter returns a new instance of AuditWriter.
+func NewAuditWriter(w io.Writer) *AuditWriter {
+	return &AuditWriter{w: w}
+}
+
+// WriteAuditEvent writes an event as a line of JSON.
+func (w *AuditWriter) WriteAuditEvent(e *AuditEvent) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_, err = w.w.Write(append(b, '\n'))
+	return err
+}
+
+// AuditChan is an audit sink that sends events on a channel. Events are
+// dropped, and an error returned, if the channel is full so that a slow
+// receiver never blocks the server.
+type AuditChan chan *AuditEvent
+
+// WriteAuditEvent sends an event on the channel.
+func (c AuditChan) WriteAuditEvent(e *AuditEvent) error {
+	select {
+	case c <- e:
+		return nil
+	default:
+		return ErrAuditChanFull
+	}
+}
+
+// auditAction returns the name of the action a statement performs and true if
+// it modifies users, privileges or schema. Returns "query" and false for
+// statements that only read data.
+func auditAction(stmt influxql.Statement) (string, bool) {
+	switch stmt.(type) {
+	case *influxql.CreateUserStatement:
+		return "create_user", true
+	case *influxql.DropUserStatement:
+		return "drop_user", true
+	case *influxql.GrantStatement:
+		return "grant", true
+	case *influxql.RevokeStatement:
+		return "revoke", true
+	case *influxql.CreateDatabaseStatement:
+		return "create_database", true
+	case *influxql.DropDatabaseStatement:
+		return "drop_database", true
+	case *influxql.CreateRetentionPolicyStatement:
+		return "create_retention_policy", true
+	case *influxql.AlterRetentionPolicyStatement:
+		return "alter_retention_policy", true
+	case *influxql.DropRetentionPolicyStatement:
+		return "drop_retention_policy", true
+	case *influxql.DropShardStatement:
+		return "drop_shard", true
+	case *influxql.DropSeriesStatement:
+		return "drop_series", true
+	case *influxql.CreateContinuousQueryStatement:
+		return "create_continuous_query", true
+	case *influxql.DropContinuousQueryStatement:
+		return "drop_continuous_query", true
+	default:
+		return "query", false
+	}
+}
+
+// auditStatement returns the text of a statement with any password removed.
+func auditStatement(stmt influxql.Statement) string {
+	if stmt, ok := stmt.(*influxql.CreateUserStatement); ok {
+		other := *stmt
+		other.Password = "[REDACTED]"
+		return other.String()
+	}
+	return stmt.String()
+}
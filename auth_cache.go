@@ -0,0 +1,59 @@
+package influxdb
+
+import (
+	"crypto/sha256"
+	"sync"
+	"time"
+)
+
+// authCache represents a cache of successful password verifications so that
+// repeated requests from a user don't each pay for a bcrypt comparison.
+// Passwords are only stored as a hash. It is safe for concurrent use.
+type authCache struct {
+	mu      sync.Mutex
+	entries map[string]*authCacheEntry // by user name
+}
+
+// authCacheEntry represents a verified password for a single user.
+type authCacheEntry struct {
+	hash    string   // user's password hash at verification time
+	sum     [32]byte // digest of the verified password
+	expires time.Time
+}
+
+// newAuthCache returns a new, empty instance of authCache.
+func newAuthCache() *authCache {
+	return &authCache{entries: make(map[string]*authCacheEntry)}
+}
+
+// get returns true if password was verified for the user and hasn't expired.
+// Entries for a previous password hash of the user are ignored.
+func (c *authCache) get(u *User, password string, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e := c.entries[u.Name]
+	if e == nil || e.hash != u.Hash || !now.Before(e.expires) {
+		return false
+	}
+	return e.sum == authCacheSum(u, password)
+}
+
+// set records a verified password for the user until expires.
+func (c *authCache) set(u *User, password string, expires time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[u.Name] = &authCacheEntry{hash: u.Hash, sum: authCacheSum(u, password), expires: expires}
+}
+
+// delete removes the entry for a user.
+func (c *authCache) delete(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, name)
+}
+
+// authCacheSum returns the digest of a password salted with the user's password hash.
+func authCacheSum(u *User, password string) [32]byte {
+	return sha256.Sum256([]byte(u.Hash + "\x00" + password))
+}
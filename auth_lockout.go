@@ -0,0 +1,64 @@
+package influxdb
+
+import (
+	"sync"
+	"time"
+)
+
+// authLockout tracks consecutive failed password verifications for each user
+// so that a user can be locked out after too many failures. Failures are only
+// tracked on the local server. It is safe for concurrent use.
+type authLockout struct {
+	mu      sync.Mutex
+	entries map[string]*authLockoutEntry // by user name
+}
+
+// authLockoutEntry represents the recent failures of a single user.
+type authLockoutEntry struct {
+	n           int       // consecutive failures
+	last        time.Time // time of the most recent failure
+	lockedUntil time.Time
+}
+
+// newAuthLockout returns a new instance of authLockout.
+func newAuthLockout() *authLockout {
+	return &authLockout{entries: make(map[string]*authLockoutEntry)}
+}
+
+// locked returns true if the user is locked out at now.
+func (l *authLockout) locked(name string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	e := l.entries[name]
+	return e != nil && now.Before(e.lockedUntil)
+}
+
+// fail records a failure for the user. Failures more than window apart are not
+// consecutive. The user is locked out for window once max failures are reached.
+// Returns true if the failure locked the user out.
+func (l *authLockout) fail(name string, max int, window time.Duration, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e := l.entries[name]
+	if e == nil || now.Sub(e.last) > window {
+		e = &authLockoutEntry{}
+		l.entries[name] = e
+	}
+	e.n++
+	e.last = now
+
+	if e.n < max {
+		return false
+	}
+	e.n = 0
+	e.lockedUntil = now.Add(window)
+	return true
+}
+
+// delete clears the failures and any lockout of a user.
+func (l *authLockout) delete(name string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.entries, name)
+}
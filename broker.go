@@ -4,6 +4,10 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/influxdb/influxdb/messaging"
@@ -15,8 +19,8 @@ type Broker struct {
 
 	done chan struct{}
 
-	// send CQ processing requests to the same data node
-	currentCQProcessingNode *messaging.Replica
+	// data nodes that failed the last CQ processing request
+	cqFailedNodes map[uint64]bool
 
 	// variables to control when to trigger processing and when to timeout
 	TriggerInterval     time.Duration
@@ -78,38 +82,61 @@ func (b *Broker) continuousQueryLoop(done chan struct{}) {
 	}
 }
 
+// runContinuousQueries asks every data node to run its share of the continuous
+// queries. Queries are spread across the data nodes that answered the previous
+// request so a node that is down doesn't stall the queries it was assigned.
 func (b *Broker) runContinuousQueries() {
-	next := 0
-	for {
-		// if the current node hasn't been set it's our first time or we're reset. move to the next one
-		if b.currentCQProcessingNode == nil {
-			dataNodes := b.Broker.Replicas()
-			if len(dataNodes) == 0 {
-				return // don't have any nodes to try, give it up
-			}
-			next = next % len(dataNodes)
-			b.currentCQProcessingNode = dataNodes[next]
-			next++
-		}
+	dataNodes := b.Broker.Replicas()
+	if len(dataNodes) == 0 {
+		return // don't have any nodes to try, give it up
+	}
 
-		// if no error, we're all good
-		err := b.requestContinuousQueryProcessing()
-		if err == nil {
-			return
+	// Distribute the queries across the nodes that are up. If none answered
+	// last time then try all of them again.
+	var nodeIDs []uint64
+	for _, n := range dataNodes {
+		if !b.cqFailedNodes[n.ID()] {
+			nodeIDs = append(nodeIDs, n.ID())
 		}
-		log.Printf("broker cq: error hitting data node: %s: %s\n", b.currentCQProcessingNode.URL, err.Error())
+	}
+	if len(nodeIDs) == 0 {
+		for _, n := range dataNodes {
+			nodeIDs = append(nodeIDs, n.ID())
+		}
+	}
 
-		// reset and let the loop try the next data node in the cluster
-		b.currentCQProcessingNode = nil
-		<-time.After(DefaultFailureSleep)
+	// Send the requests in parallel. Nodes that fail are left out of the
+	// distribution until they answer again.
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	failed := make(map[uint64]bool)
+	for _, n := range dataNodes {
+		wg.Add(1)
+		go func(n *messaging.Replica) {
+			defer wg.Done()
+			if err := b.requestContinuousQueryProcessing(n, nodeIDs); err != nil {
+				log.Printf("broker cq: error hitting data node: %s: %s\n", n.URL, err.Error())
+				mu.Lock()
+				failed[n.ID()] = true
+				mu.Unlock()
+			}
+		}(n)
 	}
+	wg.Wait()
+
+	b.cqFailedNodes = failed
 }
 
-func (b *Broker) requestContinuousQueryProcessing() error {
+func (b *Broker) requestContinuousQueryProcessing(n *messaging.Replica, nodeIDs []uint64) error {
 	// Send request.
-	cqURL := copyURL(b.currentCQProcessingNode.URL)
+	ids := make([]string, len(nodeIDs))
+	for i, id := range nodeIDs {
+		ids[i] = strconv.FormatUint(id, 10)
+	}
+	cqURL := copyURL(n.URL)
 	cqURL.Path = "/process_continuous_queries"
 	cqURL.Scheme = "http"
+	cqURL.RawQuery = url.Values{"nodes": {strings.Join(ids, ",")}}.Encode()
 	client := &http.Client{
 		Timeout: DefaultDataNodeTimeout,
 	}
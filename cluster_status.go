@@ -0,0 +1,234 @@
+package influxdb
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/influxdb/influxdb/influxql"
+)
+
+// ClusterStatus describes the data nodes, shard replication and broker
+// replication of the cluster as seen by a data node.
+type ClusterStatus struct {
+	DataNodes                  []*DataNodeStatus            `json:"dataNodes"`
+	UnderReplicatedShardGroups []*UnderReplicatedShardGroup `json:"underReplicatedShardGroups,omitempty"`
+
+	// The highest broker index applied by this data node, the highest broker
+	// index this data node has published to, and the difference between them.
+	Index       uint64 `json:"index"`
+	BrokerIndex uint64 `json:"brokerIndex"`
+	IndexLag    uint64 `json:"indexLag"`
+}
+
+// DataNodeStatus describes a single data node in the cluster.
+type DataNodeStatus struct {
+	ID     uint64 `json:"id"`
+	URL    string `json:"url"`
+	Zone   string `json:"zone,omitempty"`
+	Alive  bool   `json:"alive"`
+	ShardN int    `json:"shards"` // shards owned by the node
+	Size   int64  `json:"size"`   // bytes stored by the node; zero if not alive
+	Index  uint64 `json:"index"`  // broker index applied by the node; zero if not alive
+}
+
+// UnderReplicatedShardGroup represents a shard group with a shard that has
+// fewer live owners than its retention policy's replication factor.
+type UnderReplicatedShardGroup struct {
+	Database     string `json:"database"`
+	Policy       string `json:"policy"`
+	ID           uint64 `json:"id"`
+	ReplicaN     int    `json:"replicaN"`     // replicas required by the policy
+	LiveReplicaN int    `json:"liveReplicaN"` // fewest live owners of any shard
+}
+
+// underReplicatedShardGroups represents a list of shard groups sorted by database, policy and id.
+type underReplicatedShardGroups []*UnderReplicatedShardGroup
+
+func (a underReplicatedShardGroups) Len() int      { return len(a) }
+func (a underReplicatedShardGroups) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a underReplicatedShardGroups) Less(i, j int) bool {
+	if a[i].Database != a[j].Database {
+		return a[i].Database < a[j].Database
+	} else if a[i].Policy != a[j].Policy {
+		return a[i].Policy < a[j].Policy
+	}
+	return a[i].ID < a[j].ID
+}
+
+// dataNodeState represents the state reported by a data node's status endpoint.
+type dataNodeState struct {
+	ID    uint64 `json:"id"`
+	Index uint64 `json:"index"`
+	Size  int64  `json:"size"`
+}
+
+// ClusterStatus returns the status of the cluster. The state of each remote
+// data node is requested from the node's status endpoint. Nodes that don't
+// respond within DefaultDataNodePingTimeout are reported as not alive.
+func (s *Server) ClusterStatus() (*ClusterStatus, error) {
+	nodes, id := s.DataNodes(), s.ID()
+
+	// Retrieve the state of the local node and all remote nodes in parallel.
+	states := make([]*dataNodeState, len(nodes))
+	var wg sync.WaitGroup
+	for i, n := range nodes {
+		if n.ID == id {
+			size, err := s.LocalShardSize()
+			if err != nil {
+				return nil, err
+			}
+			states[i] = &dataNodeState{ID: id, Index: s.Index(), Size: size}
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, u *url.URL) {
+			defer wg.Done()
+			states[i], _ = getDataNodeState(u, DefaultDataNodePingTimeout)
+		}(i, n.URL)
+	}
+	wg.Wait()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	status := &ClusterStatus{
+		Index:       s.index,
+		BrokerIndex: atomic.LoadUint64(&s.brokerIndex),
+	}
+	if status.BrokerIndex > status.Index {
+		status.IndexLag = status.BrokerIndex - status.Index
+	}
+
+	// Count the shards owned by each node.
+	shardN := make(map[uint64]int)
+	for _, sh := range s.shards {
+		for _, nodeID := range sh.DataNodeIDs {
+			shardN[nodeID]++
+		}
+	}
+
+	alive := make(map[uint64]bool)
+	for i, n := range nodes {
+		st := &DataNodeStatus{ID: n.ID, URL: n.URL.String(), Zone: n.Zone, ShardN: shardN[n.ID]}
+		if states[i] != nil {
+			st.Alive, st.Size, st.Index = true, states[i].Size, states[i].Index
+			alive[n.ID] = true
+		}
+		status.DataNodes = append(status.DataNodes, st)
+	}
+
+	// Find shard groups with shards that have too few live owners.
+	for _, db := range s.databases {
+		for _, rp := range db.policies {
+			replicaN := int(rp.ReplicaN)
+			if replicaN == 0 {
+				replicaN = 1
+			} else if replicaN > len(s.dataNodes) {
+				replicaN = len(s.dataNodes)
+			}
+
+			for _, g := range rp.shardGroups {
+				liveN := replicaN
+				for _, sh := range g.Shards {
+					var n int
+					for _, nodeID := range sh.DataNodeIDs {
+						if alive[nodeID] {
+							n++
+						}
+					}
+					if n < liveN {
+						liveN = n
+					}
+				}
+				if liveN < replicaN {
+					status.UnderReplicatedShardGroups = append(status.UnderReplicatedShardGroups, &UnderReplicatedShardGroup{
+						Database:     db.name,
+						Policy:       rp.Name,
+						ID:           g.ID,
+						ReplicaN:     replicaN,
+						LiveReplicaN: liveN,
+					})
+				}
+			}
+		}
+	}
+	sort.Sort(underReplicatedShardGroups(status.UnderReplicatedShardGroups))
+
+	return status, nil
+}
+
+// LocalShardSize returns the total size, in bytes, of the shards stored on
+// this data node.
+func (s *Server) LocalShardSize() (n int64, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, sh := range s.shards {
+		sz, err := sh.size()
+		if err != nil {
+			return 0, err
+		}
+		n += sz
+	}
+	return n, nil
+}
+
+// setBrokerIndex records an index returned by the broker if it is the highest seen.
+func (s *Server) setBrokerIndex(index uint64) {
+	for {
+		prev := atomic.LoadUint64(&s.brokerIndex)
+		if index <= prev || atomic.CompareAndSwapUint64(&s.brokerIndex, prev, index) {
+			return
+		}
+	}
+}
+
+// getDataNodeState requests the state of the data node at u from its status endpoint.
+func getDataNodeState(u *url.URL, timeout time.Duration) (*dataNodeState, error) {
+	other := *u
+	other.Path = "/status"
+
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Get(other.String())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status: %s", resp.Status)
+	}
+
+	var state dataNodeState
+	if err := json.NewDecoder(resp.Body).Decode(&state); err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+func (s *Server) executeShowClusterStatement(stmt *influxql.ShowClusterStatement, user *User) *Result {
+	status, err := s.ClusterStatus()
+	if err != nil {
+		return &Result{Err: err}
+	}
+
+	nodes := &influxql.Row{Name: "data_nodes", Columns: []string{"id", "url", "zone", "alive", "shards", "size", "index"}}
+	for _, n := range status.DataNodes {
+		nodes.Values = append(nodes.Values, []interface{}{n.ID, n.URL, n.Zone, n.Alive, n.ShardN, n.Size, n.Index})
+	}
+
+	groups := &influxql.Row{Name: "under_replicated_shard_groups", Columns: []string{"database", "retention_policy", "id", "replica_n", "live_replica_n"}}
+	for _, g := range status.UnderReplicatedShardGroups {
+		groups.Values = append(groups.Values, []interface{}{g.Database, g.Policy, g.ID, g.ReplicaN, g.LiveReplicaN})
+	}
+
+	broker := &influxql.Row{Name: "broker", Columns: []string{"index", "broker_index", "index_lag"}}
+	broker.Values = append(broker.Values, []interface{}{status.Index, status.BrokerIndex, status.IndexLag})
+
+	return &Result{Rows: []*influxql.Row{nodes, groups, broker}}
+}
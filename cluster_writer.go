@@ -0,0 +1,100 @@
+package influxdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+)
+
+// forwardShardPoints writes a batch of encoded points directly to every data
+// node that owns a shard, bypassing the broker. Returns an error if any owner
+// fails to write the batch.
+func (s *Server) forwardShardPoints(sh *Shard, data [][]byte) error {
+	s.mu.RLock()
+	owners := append([]uint64{}, sh.DataNodeIDs...)
+	s.mu.RUnlock()
+
+	body := marshalPointBatch(data)
+	for _, id := range owners {
+		if id == s.ID() {
+			if err := s.WriteShardPoints(sh.ID, bytes.NewReader(body)); err != nil {
+				return err
+			}
+			continue
+		}
+
+		n := s.DataNode(id)
+		if n == nil {
+			return ErrDataNodeNotFound
+		}
+		u := *n.URL
+		u.Path = "/shards/" + strconv.FormatUint(sh.ID, 10) + "/write"
+		resp, err := s.doNodeRequest("POST", &u, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNoContent {
+			return fmt.Errorf("data node %d: %s", id, resp.Status)
+		}
+	}
+	return nil
+}
+
+// WriteShardPoints writes a batch of encoded points forwarded by another data
+// node to a local shard.
+func (s *Server) WriteShardPoints(id uint64, r io.Reader) error {
+	sh := s.Shard(id)
+	if sh == nil || !sh.hasStore() {
+		return ErrShardNotFound
+	}
+
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	data, err := unmarshalPointBatch(b)
+	if err != nil {
+		return err
+	}
+
+	for _, d := range data {
+		if err := s.writeRawPoint(sh, d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// marshalPointBatch encodes a batch of encoded points, each prefixed by its length.
+func marshalPointBatch(data [][]byte) []byte {
+	var buf bytes.Buffer
+	for _, d := range data {
+		_ = binary.Write(&buf, binary.BigEndian, uint32(len(d)))
+		buf.Write(d)
+	}
+	return buf.Bytes()
+}
+
+// unmarshalPointBatch decodes a batch encoded by marshalPointBatch.
+func unmarshalPointBatch(b []byte) ([][]byte, error) {
+	var data [][]byte
+	for len(b) > 0 {
+		if len(b) < 4 {
+			return nil, fmt.Errorf("point batch: short length")
+		}
+		n := int(binary.BigEndian.Uint32(b[0:4]))
+		b = b[4:]
+		if n < pointHeaderSize || n > len(b) {
+			return nil, fmt.Errorf("point batch: invalid point length: %d", n)
+		}
+		data = append(data, b[:n])
+		b = b[n:]
+	}
+	return data, nil
+}
@@ -54,7 +54,13 @@ type Config struct {
 	} `toml:"initialization"`
 
 	Authentication struct {
-		Enabled bool `toml:"enabled"`
+		Enabled     bool     `toml:"enabled"`
+		TokenSecret string   `toml:"token-secret"`
+		CacheTTL    Duration `toml:"cache-ttl"`
+		NodeSecret  string   `toml:"node-secret"`
+		MaxFailures int      `toml:"max-failures"`
+		Lockout     Duration `toml:"lockout"`
+		BcryptCost  int      `toml:"bcrypt-cost"`
 	} `toml:"authentication"`
 
 	Admin struct {
@@ -92,20 +98,57 @@ type Config struct {
 	} `toml:"broker"`
 
 	Data struct {
-		Dir                   string   `toml:"dir"`
-		Port                  int      `toml:"port"`
-		RetentionCheckEnabled bool     `toml:"retention-check-enabled"`
-		RetentionCheckPeriod  Duration `toml:"retention-check-period"`
+		Dir                     string   `toml:"dir"`
+		Port                    int      `toml:"port"`
+		Zone                    string   `toml:"zone"`
+		RetentionCheckEnabled   bool     `toml:"retention-check-enabled"`
+		RetentionCheckPeriod    Duration `toml:"retention-check-period"`
+		ShardRepairEnabled      bool     `toml:"shard-repair-enabled"`
+		ShardRepairPeriod       Duration `toml:"shard-repair-period"`
+		MetastoreCompactEnabled bool     `toml:"metastore-compact-enabled"`
+		MetastoreCompactPeriod  Duration `toml:"metastore-compact-period"`
+		ShardCompactEnabled     bool     `toml:"shard-compact-enabled"`
+		ShardCompactPeriod      Duration `toml:"shard-compact-period"`
+		SeriesGCEnabled         bool     `toml:"series-gc-enabled"`
+		SeriesGCPeriod          Duration `toml:"series-gc-period"`
+		QueryTimeout            Duration `toml:"query-timeout"`
+		MaxSelectPointN         int      `toml:"max-select-points"`
+		MaxSelectSeriesN        int      `toml:"max-select-series"`
+		MaxUserQueryN           int      `toml:"max-queries-per-user"`
+		MaxUserSelectPointN     int      `toml:"max-select-points-per-user"`
+		QueryCacheSize          int      `toml:"query-cache-size"`
+		SeriesIndexCacheSize    int      `toml:"series-index-cache-size"`
+		MaxOpenShards           int      `toml:"max-open-shards"`
+		LazyShardOpen           bool     `toml:"lazy-shard-open"`
+		MaxShardSize            Size     `toml:"max-shard-size"`
+		ShardMmapSize           Size     `toml:"shard-mmap-size"`
+		ShardNoSync             bool     `toml:"shard-no-sync"`
+		ShardFillPercent        float64  `toml:"shard-fill-percent"`
+		SlowQueryThreshold      Duration `toml:"slow-query-threshold"`
+
+		InfiniteShardGroupDuration Duration `toml:"infinite-shard-group-duration"`
+		MinRetentionPolicyDuration Duration `toml:"min-retention-policy-duration"`
+		ShardArchiveDir            string   `toml:"shard-archive-dir"`
+		SnapshotDir                string   `toml:"snapshot-dir"`
 	} `toml:"data"`
 
 	Cluster struct {
-		Dir string `toml:"dir"`
+		Dir                    string   `toml:"dir"`
+		ForwardWrites          bool     `toml:"forward-writes"`
+		JSONCommands           bool     `toml:"json-commands"`
+		MetastoreVerifyEnabled bool     `toml:"metastore-verify-enabled"`
+		MetastoreVerifyPeriod  Duration `toml:"metastore-verify-period"`
 	} `toml:"cluster"`
 
 	Logging struct {
 		File string `toml:"file"`
 	} `toml:"logging"`
 
+	Audit struct {
+		File    string `toml:"file"`
+		Queries bool   `toml:"queries"`
+	} `toml:"audit"`
+
 	ContinuousQuery struct {
 		// when continuous queries are run we'll automatically recompute previous intervals
 		// in case lagged data came in. Set to zero if you never have lagged data. We do
@@ -133,6 +176,24 @@ type Config struct {
 		// If you have a group by time(5m) then you'll get five computes per interval. Any group by time window larger
 		// than 10m will get computed 10 times for each interval.
 		ComputeNoMoreThan Duration `toml:"compute-no-more-than"`
+
+		// MaxConcurrent limits how many continuous queries run at the same time. Queries
+		// that are due wait for a running query to finish. Set to zero for no limit.
+		MaxConcurrent int `toml:"max-concurrent"`
+
+		// Jitter delays the start of each continuous query by up to this duration so that
+		// queries with the same interval don't all run at the top of the minute.
+		Jitter Duration `toml:"jitter"`
+
+		// Remote sends the results of continuous queries writing into a database to another
+		// server instead of writing them locally. Used to ship downsampled data to an archive.
+		Remote []struct {
+			Database       string `toml:"database"`
+			URL            string `toml:"url"`
+			RemoteDatabase string `toml:"remote-database"`
+			Username       string `toml:"username"`
+			Password       string `toml:"password"`
+		} `toml:"remote"`
 	} `toml:"continuous_queries"`
 }
 
@@ -148,12 +209,27 @@ func NewConfig() *Config {
 	c.Data.Port = DefaultDataPort
 	c.Data.RetentionCheckEnabled = true
 	c.Data.RetentionCheckPeriod = Duration(10 * time.Minute)
+	c.Data.ShardRepairEnabled = true
+	c.Data.ShardRepairPeriod = Duration(1 * time.Hour)
+	c.Data.MetastoreCompactEnabled = true
+	c.Data.MetastoreCompactPeriod = Duration(24 * time.Hour)
+	c.Data.ShardCompactEnabled = true
+	c.Data.ShardCompactPeriod = Duration(24 * time.Hour)
+	c.Data.SeriesGCEnabled = true
+	c.Data.SeriesGCPeriod = Duration(1 * time.Hour)
+	c.Data.InfiniteShardGroupDuration = Duration(7 * 24 * time.Hour)
+	c.Data.MinRetentionPolicyDuration = Duration(1 * time.Hour)
+	c.Cluster.MetastoreVerifyEnabled = true
+	c.Cluster.MetastoreVerifyPeriod = Duration(10 * time.Minute)
+	c.Authentication.CacheTTL = Duration(1 * time.Minute)
+	c.Authentication.Lockout = Duration(15 * time.Minute)
 	c.Admin.Enabled = true
 	c.Admin.Port = 8083
 	c.ContinuousQuery.RecomputePreviousN = 2
 	c.ContinuousQuery.RecomputeNoOlderThan = Duration(10 * time.Minute)
 	c.ContinuousQuery.ComputeRunsPerInterval = 10
 	c.ContinuousQuery.ComputeNoMoreThan = Duration(2 * time.Minute)
+	c.ContinuousQuery.MaxConcurrent = 4
 
 	// Detect hostname (or set to localhost).
 	if c.Hostname, _ = os.Hostname(); c.Hostname == "" {
@@ -50,10 +50,28 @@ func TestParseConfig(t *testing.T) {
 		t.Fatalf("logging file mismatch: %v", c.Logging.File)
 	}
 
+	if c.Audit.File != "audit.log" {
+		t.Fatalf("audit file mismatch: %v", c.Audit.File)
+	} else if !c.Audit.Queries {
+		t.Fatalf("audit queries mismatch: %v", c.Audit.Queries)
+	}
+
 	if !c.Authentication.Enabled {
 		t.Fatalf("authentication enabled mismatch: %v", c.Authentication.Enabled)
 	}
 
+	if c.Authentication.NodeSecret != "s3cret" {
+		t.Fatalf("authentication node secret mismatch: %v", c.Authentication.NodeSecret)
+	}
+
+	if c.Authentication.MaxFailures != 5 {
+		t.Fatalf("authentication max failures mismatch: %v", c.Authentication.MaxFailures)
+	} else if time.Duration(c.Authentication.Lockout) != 10*time.Minute {
+		t.Fatalf("authentication lockout mismatch: %v", c.Authentication.Lockout)
+	} else if c.Authentication.BcryptCost != 12 {
+		t.Fatalf("authentication bcrypt cost mismatch: %v", c.Authentication.BcryptCost)
+	}
+
 	if c.Admin.Enabled != true {
 		t.Fatalf("admin enabled mismatch: %v", c.Admin.Enabled)
 	}
@@ -126,16 +144,83 @@ func TestParseConfig(t *testing.T) {
 	if c.Data.Dir != "/tmp/influxdb/development/db" {
 		t.Fatalf("data dir mismatch: %v", c.Data.Dir)
 	}
+	if c.Data.Zone != "rack-1" {
+		t.Fatalf("data zone mismatch: %v", c.Data.Zone)
+	}
 	if c.Data.RetentionCheckEnabled != true {
 		t.Fatalf("Retention check enabled mismatch: %v", c.Data.RetentionCheckEnabled)
 	}
 	if c.Data.RetentionCheckPeriod != main.Duration(5*time.Minute) {
 		t.Fatalf("Retention check period mismatch: %v", c.Data.RetentionCheckPeriod)
 	}
+	if c.Data.ShardRepairEnabled != false {
+		t.Fatalf("Shard repair enabled mismatch: %v", c.Data.ShardRepairEnabled)
+	}
+	if c.Data.ShardRepairPeriod != main.Duration(30*time.Minute) {
+		t.Fatalf("Shard repair period mismatch: %v", c.Data.ShardRepairPeriod)
+	}
+	if c.Data.MetastoreCompactEnabled != false {
+		t.Fatalf("Metastore compact enabled mismatch: %v", c.Data.MetastoreCompactEnabled)
+	}
+	if c.Data.MetastoreCompactPeriod != main.Duration(12*time.Hour) {
+		t.Fatalf("Metastore compact period mismatch: %v", c.Data.MetastoreCompactPeriod)
+	}
+	if c.Data.ShardCompactEnabled != false {
+		t.Fatalf("Shard compact enabled mismatch: %v", c.Data.ShardCompactEnabled)
+	}
+	if c.Data.ShardCompactPeriod != main.Duration(6*time.Hour) {
+		t.Fatalf("Shard compact period mismatch: %v", c.Data.ShardCompactPeriod)
+	}
+	if c.Data.SeriesGCEnabled != false {
+		t.Fatalf("Series gc enabled mismatch: %v", c.Data.SeriesGCEnabled)
+	}
+	if c.Data.SeriesGCPeriod != main.Duration(2*time.Hour) {
+		t.Fatalf("Series gc period mismatch: %v", c.Data.SeriesGCPeriod)
+	}
+	if c.Data.SeriesIndexCacheSize != 1000000 {
+		t.Fatalf("Series index cache size mismatch: %v", c.Data.SeriesIndexCacheSize)
+	}
+
+	if c.Data.MaxOpenShards != 100 {
+		t.Fatalf("Max open shards mismatch: %v", c.Data.MaxOpenShards)
+	}
+
+	if !c.Data.LazyShardOpen {
+		t.Fatalf("Lazy shard open mismatch: %v", c.Data.LazyShardOpen)
+	}
+
+	if c.Data.MaxShardSize != main.Size(10<<30) {
+		t.Fatalf("Max shard size mismatch: %v", c.Data.MaxShardSize)
+	}
+
+	if c.Data.ShardMmapSize != main.Size(512<<20) {
+		t.Fatalf("Shard mmap size mismatch: %v", c.Data.ShardMmapSize)
+	} else if !c.Data.ShardNoSync {
+		t.Fatalf("Shard no sync mismatch: %v", c.Data.ShardNoSync)
+	} else if c.Data.ShardFillPercent != 0.9 {
+		t.Fatalf("Shard fill percent mismatch: %v", c.Data.ShardFillPercent)
+	}
 
 	if c.Cluster.Dir != "/tmp/influxdb/development/cluster" {
 		t.Fatalf("cluster dir mismatch: %v", c.Cluster.Dir)
 	}
+	if !c.Cluster.ForwardWrites {
+		t.Fatalf("cluster forward writes mismatch: %v", c.Cluster.ForwardWrites)
+	} else if !c.Cluster.JSONCommands {
+		t.Fatalf("cluster json commands mismatch: %v", c.Cluster.JSONCommands)
+	}
+	if c.Cluster.MetastoreVerifyEnabled {
+		t.Fatalf("cluster metastore verify enabled mismatch: %v", c.Cluster.MetastoreVerifyEnabled)
+	}
+	if c.Cluster.MetastoreVerifyPeriod != main.Duration(5*time.Minute) {
+		t.Fatalf("cluster metastore verify period mismatch: %v", c.Cluster.MetastoreVerifyPeriod)
+	}
+
+	if len(c.ContinuousQuery.Remote) != 1 {
+		t.Fatalf("continuous query remote count mismatch: %v", len(c.ContinuousQuery.Remote))
+	} else if r := c.ContinuousQuery.Remote[0]; r.Database != "archive" || r.URL != "http://archive.example.com:8086" || r.RemoteDatabase != "longterm" {
+		t.Fatalf("continuous query remote mismatch: %#v", r)
+	}
 
 	// TODO: UDP Servers testing.
 	/*
@@ -163,10 +248,18 @@ join-urls = "http://127.0.0.1:8086"
 # Control authentication
 [authentication]
 enabled = true
+node-secret = "s3cret"
+max-failures = 5
+lockout = "10m"
+bcrypt-cost = 12
 
 [logging]
 file   = "influxdb.log"
 
+[audit]
+file = "audit.log"
+queries = true
+
 # Configure the admin server
 [admin]
 enabled = true
@@ -227,11 +320,36 @@ dir  = "/tmp/influxdb/development/broker"
 
 [data]
 dir = "/tmp/influxdb/development/db"
+zone = "rack-1"
 retention-check-enabled = true
 retention-check-period = "5m"
+shard-repair-enabled = false
+shard-repair-period = "30m"
+metastore-compact-enabled = false
+metastore-compact-period = "12h"
+shard-compact-enabled = false
+shard-compact-period = "6h"
+series-gc-enabled = false
+series-gc-period = "2h"
+series-index-cache-size = 1000000
+max-open-shards = 100
+lazy-shard-open = true
+max-shard-size = "10g"
+shard-mmap-size = "512m"
+shard-no-sync = true
+shard-fill-percent = 0.9
 
 [cluster]
 dir = "/tmp/influxdb/development/cluster"
+forward-writes = true
+json-commands = true
+metastore-verify-enabled = false
+metastore-verify-period = "5m"
+
+[[continuous_queries.remote]]
+database = "archive"
+url = "http://archive.example.com:8086"
+remote-database = "longterm"
 `
 
 func TestCollectd_ConnectionString(t *testing.T) {
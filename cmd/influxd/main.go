@@ -6,6 +6,8 @@ import (
 	"log"
 	"os"
 	"strings"
+
+	"github.com/influxdb/influxdb"
 )
 
 const logo = `
@@ -78,6 +80,7 @@ func execRun(args []string) {
 		pidPath    = fs.String("pidfile", "", "")
 		hostname   = fs.String("hostname", "", "")
 		join       = fs.String("join", "", "")
+		resetRoot  = fs.Bool("reset-root", false, "")
 	)
 	fs.Usage = printRunUsage
 	fs.Parse(args)
@@ -101,7 +104,15 @@ func execRun(args []string) {
 	}
 	log.SetOutput(logWriter)
 
-	Run(config, *join, version, logWriter)
+	_, s := Run(config, *join, version, logWriter)
+
+	// Recover access to the cluster if requested.
+	if *resetRoot && s != nil {
+		if err := s.ResetRootPassword(); err != nil {
+			log.Fatalf("reset root password: %s", err)
+		}
+		log.Printf("reset password of %q user", influxdb.RootUsername)
+	}
 
 	// Wait indefinitely.
 	<-(chan struct{})(nil)
@@ -70,6 +70,51 @@ func Run(config *Config, join, version string, logWriter *os.File) (*messaging.B
 		log.Printf("broker enforcing retention policies with check interval of %s", interval)
 	}
 
+	// Repair shards from their other replicas if requested.
+	if config.Data.ShardRepairEnabled {
+		interval := time.Duration(config.Data.ShardRepairPeriod)
+		if err := s.StartShardRepair(interval); err != nil {
+			log.Fatalf("shard repair failed: %s", err.Error())
+		}
+		log.Printf("repairing shards with check interval of %s", interval)
+	}
+
+	// Compact the metastore if requested.
+	if config.Data.MetastoreCompactEnabled {
+		interval := time.Duration(config.Data.MetastoreCompactPeriod)
+		if err := s.StartMetastoreCompaction(interval); err != nil {
+			log.Fatalf("metastore compaction failed: %s", err.Error())
+		}
+		log.Printf("compacting metastore with check interval of %s", interval)
+	}
+
+	// Compact shards if requested.
+	if config.Data.ShardCompactEnabled {
+		interval := time.Duration(config.Data.ShardCompactPeriod)
+		if err := s.StartShardCompaction(interval); err != nil {
+			log.Fatalf("shard compaction failed: %s", err.Error())
+		}
+		log.Printf("compacting shards with check interval of %s", interval)
+	}
+
+	// Delete series without points if requested.
+	if config.Data.SeriesGCEnabled {
+		interval := time.Duration(config.Data.SeriesGCPeriod)
+		if err := s.StartSeriesCollection(interval); err != nil {
+			log.Fatalf("series collection failed: %s", err.Error())
+		}
+		log.Printf("collecting series with check interval of %s", interval)
+	}
+
+	// Verify the metastore against the other data nodes if requested.
+	if config.Cluster.MetastoreVerifyEnabled {
+		interval := time.Duration(config.Cluster.MetastoreVerifyPeriod)
+		if err := s.StartMetastoreVerification(interval); err != nil {
+			log.Fatalf("metastore verification failed: %s", err.Error())
+		}
+		log.Printf("verifying metastore with check interval of %s", interval)
+	}
+
 	// Start the server handler. Attach to broker if listening on the same port.
 	if s != nil {
 		sh := httpd.NewHandler(s, config.Authentication.Enabled, version)
@@ -242,6 +287,70 @@ func openServer(config *Config, b *influxdb.Broker, initializing, configExists b
 	s.RecomputeNoOlderThan = time.Duration(config.ContinuousQuery.RecomputeNoOlderThan)
 	s.ComputeRunsPerInterval = config.ContinuousQuery.ComputeRunsPerInterval
 	s.ComputeNoMoreThan = time.Duration(config.ContinuousQuery.ComputeNoMoreThan)
+	s.MaxConcurrentContinuousQueries = config.ContinuousQuery.MaxConcurrent
+	s.ContinuousQueryJitter = time.Duration(config.ContinuousQuery.Jitter)
+	s.QueryTimeout = time.Duration(config.Data.QueryTimeout)
+	s.MaxSelectPointN = config.Data.MaxSelectPointN
+	s.MaxSelectSeriesN = config.Data.MaxSelectSeriesN
+	s.MaxUserQueryN = config.Data.MaxUserQueryN
+	s.MaxUserSelectPointN = config.Data.MaxUserSelectPointN
+	s.QueryCacheSize = config.Data.QueryCacheSize
+	s.SeriesIndexCacheSize = config.Data.SeriesIndexCacheSize
+	s.MaxOpenShards = config.Data.MaxOpenShards
+	s.LazyShardOpen = config.Data.LazyShardOpen
+	s.MaxShardSize = int64(config.Data.MaxShardSize)
+	s.ShardStoreOptions = influxdb.ShardStoreOptions{
+		MmapSize:    int(config.Data.ShardMmapSize),
+		NoSync:      config.Data.ShardNoSync,
+		FillPercent: config.Data.ShardFillPercent,
+	}
+	s.SlowQueryThreshold = time.Duration(config.Data.SlowQueryThreshold)
+	s.InfiniteShardGroupDuration = time.Duration(config.Data.InfiniteShardGroupDuration)
+	s.MinRetentionPolicyDuration = time.Duration(config.Data.MinRetentionPolicyDuration)
+	s.ShardArchivePath = config.Data.ShardArchiveDir
+	s.SnapshotPath = config.Data.SnapshotDir
+	s.AuthenticationCacheTTL = time.Duration(config.Authentication.CacheTTL)
+	s.MaxAuthenticationFailures = config.Authentication.MaxFailures
+	s.AuthenticationLockout = time.Duration(config.Authentication.Lockout)
+	if config.Authentication.BcryptCost > 0 {
+		s.PasswordHasher = &influxdb.BcryptHasher{Cost: config.Authentication.BcryptCost}
+	}
+	if config.Authentication.TokenSecret != "" {
+		s.TokenSecret = []byte(config.Authentication.TokenSecret)
+	}
+	if config.Authentication.NodeSecret != "" {
+		s.NodeSecret = []byte(config.Authentication.NodeSecret)
+	} else if config.Authentication.Enabled {
+		log.Println("authentication is enabled without a node secret: data nodes can't join or copy data from this node")
+	}
+	s.ForwardWrites = config.Cluster.ForwardWrites
+	s.JSONCommands = config.Cluster.JSONCommands
+
+	// Record administrative operations to the audit log.
+	if config.Audit.File != "" {
+		f, err := os.OpenFile(config.Audit.File, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+		if err != nil {
+			log.Fatalf("unable to open audit file %s: %s", config.Audit.File, err)
+		}
+		s.AuditSinks = append(s.AuditSinks, influxdb.NewAuditWriter(f))
+		s.AuditQueries = config.Audit.Queries
+	}
+
+	// Send the results of continuous queries for remote databases to their servers.
+	for _, r := range config.ContinuousQuery.Remote {
+		u, err := url.Parse(r.URL)
+		if err != nil {
+			log.Fatalf("invalid continuous query remote url: %s", err)
+		}
+		w := influxdb.NewRemoteWriter(u)
+		w.Database = r.RemoteDatabase
+		w.Username, w.Password = r.Username, r.Password
+
+		if s.ContinuousQueryWriters == nil {
+			s.ContinuousQueryWriters = make(map[string]influxdb.PointsWriter)
+		}
+		s.ContinuousQueryWriters[r.Database] = w
+	}
 
 	if err := s.Open(config.Data.Dir); err != nil {
 		log.Fatalf("failed to open data server: %v", err.Error())
@@ -269,6 +378,13 @@ func openServer(config *Config, b *influxdb.Broker, initializing, configExists b
 		openServerClient(s, joinURLs, w)
 	}
 
+	// Advertise the data node's zone so replicas are spread across zones.
+	if n := s.DataNode(s.ID()); n != nil && n.Zone != config.Data.Zone {
+		if err := s.SetDataNodeZone(n.ID, config.Data.Zone); err != nil {
+			log.Printf("failed to set data node zone: %s", err)
+		}
+	}
+
 	return s
 }
 
@@ -302,22 +418,29 @@ func joinServer(s *influxdb.Server, u *url.URL, joinURLs []*url.URL) {
 	// TODO: Use separate broker and data join urls.
 
 	// Create data node on an existing data node.
-	for _, joinURL := range joinURLs {
-		if err := s.Join(u, joinURL); err != nil {
-			log.Printf("join: failed to connect data node: %s: %s", u, err)
-		} else {
-			log.Printf("join: connected data node to %s", u)
-			return
-		}
+	if err := s.Join(u, joinURLs...); err != nil {
+		log.Fatalf("join: failed to connect data node to any specified server: %s: %s", u, err)
 	}
-	log.Fatalf("join: failed to connect data node to any specified server")
+	log.Printf("join: connected data node to %s", u)
 }
 
 // opens the messaging client and attaches it to the server.
 func openServerClient(s *influxdb.Server, joinURLs []*url.URL, w io.Writer) {
-	c := messaging.NewClient(s.ID())
-	c.SetLogOutput(w)
-	if err := c.Open(filepath.Join(s.Path(), messagingClientFile), joinURLs); err != nil {
+	newClient := func() (influxdb.MessagingClient, error) {
+		c := messaging.NewClient(s.ID())
+		c.SetLogOutput(w)
+		c.SetIndexes(s.TopicIndexes())
+		if err := c.Open(filepath.Join(s.Path(), messagingClientFile), joinURLs); err != nil {
+			return nil, err
+		}
+		return c, nil
+	}
+
+	// Replace the client if its message stream closes.
+	s.ReconnectClient = newClient
+
+	c, err := newClient()
+	if err != nil {
 		log.Fatalf("messaging client error: %s", err)
 	}
 	if err := s.SetClient(c); err != nil {
@@ -368,5 +491,9 @@ is used.
 
         -pidfile <path>
                           Write process ID to a file.
+
+        -reset-root
+                          Reset the password of the root user to the default
+                          and make it an admin, creating the user if needed.
 `)
 }
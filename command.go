@@ -0,0 +1,211 @@
+package influxdb
+
+import (
+	"encoding"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/influxdb/influxdb/influxql"
+)
+
+// commandBinaryVersion is the first byte of a command in the binary encoding.
+// JSON encoded commands start with '{' so both can be read during a rolling
+// upgrade from nodes that only write JSON.
+const commandBinaryVersion = 0x01
+
+// errShortCommand is returned when a binary encoded command is truncated.
+var errShortCommand = errors.New("command: short buffer")
+
+// encodeCommand encodes a broadcast command. Commands that implement
+// encoding.BinaryMarshaler are encoded in binary unless asJSON is set.
+func encodeCommand(c interface{}, asJSON bool) ([]byte, error) {
+	m, ok := c.(encoding.BinaryMarshaler)
+	if !ok || asJSON {
+		return json.Marshal(c)
+	}
+
+	b, err := m.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{commandBinaryVersion}, b...), nil
+}
+
+// mustDecodeCommand decodes a broadcast command encoded in binary or JSON.
+// This will panic if an error occurs, like mustUnmarshalJSON.
+func mustDecodeCommand(b []byte, v interface{}) {
+	if len(b) == 0 || b[0] != commandBinaryVersion {
+		mustUnmarshalJSON(b, v)
+		return
+	}
+
+	u, ok := v.(encoding.BinaryUnmarshaler)
+	if !ok {
+		panic("unmarshal: no binary encoding for command")
+	} else if err := u.UnmarshalBinary(b[1:]); err != nil {
+		panic("unmarshal: " + err.Error())
+	}
+}
+
+// commandEncoder appends the fields of a binary encoded command.
+type commandEncoder struct {
+	buf []byte
+}
+
+func (e *commandEncoder) uvarint(v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	e.buf = append(e.buf, b[:binary.PutUvarint(b[:], v)]...)
+}
+
+func (e *commandEncoder) varint(v int64) {
+	var b [binary.MaxVarintLen64]byte
+	e.buf = append(e.buf, b[:binary.PutVarint(b[:], v)]...)
+}
+
+func (e *commandEncoder) string(s string) {
+	e.uvarint(uint64(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+// stringMap encodes a map sorted by key so equal maps encode the same.
+func (e *commandEncoder) stringMap(m map[string]string) {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	e.uvarint(uint64(len(keys)))
+	for _, k := range keys {
+		e.string(k)
+		e.string(m[k])
+	}
+}
+
+// commandDecoder reads the fields of a binary encoded command. Reads after
+// an error return zero values and the error is kept.
+type commandDecoder struct {
+	buf []byte
+	err error
+}
+
+func (d *commandDecoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		d.err = errShortCommand
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *commandDecoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.buf)
+	if n <= 0 {
+		d.err = errShortCommand
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *commandDecoder) string() string {
+	n := d.uvarint()
+	if d.err != nil {
+		return ""
+	} else if n > uint64(len(d.buf)) {
+		d.err = errShortCommand
+		return ""
+	}
+	s := string(d.buf[:n])
+	d.buf = d.buf[n:]
+	return s
+}
+
+func (d *commandDecoder) stringMap() map[string]string {
+	n := d.uvarint()
+	if d.err != nil {
+		return nil
+	} else if n > uint64(len(d.buf)) {
+		d.err = errShortCommand // each entry takes at least one byte
+		return nil
+	}
+	m := make(map[string]string, n)
+	for i := uint64(0); i < n && d.err == nil; i++ {
+		k := d.string()
+		m[k] = d.string()
+	}
+	return m
+}
+
+// MarshalBinary encodes the command in binary.
+func (c *createShardGroupIfNotExistsCommand) MarshalBinary() ([]byte, error) {
+	var e commandEncoder
+	e.string(c.Database)
+	e.string(c.Policy)
+	e.varint(c.Timestamp.UnixNano())
+	return e.buf, nil
+}
+
+// UnmarshalBinary decodes a command encoded by MarshalBinary.
+func (c *createShardGroupIfNotExistsCommand) UnmarshalBinary(b []byte) error {
+	d := commandDecoder{buf: b}
+	c.Database = d.string()
+	c.Policy = d.string()
+	c.Timestamp = time.Unix(0, d.varint()).UTC()
+	return d.err
+}
+
+// MarshalBinary encodes the command in binary.
+func (c *createSeriesIfNotExistsCommand) MarshalBinary() ([]byte, error) {
+	var e commandEncoder
+	e.string(c.Database)
+	e.string(c.Name)
+	e.stringMap(c.Tags)
+	return e.buf, nil
+}
+
+// UnmarshalBinary decodes a command encoded by MarshalBinary.
+func (c *createSeriesIfNotExistsCommand) UnmarshalBinary(b []byte) error {
+	d := commandDecoder{buf: b}
+	c.Database = d.string()
+	c.Name = d.string()
+	c.Tags = d.stringMap()
+	return d.err
+}
+
+// MarshalBinary encodes the command in binary.
+func (c *createFieldsIfNotExistCommand) MarshalBinary() ([]byte, error) {
+	fields := make(map[string]string, len(c.Fields))
+	for name, typ := range c.Fields {
+		fields[name] = string(typ)
+	}
+
+	var e commandEncoder
+	e.string(c.Database)
+	e.string(c.Measurement)
+	e.stringMap(fields)
+	return e.buf, nil
+}
+
+// UnmarshalBinary decodes a command encoded by MarshalBinary.
+func (c *createFieldsIfNotExistCommand) UnmarshalBinary(b []byte) error {
+	d := commandDecoder{buf: b}
+	c.Database = d.string()
+	c.Measurement = d.string()
+	fields := d.stringMap()
+	c.Fields = make(map[string]influxql.DataType, len(fields))
+	for name, typ := range fields {
+		c.Fields[name] = influxql.DataType(typ)
+	}
+	return d.err
+}
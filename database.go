@@ -8,6 +8,7 @@ import (
 	"regexp"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/influxdb/influxdb/influxql"
@@ -31,8 +32,11 @@ type database struct {
 
 	// in memory indexing structures
 	measurements map[string]*Measurement // measurement name to object and index
-	series       map[uint32]*Series      // map series id to the Series object
+	series       map[uint32]*Series      // map series id to the Series object, unless cache is set
 	names        []string                // sorted list of the measurement names
+
+	// Limits the series held in memory. If nil, every series is indexed in memory.
+	cache *seriesIndexCache
 }
 
 // newDatabase returns an instance of database.
@@ -60,20 +64,19 @@ func (db *database) Series(id uint32) *Series {
 	return db.series[id]
 }
 
-// MarshalJSON encodes a database into a JSON-encoded byte slice.
+// MarshalJSON encodes a database's settings into a JSON-encoded byte slice.
+// Retention policies and continuous queries are stored separately.
 func (db *database) MarshalJSON() ([]byte, error) {
 	// Copy over properties to intermediate type.
 	var o databaseJSON
 	o.Name = db.name
 	o.DefaultRetentionPolicy = db.defaultRetentionPolicy
-	for _, rp := range db.policies {
-		o.Policies = append(o.Policies, rp)
-	}
-	o.ContinuousQueries = db.continuousQueries
 	return json.Marshal(&o)
 }
 
-// UnmarshalJSON decodes a JSON-encoded byte slice to a database.
+// UnmarshalJSON decodes a JSON-encoded byte slice to a database. Databases
+// saved before retention policies and continuous queries were stored
+// separately include them.
 func (db *database) UnmarshalJSON(data []byte) error {
 	// Decode into intermediate type.
 	var o databaseJSON
@@ -94,13 +97,27 @@ func (db *database) UnmarshalJSON(data []byte) error {
 	// we need the parsed continuous queries to be in the in memory index
 	db.continuousQueries = make([]*ContinuousQuery, 0, len(o.ContinuousQueries))
 	for _, cq := range o.ContinuousQueries {
-		c, _ := NewContinuousQuery(cq.Query)
-		db.continuousQueries = append(db.continuousQueries, c)
+		db.continuousQueries = append(db.continuousQueries, loadContinuousQuery(cq))
 	}
 
 	return nil
 }
 
+// loadContinuousQuery returns a continuous query with its statement parsed
+// from a query decoded from the metastore.
+func loadContinuousQuery(o *ContinuousQuery) *ContinuousQuery {
+	c, _ := NewContinuousQuery(o.Query)
+	c.Disabled = o.Disabled
+	c.LastRunTime = o.LastRunTime
+	c.LastRunDuration = o.LastRunDuration
+	c.PointsWritten = o.PointsWritten
+	c.LastError = o.LastError
+
+	// resume scheduling from the last run so a restart doesn't rerun the query early
+	c.lastRun = o.LastRunTime
+	return c
+}
+
 // databaseJSON represents the JSON-serialization format for a database.
 type databaseJSON struct {
 	Name                   string             `json:"name,omitempty"`
@@ -117,12 +134,21 @@ type Measurement struct {
 	Name   string   `json:"name,omitempty"`
 	Fields []*Field `json:"fields,omitempty"`
 
+	// Length of time to keep the measurement's points when shorter than
+	// the retention policy. Zero keeps points for the policy's duration.
+	TTL time.Duration `json:"ttl,omitempty"`
+
 	// in-memory index fields
-	series              map[string]*Series // sorted tagset string to the series object
-	seriesByID          map[uint32]*Series // lookup table for series by their id
-	measurement         *Measurement
-	seriesByTagKeyValue map[string]map[string]seriesIDs // map from tag key to value to sorted set of series ids
-	seriesIDs           seriesIDs                       // sorted list of series IDs in this measurement
+	mu          sync.Mutex
+	idx         *measurementIndex // nil while the series are only in the metastore
+	seriesN     int               // number of series, including those not indexed in memory
+	measurement *Measurement
+
+	// Set when the database limits the series held in memory. The index is
+	// loaded from the metastore when it's used and dropped when it's the
+	// least recently used index over the limit.
+	database string
+	cache    *seriesIndexCache
 }
 
 // NewMeasurement allocates and initializes a new Measurement.
@@ -130,12 +156,60 @@ func NewMeasurement(name string) *Measurement {
 	return &Measurement{
 		Name:   name,
 		Fields: make([]*Field, 0),
+		idx:    newMeasurementIndex(),
+	}
+}
 
+// measurementIndex holds the series of a measurement and the index of their tags.
+type measurementIndex struct {
+	series              map[string]*Series              // sorted tagset string to the series object
+	seriesByID          map[uint32]*Series              // lookup table for series by their id
+	seriesByTagKeyValue map[string]map[string]seriesIDs // map from tag key to value to sorted set of series ids
+	seriesIDs           seriesIDs                       // sorted list of series IDs in this measurement
+
+	// Indexes of tag values for regex matches. They're built when a tag key is
+	// first matched against a regex and dropped when its values change.
+	tagValuesMu sync.Mutex
+	tagValues   map[string]*tagValueIndex
+}
+
+// newMeasurementIndex returns a new, empty instance of measurementIndex.
+func newMeasurementIndex() *measurementIndex {
+	return &measurementIndex{
 		series:              make(map[string]*Series),
 		seriesByID:          make(map[uint32]*Series),
 		seriesByTagKeyValue: make(map[string]map[string]seriesIDs),
 		seriesIDs:           make(seriesIDs, 0),
+		tagValues:           make(map[string]*tagValueIndex),
+	}
+}
+
+// index returns the measurement's index, loading it from the metastore if
+// it isn't held in memory. The returned index stays valid after it's dropped.
+func (m *Measurement) index() *measurementIndex {
+	m.mu.Lock()
+	idx, loaded := m.idx, 0
+	if idx == nil {
+		idx = newMeasurementIndex()
+		for _, s := range m.cache.load(m.database, m.Name) {
+			s.measurement = m
+			idx.addSeries(s)
+		}
+		m.idx, loaded = idx, len(idx.seriesIDs)
+	}
+	m.mu.Unlock()
+
+	if m.cache != nil {
+		m.cache.touch(m, loaded)
 	}
+	return idx
+}
+
+// dropIndex releases the in-memory index. It's reloaded when next used.
+func (m *Measurement) dropIndex() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.idx = nil
 }
 
 // createFieldIfNotExists creates a new field with an autoincrementing ID.
@@ -184,8 +258,42 @@ func (m *Measurement) FieldByName(name string) *Field {
 	return nil
 }
 
-// addSeries will add a series to the measurementIndex. Returns false if already present
+// addSeries will add a series to the measurement. Returns false if already present.
+// A series isn't added to an index that isn't in memory since it's loaded from the
+// metastore, which already contains the series, when it's next used.
 func (m *Measurement) addSeries(s *Series) bool {
+	m.mu.Lock()
+	added := m.idx == nil || m.idx.addSeries(s)
+	if added {
+		m.seriesN++
+	}
+	loaded := m.idx != nil
+	m.mu.Unlock()
+
+	if added && loaded && m.cache != nil {
+		m.cache.touch(m, 1)
+	}
+	return added
+}
+
+// dropSeries removes a series from the measurement. A series is assumed to be
+// present in an index that isn't in memory since it's loaded from the metastore.
+func (m *Measurement) dropSeries(id uint32) {
+	m.mu.Lock()
+	dropped := m.idx == nil || m.idx.dropSeries(id)
+	if dropped {
+		m.seriesN--
+	}
+	loaded := m.idx != nil
+	m.mu.Unlock()
+
+	if dropped && loaded && m.cache != nil {
+		m.cache.touch(m, -1)
+	}
+}
+
+// addSeries will add a series to the measurementIndex. Returns false if already present
+func (m *measurementIndex) addSeries(s *Series) bool {
 	if _, ok := m.seriesByID[s.ID]; ok {
 		return false
 	}
@@ -208,6 +316,9 @@ func (m *Measurement) addSeries(s *Series) bool {
 			m.seriesByTagKeyValue[k] = valueMap
 		}
 		ids := valueMap[v]
+		if len(ids) == 0 {
+			m.dropTagValueIndex(k)
+		}
 		ids = append(ids, s.ID)
 
 		// most of the time the series ID will be higher than all others because it's a new
@@ -221,21 +332,93 @@ func (m *Measurement) addSeries(s *Series) bool {
 	return true
 }
 
+// dropSeries removes a series from the measurementIndex. Returns false if not present.
+func (m *measurementIndex) dropSeries(id uint32) bool {
+	s := m.seriesByID[id]
+	if s == nil {
+		return false
+	}
+	delete(m.seriesByID, id)
+	delete(m.series, string(marshalTags(s.Tags)))
+	m.seriesIDs = m.seriesIDs.reject(seriesIDs{id})
+
+	// remove the series id from the tag index, dropping values without series
+	for k, v := range s.Tags {
+		valueMap := m.seriesByTagKeyValue[k]
+		if ids := valueMap[v].reject(seriesIDs{id}); len(ids) > 0 {
+			valueMap[v] = ids
+		} else {
+			delete(valueMap, v)
+			m.dropTagValueIndex(k)
+		}
+		if len(valueMap) == 0 {
+			delete(m.seriesByTagKeyValue, k)
+		}
+	}
+
+	return true
+}
+
+// seriesIDsByTagRegex returns the sorted ids of the series with a value of a
+// tag key matching re.
+func (m *measurementIndex) seriesIDsByTagRegex(key string, re *regexp.Regexp) seriesIDs {
+	values := m.seriesByTagKeyValue[key]
+	if len(values) == 0 {
+		return nil
+	}
+
+	// A series has a single value per tag key so the values' ids are disjoint.
+	var ids seriesIDs
+	for _, v := range m.tagValueIndex(key).match(re) {
+		ids = append(ids, values[v]...)
+	}
+	sort.Sort(ids)
+	return ids
+}
+
+// tagValueIndex returns the index of a tag key's values, building it if needed.
+func (m *measurementIndex) tagValueIndex(key string) *tagValueIndex {
+	m.tagValuesMu.Lock()
+	defer m.tagValuesMu.Unlock()
+	idx := m.tagValues[key]
+	if idx == nil {
+		idx = newTagValueIndex(m.seriesByTagKeyValue[key])
+		m.tagValues[key] = idx
+	}
+	return idx
+}
+
+// dropTagValueIndex drops the index of a tag key's values after they change.
+func (m *measurementIndex) dropTagValueIndex(key string) {
+	m.tagValuesMu.Lock()
+	defer m.tagValuesMu.Unlock()
+	delete(m.tagValues, key)
+}
+
 // seriesByTags returns the Series that matches the given tagset.
 func (m *Measurement) seriesByTags(tags map[string]string) *Series {
-	return m.series[string(marshalTags(tags))]
+	index := m.index()
+	return index.series[string(marshalTags(tags))]
 }
 
 func (m *Measurement) seriesIDsAndFilters(stmt *influxql.SelectStatement) (seriesIDs, map[uint32]influxql.Expr) {
+	index := m.index()
 	seriesIdsToExpr := make(map[uint32]influxql.Expr)
 	if stmt.Condition == nil {
-		return m.seriesIDs, nil
+		return index.seriesIDs, nil
+	}
+	ids, _, expr := m.walkWhereForSeriesIds(stmt.Condition, seriesIdsToExpr)
+
+	// A lone field condition applies to every matching series.
+	if expr != nil {
+		for _, id := range ids {
+			seriesIdsToExpr[id] = expr
+		}
 	}
-	ids, _, _ := m.walkWhereForSeriesIds(stmt.Condition, seriesIdsToExpr)
 
 	// ids will be empty if all they had was a time in the where clause. so return all measurement series ids
 	if len(ids) == 0 && stmt.OnlyTimeDimensions() {
-		return m.seriesIDs, nil
+		return index.seriesIDs, nil
 	}
 
 	return ids, seriesIdsToExpr
@@ -247,6 +430,7 @@ func (m *Measurement) seriesIDsAndFilters(stmt *influxql.SelectStatement) (serie
 // or region, service returns
 // {"region": "uswest", "service": "redis"}, {"region": "uswest", "service": "mysql"}, etc...
 func (m *Measurement) tagSets(stmt *influxql.SelectStatement, dimensions []string) map[string]map[uint32]influxql.Expr {
+	index := m.index()
 	// get the unique set of series ids and the filters that should be applied to each
 	seriesIDs, filters := m.seriesIDsAndFilters(stmt)
 
@@ -254,7 +438,7 @@ func (m *Measurement) tagSets(stmt *influxql.SelectStatement, dimensions []strin
 	tagSets := make(map[string]map[uint32]influxql.Expr)
 	for _, id := range seriesIDs {
 		// get the series and set the tag values for the dimensions we care about
-		s := m.seriesByID[id]
+		s := index.seriesByID[id]
 		tags := make([]string, len(dimensions))
 		for i, dim := range dimensions {
 			tags[i] = s.Tags[dim]
@@ -276,6 +460,7 @@ func (m *Measurement) tagSets(stmt *influxql.SelectStatement, dimensions []strin
 // idsForExpr will return a collection of series ids, a bool indicating if the result should be
 // used (it'll be false if it's a time expr) and a field expression if the passed in expression is against a field.
 func (m *Measurement) idsForExpr(n *influxql.BinaryExpr) (seriesIDs, bool, influxql.Expr) {
+	index := m.index()
 	name, ok := n.LHS.(*influxql.VarRef)
 	value := n.RHS
 	if !ok {
@@ -290,7 +475,16 @@ func (m *Measurement) idsForExpr(n *influxql.BinaryExpr) (seriesIDs, bool, influ
 
 	// if it's a field we can't collapse it so we have to look at all series ids for this
 	if m.FieldByName(name.Val) != nil {
-		return m.seriesIDs, true, n
+		return index.seriesIDs, true, n
+	}
+
+	// regexes are matched against the tag's values
+	if re, ok := value.(*influxql.RegexLiteral); ok {
+		ids := index.seriesIDsByTagRegex(name.Val, re.Val)
+		if n.Op == influxql.NEQREGEX {
+			ids = index.seriesIDs.reject(ids)
+		}
+		return ids, true, nil
 	}
 
 	// tag values can only be strings so if it's not a string this is an empty set
@@ -299,7 +493,7 @@ func (m *Measurement) idsForExpr(n *influxql.BinaryExpr) (seriesIDs, bool, influ
 		return nil, true, nil
 	}
 
-	vals, ok := m.seriesByTagKeyValue[name.Val]
+	vals, ok := index.seriesByTagKeyValue[name.Val]
 	if !ok {
 		return nil, true, nil
 	}
@@ -344,6 +538,18 @@ func (m *Measurement) walkWhereForSeriesIds(expr influxql.Expr, filters map[uint
 				for _, id := range idsToClear {
 					delete(filters, id)
 				}
+
+				// series only matched by the side with a field expression still need it
+				if lexpr != nil {
+					for _, id := range l.reject(r) {
+						filters[id] = lexpr
+					}
+				}
+				if rexpr != nil {
+					for _, id := range r.reject(l) {
+						filters[id] = rexpr
+					}
+				}
 			} else {
 				// put the LHS field expression into the filters
 				if lexpr != nil {
@@ -452,11 +658,12 @@ func expandExprWithValues(expr influxql.Expr, keys []string, tagExprs []tagExpr,
 // seriesIDsAllOrByExpr walks an expressions for matching series IDs
 // or, if no expressions is given, returns all series IDs for the measurement.
 func (m *Measurement) seriesIDsAllOrByExpr(expr influxql.Expr) (seriesIDs, error) {
+	index := m.index()
 	// If no expression given or the measurement has no series,
 	// we can take just return the ids or nil accordingly.
 	if expr == nil {
-		return m.seriesIDs, nil
-	} else if len(m.seriesIDs) == 0 {
+		return index.seriesIDs, nil
+	} else if len(index.seriesIDs) == 0 {
 		return nil, nil
 	}
 
@@ -801,7 +1008,7 @@ func (f *FieldCodec) DecodeFields(b []byte) map[uint8]interface{} {
 			b = b[2:]
 		case influxql.String:
 			size := binary.BigEndian.Uint16(b[1:3])
-			value = string(b[3:size])
+			value = string(b[3 : 3+size])
 			// Move bytes forward.
 			b = b[size+3:]
 		default:
@@ -815,6 +1022,63 @@ func (f *FieldCodec) DecodeFields(b []byte) map[uint8]interface{} {
 	return values
 }
 
+// DecodeFieldsWithNames decodes a byte slice into a set of field names and values.
+func (f *FieldCodec) DecodeFieldsWithNames(b []byte) map[string]interface{} {
+	fields := f.DecodeFields(b)
+	m := make(map[string]interface{}, len(fields))
+	for id, v := range fields {
+		if field := f.fieldsByID[id]; field != nil {
+			m[field.Name] = v
+		}
+	}
+	return m
+}
+
+// ValidateFields returns an error if a byte slice isn't a complete encoding
+// of fields known to the codec, such as the values of a torn write.
+func (f *FieldCodec) ValidateFields(b []byte) error {
+	if len(b) == 0 {
+		return nil
+	}
+
+	n := int(b[0])
+	b = b[1:]
+	for i := 0; i < n; i++ {
+		if len(b) == 0 {
+			return fmt.Errorf("expected %d fields, found %d", n, i)
+		}
+
+		field := f.fieldsByID[b[0]]
+		if field == nil {
+			return fmt.Errorf("field ID %d has no mapping", b[0])
+		}
+
+		var size int
+		switch field.Type {
+		case influxql.Number:
+			size = 9
+		case influxql.Boolean:
+			size = 2
+		case influxql.String:
+			if len(b) < 3 {
+				return fmt.Errorf("field %s is truncated", field.Name)
+			}
+			size = int(binary.BigEndian.Uint16(b[1:3])) + 3
+		default:
+			return fmt.Errorf("field %s has unsupported type %s", field.Name, field.Type)
+		}
+		if len(b) < size {
+			return fmt.Errorf("field %s is truncated", field.Name)
+		}
+		b = b[size:]
+	}
+
+	if len(b) > 0 {
+		return fmt.Errorf("%d bytes after last field", len(b))
+	}
+	return nil
+}
+
 // Series belong to a Measurement and represent unique time series in a database
 type Series struct {
 	ID   uint32
@@ -950,24 +1214,43 @@ type RetentionPolicy struct {
 	// Unique name within database. Required.
 	Name string `json:"name"`
 
-	// Length of time to keep data around
+	// Length of time to keep data around. A zero duration keeps data forever.
 	Duration time.Duration `json:"duration"`
 
 	// The number of copies to make of each shard.
 	ReplicaN uint32 `json:"replicaN"`
 
+	// Length of time covered by each shard group.
+	ShardGroupDuration time.Duration `json:"shardGroupDuration"`
+
+	// Compression of the points in new shard groups. Empty stores points uncompressed.
+	Compression string `json:"compression,omitempty"`
+
 	shardGroups []*ShardGroup
 }
 
 // NewRetentionPolicy returns a new instance of RetentionPolicy with defaults set.
 func NewRetentionPolicy(name string) *RetentionPolicy {
 	return &RetentionPolicy{
-		Name:     name,
-		ReplicaN: DefaultReplicaN,
-		Duration: DefaultShardRetention,
+		Name:               name,
+		ReplicaN:           DefaultReplicaN,
+		Duration:           DefaultShardRetention,
+		ShardGroupDuration: shardGroupDuration(DefaultShardRetention),
 	}
 }
 
+// shardGroupDuration returns the default shard group duration for a retention period.
+// Infinite periods use DefaultShardDuration. Short periods use a single group per period. Longer periods are split into
+// smaller groups so that expired data can be dropped without waiting on one large group.
+func shardGroupDuration(d time.Duration) time.Duration {
+	if d == 0 || d > 180*24*time.Hour {
+		return DefaultShardDuration
+	} else if d > 2*24*time.Hour {
+		return 24 * time.Hour
+	}
+	return d
+}
+
 // shardGroupByTimestamp returns the group in the policy that owns a timestamp.
 // Returns nil group does not exist.
 func (rp *RetentionPolicy) shardGroupByTimestamp(timestamp time.Time) *ShardGroup {
@@ -979,6 +1262,41 @@ func (rp *RetentionPolicy) shardGroupByTimestamp(timestamp time.Time) *ShardGrou
 	return nil
 }
 
+// shardGroupRange returns the time range of a new shard group for a timestamp.
+// The range is aligned to the policy's shard group duration and clipped so it
+// does not overlap groups created with a previous shard group duration.
+func (rp *RetentionPolicy) shardGroupRange(timestamp time.Time) (min, max time.Time) {
+	min = timestamp.Truncate(rp.ShardGroupDuration).UTC()
+	max = min.Add(rp.ShardGroupDuration).UTC()
+	for _, g := range rp.shardGroups {
+		if g.EndTime.After(min) && g.EndTime.Before(timestamp) {
+			min = g.EndTime
+		}
+		if g.StartTime.Before(max) && g.StartTime.After(timestamp) {
+			max = g.StartTime
+		}
+	}
+	return
+}
+
+// truncateShardGroup ends the group in progress at now on the next boundary of
+// the policy's shard group duration so that subsequent writes are placed in
+// groups of the current duration. Groups that already end sooner are unchanged.
+// The original end time is kept for reads since points may already have been
+// written after the new end time. Returns the group if it was cut short.
+func (rp *RetentionPolicy) truncateShardGroup(now time.Time) *ShardGroup {
+	g := rp.shardGroupByTimestamp(now)
+	if g == nil {
+		return nil
+	}
+	if end := now.Truncate(rp.ShardGroupDuration).Add(rp.ShardGroupDuration).UTC(); end.Before(g.EndTime) {
+		g.MaxTime = g.maxTime()
+		g.EndTime = end
+		return g
+	}
+	return nil
+}
+
 // shardGroupByID returns the group in the policy for the given ID.
 // Returns nil if group does not exist.
 func (rp *RetentionPolicy) shardGroupByID(shardID uint64) *ShardGroup {
@@ -990,6 +1308,22 @@ func (rp *RetentionPolicy) shardGroupByID(shardID uint64) *ShardGroup {
 	return nil
 }
 
+// expiredShardGroups returns the groups that have aged out of the policy as of now.
+// Policies with a zero duration keep data forever and never expire groups.
+func (rp *RetentionPolicy) expiredShardGroups(now time.Time) []*ShardGroup {
+	if rp.Duration == 0 {
+		return nil
+	}
+
+	var a []*ShardGroup
+	for _, g := range rp.shardGroups {
+		if g.maxTime().Add(rp.Duration).Before(now) {
+			a = append(a, g)
+		}
+	}
+	return a
+}
+
 func (rp *RetentionPolicy) removeShardGroupByID(shardID uint64) {
 	for i, g := range rp.shardGroups {
 		if g.ID == shardID {
@@ -1004,7 +1338,9 @@ func (rp *RetentionPolicy) MarshalJSON() ([]byte, error) {
 	var o retentionPolicyJSON
 	o.Name = rp.Name
 	o.Duration = rp.Duration
+	o.ShardGroupDuration = rp.ShardGroupDuration
 	o.ReplicaN = rp.ReplicaN
+	o.Compression = rp.Compression
 	for _, g := range rp.shardGroups {
 		o.ShardGroups = append(o.ShardGroups, g)
 	}
@@ -1023,18 +1359,31 @@ func (rp *RetentionPolicy) UnmarshalJSON(data []byte) error {
 	rp.Name = o.Name
 	rp.ReplicaN = o.ReplicaN
 	rp.Duration = o.Duration
+	rp.ShardGroupDuration = o.ShardGroupDuration
+	rp.Compression = o.Compression
 	rp.shardGroups = o.ShardGroups
 
+	// Policies saved before shard group durations were configurable
+	// use a single group per retention period, unless the period is infinite.
+	if rp.ShardGroupDuration == 0 {
+		rp.ShardGroupDuration = rp.Duration
+		if rp.Duration == 0 {
+			rp.ShardGroupDuration = DefaultShardDuration
+		}
+	}
+
 	return nil
 }
 
 // retentionPolicyJSON represents an intermediate struct for JSON marshaling.
 type retentionPolicyJSON struct {
-	Name        string        `json:"name"`
-	ReplicaN    uint32        `json:"replicaN,omitempty"`
-	SplitN      uint32        `json:"splitN,omitempty"`
-	Duration    time.Duration `json:"duration,omitempty"`
-	ShardGroups []*ShardGroup `json:"shardGroups,omitempty"`
+	Name               string        `json:"name"`
+	ReplicaN           uint32        `json:"replicaN,omitempty"`
+	SplitN             uint32        `json:"splitN,omitempty"`
+	Duration           time.Duration `json:"duration,omitempty"`
+	ShardGroupDuration time.Duration `json:"shardGroupDuration,omitempty"`
+	Compression        string        `json:"compression,omitempty"`
+	ShardGroups        []*ShardGroup `json:"shardGroups,omitempty"`
 }
 
 // TagFilter represents a tag filter when looking up other tags or measurements.
@@ -1168,18 +1517,42 @@ func (db *database) addSeriesToIndex(measurementName string, s *Series) bool {
 	idx := db.createMeasurementIfNotExists(measurementName)
 
 	s.measurement = idx
-	db.series[s.ID] = s
+	if db.cache == nil {
+		db.series[s.ID] = s
+	}
 
 	// TODO: add this series to the global tag index
 
 	return idx.addSeries(s)
 }
 
+// loadIndex decodes the series and measurements read from the metastore and
+// adds them to the index. If the database limits the series held in memory
+// then only the number of series in each measurement is read.
+func (db *database) loadIndex(idx *databaseIndex) {
+	for name, n := range idx.seriesN {
+		db.createMeasurementIfNotExists(name).seriesN = n
+	}
+	for i, v := range idx.series {
+		var s *Series
+		mustUnmarshalJSON(v, &s)
+		db.addSeriesToIndex(idx.seriesNames[i], s)
+	}
+	for i, v := range idx.fields {
+		m := db.createMeasurementIfNotExists(idx.measurements[i])
+		mustUnmarshalJSON(v, &m)
+	}
+}
+
 // createMeasurementIfNotExists will either add a measurement object to the index or return the existing one.
 func (db *database) createMeasurementIfNotExists(name string) *Measurement {
 	idx := db.measurements[name]
 	if idx == nil {
 		idx = NewMeasurement(name)
+		if db.cache != nil {
+			// The series are loaded from the metastore when they're used.
+			idx.idx, idx.database, idx.cache = nil, db.name, db.cache
+		}
 		db.measurements[name] = idx
 		db.names = append(db.names, name)
 		sort.Strings(db.names)
@@ -1206,6 +1579,26 @@ func (d *database) MeasurementNames() []string {
 	return d.names
 }
 
+// seriesN returns the number of series in the database.
+func (d *database) seriesN() (n int) {
+	for _, m := range d.measurements {
+		n += m.seriesN
+	}
+	return
+}
+
+// dropSeries removes series from a measurement and the index.
+func (d *database) dropSeries(name string, ids []uint32) {
+	m := d.measurements[name]
+	if m == nil {
+		return
+	}
+	for _, id := range ids {
+		m.dropSeries(id)
+		delete(d.series, id)
+	}
+}
+
 // DropSeries will clear the index of all references to a series.
 func (d *database) DropSeries(id uint32) {
 	panic("not implemented")
@@ -1213,7 +1606,23 @@ func (d *database) DropSeries(id uint32) {
 
 // DropMeasurement will clear the index of all references to a measurement and its child series.
 func (d *database) DropMeasurement(name string) {
-	panic("not implemented")
+	m := d.measurements[name]
+	if m == nil {
+		return
+	}
+	if m.cache != nil {
+		m.cache.remove(m)
+	} else {
+		for id := range m.idx.seriesByID {
+			delete(d.series, id)
+		}
+	}
+	delete(d.measurements, name)
+
+	// Remove the name from the sorted list of names.
+	if i := sort.SearchStrings(d.names, name); i < len(d.names) && d.names[i] == name {
+		d.names = append(d.names[:i], d.names[i+1:]...)
+	}
 }
 
 func (d *database) continuousQueryByName(name string) *ContinuousQuery {
@@ -1254,7 +1663,7 @@ func (db *database) SeriesIDs(names []string, filters []*TagFilter) seriesIDs {
 	if len(filters) == 0 {
 		ids := seriesIDs(make([]uint32, 0))
 		for _, m := range db.measurements {
-			ids = ids.union(m.seriesIDs)
+			ids = ids.union(m.index().seriesIDs)
 		}
 		return ids
 	}
@@ -1291,24 +1700,17 @@ func (db *database) seriesIDsByName(name string, filters []*TagFilter) seriesIDs
 
 // seriesIDs returns the series ids for a given filter
 func (m *Measurement) seriesIDsByFilter(filter *TagFilter) (ids seriesIDs) {
-	values := m.seriesByTagKeyValue[filter.Key]
+	index := m.index()
+	values := index.seriesByTagKeyValue[filter.Key]
 	if values == nil {
 		return
 	}
 
 	// handle regex filters
 	if filter.Regex != nil {
-		for k, v := range values {
-			if filter.Regex.MatchString(k) {
-				if ids == nil {
-					ids = v
-				} else {
-					ids = ids.union(v)
-				}
-			}
-		}
+		ids = index.seriesIDsByTagRegex(filter.Key, filter.Regex)
 		if filter.Not {
-			ids = m.seriesIDs.reject(ids)
+			ids = index.seriesIDs.reject(ids)
 		}
 		return
 	}
@@ -1330,7 +1732,7 @@ func (m *Measurement) seriesIDsByFilter(filter *TagFilter) (ids seriesIDs) {
 
 	// filter out these ids from the entire set if it's a not query
 	if filter.Not {
-		ids = m.seriesIDs.reject(ids)
+		ids = index.seriesIDs.reject(ids)
 	}
 
 	return
@@ -1359,6 +1761,23 @@ func (db *database) measurementsByExpr(expr influxql.Expr) (Measurements, error)
 				Value: value.Val,
 			}
 			return db.measurementsByTagFilters([]*TagFilter{tf}), nil
+		case influxql.EQREGEX, influxql.NEQREGEX:
+			tag, ok := e.LHS.(*influxql.VarRef)
+			if !ok {
+				return nil, fmt.Errorf("left side of '=~' must be a tag name")
+			}
+
+			re, ok := e.RHS.(*influxql.RegexLiteral)
+			if !ok {
+				return nil, fmt.Errorf("right side of '=~' must be a regex")
+			}
+
+			tf := &TagFilter{
+				Not:   e.Op == influxql.NEQREGEX,
+				Key:   tag.Val,
+				Regex: re.Val,
+			}
+			return db.measurementsByTagFilters([]*TagFilter{tf}), nil
 		case influxql.OR, influxql.AND:
 			lhsIDs, err := db.measurementsByExpr(e.LHS)
 			if err != nil {
@@ -1400,7 +1819,9 @@ func (db *database) measurementsByTagFilters(filters []*TagFilter) Measurements
 	for _, m := range db.measurements {
 		for _, f := range filters {
 			tagMatch = false
-			if tagVals, ok := m.seriesByTagKeyValue[f.Key]; ok {
+			if f.Regex != nil {
+				tagMatch = len(m.index().seriesIDsByTagRegex(f.Key, f.Regex)) > 0
+			} else if tagVals, ok := m.index().seriesByTagKeyValue[f.Key]; ok {
 				if _, ok := tagVals[f.Value]; ok {
 					tagMatch = true
 				}
@@ -1436,18 +1857,39 @@ func (db *database) Measurements() Measurements {
 
 // tagKeys returns a list of the measurement's tag names.
 func (m *Measurement) tagKeys() []string {
-	keys := make([]string, 0, len(m.seriesByTagKeyValue))
-	for k := range m.seriesByTagKeyValue {
+	index := m.index()
+	keys := make([]string, 0, len(index.seriesByTagKeyValue))
+	for k := range index.seriesByTagKeyValue {
 		keys = append(keys, k)
 	}
 	sort.Strings(keys)
 	return keys
 }
 
+// tagKeysBySeriesIDs returns the sorted tag keys used by a set of series.
+func (m *Measurement) tagKeysBySeriesIDs(ids seriesIDs) []string {
+	index := m.index()
+	set := newStringSet()
+	for _, id := range ids {
+		s := index.seriesByID[id]
+		if s == nil {
+			continue
+		}
+		for k := range s.Tags {
+			set.add(k)
+		}
+	}
+
+	keys := set.list()
+	sort.Strings(keys)
+	return keys
+}
+
 func (m *Measurement) tagValuesByKeyAndSeriesID(tagKeys []string, ids seriesIDs) stringSet {
+	index := m.index()
 	// If no tag keys were passed, get all tag keys for the measurement.
 	if len(tagKeys) == 0 {
-		for k := range m.seriesByTagKeyValue {
+		for k := range index.seriesByTagKeyValue {
 			tagKeys = append(tagKeys, k)
 		}
 	}
@@ -1457,7 +1899,7 @@ func (m *Measurement) tagValuesByKeyAndSeriesID(tagKeys []string, ids seriesIDs)
 
 	// Iterate all series to collect tag values.
 	for _, id := range ids {
-		s, ok := m.seriesByID[id]
+		s, ok := index.seriesByID[id]
 		if !ok {
 			continue
 		}
@@ -0,0 +1,157 @@
+package influxdb
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// exportSeries is a series of a shard to be exported.
+type exportSeries struct {
+	id    uint32
+	key   string // measurement and tags in line protocol
+	codec *FieldCodec
+}
+
+// exportSeriesSlice sorts series by key.
+type exportSeriesSlice []*exportSeries
+
+func (a exportSeriesSlice) Len() int           { return len(a) }
+func (a exportSeriesSlice) Less(i, j int) bool { return a[i].key < a[j].key }
+func (a exportSeriesSlice) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+
+// ExportShard writes the points stored in a local shard to w as line
+// protocol, one point per line. Points are grouped by measurement and series
+// key and written oldest first. Points of series that have since been dropped
+// are skipped.
+func (s *Server) ExportShard(id uint64, w io.Writer) error {
+	s.mu.RLock()
+	sh := s.shards[id]
+	var a []*exportSeries
+	if sh != nil {
+		a = s.exportSeries(id)
+	}
+	s.mu.RUnlock()
+	if sh == nil || !sh.hasStore() {
+		return ErrShardNotFound
+	}
+
+	bw := bufio.NewWriter(w)
+	for _, es := range a {
+		if err := exportPoints(bw, sh, es); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// exportPoints writes the points of a series stored in a shard to w.
+func exportPoints(w io.Writer, sh *Shard, es *exportSeries) error {
+	c, err := sh.Cursor(es.id, 0, math.MaxInt64)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	for {
+		timestamp, values, err := c.Next()
+		if err != nil {
+			return err
+		} else if values == nil {
+			return nil
+		}
+
+		fields := es.codec.DecodeFieldsWithNames(values)
+		if len(fields) == 0 {
+			continue
+		}
+		if _, err := io.WriteString(w, formatLinePoint(es.key, fields, timestamp)); err != nil {
+			return err
+		}
+	}
+}
+
+// exportSeries returns the series of the database a shard belongs to, sorted
+// by key. Must be called with the lock held.
+func (s *Server) exportSeries(shardID uint64) []*exportSeries {
+	db, _ := s.shardPolicy(shardID)
+	if db == nil {
+		return nil
+	}
+
+	var a []*exportSeries
+	for _, name := range db.names {
+		m := db.measurements[name]
+		codec := NewFieldCodec(m)
+		for _, series := range m.index().seriesByID {
+			a = append(a, &exportSeries{
+				id:    series.ID,
+				key:   formatLineKey(name, series.Tags),
+				codec: codec,
+			})
+		}
+	}
+	sort.Sort(exportSeriesSlice(a))
+	return a
+}
+
+// formatLineKey returns the measurement and tags of a series in line
+// protocol. Tags are sorted by key and tags with empty values are omitted.
+func formatLineKey(measurement string, tags map[string]string) string {
+	keys := make([]string, 0, len(tags))
+	for k, v := range tags {
+		if v != "" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	key := measurementEscaper.Replace(measurement)
+	for _, k := range keys {
+		key += "," + tagEscaper.Replace(k) + "=" + tagEscaper.Replace(tags[k])
+	}
+	return key
+}
+
+// formatLinePoint returns a point in line protocol, terminated by a newline.
+// Fields are sorted by name.
+func formatLinePoint(key string, fields map[string]interface{}, timestamp int64) string {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	line := key
+	for i, name := range names {
+		if i == 0 {
+			line += " "
+		} else {
+			line += ","
+		}
+		line += tagEscaper.Replace(name) + "=" + formatLineValue(fields[name])
+	}
+	return line + " " + strconv.FormatInt(timestamp, 10) + "\n"
+}
+
+// formatLineValue returns a field value in line protocol.
+func formatLineValue(v interface{}) string {
+	switch v := v.(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case string:
+		return `"` + stringEscaper.Replace(v) + `"`
+	}
+	return ""
+}
+
+var (
+	measurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	tagEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+	stringEscaper      = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+)
@@ -1,10 +1,13 @@
 package httpd
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"net/url"
@@ -79,10 +82,34 @@ func NewHandler(s *influxdb.Server, requireAuthentication bool, version string)
 			"data_nodes_delete",
 			"DELETE", "/data_nodes/:id", true, false, h.serveDeleteDataNode,
 		},
+		route{ // Decommission data node
+			"data_nodes_decommission",
+			"POST", "/data_nodes/:id/decommission", true, false, h.serveDecommissionDataNode,
+		},
+		route{ // Rebalance shards across data nodes
+			"shards_rebalance",
+			"POST", "/shards/rebalance", true, false, h.serveRebalanceShards,
+		},
+		route{ // Snapshot every data node at the same broker index
+			"snapshots_create",
+			"POST", "/snapshots", true, false, h.serveCreateSnapshot,
+		},
+		route{ // Files a data node wrote for a snapshot
+			"snapshot_manifest",
+			"GET", "/snapshots/:id", true, false, h.serveSnapshotManifest,
+		},
 		route{ // Metastore
 			"metastore",
 			"GET", "/metastore", false, false, h.serveMetastore,
 		},
+		route{ // Metastore digest for verifying metadata across data nodes
+			"metastore_digest",
+			"GET", "/metastore/digest", false, false, h.serveMetastoreDigest,
+		},
+		route{ // Slow queries
+			"slow_queries",
+			"GET", "/slow_queries", true, true, h.serveSlowQueries,
+		},
 		route{ // Status
 			"status",
 			"GET", "/status", true, true, h.serveStatus,
@@ -95,6 +122,30 @@ func NewHandler(s *influxdb.Server, requireAuthentication bool, version string)
 			"ping-head",
 			"HEAD", "/ping", true, true, h.servePing,
 		},
+		route{ // Stream points from a local shard to another data node
+			"shard_points",
+			"GET", "/shards/:id/points", false, false, h.serveShardPoints,
+		},
+		route{ // Copy a local shard's data file to another data node
+			"shard_data",
+			"GET", "/shards/:id/data", false, false, h.serveShardData,
+		},
+		route{ // Digest of a local shard's series for comparing replicas
+			"shard_digest",
+			"GET", "/shards/:id/digest", false, false, h.serveShardDigest,
+		},
+		route{ // Checksums of a local shard's series for verifying replicas
+			"shard_checksum",
+			"GET", "/shards/:id/checksum", false, false, h.serveShardChecksum,
+		},
+		route{ // Merge a shard's data file from another data node
+			"shard_data_merge",
+			"POST", "/shards/:id/data", false, false, h.serveMergeShardData,
+		},
+		route{ // Write points forwarded by another data node to a local shard
+			"shard_write",
+			"POST", "/shards/:id/write", false, false, h.serveWriteShardPoints,
+		},
 		route{ // Tell data node to run CQs that should be run
 			"process_continuous_queries",
 			"POST", "/process_continuous_queries", false, false, h.serveProcessContinuousQueries,
@@ -193,7 +244,7 @@ func (h *Handler) serveWrite(w http.ResponseWriter, r *http.Request, user *influ
 		return
 	}
 
-	if h.requireAuthentication && !user.Authorize(influxql.WritePrivilege, bp.Database) {
+	if h.requireAuthentication && !user.Authorize(influxql.WritePrivilege, bp.Database) && len(user.MeasurementPrivileges[bp.Database]) == 0 {
 		writeError(influxdb.Result{Err: fmt.Errorf("%q user is not authorized to write to database %q", user.Name, bp.Database)}, http.StatusUnauthorized)
 		return
 	}
@@ -204,7 +255,20 @@ func (h *Handler) serveWrite(w http.ResponseWriter, r *http.Request, user *influ
 		return
 	}
 
-	if _, err := h.server.WriteSeries(bp.Database, bp.RetentionPolicy, points); err != nil {
+	// Users without a privilege on the database must be granted each measurement.
+	if h.requireAuthentication && !user.Authorize(influxql.WritePrivilege, bp.Database) {
+		for _, p := range points {
+			if !user.AuthorizeMeasurement(influxql.WritePrivilege, bp.Database, p.Name) {
+				writeError(influxdb.Result{Err: fmt.Errorf("%q user is not authorized to write to measurement %q", user.Name, p.Name)}, http.StatusUnauthorized)
+				return
+			}
+		}
+	}
+
+	if _, err := h.server.WriteSeries(bp.Database, bp.RetentionPolicy, points); err == influxdb.ErrReadOnly {
+		writeError(influxdb.Result{Err: err}, http.StatusServiceUnavailable)
+		return
+	} else if err != nil {
 		writeError(influxdb.Result{Err: err}, http.StatusInternalServerError)
 		return
 	}
@@ -212,6 +276,11 @@ func (h *Handler) serveWrite(w http.ResponseWriter, r *http.Request, user *influ
 
 // serveMetastore returns a copy of the metastore.
 func (h *Handler) serveMetastore(w http.ResponseWriter, r *http.Request) {
+	if err := h.verifyNodeRequest(r); err != nil {
+		httpError(w, err.Error(), false, http.StatusUnauthorized)
+		return
+	}
+
 	// Set headers.
 	w.Header().Set("Content-Type", "application/octet-stream")
 	w.Header().Set("Content-Disposition", `attachment; filename="meta"`)
@@ -221,18 +290,68 @@ func (h *Handler) serveMetastore(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// serveMetastoreDigest returns the digest of the local metastore.
+func (h *Handler) serveMetastoreDigest(w http.ResponseWriter, r *http.Request) {
+	if err := h.verifyNodeRequest(r); err != nil {
+		httpError(w, err.Error(), false, http.StatusUnauthorized)
+		return
+	}
+
+	d, err := h.server.MetastoreDigest()
+	if err != nil {
+		httpError(w, err.Error(), false, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Add("content-type", "application/json")
+	_ = json.NewEncoder(w).Encode(d)
+}
+
+// serveSlowQueries returns the most recent slow queries. Only admin users can
+// view slow queries when authentication is enabled.
+func (h *Handler) serveSlowQueries(w http.ResponseWriter, r *http.Request, user *influxdb.User) {
+	if h.requireAuthentication && (user == nil || !user.Admin) {
+		httpError(w, "admin privilege required", false, http.StatusForbidden)
+		return
+	}
+
+	// Generate a list of objects for encoding to the API.
+	a := make([]*slowQueryJSON, 0)
+	for _, q := range h.server.SlowQueries() {
+		a = append(a, &slowQueryJSON{
+			Statement: q.Statement,
+			User:      q.User,
+			Database:  q.Database,
+			Duration:  q.Duration.String(),
+			ShardN:    q.ShardN,
+			Time:      q.Time,
+		})
+	}
+
+	w.Header().Add("content-type", "application/json")
+	_ = json.NewEncoder(w).Encode(a)
+}
+
 // serveStatus returns a set of states that the server is currently in.
 func (h *Handler) serveStatus(w http.ResponseWriter, r *http.Request) {
 	w.Header().Add("content-type", "application/json")
 
 	pretty := r.URL.Query().Get("pretty") == "true"
 
+	size, err := h.server.LocalShardSize()
+	if err != nil {
+		httpError(w, err.Error(), pretty, http.StatusInternalServerError)
+		return
+	}
+
 	data := struct {
 		Id    uint64 `json:"id"`
 		Index uint64 `json:"index"`
+		Size  int64  `json:"size"`
 	}{
 		Id:    h.server.ID(),
 		Index: h.server.Index(),
+		Size:  size,
 	}
 	var b []byte
 	if pretty {
@@ -259,8 +378,9 @@ func (h *Handler) serveDataNodes(w http.ResponseWriter, r *http.Request) {
 	a := make([]*dataNodeJSON, 0)
 	for _, n := range h.server.DataNodes() {
 		a = append(a, &dataNodeJSON{
-			ID:  n.ID,
-			URL: n.URL.String(),
+			ID:   n.ID,
+			URL:  n.URL.String(),
+			Zone: n.Zone,
 		})
 	}
 
@@ -270,9 +390,24 @@ func (h *Handler) serveDataNodes(w http.ResponseWriter, r *http.Request) {
 
 // serveCreateDataNode creates a new data node in the cluster.
 func (h *Handler) serveCreateDataNode(w http.ResponseWriter, r *http.Request) {
-	// Read in data node from request body.
+	// Only nodes with the shared secret can join the cluster.
+	if err := h.verifyNodeRequest(r); err != nil {
+		httpError(w, err.Error(), false, http.StatusUnauthorized)
+		return
+	}
+
+	// Read in data node from request body. The whole body is read so that
+	// it's checked against the signed digest.
+	b, err := ioutil.ReadAll(r.Body)
+	if err == influxdb.ErrInvalidNodeSignature {
+		httpError(w, err.Error(), false, http.StatusUnauthorized)
+		return
+	} else if err != nil {
+		httpError(w, err.Error(), false, http.StatusBadRequest)
+		return
+	}
 	var n dataNodeJSON
-	if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
+	if err := json.Unmarshal(b, &n); err != nil {
 		httpError(w, err.Error(), false, http.StatusBadRequest)
 		return
 	}
@@ -308,8 +443,15 @@ func (h *Handler) serveCreateDataNode(w http.ResponseWriter, r *http.Request) {
 	_ = json.NewEncoder(w).Encode(&dataNodeJSON{ID: node.ID, URL: node.URL.String()})
 }
 
-// serveDeleteDataNode removes an existing node.
-func (h *Handler) serveDeleteDataNode(w http.ResponseWriter, r *http.Request) {
+// serveDeleteDataNode removes an existing node. An unreachable node is
+// dropped from the shards it owns if force is set. Only admin users can
+// remove nodes when authentication is enabled.
+func (h *Handler) serveDeleteDataNode(w http.ResponseWriter, r *http.Request, user *influxdb.User) {
+	if h.requireAuthentication && (user == nil || !user.Admin) {
+		httpError(w, "admin privilege required", false, http.StatusForbidden)
+		return
+	}
+
 	// Parse node id.
 	nodeID, err := strconv.ParseUint(r.URL.Query().Get(":id"), 10, 64)
 	if err != nil {
@@ -318,7 +460,235 @@ func (h *Handler) serveDeleteDataNode(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Delete the node.
-	if err := h.server.DeleteDataNode(nodeID); err == influxdb.ErrDataNodeNotFound {
+	if r.URL.Query().Get("force") == "true" {
+		err = h.server.RemoveDataNode(nodeID, true)
+	} else {
+		err = h.server.DeleteDataNode(nodeID)
+	}
+	if err == influxdb.ErrDataNodeNotFound {
+		httpError(w, err.Error(), false, http.StatusNotFound)
+		return
+	} else if err != nil {
+		httpError(w, err.Error(), false, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// serveDecommissionDataNode moves an existing node's shards to the other
+// nodes and then removes the node. Only admin users can decommission nodes
+// when authentication is enabled.
+func (h *Handler) serveDecommissionDataNode(w http.ResponseWriter, r *http.Request, user *influxdb.User) {
+	if h.requireAuthentication && (user == nil || !user.Admin) {
+		httpError(w, "admin privilege required", false, http.StatusForbidden)
+		return
+	}
+
+	// Parse node id.
+	nodeID, err := strconv.ParseUint(r.URL.Query().Get(":id"), 10, 64)
+	if err != nil {
+		httpError(w, "invalid node id", false, http.StatusBadRequest)
+		return
+	}
+
+	// Decommission the node.
+	if err := h.server.DecommissionDataNode(nodeID); err == influxdb.ErrDataNodeNotFound {
+		httpError(w, err.Error(), false, http.StatusNotFound)
+		return
+	} else if err != nil {
+		httpError(w, err.Error(), false, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// serveRebalanceShards moves existing shards onto data nodes that own fewer
+// shards and returns the moves that were made. Only admin users can rebalance
+// shards when authentication is enabled.
+func (h *Handler) serveRebalanceShards(w http.ResponseWriter, r *http.Request, user *influxdb.User) {
+	if h.requireAuthentication && (user == nil || !user.Admin) {
+		httpError(w, "admin privilege required", false, http.StatusForbidden)
+		return
+	}
+
+	moves, err := h.server.RebalanceShards()
+	if err != nil {
+		httpError(w, err.Error(), false, http.StatusInternalServerError)
+		return
+	}
+
+	if moves == nil {
+		moves = []influxdb.ShardMove{}
+	}
+	w.Header().Add("content-type", "application/json")
+	_ = json.NewEncoder(w).Encode(moves)
+}
+
+// serveCreateSnapshot snapshots every data node in the cluster and returns the
+// snapshot's manifest. Only admin users can create snapshots when
+// authentication is enabled.
+func (h *Handler) serveCreateSnapshot(w http.ResponseWriter, r *http.Request, user *influxdb.User) {
+	if h.requireAuthentication && (user == nil || !user.Admin) {
+		httpError(w, "admin privilege required", false, http.StatusForbidden)
+		return
+	}
+
+	m, err := h.server.CreateClusterSnapshot()
+	if err != nil {
+		httpError(w, err.Error(), false, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Add("content-type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(m)
+}
+
+// serveSnapshotManifest returns the files the data node wrote for a snapshot.
+// The optional "index" parameter waits until the snapshot's index is applied.
+func (h *Handler) serveSnapshotManifest(w http.ResponseWriter, r *http.Request) {
+	if err := h.verifyNodeRequest(r); err != nil {
+		httpError(w, err.Error(), false, http.StatusUnauthorized)
+		return
+	}
+
+	q := r.URL.Query()
+	if s := q.Get("index"); s != "" {
+		index, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			httpError(w, "invalid index", false, http.StatusBadRequest)
+			return
+		}
+		if err := h.server.Sync(index); err != nil {
+			httpError(w, err.Error(), false, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	m, err := h.server.SnapshotManifest(q.Get(":id"))
+	if err == influxdb.ErrSnapshotNotFound {
+		httpError(w, err.Error(), false, http.StatusNotFound)
+		return
+	} else if err != nil {
+		httpError(w, err.Error(), false, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Add("content-type", "application/json")
+	_ = json.NewEncoder(w).Encode(m)
+}
+
+// serveShardData returns a copy of a local shard's data file. The copy can be
+// limited to a comma-separated list of series ids with the "series" parameter.
+func (h *Handler) serveShardData(w http.ResponseWriter, r *http.Request) {
+	if err := h.verifyNodeRequest(r); err != nil {
+		httpError(w, err.Error(), false, http.StatusUnauthorized)
+		return
+	}
+
+	shardID, err := strconv.ParseUint(r.URL.Query().Get(":id"), 10, 64)
+	if err != nil {
+		httpError(w, "invalid shard id", false, http.StatusBadRequest)
+		return
+	}
+
+	// Parse the optional list of series to copy.
+	var seriesIDs []uint32
+	if s := r.URL.Query().Get("series"); s != "" {
+		for _, str := range strings.Split(s, ",") {
+			id, err := strconv.ParseUint(str, 10, 32)
+			if err != nil {
+				httpError(w, "invalid series id", false, http.StatusBadRequest)
+				return
+			}
+			seriesIDs = append(seriesIDs, uint32(id))
+		}
+	}
+
+	// Write to a temporary file so that a missing shard can still return an
+	// error and the checksum can be sent before the data.
+	f, err := ioutil.TempFile("", "influxdb-shard-")
+	if err != nil {
+		httpError(w, err.Error(), false, http.StatusInternalServerError)
+		return
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	hash := sha256.New()
+	if seriesIDs != nil {
+		err = h.server.WriteShardSeriesTo(io.MultiWriter(f, hash), shardID, seriesIDs)
+	} else {
+		err = h.server.WriteShardTo(io.MultiWriter(f, hash), shardID)
+	}
+	if err == influxdb.ErrShardNotFound {
+		httpError(w, err.Error(), false, http.StatusNotFound)
+		return
+	} else if err != nil {
+		httpError(w, err.Error(), false, http.StatusInternalServerError)
+		return
+	}
+
+	// Rewind the file to send it.
+	size, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		httpError(w, err.Error(), false, http.StatusInternalServerError)
+		return
+	} else if _, err := f.Seek(0, io.SeekStart); err != nil {
+		httpError(w, err.Error(), false, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	w.Header().Set(influxdb.ShardChecksumHeader, hex.EncodeToString(hash.Sum(nil)))
+	_, _ = io.Copy(w, f)
+}
+
+// serveShardDigest returns the digest of each series in a local shard.
+func (h *Handler) serveShardDigest(w http.ResponseWriter, r *http.Request) {
+	if err := h.verifyNodeRequest(r); err != nil {
+		httpError(w, err.Error(), false, http.StatusUnauthorized)
+		return
+	}
+
+	shardID, err := strconv.ParseUint(r.URL.Query().Get(":id"), 10, 64)
+	if err != nil {
+		httpError(w, "invalid shard id", false, http.StatusBadRequest)
+		return
+	}
+
+	digest, err := h.server.ShardDigest(shardID)
+	if err == influxdb.ErrShardNotFound {
+		httpError(w, err.Error(), false, http.StatusNotFound)
+		return
+	} else if err != nil {
+		httpError(w, err.Error(), false, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Add("content-type", "application/json")
+	_ = json.NewEncoder(w).Encode(digest)
+}
+
+// serveShardChecksum returns the checksums of a local shard's series and the
+// problems found in its store.
+func (h *Handler) serveShardChecksum(w http.ResponseWriter, r *http.Request) {
+	if err := h.verifyNodeRequest(r); err != nil {
+		httpError(w, err.Error(), false, http.StatusUnauthorized)
+		return
+	}
+
+	shardID, err := strconv.ParseUint(r.URL.Query().Get(":id"), 10, 64)
+	if err != nil {
+		httpError(w, "invalid shard id", false, http.StatusBadRequest)
+		return
+	}
+
+	checksum, err := h.server.VerifyShard(shardID)
+	if err == influxdb.ErrShardNotFound {
 		httpError(w, err.Error(), false, http.StatusNotFound)
 		return
 	} else if err != nil {
@@ -326,12 +696,144 @@ func (h *Handler) serveDeleteDataNode(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	w.Header().Add("content-type", "application/json")
+	_ = json.NewEncoder(w).Encode(checksum)
+}
+
+// serveMergeShardData adds the points from another node's copy of a shard to
+// the local shard.
+func (h *Handler) serveMergeShardData(w http.ResponseWriter, r *http.Request) {
+	if err := h.verifyNodeRequest(r); err != nil {
+		httpError(w, err.Error(), false, http.StatusUnauthorized)
+		return
+	}
+
+	q := r.URL.Query()
+	shardID, err := strconv.ParseUint(q.Get(":id"), 10, 64)
+	if err != nil {
+		httpError(w, "invalid shard id", false, http.StatusBadRequest)
+		return
+	}
+
+	// Wait until the node has applied the change that assigned it the shard.
+	if s := q.Get("index"); s != "" {
+		index, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			httpError(w, "invalid index", false, http.StatusBadRequest)
+			return
+		}
+		if err := h.server.Sync(index); err != nil {
+			httpError(w, err.Error(), false, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if _, err := h.server.MergeShard(shardID, r.Body); err == influxdb.ErrShardNotFound {
+		httpError(w, err.Error(), false, http.StatusNotFound)
+		return
+	} else if err == influxdb.ErrInvalidNodeSignature {
+		httpError(w, err.Error(), false, http.StatusUnauthorized)
+		return
+	} else if err != nil {
+		httpError(w, err.Error(), false, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// serveShardPoints streams the points for a tagset of a local shard.
+func (h *Handler) serveShardPoints(w http.ResponseWriter, r *http.Request) {
+	if err := h.verifyNodeRequest(r); err != nil {
+		httpError(w, err.Error(), false, http.StatusUnauthorized)
+		return
+	}
+
+	q := r.URL.Query()
+
+	// Parse shard id and time range.
+	shardID, err := strconv.ParseUint(q.Get(":id"), 10, 64)
+	if err != nil {
+		httpError(w, "invalid shard id", false, http.StatusBadRequest)
+		return
+	}
+	tmin, err := strconv.ParseInt(q.Get("tmin"), 10, 64)
+	if err != nil {
+		httpError(w, "invalid tmin", false, http.StatusBadRequest)
+		return
+	}
+	tmax, err := strconv.ParseInt(q.Get("tmax"), 10, 64)
+	if err != nil {
+		httpError(w, "invalid tmax", false, http.StatusBadRequest)
+		return
+	}
+
+	// Parse the simple select statement.
+	stmt, err := influxql.NewParser(strings.NewReader(q.Get("q"))).ParseStatement()
+	if err != nil {
+		httpError(w, "error parsing query: "+err.Error(), false, http.StatusBadRequest)
+		return
+	}
+	selectStmt, ok := stmt.(*influxql.SelectStatement)
+	if !ok {
+		httpError(w, "expected select statement", false, http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Add("content-type", "application/json")
+	if err := h.server.StreamShardPoints(w, shardID, selectStmt, q.Get("tags"), tmin, tmax); err == influxdb.ErrShardNotFound {
+		httpError(w, err.Error(), false, http.StatusNotFound)
+		return
+	} else if err != nil {
+		httpError(w, err.Error(), false, http.StatusInternalServerError)
+		return
+	}
+}
+
+// serveWriteShardPoints writes a batch of encoded points from another data
+// node to a local shard.
+func (h *Handler) serveWriteShardPoints(w http.ResponseWriter, r *http.Request) {
+	if err := h.verifyNodeRequest(r); err != nil {
+		httpError(w, err.Error(), false, http.StatusUnauthorized)
+		return
+	}
+
+	shardID, err := strconv.ParseUint(r.URL.Query().Get(":id"), 10, 64)
+	if err != nil {
+		httpError(w, "invalid shard id", false, http.StatusBadRequest)
+		return
+	}
+
+	if err := h.server.WriteShardPoints(shardID, r.Body); err == influxdb.ErrShardNotFound {
+		httpError(w, err.Error(), false, http.StatusNotFound)
+		return
+	} else if err == influxdb.ErrInvalidNodeSignature {
+		httpError(w, err.Error(), false, http.StatusUnauthorized)
+		return
+	} else if err != nil {
+		httpError(w, err.Error(), false, http.StatusInternalServerError)
+		return
+	}
+
 	w.WriteHeader(http.StatusNoContent)
 }
 
 // serveProcessContinuousQueries will execute any continuous queries that should be run
 func (h *Handler) serveProcessContinuousQueries(w http.ResponseWriter, r *http.Request, u *influxdb.User) {
-	if err := h.server.RunContinuousQueries(); err != nil {
+	// The broker spreads queries across the data nodes listed in "nodes".
+	var nodeIDs []uint64
+	if s := r.URL.Query().Get("nodes"); s != "" {
+		for _, v := range strings.Split(s, ",") {
+			id, err := strconv.ParseUint(v, 10, 64)
+			if err != nil {
+				httpError(w, "invalid node id: "+v, false, http.StatusBadRequest)
+				return
+			}
+			nodeIDs = append(nodeIDs, id)
+		}
+	}
+
+	if err := h.server.RunContinuousQueriesForNodes(nodeIDs); err != nil {
 		httpError(w, err.Error(), false, http.StatusInternalServerError)
 		return
 	}
@@ -340,8 +842,18 @@ func (h *Handler) serveProcessContinuousQueries(w http.ResponseWriter, r *http.R
 }
 
 type dataNodeJSON struct {
-	ID  uint64 `json:"id"`
-	URL string `json:"url"`
+	ID   uint64 `json:"id"`
+	URL  string `json:"url"`
+	Zone string `json:"zone,omitempty"`
+}
+
+type slowQueryJSON struct {
+	Statement string    `json:"statement"`
+	User      string    `json:"user,omitempty"`
+	Database  string    `json:"database"`
+	Duration  string    `json:"duration"`
+	ShardN    int       `json:"shards"`
+	Time      time.Time `json:"time"`
 }
 
 func isAuthorizationError(err error) bool {
@@ -403,11 +915,48 @@ func parseCredentials(r *http.Request) (string, string, error) {
 	}
 }
 
+// parseBearerToken returns the token in a request's "Authorization: Bearer" header, if any.
+func parseBearerToken(r *http.Request) string {
+	if s := r.Header.Get("Authorization"); strings.HasPrefix(s, "Bearer ") {
+		return strings.TrimSpace(s[len("Bearer "):])
+	}
+	return ""
+}
+
 // authenticate wraps a handler and ensures that if user credentials are passed in
 // an attempt is made to authenticate that user. If authentication fails, an error is returned.
 //
 // There is one exception: if there are no users in the system, authentication is not required. This
 // is to facilitate bootstrapping of a system with authentication enabled.
+// verifyNodeRequest returns nil if a request to a route used between data
+// nodes is signed with the node secret. If authentication is enabled and no
+// node secret is set then only admin users may use the route.
+func (h *Handler) verifyNodeRequest(r *http.Request) error {
+	err := h.server.VerifyNodeRequest(r)
+	if err != influxdb.ErrNodeSecretRequired {
+		return err
+	}
+
+	// Authenticate with a bearer token or with credentials.
+	var u *influxdb.User
+	if token := parseBearerToken(r); token != "" {
+		if u, err = h.server.AuthenticateToken(token); err != nil {
+			return err
+		}
+	} else {
+		username, password, e := parseCredentials(r)
+		if e != nil || username == "" {
+			return err
+		} else if u, err = h.server.Authenticate(username, password); err != nil {
+			return err
+		}
+	}
+	if !u.Admin {
+		return fmt.Errorf("%q user is not an admin", u.Name)
+	}
+	return nil
+}
+
 func authenticate(inner func(http.ResponseWriter, *http.Request, *influxdb.User), h *Handler, requireAuthentication bool) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Return early if we are not authenticating
@@ -419,6 +968,17 @@ func authenticate(inner func(http.ResponseWriter, *http.Request, *influxdb.User)
 
 		// TODO corylanou: never allow this in the future without users
 		if requireAuthentication && h.server.UserCount() > 0 {
+			// Authenticate with a bearer token if one is present.
+			if token := parseBearerToken(r); token != "" {
+				u, err := h.server.AuthenticateToken(token)
+				if err != nil {
+					httpError(w, err.Error(), false, http.StatusUnauthorized)
+					return
+				}
+				inner(w, r, u)
+				return
+			}
+
 			username, password, err := parseCredentials(r)
 			if err != nil {
 				httpError(w, err.Error(), false, http.StatusUnauthorized)
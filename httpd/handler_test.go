@@ -20,12 +20,9 @@ import (
 	"github.com/influxdb/influxdb/httpd"
 	"github.com/influxdb/influxdb/influxql"
 	"github.com/influxdb/influxdb/messaging"
+	"golang.org/x/crypto/bcrypt"
 )
 
-func init() {
-	influxdb.BcryptCost = 4
-}
-
 func TestBatchWrite_UnmarshalEpoch(t *testing.T) {
 	now := time.Now().UTC()
 	tests := []struct {
@@ -263,7 +260,7 @@ func TestHandler_RetentionPolicies(t *testing.T) {
 
 	if status != http.StatusOK {
 		t.Fatalf("unexpected status: %d", status)
-	} else if body != `{"results":[{"rows":[{"columns":["name","duration","replicaN"],"values":[["bar","168h0m0s",1]]}]}]}` {
+	} else if body != `{"results":[{"rows":[{"columns":["name","duration","shardGroupDuration","replicaN","compression"],"values":[["bar","168h0m0s","24h0m0s",1,"none"]]}]}]}` {
 		t.Fatalf("unexpected body: %s", body)
 	}
 }
@@ -371,7 +368,7 @@ func TestHandler_UpdateRetentionPolicy(t *testing.T) {
 	s := NewHTTPServer(srvr)
 	defer s.Close()
 
-	query := map[string]string{"q": "ALTER RETENTION POLICY bar ON foo REPLICATION 42 DURATION 1m DEFAULT"}
+	query := map[string]string{"q": "ALTER RETENTION POLICY bar ON foo REPLICATION 42 DURATION 2w DEFAULT"}
 	status, body := MustHTTP("GET", s.URL+`/query`, query, nil, "")
 
 	// Verify updated policy.
@@ -787,6 +784,138 @@ func TestHandler_CreateDataNode_InternalServerError(t *testing.T) {
 	}
 }
 
+func TestHandler_serveMetastore_NodeSecret(t *testing.T) {
+	srvr := OpenAuthlessServer(NewMessagingClient())
+	srvr.NodeSecret = []byte("secret")
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	// Unsigned requests are rejected.
+	status, body := MustHTTP("GET", s.URL+`/metastore`, nil, nil, "")
+	if status != http.StatusUnauthorized {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `{"error":"invalid node signature"}` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+
+	// Signed requests receive the metastore.
+	req, _ := http.NewRequest("GET", s.URL+`/metastore`, nil)
+	if err := influxdb.SignNodeRequest(req, []byte("secret"), time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status: %d", resp.StatusCode)
+	}
+}
+
+func TestHandler_serveShardPoints_NodeSecret(t *testing.T) {
+	srvr := OpenAuthlessServer(NewMessagingClient())
+	srvr.NodeSecret = []byte("secret")
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	// Unsigned requests are rejected before the shard is looked up.
+	query := map[string]string{"q": `SELECT value FROM "foo"."bar"."cpu"`, "tmin": "0", "tmax": "1"}
+	status, body := MustHTTP("GET", s.URL+`/shards/100/points`, query, nil, "")
+	if status != http.StatusUnauthorized {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `{"error":"invalid node signature"}` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+
+	// Signed requests reach the shard.
+	req, _ := http.NewRequest("GET", s.URL+`/shards/100/points?q=SELECT+value+FROM+%22foo%22.%22bar%22.%22cpu%22&tmin=0&tmax=1`, nil)
+	if err := influxdb.SignNodeRequest(req, []byte("secret"), time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("unexpected status: %d", resp.StatusCode)
+	}
+}
+
+// Ensure a signed node request is rejected if its body has been modified.
+func TestHandler_serveCreateDataNode_ModifiedBody(t *testing.T) {
+	srvr := OpenAuthlessServer(NewMessagingClient())
+	srvr.NodeSecret = []byte("secret")
+	s := NewHTTPServer(srvr)
+	defer s.Close()
+
+	req, _ := http.NewRequest("POST", s.URL+`/data_nodes`, strings.NewReader(`{"url":"http://localhost:8086"}`))
+	if err := influxdb.SignNodeRequest(req, []byte("secret"), time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	modified := `{"url":"http://localhost:9999"}`
+	req.Body, req.GetBody = ioutil.NopCloser(strings.NewReader(modified)), nil
+	req.ContentLength = int64(len(modified))
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("unexpected status: %d", resp.StatusCode)
+	} else if srvr.DataNodeByURL(&url.URL{Scheme: "http", Host: "localhost:9999"}) != nil {
+		t.Fatal("unexpected data node")
+	}
+}
+
+// Ensure routes used between data nodes are limited to admin users when
+// authentication is enabled without a node secret.
+func TestHandler_AuthenticatedNodeRoutes_NoNodeSecret(t *testing.T) {
+	srvr := OpenAuthenticatedServer(NewMessagingClient())
+	srvr.CreateUser("lisa", "password", true)
+	srvr.CreateUser("john", "password", false)
+	s := NewAuthenticatedHTTPServer(srvr)
+	defer s.Close()
+
+	for _, tt := range []struct {
+		method string
+		path   string
+	}{
+		{"GET", `/metastore`},
+		{"GET", `/metastore/digest`},
+		{"POST", `/data_nodes`},
+		{"GET", `/snapshots/1`},
+		{"GET", `/shards/100/points`},
+		{"GET", `/shards/100/data`},
+		{"POST", `/shards/100/data`},
+		{"POST", `/shards/100/write`},
+		{"GET", `/shards/100/digest`},
+		{"GET", `/shards/100/checksum`},
+	} {
+		// Requests without credentials or from non-admin users are rejected.
+		status, body := MustHTTP(tt.method, s.URL+tt.path, nil, nil, "")
+		if status != http.StatusUnauthorized {
+			t.Fatalf("%s %s: unexpected status: %d", tt.method, tt.path, status)
+		} else if body != `{"error":"node secret required"}` {
+			t.Fatalf("%s %s: unexpected body: %s", tt.method, tt.path, body)
+		}
+		status, body = MustHTTP(tt.method, s.URL+tt.path, map[string]string{"u": "john", "p": "password"}, nil, "")
+		if status != http.StatusUnauthorized {
+			t.Fatalf("%s %s: unexpected status: %d", tt.method, tt.path, status)
+		} else if body != `{"error":"\"john\" user is not an admin"}` {
+			t.Fatalf("%s %s: unexpected body: %s", tt.method, tt.path, body)
+		}
+
+		// Admin users reach the handler.
+		status, body = MustHTTP(tt.method, s.URL+tt.path, map[string]string{"u": "lisa", "p": "password"}, nil, "")
+		if status == http.StatusUnauthorized {
+			t.Fatalf("%s %s: unexpected status: %d, %s", tt.method, tt.path, status, body)
+		}
+	}
+}
+
 func TestHandler_DeleteDataNode(t *testing.T) {
 	t.Skip()
 	srvr := OpenAuthlessServer(NewMessagingClient())
@@ -839,6 +968,84 @@ func TestHandler_AuthenticatedCreateAdminUser(t *testing.T) {
 
 }
 
+func TestHandler_AuthenticatedDeleteDataNode(t *testing.T) {
+	srvr := OpenAuthenticatedServer(NewMessagingClient())
+	srvr.CreateUser("lisa", "password", true)
+	srvr.CreateUser("john", "password", false)
+	s := NewAuthenticatedHTTPServer(srvr)
+	defer s.Close()
+
+	// Non-admin users cannot remove nodes, forced or not.
+	for _, force := range []string{"false", "true"} {
+		status, _ := MustHTTP("DELETE", s.URL+`/data_nodes/100`, map[string]string{"u": "john", "p": "password", "force": force}, nil, "")
+		if status != http.StatusForbidden {
+			t.Fatalf("unexpected status(force=%s): %d", force, status)
+		}
+	}
+
+	// Admin users reach the node lookup.
+	status, _ := MustHTTP("DELETE", s.URL+`/data_nodes/100`, map[string]string{"u": "lisa", "p": "password", "force": "true"}, nil, "")
+	if status != http.StatusNotFound {
+		t.Fatalf("unexpected status: %d", status)
+	}
+}
+
+func TestHandler_AuthenticatedDecommissionDataNode(t *testing.T) {
+	srvr := OpenAuthenticatedServer(NewMessagingClient())
+	srvr.CreateUser("lisa", "password", true)
+	srvr.CreateUser("john", "password", false)
+	s := NewAuthenticatedHTTPServer(srvr)
+	defer s.Close()
+
+	// Non-admin users cannot decommission nodes.
+	status, _ := MustHTTP("POST", s.URL+`/data_nodes/100/decommission`, map[string]string{"u": "john", "p": "password"}, nil, "")
+	if status != http.StatusForbidden {
+		t.Fatalf("unexpected status: %d", status)
+	}
+
+	// Admin users reach the node lookup.
+	status, _ = MustHTTP("POST", s.URL+`/data_nodes/100/decommission`, map[string]string{"u": "lisa", "p": "password"}, nil, "")
+	if status != http.StatusNotFound {
+		t.Fatalf("unexpected status: %d", status)
+	}
+}
+
+func TestHandler_AuthenticatedRebalanceShards(t *testing.T) {
+	srvr := OpenAuthenticatedServer(NewMessagingClient())
+	srvr.CreateUser("lisa", "password", true)
+	srvr.CreateUser("john", "password", false)
+	s := NewAuthenticatedHTTPServer(srvr)
+	defer s.Close()
+
+	// Non-admin users cannot rebalance shards.
+	status, _ := MustHTTP("POST", s.URL+`/shards/rebalance`, map[string]string{"u": "john", "p": "password"}, nil, "")
+	if status != http.StatusForbidden {
+		t.Fatalf("unexpected status: %d", status)
+	}
+
+	// Admin users can.
+	status, body := MustHTTP("POST", s.URL+`/shards/rebalance`, map[string]string{"u": "lisa", "p": "password"}, nil, "")
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	}
+}
+
+func TestHandler_AuthenticatedCreateSnapshot(t *testing.T) {
+	srvr := OpenAuthenticatedServer(NewMessagingClient())
+	srvr.CreateUser("lisa", "password", true)
+	srvr.CreateUser("john", "password", false)
+	s := NewAuthenticatedHTTPServer(srvr)
+	defer s.Close()
+
+	// Non-admin users cannot snapshot the cluster.
+	status, body := MustHTTP("POST", s.URL+`/snapshots`, map[string]string{"u": "john", "p": "password"}, nil, "")
+	if status != http.StatusForbidden {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `{"error":"admin privilege required"}` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+}
+
 func TestHandler_AuthenticatedDatabases_Unauthorized(t *testing.T) {
 	srvr := OpenAuthenticatedServer(NewMessagingClient())
 	s := NewAuthenticatedHTTPServer(srvr)
@@ -906,6 +1113,32 @@ func TestHandler_AuthenticatedDatabases_UnauthorizedBasicAuth(t *testing.T) {
 	}
 }
 
+func TestHandler_AuthenticatedDatabases_BearerToken(t *testing.T) {
+	srvr := OpenAuthenticatedServer(NewMessagingClient())
+	srvr.CreateUser("lisa", "password", true)
+	srvr.TokenSecret = []byte("secret")
+	s := NewAuthenticatedHTTPServer(srvr)
+	defer s.Close()
+
+	token, err := srvr.CreateToken("lisa", time.Now().Add(time.Hour), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := map[string]string{"q": "SHOW DATABASES"}
+	status, _ := MustHTTP("GET", s.URL+`/query`, query, map[string]string{"Authorization": "Bearer " + token}, "")
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d", status)
+	}
+
+	// Tamper with the token.
+	status, body := MustHTTP("GET", s.URL+`/query`, query, map[string]string{"Authorization": "Bearer " + token + "x"}, "")
+	if status != http.StatusUnauthorized {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `{"error":"invalid token"}` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+}
+
 func TestHandler_GrantAdmin(t *testing.T) {
 	srvr := OpenAuthenticatedServer(NewMessagingClient())
 	// Create a cluster admin that will grant admin to "john".
@@ -1103,6 +1336,29 @@ func TestHandler_serveWriteSeriesWithAuthNilUser(t *testing.T) {
 	}
 }
 
+func TestHandler_serveWriteSeries_MeasurementPrivilege(t *testing.T) {
+	srvr := OpenAuthenticatedServer(NewMessagingClient())
+	srvr.CreateDatabase("foo")
+	srvr.CreateRetentionPolicy("foo", influxdb.NewRetentionPolicy("bar"))
+	srvr.CreateUser("lisa", "password", false)
+	srvr.SetMeasurementPrivilege(influxql.WritePrivilege, "lisa", "foo", "cpu", false)
+	s := NewAuthenticatedHTTPServer(srvr)
+	defer s.Close()
+
+	query := map[string]string{"u": "lisa", "p": "password"}
+	status, body := MustHTTP("POST", s.URL+`/write`, query, nil, `{"database" : "foo", "retentionPolicy" : "bar", "points": [{"name": "cpu", "timestamp": "2009-11-10T23:00:00Z","values": {"value": 100}}]}`)
+	if status != http.StatusOK {
+		t.Fatalf("unexpected status: %d: %s", status, body)
+	}
+
+	status, body = MustHTTP("POST", s.URL+`/write`, query, nil, `{"database" : "foo", "retentionPolicy" : "bar", "points": [{"name": "cpu", "timestamp": "2009-11-10T23:00:00Z","values": {"value": 100}}, {"name": "mem", "timestamp": "2009-11-10T23:00:00Z","values": {"value": 100}}]}`)
+	if status != http.StatusUnauthorized {
+		t.Fatalf("unexpected status: %d", status)
+	} else if body != `{"error":"\"lisa\" user is not authorized to write to measurement \"mem\""}` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+}
+
 func TestHandler_serveWriteSeries_noDatabaseExists(t *testing.T) {
 	srvr := OpenAuthenticatedServer(NewMessagingClient())
 	s := NewHTTPServer(srvr)
@@ -1416,17 +1672,21 @@ func TestHandler_serveShowSeries(t *testing.T) {
 	s := NewHTTPServer(srvr)
 	defer s.Close()
 
-	status, body := MustHTTP("POST", s.URL+`/write`, nil, nil, `{"database" : "foo", "retentionPolicy" : "bar", "points": [
-		{"name": "cpu", "tags": {"host": "server01"},"timestamp": "2009-11-10T23:00:00Z","values": {"value": 100}},
-		{"name": "cpu", "tags": {"host": "server01", "region": "uswest"},"timestamp": "2009-11-10T23:00:00Z","values": {"value": 100}},
-		{"name": "cpu", "tags": {"host": "server01", "region": "useast"},"timestamp": "2009-11-10T23:00:00Z","values": {"value": 100}},
-		{"name": "cpu", "tags": {"host": "server02", "region": "useast"},"timestamp": "2009-11-10T23:00:00Z","values": {"value": 100}},
-		{"name": "gpu", "tags": {"host": "server02", "region": "useast"},"timestamp": "2009-11-10T23:00:00Z","values": {"value": 100}}
-		]}`)
-
-	if status != http.StatusOK {
-		t.Log(body)
-		t.Fatalf("unexpected status after write: %d", status)
+	// Write the points one at a time so the series ids are assigned in order.
+	var status int
+	var body string
+	for _, p := range []string{
+		`{"name": "cpu", "tags": {"host": "server01"},"timestamp": "2009-11-10T23:00:00Z","values": {"value": 100}}`,
+		`{"name": "cpu", "tags": {"host": "server01", "region": "uswest"},"timestamp": "2009-11-10T23:00:00Z","values": {"value": 100}}`,
+		`{"name": "cpu", "tags": {"host": "server01", "region": "useast"},"timestamp": "2009-11-10T23:00:00Z","values": {"value": 100}}`,
+		`{"name": "cpu", "tags": {"host": "server02", "region": "useast"},"timestamp": "2009-11-10T23:00:00Z","values": {"value": 100}}`,
+		`{"name": "gpu", "tags": {"host": "server02", "region": "useast"},"timestamp": "2009-11-10T23:00:00Z","values": {"value": 100}}`,
+	} {
+		status, body = MustHTTP("POST", s.URL+`/write`, nil, nil, `{"database" : "foo", "retentionPolicy" : "bar", "points": [`+p+`]}`)
+		if status != http.StatusOK {
+			t.Log(body)
+			t.Fatalf("unexpected status after write: %d", status)
+		}
 	}
 
 	var tests = []struct {
@@ -1464,27 +1724,144 @@ func TestHandler_serveShowSeries(t *testing.T) {
 			},
 		},
 		// SHOW SERIES ... LIMIT
-		// {
-		// 	q: `SHOW SERIES LIMIT 1`,
-		// 	r: &influxdb.Results{
-		// 		Results: []*influxdb.Result{
-		// 			&influxdb.Result{
-		// 				Rows: []*influxql.Row{
-		// 					&influxql.Row{
-		// 						Name:    "cpu",
-		// 						Columns: []string{"host", "region"},
-		// 						Values: [][]interface{}{
-		// 							str2iface([]string{"server01", ""}),
-		// 							str2iface([]string{"server01", "uswest"}),
-		// 							str2iface([]string{"server01", "useast"}),
-		// 							str2iface([]string{"server02", "useast"}),
-		// 						},
-		// 					},
-		// 				},
-		// 			},
-		// 		},
-		// 	},
-		// },
+		{
+			q: `SHOW SERIES LIMIT 1`,
+			r: &influxdb.Results{
+				Results: []*influxdb.Result{
+					{
+						Rows: []*influxql.Row{
+							{
+								Name:    "cpu",
+								Columns: []string{"host", "region"},
+								Values: [][]interface{}{
+									str2iface([]string{"server01", ""}),
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		// SHOW SERIES ... LIMIT ... OFFSET across measurements
+		{
+			q: `SHOW SERIES LIMIT 2 OFFSET 3`,
+			r: &influxdb.Results{
+				Results: []*influxdb.Result{
+					{
+						Rows: []*influxql.Row{
+							{
+								Name:    "cpu",
+								Columns: []string{"host", "region"},
+								Values: [][]interface{}{
+									str2iface([]string{"server02", "useast"}),
+								},
+							},
+							{
+								Name:    "gpu",
+								Columns: []string{"host", "region"},
+								Values: [][]interface{}{
+									str2iface([]string{"server02", "useast"}),
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		// SHOW SERIES FROM ... LIMIT ... OFFSET
+		{
+			q: `SHOW SERIES FROM cpu LIMIT 2 OFFSET 1`,
+			r: &influxdb.Results{
+				Results: []*influxdb.Result{
+					{
+						Rows: []*influxql.Row{
+							{
+								Name:    "cpu",
+								Columns: []string{"host", "region"},
+								Values: [][]interface{}{
+									str2iface([]string{"server01", "uswest"}),
+									str2iface([]string{"server01", "useast"}),
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		// SHOW SERIES ... SLIMIT
+		{
+			q: `SHOW SERIES SLIMIT 1`,
+			r: &influxdb.Results{
+				Results: []*influxdb.Result{
+					{
+						Rows: []*influxql.Row{
+							{
+								Name:    "cpu",
+								Columns: []string{"host", "region"},
+								Values: [][]interface{}{
+									str2iface([]string{"server01", ""}),
+								},
+							},
+							{
+								Name:    "gpu",
+								Columns: []string{"host", "region"},
+								Values: [][]interface{}{
+									str2iface([]string{"server02", "useast"}),
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		// SHOW SERIES ... SLIMIT ... SOFFSET
+		{
+			q: `SHOW SERIES SLIMIT 2 SOFFSET 1`,
+			r: &influxdb.Results{
+				Results: []*influxdb.Result{
+					{
+						Rows: []*influxql.Row{
+							{
+								Name:    "cpu",
+								Columns: []string{"host", "region"},
+								Values: [][]interface{}{
+									str2iface([]string{"server01", "uswest"}),
+									str2iface([]string{"server01", "useast"}),
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		// SHOW SERIES ... OFFSET ... SLIMIT
+		{
+			q: `SHOW SERIES OFFSET 1 SLIMIT 1`,
+			r: &influxdb.Results{
+				Results: []*influxdb.Result{
+					{
+						Rows: []*influxql.Row{
+							{
+								Name:    "gpu",
+								Columns: []string{"host", "region"},
+								Values: [][]interface{}{
+									str2iface([]string{"server02", "useast"}),
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		// SHOW SERIES ... OFFSET past the end
+		{
+			q: `SHOW SERIES OFFSET 5`,
+			r: &influxdb.Results{
+				Results: []*influxdb.Result{
+					{},
+				},
+			},
+		},
 		// SHOW SERIES FROM
 		{
 			q: `SHOW SERIES FROM cpu`,
@@ -2066,7 +2443,9 @@ type Server struct {
 
 // NewServer returns a new test server instance.
 func NewServer() *Server {
-	return &Server{influxdb.NewServer()}
+	s := influxdb.NewServer()
+	s.PasswordHasher = &influxdb.BcryptHasher{Cost: bcrypt.MinCost}
+	return &Server{s}
 }
 
 // OpenAuthenticatedServer returns a new, open test server instance with authentication enabled.
@@ -23,6 +23,37 @@ var (
 	// ErrUnableToJoin is returned when a server cannot join a cluster.
 	ErrUnableToJoin = errors.New("unable to join")
 
+	// ErrMetastoreDiverged is returned when the metastore digests of data nodes
+	// at the same index disagree and no majority agrees on one of them.
+	ErrMetastoreDiverged = errors.New("metastore diverged")
+
+	// ErrMetastoreChanged is returned when a metastore changes while it is
+	// copied from another data node.
+	ErrMetastoreChanged = errors.New("metastore changed during copy")
+
+	// ErrClusterMismatch is returned when a metastore copied while joining a
+	// cluster doesn't contain the data node created for the joining server.
+	ErrClusterMismatch = errors.New("metastore does not match joined cluster")
+
+	// ErrSnapshotExists is returned when creating a snapshot with the id of an
+	// existing snapshot.
+	ErrSnapshotExists = errors.New("snapshot exists")
+
+	// ErrSnapshotNotFound is returned when a data node has no snapshot with an id.
+	ErrSnapshotNotFound = errors.New("snapshot not found")
+
+	// ErrMetadataVersion is returned when importing a metadata document with
+	// an unsupported version.
+	ErrMetadataVersion = errors.New("unsupported metadata version")
+
+	// ErrInvalidNodeSignature is returned when a request from another node
+	// is not signed with the cluster's shared secret.
+	ErrInvalidNodeSignature = errors.New("invalid node signature")
+
+	// ErrNodeSecretRequired is returned when a request from another node is
+	// received while authentication is enabled and no shared secret is set.
+	ErrNodeSecretRequired = errors.New("node secret required")
+
 	// ErrDataNodeURLRequired is returned when creating a data node without a URL.
 	ErrDataNodeURLRequired = errors.New("data node url required")
 
@@ -59,12 +90,45 @@ var (
 	// ErrUserNotFound is returned when deleting a non-existent user.
 	ErrUserNotFound = errors.New("user not found")
 
+	// ErrUserLocked is returned when authenticating a user that has been
+	// locked out after too many failed attempts.
+	ErrUserLocked = errors.New("user locked")
+
+	// ErrReadOnly is returned when writing data or changing metadata while
+	// the server is in read-only mode.
+	ErrReadOnly = errors.New("server is read-only")
+
 	// ErrUsernameRequired is returned when using a blank username.
 	ErrUsernameRequired = errors.New("username required")
 
 	// ErrInvalidUsername is returned when using a username with invalid characters.
 	ErrInvalidUsername = errors.New("invalid username")
 
+	// ErrRoleExists is returned when creating a duplicate role.
+	ErrRoleExists = errors.New("role exists")
+
+	// ErrRoleNotFound is returned when using a non-existent role.
+	ErrRoleNotFound = errors.New("role not found")
+
+	// ErrRoleNameRequired is returned when using a blank role name.
+	ErrRoleNameRequired = errors.New("role name required")
+
+	// ErrInvalidToken is returned when authenticating with a malformed or forged token.
+	ErrInvalidToken = errors.New("invalid token")
+
+	// ErrAPITokenNotFound is returned when revoking a non-existent API token.
+	ErrAPITokenNotFound = errors.New("api token not found")
+
+	// ErrTokenExpired is returned when authenticating with an expired token.
+	ErrTokenExpired = errors.New("token expired")
+
+	// ErrTokenSecretRequired is returned when using tokens without a token secret.
+	ErrTokenSecretRequired = errors.New("token secret required")
+
+	// ErrAuditChanFull is returned when an audit event cannot be sent because
+	// the audit channel is full.
+	ErrAuditChanFull = errors.New("audit channel full")
+
 	// ErrRetentionPolicyExists is returned when creating a duplicate shard space.
 	ErrRetentionPolicyExists = errors.New("retention policy exists")
 
@@ -74,6 +138,18 @@ var (
 	// ErrRetentionPolicyNameRequired is returned using a blank shard space name.
 	ErrRetentionPolicyNameRequired = errors.New("retention policy name required")
 
+	// ErrRetentionPolicyDurationTooLow is returned when a retention policy's
+	// duration is below the server's minimum.
+	ErrRetentionPolicyDurationTooLow = errors.New("retention policy duration too low")
+
+	// ErrShardGroupDurationExceedsRetention is returned when a retention policy's
+	// shard group duration is longer than its retention duration.
+	ErrShardGroupDurationExceedsRetention = errors.New("shard group duration exceeds retention policy duration")
+
+	// ErrInvalidCompression is returned when a retention policy's
+	// compression is not supported.
+	ErrInvalidCompression = errors.New("invalid compression")
+
 	// ErrDefaultRetentionPolicyNotFound is returned when using the default
 	// policy on a database but the default has not been set.
 	ErrDefaultRetentionPolicyNotFound = errors.New("default retention policy not found")
@@ -81,6 +157,21 @@ var (
 	// ErrShardNotFound is returned writing to a non-existent shard.
 	ErrShardNotFound = errors.New("shard not found")
 
+	// ErrShardOwnersRequired is returned when removing every owner of a shard.
+	ErrShardOwnersRequired = errors.New("shard owners required")
+
+	// ErrNoReplacementDataNode is returned when decommissioning a data node
+	// that is the only owner of a shard and no other data node can take it over.
+	ErrNoReplacementDataNode = errors.New("no data node available to take over shards")
+
+	// ErrShardNotOwned is returned when copying a shard to a data node that
+	// doesn't own it.
+	ErrShardNotOwned = errors.New("shard not owned by data node")
+
+	// ErrShardChecksumMismatch is returned when a shard copied from another
+	// data node doesn't match the checksum sent with it.
+	ErrShardChecksumMismatch = errors.New("shard checksum mismatch")
+
 	// ErrReadAccessDenied is returned when a user attempts to read
 	// data that he or she does not have permission to read.
 	ErrReadAccessDenied = errors.New("read access denied")
@@ -97,6 +188,9 @@ var (
 	// ErrMeasurementNotFound is returned when a measurement does not exist.
 	ErrMeasurementNotFound = errors.New("measurement not found")
 
+	// ErrMeasurementTTLInvalid is returned when setting a negative measurement TTL.
+	ErrMeasurementTTLInvalid = errors.New("measurement ttl must not be negative")
+
 	// ErrValuesRequired is returned when a point does not any values
 	ErrValuesRequired = errors.New("values required")
 
@@ -119,12 +213,26 @@ var (
 	// This can occur when a previous statement in the same query has errored.
 	ErrNotExecuted = errors.New("not executed")
 
+	// ErrQueryTimeout is returned when a query does not complete before its deadline.
+	ErrQueryTimeout = errors.New("query timeout")
+
+	// ErrUserQueryLimitExceeded is returned when a user is already running
+	// the maximum number of concurrent queries.
+	ErrUserQueryLimitExceeded = errors.New("user query limit exceeded")
+
+	// ErrUserSelectPointsLimitExceeded is returned when a select statement
+	// reads more points than its user is allowed to.
+	ErrUserSelectPointsLimitExceeded = errors.New("user select points limit exceeded")
+
 	// ErrInvalidGrantRevoke is returned when a statement requests an invalid
 	// privilege for a user on the cluster or a database.
 	ErrInvalidGrantRevoke = errors.New("invalid privilege requested")
 
 	// ErrContinuousQueryExists is returned when creating a duplicate continuous query.
 	ErrContinuousQueryExists = errors.New("continuous query already exists")
+
+	// ErrContinuousQueryNotFound is returned when dropping a non-existent continuous query.
+	ErrContinuousQueryNotFound = errors.New("continuous query not found")
 )
 
 // BatchPoints is used to send batched data in a single write.
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -56,29 +57,38 @@ type Node interface {
 func (*Query) node()     {}
 func (Statements) node() {}
 
-func (*AlterRetentionPolicyStatement) node()  {}
-func (*CreateContinuousQueryStatement) node() {}
-func (*CreateDatabaseStatement) node()        {}
-func (*CreateRetentionPolicyStatement) node() {}
-func (*CreateUserStatement) node()            {}
-func (*DeleteStatement) node()                {}
-func (*DropContinuousQueryStatement) node()   {}
-func (*DropDatabaseStatement) node()          {}
-func (*DropRetentionPolicyStatement) node()   {}
-func (*DropSeriesStatement) node()            {}
-func (*DropUserStatement) node()              {}
-func (*GrantStatement) node()                 {}
-func (*ShowContinuousQueriesStatement) node() {}
-func (*ShowDatabasesStatement) node()         {}
-func (*ShowFieldKeysStatement) node()         {}
-func (*ShowRetentionPoliciesStatement) node() {}
-func (*ShowMeasurementsStatement) node()      {}
-func (*ShowSeriesStatement) node()            {}
-func (*ShowTagKeysStatement) node()           {}
-func (*ShowTagValuesStatement) node()         {}
-func (*ShowUsersStatement) node()             {}
-func (*RevokeStatement) node()                {}
-func (*SelectStatement) node()                {}
+func (*AlterRetentionPolicyStatement) node()       {}
+func (*CreateContinuousQueryStatement) node()      {}
+func (*CreateDatabaseStatement) node()             {}
+func (*CreateRetentionPolicyStatement) node()      {}
+func (*CreateUserStatement) node()                 {}
+func (*DeleteStatement) node()                     {}
+func (*DropContinuousQueryStatement) node()        {}
+func (*DropDatabaseStatement) node()               {}
+func (*DropRetentionPolicyStatement) node()        {}
+func (*DropSeriesStatement) node()                 {}
+func (*DropShardStatement) node()                  {}
+func (*DropUserStatement) node()                   {}
+func (*ExplainStatement) node()                    {}
+func (*GrantStatement) node()                      {}
+func (*ShowContinuousQueriesStatement) node()      {}
+func (*ShowContinuousQueryStatsStatement) node()   {}
+func (*ShowDatabasesStatement) node()              {}
+func (*ShowFieldKeysStatement) node()              {}
+func (*ShowRetentionPoliciesStatement) node()      {}
+func (*ShowMeasurementCardinalityStatement) node() {}
+func (*ShowMeasurementSizesStatement) node()       {}
+func (*ShowMeasurementsStatement) node()           {}
+func (*ShowSeriesStatement) node()                 {}
+func (*ShowSeriesCardinalityStatement) node()      {}
+func (*ShowClusterStatement) node()                {}
+func (*ShowServersStatement) node()                {}
+func (*ShowStatsStatement) node()                  {}
+func (*ShowTagKeysStatement) node()                {}
+func (*ShowTagValuesStatement) node()              {}
+func (*ShowUsersStatement) node()                  {}
+func (*RevokeStatement) node()                     {}
+func (*SelectStatement) node()                     {}
 
 func (*BinaryExpr) node()      {}
 func (*BooleanLiteral) node()  {}
@@ -95,6 +105,7 @@ func (*nilLiteral) node()      {}
 func (*Merge) node()           {}
 func (*NumberLiteral) node()   {}
 func (*ParenExpr) node()       {}
+func (*RegexLiteral) node()    {}
 func (*SortField) node()       {}
 func (SortFields) node()       {}
 func (*StringLiteral) node()   {}
@@ -144,29 +155,38 @@ type ExecutionPrivilege struct {
 // ExecutionPrivileges is a list of privileges required to execute a statement.
 type ExecutionPrivileges []ExecutionPrivilege
 
-func (*AlterRetentionPolicyStatement) stmt()  {}
-func (*CreateContinuousQueryStatement) stmt() {}
-func (*CreateDatabaseStatement) stmt()        {}
-func (*CreateRetentionPolicyStatement) stmt() {}
-func (*CreateUserStatement) stmt()            {}
-func (*DeleteStatement) stmt()                {}
-func (*DropContinuousQueryStatement) stmt()   {}
-func (*DropDatabaseStatement) stmt()          {}
-func (*DropRetentionPolicyStatement) stmt()   {}
-func (*DropSeriesStatement) stmt()            {}
-func (*DropUserStatement) stmt()              {}
-func (*GrantStatement) stmt()                 {}
-func (*ShowContinuousQueriesStatement) stmt() {}
-func (*ShowDatabasesStatement) stmt()         {}
-func (*ShowFieldKeysStatement) stmt()         {}
-func (*ShowMeasurementsStatement) stmt()      {}
-func (*ShowRetentionPoliciesStatement) stmt() {}
-func (*ShowSeriesStatement) stmt()            {}
-func (*ShowTagKeysStatement) stmt()           {}
-func (*ShowTagValuesStatement) stmt()         {}
-func (*ShowUsersStatement) stmt()             {}
-func (*RevokeStatement) stmt()                {}
-func (*SelectStatement) stmt()                {}
+func (*AlterRetentionPolicyStatement) stmt()       {}
+func (*CreateContinuousQueryStatement) stmt()      {}
+func (*CreateDatabaseStatement) stmt()             {}
+func (*CreateRetentionPolicyStatement) stmt()      {}
+func (*CreateUserStatement) stmt()                 {}
+func (*DeleteStatement) stmt()                     {}
+func (*DropContinuousQueryStatement) stmt()        {}
+func (*DropDatabaseStatement) stmt()               {}
+func (*DropRetentionPolicyStatement) stmt()        {}
+func (*DropSeriesStatement) stmt()                 {}
+func (*DropShardStatement) stmt()                  {}
+func (*DropUserStatement) stmt()                   {}
+func (*ExplainStatement) stmt()                    {}
+func (*GrantStatement) stmt()                      {}
+func (*ShowContinuousQueriesStatement) stmt()      {}
+func (*ShowContinuousQueryStatsStatement) stmt()   {}
+func (*ShowDatabasesStatement) stmt()              {}
+func (*ShowFieldKeysStatement) stmt()              {}
+func (*ShowMeasurementCardinalityStatement) stmt() {}
+func (*ShowMeasurementSizesStatement) stmt()       {}
+func (*ShowMeasurementsStatement) stmt()           {}
+func (*ShowRetentionPoliciesStatement) stmt()      {}
+func (*ShowSeriesStatement) stmt()                 {}
+func (*ShowSeriesCardinalityStatement) stmt()      {}
+func (*ShowClusterStatement) stmt()                {}
+func (*ShowServersStatement) stmt()                {}
+func (*ShowStatsStatement) stmt()                  {}
+func (*ShowTagKeysStatement) stmt()                {}
+func (*ShowTagValuesStatement) stmt()              {}
+func (*ShowUsersStatement) stmt()                  {}
+func (*RevokeStatement) stmt()                     {}
+func (*SelectStatement) stmt()                     {}
 
 // Expr represents an expression that can be evaluated to a value.
 type Expr interface {
@@ -181,6 +201,7 @@ func (*DurationLiteral) expr() {}
 func (*nilLiteral) expr()      {}
 func (*NumberLiteral) expr()   {}
 func (*ParenExpr) expr()       {}
+func (*RegexLiteral) expr()    {}
 func (*StringLiteral) expr()   {}
 func (*TimeLiteral) expr()     {}
 func (*VarRef) expr()          {}
@@ -208,9 +229,15 @@ type SortField struct {
 // String returns a string representation of a sort field
 func (field *SortField) String() string {
 	var buf bytes.Buffer
-	_, _ = buf.WriteString(field.Name)
-	_, _ = buf.WriteString(" ")
-	_, _ = buf.WriteString(strconv.FormatBool(field.Ascending))
+	if field.Name != "" {
+		_, _ = buf.WriteString(field.Name)
+		_, _ = buf.WriteString(" ")
+	}
+	if field.Ascending {
+		_, _ = buf.WriteString("ASC")
+	} else {
+		_, _ = buf.WriteString("DESC")
+	}
 	return buf.String()
 }
 
@@ -448,6 +475,12 @@ type CreateRetentionPolicyStatement struct {
 	// Replication factor for data written to this policy.
 	Replication int
 
+	// Length of time covered by each shard group. Optional.
+	ShardGroupDuration time.Duration
+
+	// Compression applied to points written to this policy. Optional.
+	Compression string
+
 	// Should this policy be set as default for the database?
 	Default bool
 }
@@ -460,9 +493,17 @@ func (s *CreateRetentionPolicyStatement) String() string {
 	_, _ = buf.WriteString(" ON ")
 	_, _ = buf.WriteString(s.Database)
 	_, _ = buf.WriteString(" DURATION ")
-	_, _ = buf.WriteString(FormatDuration(s.Duration))
+	_, _ = buf.WriteString(formatRetentionPolicyDuration(s.Duration))
 	_, _ = buf.WriteString(" REPLICATION ")
 	_, _ = buf.WriteString(strconv.Itoa(s.Replication))
+	if s.ShardGroupDuration > 0 {
+		_, _ = buf.WriteString(" SHARD DURATION ")
+		_, _ = buf.WriteString(FormatDuration(s.ShardGroupDuration))
+	}
+	if s.Compression != "" {
+		_, _ = buf.WriteString(" COMPRESSION ")
+		_, _ = buf.WriteString(s.Compression)
+	}
 	if s.Default {
 		_, _ = buf.WriteString(" DEFAULT")
 	}
@@ -474,6 +515,14 @@ func (s *CreateRetentionPolicyStatement) RequiredPrivileges() ExecutionPrivilege
 	return ExecutionPrivileges{{Name: "", Privilege: AllPrivileges}}
 }
 
+// formatRetentionPolicyDuration formats a retention period. Zero is formatted as INF.
+func formatRetentionPolicyDuration(d time.Duration) string {
+	if d == 0 {
+		return "INF"
+	}
+	return FormatDuration(d)
+}
+
 // AlterRetentionPolicyStatement represents a command to alter an existing retention policy.
 type AlterRetentionPolicyStatement struct {
 	// Name of policy to alter.
@@ -488,6 +537,12 @@ type AlterRetentionPolicyStatement struct {
 	// Replication factor for data written to this policy.
 	Replication *int
 
+	// Length of time covered by each new shard group.
+	ShardGroupDuration *time.Duration
+
+	// Compression applied to points in each new shard group.
+	Compression *string
+
 	// Should this policy be set as defalut for the database?
 	Default bool
 }
@@ -502,7 +557,7 @@ func (s *AlterRetentionPolicyStatement) String() string {
 
 	if s.Duration != nil {
 		_, _ = buf.WriteString(" DURATION ")
-		_, _ = buf.WriteString(FormatDuration(*s.Duration))
+		_, _ = buf.WriteString(formatRetentionPolicyDuration(*s.Duration))
 	}
 
 	if s.Replication != nil {
@@ -510,6 +565,16 @@ func (s *AlterRetentionPolicyStatement) String() string {
 		_, _ = buf.WriteString(strconv.Itoa(*s.Replication))
 	}
 
+	if s.ShardGroupDuration != nil {
+		_, _ = buf.WriteString(" SHARD DURATION ")
+		_, _ = buf.WriteString(FormatDuration(*s.ShardGroupDuration))
+	}
+
+	if s.Compression != nil {
+		_, _ = buf.WriteString(" COMPRESSION ")
+		_, _ = buf.WriteString(*s.Compression)
+	}
+
 	if s.Default {
 		_, _ = buf.WriteString(" DEFAULT")
 	}
@@ -549,6 +614,17 @@ type SelectStatement struct {
 	// Returns rows starting at an offset from the first row.
 	Offset int
 
+	// Maximum number of series to be returned.
+	// Unlimited if zero.
+	SLimit int
+
+	// Returns series starting at an offset from the first one.
+	SOffset int
+
+	// Time zone that GROUP BY time() intervals are aligned to.
+	// Intervals are aligned to UTC if nil.
+	Location *time.Location
+
 	// memoize the group by interval
 	groupByInterval time.Duration
 }
@@ -562,6 +638,10 @@ func (s *SelectStatement) Clone() *SelectStatement {
 		SortFields: make(SortFields, len(s.SortFields)),
 		Condition:  CloneExpr(s.Condition),
 		Limit:      s.Limit,
+		Offset:     s.Offset,
+		SLimit:     s.SLimit,
+		SOffset:    s.SOffset,
+		Location:   s.Location,
 	}
 	if s.Target != nil {
 		other.Target = &Target{Measurement: s.Target.Measurement, Database: s.Target.Database}
@@ -579,6 +659,70 @@ func (s *SelectStatement) Clone() *SelectStatement {
 	return other
 }
 
+// HasWildcard returns true if any field or dimension is a wildcard.
+func (s *SelectStatement) HasWildcard() bool {
+	for _, f := range s.Fields {
+		if _, ok := f.Expr.(*Wildcard); ok {
+			return true
+		}
+	}
+	for _, d := range s.Dimensions {
+		if _, ok := d.Expr.(*Wildcard); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// RewriteWildcards returns a copy of the statement with wildcards expanded.
+// A wildcard field is replaced by the given fields and groups by all tag keys.
+// A wildcard dimension is replaced by all tag keys. Tag keys that are already
+// dimensions are not added again.
+func (s *SelectStatement) RewriteWildcards(fields []string, tags []string) *SelectStatement {
+	other := s.Clone()
+	groupByTags := false
+
+	// Expand wildcard fields in place.
+	rwFields := make(Fields, 0, len(other.Fields))
+	for _, f := range other.Fields {
+		if _, ok := f.Expr.(*Wildcard); ok {
+			for _, name := range fields {
+				rwFields = append(rwFields, &Field{Expr: &VarRef{Val: name}})
+			}
+			groupByTags = true
+			continue
+		}
+		rwFields = append(rwFields, f)
+	}
+	other.Fields = rwFields
+
+	// Remove wildcard dimensions and track the existing tag dimensions.
+	rwDimensions := make(Dimensions, 0, len(other.Dimensions))
+	existing := make(map[string]struct{})
+	for _, d := range other.Dimensions {
+		switch expr := d.Expr.(type) {
+		case *Wildcard:
+			groupByTags = true
+			continue
+		case *VarRef:
+			existing[expr.Val] = struct{}{}
+		}
+		rwDimensions = append(rwDimensions, d)
+	}
+
+	// Group by any remaining tag keys.
+	if groupByTags {
+		for _, tag := range tags {
+			if _, ok := existing[tag]; !ok {
+				rwDimensions = append(rwDimensions, &Dimension{Expr: &VarRef{Val: tag}})
+			}
+		}
+	}
+	other.Dimensions = rwDimensions
+
+	return other
+}
+
 func cloneSource(s Source) Source {
 	if s == nil {
 		return nil
@@ -635,6 +779,15 @@ func (s *SelectStatement) String() string {
 		_, _ = buf.WriteString(" OFFSET ")
 		_, _ = buf.WriteString(strconv.Itoa(s.Offset))
 	}
+	if s.SLimit > 0 {
+		_, _ = fmt.Fprintf(&buf, " SLIMIT %d", s.SLimit)
+	}
+	if s.SOffset > 0 {
+		_, _ = fmt.Fprintf(&buf, " SOFFSET %d", s.SOffset)
+	}
+	if s.Location != nil {
+		_, _ = fmt.Fprintf(&buf, " TZ(%s)", QuoteString(s.Location.String()))
+	}
 	return buf.String()
 }
 
@@ -649,6 +802,26 @@ func (s *SelectStatement) RequiredPrivileges() ExecutionPrivileges {
 	return ep
 }
 
+// ExplainStatement represents a command for describing how a select
+// statement will be executed without executing it.
+type ExplainStatement struct {
+	// The select statement to explain.
+	Statement *SelectStatement
+}
+
+// String returns a string representation of the explain statement.
+func (s *ExplainStatement) String() string {
+	var buf bytes.Buffer
+	_, _ = buf.WriteString("EXPLAIN ")
+	_, _ = buf.WriteString(s.Statement.String())
+	return buf.String()
+}
+
+// RequiredPrivileges returns the privilege required to execute an ExplainStatement.
+func (s *ExplainStatement) RequiredPrivileges() ExecutionPrivileges {
+	return ExecutionPrivileges{{Name: "", Privilege: ReadPrivilege}}
+}
+
 // Aggregated returns true if the statement uses aggregate functions.
 func (s *SelectStatement) Aggregated() bool {
 	var v bool
@@ -787,6 +960,11 @@ WHERE yyy.region == "uswest"
 
 */
 
+// TimeAscending returns true if the results should be returned in chronological order.
+func (s *SelectStatement) TimeAscending() bool {
+	return len(s.SortFields) == 0 || s.SortFields[0].Ascending
+}
+
 // Substatement returns a single-series statement for a given variable reference.
 func (s *SelectStatement) Substatement(ref *VarRef) (*SelectStatement, error) {
 	// Copy dimensions and properties to new statement.
@@ -794,7 +972,11 @@ func (s *SelectStatement) Substatement(ref *VarRef) (*SelectStatement, error) {
 		Fields:     Fields{{Expr: ref}},
 		Dimensions: s.Dimensions,
 		Limit:      s.Limit,
+		Offset:     s.Offset,
+		SLimit:     s.SLimit,
+		SOffset:    s.SOffset,
 		SortFields: s.SortFields,
+		Location:   s.Location,
 	}
 
 	// If there is only one series source then return it with the whole condition.
@@ -943,12 +1125,19 @@ type ShowSeriesStatement struct {
 	// Fields to sort results by
 	SortFields SortFields
 
-	// Maximum number of rows to be returned.
+	// Maximum number of series to be returned across all measurements.
 	// Unlimited if zero.
 	Limit int
 
-	// Returns rows starting at an offset from the first row.
+	// Returns series starting at an offset from the first series of the first measurement.
 	Offset int
+
+	// Maximum number of series to be returned for each measurement.
+	// Unlimited if zero.
+	SLimit int
+
+	// Returns series starting at an offset from the first series of each measurement.
+	SOffset int
 }
 
 // String returns a string representation of the list series statement.
@@ -972,6 +1161,14 @@ func (s *ShowSeriesStatement) String() string {
 		_, _ = buf.WriteString(" OFFSET ")
 		_, _ = buf.WriteString(strconv.Itoa(s.Offset))
 	}
+	if s.SLimit > 0 {
+		_, _ = buf.WriteString(" SLIMIT ")
+		_, _ = buf.WriteString(strconv.Itoa(s.SLimit))
+	}
+	if s.SOffset > 0 {
+		_, _ = buf.WriteString(" SOFFSET ")
+		_, _ = buf.WriteString(strconv.Itoa(s.SOffset))
+	}
 	return buf.String()
 }
 
@@ -980,6 +1177,45 @@ func (s *ShowSeriesStatement) RequiredPrivileges() ExecutionPrivileges {
 	return ExecutionPrivileges{{Name: "", Privilege: ReadPrivilege}}
 }
 
+// ShowSeriesCardinalityStatement represents a command for counting the series
+// of each measurement in the database.
+type ShowSeriesCardinalityStatement struct {
+	// Counts the series in the index rather than using the number of series
+	// tracked for each measurement.
+	Exact bool
+
+	// Measurement(s) the series are counted for.
+	Source Source
+
+	// An expression evaluated on a series name or tag.
+	Condition Expr
+}
+
+// String returns a string representation of the statement.
+func (s *ShowSeriesCardinalityStatement) String() string {
+	var buf bytes.Buffer
+	_, _ = buf.WriteString("SHOW SERIES ")
+	if s.Exact {
+		_, _ = buf.WriteString("EXACT ")
+	}
+	_, _ = buf.WriteString("CARDINALITY")
+
+	if s.Source != nil {
+		_, _ = buf.WriteString(" FROM ")
+		_, _ = buf.WriteString(s.Source.String())
+	}
+	if s.Condition != nil {
+		_, _ = buf.WriteString(" WHERE ")
+		_, _ = buf.WriteString(s.Condition.String())
+	}
+	return buf.String()
+}
+
+// RequiredPrivileges returns the privilege required to execute a ShowSeriesCardinalityStatement.
+func (s *ShowSeriesCardinalityStatement) RequiredPrivileges() ExecutionPrivileges {
+	return ExecutionPrivileges{{Name: "", Privilege: ReadPrivilege}}
+}
+
 // DropSeriesStatement represents a command for removing a series from the database.
 type DropSeriesStatement struct {
 	Name string
@@ -993,6 +1229,20 @@ func (s DropSeriesStatement) RequiredPrivileges() ExecutionPrivileges {
 	return ExecutionPrivileges{{Name: "", Privilege: WritePrivilege}}
 }
 
+// DropShardStatement represents a command for removing a shard from the cluster.
+type DropShardStatement struct {
+	// ID of the shard to be dropped.
+	ID uint64
+}
+
+// String returns a string representation of the drop shard statement.
+func (s *DropShardStatement) String() string { return fmt.Sprintf("DROP SHARD %d", s.ID) }
+
+// RequiredPrivileges returns the privilege required to execute a DropShardStatement.
+func (s *DropShardStatement) RequiredPrivileges() ExecutionPrivileges {
+	return ExecutionPrivileges{{Name: "", Privilege: AllPrivileges}}
+}
+
 // ShowContinuousQueriesStatement represents a command for listing continuous queries.
 type ShowContinuousQueriesStatement struct{}
 
@@ -1004,6 +1254,52 @@ func (s *ShowContinuousQueriesStatement) RequiredPrivileges() ExecutionPrivilege
 	return ExecutionPrivileges{{Name: "", Privilege: ReadPrivilege}}
 }
 
+// ShowContinuousQueryStatsStatement represents a command for displaying the
+// execution statistics of continuous queries.
+type ShowContinuousQueryStatsStatement struct{}
+
+// String returns a string representation of the show continuous query stats statement.
+func (s *ShowContinuousQueryStatsStatement) String() string { return "SHOW CONTINUOUS QUERY STATS" }
+
+// RequiredPrivileges returns the privilege required to execute a ShowContinuousQueryStatsStatement.
+func (s *ShowContinuousQueryStatsStatement) RequiredPrivileges() ExecutionPrivileges {
+	return ExecutionPrivileges{{Name: "", Privilege: ReadPrivilege}}
+}
+
+// ShowClusterStatement represents a command for displaying the status of the
+// data nodes, shard replicas and broker replication in the cluster.
+type ShowClusterStatement struct{}
+
+// String returns a string representation of the show cluster statement.
+func (s *ShowClusterStatement) String() string { return "SHOW CLUSTER" }
+
+// RequiredPrivileges returns the privilege required to execute a ShowClusterStatement.
+func (s *ShowClusterStatement) RequiredPrivileges() ExecutionPrivileges {
+	return ExecutionPrivileges{{Name: "", Privilege: AllPrivileges}}
+}
+
+// ShowServersStatement represents a command for listing the data nodes in the cluster.
+type ShowServersStatement struct{}
+
+// String returns a string representation of the show servers statement.
+func (s *ShowServersStatement) String() string { return "SHOW SERVERS" }
+
+// RequiredPrivileges returns the privilege required to execute a ShowServersStatement.
+func (s *ShowServersStatement) RequiredPrivileges() ExecutionPrivileges {
+	return ExecutionPrivileges{{Name: "", Privilege: AllPrivileges}}
+}
+
+// ShowStatsStatement represents a command for displaying internal server statistics.
+type ShowStatsStatement struct{}
+
+// String returns a string representation of the show stats statement.
+func (s *ShowStatsStatement) String() string { return "SHOW STATS" }
+
+// RequiredPrivileges returns the privilege required to execute a ShowStatsStatement.
+func (s *ShowStatsStatement) RequiredPrivileges() ExecutionPrivileges {
+	return ExecutionPrivileges{{Name: "", Privilege: AllPrivileges}}
+}
+
 // ShowDatabasesStatement represents a command for listing all databases in the cluster.
 type ShowDatabasesStatement struct{}
 
@@ -1025,11 +1321,27 @@ type CreateContinuousQueryStatement struct {
 
 	// Source of data (SELECT statement).
 	Source *SelectStatement
+
+	// How often the query runs. Zero uses the server's settings.
+	ResampleEvery time.Duration
+
+	// How far back results are recomputed. Zero uses the server's settings.
+	ResampleFor time.Duration
 }
 
 // String returns a string representation of the statement.
 func (s *CreateContinuousQueryStatement) String() string {
-	return fmt.Sprintf("CREATE CONTINUOUS QUERY %s ON %s BEGIN %s END", s.Name, s.Database, s.Source.String())
+	var resample string
+	if s.ResampleEvery > 0 || s.ResampleFor > 0 {
+		resample = " RESAMPLE"
+		if s.ResampleEvery > 0 {
+			resample += " EVERY " + FormatDuration(s.ResampleEvery)
+		}
+		if s.ResampleFor > 0 {
+			resample += " FOR " + FormatDuration(s.ResampleFor)
+		}
+	}
+	return fmt.Sprintf("CREATE CONTINUOUS QUERY %s ON %s%s BEGIN %s END", s.Name, s.Database, resample, s.Source.String())
 }
 
 // RequiredPrivileges returns the privilege required to execute a CreateContinuousQueryStatement.
@@ -1112,6 +1424,50 @@ func (s *ShowMeasurementsStatement) RequiredPrivileges() ExecutionPrivileges {
 	return ExecutionPrivileges{{Name: "", Privilege: ReadPrivilege}}
 }
 
+// ShowMeasurementCardinalityStatement represents a command for counting the
+// measurements in the database.
+type ShowMeasurementCardinalityStatement struct {
+	// Counts the measurements that have series in the index rather than all
+	// measurements in the metadata.
+	Exact bool
+
+	// An expression evaluated on data point.
+	Condition Expr
+}
+
+// String returns a string representation of the statement.
+func (s *ShowMeasurementCardinalityStatement) String() string {
+	var buf bytes.Buffer
+	_, _ = buf.WriteString("SHOW MEASUREMENT ")
+	if s.Exact {
+		_, _ = buf.WriteString("EXACT ")
+	}
+	_, _ = buf.WriteString("CARDINALITY")
+
+	if s.Condition != nil {
+		_, _ = buf.WriteString(" WHERE ")
+		_, _ = buf.WriteString(s.Condition.String())
+	}
+	return buf.String()
+}
+
+// RequiredPrivileges returns the privilege required to execute a ShowMeasurementCardinalityStatement.
+func (s *ShowMeasurementCardinalityStatement) RequiredPrivileges() ExecutionPrivileges {
+	return ExecutionPrivileges{{Name: "", Privilege: ReadPrivilege}}
+}
+
+// ShowMeasurementSizesStatement represents a command for listing the disk
+// space used by the measurements in the database.
+type ShowMeasurementSizesStatement struct{}
+
+// String returns a string representation of the statement.
+func (s *ShowMeasurementSizesStatement) String() string { return "SHOW MEASUREMENT SIZES" }
+
+// RequiredPrivileges returns the privilege required to execute a ShowMeasurementSizesStatement.
+func (s *ShowMeasurementSizesStatement) RequiredPrivileges() ExecutionPrivileges {
+	return ExecutionPrivileges{{Name: "", Privilege: ReadPrivilege}}
+}
+
 // ShowRetentionPoliciesStatement represents a command for listing retention policies.
 type ShowRetentionPoliciesStatement struct {
 	// Name of the database to list policies for.
@@ -1515,6 +1871,16 @@ type StringLiteral struct {
 // String returns a string representation of the literal.
 func (l *StringLiteral) String() string { return QuoteString(l.Val) }
 
+// RegexLiteral represents a regular expression literal.
+type RegexLiteral struct {
+	Val *regexp.Regexp
+}
+
+// String returns a string representation of the literal.
+func (l *RegexLiteral) String() string {
+	return "/" + strings.Replace(l.Val.String(), "/", `\/`, -1) + "/"
+}
+
 // TimeLiteral represents a point-in-time literal.
 type TimeLiteral struct {
 	Val time.Time
@@ -1588,6 +1954,8 @@ func CloneExpr(expr Expr) Expr {
 		return &NumberLiteral{Val: expr.Val}
 	case *ParenExpr:
 		return &ParenExpr{Expr: CloneExpr(expr.Expr)}
+	case *RegexLiteral:
+		return &RegexLiteral{Val: expr.Val}
 	case *StringLiteral:
 		return &StringLiteral{Val: expr.Val}
 	case *TimeLiteral:
@@ -1696,10 +2064,20 @@ func Walk(v Visitor, node Node) {
 		Walk(v, n.Source)
 		Walk(v, n.Condition)
 
+	case *ExplainStatement:
+		Walk(v, n.Statement)
+
 	case *ShowSeriesStatement:
 		Walk(v, n.Source)
 		Walk(v, n.Condition)
 
+	case *ShowSeriesCardinalityStatement:
+		Walk(v, n.Source)
+		Walk(v, n.Condition)
+
+	case *ShowMeasurementCardinalityStatement:
+		Walk(v, n.Condition)
+
 	case *ShowTagKeysStatement:
 		Walk(v, n.Source)
 		Walk(v, n.Condition)
@@ -1773,6 +2151,9 @@ func Rewrite(r Rewriter, node Node) Node {
 		n.Source = Rewrite(r, n.Source).(Source)
 		n.Condition = Rewrite(r, n.Condition).(Expr)
 
+	case *ExplainStatement:
+		n.Statement = Rewrite(r, n.Statement).(*SelectStatement)
+
 	case Fields:
 		for i, f := range n {
 			n[i] = Rewrite(r, f).(*Field)
@@ -1829,6 +2210,8 @@ func Eval(expr Expr, m map[string]interface{}) interface{} {
 		return expr.Val
 	case *ParenExpr:
 		return Eval(expr.Expr, m)
+	case *RegexLiteral:
+		return expr.Val
 	case *StringLiteral:
 		return expr.Val
 	case *VarRef:
@@ -1880,12 +2263,19 @@ func evalBinaryExpr(expr *BinaryExpr, m map[string]interface{}) interface{} {
 			return lhs / rhs
 		}
 	case string:
-		rhs, _ := rhs.(string)
 		switch expr.Op {
 		case EQ:
+			rhs, _ := rhs.(string)
 			return lhs == rhs
 		case NEQ:
+			rhs, _ := rhs.(string)
 			return lhs != rhs
+		case EQREGEX:
+			rhs, _ := rhs.(*regexp.Regexp)
+			return rhs != nil && rhs.MatchString(lhs)
+		case NEQREGEX:
+			rhs, _ := rhs.(*regexp.Regexp)
+			return rhs != nil && !rhs.MatchString(lhs)
 		}
 	}
 	return nil
@@ -2086,6 +2476,13 @@ func reduceBinaryExprStringLHS(op Token, lhs *StringLiteral, rhs Expr) Expr {
 		case ADD:
 			return &StringLiteral{Val: lhs.Val + rhs.Val}
 		}
+	case *RegexLiteral:
+		switch op {
+		case EQREGEX:
+			return &BooleanLiteral{Val: rhs.Val.MatchString(lhs.Val)}
+		case NEQREGEX:
+			return &BooleanLiteral{Val: !rhs.Val.MatchString(lhs.Val)}
+		}
 	case *nilLiteral:
 		switch op {
 		case EQ, NEQ:
@@ -2189,12 +2586,13 @@ type Valuer interface {
 	Value(key string) (interface{}, bool)
 }
 
-// nowValuer returns only the value for "now()".
-type nowValuer struct {
+// NowValuer returns only the value for "now()".
+type NowValuer struct {
 	Now time.Time
 }
 
-func (v *nowValuer) Value(key string) (interface{}, bool) {
+// Value returns the current time for "now()".
+func (v *NowValuer) Value(key string) (interface{}, bool) {
 	if key == "now()" {
 		return v.Now, true
 	}
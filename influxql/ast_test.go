@@ -96,6 +96,52 @@ func TestSelectStatement_Substatement(t *testing.T) {
 	}
 }
 
+// Ensure wildcards are expanded to fields and tag dimensions.
+func TestSelectStatement_RewriteWildcards(t *testing.T) {
+	var tests = []struct {
+		stmt    string
+		rewrite string
+	}{
+		// No wildcards
+		{
+			stmt:    `SELECT value FROM cpu`,
+			rewrite: `SELECT value FROM cpu`,
+		},
+
+		// Wildcard field
+		{
+			stmt:    `SELECT * FROM cpu`,
+			rewrite: `SELECT value1, value2 FROM cpu GROUP BY host, region`,
+		},
+
+		// Wildcard field with other fields and dimensions
+		{
+			stmt:    `SELECT *, value1 AS v FROM cpu GROUP BY time(1m), host`,
+			rewrite: `SELECT value1, value2, value1 AS v FROM cpu GROUP BY time(1m), host, region`,
+		},
+
+		// Wildcard dimension
+		{
+			stmt:    `SELECT mean(value1) FROM cpu GROUP BY *, time(1m)`,
+			rewrite: `SELECT mean(value1) FROM cpu GROUP BY time(1m), host, region`,
+		},
+	}
+
+	for i, tt := range tests {
+		stmt, err := influxql.NewParser(strings.NewReader(tt.stmt)).ParseStatement()
+		if err != nil {
+			t.Fatalf("%d. %q: error parsing statement: %s", i, tt.stmt, err)
+		}
+		other := stmt.(*influxql.SelectStatement).RewriteWildcards([]string{"value1", "value2"}, []string{"host", "region"})
+		if s := other.String(); s != tt.rewrite {
+			t.Errorf("%d. %q: unexpected rewrite:\n\nexp=%s\n\ngot=%s\n\n", i, tt.stmt, tt.rewrite, s)
+		}
+		if stmt.String() != tt.stmt {
+			t.Errorf("%d. %q: original statement modified: %s", i, tt.stmt, stmt)
+		}
+	}
+}
+
 // Ensure the SELECT statement can extract GROUP BY interval.
 func TestSelectStatement_GroupByInterval(t *testing.T) {
 	q := "SELECT sum(value) from foo GROUP BY time(10m)"
@@ -359,6 +405,10 @@ func TestEval(t *testing.T) {
 		{in: `foo = 'bar'`, out: true, data: map[string]interface{}{"foo": "bar"}},
 		{in: `foo = 'bar'`, out: nil, data: map[string]interface{}{"foo": nil}},
 		{in: `foo <> 'bar'`, out: true, data: map[string]interface{}{"foo": "xxx"}},
+		{in: `foo =~ /b.r/`, out: true, data: map[string]interface{}{"foo": "bar"}},
+		{in: `foo =~ /b.r/`, out: false, data: map[string]interface{}{"foo": "baz"}},
+		{in: `foo !~ /b.r/`, out: true, data: map[string]interface{}{"foo": "baz"}},
+		{in: `foo =~ /b.r/`, out: nil, data: map[string]interface{}{"foo": nil}},
 	} {
 		// Evaluate expression.
 		out := influxql.Eval(MustParseExpr(tt.in), tt.data)
@@ -433,12 +483,16 @@ func TestReduce(t *testing.T) {
 
 		// String literals.
 		{in: `'foo' + 'bar'`, out: `'foobar'`},
+		{in: `'bar' =~ /b.r/`, out: `true`},
+		{in: `'bar' !~ /b.r/`, out: `false`},
+		{in: `foo =~ /\/b.r/`, out: `foo =~ /\/b.r/`},
 
 		// Variable references.
 		{in: `foo`, out: `'bar'`, data: map[string]interface{}{"foo": "bar"}},
 		{in: `foo = 'bar'`, out: `true`, data: map[string]interface{}{"foo": "bar"}},
 		{in: `foo = 'bar'`, out: `false`, data: map[string]interface{}{"foo": nil}},
 		{in: `foo <> 'bar'`, out: `false`, data: map[string]interface{}{"foo": nil}},
+		{in: `foo =~ /b.r/`, out: `true`, data: map[string]interface{}{"foo": "bar"}},
 	} {
 		// Fold expression.
 		expr := influxql.Reduce(MustParseExpr(tt.in), tt.data)
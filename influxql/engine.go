@@ -2,17 +2,31 @@ package influxql
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"hash/fnv"
 	"math"
+	"runtime"
 	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // how many values we will map before emitting
 const emitBatchSize = 1000
 
+var (
+	// ErrMaxSelectPointsLimitExceeded is returned when a query reads more
+	// points than the planner's MaxPointN.
+	ErrMaxSelectPointsLimitExceeded = errors.New("max select points limit exceeded")
+
+	// ErrMaxSelectSeriesLimitExceeded is returned when a query selects more
+	// series than the planner's MaxSeriesN.
+	ErrMaxSelectSeriesLimitExceeded = errors.New("max select series limit exceeded")
+)
+
 // DB represents an interface for creating transactions.
 type DB interface {
 	Begin() (Tx, error)
@@ -45,19 +59,60 @@ type Iterator interface {
 	Next() (key int64, data []byte, value interface{})
 }
 
+// FailingIterator represents an iterator that can stop early because of an
+// error, such as a broken stream from another server. Execution is aborted
+// with the error once the iterator returns no more values.
+type FailingIterator interface {
+	Iterator
+
+	// Err returns the error that stopped the iterator, if any.
+	Err() error
+}
+
+// iteratorErr returns the error that stopped an iterator, if any.
+func iteratorErr(itr Iterator) error {
+	if itr, ok := itr.(FailingIterator); ok {
+		return itr.Err()
+	}
+	return nil
+}
+
 // Planner represents an object for creating execution plans.
 type Planner struct {
 	DB DB
 
 	// Returns the current time. Defaults to time.Now().
 	Now func() time.Time
+
+	// The maximum number of points a query can read. Unlimited if zero.
+	MaxPointN int
+
+	// The maximum number of series (distinct tagsets) a query can select
+	// for each field. Unlimited if zero.
+	MaxSeriesN int
+
+	// The maximum number of mappers scanning shards concurrently within a
+	// query. Defaults to the number of CPUs. If zero, mappers are not pooled
+	// and only scan as fast as the reducer consumes their output.
+	MaxMapperN int
+
+	// The maximum number of values a pooled mapper buffers before it gives
+	// up its place in the pool and waits for the reducer to consume them.
+	// Defaults to DefaultMapperBufferN.
+	MapperBufferN int
 }
 
+// DefaultMapperBufferN is the default maximum number of values buffered by
+// a pooled mapper.
+const DefaultMapperBufferN = 1000
+
 // NewPlanner returns a new instance of Planner.
 func NewPlanner(db DB) *Planner {
 	return &Planner{
-		DB:  db,
-		Now: time.Now,
+		DB:            db,
+		Now:           time.Now,
+		MaxMapperN:    runtime.NumCPU(),
+		MapperBufferN: DefaultMapperBufferN,
 	}
 }
 
@@ -68,7 +123,7 @@ func (p *Planner) Plan(stmt *SelectStatement) (*Executor, error) {
 	// Clone the statement to be planned.
 	// Replace instances of "now()" with the current time.
 	stmt = stmt.Clone()
-	stmt.Condition = Reduce(stmt.Condition, &nowValuer{Now: now})
+	stmt.Condition = Reduce(stmt.Condition, &NowValuer{Now: now})
 
 	// Begin an unopened transaction.
 	tx, err := p.DB.Begin()
@@ -78,6 +133,14 @@ func (p *Planner) Plan(stmt *SelectStatement) (*Executor, error) {
 
 	// Create the executor.
 	e := newExecutor(tx, stmt)
+	e.maxSeriesN = p.MaxSeriesN
+	if p.MaxPointN > 0 {
+		e.limiter = &pointLimiter{max: int64(p.MaxPointN), abort: e.abort}
+	}
+	if p.MaxMapperN > 0 {
+		e.pool = make(chan struct{}, p.MaxMapperN)
+		e.bufferN = p.MapperBufferN
+	}
 
 	// Determine group by tag keys.
 	interval, tags, err := stmt.Dimensions.Normalize()
@@ -85,8 +148,23 @@ func (p *Planner) Plan(stmt *SelectStatement) (*Executor, error) {
 		return nil, err
 	}
 	e.interval = interval
+	e.location = stmt.Location
 	e.tags = tags
 
+	// Determine the time ordering. Only sorting by time is currently supported.
+	for _, f := range stmt.SortFields {
+		if f.Name != "" && f.Name != "time" {
+			return nil, fmt.Errorf("only ORDER BY time supported at this time")
+		}
+	}
+	e.ascending = stmt.TimeAscending()
+
+	// Push the row limit down to the iterators of raw queries so that
+	// shards stop being read once enough points have been returned.
+	if stmt.Limit > 0 && isRawQuery(stmt) {
+		e.rowLimitN = stmt.Limit + stmt.Offset
+	}
+
 	// Generate a processor for each field.
 	e.processors = make([]Processor, len(stmt.Fields))
 	for i, f := range stmt.Fields {
@@ -141,32 +219,53 @@ func (p *Planner) planRawQuery(e *Executor, v *VarRef) (Processor, error) {
 	if err != nil {
 		return nil, err
 	}
+	itrs = limitSeries(itrs, e.stmt.SLimit, e.stmt.SOffset)
+	if e.maxSeriesN > 0 && seriesN(itrs) > e.maxSeriesN {
+		return nil, ErrMaxSelectSeriesLimitExceeded
+	}
 
 	// Create mapper and reducer.
 	mappers := make([]*Mapper, len(itrs))
 	for i, itr := range itrs {
-		mappers[i] = NewMapper(MapRawQuery, itr, e.interval)
+		if e.rowLimitN > 0 {
+			itr = &limitIterator{itr: itr, n: e.rowLimitN}
+		}
+		mappers[i] = e.newMapper(MapRawQuery, itr)
 	}
-	r := NewReducer(ReduceRawQuery, mappers)
-	r.name = lastIdent(stmt.Source.(*Measurement).Name)
-
-	return r, nil
+	return e.newReducer(ReduceRawQuery, mappers, lastIdent(stmt.Source.(*Measurement).Name)), nil
 
 }
 
 // planCall generates a processor for a function call.
 func (p *Planner) planCall(e *Executor, c *Call) (Processor, error) {
-	// Ensure there is a single argument.
-	if c.Name == "percentile" {
+	// Ensure the function has the correct number of arguments.
+	switch strings.ToLower(c.Name) {
+	case "percentile", "top", "bottom":
 		if len(c.Args) != 2 {
-			return nil, fmt.Errorf("expected two arguments for percentile()")
+			return nil, fmt.Errorf("expected two arguments for %s()", c.Name)
+		}
+	case "derivative", "non_negative_derivative":
+		if len(c.Args) < 1 || len(c.Args) > 2 {
+			return nil, fmt.Errorf("expected one or two arguments for %s()", c.Name)
 		}
-	} else if len(c.Args) != 1 {
-		return nil, fmt.Errorf("expected one argument for %s()", c.Name)
+	default:
+		if len(c.Args) != 1 {
+			return nil, fmt.Errorf("expected one argument for %s()", c.Name)
+		}
+	}
+
+	// Unwrap count(distinct(field)) so it is planned against the inner field.
+	arg := c.Args[0]
+	countDistinct := false
+	if inner, ok := arg.(*Call); ok && strings.ToLower(c.Name) == "count" && strings.ToLower(inner.Name) == "distinct" {
+		if len(inner.Args) != 1 {
+			return nil, fmt.Errorf("expected one argument for %s()", inner.Name)
+		}
+		arg, countDistinct = inner.Args[0], true
 	}
 
 	// Ensure the argument is a variable reference.
-	ref, ok := c.Args[0].(*VarRef)
+	ref, ok := arg.(*VarRef)
 	if !ok {
 		return nil, fmt.Errorf("expected field argument in %s()", c.Name)
 	}
@@ -182,13 +281,23 @@ func (p *Planner) planCall(e *Executor, c *Call) (Processor, error) {
 	if err != nil {
 		return nil, err
 	}
+	itrs = limitSeries(itrs, e.stmt.SLimit, e.stmt.SOffset)
+	if e.maxSeriesN > 0 && seriesN(itrs) > e.maxSeriesN {
+		return nil, ErrMaxSelectSeriesLimitExceeded
+	}
 
 	// Retrieve map & reduce functions by name.
 	var mapFn MapFunc
 	var reduceFn ReduceFunc
 	switch strings.ToLower(c.Name) {
 	case "count":
-		mapFn, reduceFn = MapCount, ReduceSum
+		if countDistinct {
+			mapFn, reduceFn = MapDistinct, ReduceCountDistinct
+		} else {
+			mapFn, reduceFn = MapCount, ReduceSum
+		}
+	case "distinct":
+		mapFn, reduceFn = MapDistinct, ReduceDistinct
 	case "sum":
 		mapFn, reduceFn = MapSum, ReduceSum
 	case "mean":
@@ -203,14 +312,42 @@ func (p *Planner) planCall(e *Executor, c *Call) (Processor, error) {
 		mapFn, reduceFn = MapStddev, ReduceStddev
 	case "first":
 		mapFn, reduceFn = MapFirst, ReduceFirst
+		if e.isSelector(c) {
+			reduceFn = ReduceFirstSelector
+		}
 	case "last":
 		mapFn, reduceFn = MapLast, ReduceLast
+		if e.isSelector(c) {
+			reduceFn = ReduceLastSelector
+		}
 	case "percentile":
 		lit, ok := c.Args[1].(*NumberLiteral)
 		if !ok {
 			return nil, fmt.Errorf("expected float argument in percentile()")
+		} else if lit.Val <= 0 || lit.Val > 100 {
+			return nil, fmt.Errorf("percentile must be greater than 0 and less than or equal to 100")
 		}
 		mapFn, reduceFn = MapEcho, ReducePercentile(lit.Val)
+	case "top", "bottom":
+		lit, ok := c.Args[1].(*NumberLiteral)
+		if !ok || lit.Val != math.Trunc(lit.Val) || lit.Val < 1 {
+			return nil, fmt.Errorf("expected positive integer argument in %s()", c.Name)
+		}
+		bottom := strings.ToLower(c.Name) == "bottom"
+		mapFn, reduceFn = MapTop(int(lit.Val), bottom), ReduceTop(int(lit.Val), bottom)
+	case "derivative", "non_negative_derivative":
+		unit := time.Second
+		if len(c.Args) == 2 {
+			lit, ok := c.Args[1].(*DurationLiteral)
+			if !ok {
+				return nil, fmt.Errorf("expected duration argument in %s()", c.Name)
+			} else if lit.Val <= 0 {
+				return nil, fmt.Errorf("duration argument must be positive in %s()", c.Name)
+			}
+			unit = lit.Val
+		}
+		nonNegative := strings.ToLower(c.Name) == "non_negative_derivative"
+		mapFn, reduceFn = MapRawValues, ReduceDerivative(e.interval, unit, nonNegative)
 	default:
 		return nil, fmt.Errorf("function not found: %q", c.Name)
 	}
@@ -218,12 +355,106 @@ func (p *Planner) planCall(e *Executor, c *Call) (Processor, error) {
 	// Create mapper and reducer.
 	mappers := make([]*Mapper, len(itrs))
 	for i, itr := range itrs {
-		mappers[i] = NewMapper(mapFn, itr, e.interval)
+		mappers[i] = e.newMapper(mapFn, itr)
+	}
+	return e.newReducer(reduceFn, mappers, lastIdent(stmt.Source.(*Measurement).Name)), nil
+}
+
+// isSelector returns true if the call is the only field in the statement.
+// Selectors return values at their original timestamps instead of the interval's.
+func (e *Executor) isSelector(c *Call) bool {
+	return len(e.stmt.Fields) == 1 && e.stmt.Fields[0].Expr == Expr(c)
+}
+
+// limitSeries returns the iterators for a window of series, ordered by tagset.
+// A zero limit returns all series after the offset.
+func limitSeries(itrs []Iterator, limit, offset int) []Iterator {
+	if limit == 0 && offset == 0 {
+		return itrs
+	}
+
+	// Group iterators by their distinct tagsets.
+	var tagsets []string
+	m := make(map[string][]Iterator)
+	for _, itr := range itrs {
+		tags := itr.Tags()
+		if _, ok := m[tags]; !ok {
+			tagsets = append(tagsets, tags)
+		}
+		m[tags] = append(m[tags], itr)
 	}
-	r := NewReducer(reduceFn, mappers)
-	r.name = lastIdent(stmt.Source.(*Measurement).Name)
+	sort.Strings(tagsets)
 
-	return r, nil
+	// Apply the offset and limit to the tagsets.
+	if offset >= len(tagsets) {
+		return nil
+	}
+	tagsets = tagsets[offset:]
+	if limit > 0 && len(tagsets) > limit {
+		tagsets = tagsets[:limit]
+	}
+
+	// Return the iterators for the remaining tagsets.
+	var other []Iterator
+	for _, tags := range tagsets {
+		other = append(other, m[tags]...)
+	}
+	return other
+}
+
+// isRawQuery returns true if every field in the statement is a field reference.
+func isRawQuery(stmt *SelectStatement) bool {
+	for _, f := range stmt.Fields {
+		if _, ok := f.Expr.(*VarRef); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// limitIterator wraps an iterator and stops reading from it after a number of
+// distinct timestamps. Points sharing the last timestamp are still returned.
+type limitIterator struct {
+	itr  Iterator
+	n    int   // remaining distinct timestamps
+	prev int64 // timestamp of the last point returned
+	done bool
+}
+
+// Tags returns the encoded dimensional tag values of the underlying iterator.
+func (i *limitIterator) Tags() string { return i.itr.Tags() }
+
+// Err returns the error that stopped the underlying iterator, if any.
+func (i *limitIterator) Err() error { return iteratorErr(i.itr) }
+
+// Next returns the next point until the limit is reached.
+func (i *limitIterator) Next() (key int64, data []byte, value interface{}) {
+	if i.done {
+		return 0, nil, nil
+	}
+
+	key, data, value = i.itr.Next()
+	if key == 0 || key == i.prev {
+		return key, data, value
+	}
+
+	// Stop once a new timestamp exceeds the limit.
+	if i.n == 0 {
+		i.done = true
+		return 0, nil, nil
+	}
+	i.n--
+	i.prev = key
+	return key, data, value
+}
+
+// seriesN returns the number of distinct tagsets in a list of iterators.
+func seriesN(itrs []Iterator) int {
+	m := make(map[string]struct{})
+	for _, itr := range itrs {
+		m[itr.Tags()] = struct{}{}
+	}
+	return len(m)
 }
 
 // planBinaryExpr generates a processor for a binary expression.
@@ -252,7 +483,19 @@ type Executor struct {
 	stmt       *SelectStatement // original statement
 	processors []Processor      // per-field processors
 	interval   time.Duration    // group by interval
+	location   *time.Location   // optional, time zone intervals are aligned to
 	tags       []string         // dimensional tag keys
+	ascending  bool             // chronological time ordering
+	maxSeriesN int              // maximum series per field, if non-zero
+	limiter    *pointLimiter    // optional, limits the points read
+	rowLimitN  int              // optional, points read per raw query iterator
+	pool       chan struct{}    // optional, bounds concurrently scanning mappers
+	bufferN    int              // optional, values buffered by a pooled mapper
+
+	mu   sync.Mutex
+	err  error          // set when execution is aborted
+	done chan struct{}  // closed when execution is stopped
+	wg   sync.WaitGroup // running mappers
 }
 
 // newExecutor returns an executor associated with a transaction and statement.
@@ -260,7 +503,60 @@ func newExecutor(tx Tx, stmt *SelectStatement) *Executor {
 	return &Executor{
 		tx:   tx,
 		stmt: stmt,
+		done: make(chan struct{}),
+	}
+}
+
+// newMapper returns a mapper which stops when the executor is stopped.
+func (e *Executor) newMapper(fn MapFunc, itr Iterator) *Mapper {
+	m := NewMapper(fn, itr, e.interval)
+	m.ascending = e.ascending
+	m.location = e.location
+	m.limiter = e.limiter
+	m.abort = e.abort
+	m.pool = e.pool
+	m.bufferN = e.bufferN
+	m.done = e.done
+	m.wg = &e.wg
+	return m
+}
+
+// newReducer returns a reducer which stops when the executor is stopped.
+func (e *Executor) newReducer(fn ReduceFunc, mappers []*Mapper, name string) *Reducer {
+	r := NewReducer(fn, mappers)
+	r.name = name
+	r.ascending = e.ascending
+	r.done = e.done
+	return r
+}
+
+// Stop aborts execution of the query. The channel returned from Execute()
+// is closed without sending any further rows. Stop can be called multiple times.
+func (e *Executor) Stop() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	select {
+	case <-e.done:
+	default:
+		close(e.done)
+	}
+}
+
+// abort stops execution and records err. The error is sent as the final row.
+func (e *Executor) abort(err error) {
+	e.mu.Lock()
+	if e.err == nil {
+		e.err = err
 	}
+	e.mu.Unlock()
+	e.Stop()
+}
+
+// error returns the error that execution was aborted with, if any.
+func (e *Executor) error() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.err
 }
 
 // Execute begins execution of the query and returns a channel to receive rows.
@@ -287,10 +583,14 @@ func (e *Executor) execute(out chan *Row) {
 	// Ensure the transaction closes after execution.
 	defer e.tx.Close()
 
+	// Mark the end of the output channel.
+	defer close(out)
+
 	// TODO: Support multi-value rows.
 
-	// Initialize map of rows by encoded tagset.
+	// Initialize map of rows by encoded tagset and their values by timestamp.
 	rows := make(map[string]*Row)
+	index := make(map[string]map[int64][]interface{})
 
 	// Combine values from each processor.
 loop:
@@ -299,7 +599,21 @@ loop:
 		// row based on their tagset.
 		for i, p := range e.processors {
 			// Retrieve data from the processor.
-			m, ok := <-p.C()
+			// If execution is stopped then wait for the mappers to stop
+			// reading from the transaction and exit.
+			var m map[Key]interface{}
+			var ok bool
+			select {
+			case m, ok = <-p.C():
+			case <-e.done:
+				e.wg.Wait()
+
+				// Send the error if execution was aborted.
+				if err := e.error(); err != nil {
+					out <- &Row{Err: err}
+				}
+				return
+			}
 			if !ok {
 				break loop
 			}
@@ -307,19 +621,36 @@ loop:
 			// Set values on returned row.
 			for k, v := range m {
 				// Lookup row values and populate data.
-				values := e.createRowValuesIfNotExists(rows, e.processors[0].Name(), k.Timestamp, k.Values)
+				values := e.createRowValuesIfNotExists(rows, index, e.processors[0].Name(), k.Timestamp, k.Values)
 				values[i+1] = v
 			}
 		}
 	}
 
+	// Send the error instead of partial results if execution was aborted.
+	if err := e.error(); err != nil {
+		out <- &Row{Err: err}
+		return
+	}
+
 	// Normalize rows and values.
+	// Sort values by time and apply the limit & offset to each row.
 	// Convert all times to timestamps
 	a := make(Rows, 0, len(rows))
 	for _, row := range rows {
+		sort.Stable(valuesByTime{row.Values, e.ascending})
+		row.Values = limitValues(row.Values, e.stmt.Limit, e.stmt.Offset)
+		if len(row.Values) == 0 {
+			continue
+		}
+
 		for _, values := range row.Values {
 			t := time.Unix(0, values[0].(int64))
-			values[0] = t.UTC()
+			if e.location != nil {
+				values[0] = t.In(e.location)
+			} else {
+				values[0] = t.UTC()
+			}
 		}
 		a = append(a, row)
 	}
@@ -327,15 +658,44 @@ loop:
 
 	// Send rows to the channel.
 	for _, row := range a {
-		out <- row
+		select {
+		case out <- row:
+		case <-e.done:
+			return
+		}
 	}
+}
 
-	// Mark the end of the output channel.
-	close(out)
+// limitValues returns values after skipping offset values and truncating to limit.
+// A zero limit returns all remaining values.
+func limitValues(values [][]interface{}, limit, offset int) [][]interface{} {
+	if offset >= len(values) {
+		return nil
+	}
+	values = values[offset:]
+	if limit > 0 && len(values) > limit {
+		values = values[:limit]
+	}
+	return values
+}
+
+// valuesByTime sorts row values by their timestamp.
+type valuesByTime struct {
+	values    [][]interface{}
+	ascending bool
+}
+
+func (a valuesByTime) Len() int      { return len(a.values) }
+func (a valuesByTime) Swap(i, j int) { a.values[i], a.values[j] = a.values[j], a.values[i] }
+func (a valuesByTime) Less(i, j int) bool {
+	if a.ascending {
+		return a.values[i][0].(int64) < a.values[j][0].(int64)
+	}
+	return a.values[i][0].(int64) > a.values[j][0].(int64)
 }
 
 // creates a new value set if one does not already exist for a given tagset + timestamp.
-func (e *Executor) createRowValuesIfNotExists(rows map[string]*Row, name string, timestamp int64, tagset string) []interface{} {
+func (e *Executor) createRowValuesIfNotExists(rows map[string]*Row, index map[string]map[int64][]interface{}, name string, timestamp int64, tagset string) []interface{} {
 	// TODO: Add "name" to lookup key.
 
 	// Find row by tagset.
@@ -362,31 +722,45 @@ func (e *Executor) createRowValuesIfNotExists(rows map[string]*Row, name string,
 
 		// Save to lookup.
 		rows[tagset] = row
+		index[tagset] = make(map[int64][]interface{})
 	}
 
-	// If no values exist or last value doesn't match the timestamp then create new.
-	if len(row.Values) == 0 || row.Values[len(row.Values)-1][0] != timestamp {
-		values := make([]interface{}, len(e.processors)+1)
+	// If no values exist for the timestamp then create new.
+	// Processors emit independently so a timestamp may be revisited by a later field.
+	values := index[tagset][timestamp]
+	if values == nil {
+		values = make([]interface{}, len(e.processors)+1)
 		values[0] = timestamp
 		row.Values = append(row.Values, values)
+		index[tagset][timestamp] = values
 	}
 
-	return row.Values[len(row.Values)-1]
+	return values
 }
 
 // Mapper represents an object for processing iterators.
 type Mapper struct {
-	fn       MapFunc  // map function
-	itr      Iterator // iterators
-	interval int64    // grouping interval
+	fn        MapFunc        // map function
+	itr       Iterator       // iterators
+	interval  int64          // grouping interval
+	location  *time.Location // optional, time zone intervals are aligned to
+	ascending bool           // iterator returns points in chronological order
+	limiter   *pointLimiter  // optional, limits the points read
+	abort     func(error)    // optional, aborts execution if the iterator fails
+	pool      chan struct{}  // optional, bounds concurrently scanning mappers
+	bufferN   int            // optional, values buffered while in the pool
+
+	done <-chan struct{} // closed when the mapper should stop
+	wg   *sync.WaitGroup // optional, tracks running mappers
 }
 
 // NewMapper returns a new instance of Mapper with a given function and interval.
 func NewMapper(fn MapFunc, itr Iterator, interval time.Duration) *Mapper {
 	return &Mapper{
-		fn:       fn,
-		itr:      itr,
-		interval: interval.Nanoseconds(),
+		fn:        fn,
+		itr:       itr,
+		interval:  interval.Nanoseconds(),
+		ascending: true,
 	}
 }
 
@@ -394,29 +768,93 @@ func NewMapper(fn MapFunc, itr Iterator, interval time.Duration) *Mapper {
 // Returns a nil emitter if no data was found.
 func (m *Mapper) Map() *Emitter {
 	e := NewEmitter(1)
+	e.done = m.done
+	if m.wg != nil {
+		m.wg.Add(1)
+	}
 	go m.run(e)
 	return e
 }
 
 func (m *Mapper) run(e *Emitter) {
-	// Close emitter when we're done.
-	defer func() { _ = e.Close() }()
+	// Close emitter when we're done. Execution is aborted first if the
+	// iterator stopped because of an error so the output isn't used.
+	defer func() {
+		if err := iteratorErr(m.itr); err != nil && m.abort != nil {
+			m.abort(err)
+		}
+		_ = e.Close()
+		if m.wg != nil {
+			m.wg.Done()
+		}
+	}()
 
+	// Without a pool, stream output as the reducer consumes it.
+	if m.pool == nil {
+		m.scan(e)
+		return
+	}
+
+	// Otherwise wait for a slot in the pool and scan the iterator into the
+	// emitter's buffer. The slot is released before the output is sent so
+	// mappers for later shards can scan while earlier ones are being reduced.
+	// A full buffer is sent, and a slot waited for again, before scanning
+	// continues so raw queries don't hold a whole shard in memory.
+	held := m.acquire()
+	if !held {
+		return
+	}
+	e.buffering, e.bufferN = true, m.bufferN
+	e.full = func() {
+		<-m.pool
+		held = false
+		e.flush()
+		e.buffering = true
+		held = m.acquire()
+	}
+	m.scan(e)
+	if held {
+		<-m.pool
+	}
+	e.flush()
+}
+
+// acquire waits for a slot in the pool.
+// Returns false if the mapper was stopped first.
+func (m *Mapper) acquire() bool {
+	select {
+	case m.pool <- struct{}{}:
+		return true
+	case <-m.done:
+		return false
+	}
+}
+
+// scan executes the map function against each interval of the iterator.
+func (m *Mapper) scan(e *Emitter) {
 	// Wrap iterator with buffer.
-	bufItr := &bufIterator{itr: m.itr}
+	bufItr := &bufIterator{itr: m.itr, done: m.done, limiter: m.limiter}
 
 	// Determine the start time.
 	var tmin int64
 	if m.interval > 0 {
 		// Align start time to interval.
 		tmin, _, _ = bufItr.Peek()
-		tmin -= (tmin % m.interval)
+		tmin = m.truncate(tmin)
 	}
 
 	for {
-		// Set the upper bound of the interval.
+		// Set the upper bound of the interval. If the iterator is in
+		// descending order then set the lower bound instead.
+		var next int64
 		if m.interval > 0 {
-			bufItr.tmax = tmin + m.interval - 1
+			if m.ascending {
+				next = m.next(tmin)
+				bufItr.tmax = next - 1
+			} else {
+				next = m.truncate(tmin - 1)
+				bufItr.tmin = tmin
+			}
 		}
 
 		// Exit if there was only one interval or no more data is available.
@@ -427,15 +865,58 @@ func (m *Mapper) run(e *Emitter) {
 		// Execute the map function.
 		m.fn(bufItr, e, tmin)
 
-		// Move the interval forward.
-		tmin += m.interval
+		// Move the interval forward, or backward if descending.
+		tmin = next
 	}
 }
 
+// truncate returns the start of the interval containing t. Intervals are
+// aligned to local time if the mapper has a location.
+func (m *Mapper) truncate(t int64) int64 {
+	if m.location == nil {
+		return t - (t % m.interval)
+	}
+	offset := zoneOffset(t, m.location)
+	start := t - ((t + offset) % m.interval)
+
+	// Realign if the offset changed within the interval, e.g. across DST.
+	if o := zoneOffset(start, m.location); o != offset {
+		start = t - ((t + o) % m.interval)
+	}
+	return start
+}
+
+// next returns the start of the interval following the interval starting at t.
+func (m *Mapper) next(t int64) int64 {
+	next := t + m.interval
+	if m.location == nil {
+		return next
+	}
+
+	// Shift by any change in the offset so long intervals keep their local
+	// alignment, e.g. a day is 23 or 25 hours across DST.
+	if d := zoneOffset(t, m.location) - zoneOffset(next, m.location); d != 0 && d < m.interval && -d < m.interval {
+		next += d
+	}
+	if start := m.truncate(next); start > t {
+		return start
+	}
+	return next
+}
+
+// zoneOffset returns the offset of a location from UTC at t, in nanoseconds.
+func zoneOffset(t int64, loc *time.Location) int64 {
+	_, offset := time.Unix(0, t).In(loc).Zone()
+	return int64(offset) * int64(time.Second)
+}
+
 // bufIterator represents a buffer iterator.
 type bufIterator struct {
-	itr  Iterator // underlying iterator
-	tmax int64    // maximum key
+	itr     Iterator        // underlying iterator
+	tmin    int64           // minimum key
+	tmax    int64           // maximum key
+	done    <-chan struct{} // closed when iteration should stop
+	limiter *pointLimiter   // optional, limits the points read
 
 	buf struct {
 		key   int64
@@ -453,13 +934,18 @@ func (i *bufIterator) Next() (key int64, data []byte, value interface{}) {
 	// Read the key/value pair off the buffer or underlying iterator.
 	if i.buffered {
 		i.buffered = false
+	} else if i.stopped() {
+		i.buf.key, i.buf.data, i.buf.value = 0, nil, nil
 	} else {
 		i.buf.key, i.buf.data, i.buf.value = i.itr.Next()
+		if i.buf.key != 0 && i.limiter != nil && !i.limiter.inc() {
+			i.buf.key, i.buf.data, i.buf.value = 0, nil, nil
+		}
 	}
 	key, data, value = i.buf.key, i.buf.data, i.buf.value
 
-	// If key is greater than tmax then put it back on the buffer.
-	if i.tmax != 0 && key > i.tmax {
+	// If key is outside of tmin/tmax then put it back on the buffer.
+	if (i.tmax != 0 && key > i.tmax) || (i.tmin != 0 && key != 0 && key < i.tmin) {
 		i.buffered = true
 		return 0, nil, nil
 	}
@@ -477,6 +963,34 @@ func (i *bufIterator) Peek() (key int64, data []byte, value interface{}) {
 // EOF returns true if there is no more data in the underlying iterator.
 func (i *bufIterator) EOF() bool { i.Peek(); return i.buf.key == 0 }
 
+// stopped returns true if the iterator has been signaled to stop.
+func (i *bufIterator) stopped() bool {
+	select {
+	case <-i.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// pointLimiter counts the points read by all mappers of an executor and
+// aborts execution once the maximum is exceeded.
+type pointLimiter struct {
+	n     int64 // points read, updated atomically
+	max   int64
+	abort func(error)
+}
+
+// inc increments the number of points read.
+// Returns false and aborts execution if the limit has been exceeded.
+func (l *pointLimiter) inc() bool {
+	if atomic.AddInt64(&l.n, 1) > l.max {
+		l.abort(ErrMaxSelectPointsLimitExceeded)
+		return false
+	}
+	return true
+}
+
 // MapFunc represents a function used for mapping iterators.
 type MapFunc func(Iterator, *Emitter, int64)
 
@@ -508,18 +1022,21 @@ type Processor interface {
 // Reducer represents an object for processing mapper output.
 // Implements processor.
 type Reducer struct {
-	name    string
-	fn      ReduceFunc // reduce function
-	mappers []*Mapper  // child mappers
+	name      string
+	fn        ReduceFunc // reduce function
+	mappers   []*Mapper  // child mappers
+	ascending bool       // mappers emit keys in chronological order
 
-	c <-chan map[Key]interface{}
+	c    <-chan map[Key]interface{}
+	done <-chan struct{} // closed when the reducer should stop emitting
 }
 
 // NewReducer returns a new instance of reducer.
 func NewReducer(fn ReduceFunc, mappers []*Mapper) *Reducer {
 	return &Reducer{
-		fn:      fn,
-		mappers: mappers,
+		fn:        fn,
+		mappers:   mappers,
+		ascending: true,
 	}
 }
 
@@ -540,6 +1057,7 @@ func (r *Reducer) Reduce() *Emitter {
 	}
 
 	e := NewEmitter(1)
+	e.done = r.done
 	r.c = e.C()
 	go r.run(e, inputs)
 	return e
@@ -564,7 +1082,7 @@ func (r *Reducer) run(e *Emitter, inputs []<-chan map[Key]interface{}) {
 			if rec == nil {
 				continue
 			}
-			if timestamp == 0 || rec.Key.Timestamp < timestamp {
+			if timestamp == 0 || (r.ascending && rec.Key.Timestamp < timestamp) || (!r.ascending && rec.Key.Timestamp > timestamp) {
 				timestamp = rec.Key.Timestamp
 			}
 		}
@@ -886,27 +1404,18 @@ func MapFirst(itr Iterator, e *Emitter, tmax int64) {
 
 // ReduceFirst computes the first of value.
 func ReduceFirst(key Key, values []interface{}, e *Emitter) {
-	out := firstLastMapOutput{}
-	pointsYielded := false
-
-	for _, v := range values {
-		val := v.(firstLastMapOutput)
-		// Initialize first
-		if !pointsYielded {
-			out.Time = val.Time
-			out.Val = val.Val
-			pointsYielded = true
-		}
-		if val.Time < out.Time {
-			out.Time = val.Time
-			out.Val = val.Val
-		}
-	}
-	if pointsYielded {
+	if out, ok := reduceFirstLast(values, false); ok {
 		e.Emit(key, out.Val)
 	}
 }
 
+// ReduceFirstSelector emits the first value at its original timestamp.
+func ReduceFirstSelector(key Key, values []interface{}, e *Emitter) {
+	if out, ok := reduceFirstLast(values, false); ok {
+		e.Emit(Key{out.Time, key.Values}, out.Val)
+	}
+}
+
 // MapLast collects the values to pass to the reducer
 func MapLast(itr Iterator, e *Emitter, tmax int64) {
 	out := firstLastMapOutput{}
@@ -931,25 +1440,29 @@ func MapLast(itr Iterator, e *Emitter, tmax int64) {
 
 // ReduceLast computes the last of value.
 func ReduceLast(key Key, values []interface{}, e *Emitter) {
-	out := firstLastMapOutput{}
-	pointsYielded := false
+	if out, ok := reduceFirstLast(values, true); ok {
+		e.Emit(key, out.Val)
+	}
+}
 
-	for _, v := range values {
+// ReduceLastSelector emits the last value at its original timestamp.
+func ReduceLastSelector(key Key, values []interface{}, e *Emitter) {
+	if out, ok := reduceFirstLast(values, true); ok {
+		e.Emit(Key{out.Time, key.Values}, out.Val)
+	}
+}
+
+// reduceFirstLast returns the earliest mapped value, or the latest if last is set.
+// Returns false if there are no values.
+func reduceFirstLast(values []interface{}, last bool) (firstLastMapOutput, bool) {
+	var out firstLastMapOutput
+	for i, v := range values {
 		val := v.(firstLastMapOutput)
-		// Initialize last
-		if !pointsYielded {
-			out.Time = val.Time
-			out.Val = val.Val
-			pointsYielded = true
-		}
-		if val.Time > out.Time {
-			out.Time = val.Time
-			out.Val = val.Val
+		if i == 0 || (!last && val.Time < out.Time) || (last && val.Time > out.Time) {
+			out = val
 		}
 	}
-	if pointsYielded {
-		e.Emit(key, out.Val)
-	}
+	return out, len(values) > 0
 }
 
 // MapEcho emits the data points for each group by interval
@@ -974,18 +1487,228 @@ func ReducePercentile(percentile float64) ReduceFunc {
 			}
 		}
 
+		// Emit zero for intervals without any data points.
+		if len(allValues) == 0 {
+			e.Emit(key, 0.0)
+			return
+		}
+
 		sort.Float64s(allValues)
 		length := len(allValues)
 		index := int(math.Floor(float64(length)*percentile/100.0+0.5)) - 1
 
-		if index < 0 || index >= len(allValues) {
-			e.Emit(key, 0.0)
+		// Clamp the index to the bounds of the values.
+		if index < 0 {
+			index = 0
+		} else if index >= length {
+			index = length - 1
 		}
 
 		e.Emit(key, allValues[index])
 	}
 }
 
+// MapDistinct emits the unique values for each group by interval.
+func MapDistinct(itr Iterator, e *Emitter, tmin int64) {
+	index := make(map[interface{}]struct{})
+	for k, _, v := itr.Next(); k != 0; k, _, v = itr.Next() {
+		index[v] = struct{}{}
+	}
+
+	values := make(distinctValues, 0, len(index))
+	for v := range index {
+		values = append(values, v)
+	}
+	e.Emit(Key{tmin, itr.Tags()}, values)
+}
+
+// ReduceDistinct emits the sorted unique values across all mappers for each key.
+// Keys without any values are not emitted.
+func ReduceDistinct(key Key, values []interface{}, e *Emitter) {
+	results := mergeDistinctValues(values)
+	if len(results) == 0 {
+		return
+	}
+	sort.Sort(results)
+	e.Emit(key, []interface{}(results))
+}
+
+// ReduceCountDistinct emits the number of unique values across all mappers for each key.
+func ReduceCountDistinct(key Key, values []interface{}, e *Emitter) {
+	e.Emit(key, float64(len(mergeDistinctValues(values))))
+}
+
+// mergeDistinctValues returns the union of the distinct values emitted by MapDistinct.
+func mergeDistinctValues(values []interface{}) distinctValues {
+	index := make(map[interface{}]struct{})
+	for _, v := range values {
+		for _, vv := range v.(distinctValues) {
+			index[vv] = struct{}{}
+		}
+	}
+
+	results := make(distinctValues, 0, len(index))
+	for v := range index {
+		results = append(results, v)
+	}
+	return results
+}
+
+// distinctValues is a sortable list of field values.
+// Numbers sort before strings, which sort before booleans.
+type distinctValues []interface{}
+
+func (a distinctValues) Len() int      { return len(a) }
+func (a distinctValues) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a distinctValues) Less(i, j int) bool {
+	ti, tj := distinctTypeOrder(a[i]), distinctTypeOrder(a[j])
+	if ti != tj {
+		return ti < tj
+	}
+
+	switch v := a[i].(type) {
+	case float64:
+		return v < a[j].(float64)
+	case string:
+		return v < a[j].(string)
+	case bool:
+		return !v && a[j].(bool)
+	}
+	return false
+}
+
+// distinctTypeOrder returns the relative sort order for the type of v.
+func distinctTypeOrder(v interface{}) int {
+	switch v.(type) {
+	case float64:
+		return 0
+	case string:
+		return 1
+	case bool:
+		return 2
+	}
+	return 3
+}
+
+// MapRawValues emits the timestamps and values of the data points for each group by interval.
+func MapRawValues(itr Iterator, e *Emitter, tmin int64) {
+	var values rawQueryMapOutputs
+	for k, _, v := itr.Next(); k != 0; k, _, v = itr.Next() {
+		values = append(values, &rawQueryMapOutput{timestamp: k, value: v})
+	}
+	if len(values) > 0 {
+		e.Emit(Key{tmin, itr.Tags()}, values)
+	}
+}
+
+// ReduceDerivative returns a reduce function which computes the rate of change
+// of values per unit of time.
+//
+// Without a group by interval, a derivative is emitted for each point using the
+// point before it. With an interval, a single derivative is emitted for each
+// interval using the first and last points within that interval. When
+// nonNegative is set, negative rates (such as counter resets) are dropped.
+func ReduceDerivative(interval, unit time.Duration, nonNegative bool) ReduceFunc {
+	return func(key Key, values []interface{}, e *Emitter) {
+		// Merge the points from all mappers and sort by time.
+		var points rawQueryMapOutputs
+		for _, v := range values {
+			points = append(points, v.(rawQueryMapOutputs)...)
+		}
+		if len(points) < 2 {
+			return
+		}
+		sort.Sort(points)
+
+		// Computes the rate of change between two points.
+		rate := func(prev, curr *rawQueryMapOutput) (float64, bool) {
+			elapsed := curr.timestamp - prev.timestamp
+			if elapsed == 0 {
+				return 0, false
+			}
+			v := (curr.value.(float64) - prev.value.(float64)) / (float64(elapsed) / float64(unit))
+			if nonNegative && v < 0 {
+				return 0, false
+			}
+			return v, true
+		}
+
+		// Emit a single value for the interval if grouping by time.
+		if interval > 0 {
+			if v, ok := rate(points[0], points[len(points)-1]); ok {
+				e.Emit(key, v)
+			}
+			return
+		}
+
+		// Otherwise emit a value for each point after the first.
+		for i := 1; i < len(points); i++ {
+			if v, ok := rate(points[i-1], points[i]); ok {
+				e.Emit(Key{points[i].timestamp, key.Values}, v)
+			}
+		}
+	}
+}
+
+// MapTop returns a map function which emits the n largest data points for
+// each group by interval. If bottom is set then the n smallest points are emitted.
+func MapTop(n int, bottom bool) MapFunc {
+	return func(itr Iterator, e *Emitter, tmin int64) {
+		var values rawQueryMapOutputs
+		for k, _, v := itr.Next(); k != 0; k, _, v = itr.Next() {
+			values = append(values, &rawQueryMapOutput{timestamp: k, value: v})
+		}
+		if len(values) > 0 {
+			e.Emit(Key{tmin, itr.Tags()}, topValues(values, n, bottom))
+		}
+	}
+}
+
+// ReduceTop returns a reduce function which emits the n largest data points
+// for each key at their original timestamps. If bottom is set then the n
+// smallest points are emitted.
+func ReduceTop(n int, bottom bool) ReduceFunc {
+	return func(key Key, values []interface{}, e *Emitter) {
+		var points rawQueryMapOutputs
+		for _, v := range values {
+			points = append(points, v.(rawQueryMapOutputs)...)
+		}
+
+		// Select the points and emit them in time order.
+		points = topValues(points, n, bottom)
+		sort.Sort(points)
+		for _, p := range points {
+			e.Emit(Key{p.timestamp, key.Values}, p.value)
+		}
+	}
+}
+
+// topValues returns the n largest points in a. If bottom is set then the n
+// smallest points are returned. Ties are broken by the earliest timestamp.
+func topValues(a rawQueryMapOutputs, n int, bottom bool) rawQueryMapOutputs {
+	sort.Sort(topSorter{a, bottom})
+	if len(a) > n {
+		a = a[:n]
+	}
+	return a
+}
+
+// topSorter sorts raw values by value, largest first unless bottom is set.
+type topSorter struct {
+	rawQueryMapOutputs
+	bottom bool
+}
+
+func (s topSorter) Less(i, j int) bool {
+	vi, vj := s.rawQueryMapOutputs[i].value.(float64), s.rawQueryMapOutputs[j].value.(float64)
+	if vi == vj {
+		return s.rawQueryMapOutputs[i].timestamp < s.rawQueryMapOutputs[j].timestamp
+	} else if s.bottom {
+		return vi < vj
+	}
+	return vi > vj
+}
+
 func MapRawQuery(itr Iterator, e *Emitter, tmin int64) {
 	for k, _, v := itr.Next(); k != 0; k, _, v = itr.Next() {
 		e.Emit(Key{k, itr.Tags()}, v)
@@ -997,6 +1720,13 @@ type rawQueryMapOutput struct {
 	value     interface{}
 }
 
+// rawQueryMapOutputs represents a list of raw values sortable by timestamp.
+type rawQueryMapOutputs []*rawQueryMapOutput
+
+func (a rawQueryMapOutputs) Len() int           { return len(a) }
+func (a rawQueryMapOutputs) Less(i, j int) bool { return a[i].timestamp < a[j].timestamp }
+func (a rawQueryMapOutputs) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+
 func ReduceRawQuery(key Key, values []interface{}, e *Emitter) {
 	for _, v := range values {
 		e.Emit(key, v)
@@ -1064,8 +1794,13 @@ func (e *binaryExprEvaluator) run() {
 			m[k] = e.eval(float64(0), v)
 		}
 
-		// Return value.
-		e.c <- m
+		// Return value. Exit if the executor has been stopped.
+		select {
+		case e.c <- m:
+		case <-e.executor.done:
+			close(e.c)
+			return
+		}
 	}
 
 	// Mark the channel as complete.
@@ -1156,7 +1891,13 @@ func (p keySlice) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
 
 // Emitter provides bufferred emit/flush of key/value pairs.
 type Emitter struct {
-	c chan map[Key]interface{}
+	c    chan map[Key]interface{}
+	done <-chan struct{} // closed when values should be discarded
+
+	buffering bool                  // hold values until flushed
+	buf       []map[Key]interface{} // held values
+	bufferN   int                   // optional, held values that fill the buffer
+	full      func()                // called once the buffer is full
 }
 
 // NewEmitter returns a new instance of Emitter with a buffer size of n.
@@ -1173,7 +1914,33 @@ func (e *Emitter) Close() error { close(e.c); return nil }
 func (e *Emitter) C() <-chan map[Key]interface{} { return e.c }
 
 // Emit sets a key and value on the emitter's bufferred data.
-func (e *Emitter) Emit(key Key, value interface{}) { e.c <- map[Key]interface{}{key: value} }
+// The value is discarded if the emitter has been stopped.
+func (e *Emitter) Emit(key Key, value interface{}) {
+	if e.buffering {
+		e.buf = append(e.buf, map[Key]interface{}{key: value})
+		if e.bufferN > 0 && len(e.buf) >= e.bufferN && e.full != nil {
+			e.full()
+		}
+		return
+	}
+	select {
+	case e.c <- map[Key]interface{}{key: value}:
+	case <-e.done:
+	}
+}
+
+// flush sends all held values to the output channel and stops buffering.
+func (e *Emitter) flush() {
+	e.buffering = false
+	defer func() { e.buf = e.buf[:0] }()
+	for _, m := range e.buf {
+		select {
+		case e.c <- m:
+		case <-e.done:
+			return
+		}
+	}
+}
 
 // Row represents a single row returned from the execution of a statement.
 type Row struct {
@@ -422,7 +422,7 @@ func TestPlanner_Plan_FirstWithResults(t *testing.T) {
 	}
 
 	// Expected resultset.
-	exp := minify(`[{"name":"cpu","columns":["time","first"],"values":[["2000-01-01T00:00:00Z",2],["2000-01-01T00:01:00Z",10],["2000-01-01T00:02:00Z",10]]}]`)
+	exp := minify(`[{"name":"cpu","columns":["time","first"],"values":[["2000-01-01T00:00:10Z",2],["2000-01-01T00:01:00Z",10],["2000-01-01T00:02:20Z",10]]}]`)
 
 	// Execute and compare with results.
 	rs := MustPlanAndExecute(NewDB(tx), `2000-01-01T12:00:00Z`,
@@ -470,7 +470,7 @@ func TestPlanner_Plan_LastWithResults(t *testing.T) {
 	}
 
 	// Expected resultset.
-	exp := minify(`[{"name":"cpu","columns":["time","last"],"values":[["2000-01-01T00:00:00Z",3],["2000-01-01T00:01:00Z",4],["2000-01-01T00:02:00Z",10]]}]`)
+	exp := minify(`[{"name":"cpu","columns":["time","last"],"values":[["2000-01-01T00:00:20Z",3],["2000-01-01T00:01:50Z",4],["2000-01-01T00:02:20Z",10]]}]`)
 
 	// Execute and compare with results.
 	rs := MustPlanAndExecute(NewDB(tx), `2000-01-01T12:00:00Z`,
@@ -480,6 +480,29 @@ func TestPlanner_Plan_LastWithResults(t *testing.T) {
 	}
 }
 
+// Ensure first() and last() use interval timestamps when combined with other fields.
+func TestPlanner_Plan_FirstLastMultipleFields(t *testing.T) {
+	tx := NewTx()
+	tx.CreateIteratorsFunc = func(stmt *influxql.SelectStatement) ([]influxql.Iterator, error) {
+		return []influxql.Iterator{
+			NewIterator(nil, []Point{
+				{"2000-01-01T00:00:10Z", float64(2)},
+				{"2000-01-01T00:00:20Z", float64(3)},
+				{"2000-01-01T00:01:10Z", float64(8)},
+			})}, nil
+	}
+
+	// Expected resultset.
+	exp := minify(`[{"name":"cpu","columns":["time","first","last"],"values":[["2000-01-01T00:00:00Z",2,3],["2000-01-01T00:01:00Z",8,8]]}]`)
+
+	// Execute and compare with results.
+	rs := MustPlanAndExecute(NewDB(tx), `2000-01-01T12:00:00Z`,
+		`SELECT first(value), last(value) FROM cpu WHERE time >= '2000-01-01' GROUP BY time(1m)`)
+	if act := minify(jsonify(rs)); exp != act {
+		t.Fatalf("unexpected resultset: %s", act)
+	}
+}
+
 // Ensure the planner can plan and execute a last query without results
 func TestPlanner_Plan_LastWithoutResults(t *testing.T) {
 	tx := NewTx()
@@ -539,6 +562,360 @@ func TestPlanner_Plan_Percentile(t *testing.T) {
 	}
 }
 
+// Ensure the planner can plan and execute a percentile query grouped by interval and tag.
+func TestPlanner_Plan_PercentileGroupByIntervalAndTag(t *testing.T) {
+	tx := NewTx()
+	tx.CreateIteratorsFunc = func(stmt *influxql.SelectStatement) ([]influxql.Iterator, error) {
+		return []influxql.Iterator{
+			NewIterator([]string{"servera"}, []Point{
+				{"2000-01-01T09:00:00Z", float64(10)},
+				{"2000-01-01T09:10:00Z", float64(30)},
+				{"2000-01-01T09:20:00Z", float64(20)},
+				{"2000-01-01T11:00:00Z", float64(40)},
+			}),
+			NewIterator([]string{"servera"}, []Point{
+				{"2000-01-01T09:30:00Z", float64(50)},
+			}),
+			NewIterator([]string{"serverb"}, []Point{
+				{"2000-01-01T09:00:00Z", float64(1)},
+				{"2000-01-01T11:00:00Z", float64(2)},
+			})}, nil
+	}
+
+	// Query for the median, grouped every hour per host.
+	rs := MustPlanAndExecute(NewDB(tx), "2000-01-01T12:00:00Z", `
+		SELECT percentile(value, 50)
+		FROM cpu
+		WHERE time >= now() - 3h
+		GROUP BY time(1h), host`)
+
+	// Expected resultset.
+	exp := minify(`[{
+		"name":"cpu",
+		"tags":{"host":"servera"},
+		"columns":["time","percentile"],
+		"values":[
+			["2000-01-01T09:00:00Z",20],
+			["2000-01-01T10:00:00Z",0],
+			["2000-01-01T11:00:00Z",40]
+		]
+	},{
+		"name":"cpu",
+		"tags":{"host":"serverb"},
+		"columns":["time","percentile"],
+		"values":[
+			["2000-01-01T09:00:00Z",1],
+			["2000-01-01T10:00:00Z",0],
+			["2000-01-01T11:00:00Z",2]
+		]
+	}]`)
+
+	// Compare resultsets.
+	if act := jsonify(rs); exp != act {
+		t.Fatalf("unexpected resultset: %s", indent(act))
+	}
+}
+
+// Ensure the planner returns an error for an out of range percentile.
+func TestPlanner_Plan_Percentile_ErrOutOfRange(t *testing.T) {
+	tx := NewTx()
+	tx.CreateIteratorsFunc = func(stmt *influxql.SelectStatement) ([]influxql.Iterator, error) {
+		return nil, nil
+	}
+
+	for _, s := range []string{
+		`SELECT percentile(value, 0) FROM cpu`,
+		`SELECT percentile(value, 100.1) FROM cpu`,
+	} {
+		_, err := PlanAndExecute(NewDB(tx), `2000-01-01T12:00:00Z`, s)
+		if err == nil || err.Error() != `percentile must be greater than 0 and less than or equal to 100` {
+			t.Fatalf("unexpected error: %s: %v", s, err)
+		}
+	}
+}
+
+// Ensure the planner can plan and execute top() and bottom() queries.
+func TestPlanner_Plan_TopBottom(t *testing.T) {
+	tx := NewTx()
+	tx.CreateIteratorsFunc = func(stmt *influxql.SelectStatement) ([]influxql.Iterator, error) {
+		return []influxql.Iterator{
+			NewIterator([]string{"servera"}, []Point{
+				{"2000-01-01T00:00:00Z", float64(10)},
+				{"2000-01-01T00:00:10Z", float64(50)},
+				{"2000-01-01T00:00:20Z", float64(30)},
+			}),
+			NewIterator([]string{"servera"}, []Point{
+				{"2000-01-01T00:00:05Z", float64(40)},
+				{"2000-01-01T00:00:15Z", float64(20)},
+			}),
+			NewIterator([]string{"serverb"}, []Point{
+				{"2000-01-01T00:00:00Z", float64(5)},
+				{"2000-01-01T00:01:00Z", float64(7)},
+			})}, nil
+	}
+
+	// Retrieve the two largest points for each host.
+	exp := minify(`[{"name":"cpu","tags":{"host":"servera"},"columns":["time","top"],"values":[["2000-01-01T00:00:05Z",40],["2000-01-01T00:00:10Z",50]]},{"name":"cpu","tags":{"host":"serverb"},"columns":["time","top"],"values":[["2000-01-01T00:00:00Z",5],["2000-01-01T00:01:00Z",7]]}]`)
+	rs := MustPlanAndExecute(NewDB(tx), `2000-01-01T12:00:00Z`,
+		`SELECT top(value, 2) FROM cpu WHERE time >= '2000-01-01' GROUP BY host`)
+	if act := minify(jsonify(rs)); exp != act {
+		t.Fatalf("unexpected resultset: %s", act)
+	}
+
+	// Retrieve the smallest point for each host every minute.
+	exp = minify(`[{"name":"cpu","tags":{"host":"servera"},"columns":["time","bottom"],"values":[["2000-01-01T00:00:00Z",10]]},{"name":"cpu","tags":{"host":"serverb"},"columns":["time","bottom"],"values":[["2000-01-01T00:00:00Z",5],["2000-01-01T00:01:00Z",7]]}]`)
+	rs = MustPlanAndExecute(NewDB(tx), `2000-01-01T12:00:00Z`,
+		`SELECT bottom(value, 1) FROM cpu WHERE time >= '2000-01-01' GROUP BY time(1m), host`)
+	if act := minify(jsonify(rs)); exp != act {
+		t.Fatalf("unexpected resultset: %s", act)
+	}
+
+	// Ensure the number of points must be a positive integer.
+	if _, err := PlanAndExecute(NewDB(tx), `2000-01-01T12:00:00Z`, `SELECT top(value, 1.5) FROM cpu`); err == nil || err.Error() != `expected positive integer argument in top()` {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure the planner can plan and execute distinct and count distinct queries.
+func TestPlanner_Plan_Distinct(t *testing.T) {
+	tx := NewTx()
+	tx.CreateIteratorsFunc = func(stmt *influxql.SelectStatement) ([]influxql.Iterator, error) {
+		return []influxql.Iterator{
+			NewIterator([]string{"servera"}, []Point{
+				{"2000-01-01T00:00:00Z", float64(3)},
+				{"2000-01-01T00:00:10Z", float64(1)},
+				{"2000-01-01T00:01:00Z", float64(3)},
+			}),
+			NewIterator([]string{"servera"}, []Point{
+				{"2000-01-01T00:00:05Z", float64(1)},
+				{"2000-01-01T00:00:15Z", float64(2)},
+			}),
+			NewIterator([]string{"serverb"}, []Point{
+				{"2000-01-01T00:00:00Z", float64(5)},
+			})}, nil
+	}
+
+	// Retrieve the unique values for each host.
+	exp := minify(`[{"name":"cpu","tags":{"host":"servera"},"columns":["time","distinct"],"values":[["1970-01-01T00:00:00Z",[1,2,3]]]},{"name":"cpu","tags":{"host":"serverb"},"columns":["time","distinct"],"values":[["1970-01-01T00:00:00Z",[5]]]}]`)
+	rs := MustPlanAndExecute(NewDB(tx), `2000-01-01T12:00:00Z`,
+		`SELECT distinct(value) FROM cpu WHERE time >= '2000-01-01' GROUP BY host`)
+	if act := minify(jsonify(rs)); exp != act {
+		t.Fatalf("unexpected resultset: %s", act)
+	}
+
+	// The DISTINCT keyword form should return the same result.
+	rs = MustPlanAndExecute(NewDB(tx), `2000-01-01T12:00:00Z`,
+		`SELECT DISTINCT value FROM cpu WHERE time >= '2000-01-01' GROUP BY host`)
+	if act := minify(jsonify(rs)); exp != act {
+		t.Fatalf("unexpected resultset: %s", act)
+	}
+
+	// Count the unique values for each host every minute.
+	exp = minify(`[{"name":"cpu","tags":{"host":"servera"},"columns":["time","count"],"values":[["2000-01-01T00:00:00Z",3],["2000-01-01T00:01:00Z",1]]},{"name":"cpu","tags":{"host":"serverb"},"columns":["time","count"],"values":[["2000-01-01T00:00:00Z",1]]}]`)
+	rs = MustPlanAndExecute(NewDB(tx), `2000-01-01T00:02:00Z`,
+		`SELECT count(distinct(value)) FROM cpu WHERE time >= '2000-01-01' AND time < '2000-01-01T00:02:00Z' GROUP BY time(1m), host`)
+	if act := minify(jsonify(rs)); exp != act {
+		t.Fatalf("unexpected resultset: %s", act)
+	}
+}
+
+// Ensure the planner can plan and execute a derivative query.
+func TestPlanner_Plan_Derivative(t *testing.T) {
+	tx := NewTx()
+	tx.CreateIteratorsFunc = func(stmt *influxql.SelectStatement) ([]influxql.Iterator, error) {
+		return []influxql.Iterator{
+			NewIterator(nil, []Point{
+				{"2000-01-01T00:00:00Z", float64(100)},
+				{"2000-01-01T00:00:20Z", float64(120)},
+			}),
+			NewIterator(nil, []Point{
+				{"2000-01-01T00:00:10Z", float64(110)},
+				{"2000-01-01T00:01:00Z", float64(10)},
+				{"2000-01-01T00:01:30Z", float64(40)},
+			})}, nil
+	}
+
+	// Derivative between each point, per second by default.
+	exp := minify(`[{"name":"cpu","columns":["time","derivative"],"values":[["2000-01-01T00:00:10Z",1],["2000-01-01T00:00:20Z",1],["2000-01-01T00:01:00Z",-2.75],["2000-01-01T00:01:30Z",1]]}]`)
+	rs := MustPlanAndExecute(NewDB(tx), `2000-01-01T12:00:00Z`,
+		`SELECT derivative(value) FROM cpu WHERE time >= '2000-01-01'`)
+	if act := minify(jsonify(rs)); exp != act {
+		t.Fatalf("unexpected resultset: %s", act)
+	}
+
+	// Non-negative derivative per minute drops counter resets.
+	exp = minify(`[{"name":"cpu","columns":["time","non_negative_derivative"],"values":[["2000-01-01T00:00:10Z",60],["2000-01-01T00:00:20Z",60],["2000-01-01T00:01:30Z",60]]}]`)
+	rs = MustPlanAndExecute(NewDB(tx), `2000-01-01T12:00:00Z`,
+		`SELECT non_negative_derivative(value, 1m) FROM cpu WHERE time >= '2000-01-01'`)
+	if act := minify(jsonify(rs)); exp != act {
+		t.Fatalf("unexpected resultset: %s", act)
+	}
+
+	// Derivative between the first and last point of each interval.
+	exp = minify(`[{"name":"cpu","columns":["time","derivative"],"values":[["2000-01-01T00:00:00Z",1],["2000-01-01T00:01:00Z",1]]}]`)
+	rs = MustPlanAndExecute(NewDB(tx), `2000-01-01T12:00:00Z`,
+		`SELECT derivative(value) FROM cpu WHERE time >= '2000-01-01' GROUP BY time(1m)`)
+	if act := minify(jsonify(rs)); exp != act {
+		t.Fatalf("unexpected resultset: %s", act)
+	}
+}
+
+// Ensure the planner can plan and execute a query in descending time order.
+func TestPlanner_Plan_OrderByTimeDesc(t *testing.T) {
+	tx := NewTx()
+	tx.CreateIteratorsFunc = func(stmt *influxql.SelectStatement) ([]influxql.Iterator, error) {
+		if stmt.TimeAscending() {
+			t.Fatal("expected descending substatement")
+		}
+		return []influxql.Iterator{
+			NewIterator(nil, []Point{
+				{"2000-01-01T00:01:30Z", float64(40)},
+				{"2000-01-01T00:00:20Z", float64(30)},
+				{"2000-01-01T00:00:00Z", float64(10)},
+			}),
+			NewIterator(nil, []Point{
+				{"2000-01-01T00:00:10Z", float64(20)},
+			})}, nil
+	}
+
+	// Expected resultset.
+	exp := minify(`[{"name":"cpu","columns":["time","sum"],"values":[["2000-01-01T00:01:00Z",40],["2000-01-01T00:00:00Z",60]]}]`)
+
+	// Execute and compare.
+	rs := MustPlanAndExecute(NewDB(tx), `2000-01-01T12:00:00Z`,
+		`SELECT sum(value) FROM cpu WHERE time >= '2000-01-01' GROUP BY time(1m) ORDER BY time DESC`)
+	if act := minify(jsonify(rs)); exp != act {
+		t.Fatalf("unexpected resultset: %s", act)
+	}
+
+	// Only sorting by time is supported.
+	if _, err := PlanAndExecute(NewDB(tx), `2000-01-01T12:00:00Z`, `SELECT value FROM cpu ORDER BY value`); err == nil || err.Error() != `only ORDER BY time supported at this time` {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure the planner can limit the number of series returned.
+func TestPlanner_Plan_SLimitSOffset(t *testing.T) {
+	tx := NewTx()
+	tx.CreateIteratorsFunc = func(stmt *influxql.SelectStatement) ([]influxql.Iterator, error) {
+		return []influxql.Iterator{
+			NewIterator([]string{"serverc"}, []Point{
+				{"2000-01-01T00:00:00Z", float64(3)},
+			}),
+			NewIterator([]string{"servera"}, []Point{
+				{"2000-01-01T00:00:00Z", float64(1)},
+			}),
+			NewIterator([]string{"serverb"}, []Point{
+				{"2000-01-01T00:00:00Z", float64(2)},
+			}),
+			NewIterator([]string{"serverb"}, []Point{
+				{"2000-01-01T00:00:10Z", float64(20)},
+			})}, nil
+	}
+
+	// Expected resultset.
+	exp := minify(`[{"name":"cpu","tags":{"host":"serverb"},"columns":["time","sum"],"values":[["2000-01-01T00:00:00Z",22]]}]`)
+
+	// Execute and compare.
+	rs := MustPlanAndExecute(NewDB(tx), `2000-01-01T12:00:00Z`,
+		`SELECT sum(value) FROM cpu WHERE time >= '2000-01-01' GROUP BY time(1m), host SLIMIT 1 SOFFSET 1`)
+	if act := minify(jsonify(rs)); exp != act {
+		t.Fatalf("unexpected resultset: %s", act)
+	}
+}
+
+// Ensure the executor aborts a query that reads too many points or series.
+func TestPlanner_Plan_MaxPointNAndSeriesN(t *testing.T) {
+	tx := NewTx()
+	tx.CreateIteratorsFunc = func(stmt *influxql.SelectStatement) ([]influxql.Iterator, error) {
+		return []influxql.Iterator{
+			NewIterator([]string{"servera"}, []Point{
+				{"2000-01-01T00:00:00Z", float64(1)},
+				{"2000-01-01T00:00:10Z", float64(2)},
+			}),
+			NewIterator([]string{"serverb"}, []Point{
+				{"2000-01-01T00:00:00Z", float64(3)},
+				{"2000-01-01T00:00:10Z", float64(4)},
+			})}, nil
+	}
+
+	// Ensure the query succeeds at the limits.
+	p := influxql.NewPlanner(NewDB(tx))
+	p.MaxPointN, p.MaxSeriesN = 4, 2
+	if rs := MustExecute(p, `SELECT value FROM cpu GROUP BY host`); len(rs) != 2 || rs[0].Err != nil || rs[1].Err != nil {
+		t.Fatalf("unexpected rows: %s", jsonify(rs))
+	}
+
+	// Ensure reading too many points returns an error row.
+	p.MaxPointN = 3
+	if rs := MustExecute(p, `SELECT value FROM cpu GROUP BY host`); len(rs) != 1 || rs[0].Err != influxql.ErrMaxSelectPointsLimitExceeded {
+		t.Fatalf("unexpected rows: %s", jsonify(rs))
+	}
+
+	// Ensure selecting too many series returns an error when planning.
+	p.MaxPointN, p.MaxSeriesN = 0, 1
+	if _, err := p.Plan(MustParseSelectStatement(`SELECT value FROM cpu GROUP BY host`)); err != influxql.ErrMaxSelectSeriesLimitExceeded {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure pooled mappers merge shards in order regardless of the pool size.
+func TestPlanner_Plan_MaxMapperN(t *testing.T) {
+	tx := NewTx()
+	tx.CreateIteratorsFunc = func(stmt *influxql.SelectStatement) ([]influxql.Iterator, error) {
+		return []influxql.Iterator{
+			NewIterator(nil, []Point{
+				{"2000-01-01T00:01:00Z", float64(3)},
+				{"2000-01-01T00:01:10Z", float64(4)},
+			}),
+			NewIterator(nil, []Point{
+				{"2000-01-01T00:00:00Z", float64(1)},
+				{"2000-01-01T00:00:10Z", float64(2)},
+			}),
+			NewIterator(nil, []Point{
+				{"2000-01-01T00:02:00Z", float64(5)},
+			})}, nil
+	}
+
+	// Execute with a single pooled mapper, a larger pool, and no pool.
+	exp := minify(`[{"name":"cpu","columns":["time","sum"],"values":[["2000-01-01T00:00:00Z",3],["2000-01-01T00:01:00Z",7],["2000-01-01T00:02:00Z",5]]}]`)
+	for _, n := range []int{1, 2, 0} {
+		p := influxql.NewPlanner(NewDB(tx))
+		p.MaxMapperN = n
+		if act := minify(jsonify(MustExecute(p, `SELECT sum(value) FROM cpu WHERE time >= '2000-01-01' AND time < '2000-01-01T00:03:00Z' GROUP BY time(1m)`))); exp != act {
+			t.Fatalf("unexpected resultset(%d): %s", n, act)
+		}
+	}
+}
+
+// Ensure pooled mappers flush raw points in chunks without blocking each other.
+func TestPlanner_Plan_MapperBufferN(t *testing.T) {
+	tx := NewTx()
+	tx.CreateIteratorsFunc = func(stmt *influxql.SelectStatement) ([]influxql.Iterator, error) {
+		return []influxql.Iterator{
+			NewIterator(nil, []Point{
+				{"2000-01-01T00:00:10Z", float64(2)},
+				{"2000-01-01T00:00:30Z", float64(4)},
+				{"2000-01-01T00:00:50Z", float64(6)},
+			}),
+			NewIterator(nil, []Point{
+				{"2000-01-01T00:00:00Z", float64(1)},
+				{"2000-01-01T00:00:20Z", float64(3)},
+				{"2000-01-01T00:00:40Z", float64(5)},
+			})}, nil
+	}
+
+	// Execute with a single pooled mapper and buffers smaller than an iterator.
+	exp := minify(`[{"name":"cpu","columns":["time","value"],"values":[["2000-01-01T00:00:00Z",1],["2000-01-01T00:00:10Z",2],["2000-01-01T00:00:20Z",3],["2000-01-01T00:00:30Z",4],["2000-01-01T00:00:40Z",5],["2000-01-01T00:00:50Z",6]]}]`)
+	for _, n := range []int{1, 2} {
+		p := influxql.NewPlanner(NewDB(tx))
+		p.MaxMapperN, p.MapperBufferN = 1, n
+		if act := minify(jsonify(MustExecute(p, `SELECT value FROM cpu WHERE time >= '2000-01-01' AND time < '2000-01-01T00:01:00Z'`))); exp != act {
+			t.Fatalf("unexpected resultset(%d): %s", n, act)
+		}
+	}
+}
+
 // Ensure the planner can plan and execute a query that returns raw data points
 func TestPlanner_Plan_RawData(t *testing.T) {
 	tx := NewTx()
@@ -577,6 +954,39 @@ func TestPlanner_Plan_RawData(t *testing.T) {
 	}
 }
 
+// Ensure raw queries stop reading iterators once the limit is satisfied.
+func TestPlanner_Plan_RawDataLimit(t *testing.T) {
+	var points []Point
+	for i := 0; i < 100; i++ {
+		points = append(points, Point{time.Unix(int64(946684800+i*10), 0).UTC().Format(time.RFC3339), float64(i)})
+	}
+	itr0 := NewIterator(nil, points)
+	itr1 := NewIterator(nil, []Point{
+		{"2000-01-01T00:00:05Z", float64(-1)},
+		{"2000-01-01T00:00:15Z", float64(-2)},
+	})
+
+	tx := NewTx()
+	tx.CreateIteratorsFunc = func(stmt *influxql.SelectStatement) ([]influxql.Iterator, error) {
+		return []influxql.Iterator{itr0, itr1}, nil
+	}
+
+	// Expected resultset.
+	exp := minify(`[{"name":"cpu","columns":["time","value"],"values":[["2000-01-01T00:00:05Z",-1],["2000-01-01T00:00:10Z",1],["2000-01-01T00:00:15Z",-2]]}]`)
+
+	// Execute and compare.
+	rs := MustPlanAndExecute(NewDB(tx), `2000-01-01T12:00:00Z`,
+		`SELECT value FROM cpu LIMIT 3 OFFSET 1`)
+	if act := minify(jsonify(rs)); exp != act {
+		t.Fatalf("unexpected resultset: %s", act)
+	}
+
+	// Only the first points plus one past the limit should have been read.
+	if itr0.index > 5 {
+		t.Fatalf("unexpected points read: %d", itr0.index)
+	}
+}
+
 // Ensure the planner can plan and execute a count query grouped by hour.
 func TestPlanner_Plan_GroupByInterval(t *testing.T) {
 	tx := NewTx()
@@ -620,6 +1030,45 @@ func TestPlanner_Plan_GroupByInterval(t *testing.T) {
 	}
 }
 
+// Ensure the planner aligns intervals to local time when a time zone is specified.
+func TestPlanner_Plan_GroupByIntervalTZ(t *testing.T) {
+	tx := NewTx()
+	tx.CreateIteratorsFunc = func(stmt *influxql.SelectStatement) ([]influxql.Iterator, error) {
+		return []influxql.Iterator{
+			NewIterator(nil, []Point{
+				{"2015-10-31T05:00:00Z", float64(1)}, // Oct 31 01:00 EDT
+				{"2015-11-01T03:59:00Z", float64(2)}, // Oct 31 23:59 EDT
+				{"2015-11-01T04:00:00Z", float64(3)}, // Nov 1 00:00 EDT
+				{"2015-11-02T04:30:00Z", float64(4)}, // Nov 1 23:30 EST
+				{"2015-11-02T05:00:00Z", float64(5)}, // Nov 2 00:00 EST
+			})}, nil
+	}
+
+	// Expected resultset. The day of the DST change is 25 hours long.
+	exp := minify(`[{
+		"name":"cpu",
+		"columns":["time","sum"],
+		"values":[
+			["2015-10-31T00:00:00-04:00",3],
+			["2015-11-01T00:00:00-04:00",7],
+			["2015-11-02T00:00:00-05:00",5]
+		]
+	}]`)
+
+	// Query for daily sums in New York time.
+	rs := MustPlanAndExecute(NewDB(tx), "2015-11-03T00:00:00Z", `
+		SELECT sum(value)
+		FROM cpu
+		WHERE time >= '2015-10-30T00:00:00Z'
+		GROUP BY time(1d)
+		TZ('America/New_York')`)
+
+	// Compare resultsets.
+	if act := jsonify(rs); exp != act {
+		t.Fatalf("unexpected resultset: %s", indent(act))
+	}
+}
+
 // Ensure the planner can plan and execute a query grouped by interval and tag.
 func TestPlanner_Plan_GroupByIntervalAndTag(t *testing.T) {
 	tx := NewTx()
@@ -709,6 +1158,60 @@ func TestPlanner_CreateIterators(t *testing.T) {
 	}
 }
 
+// Ensure a running executor can be stopped.
+func TestExecutor_Stop(t *testing.T) {
+	closed := make(chan struct{})
+	tx := NewTx()
+	tx.CloseFunc = func() error { close(closed); return nil }
+	tx.CreateIteratorsFunc = func(stmt *influxql.SelectStatement) ([]influxql.Iterator, error) {
+		return []influxql.Iterator{&InfiniteIterator{}}, nil
+	}
+
+	// Plan and execute a query that never finishes on its own.
+	p := influxql.NewPlanner(NewDB(tx))
+	e, err := p.Plan(MustParseSelectStatement(`SELECT sum(value) FROM cpu`))
+	if err != nil {
+		t.Fatalf("unexpected plan error: %s", err)
+	}
+	ch, err := e.Execute()
+	if err != nil {
+		t.Fatalf("unexpected execute error: %s", err)
+	}
+
+	// Stop the executor and ensure the channel closes without rows.
+	e.Stop()
+	e.Stop()
+	select {
+	case row, ok := <-ch:
+		if ok {
+			t.Fatalf("unexpected row: %#v", row)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatal("timed out waiting for executor to stop")
+	}
+
+	// Ensure the transaction was closed.
+	select {
+	case <-closed:
+	case <-time.After(1 * time.Second):
+		t.Fatal("transaction not closed")
+	}
+}
+
+// InfiniteIterator represents an iterator that never reaches the end.
+type InfiniteIterator struct {
+	key int64
+}
+
+// Tags returns an empty tagset.
+func (i *InfiniteIterator) Tags() string { return "" }
+
+// Next returns an ever increasing key with a constant value.
+func (i *InfiniteIterator) Next() (key int64, data []byte, value interface{}) {
+	i.key++
+	return i.key, nil, float64(1)
+}
+
 // DB represents a mockable database.
 type DB struct {
 	BeginFunc func() (influxql.Tx, error)
@@ -820,6 +1323,25 @@ func MustPlanAndExecute(db influxql.DB, now string, querystring string) []*influ
 	return rs
 }
 
+// MustExecute plans and executes a query with the given planner and retrieves all rows.
+// Panic on planning or execution error.
+func MustExecute(p *influxql.Planner, querystring string) []*influxql.Row {
+	e, err := p.Plan(MustParseSelectStatement(querystring))
+	if err != nil {
+		panic(err.Error())
+	}
+	ch, err := e.Execute()
+	if err != nil {
+		panic(err.Error())
+	}
+
+	var rs []*influxql.Row
+	for row := range ch {
+		rs = append(rs, row)
+	}
+	return rs
+}
+
 // MustTimeRangeAndInterval returns the time range & interval of the query.
 // Set max to 2000-01-01 if zero. Panic on error.
 func MustTimeRangeAndInterval(stmt *influxql.SelectStatement, defaultMax string) (time.Time, time.Time, time.Duration) {
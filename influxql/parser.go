@@ -75,6 +75,8 @@ func (p *Parser) ParseStatement() (Statement, error) {
 		return p.parseSelectStatement(targetNotRequired)
 	case DELETE:
 		return p.parseDeleteStatement()
+	case EXPLAIN:
+		return p.parseExplainStatement()
 	case SHOW:
 		return p.parseShowStatement()
 	case CREATE:
@@ -97,8 +99,16 @@ func (p *Parser) ParseStatement() (Statement, error) {
 func (p *Parser) parseShowStatement() (Statement, error) {
 	tok, pos, lit := p.scanIgnoreWhitespace()
 	switch tok {
+	case CLUSTER:
+		return p.parseShowClusterStatement()
 	case CONTINUOUS:
-		return p.parseShowContinuousQueriesStatement()
+		tok, pos, lit := p.scanIgnoreWhitespace()
+		if tok == QUERIES {
+			return p.parseShowContinuousQueriesStatement()
+		} else if tok == QUERY {
+			return p.parseShowContinuousQueryStatsStatement()
+		}
+		return nil, newParseError(tokstr(tok, lit), []string{"QUERIES", "QUERY"}, pos)
 	case DATABASES:
 		return p.parseShowDatabasesStatement()
 	case FIELD:
@@ -107,6 +117,12 @@ func (p *Parser) parseShowStatement() (Statement, error) {
 			return p.parseShowFieldKeysStatement()
 		}
 		return nil, newParseError(tokstr(tok, lit), []string{"KEYS", "VALUES"}, pos)
+	case MEASUREMENT:
+		if tok, _, _ := p.scanIgnoreWhitespace(); tok == SIZES {
+			return p.parseShowMeasurementSizesStatement()
+		}
+		p.unscan()
+		return p.parseShowMeasurementCardinalityStatement()
 	case MEASUREMENTS:
 		return p.parseShowMeasurementsStatement()
 	case RETENTION:
@@ -116,7 +132,16 @@ func (p *Parser) parseShowStatement() (Statement, error) {
 		}
 		return nil, newParseError(tokstr(tok, lit), []string{"POLICIES"}, pos)
 	case SERIES:
+		tok, _, _ := p.scanIgnoreWhitespace()
+		p.unscan()
+		if tok == EXACT || tok == CARDINALITY {
+			return p.parseShowSeriesCardinalityStatement()
+		}
 		return p.parseShowSeriesStatement()
+	case SERVERS:
+		return p.parseShowServersStatement()
+	case STATS:
+		return p.parseShowStatsStatement()
 	case TAG:
 		tok, pos, lit := p.scanIgnoreWhitespace()
 		if tok == KEYS {
@@ -129,7 +154,7 @@ func (p *Parser) parseShowStatement() (Statement, error) {
 		return p.parseShowUsersStatement()
 	}
 
-	return nil, newParseError(tokstr(tok, lit), []string{"CONTINUOUS", "DATABASES", "FIELD", "MEASUREMENTS", "RETENTION", "SERIES", "TAG", "USERS"}, pos)
+	return nil, newParseError(tokstr(tok, lit), []string{"CLUSTER", "CONTINUOUS", "DATABASES", "FIELD", "MEASUREMENT", "MEASUREMENTS", "RETENTION", "SERIES", "SERVERS", "STATS", "TAG", "USERS"}, pos)
 }
 
 // parseCreateStatement parses a string and returns a create statement.
@@ -170,6 +195,8 @@ func (p *Parser) parseDropStatement() (Statement, error) {
 		return p.parseDropRetentionPolicyStatement()
 	} else if tok == USER {
 		return p.parseDropUserStatement()
+	} else if tok == SHARD {
+		return p.parseDropShardStatement()
 	}
 
 	return nil, newParseError(tokstr(tok, lit), []string{"SERIES", "CONTINUOUS"}, pos)
@@ -220,7 +247,7 @@ func (p *Parser) parseCreateRetentionPolicyStatement() (*CreateRetentionPolicySt
 	}
 
 	// Parse duration value
-	d, err := p.parseDuration()
+	d, err := p.parseRetentionPolicyDuration()
 	if err != nil {
 		return nil, err
 	}
@@ -238,6 +265,28 @@ func (p *Parser) parseCreateRetentionPolicyStatement() (*CreateRetentionPolicySt
 	}
 	stmt.Replication = n
 
+	// Parse optional SHARD DURATION.
+	if tok, _, _ = p.scanIgnoreWhitespace(); tok == SHARD {
+		d, err := p.parseShardGroupDuration()
+		if err != nil {
+			return nil, err
+		}
+		stmt.ShardGroupDuration = d
+	} else {
+		p.unscan()
+	}
+
+	// Parse optional COMPRESSION.
+	if tok, _, _ = p.scanIgnoreWhitespace(); tok == COMPRESSION {
+		ident, err := p.parseIdent()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Compression = ident
+	} else {
+		p.unscan()
+	}
+
 	// Parse optional DEFAULT token.
 	if tok, pos, lit = p.scanIgnoreWhitespace(); tok == DEFAULT {
 		stmt.Default = true
@@ -272,14 +321,14 @@ func (p *Parser) parseAlterRetentionPolicyStatement() (*AlterRetentionPolicyStat
 	}
 	stmt.Database = ident
 
-	// Loop through option tokens (DURATION, REPLICATION, DEFAULT, etc.).
-	maxNumOptions := 3
+	// Loop through option tokens (DURATION, REPLICATION, SHARD DURATION, COMPRESSION, DEFAULT, etc.).
+	maxNumOptions := 5
 Loop:
 	for i := 0; i < maxNumOptions; i++ {
 		tok, pos, lit := p.scanIgnoreWhitespace()
 		switch tok {
 		case DURATION:
-			d, err := p.parseDuration()
+			d, err := p.parseRetentionPolicyDuration()
 			if err != nil {
 				return nil, err
 			}
@@ -290,11 +339,23 @@ Loop:
 				return nil, err
 			}
 			stmt.Replication = &n
+		case SHARD:
+			d, err := p.parseShardGroupDuration()
+			if err != nil {
+				return nil, err
+			}
+			stmt.ShardGroupDuration = &d
+		case COMPRESSION:
+			ident, err := p.parseIdent()
+			if err != nil {
+				return nil, err
+			}
+			stmt.Compression = &ident
 		case DEFAULT:
 			stmt.Default = true
 		default:
 			if i < 1 {
-				return nil, newParseError(tokstr(tok, lit), []string{"DURATION", "RETENTION", "DEFAULT"}, pos)
+				return nil, newParseError(tokstr(tok, lit), []string{"DURATION", "REPLICATION", "SHARD", "COMPRESSION", "DEFAULT"}, pos)
 			}
 			p.unscan()
 			break Loop
@@ -304,6 +365,25 @@ Loop:
 	return stmt, nil
 }
 
+// parseRetentionPolicyDuration parses a duration literal or INF.
+// An infinite duration is returned as zero.
+func (p *Parser) parseRetentionPolicyDuration() (time.Duration, error) {
+	if tok, _, _ := p.scanIgnoreWhitespace(); tok == INF {
+		return 0, nil
+	}
+	p.unscan()
+	return p.parseDuration()
+}
+
+// parseShardGroupDuration parses the DURATION token and duration of a SHARD DURATION option.
+// This function assumes the SHARD token has already been consumed.
+func (p *Parser) parseShardGroupDuration() (time.Duration, error) {
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != DURATION {
+		return 0, newParseError(tokstr(tok, lit), []string{"DURATION"}, pos)
+	}
+	return p.parseDuration()
+}
+
 // parseInt parses a string and returns an integer literal.
 func (p *Parser) parseInt(min, max int) (int, error) {
 	tok, pos, lit := p.scanIgnoreWhitespace()
@@ -543,9 +623,41 @@ func (p *Parser) parseSelectStatement(tr targetRequirement) (*SelectStatement, e
 		return nil, err
 	}
 
+	// Parse series limit: "SLIMIT <n>".
+	if stmt.SLimit, err = p.parseOptionalTokenAndInt(SLIMIT); err != nil {
+		return nil, err
+	}
+
+	// Parse series offset: "SOFFSET <n>".
+	if stmt.SOffset, err = p.parseOptionalTokenAndInt(SOFFSET); err != nil {
+		return nil, err
+	}
+
+	// Parse time zone: "TZ('<name>')".
+	if stmt.Location, err = p.parseLocation(); err != nil {
+		return nil, err
+	}
+
 	return stmt, nil
 }
 
+// parseExplainStatement parses a string and returns an ExplainStatement.
+// This function assumes the EXPLAIN token has already been consumed.
+func (p *Parser) parseExplainStatement() (*ExplainStatement, error) {
+	// Parse the required SELECT token.
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != SELECT {
+		return nil, newParseError(tokstr(tok, lit), []string{"SELECT"}, pos)
+	}
+
+	// Parse the select statement to be explained.
+	stmt, err := p.parseSelectStatement(targetNotRequired)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ExplainStatement{Statement: stmt}, nil
+}
+
 // targetRequirement specifies whether or not a target clause is required.
 type targetRequirement int
 
@@ -648,9 +760,89 @@ func (p *Parser) parseShowSeriesStatement() (*ShowSeriesStatement, error) {
 		return nil, err
 	}
 
+	// Parse series limit: "SLIMIT <n>".
+	if stmt.SLimit, err = p.parseOptionalTokenAndInt(SLIMIT); err != nil {
+		return nil, err
+	}
+
+	// Parse series offset: "SOFFSET <n>".
+	if stmt.SOffset, err = p.parseOptionalTokenAndInt(SOFFSET); err != nil {
+		return nil, err
+	}
+
+	return stmt, nil
+}
+
+// parseShowSeriesCardinalityStatement parses a string and returns a ShowSeriesCardinalityStatement.
+// This function assumes the "SHOW SERIES" tokens have already been consumed.
+func (p *Parser) parseShowSeriesCardinalityStatement() (*ShowSeriesCardinalityStatement, error) {
+	stmt := &ShowSeriesCardinalityStatement{}
+	var err error
+
+	// Parse "[EXACT] CARDINALITY".
+	if stmt.Exact, err = p.parseCardinality(); err != nil {
+		return nil, err
+	}
+
+	// Parse optional FROM.
+	if tok, _, _ := p.scanIgnoreWhitespace(); tok == FROM {
+		if stmt.Source, err = p.parseSource(); err != nil {
+			return nil, err
+		}
+	} else {
+		p.unscan()
+	}
+
+	// Parse condition: "WHERE EXPR".
+	if stmt.Condition, err = p.parseCondition(); err != nil {
+		return nil, err
+	}
+
+	return stmt, nil
+}
+
+// parseShowMeasurementCardinalityStatement parses a string and returns a ShowMeasurementCardinalityStatement.
+// This function assumes the "SHOW MEASUREMENT" tokens have already been consumed.
+func (p *Parser) parseShowMeasurementCardinalityStatement() (*ShowMeasurementCardinalityStatement, error) {
+	stmt := &ShowMeasurementCardinalityStatement{}
+	var err error
+
+	// Parse "[EXACT] CARDINALITY".
+	if stmt.Exact, err = p.parseCardinality(); err != nil {
+		return nil, err
+	}
+
+	// Parse condition: "WHERE EXPR".
+	if stmt.Condition, err = p.parseCondition(); err != nil {
+		return nil, err
+	}
+
+	return stmt, nil
+}
+
+// parseShowMeasurementSizesStatement parses a string and returns a ShowMeasurementSizesStatement.
+// This function assumes the "SHOW MEASUREMENT SIZES" tokens have already been consumed.
+func (p *Parser) parseShowMeasurementSizesStatement() (*ShowMeasurementSizesStatement, error) {
+	stmt := &ShowMeasurementSizesStatement{}
 	return stmt, nil
 }
 
+// parseCardinality parses the "[EXACT] CARDINALITY" tokens of a cardinality
+// statement. Returns true if the EXACT token was found.
+func (p *Parser) parseCardinality() (bool, error) {
+	tok, pos, lit := p.scanIgnoreWhitespace()
+	if tok == CARDINALITY {
+		return false, nil
+	} else if tok != EXACT {
+		return false, newParseError(tokstr(tok, lit), []string{"EXACT", "CARDINALITY"}, pos)
+	}
+
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != CARDINALITY {
+		return false, newParseError(tokstr(tok, lit), []string{"CARDINALITY"}, pos)
+	}
+	return true, nil
+}
+
 // parseShowMeasurementsStatement parses a string and returns a ShowSeriesStatement.
 // This function assumes the "SHOW MEASUREMENTS" tokens have already been consumed.
 func (p *Parser) parseShowMeasurementsStatement() (*ShowMeasurementsStatement, error) {
@@ -876,18 +1068,46 @@ func (p *Parser) parseDropSeriesStatement() (*DropSeriesStatement, error) {
 }
 
 // parseShowContinuousQueriesStatement parses a string and returns a ShowContinuousQueriesStatement.
-// This function assumes the "SHOW CONTINUOUS" tokens have already been consumed.
+// This function assumes the "SHOW CONTINUOUS QUERIES" tokens have already been consumed.
 func (p *Parser) parseShowContinuousQueriesStatement() (*ShowContinuousQueriesStatement, error) {
 	stmt := &ShowContinuousQueriesStatement{}
+	return stmt, nil
+}
 
-	// Expect a "QUERIES" token.
-	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != QUERIES {
-		return nil, newParseError(tokstr(tok, lit), []string{"QUERIES"}, pos)
+// parseShowContinuousQueryStatsStatement parses a string and returns a ShowContinuousQueryStatsStatement.
+// This function assumes the "SHOW CONTINUOUS QUERY" tokens have already been consumed.
+func (p *Parser) parseShowContinuousQueryStatsStatement() (*ShowContinuousQueryStatsStatement, error) {
+	stmt := &ShowContinuousQueryStatsStatement{}
+
+	// Expect a "STATS" token.
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != STATS {
+		return nil, newParseError(tokstr(tok, lit), []string{"STATS"}, pos)
 	}
 
 	return stmt, nil
 }
 
+// parseShowClusterStatement parses a string and returns a ShowClusterStatement.
+// This function assumes the "SHOW CLUSTER" tokens have already been consumed.
+func (p *Parser) parseShowClusterStatement() (*ShowClusterStatement, error) {
+	stmt := &ShowClusterStatement{}
+	return stmt, nil
+}
+
+// parseShowServersStatement parses a string and returns a ShowServersStatement.
+// This function assumes the "SHOW SERVERS" tokens have already been consumed.
+func (p *Parser) parseShowServersStatement() (*ShowServersStatement, error) {
+	stmt := &ShowServersStatement{}
+	return stmt, nil
+}
+
+// parseShowStatsStatement parses a string and returns a ShowStatsStatement.
+// This function assumes the "SHOW STATS" tokens have already been consumed.
+func (p *Parser) parseShowStatsStatement() (*ShowStatsStatement, error) {
+	stmt := &ShowStatsStatement{}
+	return stmt, nil
+}
+
 // parseShowDatabasesStatement parses a string and returns a ShowDatabasesStatement.
 // This function assumes the "SHOW DATABASE" tokens have already been consumed.
 func (p *Parser) parseShowDatabasesStatement() (*ShowDatabasesStatement, error) {
@@ -923,6 +1143,31 @@ func (p *Parser) parseCreateContinuousQueryStatement() (*CreateContinuousQuerySt
 	}
 	stmt.Database = ident
 
+	// Parse optional RESAMPLE clause.
+	var forPos Pos
+	if tok, _, _ := p.scanIgnoreWhitespace(); tok == RESAMPLE {
+		if tok, pos, lit := p.scanIgnoreWhitespace(); tok == EVERY {
+			if stmt.ResampleEvery, err = p.parseDuration(); err != nil {
+				return nil, err
+			}
+		} else if tok != FOR {
+			return nil, newParseError(tokstr(tok, lit), []string{"EVERY", "FOR"}, pos)
+		} else {
+			p.unscan()
+		}
+
+		if tok, pos, _ := p.scanIgnoreWhitespace(); tok == FOR {
+			forPos = pos
+			if stmt.ResampleFor, err = p.parseDuration(); err != nil {
+				return nil, err
+			}
+		} else {
+			p.unscan()
+		}
+	} else {
+		p.unscan()
+	}
+
 	// Expect a "BEGIN SELECT" tokens.
 	if err := p.parseTokens([]Token{BEGIN, SELECT}); err != nil {
 		return nil, err
@@ -949,6 +1194,14 @@ func (p *Parser) parseCreateContinuousQueryStatement() (*CreateContinuousQuerySt
 			}
 			return nil, newParseError(tokstr(tok, lit), expected, pos)
 		}
+
+		// The resample window must cover at least one interval.
+		if stmt.ResampleFor > 0 && stmt.ResampleFor < d {
+			return nil, &ParseError{
+				Message: fmt.Sprintf("FOR duration must be at least the GROUP BY time interval: %s", FormatDuration(d)),
+				Pos:     forPos,
+			}
+		}
 	}
 
 	// Expect a "END" keyword.
@@ -1119,27 +1372,42 @@ func (p *Parser) parseDropContinuousQueryStatement() (*DropContinuousQueryStatem
 	return stmt, nil
 }
 
+// parseDropShardStatement parses a string and returns a DropShardStatement.
+// This function assumes the "DROP SHARD" tokens have already been consumed.
+func (p *Parser) parseDropShardStatement() (*DropShardStatement, error) {
+	stmt := &DropShardStatement{}
+
+	// Read the id of the shard to drop.
+	tok, pos, lit := p.scanIgnoreWhitespace()
+	if tok != NUMBER {
+		return nil, newParseError(tokstr(tok, lit), []string{"number"}, pos)
+	}
+	id, err := strconv.ParseUint(lit, 10, 64)
+	if err != nil {
+		return nil, &ParseError{Message: "invalid shard id: " + lit, Pos: pos}
+	}
+	stmt.ID = id
+
+	return stmt, nil
+}
+
 // parseFields parses a list of one or more fields.
 func (p *Parser) parseFields() (Fields, error) {
 	var fields Fields
 
-	// Check for "*" (i.e., "all fields")
-	if tok, _, _ := p.scanIgnoreWhitespace(); tok == MUL {
-		fields = append(fields, &Field{&Wildcard{}, ""})
-		return fields, nil
-	}
-	p.unscan()
-
 	for {
-		// Parse the field.
-		f, err := p.parseField()
-		if err != nil {
-			return nil, err
+		// Check for "*" (i.e., "all fields") or parse the field.
+		if tok, _, _ := p.scanIgnoreWhitespace(); tok == MUL {
+			fields = append(fields, &Field{&Wildcard{}, ""})
+		} else {
+			p.unscan()
+			f, err := p.parseField()
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, f)
 		}
 
-		// Add new field.
-		fields = append(fields, f)
-
 		// If there's not a comma next then stop parsing fields.
 		if tok, _, _ := p.scan(); tok != COMMA {
 			p.unscan()
@@ -1290,6 +1558,13 @@ func (p *Parser) parseDimensions() (Dimensions, error) {
 
 // parseDimension parses a single dimension.
 func (p *Parser) parseDimension() (*Dimension, error) {
+	// Check for "*" (i.e., "all tags").
+	if tok, _, _ := p.scanIgnoreWhitespace(); tok == MUL {
+		p.consumeWhitespace()
+		return &Dimension{Expr: &Wildcard{}}, nil
+	}
+	p.unscan()
+
 	// Parse the expression first.
 	expr, err := p.ParseExpr()
 	if err != nil {
@@ -1302,6 +1577,38 @@ func (p *Parser) parseDimension() (*Dimension, error) {
 	return &Dimension{Expr: expr}, nil
 }
 
+// parseLocation parses an optional "TZ('<name>')" clause.
+// TZ is not a keyword so it is matched as a case-insensitive identifier.
+func (p *Parser) parseLocation() (*time.Location, error) {
+	// Check if the clause exists.
+	if tok, _, lit := p.scanIgnoreWhitespace(); tok != IDENT || strings.ToLower(lit) != "tz" {
+		p.unscan()
+		return nil, nil
+	}
+
+	// Expect a left paren immediately after TZ.
+	if tok, pos, lit := p.scan(); tok != LPAREN {
+		return nil, newParseError(tokstr(tok, lit), []string{"("}, pos)
+	}
+
+	// Scan the time zone name and load its location.
+	tok, pos, lit := p.scanIgnoreWhitespace()
+	if tok != STRING {
+		return nil, newParseError(tokstr(tok, lit), []string{"string"}, pos)
+	}
+	loc, err := time.LoadLocation(lit)
+	if err != nil {
+		return nil, &ParseError{Message: "unknown time zone: " + lit, Pos: pos}
+	}
+
+	// Expect a closing right paren.
+	if tok, pos, lit := p.scanIgnoreWhitespace(); tok != RPAREN {
+		return nil, newParseError(tokstr(tok, lit), []string{")"}, pos)
+	}
+
+	return loc, nil
+}
+
 // parseOptionalTokenAndInt parses the specified token followed
 // by an int, if it exists.
 func (p *Parser) parseOptionalTokenAndInt(t Token) (int, error) {
@@ -1389,7 +1696,7 @@ func (p *Parser) parseSortFields() (SortFields, error) {
 
 // parseSortField parses one field of an ORDER BY clause.
 func (p *Parser) parseSortField() (*SortField, error) {
-	field := &SortField{}
+	field := &SortField{Ascending: true}
 
 	// Next token should be ASC, DESC, or IDENT | STRING.
 	tok, pos, lit := p.scanIgnoreWhitespace()
@@ -1428,8 +1735,15 @@ func (p *Parser) ParseExpr() (Expr, error) {
 			return expr, nil
 		}
 
-		// Otherwise parse the next unary expression.
-		rhs, err := p.parseUnaryExpr()
+		// Otherwise parse the next unary expression. Regex operators are
+		// always followed by a regex.
+		var rhs Expr
+		var err error
+		if op == EQREGEX || op == NEQREGEX {
+			rhs, err = p.parseRegex()
+		} else {
+			rhs, err = p.parseUnaryExpr()
+		}
 		if err != nil {
 			return nil, err
 		}
@@ -1447,6 +1761,22 @@ func (p *Parser) ParseExpr() (Expr, error) {
 	}
 }
 
+// parseRegex parses a regular expression delimited by slashes.
+func (p *Parser) parseRegex() (*RegexLiteral, error) {
+	tok, pos, lit := p.s.ScanRegex()
+	if tok == BADREGEX {
+		return nil, &ParseError{Message: "unterminated regex", Pos: pos}
+	} else if tok != REGEX {
+		return nil, newParseError(tokstr(tok, lit), []string{"regex"}, pos)
+	}
+
+	re, err := regexp.Compile(lit)
+	if err != nil {
+		return nil, &ParseError{Message: err.Error(), Pos: pos}
+	}
+	return &RegexLiteral{Val: re}, nil
+}
+
 // parseUnaryExpr parses an non-binary expression.
 func (p *Parser) parseUnaryExpr() (Expr, error) {
 	// If the first token is a LPAREN then parse it as its own grouped expression.
@@ -1476,6 +1806,18 @@ func (p *Parser) parseUnaryExpr() (Expr, error) {
 		}
 		p.unscan()
 		return &VarRef{Val: lit}, nil
+	case DISTINCT:
+		// Parse "DISTINCT(field)" as a function call and "DISTINCT field" as
+		// shorthand for the same call.
+		if tok0, _, _ := p.scanIgnoreWhitespace(); tok0 == LPAREN {
+			return p.parseCall("distinct")
+		}
+		p.unscan()
+		ident, err := p.parseIdent()
+		if err != nil {
+			return nil, err
+		}
+		return &Call{Name: "distinct", Args: []Expr{&VarRef{Val: ident}}}, nil
 	case STRING:
 		// If literal looks like a date time then parse it as a time literal.
 		if isDateTimeString(lit) {
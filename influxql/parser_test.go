@@ -2,6 +2,7 @@ package influxql_test
 
 import (
 	"reflect"
+	"regexp"
 	"strings"
 	"testing"
 	"time"
@@ -56,6 +57,22 @@ func TestParser_ParseStatement(t *testing.T) {
 			},
 		},
 
+		// SELECT * with other fields and a wildcard dimension
+		{
+			s: `SELECT *, field1 FROM myseries GROUP BY time(10m), *`,
+			stmt: &influxql.SelectStatement{
+				Fields: []*influxql.Field{
+					{Expr: &influxql.Wildcard{}},
+					{Expr: &influxql.VarRef{Val: "field1"}},
+				},
+				Source: &influxql.Measurement{Name: "myseries"},
+				Dimensions: []*influxql.Dimension{
+					{Expr: &influxql.Call{Name: "time", Args: []influxql.Expr{&influxql.DurationLiteral{Val: 10 * time.Minute}}}},
+					{Expr: &influxql.Wildcard{}},
+				},
+			},
+		},
+
 		// SELECT statement
 		{
 			s: `SELECT field1, field2 ,field3 AS field_x FROM myseries WHERE host = 'hosta.influxdb.org' GROUP BY 10h ORDER BY ASC LIMIT 20 OFFSET 10;`,
@@ -82,6 +99,35 @@ func TestParser_ParseStatement(t *testing.T) {
 			},
 		},
 
+		// SELECT statement with SLIMIT and SOFFSET
+		{
+			s: `SELECT field1 FROM myseries GROUP BY host LIMIT 10 OFFSET 20 SLIMIT 5 SOFFSET 15`,
+			stmt: &influxql.SelectStatement{
+				Fields: []*influxql.Field{{Expr: &influxql.VarRef{Val: "field1"}}},
+				Source: &influxql.Measurement{Name: "myseries"},
+				Dimensions: []*influxql.Dimension{
+					{Expr: &influxql.VarRef{Val: "host"}},
+				},
+				Limit:   10,
+				Offset:  20,
+				SLimit:  5,
+				SOffset: 15,
+			},
+		},
+
+		// SELECT statement with TZ
+		{
+			s: `SELECT sum(field1) FROM myseries GROUP BY time(1d) tz('America/New_York')`,
+			stmt: &influxql.SelectStatement{
+				Fields: []*influxql.Field{{Expr: &influxql.Call{Name: "sum", Args: []influxql.Expr{&influxql.VarRef{Val: "field1"}}}}},
+				Source: &influxql.Measurement{Name: "myseries"},
+				Dimensions: []*influxql.Dimension{
+					{Expr: &influxql.Call{Name: "time", Args: []influxql.Expr{&influxql.DurationLiteral{Val: 24 * time.Hour}}}},
+				},
+				Location: mustLoadLocation("America/New_York"),
+			},
+		},
+
 		// SELECT statement with JOIN
 		{
 			s: `SELECT field1 FROM join(aa,"bb", cc) JOIN cc`,
@@ -120,6 +166,24 @@ func TestParser_ParseStatement(t *testing.T) {
 			},
 		},
 
+		// SELECT DISTINCT statement
+		{
+			s: `SELECT DISTINCT value FROM cpu`,
+			stmt: &influxql.SelectStatement{
+				Fields: []*influxql.Field{{Expr: &influxql.Call{Name: "distinct", Args: []influxql.Expr{&influxql.VarRef{Val: "value"}}}}},
+				Source: &influxql.Measurement{Name: "cpu"},
+			},
+		},
+
+		// SELECT statement with count(distinct())
+		{
+			s: `SELECT count(distinct(value)) FROM cpu`,
+			stmt: &influxql.SelectStatement{
+				Fields: []*influxql.Field{{Expr: &influxql.Call{Name: "count", Args: []influxql.Expr{&influxql.Call{Name: "distinct", Args: []influxql.Expr{&influxql.VarRef{Val: "value"}}}}}}},
+				Source: &influxql.Measurement{Name: "cpu"},
+			},
+		},
+
 		// SELECT statement with multiple ORDER BY fields
 		{
 			s: `SELECT field1 FROM myseries ORDER BY ASC, field1, field2 DESC LIMIT 10`,
@@ -128,7 +192,7 @@ func TestParser_ParseStatement(t *testing.T) {
 				Source: &influxql.Measurement{Name: "myseries"},
 				SortFields: []*influxql.SortField{
 					{Ascending: true},
-					{Name: "field1"},
+					{Name: "field1", Ascending: true},
 					{Name: "field2"},
 				},
 				Limit: 10,
@@ -148,6 +212,22 @@ func TestParser_ParseStatement(t *testing.T) {
 			},
 		},
 
+		// EXPLAIN statement
+		{
+			s: `EXPLAIN SELECT value FROM cpu WHERE host = 'serverA'`,
+			stmt: &influxql.ExplainStatement{
+				Statement: &influxql.SelectStatement{
+					Fields: []*influxql.Field{{Expr: &influxql.VarRef{Val: "value"}}},
+					Source: &influxql.Measurement{Name: "cpu"},
+					Condition: &influxql.BinaryExpr{
+						Op:  influxql.EQ,
+						LHS: &influxql.VarRef{Val: "host"},
+						RHS: &influxql.StringLiteral{Val: "serverA"},
+					},
+				},
+			},
+		},
+
 		// SHOW DATABASES
 		{
 			s:    `SHOW DATABASES`,
@@ -171,13 +251,25 @@ func TestParser_ParseStatement(t *testing.T) {
 				},
 				SortFields: []*influxql.SortField{
 					{Ascending: true},
-					{Name: "field1"},
+					{Name: "field1", Ascending: true},
 					{Name: "field2"},
 				},
 				Limit: 10,
 			},
 		},
 
+		// SHOW SERIES with LIMIT, OFFSET, SLIMIT and SOFFSET
+		{
+			s: `SHOW SERIES FROM cpu LIMIT 10 OFFSET 20 SLIMIT 5 SOFFSET 15`,
+			stmt: &influxql.ShowSeriesStatement{
+				Source:  &influxql.Measurement{Name: "cpu"},
+				Limit:   10,
+				Offset:  20,
+				SLimit:  5,
+				SOffset: 15,
+			},
+		},
+
 		// SHOW MEASUREMENTS WHERE with ORDER BY and LIMIT
 		{
 			s: `SHOW MEASUREMENTS WHERE region = 'uswest' ORDER BY ASC, field1, field2 DESC LIMIT 10`,
@@ -189,13 +281,58 @@ func TestParser_ParseStatement(t *testing.T) {
 				},
 				SortFields: []*influxql.SortField{
 					{Ascending: true},
-					{Name: "field1"},
+					{Name: "field1", Ascending: true},
 					{Name: "field2"},
 				},
 				Limit: 10,
 			},
 		},
 
+		// SHOW SERIES CARDINALITY statement
+		{
+			s:    `SHOW SERIES CARDINALITY`,
+			stmt: &influxql.ShowSeriesCardinalityStatement{},
+		},
+
+		// SHOW SERIES EXACT CARDINALITY with FROM and WHERE
+		{
+			s: `SHOW SERIES EXACT CARDINALITY FROM cpu WHERE region = 'uswest'`,
+			stmt: &influxql.ShowSeriesCardinalityStatement{
+				Exact:  true,
+				Source: &influxql.Measurement{Name: "cpu"},
+				Condition: &influxql.BinaryExpr{
+					Op:  influxql.EQ,
+					LHS: &influxql.VarRef{Val: "region"},
+					RHS: &influxql.StringLiteral{Val: "uswest"},
+				},
+			},
+		},
+
+		// SHOW MEASUREMENT CARDINALITY statement
+		{
+			s:    `SHOW MEASUREMENT CARDINALITY`,
+			stmt: &influxql.ShowMeasurementCardinalityStatement{},
+		},
+
+		// SHOW MEASUREMENT SIZES statement
+		{
+			s:    `SHOW MEASUREMENT SIZES`,
+			stmt: &influxql.ShowMeasurementSizesStatement{},
+		},
+
+		// SHOW MEASUREMENT EXACT CARDINALITY with WHERE
+		{
+			s: `SHOW MEASUREMENT EXACT CARDINALITY WHERE region = 'uswest'`,
+			stmt: &influxql.ShowMeasurementCardinalityStatement{
+				Exact: true,
+				Condition: &influxql.BinaryExpr{
+					Op:  influxql.EQ,
+					LHS: &influxql.VarRef{Val: "region"},
+					RHS: &influxql.StringLiteral{Val: "uswest"},
+				},
+			},
+		},
+
 		// SHOW RETENTION POLICIES
 		{
 			s: `SHOW RETENTION POLICIES mydb`,
@@ -224,7 +361,7 @@ func TestParser_ParseStatement(t *testing.T) {
 				},
 				SortFields: []*influxql.SortField{
 					{Ascending: true},
-					{Name: "field1"},
+					{Name: "field1", Ascending: true},
 					{Name: "field2"},
 				},
 				Limit: 10,
@@ -244,7 +381,7 @@ func TestParser_ParseStatement(t *testing.T) {
 				},
 				SortFields: []*influxql.SortField{
 					{Ascending: true},
-					{Name: "field1"},
+					{Name: "field1", Ascending: true},
 					{Name: "field2"},
 				},
 				Limit: 10,
@@ -310,7 +447,7 @@ func TestParser_ParseStatement(t *testing.T) {
 				},
 				SortFields: []*influxql.SortField{
 					{Ascending: true},
-					{Name: "field1"},
+					{Name: "field1", Ascending: true},
 					{Name: "field2"},
 				},
 				Limit: 10,
@@ -323,12 +460,36 @@ func TestParser_ParseStatement(t *testing.T) {
 			stmt: &influxql.DropSeriesStatement{Name: "myseries"},
 		},
 
+		// SHOW CLUSTER statement
+		{
+			s:    `SHOW CLUSTER`,
+			stmt: &influxql.ShowClusterStatement{},
+		},
+
+		// SHOW SERVERS statement
+		{
+			s:    `SHOW SERVERS`,
+			stmt: &influxql.ShowServersStatement{},
+		},
+
+		// SHOW STATS statement
+		{
+			s:    `SHOW STATS`,
+			stmt: &influxql.ShowStatsStatement{},
+		},
+
 		// SHOW CONTINUOUS QUERIES statement
 		{
 			s:    `SHOW CONTINUOUS QUERIES`,
 			stmt: &influxql.ShowContinuousQueriesStatement{},
 		},
 
+		// SHOW CONTINUOUS QUERY STATS statement
+		{
+			s:    `SHOW CONTINUOUS QUERY STATS`,
+			stmt: &influxql.ShowContinuousQueryStatsStatement{},
+		},
+
 		// CREATE CONTINUOUS QUERY ... INTO <measurement>
 		{
 			s: `CREATE CONTINUOUS QUERY myquery ON testdb BEGIN SELECT count() INTO measure1 FROM myseries GROUP BY time(5m) END`,
@@ -353,6 +514,57 @@ func TestParser_ParseStatement(t *testing.T) {
 			},
 		},
 
+		// CREATE CONTINUOUS QUERY ... RESAMPLE EVERY ... FOR ...
+		{
+			s: `CREATE CONTINUOUS QUERY myquery ON testdb RESAMPLE EVERY 1m FOR 1h BEGIN SELECT count() INTO measure1 FROM myseries GROUP BY time(5m) END`,
+			stmt: &influxql.CreateContinuousQueryStatement{
+				Name:          "myquery",
+				Database:      "testdb",
+				ResampleEvery: time.Minute,
+				ResampleFor:   time.Hour,
+				Source: &influxql.SelectStatement{
+					Fields: []*influxql.Field{{Expr: &influxql.Call{Name: "count"}}},
+					Target: &influxql.Target{Measurement: "measure1"},
+					Source: &influxql.Measurement{Name: "myseries"},
+					Dimensions: []*influxql.Dimension{
+						&influxql.Dimension{
+							Expr: &influxql.Call{
+								Name: "time",
+								Args: []influxql.Expr{
+									&influxql.DurationLiteral{Val: 5 * time.Minute},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+
+		// CREATE CONTINUOUS QUERY ... RESAMPLE FOR ...
+		{
+			s: `CREATE CONTINUOUS QUERY myquery ON testdb RESAMPLE FOR 10m BEGIN SELECT count() INTO measure1 FROM myseries GROUP BY time(5m) END`,
+			stmt: &influxql.CreateContinuousQueryStatement{
+				Name:        "myquery",
+				Database:    "testdb",
+				ResampleFor: 10 * time.Minute,
+				Source: &influxql.SelectStatement{
+					Fields: []*influxql.Field{{Expr: &influxql.Call{Name: "count"}}},
+					Target: &influxql.Target{Measurement: "measure1"},
+					Source: &influxql.Measurement{Name: "myseries"},
+					Dimensions: []*influxql.Dimension{
+						&influxql.Dimension{
+							Expr: &influxql.Call{
+								Name: "time",
+								Args: []influxql.Expr{
+									&influxql.DurationLiteral{Val: 5 * time.Minute},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+
 		// CREATE CONTINUOUS QUERY ... INTO <retention-policy>.<measurement>
 		{
 			s: `CREATE CONTINUOUS QUERY myquery ON testdb BEGIN SELECT count() INTO "1h.policy1"."cpu.load" FROM myseries GROUP BY time(5m) END`,
@@ -412,6 +624,12 @@ func TestParser_ParseStatement(t *testing.T) {
 			stmt: &influxql.DropContinuousQueryStatement{Name: "myquery"},
 		},
 
+		// DROP SHARD statement
+		{
+			s:    `DROP SHARD 12`,
+			stmt: &influxql.DropShardStatement{ID: 12},
+		},
+
 		// DROP DATABASE statement
 		{
 			s:    `DROP DATABASE testdb`,
@@ -554,6 +772,43 @@ func TestParser_ParseStatement(t *testing.T) {
 			},
 		},
 
+		// CREATE RETENTION POLICY ... SHARD DURATION
+		{
+			s: `CREATE RETENTION POLICY policy1 ON testdb DURATION 52w REPLICATION 1 SHARD DURATION 1d DEFAULT`,
+			stmt: &influxql.CreateRetentionPolicyStatement{
+				Name:               "policy1",
+				Database:           "testdb",
+				Duration:           52 * 7 * 24 * time.Hour,
+				Replication:        1,
+				ShardGroupDuration: 24 * time.Hour,
+				Default:            true,
+			},
+		},
+
+		// CREATE RETENTION POLICY ... COMPRESSION
+		{
+			s: `CREATE RETENTION POLICY policy1 ON testdb DURATION 52w REPLICATION 1 COMPRESSION deflate DEFAULT`,
+			stmt: &influxql.CreateRetentionPolicyStatement{
+				Name:        "policy1",
+				Database:    "testdb",
+				Duration:    52 * 7 * 24 * time.Hour,
+				Replication: 1,
+				Compression: "deflate",
+				Default:     true,
+			},
+		},
+
+		// CREATE RETENTION POLICY ... DURATION INF
+		{
+			s: `CREATE RETENTION POLICY policy1 ON testdb DURATION INF REPLICATION 1`,
+			stmt: &influxql.CreateRetentionPolicyStatement{
+				Name:        "policy1",
+				Database:    "testdb",
+				Duration:    0,
+				Replication: 1,
+			},
+		},
+
 		// ALTER RETENTION POLICY
 		{
 			s:    `ALTER RETENTION POLICY policy1 ON testdb DURATION 1m REPLICATION 4 DEFAULT`,
@@ -584,6 +839,26 @@ func TestParser_ParseStatement(t *testing.T) {
 			stmt: newAlterRetentionPolicyStatement("policy1", "testdb", -1, 4, false),
 		},
 
+		// ALTER RETENTION POLICY with only SHARD DURATION
+		{
+			s: `ALTER RETENTION POLICY policy1 ON testdb SHARD DURATION 2h`,
+			stmt: &influxql.AlterRetentionPolicyStatement{
+				Name:               "policy1",
+				Database:           "testdb",
+				ShardGroupDuration: func() *time.Duration { d := 2 * time.Hour; return &d }(),
+			},
+		},
+
+		// ALTER RETENTION POLICY with only COMPRESSION
+		{
+			s: `ALTER RETENTION POLICY policy1 ON testdb COMPRESSION none`,
+			stmt: &influxql.AlterRetentionPolicyStatement{
+				Name:        "policy1",
+				Database:    "testdb",
+				Compression: func() *string { s := "none"; return &s }(),
+			},
+		},
+
 		// Errors
 		{s: ``, err: `found EOF, expected SELECT at line 1, char 1`},
 		{s: `SELECT`, err: `found EOF, expected identifier, string, number, bool at line 1, char 8`},
@@ -598,21 +873,33 @@ func TestParser_ParseStatement(t *testing.T) {
 		{s: `SELECT field1 FROM myseries OFFSET 10.5`, err: `fractional parts not allowed in OFFSET at line 1, char 36`},
 		{s: `SELECT field1 FROM myseries OFFSET 0`, err: `OFFSET must be > 0 at line 1, char 36`},
 		{s: `SELECT field1 FROM myseries ORDER`, err: `found EOF, expected BY at line 1, char 35`},
+		{s: `SELECT field1 FROM myseries SLIMIT 0`, err: `SLIMIT must be > 0 at line 1, char 36`},
+		{s: `SELECT field1 FROM myseries SOFFSET 1.5`, err: `fractional parts not allowed in SOFFSET at line 1, char 37`},
+		{s: `SELECT field1 FROM myseries TZ`, err: `found EOF, expected ( at line 1, char 32`},
+		{s: `SELECT field1 FROM myseries TZ(foo)`, err: `found foo, expected string at line 1, char 32`},
+		{s: `SELECT field1 FROM myseries TZ('Nowhere/Foo')`, err: `unknown time zone: Nowhere/Foo at line 1, char 31`},
+		{s: `SELECT field1 FROM myseries TZ('UTC'`, err: `found EOF, expected ) at line 1, char 37`},
 		{s: `SELECT field1 FROM myseries ORDER BY /`, err: `found /, expected identifier, ASC, or DESC at line 1, char 38`},
 		{s: `SELECT field1 FROM myseries ORDER BY 1`, err: `found 1, expected identifier, ASC, or DESC at line 1, char 38`},
 		{s: `SELECT field1 AS`, err: `found EOF, expected identifier at line 1, char 18`},
 		{s: `SELECT field1 FROM 12`, err: `found 12, expected identifier at line 1, char 20`},
-		{s: `SELECT field1 FROM myseries GROUP BY *`, err: `found *, expected identifier, string, number, bool at line 1, char 38`},
 		{s: `SELECT 1000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000 FROM myseries`, err: `unable to parse number at line 1, char 8`},
 		{s: `SELECT 10.5h FROM myseries`, err: `found h, expected FROM at line 1, char 12`},
+		{s: `EXPLAIN`, err: `found EOF, expected SELECT at line 1, char 9`},
+		{s: `EXPLAIN SHOW DATABASES`, err: `found SHOW, expected SELECT at line 1, char 9`},
 		{s: `DELETE`, err: `found EOF, expected FROM at line 1, char 8`},
 		{s: `DELETE FROM`, err: `found EOF, expected identifier at line 1, char 13`},
 		{s: `DELETE FROM myseries WHERE`, err: `found EOF, expected identifier, string, number, bool at line 1, char 28`},
 		{s: `DROP SERIES`, err: `found EOF, expected identifier at line 1, char 13`},
-		{s: `SHOW CONTINUOUS`, err: `found EOF, expected QUERIES at line 1, char 17`},
+		{s: `SHOW CONTINUOUS`, err: `found EOF, expected QUERIES, QUERY at line 1, char 17`},
+		{s: `SHOW CONTINUOUS QUERY`, err: `found EOF, expected STATS at line 1, char 23`},
 		{s: `SHOW RETENTION`, err: `found EOF, expected POLICIES at line 1, char 16`},
 		{s: `SHOW RETENTION POLICIES`, err: `found EOF, expected identifier at line 1, char 25`},
-		{s: `SHOW FOO`, err: `found FOO, expected CONTINUOUS, DATABASES, FIELD, MEASUREMENTS, RETENTION, SERIES, TAG, USERS at line 1, char 6`},
+		{s: `SHOW MEASUREMENT`, err: `found EOF, expected EXACT, CARDINALITY at line 1, char 18`},
+		{s: `SHOW SERIES EXACT`, err: `found EOF, expected CARDINALITY at line 1, char 19`},
+		{s: `SHOW FOO`, err: `found FOO, expected CLUSTER, CONTINUOUS, DATABASES, FIELD, MEASUREMENT, MEASUREMENTS, RETENTION, SERIES, SERVERS, STATS, TAG, USERS at line 1, char 6`},
+		{s: `CREATE CONTINUOUS QUERY myquery ON testdb RESAMPLE BEGIN`, err: `found BEGIN, expected EVERY, FOR at line 1, char 52`},
+		{s: `CREATE CONTINUOUS QUERY myquery ON testdb RESAMPLE FOR 1m BEGIN SELECT count() INTO measure1 FROM myseries GROUP BY time(5m) END`, err: `FOR duration must be at least the GROUP BY time interval: 5m at line 1, char 52`},
 		{s: `DROP CONTINUOUS`, err: `found EOF, expected QUERY at line 1, char 17`},
 		{s: `DROP CONTINUOUS QUERY`, err: `found EOF, expected identifier at line 1, char 23`},
 		{s: `DROP FOO`, err: `found FOO, expected SERIES, CONTINUOUS at line 1, char 6`},
@@ -622,6 +909,8 @@ func TestParser_ParseStatement(t *testing.T) {
 		{s: `DROP RETENTION POLICY "1h.cpu"`, err: `found EOF, expected ON at line 1, char 32`},
 		{s: `DROP RETENTION POLICY "1h.cpu" ON`, err: `found EOF, expected identifier at line 1, char 35`},
 		{s: `DROP USER`, err: `found EOF, expected identifier at line 1, char 11`},
+		{s: `DROP SHARD`, err: `found EOF, expected number at line 1, char 12`},
+		{s: `DROP SHARD 1.5`, err: `invalid shard id: 1.5 at line 1, char 12`},
 		{s: `CREATE USER testuser`, err: `found EOF, expected WITH at line 1, char 22`},
 		{s: `CREATE USER testuser WITH`, err: `found EOF, expected PASSWORD at line 1, char 27`},
 		{s: `CREATE USER testuser WITH PASSWORD`, err: `found EOF, expected string at line 1, char 36`},
@@ -652,19 +941,22 @@ func TestParser_ParseStatement(t *testing.T) {
 		{s: `CREATE RETENTION POLICY policy1 ON testdb DURATION 1h REPLICATION 3.14`, err: `number must be an integer at line 1, char 67`},
 		{s: `CREATE RETENTION POLICY policy1 ON testdb DURATION 1h REPLICATION 0`, err: `invalid value 0: must be 1 <= n <= 2147483647 at line 1, char 67`},
 		{s: `CREATE RETENTION POLICY policy1 ON testdb DURATION 1h REPLICATION bad`, err: `found bad, expected number at line 1, char 67`},
+		{s: `CREATE RETENTION POLICY policy1 ON testdb DURATION 1h REPLICATION 1 SHARD`, err: `found EOF, expected DURATION at line 1, char 75`},
+		{s: `CREATE RETENTION POLICY policy1 ON testdb DURATION 1h REPLICATION 1 SHARD DURATION`, err: `found EOF, expected duration at line 1, char 84`},
+		{s: `CREATE RETENTION POLICY policy1 ON testdb DURATION 1h REPLICATION 1 COMPRESSION`, err: `found EOF, expected identifier at line 1, char 81`},
 		{s: `ALTER`, err: `found EOF, expected RETENTION at line 1, char 7`},
 		{s: `ALTER RETENTION`, err: `found EOF, expected POLICY at line 1, char 17`},
 		{s: `ALTER RETENTION POLICY`, err: `found EOF, expected identifier at line 1, char 24`},
 		{s: `ALTER RETENTION POLICY policy1`, err: `found EOF, expected ON at line 1, char 32`},
 		{s: `ALTER RETENTION POLICY policy1 ON`, err: `found EOF, expected identifier at line 1, char 35`},
-		{s: `ALTER RETENTION POLICY policy1 ON testdb`, err: `found EOF, expected DURATION, RETENTION, DEFAULT at line 1, char 42`},
+		{s: `ALTER RETENTION POLICY policy1 ON testdb`, err: `found EOF, expected DURATION, REPLICATION, SHARD, COMPRESSION, DEFAULT at line 1, char 42`},
 	}
 
 	for i, tt := range tests {
 		stmt, err := influxql.NewParser(strings.NewReader(tt.s)).ParseStatement()
 
 		// if it's a CQ, there is a non-exported field that gets memoized during parsing that needs to be set
-		if _, ok := stmt.(*influxql.CreateContinuousQueryStatement); ok {
+		if _, ok := stmt.(*influxql.CreateContinuousQueryStatement); ok && tt.stmt != nil {
 			tt.stmt.(*influxql.CreateContinuousQueryStatement).Source.GroupByInterval()
 		}
 
@@ -789,6 +1081,27 @@ func TestParser_ParseExpr(t *testing.T) {
 			},
 		},
 
+		// Regex binary expressions.
+		{
+			s: `host =~ /^web-\d+/`,
+			expr: &influxql.BinaryExpr{
+				Op:  influxql.EQREGEX,
+				LHS: &influxql.VarRef{Val: "host"},
+				RHS: &influxql.RegexLiteral{Val: regexp.MustCompile(`^web-\d+`)},
+			},
+		},
+		{
+			s: `path !~ /^\/var\//`,
+			expr: &influxql.BinaryExpr{
+				Op:  influxql.NEQREGEX,
+				LHS: &influxql.VarRef{Val: "path"},
+				RHS: &influxql.RegexLiteral{Val: regexp.MustCompile(`^/var/`)},
+			},
+		},
+		{s: `host =~ /web`, err: `unterminated regex at line 1, char 9`},
+		{s: `host =~ 'web'`, err: `found web, expected regex at line 1, char 8`},
+		{s: `host =~ /web(/`, err: "error parsing regexp: missing closing ): `web(` at line 1, char 9"},
+
 		// Function call (empty)
 		{
 			s: `my_func()`,
@@ -974,3 +1287,12 @@ func newAlterRetentionPolicyStatement(name string, DB string, d time.Duration, r
 
 	return stmt
 }
+
+// mustLoadLocation loads a time zone by name. Panic on error.
+func mustLoadLocation(name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		panic(err)
+	}
+	return loc
+}
@@ -61,7 +61,16 @@ func (s *Scanner) Scan() (tok Token, pos Pos, lit string) {
 	case '/':
 		return DIV, pos, ""
 	case '=':
+		if ch1, _ := s.r.read(); ch1 == '~' {
+			return EQREGEX, pos, ""
+		}
+		s.r.unread()
 		return EQ, pos, ""
+	case '!':
+		if ch1, _ := s.r.read(); ch1 == '~' {
+			return NEQREGEX, pos, ""
+		}
+		s.r.unread()
 	case '>':
 		if ch1, _ := s.r.read(); ch1 == '=' {
 			return GTE, pos, ""
@@ -89,6 +98,40 @@ func (s *Scanner) Scan() (tok Token, pos Pos, lit string) {
 	return ILLEGAL, pos, string(ch0)
 }
 
+// ScanRegex consumes a regular expression delimited by slashes, skipping any
+// leading whitespace. Slashes within the expression are escaped with a
+// backslash. Regexes can't be told apart from division by Scan so the parser
+// calls ScanRegex when it expects one.
+func (s *Scanner) ScanRegex() (tok Token, pos Pos, lit string) {
+	ch0, pos := s.r.read()
+	if isWhitespace(ch0) {
+		s.scanWhitespace()
+		ch0, pos = s.r.read()
+	}
+	if ch0 != '/' {
+		s.r.unread()
+		return s.Scan()
+	}
+
+	var buf bytes.Buffer
+	for {
+		ch, _ := s.r.read()
+		if ch == eof || ch == '\n' {
+			return BADREGEX, pos, buf.String()
+		} else if ch == '/' {
+			return REGEX, pos, buf.String()
+		} else if ch == '\\' {
+			// Only escaped slashes are unescaped. Other escapes belong to the regex.
+			if ch1, _ := s.r.read(); ch1 == '/' {
+				_, _ = buf.WriteRune(ch1)
+				continue
+			}
+			s.r.unread()
+		}
+		_, _ = buf.WriteRune(ch)
+	}
+}
+
 // scanWhitespace consumes the current rune and all contiguous whitespace.
 func (s *Scanner) scanWhitespace() (tok Token, pos Pos, lit string) {
 	// Create a buffer and read the current character into it.
@@ -303,6 +346,16 @@ func (s *bufScanner) Scan() (tok Token, pos Pos, lit string) {
 	return s.curr()
 }
 
+// ScanRegex reads the next token from the scanner as a regular expression.
+// There must not be any unread tokens.
+func (s *bufScanner) ScanRegex() (tok Token, pos Pos, lit string) {
+	s.i = (s.i + 1) % len(s.buf)
+	buf := &s.buf[s.i]
+	buf.tok, buf.pos, buf.lit = s.s.ScanRegex()
+
+	return s.curr()
+}
+
 // Unscan pushes the previously token back onto the buffer.
 func (s *bufScanner) Unscan() { s.n++ }
 
@@ -43,6 +43,8 @@ func TestScanner_Scan(t *testing.T) {
 
 		{s: `=`, tok: influxql.EQ},
 		{s: `<>`, tok: influxql.NEQ},
+		{s: `=~`, tok: influxql.EQREGEX},
+		{s: `!~`, tok: influxql.NEQREGEX},
 		{s: `! `, tok: influxql.ILLEGAL, lit: "!"},
 		{s: `<`, tok: influxql.LT},
 		{s: `<=`, tok: influxql.LTE},
@@ -104,6 +106,8 @@ func TestScanner_Scan(t *testing.T) {
 		{s: `ASC`, tok: influxql.ASC},
 		{s: `BEGIN`, tok: influxql.BEGIN},
 		{s: `BY`, tok: influxql.BY},
+		{s: `CARDINALITY`, tok: influxql.CARDINALITY},
+		{s: `CLUSTER`, tok: influxql.CLUSTER},
 		{s: `CREATE`, tok: influxql.CREATE},
 		{s: `CONTINUOUS`, tok: influxql.CONTINUOUS},
 		{s: `DATABASE`, tok: influxql.DATABASE},
@@ -111,9 +115,11 @@ func TestScanner_Scan(t *testing.T) {
 		{s: `DEFAULT`, tok: influxql.DEFAULT},
 		{s: `DELETE`, tok: influxql.DELETE},
 		{s: `DESC`, tok: influxql.DESC},
+		{s: `DISTINCT`, tok: influxql.DISTINCT},
 		{s: `DROP`, tok: influxql.DROP},
 		{s: `DURATION`, tok: influxql.DURATION},
 		{s: `END`, tok: influxql.END},
+		{s: `EXACT`, tok: influxql.EXACT},
 		{s: `EXISTS`, tok: influxql.EXISTS},
 		{s: `EXPLAIN`, tok: influxql.EXPLAIN},
 		{s: `FIELD`, tok: influxql.FIELD},
@@ -131,6 +137,11 @@ func TestScanner_Scan(t *testing.T) {
 		{s: `MEASUREMENT`, tok: influxql.MEASUREMENT},
 		{s: `MEASUREMENTS`, tok: influxql.MEASUREMENTS},
 		{s: `OFFSET`, tok: influxql.OFFSET},
+		{s: `SERVERS`, tok: influxql.SERVERS},
+		{s: `SIZES`, tok: influxql.SIZES},
+		{s: `SLIMIT`, tok: influxql.SLIMIT},
+		{s: `SOFFSET`, tok: influxql.SOFFSET},
+		{s: `STATS`, tok: influxql.STATS},
 		{s: `ON`, tok: influxql.ON},
 		{s: `ORDER`, tok: influxql.ORDER},
 		{s: `PASSWORD`, tok: influxql.PASSWORD},
@@ -221,6 +232,31 @@ func TestScanner_Scan_Multi(t *testing.T) {
 	}
 }
 
+// Ensure the scanner can scan regex literals.
+func TestScanner_ScanRegex(t *testing.T) {
+	var tests = []struct {
+		in  string
+		tok influxql.Token
+		lit string
+	}{
+		{in: `/^web-\d+/`, tok: influxql.REGEX, lit: `^web-\d+`},
+		{in: ` /foo/ bar`, tok: influxql.REGEX, lit: `foo`},
+		{in: `/\/var\//`, tok: influxql.REGEX, lit: `/var/`},
+		{in: `/foo`, tok: influxql.BADREGEX, lit: `foo`},
+		{in: "/foo\nbar/", tok: influxql.BADREGEX, lit: `foo`},
+		{in: `'foo'`, tok: influxql.STRING, lit: `foo`},
+	}
+
+	for i, tt := range tests {
+		tok, _, lit := influxql.NewScanner(strings.NewReader(tt.in)).ScanRegex()
+		if tt.tok != tok {
+			t.Errorf("%d. %s: token mismatch: exp=%s got=%s", i, tt.in, tt.tok, tok)
+		} else if tt.lit != lit {
+			t.Errorf("%d. %s: literal mismatch: exp=%s got=%s", i, tt.in, tt.lit, lit)
+		}
+	}
+}
+
 // Ensure the library can correctly scan strings.
 func TestScanString(t *testing.T) {
 	var tests = []struct {
@@ -21,6 +21,8 @@ const (
 	STRING       // "abc"
 	BADSTRING    // "abc
 	BADESCAPE    // \q
+	REGEX        // /web-.*/
+	BADREGEX     // /web-.*
 	TRUE         // true
 	FALSE        // false
 	literal_end
@@ -35,12 +37,14 @@ const (
 	AND // AND
 	OR  // OR
 
-	EQ  // =
-	NEQ // !=
-	LT  // <
-	LTE // <=
-	GT  // >
-	GTE // >=
+	EQ       // =
+	NEQ      // !=
+	EQREGEX  // =~
+	NEQREGEX // !~
+	LT       // <
+	LTE      // <=
+	GT       // >
+	GTE      // >=
 	operator_end
 
 	LPAREN    // (
@@ -57,6 +61,9 @@ const (
 	ASC
 	BEGIN
 	BY
+	CARDINALITY
+	CLUSTER
+	COMPRESSION
 	CREATE
 	CONTINUOUS
 	DATABASE
@@ -64,17 +71,22 @@ const (
 	DEFAULT
 	DELETE
 	DESC
+	DISTINCT
 	DROP
 	DURATION
 	END
+	EVERY
+	EXACT
 	EXISTS
 	EXPLAIN
 	FIELD
+	FOR
 	FROM
 	GRANT
 	GROUP
 	IF
 	IN
+	INF
 	INNER
 	INSERT
 	INTO
@@ -95,10 +107,17 @@ const (
 	QUERY
 	READ
 	REPLICATION
+	RESAMPLE
 	RETENTION
 	REVOKE
 	SELECT
 	SERIES
+	SERVERS
+	SHARD
+	SIZES
+	SLIMIT
+	SOFFSET
+	STATS
 	TAG
 	TO
 	USER
@@ -119,6 +138,7 @@ var tokens = [...]string{
 	NUMBER:       "NUMBER",
 	DURATION_VAL: "DURATION_VAL",
 	STRING:       "STRING",
+	REGEX:        "REGEX",
 	TRUE:         "TRUE",
 	FALSE:        "FALSE",
 
@@ -130,12 +150,14 @@ var tokens = [...]string{
 	AND: "AND",
 	OR:  "OR",
 
-	EQ:  "=",
-	NEQ: "!=",
-	LT:  "<",
-	LTE: "<=",
-	GT:  ">",
-	GTE: ">=",
+	EQ:       "=",
+	NEQ:      "!=",
+	EQREGEX:  "=~",
+	NEQREGEX: "!~",
+	LT:       "<",
+	LTE:      "<=",
+	GT:       ">",
+	GTE:      ">=",
 
 	LPAREN:    "(",
 	RPAREN:    ")",
@@ -149,6 +171,9 @@ var tokens = [...]string{
 	ASC:          "ASC",
 	BEGIN:        "BEGIN",
 	BY:           "BY",
+	CARDINALITY:  "CARDINALITY",
+	CLUSTER:      "CLUSTER",
+	COMPRESSION:  "COMPRESSION",
 	CREATE:       "CREATE",
 	CONTINUOUS:   "CONTINUOUS",
 	DATABASE:     "DATABASE",
@@ -156,17 +181,22 @@ var tokens = [...]string{
 	DEFAULT:      "DEFAULT",
 	DELETE:       "DELETE",
 	DESC:         "DESC",
+	DISTINCT:     "DISTINCT",
 	DROP:         "DROP",
 	DURATION:     "DURATION",
 	END:          "END",
+	EVERY:        "EVERY",
+	EXACT:        "EXACT",
 	EXISTS:       "EXISTS",
 	EXPLAIN:      "EXPLAIN",
 	FIELD:        "FIELD",
+	FOR:          "FOR",
 	FROM:         "FROM",
 	GRANT:        "GRANT",
 	GROUP:        "GROUP",
 	IF:           "IF",
 	IN:           "IN",
+	INF:          "INF",
 	INNER:        "INNER",
 	INSERT:       "INSERT",
 	INTO:         "INTO",
@@ -187,10 +217,17 @@ var tokens = [...]string{
 	QUERY:        "QUERY",
 	READ:         "READ",
 	REPLICATION:  "REPLICATION",
+	RESAMPLE:     "RESAMPLE",
 	RETENTION:    "RETENTION",
 	REVOKE:       "REVOKE",
 	SELECT:       "SELECT",
 	SERIES:       "SERIES",
+	SERVERS:      "SERVERS",
+	SHARD:        "SHARD",
+	SIZES:        "SIZES",
+	SLIMIT:       "SLIMIT",
+	SOFFSET:      "SOFFSET",
+	STATS:        "STATS",
 	TAG:          "TAG",
 	TO:           "TO",
 	USER:         "USER",
@@ -232,7 +269,7 @@ func (tok Token) Precedence() int {
 		return 1
 	case AND:
 		return 2
-	case EQ, NEQ, LT, LTE, GT, GTE:
+	case EQ, NEQ, EQREGEX, NEQREGEX, LT, LTE, GT, GTE:
 		return 3
 	case ADD, SUB:
 		return 4
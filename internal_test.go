@@ -3,9 +3,22 @@ package influxdb
 // This file is run within the "influxdb" package and allows for internal unit tests.
 
 import (
+	"bytes"
+	"encoding"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
 	"reflect"
+	"regexp"
 	"testing"
+	"time"
 
+	"github.com/boltdb/bolt"
 	"github.com/influxdb/influxdb/influxql"
 )
 
@@ -31,6 +44,51 @@ func TestMeasurement_uniqueTagValues(t *testing.T) {
 	}
 }
 
+// Ensure the series index cache drops the least recently used measurement indexes.
+func TestSeriesIndexCache(t *testing.T) {
+	var loadN int
+	c := newSeriesIndexCache(3, func(database, name string) []*Series {
+		loadN++
+		return []*Series{{ID: 1, Tags: map[string]string{"host": "a"}}, {ID: 2, Tags: map[string]string{"host": "b"}}}
+	})
+	db := newDatabase()
+	db.name, db.cache = "db", c
+	cpu, mem := db.createMeasurementIfNotExists("cpu"), db.createMeasurementIfNotExists("mem")
+
+	// Loading the second measurement drops the first.
+	if ids := cpu.index().seriesIDs; !reflect.DeepEqual(ids, seriesIDs{1, 2}) {
+		t.Fatalf("unexpected series ids: %v", ids)
+	} else if ids := mem.index().seriesIDs; !reflect.DeepEqual(ids, seriesIDs{1, 2}) {
+		t.Fatalf("unexpected series ids: %v", ids)
+	} else if cpu.idx != nil || mem.idx == nil {
+		t.Fatal("unexpected loaded indexes")
+	} else if n := c.size(); n != 2 {
+		t.Fatalf("unexpected size: %d", n)
+	}
+
+	// Series aren't added to an index that isn't loaded.
+	if !cpu.addSeries(&Series{ID: 3}) {
+		t.Fatal("series not added")
+	} else if cpu.idx != nil || cpu.seriesN != 1 {
+		t.Fatalf("unexpected index: %v, %d", cpu.idx, cpu.seriesN)
+	}
+
+	// Using a loaded index doesn't reload it.
+	mem.index()
+	cpu.index()
+	if loadN != 3 {
+		t.Fatalf("unexpected load count: %d", loadN)
+	} else if mem.idx != nil {
+		t.Fatal("expected mem index to be dropped")
+	}
+
+	// A dropped measurement is removed from the cache.
+	db.DropMeasurement("cpu")
+	if n := c.size(); n != 0 {
+		t.Fatalf("unexpected size: %d", n)
+	}
+}
+
 // Ensure a measurement can expand an expression for all possible tag values used.
 func TestMeasurement_expandExpr(t *testing.T) {
 	m := NewMeasurement("cpu")
@@ -90,6 +148,685 @@ func TestMeasurement_expandExpr(t *testing.T) {
 	}
 }
 
+// Ensure a measurement attaches field conditions to the series they filter.
+func TestMeasurement_seriesIDsAndFilters(t *testing.T) {
+	m := NewMeasurement("cpu")
+	m.createFieldIfNotExists("value", influxql.Number)
+	m.addSeries(&Series{ID: 1, Tags: map[string]string{"host": "serverA"}})
+	m.addSeries(&Series{ID: 2, Tags: map[string]string{"host": "serverB"}})
+
+	for i, tt := range []struct {
+		expr    string
+		ids     seriesIDs
+		filters map[uint32]string
+	}{
+		{expr: `value > 90`, ids: seriesIDs{1, 2}, filters: map[uint32]string{1: `value > 90.000`, 2: `value > 90.000`}},
+		{expr: `host = 'serverA' AND value > 90`, ids: seriesIDs{1}, filters: map[uint32]string{1: `value > 90.000`}},
+		{expr: `host = 'serverA' OR value > 90`, ids: seriesIDs{1, 2}, filters: map[uint32]string{2: `value > 90.000`}},
+		{expr: `host = 'serverA'`, ids: seriesIDs{1}, filters: map[uint32]string{}},
+		{expr: `host =~ /B$/`, ids: seriesIDs{2}, filters: map[uint32]string{}},
+		{expr: `host !~ /B$/ AND value > 90`, ids: seriesIDs{1}, filters: map[uint32]string{1: `value > 90.000`}},
+	} {
+		stmt := &influxql.SelectStatement{Condition: MustParseExpr(tt.expr)}
+		ids, filters := m.seriesIDsAndFilters(stmt)
+
+		a := make(map[uint32]string)
+		for id, expr := range filters {
+			a[id] = expr.String()
+		}
+		if !reflect.DeepEqual(tt.ids, ids) {
+			t.Errorf("%d. %s: ids mismatch: exp=%v, got=%v", i, tt.expr, tt.ids, ids)
+		} else if !reflect.DeepEqual(tt.filters, a) {
+			t.Errorf("%d. %s: filters mismatch: exp=%v, got=%v", i, tt.expr, tt.filters, a)
+		}
+	}
+}
+
+// Ensure the tag value index only matches regexes against candidate values
+// and drops the index when a tag's values change.
+func TestMeasurement_seriesIDsByTagRegex(t *testing.T) {
+	m := NewMeasurement("cpu")
+	for i, host := range []string{"web-01", "web-02", "db-01", "db-web", "WEB-03"} {
+		m.addSeries(&Series{ID: uint32(i + 1), Tags: map[string]string{"host": host}})
+	}
+	index := m.index()
+
+	for i, tt := range []struct {
+		re         string
+		ids        seriesIDs
+		candidates []string
+	}{
+		{re: `^web-`, ids: seriesIDs{1, 2}, candidates: []string{"web-01", "web-02"}},
+		{re: `web`, ids: seriesIDs{1, 2, 4}, candidates: []string{"db-web", "web-01", "web-02"}},
+		{re: `-01$`, ids: seriesIDs{1, 3}, candidates: []string{"db-01", "web-01"}},
+		{re: `(?i)^web-`, ids: seriesIDs{1, 2, 5}, candidates: []string{"WEB-03", "db-01", "db-web", "web-01", "web-02"}},
+		{re: `^(web|db)-01`, ids: seriesIDs{1, 3}, candidates: []string{"db-01", "web-01"}},
+		{re: `^(web|db)`, ids: seriesIDs{1, 2, 3, 4}, candidates: []string{"WEB-03", "db-01", "db-web", "web-01", "web-02"}},
+		{re: `app`, ids: nil, candidates: nil},
+	} {
+		re := regexp.MustCompile(tt.re)
+		if ids := index.seriesIDsByTagRegex("host", re); !reflect.DeepEqual(tt.ids, ids) {
+			t.Errorf("%d. %s: ids mismatch: exp=%v, got=%v", i, tt.re, tt.ids, ids)
+		} else if a := index.tagValueIndex("host").candidates(re); !reflect.DeepEqual(tt.candidates, a) {
+			t.Errorf("%d. %s: candidates mismatch: exp=%v, got=%v", i, tt.re, tt.candidates, a)
+		}
+	}
+
+	// New and dropped values must be matched.
+	re := regexp.MustCompile(`^web-`)
+	m.addSeries(&Series{ID: 6, Tags: map[string]string{"host": "web-04"}})
+	m.dropSeries(1)
+	if ids := index.seriesIDsByTagRegex("host", re); !reflect.DeepEqual(seriesIDs{2, 6}, ids) {
+		t.Fatalf("unexpected ids: %v", ids)
+	}
+}
+
+// Ensure only the reachable data node with the lowest ID leads retention enforcement.
+func TestServer_isRetentionLeader(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+	u, _ := url.Parse(ts.URL)
+
+	s := NewServer()
+	s.dataNodes[1] = &DataNode{ID: 1, URL: u}
+	s.dataNodes[2] = &DataNode{ID: 2, URL: u}
+
+	for i, tt := range []struct {
+		id     uint64
+		leader bool
+	}{
+		{id: 0, leader: false},
+		{id: 1, leader: true},
+		{id: 2, leader: false},
+	} {
+		s.id = tt.id
+		if leader := s.isRetentionLeader(); leader != tt.leader {
+			t.Errorf("%d. id=%d: unexpected leader: %v", i, tt.id, leader)
+		}
+	}
+
+	// The next lowest node leads once the lowest is unreachable.
+	ts.Close()
+	if s.id = 2; !s.isRetentionLeader() {
+		t.Error("expected node 2 to lead while node 1 is down")
+	}
+
+	// The next lowest node leads once the lowest is removed.
+	delete(s.dataNodes, 1)
+	if s.id = 2; !s.isRetentionLeader() {
+		t.Error("expected node 2 to lead")
+	}
+}
+
+// Ensure a RESAMPLE EVERY clause overrides how often the server runs a continuous query.
+func TestServer_shouldRunContinuousQuery_ResampleEvery(t *testing.T) {
+	s := NewServer()
+	s.ComputeRunsPerInterval = 10
+	s.ComputeNoMoreThan = time.Minute
+
+	for i, tt := range []struct {
+		q       string
+		lastRun time.Duration // time since last run
+		run     bool
+	}{
+		{q: `CREATE CONTINUOUS QUERY cq ON db BEGIN SELECT count(value) INTO cpu_1h FROM cpu GROUP BY time(1h) END`, lastRun: 2 * time.Minute, run: false},
+		{q: `CREATE CONTINUOUS QUERY cq ON db BEGIN SELECT count(value) INTO cpu_1h FROM cpu GROUP BY time(1h) END`, lastRun: 7 * time.Minute, run: true},
+		{q: `CREATE CONTINUOUS QUERY cq ON db RESAMPLE EVERY 30s BEGIN SELECT count(value) INTO cpu_1h FROM cpu GROUP BY time(1h) END`, lastRun: 45 * time.Second, run: true},
+		{q: `CREATE CONTINUOUS QUERY cq ON db RESAMPLE EVERY 30m BEGIN SELECT count(value) INTO cpu_1h FROM cpu GROUP BY time(1h) END`, lastRun: 7 * time.Minute, run: false},
+	} {
+		cq, err := NewContinuousQuery(tt.q)
+		if err != nil {
+			t.Fatalf("%d. %s", i, err)
+		}
+		cq.lastRun = time.Now().Add(-tt.lastRun)
+		if run := s.shouldRunContinuousQuery(cq); run != tt.run {
+			t.Errorf("%d. %s: unexpected run: %v", i, tt.q, run)
+		}
+	}
+}
+
+// Ensure continuous queries are assigned to the same data node regardless of node order.
+func TestContinuousQueryOwner(t *testing.T) {
+	owners := make(map[uint64]bool)
+	for i := 0; i < 100; i++ {
+		name := fmt.Sprintf("cq%d", i)
+		id := continuousQueryOwner("db", name, []uint64{1, 2, 3})
+		if id < 1 || id > 3 {
+			t.Fatalf("unexpected owner: %s: %d", name, id)
+		} else if other := continuousQueryOwner("db", name, []uint64{3, 1, 2}); other != id {
+			t.Fatalf("owner changed with node order: %s: %d != %d", name, id, other)
+		}
+		owners[id] = true
+	}
+
+	// All nodes should be assigned some queries.
+	if len(owners) != 3 {
+		t.Fatalf("unexpected owner count: %d", len(owners))
+	}
+}
+
+// Ensure continuous query start offsets are stable and within the jitter.
+func TestContinuousQueryJitter(t *testing.T) {
+	if d := continuousQueryJitter("db", "cq", 0); d != 0 {
+		t.Fatalf("unexpected jitter without max: %s", d)
+	}
+
+	offsets := make(map[time.Duration]bool)
+	for i := 0; i < 100; i++ {
+		name := fmt.Sprintf("cq%d", i)
+		d := continuousQueryJitter("db", name, time.Minute)
+		if d < 0 || d >= time.Minute {
+			t.Fatalf("jitter out of range: %s: %s", name, d)
+		} else if other := continuousQueryJitter("db", name, time.Minute); other != d {
+			t.Fatalf("jitter changed: %s: %s != %s", name, d, other)
+		}
+		offsets[d] = true
+	}
+
+	// Queries should be spread out.
+	if len(offsets) < 90 {
+		t.Fatalf("unexpected distinct offsets: %d", len(offsets))
+	}
+}
+
+// Ensure the auth cache only matches the verified password of the current hash until it expires.
+func TestAuthCache(t *testing.T) {
+	c := newAuthCache()
+	u := &User{Name: "susy", Hash: "hash0"}
+	now := time.Now()
+
+	c.set(u, "pass", now.Add(time.Minute))
+	if !c.get(u, "pass", now) {
+		t.Fatal("expected cached password")
+	} else if c.get(u, "wrong", now) {
+		t.Fatal("unexpected match for wrong password")
+	} else if c.get(u, "pass", now.Add(time.Minute)) {
+		t.Fatal("unexpected match after expiry")
+	} else if c.get(&User{Name: "susy", Hash: "hash1"}, "pass", now) {
+		t.Fatal("unexpected match after password change")
+	}
+
+	c.delete("susy")
+	if c.get(u, "pass", now) {
+		t.Fatal("unexpected match after delete")
+	}
+}
+
+func TestAuthLockout(t *testing.T) {
+	l := newAuthLockout()
+	now := time.Now()
+
+	// Failures further apart than the window are not consecutive.
+	l.fail("susy", 2, time.Minute, now)
+	if l.fail("susy", 2, time.Minute, now.Add(2*time.Minute)) {
+		t.Fatal("unexpected lockout")
+	}
+
+	now = now.Add(2 * time.Minute)
+	if !l.fail("susy", 2, time.Minute, now.Add(time.Second)) {
+		t.Fatal("expected lockout")
+	} else if !l.locked("susy", now.Add(time.Minute)) {
+		t.Fatal("expected user to be locked")
+	} else if l.locked("susy", now.Add(2*time.Minute)) {
+		t.Fatal("unexpected lock after lockout")
+	} else if l.locked("bob", now) {
+		t.Fatal("unexpected lock on other user")
+	}
+
+	l.fail("susy", 1, time.Minute, now)
+	l.delete("susy")
+	if l.locked("susy", now) {
+		t.Fatal("unexpected lock after delete")
+	}
+}
+
+// Ensure message errors are dropped, oldest first, once there are too many or they are too old.
+func TestMessageErrors(t *testing.T) {
+	e := newMessageErrors(2, time.Minute)
+	now := time.Now()
+	errA, errB, errC := errors.New("a"), errors.New("b"), errors.New("c")
+
+	// Retrieved errors don't count as dropped.
+	e.add(1, errA, now)
+	if err := e.take(1); err != errA {
+		t.Fatalf("unexpected error: %v", err)
+	} else if err := e.take(1); err != nil {
+		t.Fatalf("unexpected error after take: %v", err)
+	}
+
+	// The oldest error is dropped once there are more than two.
+	e.add(2, errA, now)
+	e.add(3, errB, now)
+	if n := e.add(4, errC, now); n != 1 {
+		t.Fatalf("unexpected dropped count: %d", n)
+	} else if err := e.take(2); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if e.len() != 2 {
+		t.Fatalf("unexpected len: %d", e.len())
+	}
+
+	// Errors older than a minute are dropped.
+	if n := e.add(5, errA, now.Add(2*time.Minute)); n != 2 {
+		t.Fatalf("unexpected dropped count: %d", n)
+	} else if err := e.take(5); err != errA {
+		t.Fatalf("unexpected error: %v", err)
+	} else if e.len() != 0 {
+		t.Fatalf("unexpected len: %d", e.len())
+	}
+}
+
+// Ensure commands are decoded from the binary encoding and from legacy JSON.
+func TestCommandEncoding(t *testing.T) {
+	for i, c := range []interface{}{
+		&createShardGroupIfNotExistsCommand{Database: "db0", Policy: "rp0", Timestamp: time.Unix(0, 1434063600000000000).UTC()},
+		&createSeriesIfNotExistsCommand{Database: "db0", Name: "cpu", Tags: map[string]string{"host": "serverA", "region": ""}},
+		&createFieldsIfNotExistCommand{Database: "db0", Measurement: "cpu", Fields: map[string]influxql.DataType{"value": influxql.Number, "ok": influxql.Boolean}},
+		&deleteDatabaseCommand{Name: "db0"},
+	} {
+		for _, asJSON := range []bool{false, true} {
+			b, err := encodeCommand(c, asJSON)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, binary := c.(encoding.BinaryMarshaler); binary && !asJSON && b[0] != commandBinaryVersion {
+				t.Fatalf("%d. expected binary encoding: %q", i, b)
+			}
+
+			v := reflect.New(reflect.TypeOf(c).Elem()).Interface()
+			mustDecodeCommand(b, v)
+			if !reflect.DeepEqual(v, c) {
+				t.Errorf("%d. json=%v: mismatch:\n\nexp=%#v\n\ngot=%#v", i, asJSON, c, v)
+			}
+		}
+	}
+
+	// Truncated commands are rejected.
+	b, _ := (&createSeriesIfNotExistsCommand{Database: "db0", Name: "cpu", Tags: map[string]string{"host": "serverA"}}).MarshalBinary()
+	if err := (&createSeriesIfNotExistsCommand{}).UnmarshalBinary(b[:len(b)-2]); err != errShortCommand {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure a shard only adds the points it is missing when merging another shard.
+func TestShard_merge(t *testing.T) {
+	path, _ := ioutil.TempDir("", "influxdb-")
+	defer os.RemoveAll(path)
+
+	var src, dst Shard
+	if err := src.open(filepath.Join(path, "src"), nil); err != nil {
+		t.Fatal(err)
+	}
+	defer src.close()
+	if err := dst.open(filepath.Join(path, "dst"), nil); err != nil {
+		t.Fatal(err)
+	}
+	defer dst.close()
+
+	src.writeSeries(1, 10, []byte("a"), false)
+	src.writeSeries(1, 20, []byte("b"), false)
+	src.writeSeries(2, 10, []byte("x"), false)
+	dst.writeSeries(1, 20, []byte("c"), false)
+
+	if n, err := dst.merge(src.store); err != nil {
+		t.Fatal(err)
+	} else if n != 2 {
+		t.Fatalf("unexpected merged point count: %d", n)
+	}
+	for _, tt := range []struct {
+		seriesID  uint32
+		timestamp int64
+		value     string
+	}{
+		{1, 10, "a"},
+		{1, 20, "c"},
+		{2, 10, "x"},
+	} {
+		if v, _ := dst.readSeries(tt.seriesID, tt.timestamp); string(v) != tt.value {
+			t.Errorf("unexpected value: series=%d, timestamp=%d, value=%q", tt.seriesID, tt.timestamp, v)
+		}
+	}
+}
+
+// Ensure a compressed shard stores values compressed only when that makes them smaller.
+func TestShard_compression(t *testing.T) {
+	path, _ := ioutil.TempDir("", "influxdb-")
+	defer os.RemoveAll(path)
+
+	sh := Shard{Compression: CompressionDeflate}
+	if err := sh.open(filepath.Join(path, "shard"), nil); err != nil {
+		t.Fatal(err)
+	}
+	defer sh.close()
+
+	long := append([]byte{1}, bytes.Repeat([]byte("abcd"), 100)...)
+	sh.writeSeries(1, 10, long, false)
+	sh.writeSeries(1, 20, []byte{1, 2}, false)
+
+	// Only the long value is stored compressed.
+	sh.store.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(u32tob(1))
+		if v := b.Get(u64tob(10)); len(v) >= len(long) || v[0] != compressedValuesMarker {
+			t.Errorf("expected compressed value: len=%d", len(v))
+		}
+		if v := b.Get(u64tob(20)); !bytes.Equal(v, []byte{1, 2}) {
+			t.Errorf("unexpected stored value: %v", v)
+		}
+		return nil
+	})
+
+	// Both values are read back as written.
+	if v, err := sh.readSeries(1, 10); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(v, long) {
+		t.Errorf("unexpected value: %v", v)
+	}
+	if v, err := sh.readSeries(1, 20); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(v, []byte{1, 2}) {
+		t.Errorf("unexpected value: %v", v)
+	}
+}
+
+// Ensure a shard cursor returns the points of a series within a time range in order.
+func TestShard_Cursor(t *testing.T) {
+	path, _ := ioutil.TempDir("", "influxdb-")
+	defer os.RemoveAll(path)
+
+	sh := Shard{Compression: CompressionDeflate}
+	if err := sh.open(filepath.Join(path, "shard"), nil); err != nil {
+		t.Fatal(err)
+	}
+	defer sh.close()
+
+	long := append([]byte{1}, bytes.Repeat([]byte("abcd"), 100)...)
+	sh.writeSeries(1, 40, []byte{4}, false)
+	sh.writeSeries(1, 10, []byte{1}, false)
+	sh.writeSeries(1, 30, long, false)
+	sh.writeSeries(1, 20, []byte{2}, false)
+	sh.writeSeries(2, 25, []byte{9}, false)
+
+	for i, tt := range []struct {
+		seriesID   uint32
+		start, end int64
+		timestamps []int64
+	}{
+		{seriesID: 1, start: 0, end: 100, timestamps: []int64{10, 20, 30, 40}},
+		{seriesID: 1, start: 20, end: 30, timestamps: []int64{20, 30}},
+		{seriesID: 1, start: 41, end: 100},
+		{seriesID: 3, start: 0, end: 100},
+	} {
+		c, err := sh.Cursor(tt.seriesID, tt.start, tt.end)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var timestamps []int64
+		for {
+			timestamp, values, err := c.Next()
+			if err != nil {
+				t.Fatal(err)
+			} else if values == nil {
+				break
+			}
+			if timestamp == 30 && !bytes.Equal(values, long) {
+				t.Errorf("%d. unexpected values: %v", i, values)
+			}
+			timestamps = append(timestamps, timestamp)
+		}
+		if !reflect.DeepEqual(timestamps, tt.timestamps) {
+			t.Errorf("%d. unexpected timestamps: %v", i, timestamps)
+		}
+		if err := c.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The store is no longer in use once the cursors are closed.
+	if !sh.closeIdle() {
+		t.Fatal("expected idle store")
+	}
+}
+
+// Ensure a shard's store is opened with its tuning options.
+func TestShard_open_options(t *testing.T) {
+	path, _ := ioutil.TempDir("", "influxdb-")
+	defer os.RemoveAll(path)
+
+	var sh Shard
+	if err := sh.open(filepath.Join(path, "shard"), &ShardStoreOptions{MmapSize: 1 << 20, NoSync: true, FillPercent: 1}); err != nil {
+		t.Fatal(err)
+	}
+	defer sh.close()
+
+	if !sh.store.NoSync {
+		t.Fatal("expected store without syncs")
+	}
+	for i := int64(0); i < 1000; i++ {
+		if err := sh.writeSeries(1, i, []byte{1, 2}, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if v, err := sh.readSeries(1, 999); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(v, []byte{1, 2}) {
+		t.Errorf("unexpected value: %v", v)
+	}
+
+	// Writes can be synced explicitly.
+	if err := sh.sync(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure a shard's checksums only match another's with the same points and torn values are reported.
+func TestShard_verify(t *testing.T) {
+	path, _ := ioutil.TempDir("", "influxdb-")
+	defer os.RemoveAll(path)
+
+	var a, b Shard
+	if err := a.open(filepath.Join(path, "a"), nil); err != nil {
+		t.Fatal(err)
+	}
+	defer a.close()
+	if err := b.open(filepath.Join(path, "b"), nil); err != nil {
+		t.Fatal(err)
+	}
+	defer b.close()
+
+	m := &Measurement{Fields: []*Field{{ID: 1, Name: "value", Type: influxql.Number}}}
+	codec := NewFieldCodec(m)
+	codecs := map[uint32]*FieldCodec{1: codec, 2: codec}
+	v10, _ := codec.EncodeFields(map[string]interface{}{"value": float64(10)})
+	v20, _ := codec.EncodeFields(map[string]interface{}{"value": float64(20)})
+	for _, sh := range []*Shard{&a, &b} {
+		sh.writeSeries(1, 10, v10, false)
+		sh.writeSeries(2, 10, v10, false)
+	}
+	b.writeSeries(2, 10, v20, true)
+
+	ca, err := a.verify(codecs)
+	if err != nil {
+		t.Fatal(err)
+	} else if !ca.OK() {
+		t.Fatalf("unexpected problems: %v", ca.Problems)
+	}
+	cb, _ := b.verify(codecs)
+	if ca.Series[1] != cb.Series[1] {
+		t.Fatal("expected matching checksums for series 1")
+	} else if ca.Checksum == cb.Checksum {
+		t.Fatal("expected shard checksums to differ")
+	} else if diff := ca.Diff(cb); !reflect.DeepEqual(diff, []uint32{2}) {
+		t.Fatalf("unexpected diff: %v", diff)
+	}
+
+	// A truncated value is reported as a problem.
+	a.writeSeries(1, 20, v10[:5], false)
+	if c, err := a.verify(codecs); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(c.Problems, []string{"series 1 at 20: field value is truncated"}) {
+		t.Fatalf("unexpected problems: %v", c.Problems)
+	}
+}
+
+// Ensure the shard store cache closes the least recently used stores that aren't in use.
+func TestShardStoreCache(t *testing.T) {
+	path, _ := ioutil.TempDir("", "influxdb-")
+	defer os.RemoveAll(path)
+
+	c := newShardStoreCache(1)
+	a, b := &Shard{cache: c}, &Shard{cache: c}
+	if err := a.open(filepath.Join(path, "a"), nil); err != nil {
+		t.Fatal(err)
+	} else if err := b.open(filepath.Join(path, "b"), nil); err != nil {
+		t.Fatal(err)
+	} else if a.store != nil || b.store != nil {
+		t.Fatal("expected stores to be opened when used")
+	}
+
+	// Stores in use are kept open past the limit.
+	if err := a.writeSeries(1, 10, []byte("a"), false); err != nil {
+		t.Fatal(err)
+	} else if _, err := a.acquire(); err != nil {
+		t.Fatal(err)
+	} else if err := b.writeSeries(1, 10, []byte("b"), false); err != nil {
+		t.Fatal(err)
+	} else if a.store == nil || b.store == nil {
+		t.Fatal("expected open stores")
+	} else if n := c.size(); n != 2 {
+		t.Fatalf("unexpected size: %d", n)
+	}
+
+	// The least recently used store is closed once it is released.
+	a.release()
+	if v, err := b.readSeries(1, 10); err != nil || string(v) != "b" {
+		t.Fatalf("unexpected value: %q, %v", v, err)
+	} else if a.store != nil || b.store == nil {
+		t.Fatal("expected a to be closed")
+	}
+
+	// A closed store is reopened when it's used.
+	if v, err := a.readSeries(1, 10); err != nil || string(v) != "a" {
+		t.Fatalf("unexpected value: %q, %v", v, err)
+	} else if a.store == nil || b.store != nil {
+		t.Fatal("expected b to be closed")
+	} else if n := c.size(); n != 1 {
+		t.Fatalf("unexpected size: %d", n)
+	}
+
+	// A closed shard is removed from the cache and isn't reopened.
+	a.close()
+	if n := c.size(); n != 0 {
+		t.Fatalf("unexpected size: %d", n)
+	} else if err := a.writeSeries(1, 20, []byte("a"), false); err != ErrShardNotFound {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure shards are moved from the most to the least loaded data nodes.
+func TestPlanShardRebalance(t *testing.T) {
+	for i, tt := range []struct {
+		shards  []*Shard
+		nodeIDs []uint64
+		moves   []ShardMove
+	}{
+		// Balanced or single node clusters don't move shards.
+		{
+			shards:  []*Shard{{ID: 1, DataNodeIDs: []uint64{1}}},
+			nodeIDs: []uint64{1},
+		},
+		{
+			shards:  []*Shard{{ID: 1, DataNodeIDs: []uint64{1}}, {ID: 2, DataNodeIDs: []uint64{2}}},
+			nodeIDs: []uint64{1, 2},
+		},
+
+		// A new node takes shards from the existing node.
+		{
+			shards: []*Shard{
+				{ID: 1, DataNodeIDs: []uint64{1}},
+				{ID: 2, DataNodeIDs: []uint64{1}},
+				{ID: 3, DataNodeIDs: []uint64{1}},
+				{ID: 4, DataNodeIDs: []uint64{1}},
+			},
+			nodeIDs: []uint64{1, 2},
+			moves:   []ShardMove{{ShardID: 1, From: 1, To: 2}, {ShardID: 2, From: 1, To: 2}},
+		},
+
+		// Replicas are never moved to a node that already owns the shard.
+		{
+			shards: []*Shard{
+				{ID: 1, DataNodeIDs: []uint64{1, 2}},
+				{ID: 2, DataNodeIDs: []uint64{1, 2}},
+			},
+			nodeIDs: []uint64{1, 2, 3},
+			moves:   []ShardMove{{ShardID: 1, From: 1, To: 3}},
+		},
+	} {
+		if moves := planShardRebalance(tt.shards, tt.nodeIDs); !reflect.DeepEqual(tt.moves, moves) {
+			t.Errorf("%d. unexpected moves: %#v", i, moves)
+		}
+	}
+}
+
+// Ensure replicas are read round robin and recently failed replicas are read last.
+func TestReplicaSelector(t *testing.T) {
+	r := newReplicaSelector()
+	now := time.Now()
+
+	if a := r.order(1, []uint64{1, 2, 3}, time.Minute, now); !reflect.DeepEqual(a, []uint64{1, 2, 3}) {
+		t.Fatalf("unexpected order: %v", a)
+	} else if a := r.order(1, []uint64{1, 2, 3}, time.Minute, now); !reflect.DeepEqual(a, []uint64{2, 3, 1}) {
+		t.Fatalf("unexpected order: %v", a)
+	} else if a := r.order(2, []uint64{1, 2, 3}, time.Minute, now); !reflect.DeepEqual(a, []uint64{1, 2, 3}) {
+		t.Fatalf("unexpected order for other shard: %v", a)
+	}
+
+	// A failed replica is tried last until the backoff passes or it succeeds.
+	r.fail(1, now)
+	if a := r.order(2, []uint64{1, 2, 3}, time.Minute, now); !reflect.DeepEqual(a, []uint64{2, 3, 1}) {
+		t.Fatalf("unexpected order after failure: %v", a)
+	} else if a := r.order(2, []uint64{1, 2, 3}, time.Minute, now.Add(time.Minute)); !reflect.DeepEqual(a, []uint64{3, 1, 2}) {
+		t.Fatalf("unexpected order after backoff: %v", a)
+	}
+	r.succeed(1)
+	if a := r.order(2, []uint64{1, 2, 3}, time.Minute, now); !reflect.DeepEqual(a, []uint64{1, 2, 3}) {
+		t.Fatalf("unexpected order after success: %v", a)
+	}
+}
+
+// Ensure shard replicas are spread across zones before falling back to round robin.
+func TestPlaceShardReplicas(t *testing.T) {
+	nodes := []*DataNode{
+		{ID: 1, Zone: "a"},
+		{ID: 2, Zone: "a"},
+		{ID: 3, Zone: "b"},
+		{ID: 4, Zone: "b"},
+		{ID: 5},
+	}
+	for i, tt := range []struct {
+		nodes    []*DataNode
+		index    int
+		replicaN int
+		ids      []uint64
+	}{
+		// Nodes without zones are placed round robin.
+		{nodes: []*DataNode{{ID: 1}, {ID: 2}, {ID: 3}}, index: 1, replicaN: 2, ids: []uint64{2, 3}},
+
+		// Nodes in a zone that already holds a replica are skipped.
+		{nodes: nodes, index: 0, replicaN: 2, ids: []uint64{1, 3}},
+		{nodes: nodes, index: 1, replicaN: 3, ids: []uint64{2, 3, 5}},
+		{nodes: nodes, index: 3, replicaN: 3, ids: []uint64{4, 5, 1}},
+
+		// Zones are shared once every zone holds a replica.
+		{nodes: nodes, index: 0, replicaN: 4, ids: []uint64{1, 3, 5, 2}},
+	} {
+		if ids := placeShardReplicas(tt.nodes, tt.index, tt.replicaN); !reflect.DeepEqual(tt.ids, ids) {
+			t.Errorf("%d. unexpected ids: %v", i, ids)
+		}
+	}
+}
+
 // MustParseExpr parses an expression string and returns its AST representation.
 func MustParseExpr(s string) influxql.Expr {
 	expr, err := influxql.ParseExpr(s)
@@ -0,0 +1,63 @@
+package influxdb
+
+import "time"
+
+// messageErrors holds the errors of applied messages until Sync retrieves
+// them. A writer that stops before calling Sync never retrieves its error so
+// the oldest errors are dropped once there are more than max of them or they
+// are older than maxAge. It is not safe for concurrent use.
+type messageErrors struct {
+	m      map[uint64]*messageError // by message index
+	queue  []uint64                 // indexes in the order they were added
+	max    int
+	maxAge time.Duration
+}
+
+// messageError represents the error of a single message.
+type messageError struct {
+	err   error
+	added time.Time
+}
+
+// newMessageErrors returns a new, empty instance of messageErrors.
+func newMessageErrors(max int, maxAge time.Duration) *messageErrors {
+	return &messageErrors{m: make(map[uint64]*messageError), max: max, maxAge: maxAge}
+}
+
+// add records the error of a message and drops the errors that exceed the
+// limits. Messages are applied in order so the queue is sorted by age.
+// Returns the number of errors dropped before they were retrieved.
+func (e *messageErrors) add(index uint64, err error, now time.Time) (dropped int) {
+	e.m[index] = &messageError{err: err, added: now}
+	e.queue = append(e.queue, index)
+
+	for len(e.queue) > 0 {
+		front := e.queue[0]
+		me := e.m[front]
+		if me != nil && len(e.queue) <= e.max && now.Sub(me.added) < e.maxAge {
+			break
+		}
+
+		// Errors that were already retrieved are only removed from the queue.
+		if me != nil {
+			delete(e.m, front)
+			dropped++
+		}
+		e.queue = e.queue[1:]
+	}
+	return
+}
+
+// take returns and removes the error of a message.
+// Returns nil if the message had no error or it was dropped.
+func (e *messageErrors) take(index uint64) error {
+	me := e.m[index]
+	if me == nil {
+		return nil
+	}
+	delete(e.m, index)
+	return me.err
+}
+
+// len returns the number of errors held.
+func (e *messageErrors) len() int { return len(e.m) }
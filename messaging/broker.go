@@ -888,6 +888,9 @@ func newReplica(b *Broker, id uint64, urlstr string) *Replica {
 }
 
 // closeWriter removes the writer on the replica and closes the notify channel.
+// ID returns the replica's identifier.
+func (r *Replica) ID() uint64 { return r.id }
+
 func (r *Replica) closeWriter() {
 	if r.writer != nil {
 		r.writer = nil
@@ -932,6 +935,13 @@ func (r *Replica) Write(p []byte) (int, error) {
 // WriteTo begins writing messages to a named stream.
 // Only one writer is allowed on a stream at a time.
 func (r *Replica) WriteTo(w io.Writer) (int64, error) {
+	return r.WriteToFrom(w, nil)
+}
+
+// WriteToFrom begins writing messages to a named stream, skipping the
+// messages of each topic on or before the index the replica last processed.
+// Topics without an index are written from the replica's subscription.
+func (r *Replica) WriteToFrom(w io.Writer, indexes map[uint64]uint64) (int64, error) {
 	// Close previous writer, if set.
 	r.closeWriter()
 
@@ -957,6 +967,9 @@ func (r *Replica) WriteTo(w io.Writer) (int64, error) {
 		// Write topic messages from last known index.
 		// Replica machine can ignore messages it already seen.
 		index := r.topics[topicID]
+		if i := indexes[topicID]; i > index {
+			index = i
+		}
 		if _, err := t.writeTo(r, index); err != nil {
 			r.closeWriter()
 			return 0, fmt.Errorf("add stream writer: %s", err)
@@ -12,6 +12,7 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"sort"
 	"strconv"
 	"sync"
 	"time"
@@ -41,8 +42,9 @@ type Client struct {
 	replicaID uint64       // the replica that the client is connecting as.
 	config    ClientConfig // The Client state that must be persisted to disk.
 
-	opened bool
-	done   chan chan struct{} // disconnection notification
+	opened  bool
+	done    chan chan struct{} // disconnection notification
+	indexes map[uint64]uint64  // index of the last message received, by topic id
 
 	// Channel streams messages from the broker.
 	c chan *Message
@@ -88,6 +90,50 @@ func (c *Client) LeaderURL() *url.URL {
 	return c.config.Brokers[0]
 }
 
+// SetIndexes sets the index of the last message processed from each topic.
+// Streams start after these messages instead of replaying the topics from
+// the replica's subscriptions. It must be called before the client is opened.
+func (c *Client) SetIndexes(indexes map[uint64]uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.indexes = make(map[uint64]uint64, len(indexes))
+	for topicID, index := range indexes {
+		c.indexes[topicID] = index
+	}
+}
+
+// topicIndexes returns the topic indexes to stream from, sorted by topic id,
+// in the form "topicID:index".
+func (c *Client) topicIndexes() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ids := make([]uint64, 0, len(c.indexes))
+	for topicID := range c.indexes {
+		ids = append(ids, topicID)
+	}
+	sort.Sort(uint64Slice(ids))
+
+	a := make([]string, len(ids))
+	for i, topicID := range ids {
+		a[i] = strconv.FormatUint(topicID, 10) + ":" + strconv.FormatUint(c.indexes[topicID], 10)
+	}
+	return a
+}
+
+// setIndex records the index of a message received from a topic so
+// reconnected streams continue after it.
+func (c *Client) setIndex(topicID, index uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.indexes == nil {
+		c.indexes = make(map[uint64]uint64)
+	}
+	if index > c.indexes[topicID] {
+		c.indexes[topicID] = index
+	}
+}
+
 // SetLogOutput sets writer for all Client log output.
 func (c *Client) SetLogOutput(w io.Writer) {
 	c.Logger = log.New(w, "[messaging] ", log.LstdFlags)
@@ -396,8 +442,11 @@ func (c *Client) streamer(done chan chan struct{}) {
 
 // streamFromURL connects to a broker server and streams the replica's messages.
 func (c *Client) streamFromURL(u *url.URL, done chan chan struct{}) error {
-	// Set the replica id on the URL and open the stream.
-	u.RawQuery = url.Values{"replicaID": {strconv.FormatUint(c.replicaID, 10)}}.Encode()
+	// Set the replica id and topic indexes on the URL and open the stream.
+	u.RawQuery = url.Values{
+		"replicaID": {strconv.FormatUint(c.replicaID, 10)},
+		"index":     c.topicIndexes(),
+	}.Encode()
 	resp, err := http.Get(u.String())
 	if err != nil {
 		time.Sleep(c.ReconnectTimeout)
@@ -430,6 +479,7 @@ func (c *Client) streamFromURL(u *url.URL, done chan chan struct{}) error {
 
 			// Write message to streaming channel.
 			c.c <- m
+			c.setIndex(m.TopicID, m.Index)
 		}
 	}()
 
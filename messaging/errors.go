@@ -27,6 +27,10 @@ var (
 	// ErrReplicaIDRequired is returned when creating a replica without an id.
 	ErrReplicaIDRequired = errors.New("replica id required")
 
+	// ErrInvalidIndex is returned when streaming a replica from a topic index
+	// that isn't in the form "topicID:index".
+	ErrInvalidIndex = errors.New("invalid topic index")
+
 	// errReplicaUnavailable is returned when writing bytes to a replica when
 	// there is no writer attached to the replica.
 	errReplicaUnavailable = errors.New("replica unavailable")
@@ -96,9 +96,37 @@ func (h *Handler) stream(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Read the index of the last message the replica processed from each topic.
+	indexes, err := parseTopicIndexes(r.URL.Query()["index"])
+	if err != nil {
+		h.error(w, err, http.StatusBadRequest)
+		return
+	}
+
 	// Connect the response writer to the replica.
 	// This will block until the replica is closed or a new writer connects.
-	_, _ = replica.WriteTo(w)
+	_, _ = replica.WriteToFrom(w, indexes)
+}
+
+// parseTopicIndexes parses topic indexes in the form "topicID:index".
+func parseTopicIndexes(a []string) (map[uint64]uint64, error) {
+	indexes := make(map[uint64]uint64, len(a))
+	for _, s := range a {
+		i := strings.Index(s, ":")
+		if i == -1 {
+			return nil, ErrInvalidIndex
+		}
+		topicID, err := strconv.ParseUint(s[:i], 10, 64)
+		if err != nil {
+			return nil, ErrInvalidIndex
+		}
+		index, err := strconv.ParseUint(s[i+1:], 10, 64)
+		if err != nil {
+			return nil, ErrInvalidIndex
+		}
+		indexes[topicID] = index
+	}
+	return indexes, nil
 }
 
 // publishes a message to the broker.
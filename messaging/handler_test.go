@@ -5,6 +5,7 @@ import (
 	"net/http/httptest"
 	"net/url"
 	"reflect"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
@@ -40,6 +41,57 @@ func TestHandler_stream(t *testing.T) {
 	}
 }
 
+// Ensure a replica's stream starts after the topic indexes it has processed.
+func TestHandler_stream_Index(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	// Create replica and subscribe it to a topic with two messages.
+	b := s.Handler.Broker()
+	b.CreateReplica(2000, &url.URL{Host: "localhost"})
+	b.Subscribe(2000, 20)
+	index, _ := b.Publish(&messaging.Message{Type: 100, TopicID: 20, Data: []byte("0000")})
+	b.Publish(&messaging.Message{Type: 100, TopicID: 20, Data: []byte("1111")})
+	b.Sync(index + 1)
+
+	// Stream from after the first message of the topic.
+	resp, err := http.Get(s.URL + `/messaging/messages?replicaID=2000&index=20:` + strconv.FormatUint(index, 10))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status: %d: %s", resp.StatusCode, resp.Header.Get("X-Broker-Error"))
+	}
+
+	// Skip the config topic's messages and read the topic's remaining message.
+	dec := messaging.NewMessageDecoder(resp.Body)
+	for {
+		var m messaging.Message
+		if err := dec.Decode(&m); err != nil {
+			t.Fatalf("decode error: %s", err)
+		} else if m.TopicID == 20 {
+			if m.Index != index+1 || string(m.Data) != "1111" {
+				t.Fatalf("unexpected message: %#v", &m)
+			}
+			break
+		}
+	}
+}
+
+// Ensure an error is returned when requesting a stream with an invalid topic index.
+func TestHandler_stream_ErrInvalidIndex(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.Handler.Broker().CreateReplica(2000, &url.URL{Host: "localhost"})
+
+	resp, _ := http.Get(s.URL + `/messaging/messages?replicaID=2000&index=20`)
+	defer resp.Body.Close()
+	if msg := resp.Header.Get("X-Broker-Error"); resp.StatusCode != http.StatusBadRequest || msg != "invalid topic index" {
+		t.Fatalf("unexpected status/error: %d/%s", resp.StatusCode, msg)
+	}
+}
+
 // Ensure an error is returned when requesting a stream without a replica id.
 func TestHandler_stream_ErrReplicaIDRequired(t *testing.T) {
 	s := NewServer()
@@ -0,0 +1,350 @@
+package influxdb
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/influxdb/influxdb/influxql"
+)
+
+// MetadataVersion is the version of the document written by ExportMetadata.
+const MetadataVersion = 1
+
+// Metadata is the JSON document written by ExportMetadata and read by
+// ImportMetadata. Durations are Go duration strings, times are RFC 3339 and
+// privileges are "READ", "WRITE", "ALL PRIVILEGES" or "NO PRIVILEGES":
+//
+//	{
+//	  "version": 1,
+//	  "databases": [{
+//	    "name": "mydb",
+//	    "defaultRetentionPolicy": "raw",
+//	    "retentionPolicies": [{
+//	      "name": "raw",
+//	      "duration": "168h0m0s",
+//	      "shardGroupDuration": "24h0m0s",
+//	      "replicaN": 2,
+//	      "shardGroups": [{
+//	        "id": 1,
+//	        "startTime": "2015-01-01T00:00:00Z",
+//	        "endTime": "2015-01-02T00:00:00Z",
+//	        "shards": [{"id": 1, "dataNodeIDs": [1, 2]}]
+//	      }]
+//	    }],
+//	    "continuousQueries": [{"query": "CREATE CONTINUOUS QUERY ...", "disabled": true}]
+//	  }],
+//	  "users": [{
+//	    "name": "susy",
+//	    "hash": "$2a$10$...",
+//	    "admin": false,
+//	    "privileges": {"mydb": "READ"},
+//	    "measurementPrivileges": [{"database": "mydb", "measurement": "cpu", "privilege": "WRITE"}]
+//	  }]
+//	}
+//
+// Roles, API tokens, measurements and series are not included.
+type Metadata struct {
+	Version   int                 `json:"version"`
+	Databases []*DatabaseMetadata `json:"databases"`
+	Users     []*UserMetadata     `json:"users"`
+}
+
+// DatabaseMetadata represents a database in a metadata document.
+type DatabaseMetadata struct {
+	Name                   string                     `json:"name"`
+	DefaultRetentionPolicy string                     `json:"defaultRetentionPolicy,omitempty"`
+	RetentionPolicies      []*RetentionPolicyMetadata `json:"retentionPolicies"`
+	ContinuousQueries      []*ContinuousQueryMetadata `json:"continuousQueries"`
+}
+
+// RetentionPolicyMetadata represents a retention policy and the layout of its
+// shard groups in a metadata document.
+type RetentionPolicyMetadata struct {
+	Name               string                `json:"name"`
+	Duration           string                `json:"duration"` // "0s" keeps data forever
+	ShardGroupDuration string                `json:"shardGroupDuration"`
+	ReplicaN           uint32                `json:"replicaN"`
+	ShardGroups        []*ShardGroupMetadata `json:"shardGroups"`
+}
+
+// ShardGroupMetadata represents a shard group in a metadata document.
+type ShardGroupMetadata struct {
+	ID        uint64           `json:"id"`
+	StartTime time.Time        `json:"startTime"`
+	EndTime   time.Time        `json:"endTime"`
+	Shards    []*ShardMetadata `json:"shards"`
+}
+
+// ShardMetadata represents a shard and the data nodes that own it.
+type ShardMetadata struct {
+	ID          uint64   `json:"id"`
+	DataNodeIDs []uint64 `json:"dataNodeIDs"`
+}
+
+// ContinuousQueryMetadata represents a continuous query in a metadata document.
+type ContinuousQueryMetadata struct {
+	Query    string `json:"query"`
+	Disabled bool   `json:"disabled,omitempty"`
+}
+
+// UserMetadata represents a user and the user's privileges in a metadata
+// document. The password hash is exported so users keep their passwords.
+type UserMetadata struct {
+	Name                  string                          `json:"name"`
+	Hash                  string                          `json:"hash"`
+	Admin                 bool                            `json:"admin,omitempty"`
+	Privileges            map[string]string               `json:"privileges,omitempty"` // db name to privilege
+	MeasurementPrivileges []*MeasurementPrivilegeMetadata `json:"measurementPrivileges,omitempty"`
+}
+
+// MeasurementPrivilegeMetadata represents a user's privilege on the
+// measurements of a database that match a name or regular expression.
+type MeasurementPrivilegeMetadata struct {
+	Database    string `json:"database"`
+	Measurement string `json:"measurement"`
+	IsRegex     bool   `json:"isRegex,omitempty"`
+	Privilege   string `json:"privilege"`
+}
+
+// ExportMetadata writes the databases, retention policies, shard group
+// layouts, continuous queries and users of the cluster to w as JSON. Objects
+// are sorted by name, and shard groups by start time, so exports of the same
+// metadata are identical.
+func (s *Server) ExportMetadata(w io.Writer) error {
+	s.mu.RLock()
+	m := &Metadata{Version: MetadataVersion}
+	for _, db := range s.databases {
+		dm := &DatabaseMetadata{
+			Name:                   db.name,
+			DefaultRetentionPolicy: db.defaultRetentionPolicy,
+			RetentionPolicies:      []*RetentionPolicyMetadata{},
+			ContinuousQueries:      []*ContinuousQueryMetadata{},
+		}
+		for _, rp := range db.policies {
+			rm := &RetentionPolicyMetadata{
+				Name:               rp.Name,
+				Duration:           rp.Duration.String(),
+				ShardGroupDuration: rp.ShardGroupDuration.String(),
+				ReplicaN:           rp.ReplicaN,
+				ShardGroups:        []*ShardGroupMetadata{},
+			}
+			for _, g := range rp.shardGroups {
+				gm := &ShardGroupMetadata{ID: g.ID, StartTime: g.StartTime, EndTime: g.EndTime, Shards: []*ShardMetadata{}}
+				for _, sh := range g.Shards {
+					gm.Shards = append(gm.Shards, &ShardMetadata{ID: sh.ID, DataNodeIDs: sh.DataNodeIDs})
+				}
+				rm.ShardGroups = append(rm.ShardGroups, gm)
+			}
+			sort.Sort(shardGroupMetadatas(rm.ShardGroups))
+			dm.RetentionPolicies = append(dm.RetentionPolicies, rm)
+		}
+		sort.Sort(retentionPolicyMetadatas(dm.RetentionPolicies))
+		for _, cq := range db.continuousQueries {
+			dm.ContinuousQueries = append(dm.ContinuousQueries, &ContinuousQueryMetadata{Query: cq.Query, Disabled: cq.Disabled})
+		}
+		m.Databases = append(m.Databases, dm)
+	}
+	for _, u := range s.users {
+		um := &UserMetadata{Name: u.Name, Hash: u.Hash, Admin: u.Admin}
+		for name, p := range u.Privileges {
+			if um.Privileges == nil {
+				um.Privileges = make(map[string]string)
+			}
+			um.Privileges[name] = p.String()
+		}
+		for name, a := range u.MeasurementPrivileges {
+			for _, p := range a {
+				um.MeasurementPrivileges = append(um.MeasurementPrivileges, &MeasurementPrivilegeMetadata{
+					Database:    name,
+					Measurement: p.Measurement,
+					IsRegex:     p.IsRegex,
+					Privilege:   p.Privilege.String(),
+				})
+			}
+		}
+		sort.Sort(measurementPrivilegeMetadatas(um.MeasurementPrivileges))
+		m.Users = append(m.Users, um)
+	}
+	s.mu.RUnlock()
+
+	sort.Sort(databaseMetadatas(m.Databases))
+	sort.Sort(userMetadatas(m.Users))
+
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+// ImportMetadata creates the databases, retention policies, shard groups,
+// continuous queries and users of a document written by ExportMetadata.
+// Objects that already exist are left unchanged so an interrupted import can
+// be repeated. Shard groups are created for the same time ranges but their
+// ids and owners are assigned by this cluster.
+func (s *Server) ImportMetadata(r io.Reader) error {
+	var m Metadata
+	if err := json.NewDecoder(r).Decode(&m); err != nil {
+		return err
+	} else if m.Version != MetadataVersion {
+		return ErrMetadataVersion
+	}
+
+	// Create every database before the continuous queries that write into them.
+	for _, dm := range m.Databases {
+		created := true
+		if err := s.CreateDatabase(dm.Name); err == ErrDatabaseExists {
+			created = false
+		} else if err != nil {
+			return fmt.Errorf("database %s: %s", dm.Name, err)
+		}
+
+		for _, rm := range dm.RetentionPolicies {
+			if err := s.importRetentionPolicy(dm.Name, rm); err != nil {
+				return fmt.Errorf("retention policy %s.%s: %s", dm.Name, rm.Name, err)
+			}
+		}
+
+		if created && dm.DefaultRetentionPolicy != "" {
+			if err := s.SetDefaultRetentionPolicy(dm.Name, dm.DefaultRetentionPolicy); err != nil {
+				return fmt.Errorf("database %s: %s", dm.Name, err)
+			}
+		}
+	}
+
+	for _, dm := range m.Databases {
+		for _, cm := range dm.ContinuousQueries {
+			if err := s.importContinuousQuery(cm); err != nil {
+				return fmt.Errorf("continuous query %q: %s", cm.Query, err)
+			}
+		}
+	}
+
+	for _, um := range m.Users {
+		if err := s.importUser(um); err != nil {
+			return fmt.Errorf("user %s: %s", um.Name, err)
+		}
+	}
+	return nil
+}
+
+// importRetentionPolicy creates a retention policy, unless it exists, and its shard groups.
+func (s *Server) importRetentionPolicy(database string, rm *RetentionPolicyMetadata) error {
+	d, err := time.ParseDuration(rm.Duration)
+	if err != nil {
+		return err
+	}
+	sgd, err := time.ParseDuration(rm.ShardGroupDuration)
+	if err != nil {
+		return err
+	}
+
+	rp := &RetentionPolicy{Name: rm.Name, Duration: d, ShardGroupDuration: sgd, ReplicaN: rm.ReplicaN}
+	if err := s.CreateRetentionPolicy(database, rp); err != nil && err != ErrRetentionPolicyExists {
+		return err
+	}
+
+	for _, gm := range rm.ShardGroups {
+		if err := s.CreateShardGroupIfNotExists(database, rm.Name, gm.StartTime); err != nil {
+			return fmt.Errorf("shard group %d: %s", gm.ID, err)
+		}
+	}
+	return nil
+}
+
+// importContinuousQuery creates a continuous query unless it exists.
+func (s *Server) importContinuousQuery(cm *ContinuousQueryMetadata) error {
+	cq, err := NewContinuousQuery(cm.Query)
+	if err != nil {
+		return err
+	}
+
+	c := &createContinuousQueryCommand{Query: cm.Query}
+	if _, err := s.broadcast(createContinuousQueryMessageType, c); err == ErrContinuousQueryExists {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if cm.Disabled {
+		return s.SetContinuousQueryEnabled(cq.cq.Database, cq.cq.Name, false)
+	}
+	return nil
+}
+
+// importUser creates a user with its password hash and privileges unless it exists.
+func (s *Server) importUser(um *UserMetadata) error {
+	c := &createUserCommand{Username: um.Name, Hash: um.Hash, Admin: um.Admin}
+	if _, err := s.broadcast(createUserMessageType, c); err == ErrUserExists {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	for database, name := range um.Privileges {
+		p, err := parseMetadataPrivilege(name)
+		if err != nil {
+			return err
+		} else if err := s.SetPrivilege(p, um.Name, database); err != nil {
+			return err
+		}
+	}
+	for _, pm := range um.MeasurementPrivileges {
+		p, err := parseMetadataPrivilege(pm.Privilege)
+		if err != nil {
+			return err
+		} else if err := s.SetMeasurementPrivilege(p, um.Name, pm.Database, pm.Measurement, pm.IsRegex); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseMetadataPrivilege returns the privilege with a name written by ExportMetadata.
+func parseMetadataPrivilege(name string) (influxql.Privilege, error) {
+	for _, p := range []influxql.Privilege{influxql.NoPrivileges, influxql.ReadPrivilege, influxql.WritePrivilege, influxql.AllPrivileges} {
+		if p.String() == name {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid privilege: %s", name)
+}
+
+type databaseMetadatas []*DatabaseMetadata
+
+func (a databaseMetadatas) Len() int           { return len(a) }
+func (a databaseMetadatas) Less(i, j int) bool { return a[i].Name < a[j].Name }
+func (a databaseMetadatas) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+
+type retentionPolicyMetadatas []*RetentionPolicyMetadata
+
+func (a retentionPolicyMetadatas) Len() int           { return len(a) }
+func (a retentionPolicyMetadatas) Less(i, j int) bool { return a[i].Name < a[j].Name }
+func (a retentionPolicyMetadatas) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+
+type shardGroupMetadatas []*ShardGroupMetadata
+
+func (a shardGroupMetadatas) Len() int           { return len(a) }
+func (a shardGroupMetadatas) Less(i, j int) bool { return a[i].StartTime.Before(a[j].StartTime) }
+func (a shardGroupMetadatas) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+
+type userMetadatas []*UserMetadata
+
+func (a userMetadatas) Len() int           { return len(a) }
+func (a userMetadatas) Less(i, j int) bool { return a[i].Name < a[j].Name }
+func (a userMetadatas) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+
+type measurementPrivilegeMetadatas []*MeasurementPrivilegeMetadata
+
+func (a measurementPrivilegeMetadatas) Len() int      { return len(a) }
+func (a measurementPrivilegeMetadatas) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a measurementPrivilegeMetadatas) Less(i, j int) bool {
+	if a[i].Database != a[j].Database {
+		return a[i].Database < a[j].Database
+	}
+	return a[i].Measurement < a[j].Measurement
+}
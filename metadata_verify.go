@@ -0,0 +1,293 @@
+package influxdb
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/influxdb/influxdb/messaging"
+)
+
+// Types of problems reported by VerifyMetadata.
+const (
+	// A shard is listed in more than one shard group.
+	SharedShardProblem = "shared shard"
+
+	// A shard data file exists for a shard this data node doesn't own.
+	OrphanedShardFileProblem = "orphaned shard file"
+
+	// Series are stored under a measurement that doesn't exist.
+	OrphanedSeriesProblem = "orphaned series"
+
+	// A database's default retention policy doesn't exist.
+	MissingDefaultRetentionPolicyProblem = "missing default retention policy"
+)
+
+// MetadataReport lists the problems found by VerifyMetadata.
+type MetadataReport struct {
+	Problems []*MetadataProblem `json:"problems"`
+
+	// True if the problems were repaired.
+	Repaired bool `json:"repaired,omitempty"`
+}
+
+// OK returns true if no problems were found.
+func (r *MetadataReport) OK() bool { return len(r.Problems) == 0 }
+
+// MetadataProblem describes a single inconsistency in the metadata.
+type MetadataProblem struct {
+	Type                   string   `json:"type"`
+	Database               string   `json:"database,omitempty"`
+	DefaultRetentionPolicy string   `json:"defaultRetentionPolicy,omitempty"`
+	Measurement            string   `json:"measurement,omitempty"`
+	SeriesN                int      `json:"seriesN,omitempty"`
+	ShardID                uint64   `json:"shardID,omitempty"`
+	ShardGroupIDs          []uint64 `json:"shardGroupIDs,omitempty"`
+	Path                   string   `json:"path,omitempty"`
+}
+
+// String returns a human readable description of the problem.
+func (p *MetadataProblem) String() string {
+	switch p.Type {
+	case SharedShardProblem:
+		return fmt.Sprintf("shard %d is in shard groups %v", p.ShardID, p.ShardGroupIDs)
+	case OrphanedShardFileProblem:
+		return fmt.Sprintf("shard file %s has no owned shard", p.Path)
+	case OrphanedSeriesProblem:
+		return fmt.Sprintf("%d series in database %q belong to missing measurement %q", p.SeriesN, p.Database, p.Measurement)
+	case MissingDefaultRetentionPolicyProblem:
+		return fmt.Sprintf("default retention policy %q of database %q not found", p.DefaultRetentionPolicy, p.Database)
+	}
+	return p.Type
+}
+
+type repairMetadataCommand struct{}
+
+// VerifyMetadata cross-checks the metadata for inconsistencies left by bugs
+// or interrupted changes. Every shard must belong to exactly one shard group,
+// every stored series must belong to an existing measurement and each
+// database's default retention policy must exist. Shard data files on this
+// data node must belong to a shard it owns.
+//
+// Series are created before their measurement's fields so a measurement that
+// is being written to for the first time can briefly appear orphaned.
+func (s *Server) VerifyMetadata() (*MetadataReport, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.verifyMetadata()
+}
+
+// RepairMetadata verifies the metadata and repairs the problems it finds.
+// A shard listed in several groups is kept in the group with the lowest id,
+// orphaned series are deleted, a missing default retention policy is unset and
+// orphaned shard files are removed. Repairs are made through the broker so
+// every data node makes the same changes to its metadata.
+func (s *Server) RepairMetadata() (*MetadataReport, error) {
+	r, err := s.VerifyMetadata()
+	if err != nil || r.OK() {
+		return r, err
+	}
+	if _, err := s.broadcast(repairMetadataMessageType, &repairMetadataCommand{}); err != nil {
+		return nil, err
+	}
+	r.Repaired = true
+	return r, nil
+}
+
+// verifyMetadata returns the problems found in the metadata. Lock must be held.
+func (s *Server) verifyMetadata() (*MetadataReport, error) {
+	r := &MetadataReport{Problems: make([]*MetadataProblem, 0)}
+
+	// Find shards that are in more than one group.
+	groupIDs := make(map[uint64][]uint64)
+	for _, db := range s.databases {
+		for _, rp := range db.policies {
+			for _, g := range rp.shardGroups {
+				for _, sh := range g.Shards {
+					groupIDs[sh.ID] = append(groupIDs[sh.ID], g.ID)
+				}
+			}
+		}
+	}
+	var shardIDs []uint64
+	for id, a := range groupIDs {
+		if len(a) > 1 {
+			shardIDs = append(shardIDs, id)
+		}
+	}
+	sort.Sort(uint64Slice(shardIDs))
+	for _, id := range shardIDs {
+		a := groupIDs[id]
+		sort.Sort(uint64Slice(a))
+		r.Problems = append(r.Problems, &MetadataProblem{Type: SharedShardProblem, ShardID: id, ShardGroupIDs: a})
+	}
+
+	// Find shard files that don't belong to an owned shard.
+	paths, err := s.orphanedShardFiles()
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range paths {
+		r.Problems = append(r.Problems, &MetadataProblem{Type: OrphanedShardFileProblem, Path: path})
+	}
+
+	// Find orphaned series and missing default policies in name order.
+	var names []string
+	for name := range s.databases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if err := s.meta.view(func(tx *metatx) error {
+		for _, name := range names {
+			db := s.databases[name]
+			for _, m := range tx.orphanedSeriesMeasurements(name) {
+				r.Problems = append(r.Problems, &MetadataProblem{Type: OrphanedSeriesProblem, Database: name, Measurement: m, SeriesN: tx.seriesN(name, m)})
+			}
+			if db.defaultRetentionPolicy != "" && db.policies[db.defaultRetentionPolicy] == nil {
+				r.Problems = append(r.Problems, &MetadataProblem{Type: MissingDefaultRetentionPolicyProblem, Database: name, DefaultRetentionPolicy: db.defaultRetentionPolicy})
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// orphanedShardFiles returns the paths of the shard data files that don't
+// belong to a shard owned by this data node. Lock must be held.
+func (s *Server) orphanedShardFiles() ([]string, error) {
+	if s.path == "" {
+		return nil, nil
+	}
+	fis, err := ioutil.ReadDir(filepath.Join(s.path, "shards"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var a []string
+	for _, fi := range fis {
+		id, err := strconv.ParseUint(fi.Name(), 10, 64)
+		if err != nil || fi.IsDir() {
+			continue
+		}
+		if sh := s.shards[id]; sh == nil || !sh.HasDataNodeID(s.id) {
+			a = append(a, s.shardPath(id))
+		}
+	}
+	return a, nil
+}
+
+// applyRepairMetadata repairs the problems found in the metadata when the
+// message is applied so every data node repairs the same state.
+func (s *Server) applyRepairMetadata(m *messaging.Message) error {
+	var c repairMetadataCommand
+	mustDecodeCommand(m.Data, &c)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, err := s.verifyMetadata()
+	if err != nil {
+		return err
+	}
+
+	for _, p := range r.Problems {
+		log.Printf("repair metadata: %s", p)
+		switch p.Type {
+		case SharedShardProblem:
+			err = s.repairSharedShard(p.ShardID, p.ShardGroupIDs[0])
+		case OrphanedShardFileProblem:
+			err = os.Remove(p.Path)
+		case OrphanedSeriesProblem:
+			db := s.databases[p.Database]
+			db.DropMeasurement(p.Measurement)
+			err = s.meta.mustUpdate(func(tx *metatx) error {
+				return tx.deleteMeasurementSeries(p.Database, p.Measurement)
+			})
+		case MissingDefaultRetentionPolicyProblem:
+			db := s.databases[p.Database]
+			db.defaultRetentionPolicy = ""
+			err = s.meta.mustUpdate(func(tx *metatx) error {
+				return tx.saveDatabaseMeta(db)
+			})
+		}
+		if err != nil {
+			return fmt.Errorf("repair %s: %s", p.Type, err)
+		}
+	}
+
+	// Cached results may include removed shards or series.
+	s.queryCache.clear()
+	return nil
+}
+
+// repairSharedShard removes a shard from every group except the one with the
+// given id. Groups left empty are removed. Lock must be held.
+func (s *Server) repairSharedShard(shardID, groupID uint64) error {
+	var kept *Shard
+	for _, db := range s.databases {
+		for _, rp := range db.policies {
+			var changed []*ShardGroup
+			for _, g := range rp.shardGroups {
+				for i := 0; i < len(g.Shards); i++ {
+					sh := g.Shards[i]
+					if sh.ID != shardID {
+						continue
+					} else if g.ID == groupID {
+						kept = sh
+						continue
+					}
+
+					// Only one copy of a shard can have its store open.
+					if sh.hasStore() {
+						sh.close()
+					}
+					g.Shards = append(g.Shards[:i], g.Shards[i+1:]...)
+					i--
+					changed = append(changed, g)
+				}
+			}
+
+			// Save the changed groups, removing those left empty.
+			if len(changed) == 0 {
+				continue
+			}
+			for _, g := range changed {
+				if len(g.Shards) == 0 {
+					rp.removeShardGroupByID(g.ID)
+				}
+			}
+			if err := s.meta.mustUpdate(func(tx *metatx) error {
+				for _, g := range changed {
+					if len(g.Shards) == 0 {
+						if err := tx.deleteShardGroup(db.name, rp.Name, g.ID); err != nil {
+							return err
+						}
+					} else if err := tx.saveShardGroup(db.name, rp.Name, g); err != nil {
+						return err
+					}
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Point the server's shard at the copy that was kept.
+	s.shards[shardID] = kept
+	if kept.HasDataNodeID(s.id) && !kept.hasStore() {
+		if err := s.openShard(kept); err != nil {
+			return fmt.Errorf("open shard: %s", err)
+		}
+	}
+	return nil
+}
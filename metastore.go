@@ -1,26 +1,95 @@
 package influxdb
 
 import (
+	"crypto/sha256"
 	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"sync"
 	"time"
 	"unsafe"
-
-	"github.com/boltdb/bolt"
 )
 
+// MetaStore represents the storage backend for a server's metadata. Metadata
+// is kept as keys & values in named buckets, which can be nested. The default
+// backend stores a bolt data file at the metastore path.
+type MetaStore interface {
+	// Opens the store at path. When a data node joins a cluster, a copy written
+	// by MetaTx.WriteTo on another data node is opened in place of its store.
+	Open(path string) error
+	Close() error
+
+	// Execute fn within a read-only or read-write transaction. Changes made by
+	// a read-write transaction are discarded if fn returns an error.
+	View(fn func(MetaTx) error) error
+	Update(fn func(MetaTx) error) error
+}
+
+// MetaTx represents a transaction on a MetaStore.
+type MetaTx interface {
+	// Returns a top-level bucket by name. Returns nil if it doesn't exist.
+	Bucket(name []byte) MetaBucket
+	CreateBucketIfNotExists(name []byte) (MetaBucket, error)
+
+	// Calls fn for each top-level bucket in name order.
+	ForEach(fn func(name []byte, b MetaBucket) error) error
+
+	// Size returns the number of bytes written by WriteTo.
+	Size() int64
+
+	// Writes a consistent copy of the store that can be opened by Open.
+	WriteTo(w io.Writer) (n int64, err error)
+}
+
+// MetaBucket represents a bucket of keys & values within a MetaTx.
+type MetaBucket interface {
+	Get(key []byte) []byte
+	Put(key, value []byte) error
+	Delete(key []byte) error
+
+	// Returns a nested bucket by name. Returns nil if it doesn't exist.
+	Bucket(name []byte) MetaBucket
+	CreateBucketIfNotExists(name []byte) (MetaBucket, error)
+	DeleteBucket(name []byte) error
+
+	// Returns an autoincrementing integer for the bucket.
+	NextSequence() (uint64, error)
+
+	// Returns the last integer returned by NextSequence.
+	Sequence() uint64
+
+	// Calls fn for each key in order. The value is nil for nested buckets.
+	ForEach(fn func(k, v []byte) error) error
+}
+
+// MetaStoreCompacter is implemented by a MetaStore that can reclaim the space
+// left unused by earlier changes.
+type MetaStoreCompacter interface {
+	// Returns the size of the store before and after compaction.
+	Compact() (before, after int64, err error)
+}
+
 // metastore represents the low-level data store for metadata.
 type metastore struct {
-	db *bolt.DB
+	mu    sync.RWMutex // held exclusively while the store is compacted
+	store MetaStore
+	stats *Stats // transaction metrics, nil if not recorded
+}
+
+// newMetastore returns a metastore backed by store.
+func newMetastore(store MetaStore) *metastore {
+	return &metastore{store: store}
 }
 
 // open initializes the metastore.
 func (m *metastore) open(path string) error {
-	// Open the bolt-backed database.
-	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 1 * time.Second})
-	if err != nil {
+	// Open the backing store.
+	if err := m.store.Open(path); err != nil {
 		return err
 	}
-	m.db = db
 
 	// Initialize the metastore.
 	if err := m.init(); err != nil {
@@ -32,31 +101,87 @@ func (m *metastore) open(path string) error {
 
 // close closes the store.
 func (m *metastore) close() error {
-	if m.db != nil {
-		return m.db.Close()
+	if m.store != nil {
+		return m.store.Close()
 	}
 	return nil
 }
 
-// init initializes the metastore to ensure all top-level buckets are created.
+// init initializes the metastore to ensure all top-level buckets are created
+// and databases are stored in the current layout.
 func (m *metastore) init() error {
-	return m.db.Update(func(tx *bolt.Tx) error {
+	return m.store.Update(func(tx MetaTx) error {
 		_, _ = tx.CreateBucketIfNotExists([]byte("Meta"))
 		_, _ = tx.CreateBucketIfNotExists([]byte("DataNodes"))
 		_, _ = tx.CreateBucketIfNotExists([]byte("Databases"))
 		_, _ = tx.CreateBucketIfNotExists([]byte("Users"))
-		return nil
+		_, _ = tx.CreateBucketIfNotExists([]byte("Roles"))
+		_, _ = tx.CreateBucketIfNotExists([]byte("APITokens"))
+		return (&metatx{MetaTx: tx, stats: m.stats}).migrateDatabases()
 	})
 }
 
 // view executes a function in the context of a read-only transaction.
 func (m *metastore) view(fn func(*metatx) error) error {
-	return m.db.View(func(tx *bolt.Tx) error { return fn(&metatx{tx}) })
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	defer m.observe("metastoreView", time.Now())
+	return m.store.View(func(tx MetaTx) error { return fn(&metatx{MetaTx: tx, stats: m.stats}) })
 }
 
 // update executes a function in the context of a read-write transaction.
+// The size of the store is recorded after each change.
 func (m *metastore) update(fn func(*metatx) error) error {
-	return m.db.Update(func(tx *bolt.Tx) error { return fn(&metatx{tx}) })
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	defer m.observe("metastoreUpdate", time.Now())
+	return m.store.Update(func(tx MetaTx) error {
+		if err := fn(&metatx{MetaTx: tx, stats: m.stats}); err != nil {
+			return err
+		}
+		if m.stats != nil {
+			m.stats.Set("metastoreSize", tx.Size())
+		}
+		return nil
+	})
+}
+
+// observe records the duration of a transaction started at start.
+func (m *metastore) observe(key string, start time.Time) {
+	if m.stats != nil {
+		m.stats.Observe(key, time.Since(start))
+	}
+}
+
+// snapshot writes a consistent copy of the metastore to a new file at path.
+func (m *metastore) snapshot(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := m.view(func(tx *metatx) error {
+		_, err := tx.WriteTo(f)
+		return err
+	}); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// compact reclaims unused space in the store if the backend supports it.
+// Returns the size of the store before and after, which are both zero if the
+// backend can't be compacted.
+func (m *metastore) compact() (before, after int64, err error) {
+	c, ok := m.store.(MetaStoreCompacter)
+	if !ok {
+		return 0, 0, nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return c.Compact()
 }
 
 // mustView executes a function in the context of a read-only transaction.
@@ -85,7 +210,8 @@ func (m *metastore) mustUpdate(fn func(*metatx) error) (err error) {
 
 // metatx represents a metastore transaction.
 type metatx struct {
-	*bolt.Tx
+	MetaTx
+	stats *Stats // nil if not recorded
 }
 
 // id returns the server id.
@@ -101,6 +227,57 @@ func (tx *metatx) setID(v uint64) error {
 	return tx.Bucket([]byte("Meta")).Put([]byte("id"), u64tob(v))
 }
 
+// topicIndexes returns the index of the last message the server applied from
+// each broker topic.
+func (tx *metatx) topicIndexes() map[uint64]uint64 {
+	m := make(map[uint64]uint64)
+	if v := tx.Bucket([]byte("Meta")).Get([]byte("topicIndexes")); v != nil {
+		mustUnmarshalJSON(v, &m)
+	}
+	return m
+}
+
+// setTopicIndexes sets the index of the last message applied from each topic.
+func (tx *metatx) setTopicIndexes(m map[uint64]uint64) error {
+	return tx.Bucket([]byte("Meta")).Put([]byte("topicIndexes"), mustMarshalJSON(m))
+}
+
+// digest returns a hash of the metadata that is shared by every node in the
+// cluster. The server id and topic indexes are excluded since they differ on
+// each node.
+func (tx *metatx) digest() string {
+	h := sha256.New()
+	_ = tx.ForEach(func(name []byte, b MetaBucket) error {
+		writeDigestValue(h, name)
+		digestBucket(h, b, string(name) == "Meta")
+		return nil
+	})
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// digestBucket writes the keys & values of a bucket and its nested buckets to h.
+func digestBucket(h hash.Hash, b MetaBucket, meta bool) {
+	_ = b.ForEach(func(k, v []byte) error {
+		if meta && (string(k) == "id" || string(k) == "topicIndexes") {
+			return nil
+		}
+		writeDigestValue(h, k)
+		if v == nil {
+			digestBucket(h, b.Bucket(k), false)
+			return nil
+		}
+		writeDigestValue(h, v)
+		return nil
+	})
+	writeDigestValue(h, nil)
+}
+
+// writeDigestValue writes a length-prefixed value to h.
+func writeDigestValue(h hash.Hash, v []byte) {
+	h.Write(u64tob(uint64(len(v))))
+	h.Write(v)
+}
+
 // mustNextSequence generates a new sequence for a key in the meta bucket.
 func (tx *metatx) mustNextSequence(key []byte) (id uint64) {
 	// Retrieve the previous value, if it exists.
@@ -129,12 +306,12 @@ func (tx *metatx) nextShardGroupID() (id uint64) {
 
 // dataNodes returns a list of all data nodes from the metastore.
 func (tx *metatx) dataNodes() (a []*DataNode) {
-	c := tx.Bucket([]byte("DataNodes")).Cursor()
-	for k, v := c.First(); k != nil; k, v = c.Next() {
+	_ = tx.Bucket([]byte("DataNodes")).ForEach(func(k, v []byte) error {
 		n := newDataNode()
 		mustUnmarshalJSON(v, &n)
 		a = append(a, n)
-	}
+		return nil
+	})
 	return
 }
 
@@ -155,19 +332,81 @@ func (tx *metatx) deleteDataNode(id uint64) error {
 }
 
 // databases returns a list of all databases from the metastore.
+//
+// Each database is stored in its own bucket. The database's settings are under
+// the "meta" key, each retention policy is a bucket in "Policies" holding its
+// settings and a "ShardGroups" bucket of groups by id, and continuous queries
+// are in "ContinuousQueries" by creation sequence. Each part is saved on its
+// own so a change doesn't rewrite the whole database.
 func (tx *metatx) databases() (a []*database) {
-	c := tx.Bucket([]byte("Databases")).Cursor()
-	for k, _ := c.First(); k != nil; k, _ = c.Next() {
-		b := c.Bucket().Bucket(k)
-		v := b.Get([]byte("meta"))
-		db := newDatabase()
-		mustUnmarshalJSON(v, &db)
-		a = append(a, db)
-	}
+	b := tx.Bucket([]byte("Databases"))
+	_ = b.ForEach(func(k, _ []byte) error {
+		a = append(a, loadDatabase(b.Bucket(k)))
+		return nil
+	})
 	return
 }
 
-// saveDatabase persists a database to the metastore.
+// loadDatabase decodes a database from its bucket.
+func loadDatabase(b MetaBucket) *database {
+	db := newDatabase()
+	mustUnmarshalJSON(b.Get([]byte("meta")), &db)
+
+	if policies := b.Bucket([]byte("Policies")); policies != nil {
+		_ = policies.ForEach(func(k, _ []byte) error {
+			pb := policies.Bucket(k)
+			rp := &RetentionPolicy{}
+			mustUnmarshalJSON(pb.Get([]byte("meta")), rp)
+			_ = pb.Bucket([]byte("ShardGroups")).ForEach(func(_, v []byte) error {
+				g := &ShardGroup{}
+				mustUnmarshalJSON(v, g)
+				rp.shardGroups = append(rp.shardGroups, g)
+				return nil
+			})
+			db.policies[rp.Name] = rp
+			return nil
+		})
+	}
+
+	if cqs := b.Bucket([]byte("ContinuousQueries")); cqs != nil {
+		_ = cqs.ForEach(func(k, v []byte) error {
+			var o ContinuousQuery
+			mustUnmarshalJSON(v, &o)
+			cq := loadContinuousQuery(&o)
+			cq.seq = btou64(k)
+			db.continuousQueries = append(db.continuousQueries, cq)
+			return nil
+		})
+	}
+	return db
+}
+
+// migrateDatabases moves the retention policies, shard groups and continuous
+// queries of databases saved as a single value into their own keys.
+func (tx *metatx) migrateDatabases() error {
+	b := tx.Bucket([]byte("Databases"))
+	var a []*database
+	_ = b.ForEach(func(k, _ []byte) error {
+		var o databaseJSON
+		mustUnmarshalJSON(b.Bucket(k).Get([]byte("meta")), &o)
+		if len(o.Policies) > 0 || len(o.ContinuousQueries) > 0 {
+			db := newDatabase()
+			mustUnmarshalJSON(b.Bucket(k).Get([]byte("meta")), &db)
+			a = append(a, db)
+		}
+		return nil
+	})
+
+	for _, db := range a {
+		if err := tx.saveDatabase(db); err != nil {
+			return fmt.Errorf("migrate database %s: %s", db.name, err)
+		}
+	}
+	return nil
+}
+
+// saveDatabase persists a whole database to the metastore, replacing its
+// retention policies, shard groups and continuous queries.
 func (tx *metatx) saveDatabase(db *database) error {
 	b, err := tx.Bucket([]byte("Databases")).CreateBucketIfNotExists([]byte(db.name))
 	if err != nil {
@@ -176,7 +415,41 @@ func (tx *metatx) saveDatabase(db *database) error {
 	_, _ = b.CreateBucketIfNotExists([]byte("TagBytesToID"))
 	_, _ = b.CreateBucketIfNotExists([]byte("Measurements"))
 	_, _ = b.CreateBucketIfNotExists([]byte("Series"))
-	return b.Put([]byte("meta"), mustMarshalJSON(db))
+	if tx.stats != nil {
+		tx.stats.Inc("metastoreSaveDatabase")
+	}
+
+	// Replace the policies and continuous queries.
+	for _, name := range []string{"Policies", "ContinuousQueries"} {
+		if b.Bucket([]byte(name)) != nil {
+			if err := b.DeleteBucket([]byte(name)); err != nil {
+				return err
+			}
+		}
+	}
+	for _, rp := range db.policies {
+		if err := tx.saveRetentionPolicy(db.name, rp); err != nil {
+			return err
+		}
+		for _, g := range rp.shardGroups {
+			if err := tx.saveShardGroup(db.name, rp.Name, g); err != nil {
+				return err
+			}
+		}
+	}
+	for _, cq := range db.continuousQueries {
+		cq.seq = 0
+		if err := tx.saveContinuousQuery(db.name, cq); err != nil {
+			return err
+		}
+	}
+
+	return tx.saveDatabaseMeta(db)
+}
+
+// saveDatabaseMeta persists the settings of a database.
+func (tx *metatx) saveDatabaseMeta(db *database) error {
+	return tx.put(tx.Bucket([]byte("Databases")).Bucket([]byte(db.name)), []byte("meta"), mustMarshalJSON(db))
 }
 
 // deleteDatabase removes database from the metastore.
@@ -184,6 +457,83 @@ func (tx *metatx) deleteDatabase(name string) error {
 	return tx.Bucket([]byte("Databases")).DeleteBucket([]byte(name))
 }
 
+// saveRetentionPolicy persists the settings of a retention policy.
+// Its shard groups are saved separately.
+func (tx *metatx) saveRetentionPolicy(database string, rp *RetentionPolicy) error {
+	policies, err := tx.Bucket([]byte("Databases")).Bucket([]byte(database)).CreateBucketIfNotExists([]byte("Policies"))
+	if err != nil {
+		return err
+	}
+	b, err := policies.CreateBucketIfNotExists([]byte(rp.Name))
+	if err != nil {
+		return err
+	}
+	if _, err := b.CreateBucketIfNotExists([]byte("ShardGroups")); err != nil {
+		return err
+	}
+
+	v := mustMarshalJSON(&retentionPolicyJSON{
+		Name:               rp.Name,
+		ReplicaN:           rp.ReplicaN,
+		Duration:           rp.Duration,
+		ShardGroupDuration: rp.ShardGroupDuration,
+		Compression:        rp.Compression,
+	})
+	return tx.put(b, []byte("meta"), v)
+}
+
+// deleteRetentionPolicy removes a retention policy and its shard groups.
+func (tx *metatx) deleteRetentionPolicy(database, name string) error {
+	return tx.Bucket([]byte("Databases")).Bucket([]byte(database)).Bucket([]byte("Policies")).DeleteBucket([]byte(name))
+}
+
+// saveShardGroup persists a shard group of a retention policy.
+func (tx *metatx) saveShardGroup(database, policy string, g *ShardGroup) error {
+	b := tx.shardGroupsBucket(database, policy)
+	if tx.stats != nil {
+		tx.stats.Inc("metastoreSaveShardGroup")
+	}
+	return tx.put(b, u64tob(g.ID), mustMarshalJSON(g))
+}
+
+// deleteShardGroup removes a shard group of a retention policy.
+func (tx *metatx) deleteShardGroup(database, policy string, id uint64) error {
+	return tx.shardGroupsBucket(database, policy).Delete(u64tob(id))
+}
+
+// shardGroupsBucket returns the bucket of a retention policy's shard groups.
+func (tx *metatx) shardGroupsBucket(database, policy string) MetaBucket {
+	return tx.Bucket([]byte("Databases")).Bucket([]byte(database)).Bucket([]byte("Policies")).Bucket([]byte(policy)).Bucket([]byte("ShardGroups"))
+}
+
+// saveContinuousQuery persists a continuous query. A new query is assigned
+// the next sequence of the database so queries load in creation order.
+func (tx *metatx) saveContinuousQuery(database string, cq *ContinuousQuery) error {
+	b, err := tx.Bucket([]byte("Databases")).Bucket([]byte(database)).CreateBucketIfNotExists([]byte("ContinuousQueries"))
+	if err != nil {
+		return err
+	}
+	if cq.seq == 0 {
+		if cq.seq, err = b.NextSequence(); err != nil {
+			return err
+		}
+	}
+	return tx.put(b, u64tob(cq.seq), mustMarshalJSON(cq))
+}
+
+// deleteContinuousQuery removes a continuous query.
+func (tx *metatx) deleteContinuousQuery(database string, cq *ContinuousQuery) error {
+	return tx.Bucket([]byte("Databases")).Bucket([]byte(database)).Bucket([]byte("ContinuousQueries")).Delete(u64tob(cq.seq))
+}
+
+// put writes a metadata value to a bucket and counts the bytes written.
+func (tx *metatx) put(b MetaBucket, key, v []byte) error {
+	if tx.stats != nil {
+		tx.stats.Add("metastoreBytesWritten", int64(len(v)))
+	}
+	return b.Put(key, v)
+}
+
 // saveMeasurement persists a measurement to the metastore.
 func (tx *metatx) saveMeasurement(database string, m *Measurement) error {
 	b := tx.Bucket([]byte("Databases")).Bucket([]byte(database)).Bucket([]byte("Measurements"))
@@ -214,30 +564,121 @@ func (tx *metatx) createSeries(database, name string, tags map[string]string) (*
 	return s, nil
 }
 
-// loops through all the measurements and series in a database
-func (tx *metatx) indexDatabase(db *database) {
+// lastSeriesID returns the id of the last series created in a database.
+func (tx *metatx) lastSeriesID(database string) uint32 {
+	return uint32(tx.Bucket([]byte("Databases")).Bucket([]byte(database)).Bucket([]byte("Series")).Sequence())
+}
+
+// orphanedSeriesMeasurements returns the names that series are stored under
+// in a database which have no saved measurement.
+func (tx *metatx) orphanedSeriesMeasurements(database string) (a []string) {
+	b := tx.Bucket([]byte("Databases")).Bucket([]byte(database))
+	if b == nil {
+		return nil
+	}
+	measurements := b.Bucket([]byte("Measurements"))
+	_ = b.Bucket([]byte("Series")).ForEach(func(k, _ []byte) error {
+		if measurements.Get(k) == nil {
+			a = append(a, string(k))
+		}
+		return nil
+	})
+	return
+}
+
+// seriesN returns the number of series stored under a measurement name.
+func (tx *metatx) seriesN(database, name string) (n int) {
+	b := tx.Bucket([]byte("Databases")).Bucket([]byte(database)).Bucket([]byte("Series")).Bucket([]byte(name))
+	if b == nil {
+		return 0
+	}
+	_ = b.ForEach(func(k, v []byte) error {
+		n++
+		return nil
+	})
+	return
+}
+
+// deleteMeasurementSeries removes the series stored under a measurement name.
+func (tx *metatx) deleteMeasurementSeries(database, name string) error {
+	return tx.Bucket([]byte("Databases")).Bucket([]byte(database)).Bucket([]byte("Series")).DeleteBucket([]byte(name))
+}
+
+// deleteSeries removes series from a measurement in a database.
+func (tx *metatx) deleteSeries(database, name string, ids []uint32) error {
+	b := tx.Bucket([]byte("Databases")).Bucket([]byte(database)).Bucket([]byte("Series")).Bucket([]byte(name))
+	if b == nil {
+		return nil
+	}
+	for _, id := range ids {
+		idBytes := make([]byte, 4)
+		*(*uint32)(unsafe.Pointer(&idBytes[0])) = id
+		if err := b.Delete(idBytes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// databaseIndex holds the encoded series & measurement metadata of a database
+// read from the metastore. The values belong to the transaction they were read
+// in and are only valid until it closes.
+type databaseIndex struct {
+	seriesN      map[string]int // number of series in each measurement, if series aren't read
+	seriesNames  []string       // measurement name of each series
+	series       [][]byte
+	measurements []string
+	fields       [][]byte // encoded metadata of each measurement
+}
+
+// databaseIndex reads the series and measurements of a database. Reading is
+// kept separate from decoding since a transaction can't be shared between the
+// goroutines that build each database's index. If withSeries is false then the
+// series in each measurement are only counted.
+func (tx *metatx) databaseIndex(name string, withSeries bool) *databaseIndex {
+	idx := &databaseIndex{seriesN: make(map[string]int)}
+
 	// get the bucket that holds series data for the database
-	b := tx.Bucket([]byte("Databases")).Bucket([]byte(db.name))
+	b := tx.Bucket([]byte("Databases")).Bucket([]byte(name))
 
-	// Iterate over and index series.
+	// Iterate over series.
 	seriesBucket := b.Bucket([]byte("Series"))
-	c := seriesBucket.Cursor()
-	for k, _ := c.First(); k != nil; k, _ = c.Next() {
-		mc := seriesBucket.Bucket(k).Cursor()
+	_ = seriesBucket.ForEach(func(k, _ []byte) error {
 		name := string(k)
-		for id, v := mc.First(); id != nil; id, v = mc.Next() {
-			var s *Series
-			mustUnmarshalJSON(v, &s)
-			db.addSeriesToIndex(name, s)
-		}
-	}
+		return seriesBucket.Bucket(k).ForEach(func(id, v []byte) error {
+			if !withSeries {
+				idx.seriesN[name]++
+				return nil
+			}
+			idx.seriesNames = append(idx.seriesNames, name)
+			idx.series = append(idx.series, v)
+			return nil
+		})
+	})
 
 	// Iterate over measurement metadata.
-	c = b.Bucket([]byte("Measurements")).Cursor()
-	for k, v := c.First(); k != nil; k, v = c.Next() {
-		m := db.createMeasurementIfNotExists(string(k))
-		mustUnmarshalJSON(v, &m)
+	_ = b.Bucket([]byte("Measurements")).ForEach(func(k, v []byte) error {
+		idx.measurements = append(idx.measurements, string(k))
+		idx.fields = append(idx.fields, v)
+		return nil
+	})
+
+	return idx
+}
+
+// measurementSeries returns the series stored under a measurement name.
+func (tx *metatx) measurementSeries(database, name string) (a []*Series) {
+	b := tx.Bucket([]byte("Databases")).Bucket([]byte(database)).Bucket([]byte("Series")).Bucket([]byte(name))
+	if b == nil {
+		return nil
 	}
+	_ = b.ForEach(func(k, v []byte) error {
+		var s *Series
+		mustUnmarshalJSON(v, &s)
+		a = append(a, s)
+		return nil
+	})
+	return
 }
 
 // user returns a user from the metastore by name.
@@ -250,12 +691,12 @@ func (tx *metatx) user(name string) (u *User) {
 
 // users returns a list of all users from the metastore.
 func (tx *metatx) users() (a []*User) {
-	c := tx.Bucket([]byte("Users")).Cursor()
-	for k, v := c.First(); k != nil; k, v = c.Next() {
+	_ = tx.Bucket([]byte("Users")).ForEach(func(k, v []byte) error {
 		u := &User{}
 		mustUnmarshalJSON(v, &u)
 		a = append(a, u)
-	}
+		return nil
+	})
 	return
 }
 
@@ -269,6 +710,48 @@ func (tx *metatx) deleteUser(name string) error {
 	return tx.Bucket([]byte("Users")).Delete([]byte(name))
 }
 
+// roles returns a list of all roles from the metastore.
+func (tx *metatx) roles() (a []*Role) {
+	_ = tx.Bucket([]byte("Roles")).ForEach(func(k, v []byte) error {
+		r := &Role{}
+		mustUnmarshalJSON(v, &r)
+		a = append(a, r)
+		return nil
+	})
+	return
+}
+
+// saveRole persists a role to the metastore.
+func (tx *metatx) saveRole(r *Role) error {
+	return tx.Bucket([]byte("Roles")).Put([]byte(r.Name), mustMarshalJSON(r))
+}
+
+// deleteRole removes the role from the metastore.
+func (tx *metatx) deleteRole(name string) error {
+	return tx.Bucket([]byte("Roles")).Delete([]byte(name))
+}
+
+// apiTokens returns a list of all API tokens from the metastore.
+func (tx *metatx) apiTokens() (a []*APIToken) {
+	_ = tx.Bucket([]byte("APITokens")).ForEach(func(k, v []byte) error {
+		t := &APIToken{}
+		mustUnmarshalJSON(v, &t)
+		a = append(a, t)
+		return nil
+	})
+	return
+}
+
+// saveAPIToken persists an API token to the metastore.
+func (tx *metatx) saveAPIToken(t *APIToken) error {
+	return tx.Bucket([]byte("APITokens")).Put([]byte(t.ID), mustMarshalJSON(t))
+}
+
+// deleteAPIToken removes the API token from the metastore.
+func (tx *metatx) deleteAPIToken(id string) error {
+	return tx.Bucket([]byte("APITokens")).Delete([]byte(id))
+}
+
 // u64tob converts a uint64 into an 8-byte slice.
 func u64tob(v uint64) []byte {
 	b := make([]byte, 8)
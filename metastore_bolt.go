@@ -0,0 +1,180 @@
+package influxdb
+
+import (
+	"io"
+	"os"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// boltMetaStore is the default MetaStore. It stores metadata in a bolt data file.
+type boltMetaStore struct {
+	db *bolt.DB
+}
+
+// Open opens the bolt data file at path, creating it if it doesn't exist.
+func (m *boltMetaStore) Open(path string) error {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		return err
+	}
+	m.db = db
+	return nil
+}
+
+// Close closes the data file.
+func (m *boltMetaStore) Close() error {
+	if m.db != nil {
+		return m.db.Close()
+	}
+	return nil
+}
+
+// View executes fn within a read-only bolt transaction.
+func (m *boltMetaStore) View(fn func(MetaTx) error) error {
+	return m.db.View(func(tx *bolt.Tx) error { return fn(&boltMetaTx{tx}) })
+}
+
+// Update executes fn within a read-write bolt transaction.
+func (m *boltMetaStore) Update(fn func(MetaTx) error) error {
+	return m.db.Update(func(tx *bolt.Tx) error { return fn(&boltMetaTx{tx}) })
+}
+
+// Compact rewrites the data file into a fresh file, which drops the free
+// pages left by earlier changes, and replaces the data file with it.
+func (m *boltMetaStore) Compact() (before, after int64, err error) {
+	path := m.db.Path()
+	tmpPath := path + ".compact"
+	_ = os.Remove(tmpPath) // left by an interrupted compaction
+	defer os.Remove(tmpPath)
+	if err := writeCompactedBolt(m.db, tmpPath); err != nil {
+		return 0, 0, err
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	before = fi.Size()
+	if fi, err = os.Stat(tmpPath); err != nil {
+		return 0, 0, err
+	}
+	after = fi.Size()
+
+	// Swap in the compacted file. The store can't be used if it fails to reopen.
+	if err := m.db.Close(); err != nil {
+		return 0, 0, err
+	} else if err := os.Rename(tmpPath, path); err != nil {
+		return 0, 0, err
+	}
+	if err := m.Open(path); err != nil {
+		return 0, 0, err
+	}
+	return before, after, nil
+}
+
+// writeCompactedBolt copies every bucket of a bolt data file into a new file
+// at path.
+func writeCompactedBolt(db *bolt.DB, path string) error {
+	dst, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	return db.View(func(tx *bolt.Tx) error {
+		return dst.Update(func(dtx *bolt.Tx) error {
+			return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+				other, err := dtx.CreateBucket(name)
+				if err != nil {
+					return err
+				}
+				return copyBoltBucket(other, b)
+			})
+		})
+	})
+}
+
+// copyBoltBucket copies the keys, values, nested buckets and sequence of src to dst.
+func copyBoltBucket(dst, src *bolt.Bucket) error {
+	if err := dst.SetSequence(src.Sequence()); err != nil {
+		return err
+	}
+	return src.ForEach(func(k, v []byte) error {
+		if v != nil {
+			return dst.Put(k, v)
+		}
+		other, err := dst.CreateBucket(k)
+		if err != nil {
+			return err
+		}
+		return copyBoltBucket(other, src.Bucket(k))
+	})
+}
+
+// boltMetaTx wraps a bolt transaction to implement MetaTx.
+type boltMetaTx struct {
+	tx *bolt.Tx
+}
+
+func (tx *boltMetaTx) Bucket(name []byte) MetaBucket {
+	return newBoltMetaBucket(tx.tx.Bucket(name))
+}
+
+func (tx *boltMetaTx) CreateBucketIfNotExists(name []byte) (MetaBucket, error) {
+	b, err := tx.tx.CreateBucketIfNotExists(name)
+	if err != nil {
+		return nil, err
+	}
+	return newBoltMetaBucket(b), nil
+}
+
+func (tx *boltMetaTx) ForEach(fn func(name []byte, b MetaBucket) error) error {
+	return tx.tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+		return fn(name, newBoltMetaBucket(b))
+	})
+}
+
+func (tx *boltMetaTx) Size() int64 { return tx.tx.Size() }
+
+func (tx *boltMetaTx) WriteTo(w io.Writer) (int64, error) {
+	if err := tx.tx.Copy(w); err != nil {
+		return 0, err
+	}
+	return tx.tx.Size(), nil
+}
+
+// boltMetaBucket wraps a bolt bucket to implement MetaBucket.
+type boltMetaBucket struct {
+	b *bolt.Bucket
+}
+
+// newBoltMetaBucket returns a MetaBucket for b. Returns a nil interface,
+// rather than a nil pointer, if b is nil so callers can compare it to nil.
+func newBoltMetaBucket(b *bolt.Bucket) MetaBucket {
+	if b == nil {
+		return nil
+	}
+	return &boltMetaBucket{b}
+}
+
+func (b *boltMetaBucket) Get(key []byte) []byte          { return b.b.Get(key) }
+func (b *boltMetaBucket) Put(key, value []byte) error    { return b.b.Put(key, value) }
+func (b *boltMetaBucket) Delete(key []byte) error        { return b.b.Delete(key) }
+func (b *boltMetaBucket) Bucket(name []byte) MetaBucket  { return newBoltMetaBucket(b.b.Bucket(name)) }
+func (b *boltMetaBucket) DeleteBucket(name []byte) error { return b.b.DeleteBucket(name) }
+func (b *boltMetaBucket) NextSequence() (uint64, error)  { return b.b.NextSequence() }
+func (b *boltMetaBucket) Sequence() uint64               { return b.b.Sequence() }
+
+func (b *boltMetaBucket) CreateBucketIfNotExists(name []byte) (MetaBucket, error) {
+	other, err := b.b.CreateBucketIfNotExists(name)
+	if err != nil {
+		return nil, err
+	}
+	return newBoltMetaBucket(other), nil
+}
+
+func (b *boltMetaBucket) ForEach(fn func(k, v []byte) error) error {
+	return b.b.ForEach(fn)
+}
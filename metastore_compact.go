@@ -0,0 +1,82 @@
+package influxdb
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// SnapshotMetastore writes a consistent copy of the metastore to a new file at
+// path. The copy can be opened in place of the metastore.
+func (s *Server) SnapshotMetastore(path string) error {
+	s.mu.RLock()
+	meta := s.meta
+	s.mu.RUnlock()
+	return meta.snapshot(path)
+}
+
+// CompactMetastore reclaims the space left unused in the metastore by earlier
+// changes. Metadata changes wait until it finishes. It does nothing if the
+// metastore backend doesn't implement MetaStoreCompacter.
+func (s *Server) CompactMetastore() error {
+	s.mu.RLock()
+	meta := s.meta
+	s.mu.RUnlock()
+
+	before, after, err := meta.compact()
+	if err != nil {
+		return err
+	} else if before == 0 {
+		return nil
+	}
+	log.Printf("compacted metastore from %d to %d bytes", before, after)
+	s.stats.Inc("metastoreCompact")
+	s.stats.Add("metastoreCompactBytes", before-after)
+	return nil
+}
+
+// StartMetastoreCompaction launches a background service that periodically
+// compacts the metastore.
+func (s *Server) StartMetastoreCompaction(checkInterval time.Duration) error {
+	if checkInterval == 0 {
+		return fmt.Errorf("metastore compaction check interval must be non-zero")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.metaCompactDone != nil {
+		return fmt.Errorf("metastore compaction already started")
+	}
+
+	metaCompactDone := make(chan struct{}, 0)
+	s.metaCompactDone = metaCompactDone
+	s.metaCompactWG.Add(1)
+	go func() {
+		defer s.metaCompactWG.Done()
+		for {
+			select {
+			case <-metaCompactDone:
+				return
+			case <-time.After(checkInterval):
+				if err := s.CompactMetastore(); err != nil {
+					log.Printf("metastore compaction: %s", err)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// StopMetastoreCompaction stops the metastore compaction service. It waits
+// for an in-flight compaction to finish before returning.
+func (s *Server) StopMetastoreCompaction() {
+	s.mu.Lock()
+	metaCompactDone := s.metaCompactDone
+	s.metaCompactDone = nil
+	s.mu.Unlock()
+
+	if metaCompactDone != nil {
+		close(metaCompactDone)
+	}
+	s.metaCompactWG.Wait()
+}
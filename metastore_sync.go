@@ -0,0 +1,287 @@
+package influxdb
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// MetastoreDigest represents a hash of a data node's metastore and the broker
+// index that had been applied when the hash was taken.
+type MetastoreDigest struct {
+	Index  uint64 `json:"index"`
+	Digest string `json:"digest"`
+}
+
+// MetastoreDigest returns the digest of the metadata shared by all data nodes.
+// Data nodes that have applied the same index should have identical digests.
+func (s *Server) MetastoreDigest() (*MetastoreDigest, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	d := &MetastoreDigest{Index: s.index}
+	if err := s.meta.view(func(tx *metatx) error {
+		d.Digest = tx.digest()
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// StartMetastoreVerification launches a background service that periodically
+// verifies the local metastore against the other data nodes.
+func (s *Server) StartMetastoreVerification(checkInterval time.Duration) error {
+	if checkInterval == 0 {
+		return fmt.Errorf("metastore verification check interval must be non-zero")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.metaVerifyDone != nil {
+		return fmt.Errorf("metastore verification already started")
+	}
+
+	metaVerifyDone := make(chan struct{}, 0)
+	s.metaVerifyDone = metaVerifyDone
+	s.metaVerifyWG.Add(1)
+	go func() {
+		defer s.metaVerifyWG.Done()
+		for {
+			select {
+			case <-metaVerifyDone:
+				return
+			case <-time.After(checkInterval):
+				if _, err := s.VerifyMetastore(); err != nil {
+					log.Printf("metastore verification: %s", err)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// StopMetastoreVerification stops the metastore verification service. It waits
+// for an in-flight verification to finish before returning.
+func (s *Server) StopMetastoreVerification() {
+	s.mu.Lock()
+	metaVerifyDone := s.metaVerifyDone
+	s.metaVerifyDone = nil
+	s.mu.Unlock()
+
+	if metaVerifyDone != nil {
+		close(metaVerifyDone)
+	}
+	s.metaVerifyWG.Wait()
+}
+
+// VerifyMetastore compares the local metastore digest with the digests of the
+// other data nodes that have applied the same broker index. If a majority of
+// those nodes agree on a different digest then the local metastore missed
+// changes and is replaced with a copy from one of them. Returns true if the
+// metastore was replaced. Unreachable data nodes are skipped.
+func (s *Server) VerifyMetastore() (bool, error) {
+	local, err := s.MetastoreDigest()
+	if err != nil {
+		return false, err
+	}
+
+	// Retrieve the digests of the other data nodes at the same index.
+	type peer struct {
+		url    *url.URL
+		digest *MetastoreDigest
+	}
+	var peers []peer
+	id := s.ID()
+	for _, n := range s.DataNodes() {
+		if n.ID == id {
+			continue
+		}
+		d, e := s.getMetastoreDigest(n.URL)
+		if e != nil {
+			err = fmt.Errorf("data node %d: %s", n.ID, e)
+			continue
+		} else if d.Index != local.Index {
+			continue
+		}
+		peers = append(peers, peer{n.URL, d})
+	}
+
+	// Find the digest shared by a majority of the nodes, including this one.
+	votes := map[string]int{local.Digest: 1}
+	for _, p := range peers {
+		votes[p.digest.Digest]++
+	}
+	var majority string
+	for digest, n := range votes {
+		if n*2 > len(peers)+1 {
+			majority = digest
+		}
+	}
+	if majority == local.Digest {
+		return false, err
+	} else if majority == "" {
+		return false, ErrMetastoreDiverged
+	}
+
+	// Copy the metastore from a node in the majority.
+	for _, p := range peers {
+		if p.digest.Digest != majority {
+			continue
+		}
+		if e := s.resyncMetastore(p.url, p.digest); e != nil {
+			err = fmt.Errorf("resync from %s: %s", p.url, e)
+			continue
+		}
+		return true, nil
+	}
+	return false, err
+}
+
+// getMetastoreDigest requests the metastore digest of another data node.
+func (s *Server) getMetastoreDigest(nodeURL *url.URL) (*MetastoreDigest, error) {
+	u := copyURL(nodeURL)
+	u.Path = "/metastore/digest"
+	resp, err := s.doNodeRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("metastore digest: %s", resp.Status)
+	}
+
+	var d MetastoreDigest
+	if err := json.NewDecoder(resp.Body).Decode(&d); err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// resyncMetastore replaces the local metastore with a copy from another data
+// node. The copy must match the digest the node reported and the local server
+// must not have applied any messages since the digest was taken.
+func (s *Server) resyncMetastore(nodeURL *url.URL, want *MetastoreDigest) error {
+	u := copyURL(nodeURL)
+	u.Path = "/metastore"
+	resp, err := s.doNodeRequest("GET", u, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unsuccessful meta copy: status=%d (%s)", resp.StatusCode, u.String())
+	}
+
+	// Stream the metastore to a temporary file.
+	tmpPath := s.metaPath() + ".sync"
+	defer os.Remove(tmpPath)
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("create meta file: %s", err)
+	}
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("copy meta file: %s", err)
+	}
+	_ = f.Close()
+
+	// Verify the copy is the metastore the digest was taken from.
+	m := s.newMetastore()
+	if err := m.open(tmpPath); err != nil {
+		return fmt.Errorf("open meta copy: %s", err)
+	}
+	var digest string
+	_ = m.view(func(tx *metatx) error {
+		digest = tx.digest()
+		return nil
+	})
+	_ = m.close()
+	if digest != want.Digest {
+		return ErrMetastoreChanged
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.index != want.Index {
+		return ErrMetastoreChanged
+	}
+
+	// Close the shards loaded from the old metastore.
+	prev := make(map[uint64]bool)
+	for _, sh := range s.shards {
+		if sh.hasStore() {
+			prev[sh.ID] = true
+		}
+		_ = sh.close()
+	}
+
+	// Replace the metastore and keep the local server id and topic indexes.
+	_ = s.meta.close()
+	if err := os.Rename(tmpPath, s.metaPath()); err != nil {
+		return fmt.Errorf("replace meta file: %s", err)
+	}
+	s.meta = s.newMetastore()
+	if err := s.meta.open(s.metaPath()); err != nil {
+		return fmt.Errorf("reopen meta: %s", err)
+	}
+	if err := s.meta.mustUpdate(func(tx *metatx) error {
+		if err := tx.setID(s.id); err != nil {
+			return err
+		}
+		return tx.setTopicIndexes(s.topicIndex)
+	}); err != nil {
+		return err
+	}
+
+	log.Printf("reloading metadata from %s", nodeURL)
+	if err := s.load(); err != nil {
+		return fmt.Errorf("reload: %s", err)
+	}
+	s.reindexShards()
+
+	// Start or stop receiving writes for shards whose ownership changed.
+	for id, sh := range s.shards {
+		if !sh.hasStore() {
+			continue
+		} else if !prev[id] {
+			s.subscribe(id)
+		}
+		delete(prev, id)
+	}
+	for id := range prev {
+		s.unsubscribe(id)
+	}
+
+	return nil
+}
+
+// reindexShards rebuilds the shard lookups from the loaded databases. Series
+// keep their associations with shards that still exist. Lock must be held.
+func (s *Server) reindexShards() {
+	s.shards = make(map[uint64]*Shard)
+	for _, db := range s.databases {
+		for _, rp := range db.policies {
+			for _, g := range rp.shardGroups {
+				for _, sh := range g.Shards {
+					s.shards[sh.ID] = sh
+				}
+			}
+		}
+	}
+
+	shardsBySeriesID := s.shardsBySeriesID
+	s.shardsBySeriesID = make(map[uint32][]*Shard)
+	for seriesID, a := range shardsBySeriesID {
+		for _, sh := range a {
+			if other := s.shards[sh.ID]; other != nil {
+				s.addShardBySeriesID(other, seriesID)
+			}
+		}
+	}
+}
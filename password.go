@@ -0,0 +1,48 @@
+package influxdb
+
+import (
+	"golang.org/x/crypto/bcrypt"
+)
+
+// PasswordHasher represents a key derivation function used to store user
+// passwords, such as bcrypt, scrypt or argon2.
+type PasswordHasher interface {
+	// Hash returns an encoded hash of password.
+	Hash(password string) (string, error)
+
+	// Compare returns nil if password matches an encoded hash.
+	Compare(hash, password string) error
+
+	// NeedsRehash returns true if an encoded hash is weaker than the hashes
+	// generated with the hasher's current settings and should be replaced on
+	// the next login. Servers with different settings must not keep replacing
+	// each other's hashes so a hash is never replaced with a weaker one.
+	NeedsRehash(hash string) bool
+}
+
+// BcryptHasher hashes passwords with bcrypt.
+type BcryptHasher struct {
+	// The cost of generating a hash. Higher is slower but makes passwords
+	// harder to brute force.
+	Cost int
+}
+
+// Hash returns a bcrypt hash of password.
+func (h *BcryptHasher) Hash(password string) (string, error) {
+	b, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// Compare returns nil if password matches a bcrypt hash.
+func (h *BcryptHasher) Compare(hash, password string) error {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+}
+
+// NeedsRehash returns true if hash isn't a bcrypt hash with at least the hasher's cost.
+func (h *BcryptHasher) NeedsRehash(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost < h.Cost
+}
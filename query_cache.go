@@ -0,0 +1,71 @@
+package influxdb
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/influxdb/influxdb/influxql"
+)
+
+// queryCache represents an LRU cache of select statement results.
+// It is safe for concurrent use.
+type queryCache struct {
+	mu      sync.Mutex
+	entries map[string]*list.Element // elements by key
+	lru     *list.List               // most recently used entry at the front
+}
+
+// queryCacheEntry represents the cached rows for a single statement.
+type queryCacheEntry struct {
+	key  string
+	rows []*influxql.Row
+}
+
+// newQueryCache returns a new, empty instance of queryCache.
+func newQueryCache() *queryCache {
+	return &queryCache{
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+// get returns the cached rows for key and marks them as recently used.
+func (c *queryCache) get(key string) ([]*influxql.Row, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e := c.entries[key]
+	if e == nil {
+		return nil, false
+	}
+	c.lru.MoveToFront(e)
+	return e.Value.(*queryCacheEntry).rows, true
+}
+
+// set caches the rows for key. The least recently used entries are evicted
+// until the cache holds at most n entries.
+func (c *queryCache) set(key string, rows []*influxql.Row, n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e := c.entries[key]; e != nil {
+		e.Value.(*queryCacheEntry).rows = rows
+		c.lru.MoveToFront(e)
+	} else {
+		c.entries[key] = c.lru.PushFront(&queryCacheEntry{key: key, rows: rows})
+	}
+
+	for c.lru.Len() > n {
+		e := c.lru.Back()
+		delete(c.entries, e.Value.(*queryCacheEntry).key)
+		c.lru.Remove(e)
+	}
+}
+
+// clear removes all entries from the cache.
+func (c *queryCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[string]*list.Element)
+	c.lru.Init()
+}
@@ -0,0 +1,205 @@
+package influxdb
+
+import (
+	"fmt"
+	"log"
+	"sort"
+)
+
+// ShardMove represents the reassignment of a shard from one data node to another.
+type ShardMove struct {
+	ShardID uint64 `json:"shardID"`
+	From    uint64 `json:"from"`
+	To      uint64 `json:"to"`
+}
+
+// RebalanceShards moves existing shards so that every data node owns a
+// similar number of them. New data nodes are otherwise only assigned shard
+// groups created after they join. Each moved shard's data is copied to its new
+// owner before the previous owner is removed. Returns the moves made.
+func (s *Server) RebalanceShards() ([]ShardMove, error) {
+	s.mu.RLock()
+	shards := make([]*Shard, 0, len(s.shards))
+	for _, sh := range s.shards {
+		shards = append(shards, &Shard{ID: sh.ID, DataNodeIDs: append([]uint64{}, sh.DataNodeIDs...)})
+	}
+	nodeIDs := make([]uint64, 0, len(s.dataNodes))
+	for id := range s.dataNodes {
+		nodeIDs = append(nodeIDs, id)
+	}
+	s.mu.RUnlock()
+
+	moves := planShardRebalance(shards, nodeIDs)
+	for i, m := range moves {
+		log.Printf("rebalancing shard %d from data node %d to %d", m.ShardID, m.From, m.To)
+		if err := s.moveShard(m.ShardID, m.From, m.To); err != nil {
+			return moves[:i], fmt.Errorf("move shard %d: %s", m.ShardID, err)
+		}
+	}
+	return moves, nil
+}
+
+// planShardRebalance returns the moves that bring the number of shards owned
+// by each data node to within one of every other node. Shards are moved from
+// the most loaded node to the least loaded node, lowest shard id first.
+func planShardRebalance(shards []*Shard, nodeIDs []uint64) (moves []ShardMove) {
+	if len(nodeIDs) < 2 {
+		return nil
+	}
+	nodeIDs = append([]uint64{}, nodeIDs...)
+	sort.Sort(uint64Slice(nodeIDs))
+
+	// Copy the owners so the plan can be applied as it is built.
+	owners := make(map[uint64][]uint64, len(shards))
+	shardIDs := make([]uint64, 0, len(shards))
+	counts := make(map[uint64]int, len(nodeIDs))
+	for _, id := range nodeIDs {
+		counts[id] = 0
+	}
+	for _, sh := range shards {
+		owners[sh.ID] = append([]uint64{}, sh.DataNodeIDs...)
+		shardIDs = append(shardIDs, sh.ID)
+		for _, id := range sh.DataNodeIDs {
+			if _, ok := counts[id]; ok {
+				counts[id]++
+			}
+		}
+	}
+	sort.Sort(uint64Slice(shardIDs))
+
+	for {
+		// Find the most & least loaded nodes.
+		max, min := nodeIDs[0], nodeIDs[0]
+		for _, id := range nodeIDs {
+			if counts[id] > counts[max] {
+				max = id
+			}
+			if counts[id] < counts[min] {
+				min = id
+			}
+		}
+		if counts[max]-counts[min] <= 1 {
+			return
+		}
+
+		// Move the first shard that the least loaded node doesn't already own.
+		var moved bool
+		for _, shardID := range shardIDs {
+			a := owners[shardID]
+			if !containsUint64(a, max) || containsUint64(a, min) {
+				continue
+			}
+			owners[shardID] = append(removeUint64(a, max), min)
+			counts[max]--
+			counts[min]++
+			moves = append(moves, ShardMove{ShardID: shardID, From: max, To: min})
+			moved = true
+			break
+		}
+		if !moved {
+			return
+		}
+	}
+}
+
+// ReplicateShards assigns additional owners to shards that have fewer owners
+// than their retention policy's replication factor, such as after the factor
+// is raised or data nodes join a cluster that was smaller than the factor.
+// Each new owner receives a copy of the shard's data from an existing owner.
+// Returns the number of replicas added.
+func (s *Server) ReplicateShards() (n int, err error) {
+	// Find shards with fewer owners than their policy requires.
+	s.mu.RLock()
+	missing := make(map[uint64]int)
+	for _, db := range s.databases {
+		for _, rp := range db.policies {
+			replicaN := int(rp.ReplicaN)
+			if replicaN == 0 {
+				replicaN = 1
+			} else if replicaN > len(s.dataNodes) {
+				replicaN = len(s.dataNodes)
+			}
+			for _, g := range rp.shardGroups {
+				for _, sh := range g.Shards {
+					if len(sh.DataNodeIDs) < replicaN {
+						missing[sh.ID] = replicaN - len(sh.DataNodeIDs)
+					}
+				}
+			}
+		}
+	}
+	s.mu.RUnlock()
+
+	shardIDs := make([]uint64, 0, len(missing))
+	for id := range missing {
+		shardIDs = append(shardIDs, id)
+	}
+	sort.Sort(uint64Slice(shardIDs))
+
+	for _, shardID := range shardIDs {
+		for i := 0; i < missing[shardID]; i++ {
+			if e := s.addShardReplica(shardID); e != nil {
+				err = fmt.Errorf("replicate shard %d: %s", shardID, e)
+				break
+			}
+			n++
+		}
+	}
+	return
+}
+
+// scheduleShardReplication replicates under-replicated shards in the background.
+func (s *Server) scheduleShardReplication() {
+	s.repairWG.Add(1)
+	go func() {
+		defer s.repairWG.Done()
+		if _, err := s.ReplicateShards(); err != nil {
+			log.Printf("shard replication: %s", err)
+		}
+	}()
+}
+
+// addShardReplica assigns a shard to the least loaded data node that doesn't
+// own it and copies the shard's data to it. The assignment is reverted if the
+// data can't be copied from any owner.
+func (s *Server) addShardReplica(shardID uint64) error {
+	s.mu.RLock()
+	sh := s.shards[shardID]
+	if sh == nil {
+		s.mu.RUnlock()
+		return ErrShardNotFound
+	}
+	owners := append([]uint64{}, sh.DataNodeIDs...)
+	degraded := sh.Degraded
+	to := s.replacementDataNode(sh, 0)
+	s.mu.RUnlock()
+	if to == 0 {
+		return ErrNoReplacementDataNode
+	}
+
+	log.Printf("replicating shard %d to data node %d", shardID, to)
+	index, err := s.setShardOwners(shardID, append(append([]uint64{}, owners...), to))
+	if err != nil {
+		return err
+	} else if len(owners) == 0 {
+		return nil
+	}
+
+	for _, from := range owners {
+		if err = s.copyShardData(shardID, from, to, index); err == nil {
+			// The new replica replaces the one lost by a degraded shard.
+			if degraded {
+				degraded = false
+				c := &setShardOwnersCommand{ID: shardID, DataNodeIDs: append(owners, to), Degraded: &degraded}
+				_, err = s.broadcast(setShardOwnersMessageType, c)
+			}
+			return err
+		}
+		log.Printf("unable to copy shard %d from data node %d: %s", shardID, from, err)
+	}
+
+	if e := s.SetShardOwners(shardID, owners); e != nil {
+		log.Printf("unable to revert owners of shard %d: %s", shardID, e)
+	}
+	return err
+}
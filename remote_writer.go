@@ -0,0 +1,94 @@
+package influxdb
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/influxdb/influxdb/client"
+)
+
+// DefaultRemoteWriteTimeout is the default time to wait for a remote server to accept a write.
+const DefaultRemoteWriteTimeout = 10 * time.Second
+
+// PointsWriter represents an object that writes points into a database and retention policy.
+type PointsWriter interface {
+	WritePoints(database, retentionPolicy string, points []Point) error
+}
+
+// RemoteWriter writes points to another InfluxDB server over HTTP.
+type RemoteWriter struct {
+	// The URL of the remote server's HTTP API.
+	URL *url.URL
+
+	// The database to write into on the remote server.
+	// A blank name writes into the database with the same name as the local one.
+	Database string
+
+	// The credentials used to authenticate with the remote server, if any.
+	Username string
+	Password string
+
+	// The HTTP client used to send writes.
+	Client *http.Client
+}
+
+// NewRemoteWriter returns a new instance of RemoteWriter for a server URL.
+func NewRemoteWriter(u *url.URL) *RemoteWriter {
+	return &RemoteWriter{
+		URL:    u,
+		Client: &http.Client{Timeout: DefaultRemoteWriteTimeout},
+	}
+}
+
+// WritePoints sends points to the remote server as a single batch.
+func (w *RemoteWriter) WritePoints(database, retentionPolicy string, points []Point) error {
+	if w.Database != "" {
+		database = w.Database
+	}
+
+	// Convert to the batch format accepted by the write endpoint.
+	bp := &BatchPoints{Database: database, RetentionPolicy: retentionPolicy}
+	for _, p := range points {
+		bp.Points = append(bp.Points, client.Point{
+			Name:      p.Name,
+			Tags:      p.Tags,
+			Timestamp: client.Timestamp(p.Timestamp),
+			Values:    p.Values,
+		})
+	}
+	b, err := json.Marshal(bp)
+	if err != nil {
+		return err
+	}
+
+	// Send request.
+	u := copyURL(w.URL)
+	u.Path = "/write"
+	req, err := http.NewRequest("POST", u.String(), bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if w.Username != "" {
+		req.SetBasicAuth(w.Username, w.Password)
+	}
+	resp, err := w.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// Check if the write was accepted.
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("remote write: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	return nil
+}
@@ -0,0 +1,170 @@
+package influxdb
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StartShardRepair launches a background service that periodically repairs
+// the local shards from the other data nodes that own them. The data node with
+// the lowest ID that is reachable also assigns replicas to under-replicated shards.
+func (s *Server) StartShardRepair(checkInterval time.Duration) error {
+	if checkInterval == 0 {
+		return fmt.Errorf("shard repair check interval must be non-zero")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.repairDone != nil {
+		return fmt.Errorf("shard repair already started")
+	}
+
+	repairDone := make(chan struct{}, 0)
+	s.repairDone = repairDone
+	s.repairWG.Add(1)
+	go func() {
+		defer s.repairWG.Done()
+		for {
+			select {
+			case <-repairDone:
+				return
+			case <-time.After(checkInterval):
+				if s.isRetentionLeader() {
+					if _, err := s.ReplicateShards(); err != nil {
+						log.Printf("shard replication: %s", err)
+					}
+				}
+				if _, err := s.RepairShards(); err != nil {
+					log.Printf("shard repair: %s", err)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// StopShardRepair stops the shard repair service. It waits for an in-flight
+// repair to finish before returning.
+func (s *Server) StopShardRepair() {
+	s.mu.Lock()
+	repairDone := s.repairDone
+	s.repairDone = nil
+	s.mu.Unlock()
+
+	if repairDone != nil {
+		close(repairDone)
+	}
+	s.repairWG.Wait()
+}
+
+// RepairShards compares the digest of each local shard with the digests of
+// the other data nodes that own the shard and copies the points of any series
+// that differ. Points are only ever added so every replica running the repair
+// converges on the union of the replicas' points. Returns the number of points
+// copied. Unreachable replicas are skipped and the last error is returned.
+func (s *Server) RepairShards() (n int, err error) {
+	// Find the local shards that have other owners.
+	s.mu.RLock()
+	owners := make(map[uint64][]uint64)
+	for _, sh := range s.shards {
+		if !sh.hasStore() {
+			continue
+		}
+		for _, id := range sh.DataNodeIDs {
+			if id != s.id {
+				owners[sh.ID] = append(owners[sh.ID], id)
+			}
+		}
+	}
+	s.mu.RUnlock()
+
+	// Repair shards in a consistent order.
+	shardIDs := make([]uint64, 0, len(owners))
+	for id := range owners {
+		shardIDs = append(shardIDs, id)
+	}
+	sort.Sort(uint64Slice(shardIDs))
+
+	for _, shardID := range shardIDs {
+		for _, nodeID := range owners[shardID] {
+			pointN, e := s.repairShard(shardID, nodeID)
+			if e != nil {
+				err = fmt.Errorf("shard %d from data node %d: %s", shardID, nodeID, e)
+				continue
+			}
+			n += pointN
+		}
+	}
+
+	s.stats.Inc("shardRepairReq")
+	s.stats.Add("shardRepairPoints", int64(n))
+	return
+}
+
+// repairShard copies the series of a shard that differ from another data node.
+func (s *Server) repairShard(shardID, nodeID uint64) (int, error) {
+	n := s.DataNode(nodeID)
+	if n == nil {
+		return 0, ErrDataNodeNotFound
+	}
+	path := "/shards/" + strconv.FormatUint(shardID, 10)
+
+	// Compare the local & remote digests.
+	local, err := s.ShardDigest(shardID)
+	if err != nil {
+		return 0, err
+	}
+	var remote map[uint32]string
+	if err := s.getShardRepairData(n.URL, path+"/digest", nil, func(r io.Reader) error {
+		return json.NewDecoder(r).Decode(&remote)
+	}); err != nil {
+		return 0, err
+	}
+
+	var seriesIDs []string
+	for id, sum := range remote {
+		if local[id] != sum {
+			seriesIDs = append(seriesIDs, strconv.FormatUint(uint64(id), 10))
+		}
+	}
+	if len(seriesIDs) == 0 {
+		return 0, nil
+	}
+	sort.Strings(seriesIDs)
+
+	// Copy the differing series and add any missing points.
+	var buf bytes.Buffer
+	if err := s.getShardRepairData(n.URL, path+"/data", url.Values{"series": {strings.Join(seriesIDs, ",")}}, func(r io.Reader) error {
+		_, err := io.Copy(&buf, r)
+		return err
+	}); err != nil {
+		return 0, err
+	}
+	return s.MergeShard(shardID, &buf)
+}
+
+// getShardRepairData sends a GET request to another data node and passes the
+// response body to fn.
+func (s *Server) getShardRepairData(nodeURL *url.URL, path string, values url.Values, fn func(io.Reader) error) error {
+	u := *nodeURL
+	u.Path = path
+	u.RawQuery = values.Encode()
+	resp, err := s.doNodeRequest("GET", &u, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", path, resp.Status)
+	}
+	return fn(resp.Body)
+}
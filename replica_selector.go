@@ -0,0 +1,68 @@
+package influxdb
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultReplicaFailureBackoff is how long a data node that failed to serve a
+// remote read is tried after the other owners of a shard.
+const DefaultReplicaFailureBackoff = 30 * time.Second
+
+// replicaSelector chooses the order in which the owners of a remote shard are
+// read from. Reads are spread across the owners round robin and owners that
+// recently failed are tried last. It is safe for concurrent use.
+type replicaSelector struct {
+	mu       sync.Mutex
+	next     map[uint64]int       // next first owner, by shard id
+	failures map[uint64]time.Time // time of last failure, by data node id
+}
+
+// newReplicaSelector returns a new instance of replicaSelector.
+func newReplicaSelector() *replicaSelector {
+	return &replicaSelector{
+		next:     make(map[uint64]int),
+		failures: make(map[uint64]time.Time),
+	}
+}
+
+// order returns the owners of a shard in the order they should be tried.
+// Owners that failed within backoff of now are moved to the end.
+func (r *replicaSelector) order(shardID uint64, nodeIDs []uint64, backoff time.Duration, now time.Time) []uint64 {
+	if len(nodeIDs) == 0 {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Rotate the starting owner for each read of the shard.
+	start := r.next[shardID] % len(nodeIDs)
+	r.next[shardID] = start + 1
+
+	healthy := make([]uint64, 0, len(nodeIDs))
+	var failed []uint64
+	for i := range nodeIDs {
+		id := nodeIDs[(start+i)%len(nodeIDs)]
+		if t, ok := r.failures[id]; ok && now.Sub(t) < backoff {
+			failed = append(failed, id)
+		} else {
+			healthy = append(healthy, id)
+		}
+	}
+	return append(healthy, failed...)
+}
+
+// fail records a failed read from a data node.
+func (r *replicaSelector) fail(nodeID uint64, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.failures[nodeID] = now
+}
+
+// succeed clears any failure recorded for a data node.
+func (r *replicaSelector) succeed(nodeID uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.failures, nodeID)
+}
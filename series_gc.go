@@ -0,0 +1,220 @@
+package influxdb
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/influxdb/influxdb/messaging"
+)
+
+type deleteSeriesCommand struct {
+	Database  string              `json:"database"`
+	SeriesIDs map[string][]uint32 `json:"seriesIDs"` // by measurement name
+}
+
+// CollectSeries deletes the metadata of series that have no points left in
+// any shard, such as series whose shard groups have all aged out of their
+// retention policies. A series is only deleted if it had no points at the
+// previous collection too so that new series aren't deleted before their
+// first points are written.
+//
+// Only the reachable data node with the lowest ID collects series, and only in databases
+// whose shards are all stored on it since it can't see the points written to
+// other data nodes' shards.
+func (s *Server) CollectSeries() error {
+	if !s.isRetentionLeader() {
+		return nil
+	}
+
+	s.mu.Lock()
+	a, err := s.orphanedSeries()
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	for _, c := range a {
+		if _, err := s.broadcast(deleteSeriesMessageType, c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// orphanedSeries returns a command for each database to delete the series that
+// have no points and were marked by the previous collection. Other series
+// without points are marked for the next collection. Lock must be held.
+func (s *Server) orphanedSeries() ([]*deleteSeriesCommand, error) {
+	var a []*deleteSeriesCommand
+	marks := make(map[string]map[uint32]bool)
+	if err := s.meta.view(func(tx *metatx) error {
+		for _, db := range s.databases {
+			shards, ok := s.localDatabaseShards(db)
+			if !ok {
+				continue
+			}
+
+			c := &deleteSeriesCommand{Database: db.name, SeriesIDs: make(map[string][]uint32)}
+			marks[db.name] = make(map[uint32]bool)
+			for _, name := range db.names {
+				for _, series := range tx.measurementSeries(db.name, name) {
+					if ok, err := s.seriesHasPoints(series.ID, shards); err != nil {
+						return err
+					} else if ok {
+						continue
+					}
+
+					if s.seriesMarks[db.name][series.ID] {
+						c.SeriesIDs[name] = append(c.SeriesIDs[name], series.ID)
+					} else {
+						marks[db.name][series.ID] = true
+					}
+				}
+			}
+			if len(c.SeriesIDs) > 0 {
+				a = append(a, c)
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	s.seriesMarks = marks
+	return a, nil
+}
+
+// localDatabaseShards returns the shards of a database. Returns false if any
+// of the shards isn't open on this data node. Lock must be held.
+func (s *Server) localDatabaseShards(db *database) ([]*Shard, bool) {
+	var a []*Shard
+	for _, rp := range db.policies {
+		for _, g := range rp.shardGroups {
+			for _, sh := range g.Shards {
+				if !sh.HasDataNodeID(s.id) || !sh.hasStore() {
+					return nil, false
+				}
+				a = append(a, sh)
+			}
+		}
+	}
+	return a, true
+}
+
+// seriesHasPoints returns true if any of the shards holds points for a series.
+// The shards that points were written to are checked first. Points that reached
+// a shard without being written to it, such as those copied by shard repair,
+// are found in the shard's store and the shard is added to the lookup.
+// Lock must be held.
+func (s *Server) seriesHasPoints(seriesID uint32, shards []*Shard) (bool, error) {
+	for _, sh := range s.shardsBySeriesID[seriesID] {
+		for _, other := range shards {
+			if other.ID == sh.ID {
+				return true, nil
+			}
+		}
+	}
+
+	for _, sh := range shards {
+		if ok, err := sh.hasPoints(seriesID); err != nil {
+			return false, fmt.Errorf("shard %d: %s", sh.ID, err)
+		} else if ok {
+			s.addShardBySeriesID(sh, seriesID)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// applyDeleteSeries removes series from the index and the metastore.
+func (s *Server) applyDeleteSeries(m *messaging.Message) error {
+	var c deleteSeriesCommand
+	mustDecodeCommand(m.Data, &c)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Ignore the request if the database has been dropped since.
+	db := s.databases[c.Database]
+	if db == nil {
+		return nil
+	}
+
+	var n int
+	for name, ids := range c.SeriesIDs {
+		db.dropSeries(name, ids)
+		n += len(ids)
+	}
+	if err := s.meta.mustUpdate(func(tx *metatx) error {
+		for name, ids := range c.SeriesIDs {
+			if err := tx.deleteSeries(c.Database, name, ids); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	// Forget the deleted shards the series were written to. A series with
+	// the same id in another database is found again in its shards' stores.
+	for _, ids := range c.SeriesIDs {
+		for _, id := range ids {
+			delete(s.shardsBySeriesID, id)
+		}
+	}
+
+	log.Printf("deleted %d series without points from database %s", n, c.Database)
+	s.stats.Add("seriesCollected", int64(n))
+
+	// Cached results may include the deleted series.
+	s.queryCache.clear()
+	return nil
+}
+
+// StartSeriesCollection launches a background service that periodically
+// deletes series that have no points left.
+func (s *Server) StartSeriesCollection(checkInterval time.Duration) error {
+	if checkInterval == 0 {
+		return fmt.Errorf("series collection check interval must be non-zero")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.seriesGCDone != nil {
+		return fmt.Errorf("series collection already started")
+	}
+
+	seriesGCDone := make(chan struct{}, 0)
+	s.seriesGCDone = seriesGCDone
+	s.seriesGCWG.Add(1)
+	go func() {
+		defer s.seriesGCWG.Done()
+		for {
+			select {
+			case <-seriesGCDone:
+				return
+			case <-time.After(checkInterval):
+				if err := s.CollectSeries(); err != nil {
+					log.Printf("series collection: %s", err)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// StopSeriesCollection stops the series collection service. It waits for an
+// in-flight collection to finish before returning.
+func (s *Server) StopSeriesCollection() {
+	s.mu.Lock()
+	seriesGCDone := s.seriesGCDone
+	s.seriesGCDone = nil
+	s.mu.Unlock()
+
+	if seriesGCDone != nil {
+		close(seriesGCDone)
+	}
+	s.seriesGCWG.Wait()
+}
@@ -0,0 +1,89 @@
+package influxdb
+
+import (
+	"container/list"
+	"sync"
+)
+
+// seriesIndexCache limits the number of series held in the in-memory indexes
+// of measurements. Once the limit is exceeded, the indexes of the least
+// recently used measurements are dropped and reloaded from the metastore when
+// they're next used. It is safe for concurrent use.
+type seriesIndexCache struct {
+	mu      sync.Mutex
+	limit   int                                // maximum series held in memory
+	n       int                                // series held in memory
+	lru     *list.List                         // most recently used at the front
+	entries map[*Measurement]*seriesIndexEntry // entries by measurement
+
+	// Returns the series of a measurement from the metastore.
+	load func(database, name string) []*Series
+}
+
+// seriesIndexEntry is the cache entry of a measurement's index.
+type seriesIndexEntry struct {
+	m    *Measurement
+	n    int // series in the index
+	elem *list.Element
+}
+
+// newSeriesIndexCache returns a new instance of seriesIndexCache.
+func newSeriesIndexCache(limit int, load func(database, name string) []*Series) *seriesIndexCache {
+	return &seriesIndexCache{
+		limit:   limit,
+		lru:     list.New(),
+		entries: make(map[*Measurement]*seriesIndexEntry),
+		load:    load,
+	}
+}
+
+// touch marks a measurement's index as the most recently used and adds n to
+// the number of series it holds. The least recently used indexes are dropped
+// until the limit is met. The index of m is always kept.
+func (c *seriesIndexCache) touch(m *Measurement, n int) {
+	c.mu.Lock()
+	e := c.entries[m]
+	if e == nil {
+		e = &seriesIndexEntry{m: m}
+		e.elem = c.lru.PushFront(e)
+		c.entries[m] = e
+	} else {
+		c.lru.MoveToFront(e.elem)
+	}
+	e.n += n
+	c.n += n
+
+	var evicted []*Measurement
+	for c.n > c.limit && c.lru.Len() > 1 {
+		other := c.lru.Back().Value.(*seriesIndexEntry)
+		c.lru.Remove(other.elem)
+		delete(c.entries, other.m)
+		c.n -= other.n
+		evicted = append(evicted, other.m)
+	}
+	c.mu.Unlock()
+
+	// Indexes are dropped outside the cache lock so a measurement that is
+	// loading its index doesn't hold up the cache.
+	for _, other := range evicted {
+		other.dropIndex()
+	}
+}
+
+// remove forgets a measurement that was dropped from its database.
+func (c *seriesIndexCache) remove(m *Measurement) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e := c.entries[m]; e != nil {
+		c.lru.Remove(e.elem)
+		delete(c.entries, m)
+		c.n -= e.n
+	}
+}
+
+// size returns the number of series held in memory.
+func (c *seriesIndexCache) size() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.n
+}
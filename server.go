@@ -2,22 +2,29 @@ package influxdb
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"hash/fnv"
 	"io"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
 	"regexp"
+	"runtime"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/boltdb/bolt"
 	"github.com/influxdb/influxdb/influxql"
 	"github.com/influxdb/influxdb/messaging"
 	"golang.org/x/crypto/bcrypt"
@@ -28,6 +35,10 @@ const (
 	// It is also used when reseting the root user's password.
 	DefaultRootPassword = "root"
 
+	// RootUsername is the name of the cluster admin created when bootstrapping
+	// or recovering a cluster.
+	RootUsername = "root"
+
 	// DefaultRetentionPolicyName is the name of a databases's default shard space.
 	DefaultRetentionPolicyName = "default"
 
@@ -37,17 +48,55 @@ const (
 	// DefaultReplicaN represents the number of replicas data is written to.
 	DefaultReplicaN = 1
 
-	// DefaultShardDuration is the time period held by a shard.
+	// DefaultShardDuration is the time period held by a shard group
+	// in retention policies longer than six months.
 	DefaultShardDuration = 7 * (24 * time.Hour)
 
 	// DefaultShardRetention is the length of time before a shard is dropped.
 	DefaultShardRetention = 7 * (24 * time.Hour)
+
+	// DefaultDataNodePingTimeout is the time to wait for a data node to respond
+	// to a ping before it is considered down.
+	DefaultDataNodePingTimeout = 1 * time.Second
+
+	// DefaultRemoteShardTimeout is the time to wait for another data node to
+	// start streaming a shard's points before trying the next owner.
+	DefaultRemoteShardTimeout = 10 * time.Second
+
+	// DefaultJoinAttempts is the number of times the seed URLs are tried when
+	// joining a cluster.
+	DefaultJoinAttempts = 3
+
+	// DefaultJoinBackoff is the time to wait before retrying the seed URLs
+	// when joining a cluster.
+	DefaultJoinBackoff = 1 * time.Second
+
+	// DefaultClientReconnectDelay is the time to wait between attempts to
+	// replace a messaging client whose message stream has closed.
+	DefaultClientReconnectDelay = 1 * time.Second
+
+	// DefaultMaxConcurrentContinuousQueries is the number of continuous queries
+	// a data node runs at the same time.
+	DefaultMaxConcurrentContinuousQueries = 4
+
+	// DefaultMaxMessageErrors is the number of message errors held for Sync
+	// before the oldest are dropped.
+	DefaultMaxMessageErrors = 10000
+
+	// DefaultMessageErrorAge is the time a message error is held for Sync.
+	DefaultMessageErrorAge = 10 * time.Minute
+
+	// DefaultBcryptCost is the cost of hashing user passwords with bcrypt.
+	DefaultBcryptCost = 10
 )
 
 const (
 	// Data node messages
-	createDataNodeMessageType = messaging.MessageType(0x00)
-	deleteDataNodeMessageType = messaging.MessageType(0x01)
+	createDataNodeMessageType  = messaging.MessageType(0x00)
+	deleteDataNodeMessageType  = messaging.MessageType(0x01)
+	updateDataNodeMessageType  = messaging.MessageType(0x02)
+	setDataNodeZoneMessageType = messaging.MessageType(0x03)
+	removeDataNodeMessageType  = messaging.MessageType(0x04)
 
 	// Database messages
 	createDatabaseMessageType = messaging.MessageType(0x10)
@@ -64,24 +113,54 @@ const (
 	updateUserMessageType = messaging.MessageType(0x31)
 	deleteUserMessageType = messaging.MessageType(0x32)
 
+	// Role messages
+	createRoleMessageType       = messaging.MessageType(0x33)
+	deleteRoleMessageType       = messaging.MessageType(0x34)
+	setRolePrivilegeMessageType = messaging.MessageType(0x35)
+	setUserRoleMessageType      = messaging.MessageType(0x36)
+
+	// Root user messages
+	ensureAdminUserMessageType   = messaging.MessageType(0x37)
+	resetRootPasswordMessageType = messaging.MessageType(0x38)
+
+	// API token messages
+	createAPITokenMessageType = messaging.MessageType(0x39)
+	deleteAPITokenMessageType = messaging.MessageType(0x3A)
+
 	// Shard messages
 	createShardGroupIfNotExistsMessageType = messaging.MessageType(0x40)
 	deleteShardGroupMessageType            = messaging.MessageType(0x41)
+	retentionSweepMessageType              = messaging.MessageType(0x42)
+	deleteShardMessageType                 = messaging.MessageType(0x43)
+	setShardOwnersMessageType              = messaging.MessageType(0x44)
+	splitShardMessageType                  = messaging.MessageType(0x45)
 
 	// Series messages
 	createSeriesIfNotExistsMessageType = messaging.MessageType(0x50)
+	deleteSeriesMessageType            = messaging.MessageType(0x51)
 
 	// Measurement messages
 	createFieldsIfNotExistsMessageType = messaging.MessageType(0x60)
+	setMeasurementTTLMessageType       = messaging.MessageType(0x61)
 
 	// Continuous Query messages
-	createContinuousQueryMessageType = messaging.MessageType(0x70)
+	createContinuousQueryMessageType     = messaging.MessageType(0x70)
+	deleteContinuousQueryMessageType     = messaging.MessageType(0x71)
+	setContinuousQueryStatsMessageType   = messaging.MessageType(0x72)
+	setContinuousQueryEnabledMessageType = messaging.MessageType(0x73)
 
 	// Write series data messages (per-topic)
 	writeRawSeriesMessageType = messaging.MessageType(0x80)
 
 	// Privilege messages
-	setPrivilegeMessageType = messaging.MessageType(0x90)
+	setPrivilegeMessageType            = messaging.MessageType(0x90)
+	setMeasurementPrivilegeMessageType = messaging.MessageType(0x91)
+
+	// Snapshot messages
+	createSnapshotMessageType = messaging.MessageType(0xA0)
+
+	// Metadata repair messages
+	repairMetadataMessageType = messaging.MessageType(0xB0)
 )
 
 // Server represents a collection of metadata and raw metric data.
@@ -89,52 +168,263 @@ type Server struct {
 	mu     sync.RWMutex
 	id     uint64
 	path   string
-	done   chan struct{} // goroutine close notification
-	rpDone chan struct{} // retention policies goroutine close notification
+	done   chan struct{}  // goroutine close notification
+	rpDone chan struct{}  // retention policies goroutine close notification
+	rpWG   sync.WaitGroup // retention policies goroutine
+
+	repairDone chan struct{}  // shard repair goroutine close notification
+	repairWG   sync.WaitGroup // shard repair goroutine
 
-	client MessagingClient  // broker client
-	index  uint64           // highest broadcast index seen
-	errors map[uint64]error // message errors
+	metaVerifyDone chan struct{}  // metastore verification goroutine close notification
+	metaVerifyWG   sync.WaitGroup // metastore verification goroutine
+
+	metaCompactDone chan struct{}  // metastore compaction goroutine close notification
+	metaCompactWG   sync.WaitGroup // metastore compaction goroutine
+
+	shardCompactDone chan struct{}  // shard compaction goroutine close notification
+	shardCompactWG   sync.WaitGroup // shard compaction goroutine
+
+	seriesGCDone chan struct{}  // series collection goroutine close notification
+	seriesGCWG   sync.WaitGroup // series collection goroutine
+
+	client  MessagingClient // broker client
+	index   uint64          // highest broadcast index seen
+	indexCh chan struct{}   // closed when the index advances; nil if nothing waits
+	errors  *messageErrors  // message errors not yet retrieved by Sync
+
+	topicIndex      map[uint64]uint64 // highest index applied, by topic id
+	topicIndexSaved time.Time         // when topicIndex was last saved to the metastore
+	subscriptions   *subscriptionSet  // broker subscriptions of the local replica
+
+	brokerIndex uint64 // highest broker index returned by a publish; accessed atomically
 
 	meta *metastore // metadata store
 
 	dataNodes map[uint64]*DataNode // data nodes by id
 	databases map[string]*database // databases by name
+
+	seriesIndex *seriesIndexCache // nil unless SeriesIndexCacheSize is set
+
 	users     map[string]*User     // user by name
+	roles     map[string]*Role     // roles by name
+	apiTokens map[string]*APIToken // api tokens by id
 
 	shards           map[uint64]*Shard   // shards by shard id
+	shardStores      *shardStoreCache    // nil unless MaxOpenShards or LazyShardOpen is set
 	shardsBySeriesID map[uint32][]*Shard // shards by series id
+	shardSplits      map[uint64]bool     // shards with a split in progress
+
+	seriesMarks map[string]map[uint32]bool // series without points at the last collection, by database
+
+	stats       *Stats           // internal counters
+	slowQueries *SlowQueryLog    // recent slow select statements
+	replicas    *replicaSelector // read order of remote shard owners
+	queryCache  *queryCache      // results of aggregate queries over closed shard groups
+	authCache   *authCache       // recently verified passwords
+	authLockout *authLockout     // recent failed password verifications
+
+	cqErrorHandler ContinuousQueryErrorHandler // notified of failed continuous query runs
+
+	cqMu      sync.Mutex
+	cqSlots   chan struct{}             // limits concurrently running continuous queries
+	cqPending map[*ContinuousQuery]bool // continuous queries waiting to run
+	cqDone    chan struct{}             // continuous queries close notification
+	cqWG      sync.WaitGroup            // queued and running continuous queries
+
+	queryMu    sync.Mutex
+	userQueryN map[string]int // running queries by user name
 
 	Logger *log.Logger
 
 	authenticationEnabled bool
 
+	readOnly int32 // non-zero rejects writes & metadata changes; accessed atomically
+
+	// The maximum time a query can run before it is aborted.
+	// A zero value means queries never time out.
+	QueryTimeout time.Duration
+
+	// The maximum number of points and series a select statement can read.
+	// A zero value means there is no limit.
+	MaxSelectPointN  int
+	MaxSelectSeriesN int
+
+	// The maximum number of queries each user can run concurrently and the
+	// maximum number of points each user's select statements can read.
+	// Queries without a user are not limited. A zero value means there is no limit.
+	MaxUserQueryN       int
+	MaxUserSelectPointN int
+
+	// The maximum number of aggregate query results to cache. Only statements
+	// that read closed shard groups are cached. A zero value disables the cache.
+	QueryCacheSize int
+
+	// Select statements running longer than this are logged and recorded in
+	// the slow query log. A zero value disables the slow query log.
+	SlowQueryThreshold time.Duration
+
+	// The shard group duration used by new retention policies that keep data
+	// forever and do not specify a shard group duration.
+	InfiniteShardGroupDuration time.Duration
+
+	// The shortest duration a finite retention policy can keep data for.
+	// A zero value disables the check.
+	MinRetentionPolicyDuration time.Duration
+
+	// The directory that store files of expired and deleted shard groups are
+	// moved into instead of being removed. A blank path deletes them.
+	ShardArchivePath string
+
+	// Called with the closed store file of each shard in an expired or deleted
+	// shard group instead of removing it. Takes precedence over ShardArchivePath.
+	ShardArchiveFunc func(database, policy string, sh *Shard, path string) error
+
+	// Returns the backend that metadata is stored in. Every data node in a
+	// cluster must use the same backend since a joining node opens a copy of
+	// another node's metastore. If nil, metadata is stored in a bolt data file.
+	NewMetaStore func() MetaStore
+
+	// The maximum number of series held in the in-memory series index. The
+	// series of the least recently used measurements are dropped from memory
+	// and reloaded from the metastore when they're next used. Queries that
+	// filter measurements by tag load every measurement of a database. If
+	// zero, every series is kept in memory.
+	SeriesIndexCacheSize int
+
+	// The maximum number of shard stores kept open. Shards are opened when
+	// they're read or written and the least recently used are closed once
+	// the limit is exceeded. If zero, every local shard is kept open.
+	MaxOpenShards int
+
+	// If set, shards are opened when they're first read or written instead
+	// of when the server loads.
+	LazyShardOpen bool
+
+	// Tuning of the bolt stores of local shards. Changes apply to shards
+	// opened afterwards.
+	ShardStoreOptions ShardStoreOptions
+
+	// The size, in bytes, past which a local shard is split. The shard stops
+	// receiving new points and a shard with the same owners is added to its
+	// group to receive them instead. If zero, shards grow without limit.
+	MaxShardSize int64
+
+	// The directory that cluster snapshots are written into. A blank path
+	// writes them to the "snapshots" directory under the server's path.
+	SnapshotPath string
+
+	// How long a successfully verified password is remembered so repeated
+	// requests skip the password hash comparison. A zero value disables it.
+	AuthenticationCacheTTL time.Duration
+
+	// The number of consecutive failed authentication attempts after which a
+	// user is locked out for AuthenticationLockout. Failed attempts further
+	// apart than AuthenticationLockout are not consecutive. Zero disables it.
+	MaxAuthenticationFailures int
+	AuthenticationLockout     time.Duration
+
+	// Hashes the passwords of users stored on the server.
+	// Defaults to bcrypt with a cost of DefaultBcryptCost.
+	PasswordHasher PasswordHasher
+
+	// Verifies user passwords in place of the password hashes stored on the
+	// server, e.g. against an LDAP directory. Users must still exist on the
+	// server, which provides their privileges. Nil uses the stored hashes.
+	Authenticator Authenticator
+
+	// The secret used to sign and verify bearer tokens.
+	// Token authentication is disabled if blank.
+	TokenSecret []byte
+
+	// The secret shared by all nodes to sign requests to join the cluster and
+	// copy the metastore. Node requests are not authenticated if blank.
+	NodeSecret []byte
+
+	// The number of times the seed URLs are tried when joining a cluster and
+	// the delay before the first retry. The delay doubles after each retry.
+	JoinAttempts int
+	JoinBackoff  time.Duration
+
+	// Returns a new messaging client when the message stream of the current
+	// client closes unexpectedly, such as when the broker connection is lost.
+	// The server resubscribes to its shards' topics and skips messages it has
+	// already applied. If nil, the server stops applying messages.
+	ReconnectClient func() (MessagingClient, error)
+
+	// The delay before retrying broker subscription changes that failed.
+	// The delay doubles after each failed retry.
+	SubscriptionRetryDelay time.Duration
+
+	// If true, writes are sent directly to the data nodes that own each shard
+	// instead of being published to the broker. Writes fall back to the broker
+	// if any owner of a shard can't be reached.
+	ForwardWrites bool
+
+	// If true, commands are broadcast as JSON instead of in the more compact
+	// binary encoding. Set while a cluster is upgraded from versions that
+	// only read JSON.
+	JSONCommands bool
+
+	// Sinks that record the administrative operations users perform, such as
+	// creating users or dropping databases. Statements that only read data are
+	// also recorded if AuditQueries is true.
+	AuditSinks   []AuditSink
+	AuditQueries bool
+
 	// continuous query settings
 	RecomputePreviousN     int
 	RecomputeNoOlderThan   time.Duration
 	ComputeRunsPerInterval int
 	ComputeNoMoreThan      time.Duration
 
-	// This is the last time this data node has run continuous queries.
-	// Keep this state in memory so if a broker makes a request in another second
-	// to compute, it won't rerun CQs that have already been run. If this data node
-	// is just getting the request after being off duty for running CQs then
-	// it will recompute all of them
-	lastContinuousQueryRun time.Time
+	// The most continuous queries that run at the same time. Queries that are
+	// due wait for a running query to finish. A zero value doesn't limit them.
+	MaxConcurrentContinuousQueries int
+
+	// Delays the start of each continuous query run by up to this duration so
+	// queries with the same interval don't all start at once. The delay is
+	// derived from the query's name so a query always starts at the same offset.
+	ContinuousQueryJitter time.Duration
+
+	// Writers for the results of continuous queries, keyed by target database.
+	// Results for a database with a writer are sent to it instead of being
+	// written locally. The target database must still exist on this server.
+	ContinuousQueryWriters map[string]PointsWriter
 }
 
 // NewServer returns a new instance of Server.
 func NewServer() *Server {
 	s := Server{
-		meta:      &metastore{},
-		errors:    make(map[uint64]error),
+		meta:          &metastore{},
+		errors:        newMessageErrors(DefaultMaxMessageErrors, DefaultMessageErrorAge),
+		topicIndex:    make(map[uint64]uint64),
+		subscriptions: newSubscriptionSet(),
+
 		dataNodes: make(map[uint64]*DataNode),
 		databases: make(map[string]*database),
 		users:     make(map[string]*User),
+		roles:     make(map[string]*Role),
+		apiTokens: make(map[string]*APIToken),
 
 		shards:           make(map[uint64]*Shard),
 		shardsBySeriesID: make(map[uint32][]*Shard),
+		shardSplits:      make(map[uint64]bool),
+		stats:            NewStats("server"),
+		slowQueries:      NewSlowQueryLog(DefaultSlowQueryLogSize),
+		replicas:         newReplicaSelector(),
+		queryCache:       newQueryCache(),
+		authCache:        newAuthCache(),
+		authLockout:      newAuthLockout(),
+		userQueryN:       make(map[string]int),
+		cqPending:        make(map[*ContinuousQuery]bool),
 		Logger:           log.New(os.Stderr, "[server] ", log.LstdFlags),
+
+		InfiniteShardGroupDuration:     DefaultShardDuration,
+		MaxConcurrentContinuousQueries: DefaultMaxConcurrentContinuousQueries,
+		JoinAttempts:                   DefaultJoinAttempts,
+		JoinBackoff:                    DefaultJoinBackoff,
+		SubscriptionRetryDelay:         DefaultSubscriptionRetryDelay,
+		PasswordHasher:                 &BcryptHasher{Cost: DefaultBcryptCost},
 	}
 	// Server will always return with authentication enabled.
 	// This ensures that disabling authentication must be an explicit decision.
@@ -148,6 +438,22 @@ func (s *Server) SetAuthenticationEnabled(enabled bool) {
 	s.authenticationEnabled = enabled
 }
 
+// SetReadOnly turns on or off read-only mode. While read-only, the server
+// rejects writes, metadata changes and continuous query runs with ErrReadOnly
+// but still serves queries. Only requests made to this server are affected.
+func (s *Server) SetReadOnly(readOnly bool) {
+	var v int32
+	if readOnly {
+		v = 1
+	}
+	atomic.StoreInt32(&s.readOnly, v)
+}
+
+// ReadOnly returns true if the server is in read-only mode.
+func (s *Server) ReadOnly() bool {
+	return atomic.LoadInt32(&s.readOnly) != 0
+}
+
 // ID returns the data node id for the server.
 // Returns zero if the server is closed or the server has not joined a cluster.
 func (s *Server) ID() uint64 {
@@ -163,6 +469,12 @@ func (s *Server) Index() uint64 {
 	return s.index
 }
 
+// Stats returns a snapshot of the server's internal counters.
+func (s *Server) Stats() *Stats { return s.stats.Snapshot() }
+
+// SlowQueries returns the most recent slow select statements, oldest first.
+func (s *Server) SlowQueries() []*SlowQuery { return s.slowQueries.Queries() }
+
 // Path returns the path used when opening the server.
 // Returns an empty string when the server is closed.
 func (s *Server) Path() string {
@@ -171,6 +483,16 @@ func (s *Server) Path() string {
 	return s.path
 }
 
+// loadMeasurementSeries returns the series of a measurement from the
+// metastore. Lock must be held.
+func (s *Server) loadMeasurementSeries(database, name string) (a []*Series) {
+	_ = s.meta.mustView(func(tx *metatx) error {
+		a = tx.measurementSeries(database, name)
+		return nil
+	})
+	return
+}
+
 // shardPath returns the path for a shard.
 func (s *Server) shardPath(id uint64) string {
 	if s.path == "" {
@@ -179,6 +501,51 @@ func (s *Server) shardPath(id uint64) string {
 	return filepath.Join(s.path, "shards", strconv.FormatUint(id, 10))
 }
 
+// shardPolicy returns the database and retention policy a shard belongs to.
+// Returns nils if the shard doesn't exist. Must be called with the lock held.
+func (s *Server) shardPolicy(id uint64) (*database, *RetentionPolicy) {
+	for _, db := range s.databases {
+		for _, rp := range db.policies {
+			for _, g := range rp.shardGroups {
+				for _, sh := range g.Shards {
+					if sh.ID == id {
+						return db, rp
+					}
+				}
+			}
+		}
+	}
+	return nil, nil
+}
+
+// openShards opens the stores of shards assigned to this server concurrently.
+// A shard that fails to open is logged and left closed so that the other
+// shards are still opened.
+func (s *Server) openShards(a []*Shard) {
+	var wg sync.WaitGroup
+	throttle := make(chan struct{}, runtime.GOMAXPROCS(0))
+	for _, sh := range a {
+		wg.Add(1)
+		throttle <- struct{}{}
+		go func(sh *Shard) {
+			defer func() { <-throttle; wg.Done() }()
+			if err := s.openShard(sh); err != nil {
+				log.Printf("cannot open shard store: id=%d, err=%s", sh.ID, err)
+				s.stats.Inc("shardOpenErrors")
+			}
+		}(sh)
+	}
+	wg.Wait()
+}
+
+// openShard opens the store of a shard assigned to this server. If the number
+// of open stores is limited or shards are opened lazily then the store is
+// opened when it's first used.
+func (s *Server) openShard(sh *Shard) error {
+	sh.cache = s.shardStores
+	return sh.open(s.shardPath(sh.ID), &s.ShardStoreOptions)
+}
+
 // metaPath returns the path for the metastore.
 func (s *Server) metaPath() string {
 	if s.path == "" {
@@ -187,11 +554,31 @@ func (s *Server) metaPath() string {
 	return filepath.Join(s.path, "meta")
 }
 
+// newMetastore returns an unopened metastore using the configured backend.
+func (s *Server) newMetastore() *metastore {
+	var m *metastore
+	if s.NewMetaStore != nil {
+		m = newMetastore(s.NewMetaStore())
+	} else {
+		m = newMetastore(&boltMetaStore{})
+	}
+	m.stats = s.stats
+	return m
+}
+
 // SetLogOutput sets writer for all Server log output.
 func (s *Server) SetLogOutput(w io.Writer) {
 	s.Logger = log.New(w, "[server] ", log.LstdFlags)
 }
 
+// SetContinuousQueryErrorHandler sets the handler notified when a continuous
+// query run fails. A nil handler only logs failures.
+func (s *Server) SetContinuousQueryErrorHandler(h ContinuousQueryErrorHandler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cqErrorHandler = h
+}
+
 // Open initializes the server from a given path.
 func (s *Server) Open(path string) error {
 	// Ensure the server isn't already open and there's a path provided.
@@ -203,6 +590,7 @@ func (s *Server) Open(path string) error {
 
 	// Set the server path.
 	s.path = path
+	s.cqDone = make(chan struct{})
 
 	// Create required directories.
 	if err := os.MkdirAll(path, 0700); err != nil {
@@ -213,6 +601,7 @@ func (s *Server) Open(path string) error {
 	}
 
 	// Open metadata store.
+	s.meta = s.newMetastore()
 	if err := s.meta.open(s.metaPath()); err != nil {
 		return fmt.Errorf("meta: %s", err)
 	}
@@ -222,6 +611,18 @@ func (s *Server) Open(path string) error {
 		return fmt.Errorf("load: %s", err)
 	}
 
+	// Resume from the last messages applied before the server was closed so
+	// they aren't applied again when the broker replays them.
+	_ = s.meta.view(func(tx *metatx) error {
+		s.topicIndex = tx.topicIndexes()
+		return nil
+	})
+	for _, index := range s.topicIndex {
+		if index > s.index {
+			s.index = index
+		}
+	}
+
 	// TODO: Open shard data stores.
 	// TODO: Associate series ids with shards.
 
@@ -233,6 +634,17 @@ func (s *Server) opened() bool { return s.path != "" }
 
 // Close shuts down the server.
 func (s *Server) Close() error {
+	// Wait for an in-flight retention sweep, repair, metastore verification,
+	// metastore or shard compaction or series collection before tearing down
+	// state.
+	s.StopRetentionPolicyEnforcement()
+	s.StopShardRepair()
+	s.StopMetastoreVerification()
+	s.StopMetastoreCompaction()
+	s.StopShardCompaction()
+	s.StopSeriesCollection()
+	s.stopContinuousQueries()
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -240,17 +652,14 @@ func (s *Server) Close() error {
 		return ErrServerClosed
 	}
 
-	if s.rpDone != nil {
-		close(s.rpDone)
-	}
-
 	// Remove path.
 	s.path = ""
 
 	// Close message processing.
 	s.setClient(nil)
 
-	// Close metastore.
+	// Save the indexes applied since they were last saved and close metastore.
+	s.saveTopicIndexes()
 	_ = s.meta.close()
 
 	// Close shards.
@@ -273,53 +682,107 @@ func (s *Server) load() error {
 			s.dataNodes[node.ID] = node
 		}
 
-		// Load databases.
+		// Load databases and read their indexes in a single pass. Only the
+		// number of series is read if series are loaded when they're used.
 		s.databases = make(map[string]*database)
-		for _, db := range tx.databases() {
+		s.seriesIndex = nil
+		if s.SeriesIndexCacheSize > 0 {
+			s.seriesIndex = newSeriesIndexCache(s.SeriesIndexCacheSize, s.loadMeasurementSeries)
+		}
+		dbs := tx.databases()
+		indexes := make([]*databaseIndex, len(dbs))
+		for i, db := range dbs {
+			db.cache = s.seriesIndex
 			s.databases[db.name] = db
+			indexes[i] = tx.databaseIndex(db.name, db.cache == nil)
+		}
 
-			// load the index
+		// Decode the indexes concurrently while the transaction is still open.
+		var wg sync.WaitGroup
+		throttle := make(chan struct{}, runtime.GOMAXPROCS(0))
+		for i, db := range dbs {
 			log.Printf("Loading metadata index for %s\n", db.name)
-			err := s.meta.view(func(tx *metatx) error {
-				tx.indexDatabase(db)
-				return nil
-			})
-			if err != nil {
-				return err
+			wg.Add(1)
+			throttle <- struct{}{}
+			go func(db *database, idx *databaseIndex) {
+				defer func() { <-throttle; wg.Done() }()
+				db.loadIndex(idx)
+			}(db, indexes[i])
+		}
+		wg.Wait()
+
+		// Qualify the measurements of continuous queries now that all databases are loaded.
+		for _, db := range s.databases {
+			for _, cq := range db.continuousQueries {
+				if err := s.normalizeStatement(cq.cq.Source, cq.cq.Database); err != nil {
+					log.Printf("cannot normalize continuous query %s: %s", cq.cq.Name, err)
+				}
 			}
 		}
 
-		// Open all shards.
+		// Open all shards assigned to this server.
+		s.shardStores = nil
+		if s.MaxOpenShards > 0 || s.LazyShardOpen {
+			s.shardStores = newShardStoreCache(s.MaxOpenShards)
+		}
+		var local []*Shard
 		for _, db := range s.databases {
 			for _, rp := range db.policies {
 				for _, g := range rp.shardGroups {
 					for _, sh := range g.Shards {
-						if err := sh.open(s.shardPath(sh.ID)); err != nil {
-							return fmt.Errorf("cannot open shard store: id=%d, err=%s", sh.ID, err)
+						if sh.HasDataNodeID(s.id) {
+							local = append(local, sh)
 						}
 					}
 				}
 			}
 		}
+		s.openShards(local)
+
+		// Load roles.
+		s.roles = make(map[string]*Role)
+		for _, r := range tx.roles() {
+			s.roles[r.Name] = r
+		}
 
-		// Load users.
+		// Load users and attach their roles.
 		s.users = make(map[string]*User)
 		for _, u := range tx.users() {
+			for _, name := range u.Roles {
+				if r := s.roles[name]; r != nil {
+					u.roles = append(u.roles, r)
+				}
+			}
 			s.users[u.Name] = u
 		}
 
+		// Load api tokens.
+		s.apiTokens = make(map[string]*APIToken)
+		for _, t := range tx.apiTokens() {
+			s.apiTokens[t.ID] = t
+		}
+
 		return nil
 	})
 }
 
-//  StartRetentionPolicyEnforcement launches retention policy enforcement.
+// StartRetentionPolicyEnforcement launches retention policy enforcement.
 func (s *Server) StartRetentionPolicyEnforcement(checkInterval time.Duration) error {
 	if checkInterval == 0 {
 		return fmt.Errorf("retention policy check interval must be non-zero")
 	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.rpDone != nil {
+		return fmt.Errorf("retention policy enforcement already started")
+	}
+
 	rpDone := make(chan struct{}, 0)
 	s.rpDone = rpDone
+	s.rpWG.Add(1)
 	go func() {
+		defer s.rpWG.Done()
 		for {
 			select {
 			case <-rpDone:
@@ -332,25 +795,203 @@ func (s *Server) StartRetentionPolicyEnforcement(checkInterval time.Duration) er
 	return nil
 }
 
+// StopRetentionPolicyEnforcement stops retention policy enforcement. It waits
+// for an in-flight enforcement check to finish before returning.
+func (s *Server) StopRetentionPolicyEnforcement() {
+	s.mu.Lock()
+	rpDone := s.rpDone
+	s.rpDone = nil
+	s.mu.Unlock()
+
+	if rpDone != nil {
+		close(rpDone)
+	}
+	s.rpWG.Wait()
+}
+
 // EnforceRetentionPolicies ensures that data that is aging-out due to retention policies
-// is removed from the server.
+// is removed from the server. Only the reachable data node with the lowest ID requests
+// a sweep so that expired shard groups are deleted once across the cluster.
 func (s *Server) EnforceRetentionPolicies() {
+	if !s.isRetentionLeader() {
+		return
+	}
 	log.Println("retention policy enforcement check commencing")
 
-	// Check all shard groups.
+	c := &retentionSweepCommand{Time: time.Now().UTC()}
+	if _, err := s.broadcast(retentionSweepMessageType, c); err != nil {
+		log.Printf("failed to request retention sweep: %s", err.Error())
+	}
+}
+
+// isRetentionLeader returns true if the server is the reachable data node with
+// the lowest ID. Nodes with a lower ID that don't respond to a ping are skipped
+// so that a node that is down doesn't stop enforcement across the cluster.
+func (s *Server) isRetentionLeader() bool {
+	s.mu.RLock()
+	if s.id == 0 {
+		s.mu.RUnlock()
+		return false
+	}
+	var urls []*url.URL
+	for id, n := range s.dataNodes {
+		if id < s.id && n.URL != nil {
+			urls = append(urls, n.URL)
+		}
+	}
+	s.mu.RUnlock()
+
+	for _, u := range urls {
+		if pingDataNode(u, DefaultDataNodePingTimeout) {
+			return false
+		}
+	}
+	return true
+}
+
+// applyRetentionSweep deletes every shard group that aged out of its retention
+// policy as of the time the sweep was requested. Every node evaluates the same
+// sweep against the same metadata so they all delete the same shard groups.
+func (s *Server) applyRetentionSweep(m *messaging.Message) (err error) {
+	var c retentionSweepCommand
+	mustDecodeCommand(m.Data, &c)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	for _, db := range s.databases {
+		for _, rp := range db.policies {
+			for _, g := range rp.expiredShardGroups(c.Time) {
+				log.Printf("shard group %d, retention policy %s, database %s due for deletion",
+					g.ID, rp.Name, db.name)
+				if err := s.deleteShardGroup(db, rp, g); err != nil {
+					return err
+				}
+			}
+		}
+
+		if err := s.expireMeasurementPoints(db, c.Time); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// expireMeasurementPoints deletes the points of measurements with a TTL that are
+// older than the TTL as of now from the shards stored on this server.
+// Must be called with the lock held.
+func (s *Server) expireMeasurementPoints(db *database, now time.Time) error {
+	for _, m := range db.measurements {
+		if m.TTL == 0 {
+			continue
+		}
+		tmax := now.Add(-m.TTL)
+
+		var n int
 		for _, rp := range db.policies {
 			for _, g := range rp.shardGroups {
-				if g.EndTime.Add(rp.Duration).Before(time.Now()) {
-					log.Printf("shard group %d, retention policy %s, database %s due for deletion",
-						g.ID, rp.Name, db.name)
-					if err := s.DeleteShardGroup(db.name, rp.Name, g.ID); err != nil {
-						log.Printf("failed to request deletion of shard group %d: %s", g.ID, err.Error())
+				// Ignore groups that only hold points within the TTL.
+				if !g.StartTime.Before(tmax) {
+					continue
+				}
+
+				for _, sh := range g.Shards {
+					if !sh.HasDataNodeID(s.id) || !sh.hasStore() {
+						continue
+					}
+					deleted, err := sh.deletePointsBefore(m.index().seriesIDs, tmax.UnixNano())
+					if err != nil {
+						return fmt.Errorf("expire measurement %s in shard %d: %s", m.Name, sh.ID, err)
+					}
+					n += deleted
+				}
+			}
+		}
+
+		if n > 0 {
+			log.Printf("deleted %d points older than ttl %s from measurement %s, database %s", n, m.TTL, m.Name, db.name)
+
+			// Cached results may include the deleted points.
+			s.queryCache.clear()
+		}
+	}
+	return nil
+}
+
+type retentionSweepCommand struct {
+	Time time.Time `json:"time"`
+}
+
+// RetentionPolicyReport returns the shard groups that would be deleted if
+// retention policies were enforced now. Nothing is deleted.
+func (s *Server) RetentionPolicyReport() (*RetentionPolicyReport, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	r := &RetentionPolicyReport{Time: time.Now().UTC()}
+	for _, db := range s.databases {
+		for _, rp := range db.policies {
+			for _, g := range rp.expiredShardGroups(r.Time) {
+				e := &ExpiredShardGroup{
+					Database:  db.name,
+					Policy:    rp.Name,
+					ID:        g.ID,
+					StartTime: g.StartTime,
+					EndTime:   g.EndTime,
+				}
+
+				// Only shards stored on this server count towards its reclaimed size.
+				for _, sh := range g.Shards {
+					if !sh.HasDataNodeID(s.id) {
+						continue
+					}
+					n, err := sh.size()
+					if err != nil {
+						return nil, err
 					}
+					e.Size += n
 				}
+
+				r.ShardGroups = append(r.ShardGroups, e)
+				r.Size += e.Size
 			}
 		}
 	}
+	sort.Sort(expiredShardGroups(r.ShardGroups))
+
+	return r, nil
+}
+
+// RetentionPolicyReport describes what the next retention enforcement run would delete.
+type RetentionPolicyReport struct {
+	Time        time.Time            `json:"time"`
+	ShardGroups []*ExpiredShardGroup `json:"shardGroups,omitempty"`
+	Size        int64                `json:"size"` // bytes reclaimed on this server
+}
+
+// ExpiredShardGroup represents a shard group that has aged out of its retention policy.
+type ExpiredShardGroup struct {
+	Database  string    `json:"database"`
+	Policy    string    `json:"policy"`
+	ID        uint64    `json:"id"`
+	StartTime time.Time `json:"startTime"`
+	EndTime   time.Time `json:"endTime"`
+	Size      int64     `json:"size"` // bytes stored on this server
+}
+
+// expiredShardGroups represents a list of expired shard groups sorted by database, policy and id.
+type expiredShardGroups []*ExpiredShardGroup
+
+func (a expiredShardGroups) Len() int      { return len(a) }
+func (a expiredShardGroups) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a expiredShardGroups) Less(i, j int) bool {
+	if a[i].Database != a[j].Database {
+		return a[i].Database < a[j].Database
+	} else if a[i].Policy != a[j].Policy {
+		return a[i].Policy < a[j].Policy
+	}
+	return a[i].ID < a[j].ID
 }
 
 // Client retrieves the current messaging client.
@@ -382,22 +1023,84 @@ func (s *Server) setClient(client MessagingClient) error {
 	// Set the messaging client.
 	s.client = client
 
-	// Start goroutine to read messages from the broker.
+	// Start goroutines to read messages from the broker and to subscribe to
+	// the topics of the local shards.
 	if client != nil {
 		done := make(chan struct{}, 0)
 		s.done = done
 		go s.processor(client, done)
+
+		_, notify := s.subscriptions.reset()
+		go s.manageSubscriptions(notify, done)
 	}
 
 	return nil
 }
 
+// reconnectClient replaces the messaging client after its message stream has
+// closed and resubscribes to the topics of the local shards. Attempts repeat
+// until a client is returned or the client is replaced or removed elsewhere.
+func (s *Server) reconnectClient(done chan struct{}) {
+	s.stats.Inc("brokerDisconnect")
+	if s.ReconnectClient == nil {
+		log.Printf("broker message stream closed, no longer applying messages")
+		return
+	}
+
+	var client MessagingClient
+	for {
+		log.Printf("broker message stream closed, reconnecting")
+		var err error
+		if client, err = s.ReconnectClient(); err == nil {
+			break
+		}
+		log.Printf("reconnect broker client: %s", err)
+
+		select {
+		case <-done:
+			return
+		case <-time.After(DefaultClientReconnectDelay):
+		}
+	}
+
+	// Attach the client unless the server was closed or given a new client.
+	// A client that isn't attached is closed so its connection isn't leaked.
+	s.mu.Lock()
+	if s.done != done {
+		s.mu.Unlock()
+		closeClient(client)
+		return
+	}
+	if err := s.setClient(client); err != nil {
+		s.mu.Unlock()
+		log.Printf("set broker client: %s", err)
+		closeClient(client)
+		return
+	}
+	s.mu.Unlock()
+	s.stats.Inc("brokerReconnect")
+}
+
+// closeClient closes a messaging client if it can be closed.
+func closeClient(client MessagingClient) {
+	if c, ok := client.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			log.Printf("close broker client: %s", err)
+		}
+	}
+}
+
 // broadcast encodes a message as JSON and send it to the broker's broadcast topic.
 // This function waits until the message has been processed by the server.
 // Returns the broker log index of the message or an error.
 func (s *Server) broadcast(typ messaging.MessageType, c interface{}) (uint64, error) {
+	// Metadata cannot be changed in read-only mode.
+	if s.ReadOnly() {
+		return 0, ErrReadOnly
+	}
+
 	// Encode the command.
-	data, err := json.Marshal(c)
+	data, err := encodeCommand(c, s.JSONCommands)
 	if err != nil {
 		return 0, err
 	}
@@ -412,6 +1115,7 @@ func (s *Server) broadcast(typ messaging.MessageType, c interface{}) (uint64, er
 	if err != nil {
 		return 0, err
 	}
+	s.setBrokerIndex(index)
 
 	// Wait for the server to receive the message.
 	err = s.Sync(index)
@@ -420,23 +1124,25 @@ func (s *Server) broadcast(typ messaging.MessageType, c interface{}) (uint64, er
 }
 
 // Sync blocks until a given index (or a higher index) has been applied.
-// Returns any error associated with the command.
+// Returns any error associated with the command, unless the error was
+// dropped because too many were held or it was held for too long.
 func (s *Server) Sync(index uint64) error {
 	for {
 		// Check if index has occurred. If so, retrieve the error and return.
-		s.mu.RLock()
+		s.mu.Lock()
 		if s.index >= index {
-			err, ok := s.errors[index]
-			if ok {
-				delete(s.errors, index)
-			}
-			s.mu.RUnlock()
+			err := s.errors.take(index)
+			s.mu.Unlock()
 			return err
 		}
-		s.mu.RUnlock()
 
-		// Otherwise wait momentarily and check again.
-		time.Sleep(1 * time.Millisecond)
+		// Otherwise wait for the processor to apply the next message.
+		if s.indexCh == nil {
+			s.indexCh = make(chan struct{})
+		}
+		ch := s.indexCh
+		s.mu.Unlock()
+		<-ch
 	}
 }
 
@@ -461,7 +1167,9 @@ func (s *Server) Initialize(u *url.URL) error {
 	}
 
 	// Set the ID on the server.
+	s.mu.Lock()
 	s.id = 1
+	s.mu.Unlock()
 
 	return nil
 }
@@ -474,41 +1182,51 @@ type dataNodeJSON struct {
 }
 
 // Join creates a new data node in an existing cluster, copies the metastore,
-// and initializes the ID.
-func (s *Server) Join(u *url.URL, joinURL *url.URL) error {
+// and initializes the ID. Each seed URL is tried in order until one succeeds.
+// The seeds are retried up to JoinAttempts times, waiting JoinBackoff before
+// the second round and doubling the wait after each round.
+func (s *Server) Join(u *url.URL, joinURLs ...*url.URL) error {
+	if len(joinURLs) == 0 {
+		return ErrUnableToJoin
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	// Encode data node request.
-	var buf bytes.Buffer
-	if err := json.NewEncoder(&buf).Encode(&dataNodeJSON{URL: u.String()}); err != nil {
-		return err
-	}
-
-	// Send request.
-	joinURL = copyURL(joinURL)
-	joinURL.Path = "/data_nodes"
-	resp, err := http.Post(joinURL.String(), "application/octet-stream", &buf)
-	if err != nil {
-		return err
+	attempts, backoff := s.JoinAttempts, s.JoinBackoff
+	if attempts < 1 {
+		attempts = 1
 	}
-	defer resp.Body.Close()
 
-	// Check if created.
-	if resp.StatusCode != http.StatusCreated {
-		return ErrUnableToJoin
+	var err error
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		for _, joinURL := range joinURLs {
+			if err = s.join(u, joinURL); err == nil {
+				return nil
+			}
+			log.Printf("join: %s: %s", joinURL, err)
+		}
 	}
+	return err
+}
 
-	// Decode response.
-	var n dataNodeJSON
-	if err := json.NewDecoder(resp.Body).Decode(&n); err != nil {
+// join joins the cluster through a single seed. The data node is reused if an
+// earlier attempt already created it. Lock must be held by caller.
+func (s *Server) join(u *url.URL, joinURL *url.URL) error {
+	n, err := s.joinDataNode(u, joinURL)
+	if err != nil {
 		return err
 	}
 	assert(n.ID > 0, "invalid join node id returned: %d", n.ID)
 
 	// Download the metastore from joining server.
+	joinURL = copyURL(joinURL)
 	joinURL.Path = "/metastore"
-	resp, err = http.Get(joinURL.String())
+	resp, err := s.doNodeRequest("GET", joinURL, nil)
 	if err != nil {
 		return err
 	}
@@ -523,24 +1241,32 @@ func (s *Server) Join(u *url.URL, joinURL *url.URL) error {
 		return fmt.Errorf("cannot parse meta size: %s", err)
 	}
 
-	// Close the metastore.
-	_ = s.meta.close()
-
-	// Overwrite the metastore.
-	f, err := os.Create(s.metaPath())
+	// Copy to a temporary file so a failed download leaves the metastore intact.
+	tmpPath := s.metaPath() + ".join"
+	defer os.Remove(tmpPath)
+	f, err := os.Create(tmpPath)
 	if err != nil {
 		return fmt.Errorf("create meta file: %s", err)
 	}
-
-	// Copy and check size.
 	if _, err := io.CopyN(f, resp.Body, sz); err != nil {
 		_ = f.Close()
 		return fmt.Errorf("copy meta file: %s", err)
 	}
 	_ = f.Close()
 
+	// Verify the metastore belongs to the cluster that created the data node.
+	if err := s.validateJoinMetastore(tmpPath, n.ID, u); err != nil {
+		return err
+	}
+
+	// Close the metastore and overwrite it.
+	_ = s.meta.close()
+	if err := os.Rename(tmpPath, s.metaPath()); err != nil {
+		return fmt.Errorf("replace meta file: %s", err)
+	}
+
 	// Reopen metastore.
-	s.meta = &metastore{}
+	s.meta = s.newMetastore()
 	if err := s.meta.open(s.metaPath()); err != nil {
 		return fmt.Errorf("reopen meta: %s", err)
 	}
@@ -561,16 +1287,124 @@ func (s *Server) Join(u *url.URL, joinURL *url.URL) error {
 	return nil
 }
 
-// CopyMetastore writes the underlying metastore data file to a writer.
-func (s *Server) CopyMetastore(w io.Writer) error {
-	return s.meta.mustView(func(tx *metatx) error {
+// joinDataNode creates a data node for u through a seed. If the data node
+// already exists then the existing node is looked up and returned.
+func (s *Server) joinDataNode(u *url.URL, joinURL *url.URL) (*dataNodeJSON, error) {
+	// Encode data node request.
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(&dataNodeJSON{URL: u.String()}); err != nil {
+		return nil, err
+	}
+
+	// Send request.
+	joinURL = copyURL(joinURL)
+	joinURL.Path = "/data_nodes"
+	resp, err := s.doNodeRequest("POST", joinURL, &buf)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusCreated:
+		var n dataNodeJSON
+		if err := json.NewDecoder(resp.Body).Decode(&n); err != nil {
+			return nil, err
+		}
+		return &n, nil
+
+	case http.StatusConflict:
+		// Find the data node created by an earlier attempt.
+		resp, err := s.doNodeRequest("GET", joinURL, nil)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, ErrUnableToJoin
+		}
+
+		var a []*dataNodeJSON
+		if err := json.NewDecoder(resp.Body).Decode(&a); err != nil {
+			return nil, err
+		}
+		for _, n := range a {
+			if n.URL == u.String() {
+				return n, nil
+			}
+		}
+		return nil, ErrUnableToJoin
+
+	default:
+		return nil, ErrUnableToJoin
+	}
+}
+
+// validateJoinMetastore returns an error if the metastore at path doesn't
+// contain the data node with the given id & URL.
+func (s *Server) validateJoinMetastore(path string, id uint64, u *url.URL) error {
+	m := s.newMetastore()
+	if err := m.open(path); err != nil {
+		return fmt.Errorf("open meta copy: %s", err)
+	}
+	defer m.close()
+
+	return m.view(func(tx *metatx) error {
+		for _, n := range tx.dataNodes() {
+			if n.ID == id && n.URL.String() == u.String() {
+				return nil
+			}
+		}
+		return ErrClusterMismatch
+	})
+}
+
+// doNodeRequest sends a request to another node, signed with the node secret if set.
+func (s *Server) doNodeRequest(method string, u *url.URL, body io.Reader) (*http.Response, error) {
+	return s.doNodeRequestClient(http.DefaultClient, method, u, body)
+}
+
+// doNodeRequestClient sends a signed request to another node using client.
+func (s *Server) doNodeRequestClient(client *http.Client, method string, u *url.URL, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if method == "POST" {
+		req.Header.Set("Content-Type", "application/octet-stream")
+	}
+	if len(s.NodeSecret) > 0 {
+		if err := SignNodeRequest(req, s.NodeSecret, time.Now()); err != nil {
+			return nil, err
+		}
+	}
+	return client.Do(req)
+}
+
+// VerifyNodeRequest returns nil if a request from another node is signed with
+// the node secret. If no node secret is set then requests are only allowed
+// while authentication is disabled.
+func (s *Server) VerifyNodeRequest(r *http.Request) error {
+	if len(s.NodeSecret) == 0 {
+		if s.authenticationEnabled {
+			return ErrNodeSecretRequired
+		}
+		return nil
+	}
+	return VerifyNodeRequest(r, s.NodeSecret, time.Now())
+}
+
+// CopyMetastore writes the underlying metastore data file to a writer.
+func (s *Server) CopyMetastore(w io.Writer) error {
+	return s.meta.mustView(func(tx *metatx) error {
 		// Set content lengh if this is a HTTP connection.
 		if w, ok := w.(http.ResponseWriter); ok {
 			w.Header().Set("Content-Length", strconv.Itoa(int(tx.Size())))
 		}
 
 		// Write entire database to the writer.
-		return tx.Copy(w)
+		_, err := tx.WriteTo(w)
+		return err
 	})
 }
 
@@ -604,6 +1438,18 @@ func (s *Server) DataNodes() (a []*DataNode) {
 	return
 }
 
+// shardReplicas returns the data nodes that own a shard in the order they
+// should be read from. Unknown data nodes are skipped.
+// This function must be called with the lock held.
+func (s *Server) shardReplicas(sh *Shard) (a []*DataNode) {
+	for _, id := range s.replicas.order(sh.ID, sh.DataNodeIDs, DefaultReplicaFailureBackoff, time.Now()) {
+		if n := s.dataNodes[id]; n != nil {
+			a = append(a, n)
+		}
+	}
+	return
+}
+
 // CreateDataNode creates a new data node with a given URL.
 func (s *Server) CreateDataNode(u *url.URL) error {
 	c := &createDataNodeCommand{URL: u.String()}
@@ -613,7 +1459,7 @@ func (s *Server) CreateDataNode(u *url.URL) error {
 
 func (s *Server) applyCreateDataNode(m *messaging.Message) (err error) {
 	var c createDataNodeCommand
-	mustUnmarshalJSON(m.Data, &c)
+	mustDecodeCommand(m.Data, &c)
 
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -651,6 +1497,100 @@ type createDataNodeCommand struct {
 	URL string `json:"url"`
 }
 
+// UpdateDataNode changes the URL of an existing data node, such as after the
+// node's address has changed.
+func (s *Server) UpdateDataNode(id uint64, u *url.URL) error {
+	c := &updateDataNodeCommand{ID: id}
+	if u != nil {
+		c.URL = u.String()
+	}
+	_, err := s.broadcast(updateDataNodeMessageType, c)
+	return err
+}
+
+func (s *Server) applyUpdateDataNode(m *messaging.Message) (err error) {
+	var c updateDataNodeCommand
+	mustDecodeCommand(m.Data, &c)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Validate parameters.
+	n := s.dataNodes[c.ID]
+	if n == nil {
+		return ErrDataNodeNotFound
+	} else if c.URL == "" {
+		return ErrDataNodeURLRequired
+	}
+
+	// Check that another node with the same URL doesn't already exist.
+	u, _ := url.Parse(c.URL)
+	for _, other := range s.dataNodes {
+		if other.ID != n.ID && other.URL.String() == u.String() {
+			return ErrDataNodeExists
+		}
+	}
+
+	// Update a copy and persist to metastore.
+	other := *n
+	other.URL = u
+	if err = s.meta.mustUpdate(func(tx *metatx) error {
+		return tx.saveDataNode(&other)
+	}); err != nil {
+		return
+	}
+
+	// Update the node on the server.
+	*n = other
+
+	return
+}
+
+type updateDataNodeCommand struct {
+	ID  uint64 `json:"id"`
+	URL string `json:"url"`
+}
+
+// SetDataNodeZone sets the zone of an existing data node. A blank zone
+// removes the node from any zone.
+func (s *Server) SetDataNodeZone(id uint64, zone string) error {
+	c := &setDataNodeZoneCommand{ID: id, Zone: zone}
+	_, err := s.broadcast(setDataNodeZoneMessageType, c)
+	return err
+}
+
+func (s *Server) applySetDataNodeZone(m *messaging.Message) (err error) {
+	var c setDataNodeZoneCommand
+	mustDecodeCommand(m.Data, &c)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := s.dataNodes[c.ID]
+	if n == nil {
+		return ErrDataNodeNotFound
+	}
+
+	// Update a copy and persist to metastore.
+	other := *n
+	other.Zone = c.Zone
+	if err = s.meta.mustUpdate(func(tx *metatx) error {
+		return tx.saveDataNode(&other)
+	}); err != nil {
+		return
+	}
+
+	// Update the node on the server.
+	*n = other
+
+	return
+}
+
+type setDataNodeZoneCommand struct {
+	ID   uint64 `json:"id"`
+	Zone string `json:"zone"`
+}
+
 // DeleteDataNode deletes an existing data node.
 func (s *Server) DeleteDataNode(id uint64) error {
 	c := &deleteDataNodeCommand{ID: id}
@@ -660,7 +1600,7 @@ func (s *Server) DeleteDataNode(id uint64) error {
 
 func (s *Server) applyDeleteDataNode(m *messaging.Message) (err error) {
 	var c deleteDataNodeCommand
-	mustUnmarshalJSON(m.Data, &c)
+	mustDecodeCommand(m.Data, &c)
 
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -682,6 +1622,276 @@ type deleteDataNodeCommand struct {
 	ID uint64 `json:"id"`
 }
 
+// RemoveDataNode removes a data node from the cluster. Unless forced, the
+// node's shards are first moved to the remaining data nodes as with
+// DecommissionDataNode. Forcing is for a node that is permanently unreachable:
+// the node is dropped as an owner of its shards without copying any data, the
+// shards are marked as degraded, and new replicas are copied from the
+// surviving owners in the background. A shard with no surviving owner is
+// reassigned to another data node without its data.
+func (s *Server) RemoveDataNode(id uint64, force bool) error {
+	if !force {
+		return s.DecommissionDataNode(id)
+	}
+
+	c := &removeDataNodeCommand{ID: id}
+	if _, err := s.broadcast(removeDataNodeMessageType, c); err != nil {
+		return err
+	}
+	s.scheduleShardReplication()
+	return nil
+}
+
+func (s *Server) applyRemoveDataNode(m *messaging.Message) error {
+	var c removeDataNodeCommand
+	mustDecodeCommand(m.Data, &c)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := s.dataNodes[c.ID]
+	if n == nil {
+		return ErrDataNodeNotFound
+	}
+
+	// Update the owned shards in id order so every server picks the same replacements.
+	var shards []*Shard
+	owned := make(map[uint64]bool)
+	for _, sh := range s.shards {
+		if sh.HasDataNodeID(c.ID) {
+			shards = append(shards, sh)
+			owned[sh.ID] = true
+		}
+	}
+	sort.Sort(shardsByID(shards))
+
+	for _, sh := range shards {
+		sh.DataNodeIDs = removeUint64(sh.DataNodeIDs, c.ID)
+		sh.Degraded = true
+		if len(sh.DataNodeIDs) > 0 {
+			continue
+		}
+
+		// Reassign a shard that lost its only owner.
+		id := s.replacementDataNode(sh, c.ID)
+		if id == 0 {
+			continue
+		}
+		log.Printf("shard %d lost its only owner, reassigned to data node %d", sh.ID, id)
+		sh.DataNodeIDs = []uint64{id}
+		if id == s.id {
+			if err := s.openShard(sh); err != nil {
+				panic("unable to open shard: " + err.Error())
+			}
+			s.subscribe(sh.ID)
+		}
+	}
+
+	// Persist the groups of the updated shards and remove the node.
+	if err := s.meta.mustUpdate(func(tx *metatx) error {
+		for _, db := range s.databases {
+			for _, rp := range db.policies {
+				for _, g := range rp.shardGroups {
+					for _, sh := range g.Shards {
+						if !owned[sh.ID] {
+							continue
+						}
+						if err := tx.saveShardGroup(db.name, rp.Name, g); err != nil {
+							return err
+						}
+						break
+					}
+				}
+			}
+		}
+		return tx.deleteDataNode(c.ID)
+	}); err != nil {
+		return err
+	}
+	delete(s.dataNodes, c.ID)
+
+	return nil
+}
+
+type removeDataNodeCommand struct {
+	ID uint64 `json:"id"`
+}
+
+// DecommissionDataNode moves the shards owned by a data node to the remaining
+// data nodes and then deletes the node. Each shard is assigned to the least
+// loaded data node that doesn't already own it, its data is copied from an
+// existing owner, and only then is the decommissioned node removed as an owner.
+// A shard keeps its other owners without a replacement if every data node
+// already owns it.
+func (s *Server) DecommissionDataNode(id uint64) error {
+	if s.DataNode(id) == nil {
+		return ErrDataNodeNotFound
+	}
+
+	// Find the shards owned by the node.
+	s.mu.RLock()
+	var shardIDs []uint64
+	for _, sh := range s.shards {
+		if sh.HasDataNodeID(id) {
+			shardIDs = append(shardIDs, sh.ID)
+		}
+	}
+	s.mu.RUnlock()
+	sort.Sort(uint64Slice(shardIDs))
+
+	for _, shardID := range shardIDs {
+		if err := s.decommissionShard(shardID, id); err != nil {
+			return fmt.Errorf("move shard %d: %s", shardID, err)
+		}
+	}
+
+	return s.DeleteDataNode(id)
+}
+
+// decommissionShard moves a shard off of a data node that is being removed.
+func (s *Server) decommissionShard(shardID, nodeID uint64) error {
+	s.mu.RLock()
+	sh := s.shards[shardID]
+	if sh == nil {
+		s.mu.RUnlock()
+		return ErrShardNotFound
+	}
+	remaining := removeUint64(sh.DataNodeIDs, nodeID)
+	replacement := s.replacementDataNode(sh, nodeID)
+	s.mu.RUnlock()
+
+	// Drop the owner if there's nowhere else to replicate the shard.
+	if replacement == 0 {
+		if len(remaining) == 0 {
+			return ErrNoReplacementDataNode
+		}
+		log.Printf("no replacement for shard %d, replicas reduced to %d", shardID, len(remaining))
+		return s.SetShardOwners(shardID, remaining)
+	}
+
+	return s.moveShard(shardID, nodeID, replacement)
+}
+
+// moveShard reassigns a shard from one data node to another data node. The
+// new owner receives a copy of the shard's data before the old owner is removed.
+func (s *Server) moveShard(shardID, from, to uint64) error {
+	s.mu.RLock()
+	sh := s.shards[shardID]
+	if sh == nil {
+		s.mu.RUnlock()
+		return ErrShardNotFound
+	}
+	owners := append([]uint64{}, sh.DataNodeIDs...)
+	s.mu.RUnlock()
+	remaining := removeUint64(owners, from)
+
+	// Add the new owner so that it receives writes while the data is copied.
+	index, err := s.setShardOwners(shardID, append(owners, to))
+	if err != nil {
+		return err
+	}
+
+	// Copy existing data to the new owner. Prefer owners that are staying in
+	// the cluster in case the old owner is unavailable.
+	for _, src := range append(append([]uint64{}, remaining...), from) {
+		if err = s.copyShardData(shardID, src, to, index); err == nil {
+			break
+		}
+		log.Printf("unable to copy shard %d from data node %d: %s", shardID, src, err)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Remove the old owner.
+	return s.SetShardOwners(shardID, append(remaining, to))
+}
+
+// replacementDataNode returns the id of the data node owning the fewest shards
+// that doesn't already own sh and isn't excluded. Returns zero if there is none.
+// This function must be called with the lock held.
+func (s *Server) replacementDataNode(sh *Shard, exclude uint64) uint64 {
+	// Count the shards owned by each data node.
+	counts := make(map[uint64]int)
+	for _, other := range s.shards {
+		for _, id := range other.DataNodeIDs {
+			counts[id]++
+		}
+	}
+
+	var nodeID uint64
+	for _, n := range s.dataNodes {
+		if n.ID == exclude || sh.HasDataNodeID(n.ID) {
+			continue
+		} else if nodeID == 0 || counts[n.ID] < counts[nodeID] || (counts[n.ID] == counts[nodeID] && n.ID < nodeID) {
+			nodeID = n.ID
+		}
+	}
+	return nodeID
+}
+
+// copyShardData copies a shard's data from one data node to another. The
+// receiving node waits until it has applied index before merging the data.
+func (s *Server) copyShardData(shardID, from, to, index uint64) error {
+	// Pull the data file directly if this node is the destination.
+	if to == s.ID() {
+		return s.CopyShard(shardID, from)
+	}
+	path := "/shards/" + strconv.FormatUint(shardID, 10) + "/data"
+
+	// Read the data file from the source node into a temporary file.
+	f, err := ioutil.TempFile("", "influxdb-shard-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	if from == s.ID() {
+		if err := s.WriteShardTo(f, shardID); err != nil {
+			return err
+		}
+	} else {
+		n := s.DataNode(from)
+		if n == nil {
+			return ErrDataNodeNotFound
+		}
+		u := *n.URL
+		u.Path = path
+		resp, err := s.doNodeRequest("GET", &u, nil)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("copy shard: %s", resp.Status)
+		}
+		if _, err := io.Copy(f, resp.Body); err != nil {
+			return err
+		}
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	// Merge the data file on the destination node.
+	n := s.DataNode(to)
+	if n == nil {
+		return ErrDataNodeNotFound
+	}
+	u := *n.URL
+	u.Path = path
+	u.RawQuery = url.Values{"index": {strconv.FormatUint(index, 10)}}.Encode()
+	resp, err := s.doNodeRequest("POST", &u, f)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("merge shard: %s", resp.Status)
+	}
+	return nil
+}
+
 // DatabaseExists returns true if a database exists.
 func (s *Server) DatabaseExists(name string) bool {
 	s.mu.RLock()
@@ -709,7 +1919,7 @@ func (s *Server) CreateDatabase(name string) error {
 
 func (s *Server) applyCreateDatabase(m *messaging.Message) (err error) {
 	var c createDatabaseCommand
-	mustUnmarshalJSON(m.Data, &c)
+	mustDecodeCommand(m.Data, &c)
 
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -720,6 +1930,7 @@ func (s *Server) applyCreateDatabase(m *messaging.Message) (err error) {
 	// Create database entry.
 	db := newDatabase()
 	db.name = c.Name
+	db.cache = s.seriesIndex
 
 	// Persist to metastore.
 	err = s.meta.mustUpdate(func(tx *metatx) error { return tx.saveDatabase(db) })
@@ -743,7 +1954,7 @@ func (s *Server) DeleteDatabase(name string) error {
 
 func (s *Server) applyDeleteDatabase(m *messaging.Message) (err error) {
 	var c deleteDatabaseCommand
-	mustUnmarshalJSON(m.Data, &c)
+	mustDecodeCommand(m.Data, &c)
 
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -751,11 +1962,21 @@ func (s *Server) applyDeleteDatabase(m *messaging.Message) (err error) {
 		return ErrDatabaseNotFound
 	}
 
+	// Continuous queries in other databases must not be left writing into this one.
+	for _, cq := range s.continuousQueriesInto(c.Name, "") {
+		if cq.cq.Database != c.Name {
+			return fmt.Errorf("database is the target of continuous query %s on %s", cq.cq.Name, cq.cq.Database)
+		}
+	}
+
 	// Remove from metastore.
 	err = s.meta.mustUpdate(func(tx *metatx) error { return tx.deleteDatabase(c.Name) })
 
 	// Delete the database entry.
 	delete(s.databases, c.Name)
+
+	// Cached results may include the database's shards.
+	s.queryCache.clear()
 	return
 }
 
@@ -770,6 +1991,18 @@ func (s *Server) Shard(id uint64) *Shard {
 	return s.shards[id]
 }
 
+// ShardOwners returns a copy of a shard's owning data node IDs and whether
+// the shard is under-replicated.
+func (s *Server) ShardOwners(id uint64) ([]uint64, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	sh := s.shards[id]
+	if sh == nil {
+		return nil, false, ErrShardNotFound
+	}
+	return append([]uint64(nil), sh.DataNodeIDs...), sh.Degraded, nil
+}
+
 // shardGroupByTimestamp returns a group for a database, policy & timestamp.
 func (s *Server) shardGroupByTimestamp(database, policy string, timestamp time.Time) (*ShardGroup, error) {
 	db := s.databases[database]
@@ -812,7 +2045,9 @@ func (s *Server) CreateShardGroupIfNotExists(database, policy string, timestamp
 // If the group doesn't exist then one will be created automatically.
 func (s *Server) createShardGroupIfNotExists(database, policy string, timestamp time.Time) (*ShardGroup, error) {
 	// Check if shard group exists first.
+	s.mu.RLock()
 	g, err := s.shardGroupByTimestamp(database, policy, timestamp)
+	s.mu.RUnlock()
 	if err != nil {
 		return nil, err
 	} else if g != nil {
@@ -825,12 +2060,14 @@ func (s *Server) createShardGroupIfNotExists(database, policy string, timestamp
 	}
 
 	// Lookup the shard again.
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	return s.shardGroupByTimestamp(database, policy, timestamp)
 }
 
 func (s *Server) applyCreateShardGroupIfNotExists(m *messaging.Message) (err error) {
 	var c createShardGroupIfNotExistsCommand
-	mustUnmarshalJSON(m.Data, &c)
+	mustDecodeCommand(m.Data, &c)
 
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -854,8 +2091,7 @@ func (s *Server) applyCreateShardGroupIfNotExists(m *messaging.Message) (err err
 
 	// If no shards match then create a new one.
 	g := newShardGroup()
-	g.StartTime = c.Timestamp.Truncate(rp.Duration).UTC()
-	g.EndTime = g.StartTime.Add(rp.Duration).UTC()
+	g.StartTime, g.EndTime = rp.shardGroupRange(c.Timestamp)
 
 	// Sort nodes so they're consistently assigned to the shards.
 	nodes := make([]*DataNode, 0, len(s.dataNodes))
@@ -881,6 +2117,7 @@ func (s *Server) applyCreateShardGroupIfNotExists(m *messaging.Message) (err err
 	g.Shards = make([]*Shard, shardN)
 	for i := range g.Shards {
 		g.Shards[i] = newShard()
+		g.Shards[i].Compression = rp.Compression
 	}
 
 	// Persist to metastore if a shard was created.
@@ -893,21 +2130,19 @@ func (s *Server) applyCreateShardGroupIfNotExists(m *messaging.Message) (err err
 			sh.ID = tx.nextShardID()
 		}
 
-		// Assign data nodes to shards via round robin.
-		// Start from a repeatably "random" place in the node list.
+		// Assign data nodes to shards via round robin, spreading the replicas
+		// of each shard across zones. Start from a repeatably "random" place
+		// in the node list.
 		nodeIndex := int(m.Index % uint64(len(nodes)))
 		for _, sh := range g.Shards {
-			for i := 0; i < replicaN; i++ {
-				node := nodes[nodeIndex%len(nodes)]
-				sh.DataNodeIDs = append(sh.DataNodeIDs, node.ID)
-				nodeIndex++
-			}
+			sh.DataNodeIDs = placeShardReplicas(nodes, nodeIndex, replicaN)
+			nodeIndex += replicaN
 		}
 
 		// Retention policy has a new shard group, so update the policy.
 		rp.shardGroups = append(rp.shardGroups, g)
 
-		return tx.saveDatabase(db)
+		return tx.saveShardGroup(db.name, rp.Name, g)
 	}); err != nil {
 		g.close()
 		return
@@ -921,7 +2156,7 @@ func (s *Server) applyCreateShardGroupIfNotExists(m *messaging.Message) (err err
 		}
 
 		// Open shard store. Panic if an error occurs and we can retry.
-		if err := sh.open(s.shardPath(sh.ID)); err != nil {
+		if err := s.openShard(sh); err != nil {
 			panic("unable to open shard: " + err.Error())
 		}
 	}
@@ -931,22 +2166,44 @@ func (s *Server) applyCreateShardGroupIfNotExists(m *messaging.Message) (err err
 		s.shards[sh.ID] = sh
 	}
 
-	// Subscribe to shard if it matches the server's index.
-	// TODO: Move subscription outside of command processing.
-	// TODO: Retry subscriptions on failure.
+	// Subscribe to the shards assigned to this server.
 	for _, sh := range g.Shards {
-		// Ignore if this server is not assigned.
-		if !sh.HasDataNodeID(s.id) {
+		if sh.HasDataNodeID(s.id) {
+			s.subscribe(sh.ID)
+		}
+	}
+
+	return
+}
+
+// placeShardReplicas returns the ids of replicaN data nodes, chosen round
+// robin from index. Nodes in zones that don't hold a replica yet are chosen
+// first and the remaining nodes are used once every zone holds one. Nodes
+// without a zone never share a zone with another node.
+func placeShardReplicas(nodes []*DataNode, index, replicaN int) []uint64 {
+	ids := make([]uint64, 0, replicaN)
+	chosen := make(map[uint64]bool)
+	zones := make(map[string]bool)
+
+	// Choose nodes from distinct zones.
+	for i := 0; i < len(nodes) && len(ids) < replicaN; i++ {
+		n := nodes[(index+i)%len(nodes)]
+		if n.Zone != "" && zones[n.Zone] {
 			continue
 		}
+		ids = append(ids, n.ID)
+		chosen[n.ID], zones[n.Zone] = true, true
+	}
 
-		// Subscribe on the broker.
-		if err := s.client.Subscribe(s.id, sh.ID); err != nil {
-			log.Printf("unable to subscribe: replica=%d, topic=%d, err=%s", s.id, sh.ID, err)
+	// Fall back to the nodes in zones that already hold a replica.
+	for i := 0; i < len(nodes) && len(ids) < replicaN; i++ {
+		if n := nodes[(index+i)%len(nodes)]; !chosen[n.ID] {
+			ids = append(ids, n.ID)
+			chosen[n.ID] = true
 		}
 	}
 
-	return
+	return ids
 }
 
 type createShardGroupIfNotExistsCommand struct {
@@ -965,7 +2222,7 @@ func (s *Server) DeleteShardGroup(database, policy string, shardID uint64) error
 // applyDeleteShardGroup deletes shard data from disk and updates the metastore.
 func (s *Server) applyDeleteShardGroup(m *messaging.Message) (err error) {
 	var c deleteShardGroupCommand
-	mustUnmarshalJSON(m.Data, &c)
+	mustDecodeCommand(m.Data, &c)
 
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -982,34 +2239,60 @@ func (s *Server) applyDeleteShardGroup(m *messaging.Message) (err error) {
 		return ErrRetentionPolicyNotFound
 	}
 
-	// If shard group no longer exists, then ignore request. This can occur if multiple
-	// data nodes triggered the deletion.
+	// If shard group no longer exists, then ignore request. This can occur if the
+	// group was already removed by a retention sweep.
 	g := rp.shardGroupByID(c.ID)
 	if g == nil {
 		return nil
 	}
 
+	return s.deleteShardGroup(db, rp, g)
+}
+
+// deleteShardGroup removes the group's local shards from disk and the group
+// from the metastore. Must be called with the lock held.
+func (s *Server) deleteShardGroup(db *database, rp *RetentionPolicy, g *ShardGroup) error {
 	for _, shard := range g.Shards {
 		// Ignore shards not on this server.
 		if !shard.HasDataNodeID(s.id) {
 			continue
 		}
 
-		path := shard.store.Path()
+		path := shard.storePath()
 		shard.close()
-		if err := os.Remove(path); err != nil {
+		if err := s.removeShardStore(db, rp, shard, path); err != nil {
 			// Log, but keep going. This can happen if shards were deleted, but the server exited
 			// before it acknowledged the delete command.
 			log.Printf("error deleting shard %s, group ID %d, policy %s: %s", path, g.ID, rp.Name, err.Error())
 		}
+		s.unsubscribe(shard.ID)
 	}
 
 	// Remove from metastore.
-	rp.removeShardGroupByID(c.ID)
-	err = s.meta.mustUpdate(func(tx *metatx) error {
-		return tx.saveDatabase(db)
+	rp.removeShardGroupByID(g.ID)
+	err := s.meta.mustUpdate(func(tx *metatx) error {
+		return tx.deleteShardGroup(db.name, rp.Name, g.ID)
 	})
-	return
+
+	// Cached results may include the deleted shards.
+	s.queryCache.clear()
+	return err
+}
+
+// removeShardStore removes the store file of a shard in a deleted shard group.
+// The file is handed to ShardArchiveFunc or moved under ShardArchivePath,
+// by database and policy, if either is set. Otherwise it is deleted.
+func (s *Server) removeShardStore(db *database, rp *RetentionPolicy, sh *Shard, path string) error {
+	if s.ShardArchiveFunc != nil {
+		return s.ShardArchiveFunc(db.name, rp.Name, sh, path)
+	} else if s.ShardArchivePath != "" {
+		dir := filepath.Join(s.ShardArchivePath, db.name, rp.Name)
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return err
+		}
+		return os.Rename(path, filepath.Join(dir, filepath.Base(path)))
+	}
+	return os.Remove(path)
 }
 
 type deleteShardGroupCommand struct {
@@ -1018,215 +2301,1054 @@ type deleteShardGroupCommand struct {
 	ID       uint64 `json:"id"`
 }
 
-// User returns a user by username
-// Returns nil if the user does not exist.
-func (s *Server) User(name string) *User {
+// DeleteShard removes a single shard from the cluster. The shard's data is
+// deleted from each data node it is assigned to.
+func (s *Server) DeleteShard(id uint64) error {
+	c := &deleteShardCommand{ID: id}
+	_, err := s.broadcast(deleteShardMessageType, c)
+	return err
+}
+
+// applyDeleteShard deletes a shard's data from disk, unsubscribes from its
+// topic and removes it from the metastore. A shard group left without any
+// shards is removed as well.
+func (s *Server) applyDeleteShard(m *messaging.Message) error {
+	var c deleteShardCommand
+	mustDecodeCommand(m.Data, &c)
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	return s.users[name]
-}
 
-// Users returns a list of all users, sorted by name.
-func (s *Server) Users() (a []*User) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	for _, u := range s.users {
-		a = append(a, u)
-	}
-	sort.Sort(users(a))
-	return a
-}
+	// Find the shard's group.
+	for _, db := range s.databases {
+		for _, rp := range db.policies {
+			for _, g := range rp.shardGroups {
+				for i, sh := range g.Shards {
+					if sh.ID != c.ID {
+						continue
+					}
 
-// UserCount returns the number of users.
-func (s *Server) UserCount() int {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	return len(s.users)
-}
+					// Remove the local data and stop receiving writes.
+					if sh.HasDataNodeID(s.id) {
+						path := sh.storePath()
+						sh.close()
+						if err := os.Remove(path); err != nil {
+							log.Printf("error deleting shard %s: %s", path, err.Error())
+						}
+						s.unsubscribe(sh.ID)
+					}
+					delete(s.shards, sh.ID)
 
-// AdminUserExists returns whether at least 1 admin-level user exists.
-func (s *Server) AdminUserExists() bool {
-	for _, u := range s.users {
-		if u.Admin {
-			return true
+					// Remove from the group, dropping the group if it is now empty.
+					g.Shards = append(g.Shards[:i], g.Shards[i+1:]...)
+					if len(g.Shards) == 0 {
+						rp.removeShardGroupByID(g.ID)
+					}
+
+					// Persist to metastore.
+					err := s.meta.mustUpdate(func(tx *metatx) error {
+						if len(g.Shards) == 0 {
+							return tx.deleteShardGroup(db.name, rp.Name, g.ID)
+						}
+						return tx.saveShardGroup(db.name, rp.Name, g)
+					})
+
+					// Cached results may include the deleted shard.
+					s.queryCache.clear()
+					return err
+				}
+			}
 		}
 	}
-	return false
-}
-
-// Authenticate returns an authenticated user by username. If any error occurs,
-// or the authentication credentials are invalid, an error is returned.
-func (s *Server) Authenticate(username, password string) (*User, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
 
-	u := s.users[username]
+	return ErrShardNotFound
+}
 
-	// If authorization is not enabled and user is nil, we are authorized
-	if u == nil && !s.authenticationEnabled {
-		return nil, nil
-	}
-	if u == nil {
-		return nil, fmt.Errorf("invalid username or password")
-	}
-	err := u.Authenticate(password)
-	if err != nil {
-		return nil, fmt.Errorf("invalid username or password")
-	}
-	return u, nil
+type deleteShardCommand struct {
+	ID uint64 `json:"id"`
 }
 
-// CreateUser creates a user on the server.
-func (s *Server) CreateUser(username, password string, admin bool) error {
-	c := &createUserCommand{Username: username, Password: password, Admin: admin}
-	_, err := s.broadcast(createUserMessageType, c)
+// SetShardOwners replaces the data nodes that own a shard. Data nodes that are
+// added open the shard and begin receiving its writes. Data nodes that are
+// removed delete their copy of the shard.
+func (s *Server) SetShardOwners(id uint64, nodeIDs []uint64) error {
+	_, err := s.setShardOwners(id, nodeIDs)
 	return err
 }
 
-func (s *Server) applyCreateUser(m *messaging.Message) (err error) {
-	var c createUserCommand
-	mustUnmarshalJSON(m.Data, &c)
+// setShardOwners broadcasts a change of shard owners and returns its index.
+func (s *Server) setShardOwners(id uint64, nodeIDs []uint64) (uint64, error) {
+	c := &setShardOwnersCommand{ID: id, DataNodeIDs: nodeIDs}
+	return s.broadcast(setShardOwnersMessageType, c)
+}
+
+func (s *Server) applySetShardOwners(m *messaging.Message) error {
+	var c setShardOwnersCommand
+	mustDecodeCommand(m.Data, &c)
 
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	// Validate user.
-	if c.Username == "" {
-		return ErrUsernameRequired
-	} else if s.users[c.Username] != nil {
-		return ErrUserExists
+	// Validate owners.
+	if len(c.DataNodeIDs) == 0 {
+		return ErrShardOwnersRequired
 	}
-
-	// Generate the hash of the password.
-	hash, err := HashPassword(c.Password)
-	if err != nil {
-		return err
+	for _, id := range c.DataNodeIDs {
+		if s.dataNodes[id] == nil {
+			return ErrDataNodeNotFound
+		}
 	}
 
-	// Create the user.
-	u := &User{
-		Name:       c.Username,
-		Hash:       string(hash),
-		Privileges: make(map[string]influxql.Privilege),
-		Admin:      c.Admin,
-	}
+	// Find the shard's database.
+	for _, db := range s.databases {
+		for _, rp := range db.policies {
+			for _, g := range rp.shardGroups {
+				for _, sh := range g.Shards {
+					if sh.ID != c.ID {
+						continue
+					}
 
-	// Persist to metastore.
-	err = s.meta.mustUpdate(func(tx *metatx) error {
-		return tx.saveUser(u)
-	})
+					// Update owners.
+					wasOwner := sh.HasDataNodeID(s.id)
+					sh.DataNodeIDs = c.DataNodeIDs
+					isOwner := sh.HasDataNodeID(s.id)
+					if c.Degraded != nil {
+						sh.Degraded = *c.Degraded
+					}
 
-	s.users[u.Name] = u
-	return
+					// Persist to metastore.
+					if err := s.meta.mustUpdate(func(tx *metatx) error {
+						return tx.saveShardGroup(db.name, rp.Name, g)
+					}); err != nil {
+						return err
+					}
+
+					// Open the shard and start receiving writes if this
+					// server is a new owner. Panic if an error occurs and we can retry.
+					if !wasOwner && isOwner {
+						if err := s.openShard(sh); err != nil {
+							panic("unable to open shard: " + err.Error())
+						}
+						s.subscribe(sh.ID)
+					}
+
+					// Remove the local data if this server is no longer an owner.
+					if wasOwner && !isOwner {
+						path := sh.storePath()
+						sh.close()
+						if err := os.Remove(path); err != nil {
+							log.Printf("error deleting shard %s: %s", path, err.Error())
+						}
+						s.unsubscribe(sh.ID)
+					}
+
+					return nil
+				}
+			}
+		}
+	}
+
+	return ErrShardNotFound
 }
 
-type createUserCommand struct {
-	Username string `json:"username"`
-	Password string `json:"password"`
-	Admin    bool   `json:"admin,omitempty"`
+type setShardOwnersCommand struct {
+	ID          uint64   `json:"id"`
+	DataNodeIDs []uint64 `json:"nodeIDs"`
+	Degraded    *bool    `json:"degraded,omitempty"` // unchanged if nil
 }
 
-// UpdateUser updates an existing user on the server.
-func (s *Server) UpdateUser(username, password string) error {
-	c := &updateUserCommand{Username: username, Password: password}
-	_, err := s.broadcast(updateUserMessageType, c)
+// SplitShard marks a shard as full and adds a shard with the same owners to
+// its group. Points written to the group afterwards are spread over the
+// shards that aren't full so the full shard stops growing.
+func (s *Server) SplitShard(id uint64) error {
+	c := &splitShardCommand{ID: id}
+	_, err := s.broadcast(splitShardMessageType, c)
 	return err
 }
 
-func (s *Server) applyUpdateUser(m *messaging.Message) (err error) {
-	var c updateUserCommand
-	mustUnmarshalJSON(m.Data, &c)
+func (s *Server) applySplitShard(m *messaging.Message) error {
+	var c splitShardCommand
+	mustDecodeCommand(m.Data, &c)
 
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	// Validate command.
-	u := s.users[c.Username]
-	if u == nil {
-		return ErrUserNotFound
-	}
+	// Find the shard's group.
+	for _, db := range s.databases {
+		for _, rp := range db.policies {
+			for _, g := range rp.shardGroups {
+				for _, sh := range g.Shards {
+					if sh.ID != c.ID {
+						continue
+					}
 
-	// Update the user's password, if set.
-	if c.Password != "" {
-		hash, err := HashPassword(c.Password)
-		if err != nil {
-			return err
+					// Every owner may request the split so only the first
+					// request splits the shard.
+					if sh.Full {
+						return nil
+					}
+
+					// Add a shard on the same data nodes. Series that exist
+					// now stay on their shards and newer series are spread
+					// over the shards that aren't full.
+					other := newShard()
+					other.DataNodeIDs = append([]uint64(nil), sh.DataNodeIDs...)
+					other.Compression = rp.Compression
+					if err := s.meta.mustUpdate(func(tx *metatx) error {
+						other.ID = tx.nextShardID()
+						other.MinSeriesID = tx.lastSeriesID(db.name)
+						sh.Full, sh.MaxSeriesID = true, other.MinSeriesID
+						g.Shards = append(g.Shards, other)
+						return tx.saveShardGroup(db.name, rp.Name, g)
+					}); err != nil {
+						return err
+					}
+					s.shards[other.ID] = other
+
+					// Open the new shard if it's assigned to this server.
+					// Panic if an error occurs and we can retry.
+					if other.HasDataNodeID(s.id) {
+						if err := s.openShard(other); err != nil {
+							panic("unable to open shard: " + err.Error())
+						}
+						s.subscribe(other.ID)
+					}
+
+					s.stats.Inc("shardSplits")
+					return nil
+				}
+			}
 		}
-		u.Hash = string(hash)
 	}
 
-	// Persist to metastore.
-	return s.meta.mustUpdate(func(tx *metatx) error {
-		return tx.saveUser(u)
-	})
+	return ErrShardNotFound
 }
 
-type updateUserCommand struct {
-	Username string `json:"username"`
-	Password string `json:"password,omitempty"`
+type splitShardCommand struct {
+	ID uint64 `json:"id"`
 }
 
-// DeleteUser removes a user from the server.
-func (s *Server) DeleteUser(username string) error {
-	c := &deleteUserCommand{Username: username}
-	_, err := s.broadcast(deleteUserMessageType, c)
-	return err
-}
+// shardSizeCheckInterval is the minimum time between checks of a shard's
+// size after it's written to.
+const shardSizeCheckInterval = 1 * time.Second
 
-func (s *Server) applyDeleteUser(m *messaging.Message) error {
-	var c deleteUserCommand
-	mustUnmarshalJSON(m.Data, &c)
+// checkShardSize requests a split of a local shard that has grown past
+// MaxShardSize. The size is checked at most once every shardSizeCheckInterval.
+// The split is requested in the background since points can be written while
+// the broker's messages are being applied.
+func (s *Server) checkShardSize(sh *Shard) {
+	s.mu.RLock()
+	skip := sh.Full || s.shardSplits[sh.ID]
+	s.mu.RUnlock()
+	if skip || !sh.sizeCheckDue(time.Now(), shardSizeCheckInterval) {
+		return
+	}
+	if n, err := sh.size(); err != nil || n <= s.MaxShardSize {
+		return
+	}
 
 	s.mu.Lock()
 	defer s.mu.Unlock()
-
-	// Validate user.
-	if c.Username == "" {
-		return ErrUsernameRequired
-	} else if s.users[c.Username] == nil {
-		return ErrUserNotFound
+	if sh.Full || s.shardSplits[sh.ID] {
+		return
 	}
+	s.shardSplits[sh.ID] = true
 
-	// Remove from metastore.
-	s.meta.mustUpdate(func(tx *metatx) error {
-		return tx.deleteUser(c.Username)
-	})
-
-	// Delete the user.
-	delete(s.users, c.Username)
-	return nil
+	go func() {
+		if err := s.SplitShard(sh.ID); err != nil {
+			log.Printf("split shard %d: %s", sh.ID, err)
+		}
+		s.mu.Lock()
+		delete(s.shardSplits, sh.ID)
+		s.mu.Unlock()
+	}()
 }
 
-type deleteUserCommand struct {
-	Username string `json:"username"`
+// WriteShardTo writes a copy of a local shard's data file to a writer.
+func (s *Server) WriteShardTo(w io.Writer, id uint64) error {
+	s.mu.RLock()
+	sh := s.shards[id]
+	s.mu.RUnlock()
+	if sh == nil {
+		return ErrShardNotFound
+	}
+	return sh.copy(w)
 }
 
-// SetPrivilege grants / revokes a privilege to a user.
-func (s *Server) SetPrivilege(p influxql.Privilege, username string, dbname string) error {
-	c := &setPrivilegeCommand{p, username, dbname}
-	_, err := s.broadcast(setPrivilegeMessageType, c)
-	return err
+// WriteShardSeriesTo writes a copy of a local shard's data file to a writer that
+// only contains the data for a set of series.
+func (s *Server) WriteShardSeriesTo(w io.Writer, id uint64, seriesIDs []uint32) error {
+	s.mu.RLock()
+	sh := s.shards[id]
+	s.mu.RUnlock()
+	if sh == nil {
+		return ErrShardNotFound
+	}
+	return sh.copySeries(w, seriesIDs)
 }
 
-func (s *Server) applySetPrivilege(m *messaging.Message) error {
-	var c setPrivilegeCommand
-	mustUnmarshalJSON(m.Data, &c)
-
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	// Validate user.
-	if c.Username == "" {
-		return ErrUsernameRequired
+// ShardDigest returns a hash of the points stored for each series in a local
+// shard, keyed by series id. Replicas of a shard can compare digests to find
+// the series they are missing points for.
+func (s *Server) ShardDigest(id uint64) (map[uint32]string, error) {
+	s.mu.RLock()
+	sh := s.shards[id]
+	s.mu.RUnlock()
+	if sh == nil {
+		return nil, ErrShardNotFound
 	}
+	return sh.digest()
+}
 
-	u := s.users[c.Username]
-	if u == nil {
-		return ErrUserNotFound
+// MergeShard reads a shard data file written by WriteShardTo on another data node
+// and adds any points that are missing from the local shard. Returns the
+// number of points added.
+func (s *Server) MergeShard(id uint64, r io.Reader) (int, error) {
+	s.mu.RLock()
+	sh := s.shards[id]
+	s.mu.RUnlock()
+	if sh == nil || !sh.hasStore() {
+		return 0, ErrShardNotFound
 	}
 
-	// If dbname is empty, update user's Admin flag.
-	if c.Database == "" && (c.Privilege == influxql.AllPrivileges || c.Privilege == influxql.NoPrivileges) {
+	// Write the data file to disk so it can be opened.
+	f, err := ioutil.TempFile("", "influxdb-shard-")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(f.Name())
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return 0, err
+	}
+	if err := f.Close(); err != nil {
+		return 0, err
+	}
+
+	return mergeShardFile(sh, f.Name())
+}
+
+// mergeShardFile adds the points in a shard data file that are missing from sh.
+func mergeShardFile(sh *Shard, path string) (int, error) {
+	src, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		return 0, fmt.Errorf("open shard copy: %s", err)
+	}
+	defer src.Close()
+
+	return sh.merge(src)
+}
+
+// ShardChecksumHeader is the header containing the hex encoded SHA-256
+// checksum of a shard data file copied between data nodes.
+const ShardChecksumHeader = "X-Influxdb-Shard-Checksum"
+
+// CopyShard copies a shard's data file from another data node and verifies
+// its checksum. The local data node must own the shard. If the shard isn't
+// open locally then the copy is opened as the local shard. Otherwise points
+// missing from the local shard are merged in so that writes received since
+// the shard was opened are kept.
+func (s *Server) CopyShard(shardID, sourceNodeID uint64) error {
+	s.mu.RLock()
+	sh, n := s.shards[shardID], s.dataNodes[sourceNodeID]
+	owned := sh != nil && sh.HasDataNodeID(s.id)
+	path := s.shardPath(shardID)
+	s.mu.RUnlock()
+	if sh == nil {
+		return ErrShardNotFound
+	} else if n == nil {
+		return ErrDataNodeNotFound
+	} else if !owned {
+		return ErrShardNotOwned
+	}
+
+	// Request the data file from the source node.
+	u := *n.URL
+	u.Path = "/shards/" + strconv.FormatUint(shardID, 10) + "/data"
+	resp, err := s.doNodeRequest("GET", &u, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("copy shard: %s", resp.Status)
+	}
+
+	// Stream the file next to the shard's path while computing its checksum.
+	f, err := ioutil.TempFile(filepath.Dir(path), "copy-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(f, h), resp.Body); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if hex.EncodeToString(h.Sum(nil)) != resp.Header.Get(ShardChecksumHeader) {
+		return ErrShardChecksumMismatch
+	}
+
+	// Open the copy as the local shard if there isn't one already.
+	s.mu.Lock()
+	if !sh.hasStore() {
+		defer s.mu.Unlock()
+		if err := os.Rename(f.Name(), path); err != nil {
+			return err
+		}
+		return s.openShard(sh)
+	}
+	s.mu.Unlock()
+
+	_, err = mergeShardFile(sh, f.Name())
+	return err
+}
+
+// BackupShard writes a consistent snapshot of a local shard's data file to w.
+// The snapshot is read within a single transaction so the shard keeps
+// receiving writes while it's streamed.
+func (s *Server) BackupShard(id uint64, w io.Writer) error {
+	s.mu.RLock()
+	sh := s.shards[id]
+	s.mu.RUnlock()
+	if sh == nil || !sh.hasStore() {
+		return ErrShardNotFound
+	}
+	return sh.copy(w)
+}
+
+// RestoreShard replaces the data of a local shard with a snapshot written by
+// BackupShard. Points written to the shard since the backup was taken are
+// lost. The local data node must own the shard.
+func (s *Server) RestoreShard(id uint64, r io.Reader) error {
+	s.mu.RLock()
+	sh := s.shards[id]
+	owned := sh != nil && sh.HasDataNodeID(s.id)
+	path := s.shardPath(id)
+	s.mu.RUnlock()
+	if sh == nil {
+		return ErrShardNotFound
+	} else if !owned {
+		return ErrShardNotOwned
+	}
+
+	// Stream the backup next to the shard's path.
+	f, err := ioutil.TempFile(filepath.Dir(path), "restore-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	// Ensure the backup is a valid store before replacing the shard's data.
+	db, err := bolt.Open(f.Name(), 0600, &bolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		return fmt.Errorf("open shard backup: %s", err)
+	}
+	_ = db.Close()
+
+	// Swap the backup in for the shard's store.
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := sh.close(); err != nil {
+		return err
+	} else if err := os.Rename(f.Name(), path); err != nil {
+		return err
+	} else if err := s.openShard(sh); err != nil {
+		return err
+	}
+
+	// Cached results may include the replaced points.
+	s.queryCache.clear()
+	return nil
+}
+
+// User returns a user by username
+// Returns nil if the user does not exist.
+func (s *Server) User(name string) *User {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.users[name]
+}
+
+// Users returns a list of all users, sorted by name.
+func (s *Server) Users() (a []*User) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, u := range s.users {
+		a = append(a, u)
+	}
+	sort.Sort(users(a))
+	return a
+}
+
+// UserCount returns the number of users.
+func (s *Server) UserCount() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.users)
+}
+
+// AdminUserExists returns whether at least 1 admin-level user exists.
+func (s *Server) AdminUserExists() bool {
+	for _, u := range s.users {
+		if u.Admin {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticate returns an authenticated user by username. If any error occurs,
+// or the authentication credentials are invalid, an error is returned.
+//
+// Passwords stored with outdated password hasher settings are rehashed. The
+// new hash is generated once on this server and stored by every server.
+func (s *Server) Authenticate(username, password string) (*User, error) {
+	u, rehash, err := s.authenticate(username, password)
+	if err != nil {
+		return nil, err
+	}
+
+	// Failing to rehash doesn't prevent the user from authenticating.
+	if rehash && !s.ReadOnly() {
+		if err := s.rehashUser(u.Name, password); err != nil {
+			s.Logger.Printf("rehash password for user %q: %s", u.Name, err)
+		}
+	}
+	return u, nil
+}
+
+// rehashUser replaces the stored hash of a user's password with a hash
+// generated by the server's password hasher.
+func (s *Server) rehashUser(username, password string) error {
+	hash, err := s.PasswordHasher.Hash(password)
+	if err != nil {
+		return err
+	}
+	c := &updateUserCommand{Username: username, Hash: hash}
+	_, err = s.broadcast(updateUserMessageType, c)
+	return err
+}
+
+// authenticate returns an authenticated user by username and whether the
+// user's password hash should be replaced.
+func (s *Server) authenticate(username, password string) (*User, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	u := s.users[username]
+
+	// If authorization is not enabled and user is nil, we are authorized
+	if u == nil && !s.authenticationEnabled {
+		return nil, false, nil
+	}
+	if u == nil {
+		return nil, false, fmt.Errorf("invalid username or password")
+	}
+
+	// Locked out users cannot authenticate, even with the correct password.
+	now := time.Now()
+	if s.MaxAuthenticationFailures > 0 && s.authLockout.locked(u.Name, now) {
+		return nil, false, ErrUserLocked
+	}
+
+	// Skip the comparison if the password was verified recently.
+	if s.AuthenticationCacheTTL > 0 && s.authCache.get(u, password, now) {
+		return u, false, nil
+	}
+
+	var err error
+	if s.Authenticator != nil {
+		err = s.Authenticator.Authenticate(u.Name, password)
+	} else {
+		err = s.PasswordHasher.Compare(u.Hash, password)
+	}
+	if err != nil {
+		if s.MaxAuthenticationFailures > 0 && s.authLockout.fail(u.Name, s.MaxAuthenticationFailures, s.AuthenticationLockout, now) {
+			s.Logger.Printf("user %q locked out after %d failed authentication attempts", u.Name, s.MaxAuthenticationFailures)
+		}
+		return nil, false, fmt.Errorf("invalid username or password")
+	}
+	s.authLockout.delete(u.Name)
+
+	if s.AuthenticationCacheTTL > 0 {
+		s.authCache.set(u, password, now.Add(s.AuthenticationCacheTTL))
+	}
+	return u, s.Authenticator == nil && s.PasswordHasher.NeedsRehash(u.Hash), nil
+}
+
+// UnlockUser clears a user's failed authentication attempts, allowing a
+// locked out user to authenticate again on this server.
+func (s *Server) UnlockUser(username string) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.users[username] == nil {
+		return ErrUserNotFound
+	}
+	s.authLockout.delete(username)
+	return nil
+}
+
+// AuthenticateToken returns the user a signed bearer token authenticates. A token
+// limited to databases returns a copy of the user with privileges on only those
+// databases.
+func (s *Server) AuthenticateToken(token string) (*User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// API tokens are looked up instead of being verified with the token secret.
+	if id, secret, ok := splitAPIToken(token); ok {
+		return s.authenticateAPIToken(id, secret)
+	}
+
+	if len(s.TokenSecret) == 0 {
+		return nil, ErrTokenSecretRequired
+	}
+
+	claims, err := ParseToken(token, s.TokenSecret)
+	if err != nil {
+		return nil, err
+	} else if claims.ExpiresAt != 0 && time.Now().Unix() >= claims.ExpiresAt {
+		return nil, ErrTokenExpired
+	}
+
+	u := s.users[claims.Username]
+	if u == nil {
+		return nil, ErrInvalidToken
+	} else if len(claims.Databases) == 0 {
+		return u, nil
+	}
+
+	// Limit the privileges to the token's databases.
+	other := &User{Name: u.Name, Privileges: make(map[string]influxql.Privilege)}
+	for _, name := range claims.Databases {
+		if u.Admin {
+			other.Privileges[name] = influxql.AllPrivileges
+		} else if p, ok := u.privilege(name); ok {
+			other.Privileges[name] = p
+		}
+		if a := u.MeasurementPrivileges[name]; !u.Admin && len(a) > 0 {
+			if other.MeasurementPrivileges == nil {
+				other.MeasurementPrivileges = make(map[string][]*MeasurementPrivilege)
+			}
+			other.MeasurementPrivileges[name] = a
+		}
+	}
+	return other, nil
+}
+
+// authenticateAPIToken returns a copy of the user an API token belongs to with
+// only the privileges of the token that the user also has. Must be called under lock.
+func (s *Server) authenticateAPIToken(id, secret string) (*User, error) {
+	t := s.apiTokens[id]
+	if t == nil || !t.matches(secret) {
+		return nil, ErrInvalidToken
+	} else if t.expired(time.Now()) {
+		return nil, ErrTokenExpired
+	}
+
+	u := s.users[t.Username]
+	if u == nil {
+		return nil, ErrInvalidToken
+	}
+
+	other := &User{Name: u.Name, Privileges: make(map[string]influxql.Privilege)}
+	for name, p := range t.Privileges {
+		if u.Admin {
+			other.Privileges[name] = p
+		} else if up, ok := u.privilege(name); ok {
+			if up < p {
+				p = up
+			}
+			other.Privileges[name] = p
+		}
+	}
+	return other, nil
+}
+
+// CreateAPIToken creates a token for a user that grants at most the given
+// privileges by database name. The token never expires if expiresAt is zero.
+// The returned token is the only copy of its secret.
+func (s *Server) CreateAPIToken(username string, privileges map[string]influxql.Privilege, expiresAt time.Time) (string, error) {
+	id, secret, err := newAPITokenSecret()
+	if err != nil {
+		return "", err
+	}
+
+	c := &createAPITokenCommand{
+		ID:         id,
+		Username:   username,
+		Hash:       apiTokenHash(secret),
+		Privileges: privileges,
+		CreatedAt:  time.Now().UTC(),
+		ExpiresAt:  expiresAt,
+	}
+	if _, err := s.broadcast(createAPITokenMessageType, c); err != nil {
+		return "", err
+	}
+	return id + "." + secret, nil
+}
+
+func (s *Server) applyCreateAPIToken(m *messaging.Message) error {
+	var c createAPITokenCommand
+	mustDecodeCommand(m.Data, &c)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Validate user.
+	if c.Username == "" {
+		return ErrUsernameRequired
+	} else if s.users[c.Username] == nil {
+		return ErrUserNotFound
+	}
+
+	t := &APIToken{
+		ID:         c.ID,
+		Username:   c.Username,
+		Hash:       c.Hash,
+		Privileges: c.Privileges,
+		CreatedAt:  c.CreatedAt,
+		ExpiresAt:  c.ExpiresAt,
+	}
+	if t.Privileges == nil {
+		t.Privileges = make(map[string]influxql.Privilege)
+	}
+
+	// Persist to metastore.
+	if err := s.meta.mustUpdate(func(tx *metatx) error {
+		return tx.saveAPIToken(t)
+	}); err != nil {
+		return err
+	}
+
+	s.apiTokens[t.ID] = t
+	return nil
+}
+
+type createAPITokenCommand struct {
+	ID         string                        `json:"id"`
+	Username   string                        `json:"username"`
+	Hash       string                        `json:"hash"`
+	Privileges map[string]influxql.Privilege `json:"privileges"`
+	CreatedAt  time.Time                     `json:"createdAt"`
+	ExpiresAt  time.Time                     `json:"expiresAt,omitempty"`
+}
+
+// RevokeAPIToken deletes an API token by id.
+func (s *Server) RevokeAPIToken(id string) error {
+	c := &deleteAPITokenCommand{ID: id}
+	_, err := s.broadcast(deleteAPITokenMessageType, c)
+	return err
+}
+
+func (s *Server) applyDeleteAPIToken(m *messaging.Message) error {
+	var c deleteAPITokenCommand
+	mustDecodeCommand(m.Data, &c)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.apiTokens[c.ID] == nil {
+		return ErrAPITokenNotFound
+	}
+
+	// Remove from metastore.
+	if err := s.meta.mustUpdate(func(tx *metatx) error {
+		return tx.deleteAPIToken(c.ID)
+	}); err != nil {
+		return err
+	}
+
+	delete(s.apiTokens, c.ID)
+	return nil
+}
+
+type deleteAPITokenCommand struct {
+	ID string `json:"id"`
+}
+
+// APITokens returns the API tokens of a user, sorted by id.
+// Returns the tokens of all users if username is blank.
+func (s *Server) APITokens(username string) (a []*APIToken) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, t := range s.apiTokens {
+		if username == "" || t.Username == username {
+			a = append(a, t)
+		}
+	}
+	sort.Sort(apiTokens(a))
+	return
+}
+
+// CreateToken returns a bearer token for a user signed with the token secret.
+// The token never expires if expiry is zero and is limited to databases, if any.
+func (s *Server) CreateToken(username string, expiry time.Time, databases []string) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if len(s.TokenSecret) == 0 {
+		return "", ErrTokenSecretRequired
+	} else if s.users[username] == nil {
+		return "", ErrUserNotFound
+	}
+
+	claims := &TokenClaims{Username: username, Databases: databases}
+	if !expiry.IsZero() {
+		claims.ExpiresAt = expiry.Unix()
+	}
+	return SignToken(claims, s.TokenSecret)
+}
+
+// CreateUser creates a user on the server.
+func (s *Server) CreateUser(username, password string, admin bool) error {
+	c := &createUserCommand{Username: username, Password: password, Admin: admin}
+	_, err := s.broadcast(createUserMessageType, c)
+	return err
+}
+
+func (s *Server) applyCreateUser(m *messaging.Message) (err error) {
+	var c createUserCommand
+	mustDecodeCommand(m.Data, &c)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Validate user.
+	if c.Username == "" {
+		return ErrUsernameRequired
+	} else if s.users[c.Username] != nil {
+		return ErrUserExists
+	}
+
+	// Generate the hash of the password unless one was given.
+	hash := c.Hash
+	if hash == "" {
+		if hash, err = s.PasswordHasher.Hash(c.Password); err != nil {
+			return err
+		}
+	}
+
+	// Create the user.
+	u := &User{
+		Name:       c.Username,
+		Hash:       hash,
+		Privileges: make(map[string]influxql.Privilege),
+		Admin:      c.Admin,
+	}
+
+	// Persist to metastore.
+	err = s.meta.mustUpdate(func(tx *metatx) error {
+		return tx.saveUser(u)
+	})
+
+	s.users[u.Name] = u
+	return
+}
+
+type createUserCommand struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Admin    bool   `json:"admin,omitempty"`
+
+	// The stored hash of the user's password. Used instead of Password when
+	// importing users exported from another cluster.
+	Hash string `json:"hash,omitempty"`
+}
+
+// UpdateUser updates an existing user on the server.
+func (s *Server) UpdateUser(username, password string) error {
+	c := &updateUserCommand{Username: username, Password: password}
+	_, err := s.broadcast(updateUserMessageType, c)
+	return err
+}
+
+func (s *Server) applyUpdateUser(m *messaging.Message) (err error) {
+	var c updateUserCommand
+	mustDecodeCommand(m.Data, &c)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Validate command.
+	u := s.users[c.Username]
+	if u == nil {
+		return ErrUserNotFound
+	}
+
+	// Update the user's password, if set.
+	if c.Hash != "" {
+		u.Hash = c.Hash
+		s.authCache.delete(u.Name)
+	} else if c.Password != "" {
+		hash, err := s.PasswordHasher.Hash(c.Password)
+		if err != nil {
+			return err
+		}
+		u.Hash = hash
+		s.authCache.delete(u.Name)
+	}
+
+	// Persist to metastore.
+	return s.meta.mustUpdate(func(tx *metatx) error {
+		return tx.saveUser(u)
+	})
+}
+
+type updateUserCommand struct {
+	Username string `json:"username"`
+	Password string `json:"password,omitempty"`
+
+	// The new hash of the user's password. Used instead of Password when a
+	// password is rehashed so every server stores the same hash.
+	Hash string `json:"hash,omitempty"`
+}
+
+// EnsureAdminUser creates the root user with the default root password if the
+// cluster has no admin users. Does nothing if an admin user already exists.
+func (s *Server) EnsureAdminUser() error {
+	_, err := s.broadcast(ensureAdminUserMessageType, &struct{}{})
+	return err
+}
+
+func (s *Server) applyEnsureAdminUser(m *messaging.Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, u := range s.users {
+		if u.Admin {
+			return nil
+		}
+	}
+	s.Logger.Printf("no admin user found, creating %q user", RootUsername)
+	return s.setRootUser()
+}
+
+// ResetRootPassword sets the root user's password to the default root password
+// and makes it an admin, creating the user if it doesn't exist. This recovers a
+// cluster whose admin credentials have been lost.
+func (s *Server) ResetRootPassword() error {
+	_, err := s.broadcast(resetRootPasswordMessageType, &struct{}{})
+	return err
+}
+
+func (s *Server) applyResetRootPassword(m *messaging.Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.setRootUser()
+}
+
+// setRootUser creates or updates the root user as an admin with the default
+// root password. Must be called under lock.
+func (s *Server) setRootUser() error {
+	hash, err := s.PasswordHasher.Hash(DefaultRootPassword)
+	if err != nil {
+		return err
+	}
+
+	u := s.users[RootUsername]
+	if u == nil {
+		u = &User{Name: RootUsername, Privileges: make(map[string]influxql.Privilege)}
+	}
+	u.Hash = hash
+	u.Admin = true
+
+	// Persist to metastore.
+	if err := s.meta.mustUpdate(func(tx *metatx) error {
+		return tx.saveUser(u)
+	}); err != nil {
+		return err
+	}
+
+	s.users[u.Name] = u
+	s.authCache.delete(u.Name)
+	s.authLockout.delete(u.Name)
+	return nil
+}
+
+// DeleteUser removes a user from the server.
+func (s *Server) DeleteUser(username string) error {
+	c := &deleteUserCommand{Username: username}
+	_, err := s.broadcast(deleteUserMessageType, c)
+	return err
+}
+
+func (s *Server) applyDeleteUser(m *messaging.Message) error {
+	var c deleteUserCommand
+	mustDecodeCommand(m.Data, &c)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Validate user.
+	if c.Username == "" {
+		return ErrUsernameRequired
+	} else if s.users[c.Username] == nil {
+		return ErrUserNotFound
+	}
+
+	// Remove the user and their api tokens from metastore.
+	s.meta.mustUpdate(func(tx *metatx) error {
+		for id, t := range s.apiTokens {
+			if t.Username == c.Username {
+				if err := tx.deleteAPIToken(id); err != nil {
+					return err
+				}
+				delete(s.apiTokens, id)
+			}
+		}
+		return tx.deleteUser(c.Username)
+	})
+
+	// Delete the user.
+	delete(s.users, c.Username)
+	s.authCache.delete(c.Username)
+	s.authLockout.delete(c.Username)
+	return nil
+}
+
+type deleteUserCommand struct {
+	Username string `json:"username"`
+}
+
+// SetPrivilege grants / revokes a privilege to a user.
+func (s *Server) SetPrivilege(p influxql.Privilege, username string, dbname string) error {
+	c := &setPrivilegeCommand{p, username, dbname}
+	_, err := s.broadcast(setPrivilegeMessageType, c)
+	return err
+}
+
+func (s *Server) applySetPrivilege(m *messaging.Message) error {
+	var c setPrivilegeCommand
+	mustDecodeCommand(m.Data, &c)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Validate user.
+	if c.Username == "" {
+		return ErrUsernameRequired
+	}
+
+	u := s.users[c.Username]
+	if u == nil {
+		return ErrUserNotFound
+	}
+
+	// If dbname is empty, update user's Admin flag.
+	if c.Database == "" && (c.Privilege == influxql.AllPrivileges || c.Privilege == influxql.NoPrivileges) {
 		u.Admin = (c.Privilege == influxql.AllPrivileges)
 	} else if c.Database != "" {
 		// Update user's privilege for the database.
@@ -1247,6 +3369,255 @@ type setPrivilegeCommand struct {
 	Database  string             `json:"database"`
 }
 
+// SetMeasurementPrivilege grants a privilege to a user on the measurements of a
+// database matching a name, or a regular expression if isRegex is true.
+// A privilege of NoPrivileges revokes the grant.
+func (s *Server) SetMeasurementPrivilege(p influxql.Privilege, username, database, measurement string, isRegex bool) error {
+	if isRegex {
+		if _, err := regexp.Compile(measurement); err != nil {
+			return err
+		}
+	}
+	c := &setMeasurementPrivilegeCommand{Privilege: p, Username: username, Database: database, Measurement: measurement, IsRegex: isRegex}
+	_, err := s.broadcast(setMeasurementPrivilegeMessageType, c)
+	return err
+}
+
+func (s *Server) applySetMeasurementPrivilege(m *messaging.Message) error {
+	var c setMeasurementPrivilegeCommand
+	mustDecodeCommand(m.Data, &c)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Validate user, database & measurement.
+	if c.Username == "" {
+		return ErrUsernameRequired
+	} else if c.Database == "" {
+		return ErrDatabaseNameRequired
+	} else if c.Measurement == "" {
+		return ErrMeasurementNameRequired
+	}
+	u := s.users[c.Username]
+	if u == nil {
+		return ErrUserNotFound
+	}
+
+	// Replace any existing grant on the same measurements.
+	u.removeMeasurementPrivilege(c.Database, c.Measurement, c.IsRegex)
+	if c.Privilege != influxql.NoPrivileges {
+		if u.MeasurementPrivileges == nil {
+			u.MeasurementPrivileges = make(map[string][]*MeasurementPrivilege)
+		}
+		u.MeasurementPrivileges[c.Database] = append(u.MeasurementPrivileges[c.Database], &MeasurementPrivilege{
+			Measurement: c.Measurement,
+			IsRegex:     c.IsRegex,
+			Privilege:   c.Privilege,
+		})
+	}
+
+	// Persist to metastore.
+	return s.meta.mustUpdate(func(tx *metatx) error {
+		return tx.saveUser(u)
+	})
+}
+
+type setMeasurementPrivilegeCommand struct {
+	Privilege   influxql.Privilege `json:"privilege"`
+	Username    string             `json:"username"`
+	Database    string             `json:"database"`
+	Measurement string             `json:"measurement"`
+	IsRegex     bool               `json:"isRegex,omitempty"`
+}
+
+// Role returns a role by name.
+// Returns nil if the role does not exist.
+func (s *Server) Role(name string) *Role {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.roles[name]
+}
+
+// Roles returns a list of all roles, sorted by name.
+func (s *Server) Roles() (a []*Role) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, r := range s.roles {
+		a = append(a, r)
+	}
+	sort.Sort(roles(a))
+	return a
+}
+
+// CreateRole creates a role without privileges.
+func (s *Server) CreateRole(name string) error {
+	c := &createRoleCommand{Name: name}
+	_, err := s.broadcast(createRoleMessageType, c)
+	return err
+}
+
+func (s *Server) applyCreateRole(m *messaging.Message) error {
+	var c createRoleCommand
+	mustDecodeCommand(m.Data, &c)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Validate role.
+	if c.Name == "" {
+		return ErrRoleNameRequired
+	} else if s.roles[c.Name] != nil {
+		return ErrRoleExists
+	}
+
+	// Create the role & persist to metastore.
+	r := &Role{Name: c.Name, Privileges: make(map[string]influxql.Privilege)}
+	s.meta.mustUpdate(func(tx *metatx) error {
+		return tx.saveRole(r)
+	})
+
+	s.roles[r.Name] = r
+	return nil
+}
+
+type createRoleCommand struct {
+	Name string `json:"name"`
+}
+
+// DeleteRole removes a role and revokes it from all of its users.
+func (s *Server) DeleteRole(name string) error {
+	c := &deleteRoleCommand{Name: name}
+	_, err := s.broadcast(deleteRoleMessageType, c)
+	return err
+}
+
+func (s *Server) applyDeleteRole(m *messaging.Message) error {
+	var c deleteRoleCommand
+	mustDecodeCommand(m.Data, &c)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Validate role.
+	if c.Name == "" {
+		return ErrRoleNameRequired
+	} else if s.roles[c.Name] == nil {
+		return ErrRoleNotFound
+	}
+
+	// Remove the role from its users & the metastore.
+	s.meta.mustUpdate(func(tx *metatx) error {
+		for _, u := range s.users {
+			if u.removeRole(c.Name) {
+				if err := tx.saveUser(u); err != nil {
+					return err
+				}
+			}
+		}
+		return tx.deleteRole(c.Name)
+	})
+
+	delete(s.roles, c.Name)
+	return nil
+}
+
+type deleteRoleCommand struct {
+	Name string `json:"name"`
+}
+
+// SetRolePrivilege sets the privilege a role grants on a database.
+func (s *Server) SetRolePrivilege(p influxql.Privilege, role string, database string) error {
+	c := &setRolePrivilegeCommand{Privilege: p, Role: role, Database: database}
+	_, err := s.broadcast(setRolePrivilegeMessageType, c)
+	return err
+}
+
+func (s *Server) applySetRolePrivilege(m *messaging.Message) error {
+	var c setRolePrivilegeCommand
+	mustDecodeCommand(m.Data, &c)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Validate role & database.
+	r := s.roles[c.Role]
+	if r == nil {
+		return ErrRoleNotFound
+	} else if c.Database == "" {
+		return ErrDatabaseNameRequired
+	}
+
+	// Update the role's privilege for the database.
+	if c.Privilege == influxql.NoPrivileges {
+		delete(r.Privileges, c.Database)
+	} else {
+		r.Privileges[c.Database] = c.Privilege
+	}
+
+	// Persist to metastore.
+	return s.meta.mustUpdate(func(tx *metatx) error {
+		return tx.saveRole(r)
+	})
+}
+
+type setRolePrivilegeCommand struct {
+	Privilege influxql.Privilege `json:"privilege"`
+	Role      string             `json:"role"`
+	Database  string             `json:"database"`
+}
+
+// GrantRole adds a user to a role. The user is granted the role's privileges.
+func (s *Server) GrantRole(role, username string) error {
+	c := &setUserRoleCommand{Role: role, Username: username, Member: true}
+	_, err := s.broadcast(setUserRoleMessageType, c)
+	return err
+}
+
+// RevokeRole removes a user from a role.
+func (s *Server) RevokeRole(role, username string) error {
+	c := &setUserRoleCommand{Role: role, Username: username, Member: false}
+	_, err := s.broadcast(setUserRoleMessageType, c)
+	return err
+}
+
+func (s *Server) applySetUserRole(m *messaging.Message) error {
+	var c setUserRoleCommand
+	mustDecodeCommand(m.Data, &c)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Validate user & role.
+	u := s.users[c.Username]
+	if u == nil {
+		return ErrUserNotFound
+	}
+	r := s.roles[c.Role]
+	if r == nil {
+		return ErrRoleNotFound
+	}
+
+	// Update the user's membership.
+	if c.Member {
+		if !u.addRole(r) {
+			return nil
+		}
+	} else if !u.removeRole(r.Name) {
+		return nil
+	}
+
+	// Persist to metastore.
+	return s.meta.mustUpdate(func(tx *metatx) error {
+		return tx.saveUser(u)
+	})
+}
+
+type setUserRoleCommand struct {
+	Role     string `json:"role"`
+	Username string `json:"username"`
+	Member   bool   `json:"member"`
+}
+
 // RetentionPolicy returns a retention policy by name.
 // Returns an error if the database doesn't exist.
 func (s *Server) RetentionPolicy(database, name string) (*RetentionPolicy, error) {
@@ -1300,18 +3671,26 @@ func (s *Server) RetentionPolicies(database string) ([]*RetentionPolicy, error)
 // CreateRetentionPolicy creates a retention policy for a database.
 func (s *Server) CreateRetentionPolicy(database string, rp *RetentionPolicy) error {
 	c := &createRetentionPolicyCommand{
-		Database: database,
-		Name:     rp.Name,
-		Duration: rp.Duration,
-		ReplicaN: rp.ReplicaN,
+		Database:           database,
+		Name:               rp.Name,
+		Duration:           rp.Duration,
+		ShardGroupDuration: rp.ShardGroupDuration,
+		ReplicaN:           rp.ReplicaN,
+		Compression:        rp.Compression,
 	}
+
+	// Infinite policies cannot derive shard groups from their duration.
+	if c.Duration == 0 && c.ShardGroupDuration == 0 {
+		c.ShardGroupDuration = s.InfiniteShardGroupDuration
+	}
+
 	_, err := s.broadcast(createRetentionPolicyMessageType, c)
 	return err
 }
 
 func (s *Server) applyCreateRetentionPolicy(m *messaging.Message) error {
 	var c createRetentionPolicyCommand
-	mustUnmarshalJSON(m.Data, &c)
+	mustDecodeCommand(m.Data, &c)
 
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -1326,40 +3705,71 @@ func (s *Server) applyCreateRetentionPolicy(m *messaging.Message) error {
 		return ErrRetentionPolicyExists
 	}
 
+	// Default the shard group duration based on the retention period.
+	sgd := c.ShardGroupDuration
+	if sgd == 0 {
+		sgd = shardGroupDuration(c.Duration)
+	}
+	if err := s.validateRetentionPolicyDuration(c.Duration, sgd); err != nil {
+		return err
+	} else if !validCompression(c.Compression) {
+		return ErrInvalidCompression
+	}
+
 	// Add policy to the database.
-	db.policies[c.Name] = &RetentionPolicy{
-		Name:     c.Name,
-		Duration: c.Duration,
-		ReplicaN: c.ReplicaN,
+	rp := &RetentionPolicy{
+		Name:               c.Name,
+		Duration:           c.Duration,
+		ShardGroupDuration: sgd,
+		ReplicaN:           c.ReplicaN,
+		Compression:        c.Compression,
 	}
+	db.policies[c.Name] = rp
 
 	// Persist to metastore.
 	s.meta.mustUpdate(func(tx *metatx) error {
-		return tx.saveDatabase(db)
+		return tx.saveRetentionPolicy(db.name, rp)
 	})
 
 	return nil
 }
 
+// validateRetentionPolicyDuration returns an error if a finite retention period
+// is below the server's minimum or shorter than its shard group duration.
+func (s *Server) validateRetentionPolicyDuration(duration, shardGroupDuration time.Duration) error {
+	if duration == 0 {
+		return nil
+	} else if duration < s.MinRetentionPolicyDuration {
+		return ErrRetentionPolicyDurationTooLow
+	} else if shardGroupDuration > duration {
+		return ErrShardGroupDurationExceedsRetention
+	}
+	return nil
+}
+
 type createRetentionPolicyCommand struct {
-	Database string        `json:"database"`
-	Name     string        `json:"name"`
-	Duration time.Duration `json:"duration"`
-	ReplicaN uint32        `json:"replicaN"`
-	SplitN   uint32        `json:"splitN"`
+	Database           string        `json:"database"`
+	Name               string        `json:"name"`
+	Duration           time.Duration `json:"duration"`
+	ShardGroupDuration time.Duration `json:"shardGroupDuration,omitempty"`
+	ReplicaN           uint32        `json:"replicaN"`
+	SplitN             uint32        `json:"splitN"`
+	Compression        string        `json:"compression,omitempty"`
 }
 
 // RetentionPolicyUpdate represents retention policy fields that
 // need to be updated.
 type RetentionPolicyUpdate struct {
-	Name     *string        `json:"name,omitempty"`
-	Duration *time.Duration `json:"duration,omitempty"`
-	ReplicaN *uint32        `json:"replicaN,omitempty"`
+	Name               *string        `json:"name,omitempty"`
+	Duration           *time.Duration `json:"duration,omitempty"`
+	ShardGroupDuration *time.Duration `json:"shardGroupDuration,omitempty"`
+	ReplicaN           *uint32        `json:"replicaN,omitempty"`
+	Compression        *string        `json:"compression,omitempty"`
 }
 
 // UpdateRetentionPolicy updates an existing retention policy on a database.
 func (s *Server) UpdateRetentionPolicy(database, name string, rpu *RetentionPolicyUpdate) error {
-	c := &updateRetentionPolicyCommand{Database: database, Name: name, Policy: rpu}
+	c := &updateRetentionPolicyCommand{Database: database, Name: name, Policy: rpu, Time: time.Now().UTC()}
 	_, err := s.broadcast(updateRetentionPolicyMessageType, c)
 	return err
 }
@@ -1368,11 +3778,12 @@ type updateRetentionPolicyCommand struct {
 	Database string                 `json:"database"`
 	Name     string                 `json:"name"`
 	Policy   *RetentionPolicyUpdate `json:"policy"`
+	Time     time.Time              `json:"time"`
 }
 
 func (s *Server) applyUpdateRetentionPolicy(m *messaging.Message) (err error) {
 	var c updateRetentionPolicyCommand
-	mustUnmarshalJSON(m.Data, &c)
+	mustDecodeCommand(m.Data, &c)
 
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -1391,7 +3802,27 @@ func (s *Server) applyUpdateRetentionPolicy(m *messaging.Message) (err error) {
 		return ErrRetentionPolicyNotFound
 	}
 
+	// Validate the durations the policy will have after the update. Changing the
+	// duration alone derives a new shard group duration, as on creation.
+	duration, sgd := p.Duration, p.ShardGroupDuration
+	if c.Policy.Duration != nil {
+		duration = *c.Policy.Duration
+		sgd = shardGroupDuration(duration)
+		if duration == 0 {
+			sgd = p.ShardGroupDuration
+		}
+	}
+	if c.Policy.ShardGroupDuration != nil {
+		sgd = *c.Policy.ShardGroupDuration
+	}
+	if err := s.validateRetentionPolicyDuration(duration, sgd); err != nil {
+		return err
+	} else if c.Policy.Compression != nil && !validCompression(*c.Policy.Compression) {
+		return ErrInvalidCompression
+	}
+
 	// Update the policy name.
+	oldName := p.Name
 	if c.Policy.Name != nil {
 		delete(db.policies, p.Name)
 		p.Name = *c.Policy.Name
@@ -1403,14 +3834,47 @@ func (s *Server) applyUpdateRetentionPolicy(m *messaging.Message) (err error) {
 		p.Duration = *c.Policy.Duration
 	}
 
+	// Update shard group duration. The group in progress is cut short so that
+	// later writes land in groups of the new duration.
+	var truncated *ShardGroup
+	if sgd != p.ShardGroupDuration {
+		p.ShardGroupDuration = sgd
+		truncated = p.truncateShardGroup(c.Time)
+	}
+
 	// Update replication factor.
 	if c.Policy.ReplicaN != nil {
 		p.ReplicaN = *c.Policy.ReplicaN
 	}
 
-	// Persist to metastore.
+	// Update compression. Existing shard groups keep their compression.
+	if c.Policy.Compression != nil {
+		p.Compression = *c.Policy.Compression
+	}
+
+	// Persist to metastore. A renamed policy's shard groups move with it.
 	err = s.meta.mustUpdate(func(tx *metatx) error {
-		return tx.saveDatabase(db)
+		if p.Name != oldName {
+			if err := tx.deleteRetentionPolicy(db.name, oldName); err != nil {
+				return err
+			}
+			if err := tx.saveRetentionPolicy(db.name, p); err != nil {
+				return err
+			}
+			for _, g := range p.shardGroups {
+				if err := tx.saveShardGroup(db.name, p.Name, g); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+
+		if truncated != nil {
+			if err := tx.saveShardGroup(db.name, p.Name, truncated); err != nil {
+				return err
+			}
+		}
+		return tx.saveRetentionPolicy(db.name, p)
 	})
 
 	return
@@ -1425,7 +3889,7 @@ func (s *Server) DeleteRetentionPolicy(database, name string) error {
 
 func (s *Server) applyDeleteRetentionPolicy(m *messaging.Message) (err error) {
 	var c deleteRetentionPolicyCommand
-	mustUnmarshalJSON(m.Data, &c)
+	mustDecodeCommand(m.Data, &c)
 
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -1440,12 +3904,20 @@ func (s *Server) applyDeleteRetentionPolicy(m *messaging.Message) (err error) {
 		return ErrRetentionPolicyNotFound
 	}
 
+	// Continuous queries must not be left writing into the policy.
+	if a := s.continuousQueriesInto(c.Database, c.Name); len(a) > 0 {
+		return fmt.Errorf("retention policy is the target of continuous query %s on %s", a[0].cq.Name, a[0].cq.Database)
+	}
+
 	// Remove retention policy.
 	delete(db.policies, c.Name)
 
+	// Cached results may include the policy's shards.
+	s.queryCache.clear()
+
 	// Persist to metastore.
 	err = s.meta.mustUpdate(func(tx *metatx) error {
-		return tx.saveDatabase(db)
+		return tx.deleteRetentionPolicy(db.name, c.Name)
 	})
 
 	return
@@ -1465,7 +3937,7 @@ func (s *Server) SetDefaultRetentionPolicy(database, name string) error {
 
 func (s *Server) applySetDefaultRetentionPolicy(m *messaging.Message) (err error) {
 	var c setDefaultRetentionPolicyCommand
-	mustUnmarshalJSON(m.Data, &c)
+	mustDecodeCommand(m.Data, &c)
 
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -1483,7 +3955,7 @@ func (s *Server) applySetDefaultRetentionPolicy(m *messaging.Message) (err error
 
 	// Persist to metastore.
 	err = s.meta.mustUpdate(func(tx *metatx) error {
-		return tx.saveDatabase(db)
+		return tx.saveDatabaseMeta(db)
 	})
 
 	return
@@ -1502,7 +3974,7 @@ type createFieldsIfNotExistCommand struct {
 
 func (s *Server) applyCreateFieldsIfNotExist(m *messaging.Message) error {
 	var c createFieldsIfNotExistCommand
-	mustUnmarshalJSON(m.Data, &c)
+	mustDecodeCommand(m.Data, &c)
 
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -1538,7 +4010,7 @@ func (s *Server) applyCreateFieldsIfNotExist(m *messaging.Message) error {
 			if err := tx.saveMeasurement(db.name, mm); err != nil {
 				return fmt.Errorf("save measurement: %s", err)
 			}
-			return tx.saveDatabase(db)
+			return nil
 		}); err != nil {
 			return err
 		}
@@ -1549,7 +4021,7 @@ func (s *Server) applyCreateFieldsIfNotExist(m *messaging.Message) error {
 
 func (s *Server) applyCreateSeriesIfNotExists(m *messaging.Message) error {
 	var c createSeriesIfNotExistsCommand
-	mustUnmarshalJSON(m.Data, &c)
+	mustDecodeCommand(m.Data, &c)
 
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -1596,6 +4068,10 @@ type Point struct {
 // WriteSeries writes series data to the database.
 // Returns the messaging index the data was written to.
 func (s *Server) WriteSeries(database, retentionPolicy string, points []Point) (uint64, error) {
+	if s.ReadOnly() {
+		return 0, ErrReadOnly
+	}
+
 	// If the retention policy is not set, use the default for this database.
 	if retentionPolicy == "" {
 		rp, err := s.DefaultRetentionPolicy(database)
@@ -1607,21 +4083,34 @@ func (s *Server) WriteSeries(database, retentionPolicy string, points []Point) (
 		retentionPolicy = rp.Name
 	}
 
+	// Track the number of write requests and points.
+	s.stats.Inc("writeSeriesReq")
+	s.stats.Add("pointsWritten", int64(len(points)))
+
 	// Collect responses for each channel.
 	type resp struct {
 		index uint64
+		sh    *Shard
+		data  []byte
 		err   error
 	}
 	ch := make(chan resp, len(points))
 
-	// Write each point in parallel.
+	// Write each point in parallel. Points are batched by shard instead if
+	// they are forwarded directly to the shard owners.
+	forward := s.ForwardWrites
 	var wg sync.WaitGroup
 	for i := range points {
 		wg.Add(1)
 		go func(p *Point) {
-			index, err := s.writePoint(database, retentionPolicy, p)
-			ch <- resp{index, err}
-			wg.Done()
+			defer wg.Done()
+			sh, data, err := s.encodePoint(database, retentionPolicy, p)
+			if err != nil || forward {
+				ch <- resp{sh: sh, data: data, err: err}
+				return
+			}
+			index, err := s.publishPoint(sh, data)
+			ch <- resp{index: index, err: err}
 		}(&points[i])
 	}
 	wg.Wait()
@@ -1630,6 +4119,7 @@ func (s *Server) WriteSeries(database, retentionPolicy string, points []Point) (
 	// Calculate max index and check for errors.
 	var index uint64
 	var err error
+	batches := make(map[*Shard][][]byte)
 	for resp := range ch {
 		if resp.index > index {
 			index = resp.index
@@ -1637,48 +4127,78 @@ func (s *Server) WriteSeries(database, retentionPolicy string, points []Point) (
 		if err == nil && resp.err != nil {
 			err = resp.err
 		}
+		if resp.err == nil && resp.sh != nil {
+			batches[resp.sh] = append(batches[resp.sh], resp.data)
+		}
+	}
+
+	// Forward batches to the shard owners. Fall back to the broker for any
+	// shard that can't be written to directly.
+	for sh, data := range batches {
+		e := s.forwardShardPoints(sh, data)
+		if e == nil {
+			continue
+		}
+		log.Printf("forward to shard %d: %s, publishing to broker", sh.ID, e)
+
+		for _, d := range data {
+			i, e := s.publishPoint(sh, d)
+			if i > index {
+				index = i
+			}
+			if err == nil && e != nil {
+				err = e
+			}
+		}
 	}
+
 	return index, err
 }
 
-func (s *Server) writePoint(database, retentionPolicy string, point *Point) (uint64, error) {
+// encodePoint creates the series, fields and shard group for a point as
+// necessary and returns the shard it belongs to and its raw encoding.
+func (s *Server) encodePoint(database, retentionPolicy string, point *Point) (*Shard, []byte, error) {
 	measurement, tags, timestamp, values := point.Name, point.Tags, point.Timestamp, point.Values
 
 	// Sanity-check the data point.
 	if measurement == "" {
-		return 0, ErrMeasurementNameRequired
+		return nil, nil, ErrMeasurementNameRequired
 	}
 	if len(values) == 0 {
-		return 0, ErrValuesRequired
+		return nil, nil, ErrValuesRequired
 	}
 
 	// Find the id for the series and tagset
 	seriesID, err := s.createSeriesIfNotExists(database, measurement, tags)
 	if err != nil {
-		return 0, err
+		return nil, nil, err
 	}
 
 	// Retrieve measurement.
+	s.mu.RLock()
 	m, err := s.measurement(database, measurement)
+	s.mu.RUnlock()
 	if err != nil {
-		return 0, err
+		return nil, nil, err
 	} else if m == nil {
-		return 0, ErrMeasurementNotFound
+		return nil, nil, ErrMeasurementNotFound
 	}
 
 	// Retrieve shard group.
 	g, err := s.createShardGroupIfNotExists(database, retentionPolicy, timestamp)
 	if err != nil {
-		return 0, fmt.Errorf("create shard(%s/%s): %s", retentionPolicy, timestamp.Format(time.RFC3339Nano), err)
+		return nil, nil, fmt.Errorf("create shard(%s/%s): %s", retentionPolicy, timestamp.Format(time.RFC3339Nano), err)
 	}
 
 	// Find appropriate shard within the shard group.
+	s.mu.RLock()
 	sh := g.ShardBySeriesID(seriesID)
+	s.mu.RUnlock()
 
 	// Ensure fields are created as necessary.
 	err = s.createFieldsIfNotExists(database, measurement, values)
 	if err != nil {
-		return 0, err
+		return nil, nil, err
 	}
 
 	// Get a field codec.
@@ -1692,19 +4212,29 @@ func (s *Server) writePoint(database, retentionPolicy string, point *Point) (uin
 	// Convert string-key/values to encoded fields.
 	encodedFields, err := codec.EncodeFields(values)
 	if err != nil {
-		return 0, err
+		return nil, nil, err
 	}
 
 	// Encode point header.
 	data := marshalPointHeader(seriesID, timestamp.UnixNano())
 	data = append(data, encodedFields...)
 
-	// Publish "raw write series" message on shard's topic to broker.
-	return s.client.Publish(&messaging.Message{
+	return sh, data, nil
+}
+
+// publishPoint publishes a "raw write series" message with an encoded point
+// on the shard's topic to the broker.
+func (s *Server) publishPoint(sh *Shard, data []byte) (uint64, error) {
+	index, err := s.client.Publish(&messaging.Message{
 		Type:    writeRawSeriesMessageType,
 		TopicID: sh.ID,
 		Data:    data,
 	})
+	if err != nil {
+		return 0, err
+	}
+	s.setBrokerIndex(index)
+	return index, nil
 }
 
 // applyWriteRawSeries writes raw series data to the database.
@@ -1717,19 +4247,39 @@ func (s *Server) applyWriteRawSeries(m *messaging.Message) error {
 		return ErrShardNotFound
 	}
 
+	// Ignore writes to shards that are not stored on this node.
+	if !sh.hasStore() {
+		return nil
+	}
+
+	return s.writeRawPoint(sh, m.Data)
+}
+
+// writeRawPoint writes an encoded point to a local shard.
+func (s *Server) writeRawPoint(sh *Shard, b []byte) error {
 	// Extract the series id and timestamp from the header.
 	// Everything after the header is the marshalled value.
-	seriesID, timestamp := unmarshalPointHeader(m.Data[:pointHeaderSize])
-	data := m.Data[pointHeaderSize:]
+	seriesID, timestamp := unmarshalPointHeader(b[:pointHeaderSize])
+	data := b[pointHeaderSize:]
 
 	// Add to lookup.
+	s.mu.Lock()
 	s.addShardBySeriesID(sh, seriesID)
+	s.mu.Unlock()
 
 	// TODO: Enable some way to specify if the data should be overwritten
 	overwrite := true
 
 	// Write to shard.
-	return sh.writeSeries(seriesID, timestamp, data, overwrite)
+	if err := sh.writeSeries(seriesID, timestamp, data, overwrite); err != nil {
+		return err
+	}
+
+	// Split the shard once it grows too large.
+	if s.MaxShardSize > 0 {
+		s.checkShardSize(sh)
+	}
+	return nil
 }
 
 func (s *Server) addShardBySeriesID(sh *Shard, seriesID uint32) {
@@ -1764,7 +4314,9 @@ func (s *Server) createSeriesIfNotExists(database, name string, tags map[string]
 	}
 
 	// Lookup series again.
+	s.mu.RLock()
 	_, series := db.MeasurementAndSeries(name, tags)
+	s.mu.RUnlock()
 	if series == nil {
 		return 0, ErrSeriesNotFound
 	}
@@ -1817,6 +4369,48 @@ func (s *Server) createFieldsIfNotExists(database string, measurement string, va
 	return nil
 }
 
+// SetMeasurementTTL sets how long a measurement's points are kept. Points older
+// than the TTL are deleted from their shards by the next retention sweep, so the
+// TTL only has an effect when it is shorter than the retention policy's duration.
+// A zero TTL removes the override.
+func (s *Server) SetMeasurementTTL(database, measurement string, ttl time.Duration) error {
+	c := &setMeasurementTTLCommand{Database: database, Measurement: measurement, TTL: ttl}
+	_, err := s.broadcast(setMeasurementTTLMessageType, c)
+	return err
+}
+
+func (s *Server) applySetMeasurementTTL(m *messaging.Message) error {
+	var c setMeasurementTTLCommand
+	mustDecodeCommand(m.Data, &c)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Validate command.
+	db := s.databases[c.Database]
+	if db == nil {
+		return ErrDatabaseNotFound
+	}
+	mm := db.measurements[c.Measurement]
+	if mm == nil {
+		return ErrMeasurementNotFound
+	} else if c.TTL < 0 {
+		return ErrMeasurementTTLInvalid
+	}
+	mm.TTL = c.TTL
+
+	// Persist to metastore.
+	return s.meta.mustUpdate(func(tx *metatx) error {
+		return tx.saveMeasurement(db.name, mm)
+	})
+}
+
+type setMeasurementTTLCommand struct {
+	Database    string        `json:"database"`
+	Measurement string        `json:"measurement"`
+	TTL         time.Duration `json:"ttl"`
+}
+
 // ReadSeries reads a single point from a series in the database. It is used for debug and test only.
 func (s *Server) ReadSeries(database, retentionPolicy, name string, tags map[string]string, timestamp time.Time) (map[string]interface{}, error) {
 	s.mu.RLock()
@@ -1858,7 +4452,7 @@ func (s *Server) ReadSeries(database, retentionPolicy, name string, tags map[str
 	// TODO: Verify that server owns shard.
 
 	// Find appropriate shard within the shard group.
-	sh := g.Shards[int(series.ID)%len(g.Shards)]
+	sh := g.ShardBySeriesID(series.ID)
 
 	// Read raw encoded series data.
 	data, err := sh.readSeries(series.ID, timestamp.UnixNano())
@@ -1886,159 +4480,788 @@ func (s *Server) ReadSeries(database, retentionPolicy, name string, tags map[str
 	return values, nil
 }
 
+// StreamShardPoints writes the points of a single tagset within a local shard
+// to w as a stream of JSON objects. The statement must be a simple select
+// statement against a single field. It is used to serve queries from other data nodes.
+func (s *Server) StreamShardPoints(w io.Writer, shardID uint64, stmt *influxql.SelectStatement, tags string, tmin, tmax int64) error {
+	enc := json.NewEncoder(w)
+	itr, err := s.localShardIterator(shardID, stmt, tags, tmin, tmax)
+	if err != nil {
+		return err
+	} else if itr == nil {
+		return enc.Encode(map[string]bool{"end": true})
+	}
+
+	// Open the shard's read transaction and stream points until exhausted.
+	if err := itr.open(); err != nil {
+		return err
+	}
+	defer itr.close()
+
+	// Mark the end of the stream so the reader can tell it wasn't cut short.
+	for k, _, v := itr.Next(); k != 0; k, _, v = itr.Next() {
+		if err := enc.Encode(&remotePoint{Time: k, Value: v}); err != nil {
+			return err
+		}
+	}
+	return enc.Encode(map[string]bool{"end": true})
+}
+
+// localShardIterator returns an iterator for a tagset within a local shard.
+// Returns nil if the tagset has no series.
+func (s *Server) localShardIterator(shardID uint64, stmt *influxql.SelectStatement, tags string, tmin, tmax int64) (*shardIterator, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// Find the shard and ensure it is stored on this node.
+	sh := s.shards[shardID]
+	if sh == nil || !sh.hasStore() {
+		return nil, ErrShardNotFound
+	}
+
+	// Find the measurement & field.
+	database, _, name, err := splitIdent(stmt.Source.(*influxql.Measurement).Name)
+	if err != nil {
+		return nil, err
+	}
+	m, err := s.measurement(database, name)
+	if err != nil {
+		return nil, err
+	} else if m == nil {
+		return nil, ErrMeasurementNotFound
+	}
+	ref, ok := stmt.Fields[0].Expr.(*influxql.VarRef)
+	if !ok {
+		return nil, fmt.Errorf("expected field reference: %s", stmt.Fields[0].Expr)
+	}
+	f := m.FieldByName(ref.Val)
+	if f == nil {
+		return nil, fmt.Errorf("field not found: %s", ref.Val)
+	}
+
+	// Find the series for the tagset.
+	_, dimensions, err := stmt.Dimensions.Normalize()
+	if err != nil {
+		return nil, err
+	}
+	set := m.tagSets(stmt, dimensions)[tags]
+	if len(set) == 0 {
+		return nil, nil
+	}
+
+	return newShardIterator(m, f, tags, set, NewFieldCodec(m), sh, tmin, tmax, stmt.TimeAscending()), nil
+}
+
 // ExecuteQuery executes an InfluxQL query against the server.
 // Returns a resultset for each statement in the query.
 // Stops on first execution error that occurs.
 func (s *Server) ExecuteQuery(q *influxql.Query, database string, user *User) Results {
+	return s.ExecuteQueryTimeout(q, database, user, s.QueryTimeout)
+}
+
+// ExecuteQueryTimeout executes an InfluxQL query against the server and
+// aborts execution if the query runs longer than timeout. Statements that
+// are aborted return ErrQueryTimeout. A zero timeout disables the deadline.
+func (s *Server) ExecuteQueryTimeout(q *influxql.Query, database string, user *User, timeout time.Duration) Results {
+	// Build empty resultsets.
+	results := Results{Results: make([]*Result, len(q.Statements))}
+
+	// Execute the query and merge the rows for each statement into a single result.
+	if err := s.executeQuery(q, database, user, 0, timeout, func(i int, res *Result) error {
+		if results.Results[i] == nil {
+			results.Results[i] = res
+			return nil
+		}
+		results.Results[i].Rows = append(results.Results[i].Rows, res.Rows...)
+		results.Results[i].Err = res.Err
+		return nil
+	}); err != nil {
+		return Results{Err: err}
+	}
+
+	// Fill any empty results after error.
+	for i, res := range results.Results {
+		if res == nil {
+			results.Results[i] = &Result{Err: ErrNotExecuted}
+		}
+	}
+
+	return results
+}
+
+// ExecuteQueryStream executes an InfluxQL query against the server and passes
+// each result to fn as it becomes available, along with the statement index.
+//
+// Rows from select statements are passed in chunks of at most chunkSize rows
+// so that large result sets do not need to be held in memory. A chunkSize of
+// zero passes all rows for a statement in a single result. Statements without
+// rows always produce exactly one result.
+//
+// Statement errors are passed to fn and stop processing of remaining statements.
+// Returns an error if the query could not be authorized or if fn returns an error.
+func (s *Server) ExecuteQueryStream(q *influxql.Query, database string, user *User, chunkSize int, fn func(i int, res *Result) error) error {
+	return s.executeQuery(q, database, user, chunkSize, s.QueryTimeout, fn)
+}
+
+// executeQuery executes a query and streams results to fn. Select statements
+// are aborted if the query has been running longer than timeout.
+func (s *Server) executeQuery(q *influxql.Query, database string, user *User, chunkSize int, timeout time.Duration, fn func(i int, res *Result) error) error {
+	// Determine the deadline for the query, if any.
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+
+	// Track the number of query requests.
+	s.stats.Inc("queryReq")
+
 	// Authorize user to execute the query.
 	if s.authenticationEnabled {
 		if err := s.Authorize(user, q, database); err != nil {
-			return Results{Err: err}
+			for _, stmt := range q.Statements {
+				s.audit(stmt, database, user, err)
+			}
+			return err
+		}
+	}
+
+	// Ensure the user is not already running too many queries.
+	if user != nil {
+		if err := s.acquireUserQuery(user.Name); err != nil {
+			return err
+		}
+		defer s.releaseUserQuery(user.Name)
+	}
+
+	// Execute each statement.
+	for i, stmt := range q.Statements {
+		// Set default database and policy on the statement.
+		if err := s.NormalizeStatement(stmt, database); err != nil {
+			return fn(i, &Result{Err: err})
+		}
+
+		// Select statements stream their rows directly to the callback.
+		if stmt, ok := stmt.(*influxql.SelectStatement); ok {
+			start := time.Now()
+			var stmtErr error
+			ok, err := s.executeSelectStatementStream(stmt, database, user, chunkSize, deadline, func(res *Result) error {
+				if res.Err != nil {
+					stmtErr = res.Err
+				}
+				return fn(i, res)
+			})
+			s.logSlowQuery(stmt, database, user, start)
+			s.audit(stmt, database, user, stmtErr)
+			if err != nil {
+				return err
+			} else if !ok {
+				break
+			}
+			continue
+		}
+
+		// Execute all other statements and return a single result.
+		res := s.executeStatement(stmt, database, user)
+		if res == nil {
+			s.audit(stmt, database, user, nil)
+			continue
+		}
+		s.audit(stmt, database, user, res.Err)
+		if err := fn(i, res); err != nil {
+			return err
+		}
+
+		// If an error occurs then stop processing remaining statements.
+		if res.Err != nil {
+			break
+		}
+	}
+
+	return nil
+}
+
+// acquireUserQuery tracks a running query for a user.
+// Returns ErrUserQueryLimitExceeded if the user is at their limit.
+func (s *Server) acquireUserQuery(name string) error {
+	s.queryMu.Lock()
+	defer s.queryMu.Unlock()
+	if s.MaxUserQueryN > 0 && s.userQueryN[name] >= s.MaxUserQueryN {
+		return ErrUserQueryLimitExceeded
+	}
+	s.userQueryN[name]++
+	return nil
+}
+
+// releaseUserQuery stops tracking a running query for a user.
+func (s *Server) releaseUserQuery(name string) {
+	s.queryMu.Lock()
+	defer s.queryMu.Unlock()
+	if s.userQueryN[name]--; s.userQueryN[name] <= 0 {
+		delete(s.userQueryN, name)
+	}
+}
+
+// maxSelectPointN returns the maximum number of points a select statement
+// run by user can read. Returns true if the limit is the user's quota.
+func (s *Server) maxSelectPointN(user *User) (int, bool) {
+	if user == nil || s.MaxUserSelectPointN <= 0 {
+		return s.MaxSelectPointN, false
+	} else if s.MaxSelectPointN > 0 && s.MaxSelectPointN <= s.MaxUserSelectPointN {
+		return s.MaxSelectPointN, false
+	}
+	return s.MaxUserSelectPointN, true
+}
+
+// audit records a statement executed by a user, and its error if it failed,
+// to the audit sinks. Statements that only read data are ignored unless
+// AuditQueries is set.
+func (s *Server) audit(stmt influxql.Statement, database string, user *User, err error) {
+	if len(s.AuditSinks) == 0 {
+		return
+	}
+	action, admin := auditAction(stmt)
+	if !admin && !s.AuditQueries {
+		return
+	}
+
+	e := &AuditEvent{
+		Time:      time.Now().UTC(),
+		Action:    action,
+		Database:  database,
+		Statement: auditStatement(stmt),
+	}
+	if user != nil {
+		e.Username = user.Name
+	}
+	if err != nil {
+		e.Err = err.Error()
+	}
+
+	for _, sink := range s.AuditSinks {
+		if err := sink.WriteAuditEvent(e); err != nil {
+			s.Logger.Printf("audit: %s", err)
+		}
+	}
+}
+
+// logSlowQuery records a select statement that started at start if it ran
+// longer than the slow query threshold.
+func (s *Server) logSlowQuery(stmt *influxql.SelectStatement, database string, user *User, start time.Time) {
+	d := time.Since(start)
+	if s.SlowQueryThreshold <= 0 || d < s.SlowQueryThreshold {
+		return
+	}
+
+	q := &SlowQuery{
+		Statement: stmt.String(),
+		Database:  database,
+		Duration:  d,
+		ShardN:    s.shardN(stmt, start),
+		Time:      start.UTC(),
+	}
+	if user != nil {
+		q.User = user.Name
+	}
+	s.slowQueries.Add(q)
+	s.stats.Inc("slowQueryReq")
+
+	s.Logger.Printf("slow query: duration=%s shards=%d db=%s user=%s stmt=%s", q.Duration, q.ShardN, q.Database, q.User, q.Statement)
+}
+
+// shardN returns the number of shards that overlap a select statement's time range.
+func (s *Server) shardN(stmt *influxql.SelectStatement, now time.Time) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// Determine the time range in the same way the planner does.
+	_, tmin, tmax := queryTimeRange(stmt.Condition, now)
+
+	// Count the shards in each overlapping shard group.
+	var n int
+	for _, m := range sourceMeasurements(stmt.Source) {
+		dbName, policyName, _, err := splitIdent(m.Name)
+		if err != nil {
+			continue
+		}
+		db := s.databases[dbName]
+		if db == nil {
+			continue
+		}
+		rp := db.policies[policyName]
+		if rp == nil {
+			continue
+		}
+		for _, g := range rp.shardGroups {
+			if g.overlaps(tmin, tmax) {
+				n += len(g.Shards)
+			}
+		}
+	}
+	return n
+}
+
+// sourceMeasurements returns the measurements read by a source.
+func sourceMeasurements(source influxql.Source) []*influxql.Measurement {
+	switch source := source.(type) {
+	case *influxql.Measurement:
+		return []*influxql.Measurement{source}
+	case *influxql.Join:
+		return source.Measurements
+	case *influxql.Merge:
+		return source.Measurements
+	}
+	return nil
+}
+
+// executeStatement executes a single non-select statement.
+// Returns nil if the statement does not produce a result.
+func (s *Server) executeStatement(stmt influxql.Statement, database string, user *User) *Result {
+	switch stmt := stmt.(type) {
+	case *influxql.CreateDatabaseStatement:
+		return s.executeCreateDatabaseStatement(stmt, user)
+	case *influxql.DropDatabaseStatement:
+		return s.executeDropDatabaseStatement(stmt, user)
+	case *influxql.DropShardStatement:
+		return s.executeDropShardStatement(stmt, user)
+	case *influxql.ShowDatabasesStatement:
+		return s.executeShowDatabasesStatement(stmt, user)
+	case *influxql.CreateUserStatement:
+		return s.executeCreateUserStatement(stmt, user)
+	case *influxql.DropUserStatement:
+		return s.executeDropUserStatement(stmt, user)
+	case *influxql.ShowUsersStatement:
+		return s.executeShowUsersStatement(stmt, user)
+	case *influxql.DropSeriesStatement:
+		return nil
+	case *influxql.ShowSeriesStatement:
+		return s.executeShowSeriesStatement(stmt, database, user)
+	case *influxql.ShowSeriesCardinalityStatement:
+		return s.executeShowSeriesCardinalityStatement(stmt, database, user)
+	case *influxql.ShowMeasurementsStatement:
+		return s.executeShowMeasurementsStatement(stmt, database, user)
+	case *influxql.ShowMeasurementCardinalityStatement:
+		return s.executeShowMeasurementCardinalityStatement(stmt, database, user)
+	case *influxql.ShowMeasurementSizesStatement:
+		return s.executeShowMeasurementSizesStatement(stmt, database, user)
+	case *influxql.ShowTagKeysStatement:
+		return s.executeShowTagKeysStatement(stmt, database, user)
+	case *influxql.ShowTagValuesStatement:
+		return s.executeShowTagValuesStatement(stmt, database, user)
+	case *influxql.ShowFieldKeysStatement:
+		return s.executeShowFieldKeysStatement(stmt, database, user)
+	case *influxql.GrantStatement:
+		return s.executeGrantStatement(stmt, user)
+	case *influxql.RevokeStatement:
+		return s.executeRevokeStatement(stmt, user)
+	case *influxql.CreateRetentionPolicyStatement:
+		return s.executeCreateRetentionPolicyStatement(stmt, user)
+	case *influxql.AlterRetentionPolicyStatement:
+		return s.executeAlterRetentionPolicyStatement(stmt, user)
+	case *influxql.DropRetentionPolicyStatement:
+		return s.executeDropRetentionPolicyStatement(stmt, user)
+	case *influxql.ShowRetentionPoliciesStatement:
+		return s.executeShowRetentionPoliciesStatement(stmt, user)
+	case *influxql.CreateContinuousQueryStatement:
+		return s.executeCreateContinuousQueryStatement(stmt, user)
+	case *influxql.DropContinuousQueryStatement:
+		return s.executeDropContinuousQueryStatement(stmt, database, user)
+	case *influxql.ShowContinuousQueriesStatement:
+		return s.executeShowContinuousQueriesStatement(stmt, database, user)
+	case *influxql.ShowContinuousQueryStatsStatement:
+		return s.executeShowContinuousQueryStatsStatement(stmt, database, user)
+	case *influxql.ShowStatsStatement:
+		return s.executeShowStatsStatement(stmt, user)
+	case *influxql.ShowClusterStatement:
+		return s.executeShowClusterStatement(stmt, user)
+	case *influxql.ShowServersStatement:
+		return s.executeShowServersStatement(stmt, user)
+	case *influxql.ExplainStatement:
+		return s.executeExplainStatement(stmt, database, user)
+	default:
+		panic(fmt.Sprintf("unsupported statement type: %T", stmt))
+	}
+}
+
+// executeSelectStatementStream plans and executes a select statement against a
+// database and passes rows to fn in chunks of at most chunkSize rows. Execution
+// is stopped if it has not finished by deadline. Returns false if the
+// statement failed. Returns an error if fn returns an error.
+func (s *Server) executeSelectStatementStream(stmt *influxql.SelectStatement, database string, user *User, chunkSize int, deadline time.Time, fn func(*Result) error) (bool, error) {
+	// Return immediately if the deadline has already passed.
+	if !deadline.IsZero() && !time.Now().Before(deadline) {
+		return false, fn(&Result{Err: ErrQueryTimeout})
+	}
+
+	// Return cached rows if the statement's results cannot change.
+	key, cacheable := s.queryCacheKey(stmt)
+	if cacheable {
+		if rows, ok := s.queryCache.get(key); ok {
+			s.stats.Inc("queryCacheHit")
+			return true, sendRows(rows, chunkSize, fn)
+		}
+	}
+
+	// Plan statement execution.
+	e, err := s.planSelectStatement(stmt, user)
+	if err != nil {
+		return false, fn(&Result{Err: err})
+	}
+
+	// Execute plan.
+	ch, err := e.Execute()
+	if err != nil {
+		return false, fn(&Result{Err: err})
+	}
+
+	// Stop the executor if it is still running at the deadline.
+	timedout := make(chan struct{})
+	if !deadline.IsZero() {
+		timer := time.AfterFunc(deadline.Sub(time.Now()), func() {
+			close(timedout)
+			e.Stop()
+		})
+		defer timer.Stop()
+	}
+
+	// If the statement has an INTO clause then write the rows to the target.
+	if stmt.Target != nil {
+		res := s.writeSelectIntoResults(stmt.Target, database, ch)
+		select {
+		case <-timedout:
+			res = &Result{Err: ErrQueryTimeout}
+		default:
+		}
+		return res.Err == nil, fn(res)
+	}
+
+	// Read rows from channel and send them in chunks.
+	// An error row means execution was aborted and is always the last row.
+	var sent bool
+	var cached []*influxql.Row
+	rows := make([]*influxql.Row, 0)
+	for row := range ch {
+		if row.Err != nil {
+			// Report the user's quota if it is the limit that was exceeded.
+			if _, quota := s.maxSelectPointN(user); quota && row.Err == influxql.ErrMaxSelectPointsLimitExceeded {
+				row.Err = ErrUserSelectPointsLimitExceeded
+			}
+			return false, fn(&Result{Err: row.Err})
+		}
+		if cacheable {
+			cached = append(cached, row)
+		}
+		rows = append(rows, row)
+		if chunkSize > 0 && len(rows) >= chunkSize {
+			if err := fn(&Result{Rows: rows}); err != nil {
+				e.Stop()
+				return false, err
+			}
+			sent = true
+			rows = make([]*influxql.Row, 0)
+		}
+	}
+
+	// Return a timeout error if execution was aborted.
+	select {
+	case <-timedout:
+		return false, fn(&Result{Rows: rows, Err: ErrQueryTimeout})
+	default:
+	}
+
+	// Cache the complete results.
+	if cacheable {
+		s.queryCache.set(key, cached, s.QueryCacheSize)
+	}
+
+	// Send any remaining rows. An empty result is sent if no rows were sent.
+	if len(rows) > 0 || !sent {
+		if err := fn(&Result{Rows: rows}); err != nil {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// sendRows passes rows to fn in chunks of at most chunkSize rows.
+// An empty result is sent if there are no rows.
+func sendRows(rows []*influxql.Row, chunkSize int, fn func(*Result) error) error {
+	if chunkSize <= 0 || len(rows) <= chunkSize {
+		return fn(&Result{Rows: rows})
+	}
+	for i := 0; i < len(rows); i += chunkSize {
+		j := i + chunkSize
+		if j > len(rows) {
+			j = len(rows)
+		}
+		if err := fn(&Result{Rows: rows[i:j]}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// queryCacheKey returns the key that a statement's results are cached under.
+// Returns false if the cache is disabled or if the statement is not fully
+// aggregated or reads shard groups that can still be written to.
+//
+// Closed shard groups still receive backfilled points so the key includes
+// the generation of every shard read. A write to any of them, or a new shard
+// group in the time range, changes the key. Statements that read shards
+// stored on other nodes are not cached since their writes are not seen here.
+func (s *Server) queryCacheKey(stmt *influxql.SelectStatement) (string, bool) {
+	if s.QueryCacheSize <= 0 || stmt.Target != nil {
+		return "", false
+	}
+
+	// Only cache statements where every field is an aggregate.
+	for _, f := range stmt.Fields {
+		if _, ok := f.Expr.(*influxql.Call); !ok {
+			return "", false
+		}
+	}
+
+	// The statement must have an upper time bound in the past. Without an
+	// upper bound the range ends at now.
+	now := time.Now()
+	condition, tmin, tmax := queryTimeRange(stmt.Condition, now)
+	if !tmax.Before(now) {
+		return "", false
+	}
+
+	// Ensure every shard group in the time range has closed.
+	var gens bytes.Buffer
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, m := range sourceMeasurements(stmt.Source) {
+		dbName, policyName, _, err := splitIdent(m.Name)
+		if err != nil {
+			return "", false
+		}
+		db := s.databases[dbName]
+		if db == nil {
+			return "", false
+		}
+		rp := db.policies[policyName]
+		if rp == nil {
+			return "", false
+		}
+		for _, g := range rp.shardGroups {
+			if !g.overlaps(tmin, tmax) {
+				continue
+			} else if g.maxTime().After(now) {
+				return "", false
+			}
+			for _, sh := range g.Shards {
+				gen := sh.generation()
+				if gen == 0 {
+					return "", false
+				}
+				fmt.Fprintf(&gens, " %d:%d", sh.ID, gen)
+			}
+		}
+	}
+
+	// Key on the statement with its time range resolved and the shards read.
+	other := stmt.Clone()
+	other.Condition = condition
+	return other.String() + gens.String(), true
+}
+
+// writeSelectIntoResults reads all rows from ch, converts them to points and
+// writes them into the target measurement. The returned result contains a
+// single row with the number of points written.
+func (s *Server) writeSelectIntoResults(target *influxql.Target, database string, ch <-chan *influxql.Row) *Result {
+	// Determine the database, retention policy & measurement to write into.
+	intoDB, intoRP, intoMeasurement, err := s.resolveTarget(target, database)
+	if err != nil {
+		// Drain the channel so the executor can finish.
+		for range ch {
 		}
+		return &Result{Err: err}
 	}
 
-	// Build empty resultsets.
-	results := Results{Results: make([]*Result, len(q.Statements))}
-
-	// Execute each statement.
-	for i, stmt := range q.Statements {
-		// Set default database and policy on the statement.
-		if err := s.NormalizeStatement(stmt, database); err != nil {
-			results.Results[i] = &Result{Err: err}
-			break
+	// Convert each row to points and write them to the target.
+	var index uint64
+	var written int
+	for row := range ch {
+		if err != nil {
+			continue
+		} else if row.Err != nil {
+			err = row.Err
+			continue
 		}
 
-		var res *Result
-		switch stmt := stmt.(type) {
-		case *influxql.SelectStatement:
-			res = s.executeSelectStatement(stmt, database, user)
-		case *influxql.CreateDatabaseStatement:
-			res = s.executeCreateDatabaseStatement(stmt, user)
-		case *influxql.DropDatabaseStatement:
-			res = s.executeDropDatabaseStatement(stmt, user)
-		case *influxql.ShowDatabasesStatement:
-			res = s.executeShowDatabasesStatement(stmt, user)
-		case *influxql.CreateUserStatement:
-			res = s.executeCreateUserStatement(stmt, user)
-		case *influxql.DropUserStatement:
-			res = s.executeDropUserStatement(stmt, user)
-		case *influxql.ShowUsersStatement:
-			res = s.executeShowUsersStatement(stmt, user)
-		case *influxql.DropSeriesStatement:
+		points, e := s.convertRowToPoints(intoMeasurement, row)
+		if e != nil {
+			err = e
 			continue
-		case *influxql.ShowSeriesStatement:
-			res = s.executeShowSeriesStatement(stmt, database, user)
-		case *influxql.ShowMeasurementsStatement:
-			res = s.executeShowMeasurementsStatement(stmt, database, user)
-		case *influxql.ShowTagKeysStatement:
-			res = s.executeShowTagKeysStatement(stmt, database, user)
-		case *influxql.ShowTagValuesStatement:
-			res = s.executeShowTagValuesStatement(stmt, database, user)
-		case *influxql.ShowFieldKeysStatement:
-			res = s.executeShowFieldKeysStatement(stmt, database, user)
-		case *influxql.GrantStatement:
-			res = s.executeGrantStatement(stmt, user)
-		case *influxql.RevokeStatement:
-			res = s.executeRevokeStatement(stmt, user)
-		case *influxql.CreateRetentionPolicyStatement:
-			res = s.executeCreateRetentionPolicyStatement(stmt, user)
-		case *influxql.AlterRetentionPolicyStatement:
-			res = s.executeAlterRetentionPolicyStatement(stmt, user)
-		case *influxql.DropRetentionPolicyStatement:
-			res = s.executeDropRetentionPolicyStatement(stmt, user)
-		case *influxql.ShowRetentionPoliciesStatement:
-			res = s.executeShowRetentionPoliciesStatement(stmt, user)
-		case *influxql.CreateContinuousQueryStatement:
-			res = s.executeCreateContinuousQueryStatement(stmt, user)
-		case *influxql.DropContinuousQueryStatement:
+		} else if len(points) == 0 {
 			continue
-		case *influxql.ShowContinuousQueriesStatement:
-			res = s.executeShowContinuousQueriesStatement(stmt, database, user)
-		default:
-			panic(fmt.Sprintf("unsupported statement type: %T", stmt))
 		}
 
-		// If an error occurs then stop processing remaining statements.
-		results.Results[i] = res
-		if res.Err != nil {
-			break
+		i, e := s.WriteSeries(intoDB, intoRP, points)
+		if e != nil {
+			err = e
+			continue
 		}
+		if i > index {
+			index = i
+		}
+		written += len(points)
+	}
+	if err != nil {
+		return &Result{Err: err}
 	}
 
-	// Fill any empty results after error.
-	for i, res := range results.Results {
-		if res == nil {
-			results.Results[i] = &Result{Err: ErrNotExecuted}
-		}
+	// Wait for the writes to be applied so they are visible to later statements.
+	if err := s.Sync(index); err != nil {
+		return &Result{Err: err}
 	}
 
-	return results
+	return &Result{
+		Rows: []*influxql.Row{{
+			Name:    "result",
+			Columns: []string{"time", "written"},
+			Values:  [][]interface{}{{time.Unix(0, 0).UTC(), written}},
+		}},
+	}
 }
 
-// executeSelectStatement plans and executes a select statement against a database.
-func (s *Server) executeSelectStatement(stmt *influxql.SelectStatement, database string, user *User) *Result {
-	// Plan statement execution.
-	e, err := s.planSelectStatement(stmt)
+// resolveTarget returns the database, retention policy and measurement name
+// referenced by an INTO clause. The database defaults to defaultDatabase and
+// the retention policy defaults to the database's default policy.
+func (s *Server) resolveTarget(target *influxql.Target, defaultDatabase string) (database, policy, name string, err error) {
+	if target.Database != "" {
+		defaultDatabase = target.Database
+	}
+
+	// Fully qualify the target measurement.
+	qualified, err := s.NormalizeMeasurement(target.Measurement, defaultDatabase)
 	if err != nil {
-		return &Result{Err: err}
+		return "", "", "", err
 	}
 
-	// Execute plan.
-	ch, err := e.Execute()
+	segments, err := influxql.SplitIdent(qualified)
 	if err != nil {
-		return &Result{Err: err}
+		return "", "", "", err
 	}
+	return segments[0], segments[1], segments[2], nil
+}
 
-	// Read all rows from channel.
-	res := &Result{Rows: make([]*influxql.Row, 0)}
-	for row := range ch {
-		res.Rows = append(res.Rows, row)
+// plans a selection statement under lock. The statement's point limit includes
+// the user's quota, if a user is specified.
+func (s *Server) planSelectStatement(stmt *influxql.SelectStatement, user *User) (*influxql.Executor, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// Expand wildcards to the measurement's fields and tag keys.
+	if stmt.HasWildcard() {
+		measurement, ok := stmt.Source.(*influxql.Measurement)
+		if !ok {
+			return nil, fmt.Errorf("unsupported query: %s.  wildcards require a single measurement source.", stmt.String())
+		}
+		segments, err := influxql.SplitIdent(measurement.Name)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse measurement %s", measurement.Name)
+		}
+		db, m := segments[0], segments[2]
+		mm := s.databases[db].measurements[m]
+		if mm == nil {
+			return nil, fmt.Errorf("measurement %s does not exist.", measurement.Name)
+		}
+		var fields []string
+		for _, f := range mm.Fields {
+			fields = append(fields, f.Name)
+		}
+		stmt = stmt.RewriteWildcards(fields, mm.tagKeys())
+	}
+
+	// Plan query. Non-admin users may only read the measurements they are
+	// authorized for.
+	var db influxql.DB = s
+	if s.authenticationEnabled && user != nil && !user.Admin {
+		db = &userDB{server: s, user: user}
 	}
+	p := influxql.NewPlanner(db)
+	p.MaxPointN, _ = s.maxSelectPointN(user)
+	p.MaxSeriesN = s.MaxSelectSeriesN
 
-	return res
+	return p.Plan(stmt)
 }
 
-// plans a selection statement under lock.
-func (s *Server) planSelectStatement(stmt *influxql.SelectStatement) (*influxql.Executor, error) {
+// executeExplainStatement plans a select statement without executing it and
+// returns a summary of the plan and a row for each shard that would be read.
+func (s *Server) executeExplainStatement(stmt *influxql.ExplainStatement, database string, user *User) *Result {
+	// Plan the statement to ensure it is valid. The plan is never executed.
+	if _, err := s.planSelectStatement(stmt.Statement, user); err != nil {
+		return &Result{Err: err}
+	}
+
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	// If this is a wildcard statement, expand this to use the fields
-	// This is temporary until we move other parts of the system further
-	isWildcard := false
-	for _, f := range stmt.Fields {
-		if _, ok := f.Expr.(*influxql.Wildcard); ok {
-			isWildcard = true
-			break
-		}
+	// Only single measurement sources are supported.
+	source, ok := stmt.Statement.Source.(*influxql.Measurement)
+	if !ok {
+		return &Result{Err: fmt.Errorf("unsupported source: %s", stmt.Statement.Source)}
+	}
+	dbName, policyName, name, err := splitIdent(source.Name)
+	if err != nil {
+		return &Result{Err: err}
 	}
 
-	if len(stmt.Fields) != 1 && isWildcard {
-		return nil, fmt.Errorf("unsupported query: %s.  currently only single wildcard is supported.", stmt.String())
+	// Find the database, retention policy & measurement.
+	db := s.databases[dbName]
+	if db == nil {
+		return &Result{Err: ErrDatabaseNotFound}
+	}
+	rp := db.policies[policyName]
+	if rp == nil {
+		return &Result{Err: ErrRetentionPolicyNotFound}
+	}
+	m := db.measurements[name]
+	if m == nil {
+		return &Result{Err: ErrMeasurementNotFound}
 	}
 
-	if isWildcard {
-		if measurement, ok := stmt.Source.(*influxql.Measurement); ok {
-			segments, err := influxql.SplitIdent(measurement.Name)
-			if err != nil {
-				return nil, fmt.Errorf("unable to parse measurement %s", measurement.Name)
-			}
-			db, m := segments[0], segments[2]
-			if s.databases[db].measurements[m] == nil {
-				return nil, fmt.Errorf("measurement %s does not exist.", measurement.Name)
-			}
-			var fields influxql.Fields
-			for _, f := range s.databases[db].measurements[m].Fields {
-				fields = append(fields, &influxql.Field{Expr: &influxql.VarRef{Val: f.Name}})
+	// Determine the time range in the same way the planner does.
+	condition, tmin, tmax := queryTimeRange(stmt.Statement.Condition, time.Now())
+
+	// Determine which series match the condition.
+	ids, _ := m.seriesIDsAndFilters(&influxql.SelectStatement{Condition: condition})
+
+	// Describe each shard that overlaps the time range.
+	shards := &influxql.Row{
+		Name:    "shards",
+		Columns: []string{"id", "start_time", "end_time", "points"},
+	}
+	var points int
+	for _, g := range rp.shardGroups {
+		if !g.overlaps(tmin, tmax) {
+			continue
+		}
+		for _, sh := range g.Shards {
+			// Estimate the points by counting keys. Remote shards are unknown.
+			var n int
+			if sh.hasStore() {
+				if n, err = sh.countPoints(ids, tmin.UnixNano(), tmax.UnixNano()); err != nil {
+					return &Result{Err: err}
+				}
 			}
-			stmt.Fields = fields
+			points += n
+			shards.Values = append(shards.Values, []interface{}{sh.ID, g.StartTime, g.EndTime, n})
 		}
 	}
 
-	// Plan query.
-	p := influxql.NewPlanner(s)
+	// Summarize the plan.
+	plan := &influxql.Row{
+		Name:    "plan",
+		Columns: []string{"measurement", "start_time", "end_time", "shards", "series", "points"},
+		Values:  [][]interface{}{{source.Name, tmin.UTC(), tmax.UTC(), len(shards.Values), len(ids), points}},
+	}
 
-	return p.Plan(stmt)
+	return &Result{Rows: []*influxql.Row{plan, shards}}
 }
 
 func (s *Server) executeCreateDatabaseStatement(q *influxql.CreateDatabaseStatement, user *User) *Result {
@@ -2049,6 +5272,10 @@ func (s *Server) executeDropDatabaseStatement(q *influxql.DropDatabaseStatement,
 	return &Result{Err: s.DeleteDatabase(q.Name)}
 }
 
+func (s *Server) executeDropShardStatement(q *influxql.DropShardStatement, user *User) *Result {
+	return &Result{Err: s.DeleteShard(q.ID)}
+}
+
 func (s *Server) executeShowDatabasesStatement(q *influxql.ShowDatabasesStatement, user *User) *Result {
 	row := &influxql.Row{Columns: []string{"name"}}
 	for _, name := range s.Databases() {
@@ -2085,18 +5312,22 @@ func (s *Server) executeShowSeriesStatement(stmt *influxql.ShowSeriesStatement,
 		return &Result{Err: err}
 	}
 
-	// // If OFFSET is past the end of the array, return empty results.
-	// if stmt.Offset > len(measurements)-1 {
-	// 	return &Result{}
-	// }
-
 	// Create result struct that will be populated and returned.
 	result := &Result{
 		Rows: make(influxql.Rows, 0, len(measurements)),
 	}
 
+	// SOFFSET and SLIMIT page through the series of each measurement. OFFSET
+	// and LIMIT then apply to the series across all measurements.
+	offset, remaining := stmt.Offset, stmt.Limit
+
 	// Loop through measurements to build result. One result row / measurement.
 	for _, m := range measurements {
+		// Stop once the limit has been reached.
+		if stmt.Limit > 0 && remaining <= 0 {
+			break
+		}
+
 		var ids seriesIDs
 
 		if stmt.Condition != nil {
@@ -2112,7 +5343,31 @@ func (s *Server) executeShowSeriesStatement(stmt *influxql.ShowSeriesStatement,
 			// TODO: check return of walkWhereForSeriesIds for fields
 		} else {
 			// No WHERE clause so get all series IDs for this measurement.
-			ids = m.seriesIDs
+			ids = m.index().seriesIDs
+		}
+
+		// Page through the measurement's series.
+		if stmt.SOffset >= len(ids) {
+			continue
+		}
+		ids = ids[stmt.SOffset:]
+		if stmt.SLimit > 0 && len(ids) > stmt.SLimit {
+			ids = ids[:stmt.SLimit]
+		}
+
+		// Skip series before the offset.
+		if offset >= len(ids) {
+			offset -= len(ids)
+			continue
+		}
+		ids, offset = ids[offset:], 0
+
+		// Truncate series past the limit.
+		if stmt.Limit > 0 {
+			if len(ids) > remaining {
+				ids = ids[:remaining]
+			}
+			remaining -= len(ids)
 		}
 
 		// Make a new row for this measurement.
@@ -2122,8 +5377,9 @@ func (s *Server) executeShowSeriesStatement(stmt *influxql.ShowSeriesStatement,
 		}
 
 		// Loop through series IDs getting matching tag sets.
+		index := m.index()
 		for _, id := range ids {
-			if s, ok := m.seriesByID[id]; ok {
+			if s, ok := index.seriesByID[id]; ok {
 				values := make([]interface{}, 0, len(r.Columns))
 				for _, column := range r.Columns {
 					values = append(values, s.Tags[column])
@@ -2141,6 +5397,55 @@ func (s *Server) executeShowSeriesStatement(stmt *influxql.ShowSeriesStatement,
 	return result
 }
 
+// executeShowSeriesCardinalityStatement returns one row for each measurement
+// with its number of series. Estimates use the number of series tracked for
+// each measurement so indexes that aren't held in memory aren't loaded. The
+// tracked numbers can't be filtered so a WHERE clause always counts exactly.
+func (s *Server) executeShowSeriesCardinalityStatement(stmt *influxql.ShowSeriesCardinalityStatement, database string, user *User) *Result {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// Find the database.
+	db := s.databases[database]
+	if db == nil {
+		return &Result{Err: ErrDatabaseNotFound}
+	}
+
+	// Get the list of measurements we're interested in.
+	measurements, err := measurementsFromSourceOrDB(stmt.Source, db)
+	if err != nil {
+		return &Result{Err: err}
+	}
+
+	result := &Result{
+		Rows: make(influxql.Rows, 0, len(measurements)),
+	}
+	for _, m := range measurements {
+		var n int
+		if stmt.Condition != nil {
+			// Count the series IDs that match the WHERE clause.
+			filters := map[uint32]influxql.Expr{}
+			ids, _, _ := m.walkWhereForSeriesIds(stmt.Condition, filters)
+			if len(ids) == 0 {
+				continue
+			}
+			n = len(ids)
+		} else if stmt.Exact {
+			n = len(m.index().seriesIDs)
+		} else {
+			n = m.seriesN
+		}
+
+		result.Rows = append(result.Rows, &influxql.Row{
+			Name:    m.Name,
+			Columns: []string{"count"},
+			Values:  [][]interface{}{{n}},
+		})
+	}
+
+	return result
+}
+
 func (s *Server) executeShowMeasurementsStatement(stmt *influxql.ShowMeasurementsStatement, database string, user *User) *Result {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
@@ -2151,9 +5456,8 @@ func (s *Server) executeShowMeasurementsStatement(stmt *influxql.ShowMeasurement
 		return &Result{Err: ErrDatabaseNotFound}
 	}
 
-	// Get all measurements in sorted order.
+	// Get all measurements.
 	measurements := db.Measurements()
-	sort.Sort(measurements)
 
 	// If a WHERE clause was specified, filter the measurements.
 	if stmt.Condition != nil {
@@ -2163,6 +5467,7 @@ func (s *Server) executeShowMeasurementsStatement(stmt *influxql.ShowMeasurement
 			return &Result{Err: err}
 		}
 	}
+	sort.Sort(measurements)
 
 	offset := stmt.Offset
 	limit := stmt.Limit
@@ -2201,6 +5506,66 @@ func (s *Server) executeShowMeasurementsStatement(stmt *influxql.ShowMeasurement
 	return result
 }
 
+// executeShowMeasurementCardinalityStatement returns the number of measurements
+// in the database. The estimate includes every measurement in the metadata
+// while an exact count only includes measurements that have series.
+func (s *Server) executeShowMeasurementCardinalityStatement(stmt *influxql.ShowMeasurementCardinalityStatement, database string, user *User) *Result {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// Find the database.
+	db := s.databases[database]
+	if db == nil {
+		return &Result{Err: ErrDatabaseNotFound}
+	}
+
+	var n int
+	if stmt.Condition != nil {
+		measurements, err := db.measurementsByExpr(stmt.Condition)
+		if err != nil {
+			return &Result{Err: err}
+		}
+		n = len(measurements)
+	} else if stmt.Exact {
+		for _, m := range db.measurements {
+			if len(m.index().seriesIDs) > 0 {
+				n++
+			}
+		}
+	} else {
+		n = len(db.measurements)
+	}
+
+	return &Result{
+		Rows: influxql.Rows{{
+			Name:    "measurements",
+			Columns: []string{"count"},
+			Values:  [][]interface{}{{n}},
+		}},
+	}
+}
+
+// executeShowMeasurementSizesStatement returns the approximate bytes used by
+// each measurement in the database's local shards, largest first.
+func (s *Server) executeShowMeasurementSizesStatement(stmt *influxql.ShowMeasurementSizesStatement, database string, user *User) *Result {
+	stats, err := s.DatabaseShardStats(database)
+	if err != nil {
+		return &Result{Err: err}
+	}
+
+	a := make(measurementSizes, 0, len(stats.Measurements))
+	for name, size := range stats.Measurements {
+		a = append(a, measurementSize{name: name, size: size})
+	}
+	sort.Sort(a)
+
+	row := &influxql.Row{Name: "measurements", Columns: []string{"name", "size"}}
+	for _, m := range a {
+		row.Values = append(row.Values, []interface{}{m.name, m.size})
+	}
+	return &Result{Rows: influxql.Rows{row}}
+}
+
 func (s *Server) executeShowTagKeysStatement(stmt *influxql.ShowTagKeysStatement, database string, user *User) *Result {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
@@ -2222,15 +5587,52 @@ func (s *Server) executeShowTagKeysStatement(stmt *influxql.ShowTagKeysStatement
 		Rows: make(influxql.Rows, 0, len(measurements)),
 	}
 
+	// Determine the time range of the WHERE clause, if any.
+	var condition influxql.Expr
+	var tmin, tmax time.Time
+	if stmt.Condition != nil {
+		now := time.Now()
+		condition = influxql.Reduce(stmt.Condition, &influxql.NowValuer{Now: now})
+		tmin, tmax = influxql.TimeRange(condition)
+		if !tmin.IsZero() && tmax.IsZero() {
+			tmax = now
+		}
+	}
+
 	// Add one row per measurement to the result.
 	for _, m := range measurements {
-		// TODO: filter tag keys by stmt.Condition
-
 		// Get the tag keys in sorted order.
 		keys := m.tagKeys()
 
+		// Only include tag keys from series matching the WHERE clause.
+		if condition != nil {
+			ids, _ := m.seriesIDsAndFilters(&influxql.SelectStatement{Condition: condition})
+
+			// Remove series without data in the time range.
+			if !tmin.IsZero() || !tmax.IsZero() {
+				if ids, err = s.seriesIDsWithPoints(db, ids, tmin, tmax); err != nil {
+					return &Result{Err: err}
+				}
+			}
+
+			// If no series matched, then go to the next measurement.
+			if len(ids) == 0 {
+				continue
+			}
+			keys = m.tagKeysBySeriesIDs(ids)
+		}
+
+		// Apply the OFFSET and LIMIT to the tag keys of each measurement.
+		if stmt.Offset >= len(keys) {
+			continue
+		}
+		keys = keys[stmt.Offset:]
+		if stmt.Limit > 0 && len(keys) > stmt.Limit {
+			keys = keys[:stmt.Limit]
+		}
+
 		// Convert keys to an [][]interface{}.
-		values := make([][]interface{}, 0, len(m.seriesByTagKeyValue))
+		values := make([][]interface{}, 0, len(keys))
 		for _, k := range keys {
 			v := interface{}(k)
 			values = append(values, []interface{}{v})
@@ -2246,11 +5648,47 @@ func (s *Server) executeShowTagKeysStatement(stmt *influxql.ShowTagKeysStatement
 		result.Rows = append(result.Rows, r)
 	}
 
-	// TODO: LIMIT & OFFSET
-
 	return result
 }
 
+// seriesIDsWithPoints returns the subset of ids that have at least one point
+// in the database's local shards between tmin and tmax, inclusive.
+func (s *Server) seriesIDsWithPoints(db *database, ids seriesIDs, tmin, tmax time.Time) (seriesIDs, error) {
+	if tmin.IsZero() {
+		tmin = time.Unix(0, 1)
+	}
+
+	found := make(map[uint32]bool)
+	for _, rp := range db.policies {
+		for _, g := range rp.shardGroups {
+			if !g.overlaps(tmin, tmax) {
+				continue
+			}
+			for _, sh := range g.Shards {
+				if !sh.hasStore() {
+					continue
+				}
+				a, err := sh.seriesIDsWithPoints(ids, tmin.UnixNano(), tmax.UnixNano())
+				if err != nil {
+					return nil, err
+				}
+				for _, id := range a {
+					found[id] = true
+				}
+			}
+		}
+	}
+
+	// Retain the original ordering of ids.
+	var other seriesIDs
+	for _, id := range ids {
+		if found[id] {
+			other = append(other, id)
+		}
+	}
+	return other, nil
+}
+
 func (s *Server) executeShowTagValuesStatement(stmt *influxql.ShowTagValuesStatement, database string, user *User) *Result {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
@@ -2288,7 +5726,7 @@ func (s *Server) executeShowTagValuesStatement(stmt *influxql.ShowTagValuesState
 			// TODO: check return of walkWhereForSeriesIds for fields
 		} else {
 			// No WHERE clause so get all series IDs for this measurement.
-			ids = m.seriesIDs
+			ids = m.index().seriesIDs
 		}
 
 		tagValues := m.tagValuesByKeyAndSeriesID(stmt.TagKeys, ids)
@@ -2324,6 +5762,108 @@ func (s *Server) executeShowContinuousQueriesStatement(stmt *influxql.ShowContin
 	return &Result{Rows: rows}
 }
 
+func (s *Server) executeShowContinuousQueryStatsStatement(stmt *influxql.ShowContinuousQueryStatsStatement, database string, user *User) *Result {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rows := make([]*influxql.Row, 0)
+	for _, name := range s.databaseNames() {
+		row := &influxql.Row{Columns: []string{"name", "lastRun", "duration", "pointsWritten", "lastError"}, Name: name}
+		for _, cq := range s.databases[name].continuousQueries {
+			var lastRun interface{}
+			if !cq.LastRunTime.IsZero() {
+				lastRun = cq.LastRunTime
+			}
+			row.Values = append(row.Values, []interface{}{cq.cq.Name, lastRun, cq.LastRunDuration.String(), cq.PointsWritten, cq.LastError})
+		}
+		rows = append(rows, row)
+	}
+	return &Result{Rows: rows}
+}
+
+func (s *Server) executeShowStatsStatement(stmt *influxql.ShowStatsStatement, user *User) *Result {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// Add the counters and current server state to a single row.
+	row := &influxql.Row{Name: s.stats.Name(), Columns: []string{"name", "value"}}
+	stats := s.stats.Snapshot()
+	stats.Set("index", int64(s.index))
+	stats.Set("databases", int64(len(s.databases)))
+	stats.Set("shards", int64(len(s.shards)))
+	stats.Set("messageErrors", int64(s.errors.len()))
+	stats.Walk(func(k string, v int64) {
+		row.Values = append(row.Values, []interface{}{k, v})
+	})
+
+	// Add the series & shard counts for each database.
+	databases := &influxql.Row{Name: "databases", Columns: []string{"name", "measurements", "series", "shards"}}
+	for _, name := range s.databaseNames() {
+		db := s.databases[name]
+		var shardN int
+		for _, rp := range db.policies {
+			for _, g := range rp.shardGroups {
+				shardN += len(g.Shards)
+			}
+		}
+		databases.Values = append(databases.Values, []interface{}{name, len(db.measurements), db.seriesN(), shardN})
+	}
+
+	return &Result{Rows: []*influxql.Row{row, databases}}
+}
+
+func (s *Server) executeShowServersStatement(stmt *influxql.ShowServersStatement, user *User) *Result {
+	nodes, id := s.DataNodes(), s.ID()
+
+	// Ping all remote data nodes in parallel. The local node is always alive.
+	alive := make([]bool, len(nodes))
+	var wg sync.WaitGroup
+	for i, n := range nodes {
+		if n.ID == id {
+			alive[i] = true
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, u *url.URL) {
+			defer wg.Done()
+			alive[i] = pingDataNode(u, DefaultDataNodePingTimeout)
+		}(i, n.URL)
+	}
+	wg.Wait()
+
+	row := &influxql.Row{Columns: []string{"id", "url", "alive"}}
+	for i, n := range nodes {
+		row.Values = append(row.Values, []interface{}{n.ID, n.URL.String(), alive[i]})
+	}
+	return &Result{Rows: []*influxql.Row{row}}
+}
+
+// pingDataNode returns true if the data node at u responds to a ping within timeout.
+func pingDataNode(u *url.URL, timeout time.Duration) bool {
+	other := *u
+	other.Path = "/ping"
+
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Get(other.String())
+	if err != nil {
+		return false
+	}
+	_ = resp.Body.Close()
+	return resp.StatusCode == http.StatusNoContent
+}
+
+// databaseNames returns the sorted names of all databases.
+// The server lock must be held by the caller.
+func (s *Server) databaseNames() []string {
+	a := make([]string, 0, len(s.databases))
+	for name := range s.databases {
+		a = append(a, name)
+	}
+	sort.Strings(a)
+	return a
+}
+
 // filterMeasurementsByExpr filters a list of measurements by a tags expression.
 func filterMeasurementsByExpr(measurements Measurements, expr influxql.Expr) (Measurements, error) {
 	// Create a list to hold result measurements.
@@ -2456,7 +5996,9 @@ func (s *Server) executeShowUsersStatement(q *influxql.ShowUsersStatement, user
 func (s *Server) executeCreateRetentionPolicyStatement(q *influxql.CreateRetentionPolicyStatement, user *User) *Result {
 	rp := NewRetentionPolicy(q.Name)
 	rp.Duration = q.Duration
+	rp.ShardGroupDuration = q.ShardGroupDuration
 	rp.ReplicaN = uint32(q.Replication)
+	rp.Compression = q.Compression
 
 	// Create new retention policy.
 	err := s.CreateRetentionPolicy(q.Database, rp)
@@ -2474,8 +6016,13 @@ func (s *Server) executeCreateRetentionPolicyStatement(q *influxql.CreateRetenti
 
 func (s *Server) executeAlterRetentionPolicyStatement(stmt *influxql.AlterRetentionPolicyStatement, user *User) *Result {
 	rpu := &RetentionPolicyUpdate{
-		Duration: stmt.Duration,
-		ReplicaN: func() *uint32 { n := uint32(*stmt.Replication); return &n }(),
+		Duration:           stmt.Duration,
+		ShardGroupDuration: stmt.ShardGroupDuration,
+		Compression:        stmt.Compression,
+	}
+	if stmt.Replication != nil {
+		n := uint32(*stmt.Replication)
+		rpu.ReplicaN = &n
 	}
 
 	// Update the retention policy.
@@ -2502,9 +6049,13 @@ func (s *Server) executeShowRetentionPoliciesStatement(q *influxql.ShowRetention
 		return &Result{Err: err}
 	}
 
-	row := &influxql.Row{Columns: []string{"name", "duration", "replicaN"}}
+	row := &influxql.Row{Columns: []string{"name", "duration", "shardGroupDuration", "replicaN", "compression"}}
 	for _, rp := range a {
-		row.Values = append(row.Values, []interface{}{rp.Name, rp.Duration.String(), rp.ReplicaN})
+		compression := rp.Compression
+		if compression == "" {
+			compression = CompressionNone
+		}
+		row.Values = append(row.Values, []interface{}{rp.Name, rp.Duration.String(), rp.ShardGroupDuration.String(), rp.ReplicaN, compression})
 	}
 	return &Result{Rows: []*influxql.Row{row}}
 }
@@ -2519,6 +6070,25 @@ func (s *Server) CreateContinuousQuery(q *influxql.CreateContinuousQueryStatemen
 	return err
 }
 
+func (s *Server) executeDropContinuousQueryStatement(q *influxql.DropContinuousQueryStatement, database string, user *User) *Result {
+	return &Result{Err: s.DeleteContinuousQuery(database, q.Name)}
+}
+
+// DeleteContinuousQuery removes a continuous query from a database.
+func (s *Server) DeleteContinuousQuery(database, name string) error {
+	c := &deleteContinuousQueryCommand{Database: database, Name: name}
+	_, err := s.broadcast(deleteContinuousQueryMessageType, c)
+	return err
+}
+
+// SetContinuousQueryEnabled pauses or resumes a continuous query. A paused query
+// keeps its definition but isn't run until it is resumed.
+func (s *Server) SetContinuousQueryEnabled(database, name string, enabled bool) error {
+	c := &setContinuousQueryEnabledCommand{Database: database, Name: name, Enabled: enabled}
+	_, err := s.broadcast(setContinuousQueryEnabledMessageType, c)
+	return err
+}
+
 func (s *Server) ContinuousQueries(database string) []*ContinuousQuery {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
@@ -2572,10 +6142,13 @@ func (s *Server) measurement(database, name string) (*Measurement, error) {
 func (s *Server) Begin() (influxql.Tx, error) { return newTx(s), nil }
 
 // NormalizeStatement adds a default database and policy to the measurements in statement.
-func (s *Server) NormalizeStatement(stmt influxql.Statement, defaultDatabase string) (err error) {
+func (s *Server) NormalizeStatement(stmt influxql.Statement, defaultDatabase string) error {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
+	return s.normalizeStatement(stmt, defaultDatabase)
+}
 
+func (s *Server) normalizeStatement(stmt influxql.Statement, defaultDatabase string) (err error) {
 	// Track prefixes for replacing field names.
 	prefixes := make(map[string]string)
 
@@ -2678,13 +6251,18 @@ func (s *Server) processor(client MessagingClient, done chan struct{}) {
 			return
 		case m, ok = <-client.C():
 			if !ok {
+				go s.reconnectClient(done)
 				return
 			}
 		}
 
-		// Exit if closed.
+		// Exit if closed. Skip messages replayed by the broker after a reconnect.
 		// TODO: Wrap this check in a lock with the apply itself.
-		if !s.opened() {
+		s.mu.RLock()
+		opened := s.opened()
+		last, seen := s.topicIndex[m.TopicID]
+		s.mu.RUnlock()
+		if !opened || (seen && m.Index <= last) {
 			continue
 		}
 
@@ -2697,6 +6275,12 @@ func (s *Server) processor(client MessagingClient, done chan struct{}) {
 			err = s.applyCreateDataNode(m)
 		case deleteDataNodeMessageType:
 			err = s.applyDeleteDataNode(m)
+		case updateDataNodeMessageType:
+			err = s.applyUpdateDataNode(m)
+		case setDataNodeZoneMessageType:
+			err = s.applySetDataNodeZone(m)
+		case removeDataNodeMessageType:
+			err = s.applyRemoveDataNode(m)
 		case createDatabaseMessageType:
 			err = s.applyCreateDatabase(m)
 		case deleteDatabaseMessageType:
@@ -2717,28 +6301,119 @@ func (s *Server) processor(client MessagingClient, done chan struct{}) {
 			err = s.applyCreateShardGroupIfNotExists(m)
 		case deleteShardGroupMessageType:
 			err = s.applyDeleteShardGroup(m)
+		case retentionSweepMessageType:
+			err = s.applyRetentionSweep(m)
+		case deleteShardMessageType:
+			err = s.applyDeleteShard(m)
+		case setShardOwnersMessageType:
+			err = s.applySetShardOwners(m)
+		case splitShardMessageType:
+			err = s.applySplitShard(m)
 		case setDefaultRetentionPolicyMessageType:
 			err = s.applySetDefaultRetentionPolicy(m)
 		case createFieldsIfNotExistsMessageType:
 			err = s.applyCreateFieldsIfNotExist(m)
+		case setMeasurementTTLMessageType:
+			err = s.applySetMeasurementTTL(m)
 		case createSeriesIfNotExistsMessageType:
 			err = s.applyCreateSeriesIfNotExists(m)
+		case deleteSeriesMessageType:
+			err = s.applyDeleteSeries(m)
 		case setPrivilegeMessageType:
 			err = s.applySetPrivilege(m)
+		case setMeasurementPrivilegeMessageType:
+			err = s.applySetMeasurementPrivilege(m)
+		case ensureAdminUserMessageType:
+			err = s.applyEnsureAdminUser(m)
+		case resetRootPasswordMessageType:
+			err = s.applyResetRootPassword(m)
+		case createAPITokenMessageType:
+			err = s.applyCreateAPIToken(m)
+		case deleteAPITokenMessageType:
+			err = s.applyDeleteAPIToken(m)
+		case createRoleMessageType:
+			err = s.applyCreateRole(m)
+		case deleteRoleMessageType:
+			err = s.applyDeleteRole(m)
+		case setRolePrivilegeMessageType:
+			err = s.applySetRolePrivilege(m)
+		case setUserRoleMessageType:
+			err = s.applySetUserRole(m)
 		case createContinuousQueryMessageType:
 			err = s.applyCreateContinuousQueryCommand(m)
+		case deleteContinuousQueryMessageType:
+			err = s.applyDeleteContinuousQueryCommand(m)
+		case setContinuousQueryStatsMessageType:
+			err = s.applySetContinuousQueryStatsCommand(m)
+		case setContinuousQueryEnabledMessageType:
+			err = s.applySetContinuousQueryEnabledCommand(m)
+		case createSnapshotMessageType:
+			err = s.applyCreateSnapshot(m)
+		case repairMetadataMessageType:
+			err = s.applyRepairMetadata(m)
 		}
 
 		// Sync high water mark and errors.
 		s.mu.Lock()
 		s.index = m.Index
+		s.topicIndex[m.TopicID] = m.Index
 		if err != nil {
-			s.errors[m.Index] = err
+			if n := s.errors.add(m.Index, err, time.Now()); n > 0 {
+				s.stats.Add("messageErrorsDropped", int64(n))
+			}
+		}
+		if s.indexCh != nil {
+			close(s.indexCh)
+			s.indexCh = nil
+		}
+		if time.Since(s.topicIndexSaved) >= topicIndexSaveInterval {
+			s.saveTopicIndexes()
 		}
 		s.mu.Unlock()
 	}
 }
 
+// topicIndexSaveInterval is the minimum time between saves of the indexes
+// applied from each topic. Messages applied after the last save are applied
+// again if the server restarts.
+const topicIndexSaveInterval = 1 * time.Second
+
+// saveTopicIndexes saves the index of the last message applied from each
+// topic to the metastore. The broker won't replay messages up to the saved
+// indexes so shard stores that don't sync their writes are synced first.
+// Lock must be held.
+func (s *Server) saveTopicIndexes() {
+	if s.ShardStoreOptions.NoSync {
+		for _, sh := range s.shards {
+			if err := sh.sync(); err != nil {
+				log.Printf("save topic indexes: sync shard %d: %s", sh.ID, err)
+				return
+			}
+		}
+	}
+
+	if err := s.meta.update(func(tx *metatx) error {
+		return tx.setTopicIndexes(s.topicIndex)
+	}); err != nil {
+		log.Printf("save topic indexes: %s", err)
+		return
+	}
+	s.topicIndexSaved = time.Now()
+}
+
+// TopicIndexes returns the index of the last message applied from each broker
+// topic. A messaging client streams from these indexes so messages applied
+// before a restart aren't sent again.
+func (s *Server) TopicIndexes() map[uint64]uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	m := make(map[uint64]uint64, len(s.topicIndex))
+	for topicID, index := range s.topicIndex {
+		m[topicID] = index
+	}
+	return m
+}
+
 // Result represents a resultset returned from a single statement.
 type Result struct {
 	Rows []*influxql.Row
@@ -2860,6 +6535,10 @@ type MessagingClient interface {
 type DataNode struct {
 	ID  uint64
 	URL *url.URL
+
+	// The failure domain of the node, such as a rack or availability zone.
+	// Replicas of a shard are spread across zones. Blank if not set.
+	Zone string
 }
 
 // newDataNode returns an instance of DataNode.
@@ -2871,6 +6550,12 @@ func (p dataNodes) Len() int           { return len(p) }
 func (p dataNodes) Less(i, j int) bool { return p[i].ID < p[j].ID }
 func (p dataNodes) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
 
+type uint64Slice []uint64
+
+func (p uint64Slice) Len() int           { return len(p) }
+func (p uint64Slice) Less(i, j int) bool { return p[i] < p[j] }
+func (p uint64Slice) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
 // Authorize user u to execute query q on database.
 // database can be "" for queries that do not require a database.
 // If u is nil, this means authorization is disabled.
@@ -2904,7 +6589,7 @@ func (s *Server) Authorize(u *User, q *influxql.Query, database string) error {
 			}
 
 			// Check if user has required privilege.
-			if !u.Authorize(p.Privilege, dbname) {
+			if !u.Authorize(p.Privilege, dbname) && !authorizeSelectSource(u, stmt, p, dbname) {
 				var msg string
 				if dbname == "" {
 					msg = "requires cluster admin"
@@ -2921,9 +6606,51 @@ func (s *Server) Authorize(u *User, q *influxql.Query, database string) error {
 	return nil
 }
 
-// BcryptCost is the cost associated with generating password with Bcrypt.
-// This setting is lowered during testing to improve test suite performance.
-var BcryptCost = 10
+// authorizeSelectSource returns true if the read privilege required by a select
+// statement is granted by the user's privileges on its source measurements.
+// The measurements are checked again when the statement is planned.
+func authorizeSelectSource(u *User, stmt influxql.Statement, p influxql.ExecutionPrivilege, database string) bool {
+	// Only the source of a select statement is covered by measurement privileges.
+	var sel *influxql.SelectStatement
+	switch stmt := stmt.(type) {
+	case *influxql.SelectStatement:
+		sel = stmt
+	case *influxql.ExplainStatement:
+		sel = stmt.Statement
+	}
+	if sel == nil || p.Name != "" || p.Privilege != influxql.ReadPrivilege {
+		return false
+	}
+
+	var measurements influxql.Measurements
+	switch src := sel.Source.(type) {
+	case *influxql.Measurement:
+		measurements = influxql.Measurements{src}
+	case *influxql.Join:
+		measurements = src.Measurements
+	case *influxql.Merge:
+		measurements = src.Measurements
+	}
+	if len(measurements) == 0 {
+		return false
+	}
+
+	for _, m := range measurements {
+		// Measurements may be fully qualified with a database & policy.
+		segments, err := influxql.SplitIdent(m.Name)
+		if err != nil {
+			return false
+		}
+		db, name := database, segments[len(segments)-1]
+		if len(segments) == 3 && segments[0] != "" {
+			db = segments[0]
+		}
+		if !u.AuthorizeMeasurement(p.Privilege, db, name) {
+			return false
+		}
+	}
+	return true
+}
 
 // User represents a user account on the system.
 // It can be given read/write permissions to individual databases.
@@ -2932,6 +6659,12 @@ type User struct {
 	Hash       string                        `json:"hash"`
 	Privileges map[string]influxql.Privilege `json:"privileges"` // db name to privilege
 	Admin      bool                          `json:"admin,omitempty"`
+	Roles      []string                      `json:"roles,omitempty"` // role names
+
+	// Privileges on individual measurements, by db name.
+	MeasurementPrivileges map[string][]*MeasurementPrivilege `json:"measurementPrivileges,omitempty"`
+
+	roles []*Role // roles the user is a member of
 }
 
 // Authenticate returns nil if the password matches the user's password.
@@ -2941,11 +6674,110 @@ func (u *User) Authenticate(password string) error {
 }
 
 // Authorize returns true if the user is authorized and false if not.
+// Privileges granted through the user's roles are included.
 func (u *User) Authorize(privilege influxql.Privilege, database string) bool {
-	p, ok := u.Privileges[database]
+	p, ok := u.privilege(database)
 	return (ok && p >= privilege) || (u.Admin)
 }
 
+// AuthorizeMeasurement returns true if the user is authorized to access a
+// measurement, either through a database privilege or a measurement privilege.
+func (u *User) AuthorizeMeasurement(privilege influxql.Privilege, database, measurement string) bool {
+	if u.Authorize(privilege, database) {
+		return true
+	}
+	for _, p := range u.MeasurementPrivileges[database] {
+		if p.Privilege >= privilege && p.Matches(measurement) {
+			return true
+		}
+	}
+	return false
+}
+
+// removeMeasurementPrivilege removes the grant on a measurement name or regex.
+func (u *User) removeMeasurementPrivilege(database, measurement string, isRegex bool) {
+	a := u.MeasurementPrivileges[database]
+	for i, p := range a {
+		if p.Measurement == measurement && p.IsRegex == isRegex {
+			a = append(a[:i:i], a[i+1:]...)
+			break
+		}
+	}
+	if len(a) == 0 {
+		delete(u.MeasurementPrivileges, database)
+	} else {
+		u.MeasurementPrivileges[database] = a
+	}
+}
+
+// privilege returns the highest privilege the user has on a database,
+// either directly or through a role.
+func (u *User) privilege(database string) (influxql.Privilege, bool) {
+	p, ok := u.Privileges[database]
+	for _, r := range u.roles {
+		if rp, rok := r.Privileges[database]; rok && (!ok || rp > p) {
+			p, ok = rp, true
+		}
+	}
+	return p, ok
+}
+
+// addRole adds the user to a role. Returns false if the user is already a member.
+func (u *User) addRole(r *Role) bool {
+	for _, name := range u.Roles {
+		if name == r.Name {
+			return false
+		}
+	}
+	u.Roles = append(u.Roles, r.Name)
+	u.roles = append(u.roles, r)
+	return true
+}
+
+// removeRole removes the user from a role. Returns false if the user isn't a member.
+func (u *User) removeRole(name string) bool {
+	for i, n := range u.Roles {
+		if n == name {
+			u.Roles = append(u.Roles[:i:i], u.Roles[i+1:]...)
+			break
+		}
+	}
+	for i, r := range u.roles {
+		if r.Name == name {
+			u.roles = append(u.roles[:i:i], u.roles[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// MeasurementPrivilege represents a privilege on the measurements of a
+// database that match a name or a regular expression.
+type MeasurementPrivilege struct {
+	Measurement string             `json:"measurement"`
+	IsRegex     bool               `json:"isRegex,omitempty"`
+	Privilege   influxql.Privilege `json:"privilege"`
+}
+
+// Matches returns true if the privilege applies to the measurement name.
+func (p *MeasurementPrivilege) Matches(name string) bool {
+	m := Matcher{IsRegex: p.IsRegex, Name: p.Measurement}
+	return m.Matches(name)
+}
+
+// Role represents a named set of database privileges that can be granted to users.
+type Role struct {
+	Name       string                        `json:"name"`
+	Privileges map[string]influxql.Privilege `json:"privileges"` // db name to privilege
+}
+
+// roles represents a list of roles, sortable by name.
+type roles []*Role
+
+func (p roles) Len() int           { return len(p) }
+func (p roles) Less(i, j int) bool { return p[i].Name < p[j].Name }
+func (p roles) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
 // users represents a list of users, sortable by name.
 type users []*User
 
@@ -2968,20 +6800,22 @@ func (m *Matcher) Matches(name string) bool {
 	return m.Name == name
 }
 
-// HashPassword generates a cryptographically secure hash for password.
-// Returns an error if the password is invalid or a hash cannot be generated.
-func HashPassword(password string) ([]byte, error) {
-	// The second arg is the cost of the hashing, higher is slower but makes
-	// it harder to brute force, since it will be really slow and impractical
-	return bcrypt.GenerateFromPassword([]byte(password), BcryptCost)
-}
-
 // ContinuousQuery represents a query that exists on the server and processes
 // each incoming event.
 type ContinuousQuery struct {
 	Query string `json:"query"`
 
+	// Paused queries are not run.
+	Disabled bool `json:"disabled,omitempty"`
+
+	// Execution statistics of the last run.
+	LastRunTime     time.Time     `json:"lastRunTime,omitempty"`
+	LastRunDuration time.Duration `json:"lastRunDuration,omitempty"`
+	PointsWritten   int           `json:"pointsWritten,omitempty"`
+	LastError       string        `json:"lastError,omitempty"`
+
 	mu              sync.Mutex
+	seq             uint64 // key in the metastore, assigned when first saved
 	cq              *influxql.CreateContinuousQueryStatement
 	lastRun         time.Time
 	intoDB          string
@@ -2989,6 +6823,34 @@ type ContinuousQuery struct {
 	intoMeasurement string
 }
 
+// Authenticator represents an external service that verifies user credentials.
+type Authenticator interface {
+	Authenticate(username, password string) error
+}
+
+// AuthenticatorFunc is an adapter to allow a function to be used as an Authenticator.
+type AuthenticatorFunc func(username, password string) error
+
+// Authenticate calls fn(username, password).
+func (fn AuthenticatorFunc) Authenticate(username, password string) error {
+	return fn(username, password)
+}
+
+// ContinuousQueryErrorHandler represents an object that is notified when a
+// continuous query run fails, e.g. to export failures to an alerting system.
+type ContinuousQueryErrorHandler interface {
+	HandleContinuousQueryError(database, name string, err error)
+}
+
+// ContinuousQueryErrorHandlerFunc is an adapter to allow a function to be
+// used as a ContinuousQueryErrorHandler.
+type ContinuousQueryErrorHandlerFunc func(database, name string, err error)
+
+// HandleContinuousQueryError calls fn(database, name, err).
+func (fn ContinuousQueryErrorHandlerFunc) HandleContinuousQueryError(database, name string, err error) {
+	fn(database, name, err)
+}
+
 // NewContinuousQuery returns a ContinuousQuery object with a parsed influxql.CreateContinuousQueryStatement
 func NewContinuousQuery(q string) (*ContinuousQuery, error) {
 	stmt, err := influxql.NewParser(strings.NewReader(q)).ParseStatement()
@@ -3032,7 +6894,7 @@ func NewContinuousQuery(q string) (*ContinuousQuery, error) {
 // applyCreateContinuousQueryCommand adds the continuous query to the database object and saves it to the metastore
 func (s *Server) applyCreateContinuousQueryCommand(m *messaging.Message) error {
 	var c createContinuousQueryCommand
-	mustUnmarshalJSON(m.Data, &c)
+	mustDecodeCommand(m.Data, &c)
 
 	cq, err := NewContinuousQuery(c.Query)
 	if err != nil {
@@ -3065,28 +6927,161 @@ func (s *Server) applyCreateContinuousQueryCommand(m *messaging.Message) error {
 
 	// Persist to metastore.
 	s.meta.mustUpdate(func(tx *metatx) error {
-		return tx.saveDatabase(db)
+		return tx.saveContinuousQuery(db.name, cq)
+	})
+
+	return nil
+}
+
+func (s *Server) applyDeleteContinuousQueryCommand(m *messaging.Message) error {
+	var c deleteContinuousQueryCommand
+	mustDecodeCommand(m.Data, &c)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Retrieve the database.
+	db := s.databases[c.Database]
+	if db == nil {
+		return ErrDatabaseNotFound
+	}
+
+	// Remove the cq from the database.
+	for i, cq := range db.continuousQueries {
+		if cq.cq.Name == c.Name {
+			db.continuousQueries = append(db.continuousQueries[:i], db.continuousQueries[i+1:]...)
+
+			// Persist to metastore.
+			s.meta.mustUpdate(func(tx *metatx) error {
+				return tx.deleteContinuousQuery(db.name, cq)
+			})
+			return nil
+		}
+	}
+	return ErrContinuousQueryNotFound
+}
+
+// applySetContinuousQueryEnabledCommand pauses or resumes a continuous query and saves it to the metastore
+func (s *Server) applySetContinuousQueryEnabledCommand(m *messaging.Message) error {
+	var c setContinuousQueryEnabledCommand
+	mustDecodeCommand(m.Data, &c)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Retrieve the database.
+	db := s.databases[c.Database]
+	if db == nil {
+		return ErrDatabaseNotFound
+	}
+
+	cq := db.continuousQueryByName(c.Name)
+	if cq == nil {
+		return ErrContinuousQueryNotFound
+	}
+	cq.Disabled = !c.Enabled
+
+	// Persist to metastore.
+	s.meta.mustUpdate(func(tx *metatx) error {
+		return tx.saveContinuousQuery(db.name, cq)
+	})
+
+	return nil
+}
+
+// applySetContinuousQueryStatsCommand records the result of a continuous query run and saves it to the metastore
+func (s *Server) applySetContinuousQueryStatsCommand(m *messaging.Message) error {
+	var c setContinuousQueryStatsCommand
+	mustDecodeCommand(m.Data, &c)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Retrieve the database.
+	db := s.databases[c.Database]
+	if db == nil {
+		return ErrDatabaseNotFound
+	}
+
+	// The query may have been dropped while it was running.
+	cq := db.continuousQueryByName(c.Name)
+	if cq == nil {
+		return ErrContinuousQueryNotFound
+	}
+
+	cq.LastRunTime = c.Time
+	cq.LastRunDuration = c.Duration
+	cq.PointsWritten = c.PointsWritten
+	cq.LastError = c.Error
+
+	// Persist to metastore.
+	s.meta.mustUpdate(func(tx *metatx) error {
+		return tx.saveContinuousQuery(db.name, cq)
 	})
 
 	return nil
 }
 
+// continuousQueriesInto returns the continuous queries across all databases that
+// write into a database and retention policy. A blank policy matches any policy.
+// A continuous query without an explicit policy writes into the default policy.
+func (s *Server) continuousQueriesInto(database, policy string) []*ContinuousQuery {
+	var a []*ContinuousQuery
+	for _, db := range s.databases {
+		for _, cq := range db.continuousQueries {
+			if cq.intoDB != database {
+				continue
+			}
+
+			rp := cq.intoRP
+			if rp == "" && s.databases[database] != nil {
+				rp = s.databases[database].defaultRetentionPolicy
+			}
+			if policy == "" || rp == policy {
+				a = append(a, cq)
+			}
+		}
+	}
+	return a
+}
+
 // RunContinuousQueries will run any continuous queries that are due to run and write the
 // results back into the database
 func (s *Server) RunContinuousQueries() error {
+	return s.RunContinuousQueriesForNodes(nil)
+}
+
+// RunContinuousQueriesForNodes runs the continuous queries that are due to run and are
+// assigned to this data node when queries are spread across the given data nodes.
+// Every continuous query is run if no data nodes are given.
+func (s *Server) RunContinuousQueriesForNodes(nodeIDs []uint64) error {
+	if s.ReadOnly() {
+		return ErrReadOnly
+	}
+
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
+	if s.cqDone == nil {
+		return ErrServerClosed
+	}
+
 	for _, d := range s.databases {
 		for _, c := range d.continuousQueries {
-			if s.shouldRunContinuousQuery(c) {
+			// skip paused queries and queries another data node is responsible for
+			if c.Disabled {
+				continue
+			} else if len(nodeIDs) > 0 && continuousQueryOwner(d.name, c.cq.Name, nodeIDs) != s.id {
+				continue
+			}
+
+			if s.shouldRunContinuousQuery(c) && s.queueContinuousQuery(c) {
 				// set the into retention policy based on what is now the default
 				if c.intoRP == "" {
 					c.intoRP = d.defaultRetentionPolicy
 				}
-				go func(cq *ContinuousQuery) {
-					s.runContinuousQuery(c)
-				}(c)
+				s.cqWG.Add(1)
+				go s.runQueuedContinuousQuery(c, s.cqDone)
 			}
 		}
 	}
@@ -3094,6 +7089,105 @@ func (s *Server) RunContinuousQueries() error {
 	return nil
 }
 
+// queueContinuousQuery marks a continuous query as pending. Returns false if
+// the query is already waiting to run.
+func (s *Server) queueContinuousQuery(cq *ContinuousQuery) bool {
+	s.cqMu.Lock()
+	defer s.cqMu.Unlock()
+
+	if s.cqPending[cq] {
+		return false
+	}
+	s.cqPending[cq] = true
+	return true
+}
+
+// runQueuedContinuousQuery waits for the query's start offset and a free slot,
+// then runs a pending continuous query. Returns early if done is closed.
+func (s *Server) runQueuedContinuousQuery(cq *ContinuousQuery, done chan struct{}) {
+	defer s.cqWG.Done()
+
+	// Once the query starts it can be queued again to pick up newer data.
+	dequeue := func() {
+		s.cqMu.Lock()
+		delete(s.cqPending, cq)
+		s.cqMu.Unlock()
+	}
+
+	// Wait for the query's start offset.
+	if d := continuousQueryJitter(cq.cq.Database, cq.cq.Name, s.ContinuousQueryJitter); d > 0 {
+		select {
+		case <-done:
+			dequeue()
+			return
+		case <-time.After(d):
+		}
+	}
+
+	// Wait for a free slot.
+	if slots := s.continuousQuerySlots(); slots != nil {
+		select {
+		case <-done:
+			dequeue()
+			return
+		case slots <- struct{}{}:
+		}
+		defer func() { <-slots }()
+	}
+
+	dequeue()
+	s.runContinuousQuery(cq)
+}
+
+// stopContinuousQueries drops queued continuous queries and waits for running
+// ones to finish so that their shards aren't closed while they're read.
+func (s *Server) stopContinuousQueries() {
+	s.mu.Lock()
+	if s.cqDone != nil {
+		close(s.cqDone)
+		s.cqDone = nil
+	}
+	s.mu.Unlock()
+	s.cqWG.Wait()
+}
+
+// continuousQuerySlots returns the semaphore limiting concurrently running
+// continuous queries. Returns nil if they are not limited.
+func (s *Server) continuousQuerySlots() chan struct{} {
+	s.cqMu.Lock()
+	defer s.cqMu.Unlock()
+
+	if s.MaxConcurrentContinuousQueries <= 0 {
+		return nil
+	} else if s.cqSlots == nil || cap(s.cqSlots) != s.MaxConcurrentContinuousQueries {
+		s.cqSlots = make(chan struct{}, s.MaxConcurrentContinuousQueries)
+	}
+	return s.cqSlots
+}
+
+// continuousQueryJitter returns the delay before a continuous query starts, up to max.
+// The delay is derived from the query's name so it is the same for every run.
+func continuousQueryJitter(database, name string, max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	h := fnv.New64a()
+	h.Write([]byte(database + "." + name))
+	return time.Duration(h.Sum64() % uint64(max))
+}
+
+// continuousQueryOwner returns the data node, of the given nodes, that runs a continuous query.
+// Queries are assigned by hashing their name so the assignment only depends on the set of nodes.
+func continuousQueryOwner(database, name string, nodeIDs []uint64) uint64 {
+	ids := make([]uint64, len(nodeIDs))
+	copy(ids, nodeIDs)
+	sort.Sort(uint64Slice(ids))
+
+	h := fnv.New32a()
+	h.Write([]byte(database + "." + name))
+	return ids[h.Sum32()%uint32(len(ids))]
+}
+
 // shouldRunContinuousQuery returns true if the CQ should be schedule to run. It will use the
 // lastRunTime of the CQ and the rules for when to run set through the config to determine
 // if this CQ should be run
@@ -3116,6 +7210,10 @@ func (s *Server) shouldRunContinuousQuery(cq *ContinuousQuery) bool {
 	if computeEvery < s.ComputeNoMoreThan {
 		computeEvery = s.ComputeNoMoreThan
 	}
+	// a RESAMPLE EVERY clause overrides the config
+	if cq.cq.ResampleEvery > 0 {
+		computeEvery = cq.cq.ResampleEvery
+	}
 
 	// if we've passed the amount of time since the last run, do it up
 	if cq.lastRun.Add(computeEvery).UnixNano() <= time.Now().UnixNano() {
@@ -3125,18 +7223,46 @@ func (s *Server) shouldRunContinuousQuery(cq *ContinuousQuery) bool {
 	return false
 }
 
-// runContinuousQuery will execute a continuous query
-// TODO: make this fan out to the cluster instead of running all the queries on this single data node
+// runContinuousQuery will execute a continuous query and record the result of the run
+// across the cluster.
 func (s *Server) runContinuousQuery(cq *ContinuousQuery) {
+	now := time.Now()
+	n, err := s.executeContinuousQuery(cq, now)
+
+	c := &setContinuousQueryStatsCommand{
+		Database:      cq.cq.Database,
+		Name:          cq.cq.Name,
+		Time:          now,
+		Duration:      time.Since(now),
+		PointsWritten: n,
+	}
+	if err != nil {
+		log.Printf("cq error: %s. running: %s\n", err.Error(), cq.cq.String())
+		c.Error = err.Error()
+
+		s.mu.RLock()
+		h := s.cqErrorHandler
+		s.mu.RUnlock()
+		if h != nil {
+			h.HandleContinuousQueryError(cq.cq.Database, cq.cq.Name, err)
+		}
+	}
+	if _, err := s.broadcast(setContinuousQueryStatsMessageType, c); err != nil {
+		log.Printf("cq error recording stats: %s\n", err.Error())
+	}
+}
+
+// executeContinuousQuery runs a continuous query for the current interval and recomputes
+// previous intervals. It returns the number of points written and the last error.
+func (s *Server) executeContinuousQuery(cq *ContinuousQuery, now time.Time) (int, error) {
 	cq.mu.Lock()
 	defer cq.mu.Unlock()
 
-	now := time.Now()
 	cq.lastRun = now
 
 	interval, err := cq.cq.Source.GroupByInterval()
 	if err != nil || interval == 0 {
-		return
+		return 0, err
 	}
 
 	startTime := now.Round(interval)
@@ -3145,63 +7271,83 @@ func (s *Server) runContinuousQuery(cq *ContinuousQuery) {
 	}
 
 	if err := cq.cq.Source.SetTimeRange(startTime, startTime.Add(interval)); err != nil {
-		log.Printf("cq error setting time range: %s\n", err.Error())
+		return 0, err
 	}
 
-	if err := s.runContinuousQueryAndWriteResult(cq); err != nil {
-		log.Printf("cq error: %s. running: %s\n", err.Error(), cq.cq.String())
+	n, lastErr := s.runContinuousQueryAndWriteResult(cq)
+
+	// a RESAMPLE FOR clause overrides how far back the config recomputes
+	recomputePreviousN, recomputeNoOlderThan := s.RecomputePreviousN, s.RecomputeNoOlderThan
+	if d := cq.cq.ResampleFor; d > 0 {
+		recomputePreviousN = int((d+interval-1)/interval) - 1
+		recomputeNoOlderThan = d
 	}
 
-	for i := 0; i < s.RecomputePreviousN; i++ {
+	for i := 0; i < recomputePreviousN; i++ {
 		// if we're already more time past the previous window than we're going to look back, stop
-		if now.Sub(startTime) > s.RecomputeNoOlderThan {
-			return
+		if now.Sub(startTime) > recomputeNoOlderThan {
+			break
 		}
 		newStartTime := startTime.Add(-interval)
 
 		if err := cq.cq.Source.SetTimeRange(newStartTime, startTime); err != nil {
-			log.Printf("cq error setting time range: %s\n", err.Error())
+			return n, err
 		}
 
-		if err := s.runContinuousQueryAndWriteResult(cq); err != nil {
-			log.Printf("cq error: %s. running: %s\n", err.Error(), cq.cq.String())
+		m, err := s.runContinuousQueryAndWriteResult(cq)
+		n += m
+		if err != nil {
+			lastErr = err
 		}
 
 		startTime = newStartTime
 	}
+
+	return n, lastErr
 }
 
-// runContinuousQueryAndWriteResult will run the query against the cluster and write the results back in
-func (s *Server) runContinuousQueryAndWriteResult(cq *ContinuousQuery) error {
-	e, err := s.planSelectStatement(cq.cq.Source)
+// runContinuousQueryAndWriteResult will run the query against the cluster and write the results back in.
+// It returns the number of points written and the last error encountered while writing.
+func (s *Server) runContinuousQueryAndWriteResult(cq *ContinuousQuery) (int, error) {
+	e, err := s.planSelectStatement(cq.cq.Source, nil)
 
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	// Execute plan.
 	ch, err := e.Execute()
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	// Read all rows from channel and write them in
+	var n int
+	var lastErr error
 	for row := range ch {
 		points, err := s.convertRowToPoints(cq.intoMeasurement, row)
 		if err != nil {
 			log.Println(err)
+			lastErr = err
 			continue
 		}
 
 		if len(points) > 0 {
-			_, err = s.WriteSeries(cq.intoDB, cq.intoRP, points)
+			if w := s.ContinuousQueryWriters[cq.intoDB]; w != nil {
+				err = w.WritePoints(cq.intoDB, cq.intoRP, points)
+			} else {
+				_, err = s.WriteSeries(cq.intoDB, cq.intoRP, points)
+			}
 			if err != nil {
 				log.Printf("[cq] err: %s", err)
+				lastErr = err
+				continue
 			}
+			n += len(points)
 		}
 	}
 
-	return nil
+	return n, lastErr
 }
 
 // convertRowToPoints will convert a query result Row into Points that can be written back in.
@@ -3247,6 +7393,28 @@ type createContinuousQueryCommand struct {
 	Query string `json:"query"`
 }
 
+type deleteContinuousQueryCommand struct {
+	Database string `json:"database"`
+	Name     string `json:"name"`
+}
+
+// setContinuousQueryEnabledCommand is the raft command for pausing or resuming a continuous query
+type setContinuousQueryEnabledCommand struct {
+	Database string `json:"database"`
+	Name     string `json:"name"`
+	Enabled  bool   `json:"enabled"`
+}
+
+// setContinuousQueryStatsCommand is the raft command for recording the result of a continuous query run
+type setContinuousQueryStatsCommand struct {
+	Database      string        `json:"database"`
+	Name          string        `json:"name"`
+	Time          time.Time     `json:"time"`
+	Duration      time.Duration `json:"duration"`
+	PointsWritten int           `json:"pointsWritten"`
+	Error         string        `json:"error,omitempty"`
+}
+
 // copyURL returns a copy of the the URL.
 func copyURL(u *url.URL) *url.URL {
 	other := &url.URL{}
@@ -2,14 +2,24 @@ package influxdb_test
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"os"
+	"path/filepath"
 	"reflect"
+	"sort"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -31,6 +41,215 @@ func TestServer_Open(t *testing.T) {
 	}
 }
 
+// Ensure the server replaces its client and resubscribes when the message stream closes.
+func TestServer_ReconnectClient(t *testing.T) {
+	// Create a replacement client that continues from the broker's index.
+	c, other := NewMessagingClient(), NewMessagingClient()
+	var mu sync.Mutex
+	var topics []uint64
+	other.SubscribeFunc = func(replicaID, topicID uint64) error {
+		mu.Lock()
+		defer mu.Unlock()
+		topics = append(topics, topicID)
+		return nil
+	}
+
+	s := NewServer()
+	defer s.Close()
+	s.ReconnectClient = func() (influxdb.MessagingClient, error) {
+		other.index = c.index
+		return other, nil
+	}
+	if err := s.Server.Open(tempfile()); err != nil {
+		t.Fatal(err)
+	} else if err := s.SetClient(c); err != nil {
+		t.Fatal(err)
+	} else if err := s.Initialize(&url.URL{Host: "127.0.0.1:8080"}); err != nil {
+		t.Fatal(err)
+	}
+	s.CreateDatabase("db")
+	s.CreateRetentionPolicy("db", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.MustWriteSeries("db", "raw", []influxdb.Point{{Name: "cpu", Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(10)}}})
+	groups, _ := s.ShardGroups("db")
+
+	// Record the message that creates a database.
+	var replay *messaging.Message
+	c.PublishFunc = func(m *messaging.Message) (uint64, error) {
+		replay = m
+		return c.send(m)
+	}
+	s.CreateDatabase("foo")
+
+	// Close the stream and wait for the new client to be attached and to
+	// resubscribe to the shard's topic.
+	close(c.c)
+	for i := 0; ; i++ {
+		mu.Lock()
+		a := topics
+		mu.Unlock()
+		if s.Client() == other && len(a) > 0 {
+			if !reflect.DeepEqual(a, []uint64{groups[0].Shards[0].ID}) {
+				t.Fatalf("unexpected subscriptions: %v", a)
+			}
+			break
+		} else if i == 100 {
+			t.Fatal("client not replaced")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Messages that were already applied are skipped when replayed.
+	if err := s.DeleteDatabase("foo"); err != nil {
+		t.Fatal(err)
+	}
+	other.c <- replay
+	if err := s.CreateDatabase("bar"); err != nil {
+		t.Fatal(err)
+	} else if s.DatabaseExists("foo") {
+		t.Fatal("replayed message applied")
+	}
+}
+
+// Ensure a reconnected client is closed if the server was given another client meanwhile.
+func TestServer_ReconnectClient_Replaced(t *testing.T) {
+	// Attach a new client while the server is reconnecting.
+	c, other, replacement := NewMessagingClient(), NewMessagingClient(), NewMessagingClient()
+	closed := make(chan struct{})
+	other.CloseFunc = func() error { close(closed); return nil }
+
+	s := NewServer()
+	defer s.Close()
+	s.ReconnectClient = func() (influxdb.MessagingClient, error) {
+		if err := s.SetClient(replacement); err != nil {
+			t.Error(err)
+		}
+		return other, nil
+	}
+	if err := s.Server.Open(tempfile()); err != nil {
+		t.Fatal(err)
+	} else if err := s.SetClient(c); err != nil {
+		t.Fatal(err)
+	} else if err := s.Initialize(&url.URL{Host: "127.0.0.1:8080"}); err != nil {
+		t.Fatal(err)
+	}
+
+	close(c.c)
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("reconnected client not closed")
+	}
+	if s.Client() != replacement {
+		t.Fatal("unexpected client")
+	}
+}
+
+// Ensure the server resumes after the messages it applied before it was closed.
+func TestServer_TopicIndexes(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+
+	// Record the message that creates a database.
+	var replay *messaging.Message
+	c.PublishFunc = func(m *messaging.Message) (uint64, error) {
+		replay = m
+		return c.send(m)
+	}
+	s.CreateDatabase("foo")
+	c.PublishFunc = c.send
+	if err := s.DeleteDatabase("foo"); err != nil {
+		t.Fatal(err)
+	}
+	indexes := s.TopicIndexes()
+	if indexes[messaging.BroadcastTopicID] != c.index {
+		t.Fatalf("unexpected indexes: %v", indexes)
+	}
+
+	// Open the server's path in a new server.
+	path := s.Path()
+	s.Server.Close()
+	s.Server = NewServer().Server
+	if err := s.Open(path); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(s.TopicIndexes(), indexes) {
+		t.Fatalf("unexpected indexes after reopen: %v", s.TopicIndexes())
+	}
+
+	// Messages that were applied before closing are skipped when replayed.
+	other := NewMessagingClient()
+	other.index = c.index
+	if err := s.SetClient(other); err != nil {
+		t.Fatal(err)
+	}
+	other.c <- replay
+	if err := s.CreateDatabase("bar"); err != nil {
+		t.Fatal(err)
+	} else if s.DatabaseExists("foo") {
+		t.Fatal("replayed message applied")
+	}
+}
+
+// Ensure the server retries failed subscriptions and resubscribes on startup.
+func TestServer_Subscriptions(t *testing.T) {
+	// Fail the first two subscription attempts.
+	var mu sync.Mutex
+	var attempts int
+	var topics []uint64
+	c := NewMessagingClient()
+	c.SubscribeFunc = func(replicaID, topicID uint64) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if attempts++; attempts <= 2 {
+			return errors.New("marker")
+		}
+		topics = append(topics, topicID)
+		return nil
+	}
+
+	s := NewServer()
+	defer s.Close()
+	s.SubscriptionRetryDelay = 10 * time.Millisecond
+	if err := s.Server.Open(tempfile()); err != nil {
+		t.Fatal(err)
+	} else if err := s.SetClient(c); err != nil {
+		t.Fatal(err)
+	} else if err := s.Initialize(&url.URL{Host: "127.0.0.1:8080"}); err != nil {
+		t.Fatal(err)
+	}
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	waitForTopics := func(n int) []uint64 {
+		for i := 0; ; i++ {
+			mu.Lock()
+			a := topics
+			mu.Unlock()
+			if len(a) >= n {
+				return a
+			} else if i == 100 {
+				t.Fatalf("unexpected subscriptions: %v", a)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// The shard's topic is subscribed once the broker accepts it.
+	if err := s.CreateShardGroupIfNotExists("foo", "raw", mustParseTime("2000-01-01T00:00:00Z")); err != nil {
+		t.Fatal(err)
+	}
+	groups, _ := s.ShardGroups("foo")
+	id := groups[0].Shards[0].ID
+	if a := waitForTopics(1); !reflect.DeepEqual(a, []uint64{id}) {
+		t.Fatalf("unexpected subscriptions: %v", a)
+	}
+
+	// The topic is subscribed again when the server restarts.
+	s.Restart()
+	if a := waitForTopics(2); !reflect.DeepEqual(a, []uint64{id, id}) {
+		t.Fatalf("unexpected subscriptions: %v", a)
+	}
+}
+
 // Ensure an error is returned when opening an already open server.
 func TestServer_Open_ErrServerOpen(t *testing.T) { t.Skip("pending") }
 
@@ -74,834 +293,4395 @@ func TestServer_CreateDatabase_ErrDataNodeExists(t *testing.T) {
 	}
 }
 
-// Ensure the server can delete a node.
-func TestServer_DeleteDataNode(t *testing.T) {
+// Ensure the server can change the URL of a node.
+func TestServer_UpdateDataNode(t *testing.T) {
 	s := OpenServer(NewMessagingClient())
 	defer s.Close()
 
-	// Create a data node and verify it exists.
 	u, _ := url.Parse("http://localhost:80000")
 	if err := s.CreateDataNode(u); err != nil {
 		t.Fatal(err)
-	} else if s.DataNodeByURL(u) == nil {
-		t.Fatalf("data node not actually created")
 	}
-	s.Restart()
-
-	// Drop the node and verify that it's gone.
 	n := s.DataNodeByURL(u)
-	if err := s.DeleteDataNode(n.ID); err != nil {
+
+	// Update the URL and verify it persists.
+	other, _ := url.Parse("http://10.0.0.1:8086")
+	if err := s.UpdateDataNode(n.ID, other); err != nil {
 		t.Fatal(err)
-	} else if s.DataNode(n.ID) != nil {
-		t.Fatalf("data node not actually dropped")
+	}
+	s.Restart()
+	if n := s.DataNode(n.ID); n == nil || n.URL.String() != other.String() {
+		t.Fatalf("unexpected data node: %#v", n)
+	} else if s.DataNodeByURL(u) != nil {
+		t.Fatal("old url still exists")
+	}
+
+	// URLs must be unique and required.
+	if err := s.UpdateDataNode(n.ID, &url.URL{Host: "127.0.0.1:8080"}); err != influxdb.ErrDataNodeExists {
+		t.Fatalf("unexpected error: %v", err)
+	} else if err := s.UpdateDataNode(n.ID, nil); err != influxdb.ErrDataNodeURLRequired {
+		t.Fatalf("unexpected error: %v", err)
+	} else if err := s.UpdateDataNode(1000, other); err != influxdb.ErrDataNodeNotFound {
+		t.Fatalf("unexpected error: %v", err)
 	}
 }
 
-// Test unuathorized requests logging
-func TestServer_UnauthorizedRequests(t *testing.T) {
+// Ensure the server reloads the series and measurements of every database on restart.
+func TestServer_Restart_LoadIndexes(t *testing.T) {
 	s := OpenServer(NewMessagingClient())
 	defer s.Close()
+	for i := 0; i < 10; i++ {
+		name := fmt.Sprintf("db%d", i)
+		s.CreateDatabase(name)
+		s.CreateRetentionPolicy(name, &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+		s.MustWriteSeries(name, "raw", []influxdb.Point{
+			{Name: "cpu", Tags: map[string]string{"host": "servera"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(i)}},
+			{Name: "mem", Tags: map[string]string{"host": "serverb"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"used": float64(i)}},
+		})
+	}
+	s.Restart()
 
-	s.SetAuthenticationEnabled(true)
+	for i := 0; i < 10; i++ {
+		name := fmt.Sprintf("db%d", i)
+		if a := s.MeasurementNames(name); !reflect.DeepEqual(a, []string{"cpu", "mem"}) {
+			t.Fatalf("%s: unexpected measurements: %v", name, a)
+		} else if v, err := s.ReadSeries(name, "raw", "mem", map[string]string{"host": "serverb"}, mustParseTime("2000-01-01T00:00:00Z")); err != nil {
+			t.Fatalf("%s: read series: %s", name, err)
+		} else if !reflect.DeepEqual(v, map[string]interface{}{"used": float64(i)}) {
+			t.Fatalf("%s: unexpected values: %#v", name, v)
+		}
+	}
+}
 
-	var b bytes.Buffer
-	s.SetLogOutput(&b)
+// Ensure a server can join through a list of seeds, retrying after failures.
+func TestServer_Join(t *testing.T) {
+	seed := OpenServer(NewMessagingClient())
+	defer seed.Close()
+	seed.CreateDatabase("foo")
+
+	// Serve the seed's data nodes & metastore. The first metastore copy fails.
+	var metaN int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "POST" && r.URL.Path == "/data_nodes":
+			var n struct{ URL string }
+			json.NewDecoder(r.Body).Decode(&n)
+			u, _ := url.Parse(n.URL)
+			if err := seed.CreateDataNode(u); err == influxdb.ErrDataNodeExists {
+				w.WriteHeader(http.StatusConflict)
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprint(w, mustMarshalJSON(map[string]interface{}{"id": seed.DataNodeByURL(u).ID, "url": n.URL}))
+		case r.URL.Path == "/data_nodes":
+			var a []map[string]interface{}
+			for _, n := range seed.DataNodes() {
+				a = append(a, map[string]interface{}{"id": n.ID, "url": n.URL.String()})
+			}
+			fmt.Fprint(w, mustMarshalJSON(a))
+		case r.URL.Path == "/metastore":
+			if metaN++; metaN == 1 {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			seed.CopyMetastore(w)
+		}
+	}))
+	defer ts.Close()
 
-	adminOnlyQuery := &influxql.Query{
-		Statements: []influxql.Statement{
-			&influxql.DropDatabaseStatement{Name: "foo"},
-		},
-	}
+	// Create a seed that is down.
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
 
-	e := s.Authorize(nil, adminOnlyQuery, "foo")
-	if _, ok := e.(influxdb.ErrAuthorize); !ok {
-		t.Fatalf("unexpected error.  expected %v, actual: %v", influxdb.ErrAuthorize{}, e)
-	}
-	if !strings.Contains(b.String(), "unauthorized request") {
-		t.Log(b.String())
-		t.Fatalf(`log should contain "unuathorized request"`)
+	s := OpenUninitializedServer(NewMessagingClient())
+	defer s.Close()
+	s.JoinBackoff = time.Millisecond
+
+	// Join through both seeds. The data node from the first attempt is reused.
+	u, _ := url.Parse("http://localhost:9000")
+	downURL, _ := url.Parse(down.URL)
+	seedURL, _ := url.Parse(ts.URL)
+	if err := s.Join(u, downURL, seedURL); err != nil {
+		t.Fatal(err)
+	} else if n := seed.DataNodeByURL(u); n == nil || s.ID() != n.ID {
+		t.Fatalf("unexpected id: %d", s.ID())
+	} else if !s.DatabaseExists("foo") {
+		t.Fatal("database not copied")
+	} else if metaN != 2 {
+		t.Fatalf("unexpected metastore requests: %d", metaN)
 	}
+}
 
-	b.Reset()
+// Ensure a server won't replace its metastore with one from another cluster.
+func TestServer_Join_ErrClusterMismatch(t *testing.T) {
+	other := OpenServer(NewMessagingClient())
+	defer other.Close()
+
+	// Create a seed that copies the metastore of an unrelated server.
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/data_nodes":
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprint(w, mustMarshalJSON(map[string]interface{}{"id": 2, "url": "http://localhost:9000"}))
+		case "/metastore":
+			other.CopyMetastore(w)
+		}
+	}))
+	defer ts.Close()
 
-	// Create normal database user.
-	s.CreateUser("user1", "user1", false)
-	user1 := s.User("user1")
+	s := OpenUninitializedServer(NewMessagingClient())
+	defer s.Close()
+	s.JoinAttempts = 1
 
-	e = s.Authorize(user1, adminOnlyQuery, "foo")
-	if _, ok := e.(influxdb.ErrAuthorize); !ok {
-		t.Fatalf("unexpected error.  expected %v, actual: %v", influxdb.ErrAuthorize{}, e)
-	}
-	if !strings.Contains(b.String(), "unauthorized request") {
-		t.Log(b.String())
-		t.Fatalf(`log should contain "unuathorized request"`)
+	u, _ := url.Parse("http://localhost:9000")
+	seedURL, _ := url.Parse(ts.URL)
+	if err := s.Join(u, seedURL); err != influxdb.ErrClusterMismatch {
+		t.Fatalf("unexpected error: %v", err)
+	} else if s.ID() != 0 {
+		t.Fatalf("unexpected id: %d", s.ID())
 	}
 }
 
-// Test user privilege authorization.
-func TestServer_UserPrivilegeAuthorization(t *testing.T) {
-	s := OpenServer(NewMessagingClient())
+// Ensure a server replaces its metastore when a majority of nodes disagree with it.
+func TestServer_VerifyMetastore(t *testing.T) {
+	// Create two remote nodes that report a digest and serve a metastore.
+	var index uint64
+	var digests [2]string
+	var good *Server
+	newNode := func(i int) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/metastore/digest":
+				fmt.Fprint(w, mustMarshalJSON(&influxdb.MetastoreDigest{Index: index, Digest: digests[i]}))
+			case "/metastore":
+				good.CopyMetastore(w)
+			}
+		}))
+	}
+	ts0, ts1 := newNode(0), newNode(1)
+	defer ts0.Close()
+	defer ts1.Close()
+
+	// Create a server with the same data nodes and a database that the local server missed.
+	c := NewMessagingClient()
+	s := OpenServer(c)
 	defer s.Close()
+	good = OpenServer(NewMessagingClient())
+	defer good.Close()
+	for _, ts := range []*httptest.Server{ts0, ts1} {
+		u, _ := url.Parse(ts.URL)
+		s.CreateDataNode(u)
+		good.CreateDataNode(u)
+	}
+	good.CreateDatabase("foo")
+	good.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour, ReplicaN: 3})
+	good.SetDefaultRetentionPolicy("foo", "raw")
+	if err := good.CreateShardGroupIfNotExists("foo", "raw", mustParseTime("2000-01-01T00:00:00Z")); err != nil {
+		t.Fatal(err)
+	}
+	want, _ := good.MetastoreDigest()
+	index = s.Index()
 
-	// Create cluster admin.
-	s.CreateUser("admin", "admin", true)
-	admin := s.User("admin")
+	// Nothing is replaced if no majority agrees on a digest.
+	digests = [2]string{want.Digest, "other"}
+	if ok, err := s.VerifyMetastore(); err != influxdb.ErrMetastoreDiverged || ok {
+		t.Fatalf("unexpected result: %v, %v", ok, err)
+	} else if s.DatabaseExists("foo") {
+		t.Fatal("unexpected database")
+	}
 
-	// Create normal database user.
-	s.CreateUser("user1", "user1", false)
-	user1 := s.User("user1")
-	user1.Privileges["foo"] = influxql.ReadPrivilege
+	// Replace the metastore once both nodes agree.
+	var topics []uint64
+	c.SubscribeFunc = func(replicaID, topicID uint64) error {
+		topics = append(topics, topicID)
+		return nil
+	}
+	digests = [2]string{want.Digest, want.Digest}
+	if ok, err := s.VerifyMetastore(); err != nil || !ok {
+		t.Fatalf("unexpected result: %v, %v", ok, err)
+	} else if !s.DatabaseExists("foo") {
+		t.Fatal("database not copied")
+	} else if s.ID() != 1 {
+		t.Fatalf("unexpected id: %d", s.ID())
+	} else if d, _ := s.MetastoreDigest(); d.Digest != want.Digest {
+		t.Fatalf("unexpected digest: %s", d.Digest)
+	}
 
-	s.Restart()
+	// The server owns the copied shard and receives its writes.
+	groups, _ := good.ShardGroups("foo")
+	if !reflect.DeepEqual(topics, []uint64{groups[0].Shards[0].ID}) {
+		t.Fatalf("unexpected subscriptions: %v", topics)
+	}
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(10)}}})
+	results := s.ExecuteQuery(MustParseQuery(`SELECT value FROM cpu`), "foo", nil)
+	if res := results.Results[0]; res.Err != nil {
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if s := mustMarshalJSON(res); s != `{"rows":[{"name":"cpu","columns":["time","value"],"values":[["2000-01-01T00:00:00Z",10]]}]}` {
+		t.Fatalf("unexpected row(0): %s", s)
+	}
 
-	// admin user should be authorized for all privileges.
-	if !admin.Authorize(influxql.AllPrivileges, "") {
-		t.Fatalf("cluster admin doesn't have influxql.AllPrivileges")
-	} else if !admin.Authorize(influxql.WritePrivilege, "") {
-		t.Fatalf("cluster admin doesn't have influxql.WritePrivilege")
+	// Nothing changes once the metastores match.
+	if ok, err := s.VerifyMetastore(); err != nil || ok {
+		t.Fatalf("unexpected result: %v, %v", ok, err)
 	}
+}
 
-	// Normal user with only read privilege on database foo.
-	if !user1.Authorize(influxql.ReadPrivilege, "foo") {
-		t.Fatalf("user1 doesn't have influxql.ReadPrivilege on foo")
-	} else if user1.Authorize(influxql.WritePrivilege, "foo") {
-		t.Fatalf("user1 has influxql.WritePrivilege on foo")
-	} else if user1.Authorize(influxql.ReadPrivilege, "bar") {
-		t.Fatalf("user1 has influxql.ReadPrivilege on bar")
-	} else if user1.Authorize(influxql.AllPrivileges, "") {
-		t.Fatalf("user1 is cluster admin")
+// Ensure the server records metrics for metastore transactions.
+func TestServer_MetastoreStats(t *testing.T) {
+	s := OpenDefaultServer(NewMessagingClient())
+	defer s.Close()
+	before := s.Stats()
+
+	// Each shard group creation only saves the new group.
+	s.MustWriteSeries("db", "raw", []influxdb.Point{{Name: "cpu", Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(1)}}})
+	s.MustWriteSeries("db", "raw", []influxdb.Point{{Name: "cpu", Timestamp: mustParseTime("2000-02-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(2)}}})
+
+	stats := s.Stats()
+	if n := stats.Get("metastoreSaveShardGroup") - before.Get("metastoreSaveShardGroup"); n != 2 {
+		t.Fatalf("unexpected shard group saves: %d", n)
+	} else if n := stats.Get("metastoreSaveDatabase") - before.Get("metastoreSaveDatabase"); n != 0 {
+		t.Fatalf("unexpected database saves: %d", n)
+	} else if n := stats.Get("metastoreBytesWritten"); n <= before.Get("metastoreBytesWritten") {
+		t.Fatalf("unexpected bytes written: %d", n)
+	} else if n := stats.Get("metastoreSize"); n <= 0 {
+		t.Fatalf("unexpected metastore size: %d", n)
+	}
+
+	// Every transaction lands in a single duration bucket.
+	for _, key := range []string{"metastoreView", "metastoreUpdate"} {
+		var n int64
+		for _, suffix := range []string{"Lt1ms", "Lt10ms", "Lt100ms", "Lt1s", "Ge1s"} {
+			n += stats.Get(key + suffix)
+		}
+		if count := stats.Get(key + "Count"); count == 0 || n != count {
+			t.Fatalf("%s: unexpected count: %d, buckets: %d", key, count, n)
+		} else if stats.Get(key+"Ns") <= 0 {
+			t.Fatalf("%s: unexpected duration: %d", key, stats.Get(key+"Ns"))
+		}
 	}
 }
 
-// Test single statement query authorization.
-func TestServer_SingleStatementQueryAuthorization(t *testing.T) {
-	s := OpenServer(NewMessagingClient())
+// Ensure the server can compact and snapshot the metastore.
+func TestServer_CompactMetastore(t *testing.T) {
+	s := OpenDefaultServer(NewMessagingClient())
 	defer s.Close()
+	s.CreateDatabase("foo")
 
-	// Create cluster admin.
-	s.CreateUser("admin", "admin", true)
-	admin := s.User("admin")
+	// Leave free pages behind by deleting a database with many series.
+	var points []influxdb.Point
+	for i := 0; i < 1000; i++ {
+		points = append(points, influxdb.Point{Name: "cpu", Tags: map[string]string{"host": fmt.Sprintf("server%d", i)}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(i)}})
+	}
+	s.MustWriteSeries("db", "raw", points)
+	s.DeleteDatabase("db")
+	u, _ := url.Parse("http://localhost:20000")
+	s.CreateDataNode(u)
+	s.DeleteDataNode(2)
+	want, _ := s.MetastoreDigest()
+
+	path := filepath.Join(s.Path(), "meta")
+	before, _ := os.Stat(path)
+	if err := s.CompactMetastore(); err != nil {
+		t.Fatal(err)
+	}
+	after, _ := os.Stat(path)
+	if after.Size() >= before.Size() {
+		t.Fatalf("metastore not compacted: %d >= %d", after.Size(), before.Size())
+	} else if d, _ := s.MetastoreDigest(); d.Digest != want.Digest {
+		t.Fatalf("unexpected digest: %s", d.Digest)
+	}
 
-	// Create normal database user.
-	s.CreateUser("user", "user", false)
-	user := s.User("user")
-	user.Privileges["foo"] = influxql.ReadPrivilege
+	// Bucket sequences are kept so ids are not reused.
+	if err := s.CreateDataNode(u); err != nil {
+		t.Fatal(err)
+	} else if n := s.DataNodeByURL(u); n.ID != 3 {
+		t.Fatalf("unexpected data node id: %d", n.ID)
+	}
 
-	s.Restart()
+	// A snapshot can be opened as a server's metastore.
+	dir := tempfile()
+	defer os.RemoveAll(dir)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatal(err)
+	} else if err := s.SnapshotMetastore(filepath.Join(dir, "meta")); err != nil {
+		t.Fatal(err)
+	}
+	other := NewServer()
+	defer other.Close()
+	if err := other.Server.Open(dir); err != nil {
+		t.Fatal(err)
+	} else if !other.DatabaseExists("foo") || other.DatabaseExists("db") {
+		t.Fatal("unexpected databases")
+	} else if other.ID() != s.ID() {
+		t.Fatalf("unexpected id: %d", other.ID())
+	}
+}
 
-	// Create a query that only cluster admins can run.
-	adminOnlyQuery := &influxql.Query{
-		Statements: []influxql.Statement{
-			&influxql.DropDatabaseStatement{Name: "foo"},
-		},
+// Ensure the server can export its metadata and import it into another cluster.
+func TestServer_ExportImportMetadata(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateDatabase("bar")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 24 * time.Hour, ReplicaN: 1})
+	s.CreateRetentionPolicy("bar", &influxdb.RetentionPolicy{Name: "1d"})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.SetDefaultRetentionPolicy("bar", "1d")
+	if err := s.CreateShardGroupIfNotExists("foo", "raw", mustParseTime("2000-01-01T00:00:00Z")); err != nil {
+		t.Fatal(err)
+	}
+	q := `CREATE CONTINUOUS QUERY myquery ON foo BEGIN SELECT count(value) INTO "bar"."1d".cpu FROM cpu GROUP BY time(1h) END`
+	stmt, _ := influxql.NewParser(strings.NewReader(q)).ParseStatement()
+	if err := s.CreateContinuousQuery(stmt.(*influxql.CreateContinuousQueryStatement)); err != nil {
+		t.Fatal(err)
 	}
+	s.SetContinuousQueryEnabled("foo", "myquery", false)
+	s.CreateUser("susy", "pass", false)
+	s.SetPrivilege(influxql.ReadPrivilege, "susy", "foo")
+	s.SetMeasurementPrivilege(influxql.WritePrivilege, "susy", "foo", "cpu", false)
 
-	// Create a query that requires read on one db and write on another.
-	readWriteQuery := &influxql.Query{
-		Statements: []influxql.Statement{
-			&influxql.CreateContinuousQueryStatement{
-				Name:     "myquery",
-				Database: "foo",
-				Source: &influxql.SelectStatement{
-					Fields: []*influxql.Field{{Expr: &influxql.Call{Name: "count"}}},
-					Target: &influxql.Target{Measurement: "measure1", Database: "bar"},
-					Source: &influxql.Measurement{Name: "myseries"},
-				},
-			},
-		},
+	var buf bytes.Buffer
+	if err := s.ExportMetadata(&buf); err != nil {
+		t.Fatal(err)
+	}
+	exported := buf.String()
+	if !strings.Contains(exported, `"disabled": true`) || !strings.Contains(exported, `"foo": "READ"`) {
+		t.Fatalf("unexpected export: %s", exported)
 	}
 
-	// admin user should be authorized to execute any query.
-	if err := s.Authorize(admin, adminOnlyQuery, ""); err != nil {
+	// Importing into an empty cluster reproduces the same metadata.
+	other := OpenServer(NewMessagingClient())
+	defer other.Close()
+	if err := other.ImportMetadata(strings.NewReader(exported)); err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	if err := other.ExportMetadata(&buf); err != nil {
 		t.Fatal(err)
+	} else if buf.String() != exported {
+		t.Fatalf("unexpected export:\n%s\nexpected:\n%s", buf.String(), exported)
+	} else if err := other.User("susy").Authenticate("pass"); err != nil {
+		t.Fatalf("unexpected authentication error: %s", err)
 	}
 
-	if err := s.Authorize(admin, readWriteQuery, "foo"); err != nil {
+	// Importing again leaves the existing metadata unchanged.
+	if err := other.ImportMetadata(strings.NewReader(exported)); err != nil {
 		t.Fatal(err)
 	}
 
-	// Normal user should not be authorized to execute admin only query.
-	if err := s.Authorize(user, adminOnlyQuery, ""); err == nil {
-		t.Fatalf("normal user should not be authorized to execute cluster admin level queries")
+	// Unknown document versions are rejected.
+	if err := other.ImportMetadata(strings.NewReader(`{"version":2}`)); err != influxdb.ErrMetadataVersion {
+		t.Fatalf("unexpected error: %v", err)
 	}
+}
 
-	// Normal user should not be authorized to execute query that selects into another
-	// database which (s)he doesn't have privileges on.
-	if err := s.Authorize(user, readWriteQuery, ""); err == nil {
-		t.Fatalf("normal user should not be authorized to write to database bar")
+// Ensure the server can store its metadata in an alternative backend.
+func TestServer_NewMetaStore(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.NewMetaStore = func() influxdb.MetaStore { return &MemMetaStore{} }
+	if err := s.Server.Open(tempfile()); err != nil {
+		t.Fatal(err)
+	} else if err := s.SetClient(NewMessagingClient()); err != nil {
+		t.Fatal(err)
+	} else if err := s.Initialize(&url.URL{Host: "127.0.0.1:8080"}); err != nil {
+		t.Fatal(err)
 	}
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.CreateUser("susy", "pass", true)
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"host": "servera"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": 1.0}}})
+	want, _ := s.MetastoreDigest()
 
-	// Grant normal user write privileges on database "bar".
-	user.Privileges["bar"] = influxql.WritePrivilege
+	// Compaction is skipped since the backend doesn't support it.
+	if err := s.CompactMetastore(); err != nil {
+		t.Fatal(err)
+	}
 
-	//Authorization on the previous query should now succeed.
-	if err := s.Authorize(user, readWriteQuery, ""); err != nil {
+	// Metadata is reloaded from the backend.
+	s.Restart()
+	if d, _ := s.MetastoreDigest(); d.Digest != want.Digest {
+		t.Fatalf("unexpected digest: %s", d.Digest)
+	} else if !s.DatabaseExists("foo") {
+		t.Fatal("database not found")
+	} else if u := s.User("susy"); u == nil || !u.Admin {
+		t.Fatalf("unexpected user: %#v", u)
+	} else if a := s.MeasurementNames("foo"); !reflect.DeepEqual(a, []string{"cpu"}) {
+		t.Fatalf("unexpected measurements: %v", a)
+	}
+
+	// The data file is written by the backend rather than bolt.
+	var root MemMetaBucket
+	if b, err := ioutil.ReadFile(filepath.Join(s.Path(), "meta")); err != nil {
 		t.Fatal(err)
+	} else if err := json.Unmarshal(b, &root); err != nil {
+		t.Fatalf("unexpected meta file: %s", err)
 	}
 }
 
-// Test multiple statement query authorization.
-func TestServer_MultiStatementQueryAuthorization(t *testing.T) {
-	s := OpenServer(NewMessagingClient())
+// Ensure the server saves retention policies, shard groups and continuous
+// queries under their own keys and migrates databases saved as a single value.
+func TestServer_MetastoreLayout(t *testing.T) {
+	store := &MemMetaStore{}
+	s := NewServer()
 	defer s.Close()
+	s.NewMetaStore = func() influxdb.MetaStore { return store }
+	if err := s.Server.Open(tempfile()); err != nil {
+		t.Fatal(err)
+	} else if err := s.SetClient(NewMessagingClient()); err != nil {
+		t.Fatal(err)
+	} else if err := s.Initialize(&url.URL{Host: "127.0.0.1:8080"}); err != nil {
+		t.Fatal(err)
+	}
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": 1.0}}})
+	for _, q := range []string{
+		`CREATE CONTINUOUS QUERY b ON foo BEGIN SELECT count(value) INTO cpu_b FROM cpu GROUP BY time(1h) END`,
+		`CREATE CONTINUOUS QUERY a ON foo BEGIN SELECT count(value) INTO cpu_a FROM cpu GROUP BY time(1h) END`,
+	} {
+		stmt, _ := influxql.NewParser(strings.NewReader(q)).ParseStatement()
+		if err := s.CreateContinuousQuery(stmt.(*influxql.CreateContinuousQueryStatement)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	groups, _ := s.ShardGroups("foo")
+
+	// Rewrite the database as a single value like older versions did.
+	store.mu.Lock()
+	db := store.root.Buckets["Databases"].Buckets["foo"]
+	rp := db.Buckets["Policies"].Buckets["raw"]
+	var policy map[string]interface{}
+	json.Unmarshal(rp.Values["meta"], &policy)
+	var shardGroups []json.RawMessage
+	for _, g := range groups {
+		shardGroups = append(shardGroups, rp.Buckets["ShardGroups"].Values[string(u64tob(g.ID))])
+	}
+	policy["shardGroups"] = shardGroups
+	var cqs []json.RawMessage
+	for _, seq := range []uint64{1, 2} {
+		cqs = append(cqs, db.Buckets["ContinuousQueries"].Values[string(u64tob(seq))])
+	}
+	db.Values["meta"], _ = json.Marshal(map[string]interface{}{
+		"name":                   "foo",
+		"defaultRetentionPolicy": "raw",
+		"policies":               []interface{}{policy},
+		"continuousQueries":      cqs,
+	})
+	delete(db.Buckets, "Policies")
+	delete(db.Buckets, "ContinuousQueries")
+	store.mu.Unlock()
+	s.Restart()
 
-	// Create cluster admin.
-	s.CreateUser("admin", "admin", true)
-	admin := s.User("admin")
+	// The database is migrated and loads as it was saved.
+	store.mu.Lock()
+	meta := string(store.root.Buckets["Databases"].Buckets["foo"].Values["meta"])
+	store.mu.Unlock()
+	if meta != `{"name":"foo","defaultRetentionPolicy":"raw"}` {
+		t.Fatalf("unexpected meta: %s", meta)
+	} else if other, _ := s.ShardGroups("foo"); mustMarshalJSON(other) != mustMarshalJSON(groups) {
+		t.Fatalf("unexpected shard groups: %s", mustMarshalJSON(other))
+	} else if rp, _ := s.DefaultRetentionPolicy("foo"); rp == nil || rp.Name != "raw" || rp.Duration != 1*time.Hour {
+		t.Fatalf("unexpected default retention policy: %#v", rp)
+	}
+	if a := s.ContinuousQueries("foo"); len(a) != 2 || !strings.Contains(a[0].Query, "cpu_b") || !strings.Contains(a[1].Query, "cpu_a") {
+		t.Fatalf("unexpected continuous queries: %s", mustMarshalJSON(a))
+	}
 
-	// Create normal database user.
-	s.CreateUser("user", "user", false)
-	user := s.User("user")
-	user.Privileges["foo"] = influxql.ReadPrivilege
+	// A renamed policy moves its shard groups and a dropped query is deleted.
+	name := "renamed"
+	if err := s.UpdateRetentionPolicy("foo", "raw", &influxdb.RetentionPolicyUpdate{Name: &name}); err != nil {
+		t.Fatal(err)
+	} else if err := s.DeleteContinuousQuery("foo", "b"); err != nil {
+		t.Fatal(err)
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	db = store.root.Buckets["Databases"].Buckets["foo"]
+	if _, ok := db.Buckets["Policies"].Buckets["raw"]; ok {
+		t.Fatal("renamed policy not deleted")
+	} else if rp := db.Buckets["Policies"].Buckets["renamed"]; rp == nil || len(rp.Buckets["ShardGroups"].Values) != len(groups) {
+		t.Fatalf("unexpected policy: %s", mustMarshalJSON(rp))
+	} else if a := db.Buckets["ContinuousQueries"].Values; len(a) != 1 || a[string(u64tob(2))] == nil {
+		t.Fatalf("unexpected continuous queries: %s", mustMarshalJSON(a))
+	}
+}
 
-	s.Restart()
+// Ensure the server can find and repair inconsistent metadata.
+func TestServer_VerifyMetadata(t *testing.T) {
+	store := &MemMetaStore{}
+	s := NewServer()
+	defer s.Close()
+	s.NewMetaStore = func() influxdb.MetaStore { return store }
+	if err := s.Server.Open(tempfile()); err != nil {
+		t.Fatal(err)
+	} else if err := s.SetClient(NewMessagingClient()); err != nil {
+		t.Fatal(err)
+	} else if err := s.Initialize(&url.URL{Host: "127.0.0.1:8080"}); err != nil {
+		t.Fatal(err)
+	}
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "other", Duration: 1 * time.Hour})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{
+		{Name: "cpu", Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": 1.0}},
+		{Name: "mem", Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": 1.0}},
+	})
+	if r, err := s.VerifyMetadata(); err != nil {
+		t.Fatal(err)
+	} else if !r.OK() {
+		t.Fatalf("unexpected problems: %s", mustMarshalJSON(r))
+	}
 
-	// Create a query that requires read for one statement and write for the second.
-	readWriteQuery := &influxql.Query{
-		Statements: []influxql.Statement{
-			// Statement that requires read.
-			&influxql.SelectStatement{
-				Fields: []*influxql.Field{{Expr: &influxql.Call{Name: "count"}}},
-				Source: &influxql.Measurement{Name: "cpu"},
-			},
+	// Leave the default retention policy dangling and a shard file behind.
+	s.SetDefaultRetentionPolicy("foo", "other")
+	s.DeleteRetentionPolicy("foo", "other")
+	path := filepath.Join(s.Path(), "shards", "1000")
+	if err := ioutil.WriteFile(path, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
 
-			// Statement that requires write.
-			&influxql.SelectStatement{
-				Fields: []*influxql.Field{{Expr: &influxql.Call{Name: "count"}}},
-				Source: &influxql.Measurement{Name: "cpu"},
-				Target: &influxql.Target{Measurement: "tmp"},
-			},
-		},
+	// Drop the "mem" measurement and add the shard to a second group.
+	groups, _ := s.ShardGroups("foo")
+	shardID := groups[0].Shards[0].ID
+	store.mu.Lock()
+	db := store.root.Buckets["Databases"].Buckets["foo"]
+	delete(db.Buckets["Measurements"].Values, "mem")
+	shardGroups := db.Buckets["Policies"].Buckets["raw"].Buckets["ShardGroups"]
+	var g map[string]interface{}
+	json.Unmarshal(shardGroups.Values[string(u64tob(groups[0].ID))], &g)
+	shardGroups.Values[string(u64tob(100))], _ = json.Marshal(map[string]interface{}{
+		"id": 100, "startTime": g["startTime"], "endTime": g["endTime"],
+		"shards": []interface{}{map[string]interface{}{"id": shardID, "nodeIDs": []uint64{2}}},
+	})
+	store.mu.Unlock()
+	s.Restart()
+
+	// Verify the problems are reported.
+	r, err := s.VerifyMetadata()
+	if err != nil {
+		t.Fatal(err)
+	} else if len(r.Problems) != 4 {
+		t.Fatalf("unexpected problems: %s", mustMarshalJSON(r))
+	} else if p := r.Problems[0]; p.Type != influxdb.SharedShardProblem || p.ShardID != shardID || !reflect.DeepEqual(p.ShardGroupIDs, []uint64{groups[0].ID, 100}) {
+		t.Fatalf("unexpected problem(0): %s", mustMarshalJSON(p))
+	} else if p := r.Problems[1]; p.Type != influxdb.OrphanedShardFileProblem || p.Path != path {
+		t.Fatalf("unexpected problem(1): %s", mustMarshalJSON(p))
+	} else if p := r.Problems[2]; p.Type != influxdb.OrphanedSeriesProblem || p.Database != "foo" || p.Measurement != "mem" || p.SeriesN != 1 {
+		t.Fatalf("unexpected problem(2): %s", mustMarshalJSON(p))
+	} else if p := r.Problems[3]; p.Type != influxdb.MissingDefaultRetentionPolicyProblem || p.DefaultRetentionPolicy != "other" {
+		t.Fatalf("unexpected problem(3): %s", mustMarshalJSON(p))
+	}
+
+	// Repair the problems and verify they stay repaired after a restart.
+	if r, err := s.RepairMetadata(); err != nil {
+		t.Fatal(err)
+	} else if !r.Repaired || len(r.Problems) != 4 {
+		t.Fatalf("unexpected report: %s", mustMarshalJSON(r))
+	} else if a := s.MeasurementNames("foo"); !reflect.DeepEqual(a, []string{"cpu"}) {
+		t.Fatalf("unexpected measurements: %v", a)
 	}
+	s.Restart()
+	if r, err := s.VerifyMetadata(); err != nil {
+		t.Fatal(err)
+	} else if !r.OK() {
+		t.Fatalf("unexpected problems: %s", mustMarshalJSON(r))
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("shard file not removed: %v", err)
+	} else if a := s.MeasurementNames("foo"); !reflect.DeepEqual(a, []string{"cpu"}) {
+		t.Fatalf("unexpected measurements: %v", a)
+	} else if groups, _ := s.ShardGroups("foo"); len(groups) != 1 {
+		t.Fatalf("unexpected shard group count: %d", len(groups))
+	} else if rp, _ := s.DefaultRetentionPolicy("foo"); rp != nil {
+		t.Fatalf("unexpected default retention policy: %s", rp.Name)
+	}
+}
 
-	// Admin should be authorized to execute both statements in the query.
-	if err := s.Authorize(admin, readWriteQuery, "foo"); err != nil {
+// Ensure the server can snapshot every data node at the same broker index.
+func TestServer_CreateClusterSnapshot(t *testing.T) {
+	s := OpenDefaultServer(NewMessagingClient())
+	defer s.Close()
+	s.SnapshotPath = tempfile()
+	defer os.RemoveAll(s.SnapshotPath)
+	s.MustWriteSeries("db", "raw", []influxdb.Point{{Name: "cpu", Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(10)}}})
+
+	// Create a remote node that reports its snapshot once it reaches the index.
+	var index string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		index = r.URL.Query().Get("index")
+		fmt.Fprint(w, mustMarshalJSON(&influxdb.DataNodeSnapshot{Path: "/snapshots/x", Files: []*influxdb.SnapshotFile{{Name: "meta"}}}))
+	}))
+	defer ts.Close()
+	u, _ := url.Parse(ts.URL)
+	s.CreateDataNode(u)
+
+	m, err := s.CreateClusterSnapshot()
+	if err != nil {
 		t.Fatal(err)
+	} else if index != fmt.Sprint(m.Index) {
+		t.Fatalf("unexpected index: %s", index)
+	} else if len(m.DataNodes) != 2 {
+		t.Fatalf("unexpected data node count: %d", len(m.DataNodes))
+	} else if n := m.DataNodes[1]; n.ID != 2 || n.URL != ts.URL || n.Path != "/snapshots/x" {
+		t.Fatalf("unexpected remote snapshot: %#v", n)
+	}
+
+	// The local node copies the metastore and its shard.
+	local := m.DataNodes[0]
+	if local.ID != 1 || local.Index != m.Index || len(local.Files) != 2 {
+		t.Fatalf("unexpected local snapshot: %#v", local)
+	} else if local.Files[0].Name != "meta" || local.Files[1].ShardID == 0 {
+		t.Fatalf("unexpected files: %#v, %#v", local.Files[0], local.Files[1])
+	}
+	for _, f := range local.Files {
+		b, err := ioutil.ReadFile(filepath.Join(local.Path, f.Name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sum := sha256.Sum256(b); hex.EncodeToString(sum[:]) != f.Checksum || int64(len(b)) != f.Size {
+			t.Fatalf("unexpected checksum: %s", f.Name)
+		}
 	}
 
-	// Normal user with only read privileges should not be authorized to execute both statements.
-	if err := s.Authorize(user, readWriteQuery, "foo"); err == nil {
-		t.Fatalf("user should not be authorized to execute both statements")
+	// The cluster manifest is stored with the local snapshot.
+	if _, err := os.Stat(filepath.Join(local.Path, "cluster.json")); err != nil {
+		t.Fatal(err)
 	}
 }
 
-// Ensure the server can create a database.
-func TestServer_CreateDatabase(t *testing.T) {
+// Ensure the server places the replicas of new shards in different zones.
+func TestServer_SetDataNodeZone(t *testing.T) {
 	s := OpenServer(NewMessagingClient())
 	defer s.Close()
 
-	// Create the "foo" database.
-	if err := s.CreateDatabase("foo"); err != nil {
-		t.Fatal(err)
+	// Create three nodes where the first two share a zone.
+	for _, rawurl := range []string{"http://localhost:8001", "http://localhost:8002"} {
+		u, _ := url.Parse(rawurl)
+		if err := s.CreateDataNode(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	nodes := s.DataNodes()
+	for i, zone := range []string{"a", "a", "b"} {
+		if err := s.SetDataNodeZone(nodes[i].ID, zone); err != nil {
+			t.Fatal(err)
+		}
 	}
 	s.Restart()
+	if n := s.DataNode(nodes[2].ID); n.Zone != "b" {
+		t.Fatalf("unexpected zone: %q", n.Zone)
+	} else if err := s.SetDataNodeZone(1000, "c"); err != influxdb.ErrDataNodeNotFound {
+		t.Fatalf("unexpected error: %v", err)
+	}
 
-	// Verify that the database exists.
-	if !s.DatabaseExists("foo") {
-		t.Fatalf("database not found")
+	// Every shard should be replicated to both zones.
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour, ReplicaN: 2})
+	for i := 0; i < 3; i++ {
+		if err := s.CreateShardGroupIfNotExists("foo", "raw", mustParseTime("2000-01-01T00:00:00Z").Add(time.Duration(i)*time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	groups, _ := s.ShardGroups("foo")
+	for _, g := range groups {
+		for _, sh := range g.Shards {
+			if !sh.HasDataNodeID(nodes[2].ID) {
+				t.Fatalf("replica missing from zone: shard=%d, owners=%v", sh.ID, sh.DataNodeIDs)
+			}
+		}
 	}
 }
 
-// Ensure the server returns an error when creating a duplicate database.
-func TestServer_CreateDatabase_ErrDatabaseExists(t *testing.T) {
+// Ensure the server can delete a node.
+func TestServer_DeleteDataNode(t *testing.T) {
 	s := OpenServer(NewMessagingClient())
 	defer s.Close()
 
-	// Create the "foo" database twice.
-	if err := s.CreateDatabase("foo"); err != nil {
+	// Create a data node and verify it exists.
+	u, _ := url.Parse("http://localhost:80000")
+	if err := s.CreateDataNode(u); err != nil {
 		t.Fatal(err)
+	} else if s.DataNodeByURL(u) == nil {
+		t.Fatalf("data node not actually created")
 	}
-	if err := s.CreateDatabase("foo"); err != influxdb.ErrDatabaseExists {
+	s.Restart()
+
+	// Drop the node and verify that it's gone.
+	n := s.DataNodeByURL(u)
+	if err := s.DeleteDataNode(n.ID); err != nil {
 		t.Fatal(err)
+	} else if s.DataNode(n.ID) != nil {
+		t.Fatalf("data node not actually dropped")
 	}
 }
 
-// Ensure the server can drop a database.
-func TestServer_DropDatabase(t *testing.T) {
-	s := OpenServer(NewMessagingClient())
-	defer s.Close()
+// Ensure node requests are verified against the shared secret.
+func TestVerifyNodeRequest(t *testing.T) {
+	secret := []byte("secret")
+	now := mustParseTime("2000-01-01T00:00:00Z")
+	newRequest := func(body string) *http.Request {
+		req, _ := http.NewRequest("POST", "http://localhost/data_nodes", strings.NewReader(body))
+		if err := influxdb.SignNodeRequest(req, secret, now); err != nil {
+			t.Fatal(err)
+		}
+		return req
+	}
 
-	// Create the "foo" database and verify it exists.
-	if err := s.CreateDatabase("foo"); err != nil {
-		t.Fatal(err)
-	} else if !s.DatabaseExists("foo") {
-		t.Fatalf("database not actually created")
+	// Signed request.
+	req := newRequest(`{"url":"http://localhost:8086"}`)
+	if err := influxdb.VerifyNodeRequest(req, secret, now.Add(time.Minute)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if b, _ := ioutil.ReadAll(req.Body); string(b) != `{"url":"http://localhost:8086"}` {
+		t.Fatalf("unexpected body: %s", b)
 	}
-	s.Restart()
 
-	// Drop the "foo" database and verify that it's gone.
-	if err := s.DeleteDatabase("foo"); err != nil {
+	// Wrong secret.
+	if err := influxdb.VerifyNodeRequest(newRequest(""), []byte("other"), now); err != influxdb.ErrInvalidNodeSignature {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// Expired request.
+	if err := influxdb.VerifyNodeRequest(newRequest(""), secret, now.Add(influxdb.MaxNodeRequestSkew+time.Second)); err != influxdb.ErrInvalidNodeSignature {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// Modified body is rejected once it's read.
+	req = newRequest(`{"url":"http://localhost:8086"}`)
+	req.Body = ioutil.NopCloser(strings.NewReader(`{"url":"http://evil:8086"}`))
+	if err := influxdb.VerifyNodeRequest(req, secret, now); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if _, err := ioutil.ReadAll(req.Body); err != influxdb.ErrInvalidNodeSignature {
+		t.Fatalf("unexpected read error: %v", err)
+	}
+
+	// Streamed body is spooled while it's signed.
+	req, _ = http.NewRequest("POST", "http://localhost/data_nodes", nil)
+	req.Body = ioutil.NopCloser(strings.NewReader(`{"url":"http://localhost:8086"}`))
+	if err := influxdb.SignNodeRequest(req, secret, now); err != nil {
 		t.Fatal(err)
-	} else if s.DatabaseExists("foo") {
-		t.Fatalf("database not actually dropped")
+	} else if err := influxdb.VerifyNodeRequest(req, secret, now); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if b, err := ioutil.ReadAll(req.Body); err != nil || string(b) != `{"url":"http://localhost:8086"}` {
+		t.Fatalf("unexpected body: %s, %v", b, err)
+	}
+	req.Body.Close()
+
+	// Unsigned request.
+	req, _ = http.NewRequest("GET", "http://localhost/metastore", nil)
+	if err := influxdb.VerifyNodeRequest(req, secret, now); err != influxdb.ErrInvalidNodeSignature {
+		t.Fatalf("unexpected error: %s", err)
 	}
 }
 
-// Ensure the server returns an error when dropping a database that doesn't exist.
-func TestServer_DropDatabase_ErrDatabaseNotFound(t *testing.T) {
+// Ensure a read-only server rejects changes but still serves queries.
+func TestServer_SetReadOnly(t *testing.T) {
 	s := OpenServer(NewMessagingClient())
 	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(20)}}})
 
-	// Drop a database that doesn't exist.
-	if err := s.DeleteDatabase("no_such_db"); err != influxdb.ErrDatabaseNotFound {
+	s.SetReadOnly(true)
+	if !s.ReadOnly() {
+		t.Fatal("expected read-only")
+	}
+
+	// Writes, metadata changes & continuous queries are rejected.
+	if _, err := s.WriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Timestamp: mustParseTime("2000-01-01T00:00:10Z"), Values: map[string]interface{}{"value": float64(30)}}}); err != influxdb.ErrReadOnly {
+		t.Fatalf("unexpected write error: %v", err)
+	} else if err := s.CreateDatabase("bar"); err != influxdb.ErrReadOnly {
+		t.Fatalf("unexpected create database error: %v", err)
+	} else if res := s.ExecuteQuery(MustParseQuery(`CREATE USER susy WITH PASSWORD 'pass'`), "foo", nil); res.Error() != influxdb.ErrReadOnly {
+		t.Fatalf("unexpected create user error: %v", res.Error())
+	} else if err := s.RunContinuousQueries(); err != influxdb.ErrReadOnly {
+		t.Fatalf("unexpected continuous query error: %v", err)
+	}
+
+	// Queries are still served.
+	if res := s.ExecuteQuery(MustParseQuery(`SELECT value FROM cpu`), "foo", nil); res.Error() != nil {
+		t.Fatalf("unexpected query error: %s", res.Error())
+	} else if s := mustMarshalJSON(res); s != `{"results":[{"rows":[{"name":"cpu","columns":["time","value"],"values":[["2000-01-01T00:00:00Z",20]]}]}]}` {
+		t.Fatalf("unexpected results: %s", s)
+	}
+
+	// Changes are accepted again once read-only mode is turned off.
+	s.SetReadOnly(false)
+	if err := s.CreateDatabase("bar"); err != nil {
 		t.Fatal(err)
 	}
 }
 
-// Ensure the server can return a list of all databases.
-func TestServer_Databases(t *testing.T) {
+// Test unuathorized requests logging
+func TestServer_UnauthorizedRequests(t *testing.T) {
 	s := OpenServer(NewMessagingClient())
 	defer s.Close()
 
-	// Create some databases.
-	s.CreateDatabase("foo")
-	s.CreateDatabase("bar")
-	s.Restart()
+	s.SetAuthenticationEnabled(true)
 
-	// Return the databases.
-	if a := s.Databases(); len(a) != 2 {
-		t.Fatalf("unexpected db count: %d", len(a))
-	} else if a[0] != "bar" {
-		t.Fatalf("unexpected db(0): %s", a[0])
-	} else if a[1] != "foo" {
-		t.Fatalf("unexpected db(1): %s", a[1])
+	var b bytes.Buffer
+	s.SetLogOutput(&b)
+
+	adminOnlyQuery := &influxql.Query{
+		Statements: []influxql.Statement{
+			&influxql.DropDatabaseStatement{Name: "foo"},
+		},
+	}
+
+	e := s.Authorize(nil, adminOnlyQuery, "foo")
+	if _, ok := e.(influxdb.ErrAuthorize); !ok {
+		t.Fatalf("unexpected error.  expected %v, actual: %v", influxdb.ErrAuthorize{}, e)
+	}
+	if !strings.Contains(b.String(), "unauthorized request") {
+		t.Log(b.String())
+		t.Fatalf(`log should contain "unuathorized request"`)
+	}
+
+	b.Reset()
+
+	// Create normal database user.
+	s.CreateUser("user1", "user1", false)
+	user1 := s.User("user1")
+
+	e = s.Authorize(user1, adminOnlyQuery, "foo")
+	if _, ok := e.(influxdb.ErrAuthorize); !ok {
+		t.Fatalf("unexpected error.  expected %v, actual: %v", influxdb.ErrAuthorize{}, e)
+	}
+	if !strings.Contains(b.String(), "unauthorized request") {
+		t.Log(b.String())
+		t.Fatalf(`log should contain "unuathorized request"`)
 	}
 }
 
-// Ensure the server can create a new user.
-func TestServer_CreateUser(t *testing.T) {
+// Test user privilege authorization.
+func TestServer_UserPrivilegeAuthorization(t *testing.T) {
 	s := OpenServer(NewMessagingClient())
 	defer s.Close()
 
-	// Create a user.
-	if err := s.CreateUser("susy", "pass", true); err != nil {
-		t.Fatal(err)
-	}
+	// Create cluster admin.
+	s.CreateUser("admin", "admin", true)
+	admin := s.User("admin")
+
+	// Create normal database user.
+	s.CreateUser("user1", "user1", false)
+	user1 := s.User("user1")
+	user1.Privileges["foo"] = influxql.ReadPrivilege
+
 	s.Restart()
 
-	// Verify that the user exists.
-	if u := s.User("susy"); u == nil {
-		t.Fatalf("user not found")
-	} else if u.Name != "susy" {
-		t.Fatalf("username mismatch: %v", u.Name)
-	} else if !u.Admin {
-		t.Fatalf("admin mismatch: %v", u.Admin)
-	} else if bcrypt.CompareHashAndPassword([]byte(u.Hash), []byte("pass")) != nil {
-		t.Fatal("invalid password")
+	// admin user should be authorized for all privileges.
+	if !admin.Authorize(influxql.AllPrivileges, "") {
+		t.Fatalf("cluster admin doesn't have influxql.AllPrivileges")
+	} else if !admin.Authorize(influxql.WritePrivilege, "") {
+		t.Fatalf("cluster admin doesn't have influxql.WritePrivilege")
 	}
 
-	// Verify that the authenticated user exists.
-	u, err := s.Authenticate("susy", "pass")
-	if err != nil {
-		t.Fatalf("error fetching authenticated user")
-	} else if u.Name != "susy" {
-		t.Fatalf("username mismatch: %v", u.Name)
+	// Normal user with only read privilege on database foo.
+	if !user1.Authorize(influxql.ReadPrivilege, "foo") {
+		t.Fatalf("user1 doesn't have influxql.ReadPrivilege on foo")
+	} else if user1.Authorize(influxql.WritePrivilege, "foo") {
+		t.Fatalf("user1 has influxql.WritePrivilege on foo")
+	} else if user1.Authorize(influxql.ReadPrivilege, "bar") {
+		t.Fatalf("user1 has influxql.ReadPrivilege on bar")
+	} else if user1.Authorize(influxql.AllPrivileges, "") {
+		t.Fatalf("user1 is cluster admin")
+	}
+}
+
+// Ensure cached authentications are invalidated when a user changes.
+func TestServer_Authenticate_Cache(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.AuthenticationCacheTTL = time.Minute
+	s.CreateUser("susy", "pass", false)
+	s.CreateUser("bob", "pass", false)
+
+	// Authenticate twice to use the cache.
+	for i := 0; i < 2; i++ {
+		if _, err := s.Authenticate("susy", "pass"); err != nil {
+			t.Fatalf("%d. unexpected error: %s", i, err)
+		} else if _, err := s.Authenticate("susy", "wrong"); err == nil {
+			t.Fatalf("%d. expected error for wrong password", i)
+		}
+	}
+
+	// Ensure the old password stops working after it is changed.
+	if err := s.UpdateUser("susy", "newpass"); err != nil {
+		t.Fatal(err)
+	} else if _, err := s.Authenticate("susy", "pass"); err == nil {
+		t.Fatal("expected error for old password")
+	} else if _, err := s.Authenticate("susy", "newpass"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// Ensure a deleted user can't authenticate.
+	s.Authenticate("bob", "pass")
+	if err := s.DeleteUser("bob"); err != nil {
+		t.Fatal(err)
+	} else if u, _ := s.Authenticate("bob", "pass"); u != nil {
+		t.Fatal("unexpected deleted user")
+	}
+}
+
+// Ensure users are locked out after too many failed authentication attempts.
+func TestServer_Authenticate_Lockout(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.MaxAuthenticationFailures = 3
+	s.AuthenticationLockout = time.Minute
+	s.CreateUser("susy", "pass", false)
+
+	// A successful attempt resets the failures.
+	s.Authenticate("susy", "wrong")
+	s.Authenticate("susy", "wrong")
+	if _, err := s.Authenticate("susy", "pass"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// Fail enough times to lock the user out.
+	for i := 0; i < 3; i++ {
+		if _, err := s.Authenticate("susy", "wrong"); err == nil || err == influxdb.ErrUserLocked {
+			t.Fatalf("%d. unexpected error: %v", i, err)
+		}
+	}
+	if _, err := s.Authenticate("susy", "pass"); err != influxdb.ErrUserLocked {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Unlock the user.
+	if err := s.UnlockUser("susy"); err != nil {
+		t.Fatal(err)
+	} else if _, err := s.Authenticate("susy", "pass"); err != nil {
+		t.Fatalf("unexpected error after unlock: %s", err)
+	} else if err := s.UnlockUser("no_such_user"); err != influxdb.ErrUserNotFound {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure passwords can be verified by an external authenticator.
+func TestServer_Authenticate_Authenticator(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateUser("susy", "pass", false)
+	s.Authenticator = influxdb.AuthenticatorFunc(func(username, password string) error {
+		if username != "susy" || password != "ldappass" {
+			return errors.New("rejected")
+		}
+		return nil
+	})
+
+	// The external password is used instead of the stored one.
+	if u, err := s.Authenticate("susy", "ldappass"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if u != s.User("susy") {
+		t.Fatalf("unexpected user: %#v", u)
+	} else if _, err := s.Authenticate("susy", "pass"); err == nil {
+		t.Fatal("expected error for stored password")
+	}
+
+	// Users must exist on the server.
+	s.Authenticator = influxdb.AuthenticatorFunc(func(username, password string) error { return nil })
+	s.SetAuthenticationEnabled(true)
+	if _, err := s.Authenticate("bob", "pass"); err == nil {
+		t.Fatal("expected error for unknown user")
+	}
+}
+
+// Ensure the root user can be bootstrapped and its password reset.
+func TestServer_EnsureAdminUser(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.SetAuthenticationEnabled(true)
+	s.CreateUser("susy", "pass", false)
+
+	// The root user is created when there are no admins.
+	if err := s.EnsureAdminUser(); err != nil {
+		t.Fatal(err)
+	} else if u, err := s.Authenticate(influxdb.RootUsername, influxdb.DefaultRootPassword); err != nil {
+		t.Fatalf("unexpected error: %s", err)
 	} else if !u.Admin {
-		t.Fatalf("admin mismatch: %v", u.Admin)
-	} else if bcrypt.CompareHashAndPassword([]byte(u.Hash), []byte("pass")) != nil {
-		t.Fatal("invalid password")
+		t.Fatal("expected root to be an admin")
+	}
+
+	// An existing admin is left alone.
+	s.UpdateUser(influxdb.RootUsername, "secret")
+	if err := s.EnsureAdminUser(); err != nil {
+		t.Fatal(err)
+	} else if _, err := s.Authenticate(influxdb.RootUsername, "secret"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// Resetting restores the default password, even after a restart.
+	if err := s.ResetRootPassword(); err != nil {
+		t.Fatal(err)
+	}
+	s.Restart()
+	s.SetAuthenticationEnabled(true)
+	if _, err := s.Authenticate(influxdb.RootUsername, "secret"); err == nil {
+		t.Fatal("expected error for old password")
+	} else if u, err := s.Authenticate(influxdb.RootUsername, influxdb.DefaultRootPassword); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if !u.Admin {
+		t.Fatal("expected root to be an admin")
+	}
+}
+
+// Ensure passwords are rehashed on login when the hasher's settings change.
+func TestServer_Authenticate_Rehash(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.PasswordHasher = &influxdb.BcryptHasher{Cost: bcrypt.MinCost}
+	s.CreateUser("susy", "pass", false)
+	if cost, _ := bcrypt.Cost([]byte(s.User("susy").Hash)); cost != bcrypt.MinCost {
+		t.Fatalf("unexpected cost: %d", cost)
+	}
+
+	// Increase the cost and login.
+	s.PasswordHasher = &influxdb.BcryptHasher{Cost: bcrypt.MinCost + 1}
+	if _, err := s.Authenticate("susy", "wrong"); err == nil {
+		t.Fatal("expected error for wrong password")
+	} else if cost, _ := bcrypt.Cost([]byte(s.User("susy").Hash)); cost != bcrypt.MinCost {
+		t.Fatalf("unexpected rehash after failed login: %d", cost)
+	}
+	if _, err := s.Authenticate("susy", "pass"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if cost, _ := bcrypt.Cost([]byte(s.User("susy").Hash)); cost != bcrypt.MinCost+1 {
+		t.Fatalf("unexpected cost after login: %d", cost)
+	} else if _, err := s.Authenticate("susy", "pass"); err != nil {
+		t.Fatalf("unexpected error after rehash: %s", err)
+	}
+
+	// A server with a lower cost doesn't replace the stronger hash.
+	hash := s.User("susy").Hash
+	s.PasswordHasher = &influxdb.BcryptHasher{Cost: bcrypt.MinCost}
+	if _, err := s.Authenticate("susy", "pass"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if s.User("susy").Hash != hash {
+		t.Fatal("unexpected rehash with a lower cost")
+	}
+}
+
+// Ensure the server authenticates users with signed bearer tokens.
+func TestServer_AuthenticateToken(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateUser("admin", "admin", true)
+	s.CreateUser("user1", "user1", false)
+	s.User("user1").Privileges["foo"] = influxql.ReadPrivilege
+
+	// Tokens require a secret.
+	if _, err := s.CreateToken("admin", time.Time{}, nil); err != influxdb.ErrTokenSecretRequired {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	s.TokenSecret = []byte("secret")
+
+	// Authenticate with an unrestricted token.
+	token, err := s.CreateToken("user1", time.Now().Add(time.Hour), nil)
+	if err != nil {
+		t.Fatal(err)
+	} else if u, err := s.AuthenticateToken(token); err != nil {
+		t.Fatal(err)
+	} else if u != s.User("user1") {
+		t.Fatalf("unexpected user: %#v", u)
+	}
+
+	// Tokens limited to databases only grant privileges on those databases.
+	token, _ = s.CreateToken("admin", time.Time{}, []string{"bar"})
+	if u, err := s.AuthenticateToken(token); err != nil {
+		t.Fatal(err)
+	} else if !u.Authorize(influxql.AllPrivileges, "bar") {
+		t.Fatal("expected privileges on bar")
+	} else if u.Authorize(influxql.ReadPrivilege, "foo") || u.Admin {
+		t.Fatal("unexpected privileges outside of bar")
+	}
+
+	// Reject expired, forged and unknown user tokens.
+	token, _ = s.CreateToken("user1", time.Now().Add(-time.Second), nil)
+	if _, err := s.AuthenticateToken(token); err != influxdb.ErrTokenExpired {
+		t.Fatalf("unexpected expired token error: %s", err)
+	}
+	token, _ = influxdb.SignToken(&influxdb.TokenClaims{Username: "admin"}, []byte("other"))
+	if _, err := s.AuthenticateToken(token); err != influxdb.ErrInvalidToken {
+		t.Fatalf("unexpected forged token error: %s", err)
+	}
+	token, _ = influxdb.SignToken(&influxdb.TokenClaims{Username: "nobody"}, []byte("secret"))
+	if _, err := s.AuthenticateToken(token); err != influxdb.ErrInvalidToken {
+		t.Fatalf("unexpected unknown user error: %s", err)
+	}
+	if _, err := s.AuthenticateToken("foo.bar"); err != influxdb.ErrInvalidToken {
+		t.Fatalf("unexpected malformed token error: %s", err)
+	}
+}
+
+// Ensure API tokens can be created, listed and revoked.
+func TestServer_APITokens(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateUser("susy", "pass", false)
+	s.CreateUser("bob", "pass", false)
+	s.SetPrivilege(influxql.ReadPrivilege, "susy", "foo")
+	s.SetPrivilege(influxql.AllPrivileges, "susy", "bar")
+
+	// Tokens only grant the privileges of the token that the user also has.
+	token, err := s.CreateAPIToken("susy", map[string]influxql.Privilege{"foo": influxql.AllPrivileges, "baz": influxql.ReadPrivilege}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	} else if _, err := s.CreateAPIToken("nobody", nil, time.Time{}); err != influxdb.ErrUserNotFound {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	s.Restart()
+	if u, err := s.AuthenticateToken(token); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if u.Name != "susy" || !reflect.DeepEqual(u.Privileges, map[string]influxql.Privilege{"foo": influxql.ReadPrivilege}) {
+		t.Fatalf("unexpected user: %#v", u)
+	} else if _, err := s.AuthenticateToken(token + "0"); err != influxdb.ErrInvalidToken {
+		t.Fatalf("unexpected wrong secret error: %v", err)
+	}
+
+	// Expired tokens are rejected.
+	expired, _ := s.CreateAPIToken("susy", nil, time.Now().Add(-time.Second))
+	if _, err := s.AuthenticateToken(expired); err != influxdb.ErrTokenExpired {
+		t.Fatalf("unexpected expired token error: %v", err)
+	}
+
+	// List tokens.
+	s.CreateAPIToken("bob", nil, time.Time{})
+	if a := s.APITokens("susy"); len(a) != 2 {
+		t.Fatalf("unexpected susy token count: %d", len(a))
+	} else if a := s.APITokens(""); len(a) != 3 {
+		t.Fatalf("unexpected token count: %d", len(a))
+	}
+
+	// Revoke a token.
+	id := strings.Split(token, ".")[0]
+	if err := s.RevokeAPIToken(id); err != nil {
+		t.Fatal(err)
+	} else if err := s.RevokeAPIToken(id); err != influxdb.ErrAPITokenNotFound {
+		t.Fatalf("unexpected error: %v", err)
+	} else if _, err := s.AuthenticateToken(token); err != influxdb.ErrInvalidToken {
+		t.Fatalf("unexpected revoked token error: %v", err)
+	}
+
+	// Deleting a user removes their tokens.
+	s.DeleteUser("bob")
+	s.Restart()
+	if a := s.APITokens(""); len(a) != 1 || a[0].Username != "susy" {
+		t.Fatalf("unexpected tokens: %v", a)
+	}
+}
+
+// Ensure users are authorized through the privileges of their roles.
+func TestServer_Roles(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateUser("susy", "pass", false)
+
+	// Create a role that can read foo.
+	if err := s.CreateRole("readers"); err != nil {
+		t.Fatal(err)
+	} else if err := s.CreateRole("readers"); err != influxdb.ErrRoleExists {
+		t.Fatalf("unexpected error: %s", err)
+	} else if err := s.SetRolePrivilege(influxql.ReadPrivilege, "readers", "foo"); err != nil {
+		t.Fatal(err)
+	}
+	if s.User("susy").Authorize(influxql.ReadPrivilege, "foo") {
+		t.Fatal("unexpected privilege before grant")
+	}
+
+	// Grant the role and ensure it's used after a restart.
+	if err := s.GrantRole("readers", "susy"); err != nil {
+		t.Fatal(err)
+	} else if err := s.GrantRole("no_such_role", "susy"); err != influxdb.ErrRoleNotFound {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	s.Restart()
+	if u := s.User("susy"); !reflect.DeepEqual(u.Roles, []string{"readers"}) {
+		t.Fatalf("unexpected roles: %v", u.Roles)
+	} else if !u.Authorize(influxql.ReadPrivilege, "foo") {
+		t.Fatal("expected read privilege on foo")
+	} else if u.Authorize(influxql.WritePrivilege, "foo") || u.Authorize(influxql.ReadPrivilege, "bar") {
+		t.Fatal("unexpected privilege")
+	}
+
+	// Changes to the role apply to its members.
+	if err := s.SetRolePrivilege(influxql.AllPrivileges, "readers", "foo"); err != nil {
+		t.Fatal(err)
+	} else if !s.User("susy").Authorize(influxql.WritePrivilege, "foo") {
+		t.Fatal("expected write privilege on foo")
+	}
+
+	// Revoke the role.
+	if err := s.RevokeRole("readers", "susy"); err != nil {
+		t.Fatal(err)
+	} else if u := s.User("susy"); len(u.Roles) != 0 || u.Authorize(influxql.ReadPrivilege, "foo") {
+		t.Fatalf("unexpected role after revoke: %v", u.Roles)
+	}
+
+	// Deleting a role removes it from its members.
+	s.GrantRole("readers", "susy")
+	if err := s.DeleteRole("readers"); err != nil {
+		t.Fatal(err)
+	} else if s.Role("readers") != nil {
+		t.Fatal("expected role to be deleted")
+	}
+	s.Restart()
+	if u := s.User("susy"); len(u.Roles) != 0 || u.Authorize(influxql.ReadPrivilege, "foo") {
+		t.Fatalf("unexpected role after delete: %v", u.Roles)
+	} else if len(s.Roles()) != 0 {
+		t.Fatalf("unexpected roles: %v", s.Roles())
+	}
+}
+
+// Ensure privileges can be granted on individual measurements.
+func TestServer_MeasurementPrivileges(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.CreateUser("susy", "pass", false)
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(20)}}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "disk", Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(30)}}})
+
+	// Grant read on cpu and write on measurements matching a regex.
+	if err := s.SetMeasurementPrivilege(influxql.ReadPrivilege, "susy", "foo", "cpu", false); err != nil {
+		t.Fatal(err)
+	} else if err := s.SetMeasurementPrivilege(influxql.WritePrivilege, "susy", "foo", "^mem", true); err != nil {
+		t.Fatal(err)
+	} else if err := s.SetMeasurementPrivilege(influxql.ReadPrivilege, "susy", "foo", "(", true); err == nil {
+		t.Fatal("expected invalid regex error")
+	} else if err := s.SetMeasurementPrivilege(influxql.ReadPrivilege, "susy", "", "cpu", false); err != influxdb.ErrDatabaseNameRequired {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// Ensure the grants are persisted.
+	s.Restart()
+	u := s.User("susy")
+	if !u.AuthorizeMeasurement(influxql.ReadPrivilege, "foo", "cpu") {
+		t.Fatal("expected read privilege on cpu")
+	} else if u.AuthorizeMeasurement(influxql.WritePrivilege, "foo", "cpu") || u.AuthorizeMeasurement(influxql.ReadPrivilege, "foo", "disk") {
+		t.Fatal("unexpected privilege on cpu or disk")
+	} else if !u.AuthorizeMeasurement(influxql.WritePrivilege, "foo", "mem_free") {
+		t.Fatal("expected write privilege on mem_free")
+	} else if u.AuthorizeMeasurement(influxql.ReadPrivilege, "bar", "cpu") || u.Authorize(influxql.ReadPrivilege, "foo") {
+		t.Fatal("unexpected database privilege")
+	}
+
+	// Ensure queries are limited to the granted measurements.
+	s.SetAuthenticationEnabled(true)
+	if results := s.ExecuteQuery(MustParseQuery(`SELECT value FROM cpu`), "foo", u); results.Error() != nil {
+		t.Fatalf("unexpected error: %s", results.Error())
+	} else if s := mustMarshalJSON(results); s != `{"results":[{"rows":[{"name":"cpu","columns":["time","value"],"values":[["2000-01-01T00:00:00Z",20]]}]}]}` {
+		t.Fatalf("unexpected results: %s", s)
+	}
+	if err := s.Authorize(u, MustParseQuery(`SELECT value FROM disk`), "foo"); err == nil {
+		t.Fatal("expected authorization error for disk")
+	} else if err := s.Authorize(u, MustParseQuery(`SELECT value FROM "foo"."raw".cpu`), "bar"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if err := s.Authorize(u, MustParseQuery(`SHOW MEASUREMENTS`), "foo"); err == nil {
+		t.Fatal("expected authorization error for SHOW MEASUREMENTS")
+	}
+
+	// Revoke the grant.
+	if err := s.SetMeasurementPrivilege(influxql.NoPrivileges, "susy", "foo", "cpu", false); err != nil {
+		t.Fatal(err)
+	} else if u := s.User("susy"); u.AuthorizeMeasurement(influxql.ReadPrivilege, "foo", "cpu") {
+		t.Fatal("unexpected read privilege on cpu after revoke")
+	} else if len(u.MeasurementPrivileges["foo"]) != 1 {
+		t.Fatalf("unexpected measurement privileges: %v", u.MeasurementPrivileges)
+	}
+}
+
+// Ensure administrative statements are recorded to the audit sinks.
+func TestServer_Audit(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateUser("admin", "pass", true)
+	s.CreateUser("susy", "pass", false)
+	s.SetAuthenticationEnabled(true)
+
+	ch := make(influxdb.AuditChan, 1)
+	var buf bytes.Buffer
+	s.AuditSinks = []influxdb.AuditSink{ch, influxdb.NewAuditWriter(&buf)}
+
+	// Passwords are not recorded.
+	if res := s.ExecuteQuery(MustParseQuery(`CREATE USER bob WITH PASSWORD 'secret'`), "foo", s.User("admin")); res.Error() != nil {
+		t.Fatal(res.Error())
+	} else if e := <-ch; e.Username != "admin" || e.Action != "create_user" || e.Database != "foo" || e.Err != "" || strings.Contains(e.Statement, "secret") || e.Time.IsZero() {
+		t.Fatalf("unexpected event: %#v", e)
+	}
+
+	// Queries are only recorded if enabled.
+	s.ExecuteQuery(MustParseQuery(`SHOW MEASUREMENTS`), "foo", s.User("susy"))
+	select {
+	case e := <-ch:
+		t.Fatalf("unexpected event: %#v", e)
+	default:
+	}
+	s.AuditQueries = true
+	s.ExecuteQuery(MustParseQuery(`SHOW MEASUREMENTS`), "foo", s.User("admin"))
+	if e := <-ch; e.Action != "query" || e.Statement != "SHOW MEASUREMENTS" {
+		t.Fatalf("unexpected event: %#v", e)
+	}
+
+	// Unauthorized statements are recorded with their error.
+	s.ExecuteQuery(MustParseQuery(`DROP DATABASE foo`), "foo", s.User("susy"))
+	if e := <-ch; e.Username != "susy" || e.Action != "drop_database" || e.Err == "" {
+		t.Fatalf("unexpected event: %#v", e)
+	} else if !s.DatabaseExists("foo") {
+		t.Fatal("database unexpectedly dropped")
+	}
+
+	// Events are dropped when the channel is full but still written to the writer.
+	ch <- &influxdb.AuditEvent{}
+	s.ExecuteQuery(MustParseQuery(`DROP USER bob`), "foo", s.User("admin"))
+	if lines := strings.Split(strings.TrimSpace(buf.String()), "\n"); len(lines) != 4 {
+		t.Fatalf("unexpected audit log: %s", buf.String())
+	} else if !strings.Contains(lines[3], `"username":"admin","action":"drop_user","database":"foo","statement":"DROP USER bob"}`) {
+		t.Fatalf("unexpected audit line: %s", lines[3])
+	}
+}
+
+// Test single statement query authorization.
+func TestServer_SingleStatementQueryAuthorization(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+
+	// Create cluster admin.
+	s.CreateUser("admin", "admin", true)
+	admin := s.User("admin")
+
+	// Create normal database user.
+	s.CreateUser("user", "user", false)
+	user := s.User("user")
+	user.Privileges["foo"] = influxql.ReadPrivilege
+
+	s.Restart()
+
+	// Create a query that only cluster admins can run.
+	adminOnlyQuery := &influxql.Query{
+		Statements: []influxql.Statement{
+			&influxql.DropDatabaseStatement{Name: "foo"},
+		},
+	}
+
+	// Create a query that requires read on one db and write on another.
+	readWriteQuery := &influxql.Query{
+		Statements: []influxql.Statement{
+			&influxql.CreateContinuousQueryStatement{
+				Name:     "myquery",
+				Database: "foo",
+				Source: &influxql.SelectStatement{
+					Fields: []*influxql.Field{{Expr: &influxql.Call{Name: "count"}}},
+					Target: &influxql.Target{Measurement: "measure1", Database: "bar"},
+					Source: &influxql.Measurement{Name: "myseries"},
+				},
+			},
+		},
+	}
+
+	// admin user should be authorized to execute any query.
+	if err := s.Authorize(admin, adminOnlyQuery, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Authorize(admin, readWriteQuery, "foo"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Normal user should not be authorized to execute admin only query.
+	if err := s.Authorize(user, adminOnlyQuery, ""); err == nil {
+		t.Fatalf("normal user should not be authorized to execute cluster admin level queries")
+	}
+
+	// Normal user should not be authorized to execute query that selects into another
+	// database which (s)he doesn't have privileges on.
+	if err := s.Authorize(user, readWriteQuery, ""); err == nil {
+		t.Fatalf("normal user should not be authorized to write to database bar")
+	}
+
+	// Grant normal user write privileges on database "bar".
+	user.Privileges["bar"] = influxql.WritePrivilege
+
+	//Authorization on the previous query should now succeed.
+	if err := s.Authorize(user, readWriteQuery, ""); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Test multiple statement query authorization.
+func TestServer_MultiStatementQueryAuthorization(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+
+	// Create cluster admin.
+	s.CreateUser("admin", "admin", true)
+	admin := s.User("admin")
+
+	// Create normal database user.
+	s.CreateUser("user", "user", false)
+	user := s.User("user")
+	user.Privileges["foo"] = influxql.ReadPrivilege
+
+	s.Restart()
+
+	// Create a query that requires read for one statement and write for the second.
+	readWriteQuery := &influxql.Query{
+		Statements: []influxql.Statement{
+			// Statement that requires read.
+			&influxql.SelectStatement{
+				Fields: []*influxql.Field{{Expr: &influxql.Call{Name: "count"}}},
+				Source: &influxql.Measurement{Name: "cpu"},
+			},
+
+			// Statement that requires write.
+			&influxql.SelectStatement{
+				Fields: []*influxql.Field{{Expr: &influxql.Call{Name: "count"}}},
+				Source: &influxql.Measurement{Name: "cpu"},
+				Target: &influxql.Target{Measurement: "tmp"},
+			},
+		},
+	}
+
+	// Admin should be authorized to execute both statements in the query.
+	if err := s.Authorize(admin, readWriteQuery, "foo"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Normal user with only read privileges should not be authorized to execute both statements.
+	if err := s.Authorize(user, readWriteQuery, "foo"); err == nil {
+		t.Fatalf("user should not be authorized to execute both statements")
+	}
+}
+
+// Ensure the server can create a database.
+func TestServer_CreateDatabase(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+
+	// Create the "foo" database.
+	if err := s.CreateDatabase("foo"); err != nil {
+		t.Fatal(err)
+	}
+	s.Restart()
+
+	// Verify that the database exists.
+	if !s.DatabaseExists("foo") {
+		t.Fatalf("database not found")
+	}
+}
+
+// Ensure the server returns an error when creating a duplicate database.
+func TestServer_CreateDatabase_ErrDatabaseExists(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+
+	// Create the "foo" database twice.
+	if err := s.CreateDatabase("foo"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CreateDatabase("foo"); err != influxdb.ErrDatabaseExists {
+		t.Fatal(err)
+	}
+}
+
+// Ensure the server can drop a database.
+func TestServer_DropDatabase(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+
+	// Create the "foo" database and verify it exists.
+	if err := s.CreateDatabase("foo"); err != nil {
+		t.Fatal(err)
+	} else if !s.DatabaseExists("foo") {
+		t.Fatalf("database not actually created")
+	}
+	s.Restart()
+
+	// Drop the "foo" database and verify that it's gone.
+	if err := s.DeleteDatabase("foo"); err != nil {
+		t.Fatal(err)
+	} else if s.DatabaseExists("foo") {
+		t.Fatalf("database not actually dropped")
+	}
+}
+
+// Ensure the server returns an error when dropping a database that doesn't exist.
+func TestServer_DropDatabase_ErrDatabaseNotFound(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+
+	// Drop a database that doesn't exist.
+	if err := s.DeleteDatabase("no_such_db"); err != influxdb.ErrDatabaseNotFound {
+		t.Fatal(err)
+	}
+}
+
+// Ensure the server can return a list of all databases.
+func TestServer_Databases(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+
+	// Create some databases.
+	s.CreateDatabase("foo")
+	s.CreateDatabase("bar")
+	s.Restart()
+
+	// Return the databases.
+	if a := s.Databases(); len(a) != 2 {
+		t.Fatalf("unexpected db count: %d", len(a))
+	} else if a[0] != "bar" {
+		t.Fatalf("unexpected db(0): %s", a[0])
+	} else if a[1] != "foo" {
+		t.Fatalf("unexpected db(1): %s", a[1])
+	}
+}
+
+// Ensure the server can create a new user.
+func TestServer_CreateUser(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+
+	// Create a user.
+	if err := s.CreateUser("susy", "pass", true); err != nil {
+		t.Fatal(err)
+	}
+	s.Restart()
+
+	// Verify that the user exists.
+	if u := s.User("susy"); u == nil {
+		t.Fatalf("user not found")
+	} else if u.Name != "susy" {
+		t.Fatalf("username mismatch: %v", u.Name)
+	} else if !u.Admin {
+		t.Fatalf("admin mismatch: %v", u.Admin)
+	} else if bcrypt.CompareHashAndPassword([]byte(u.Hash), []byte("pass")) != nil {
+		t.Fatal("invalid password")
+	}
+
+	// Verify that the authenticated user exists.
+	u, err := s.Authenticate("susy", "pass")
+	if err != nil {
+		t.Fatalf("error fetching authenticated user")
+	} else if u.Name != "susy" {
+		t.Fatalf("username mismatch: %v", u.Name)
+	} else if !u.Admin {
+		t.Fatalf("admin mismatch: %v", u.Admin)
+	} else if bcrypt.CompareHashAndPassword([]byte(u.Hash), []byte("pass")) != nil {
+		t.Fatal("invalid password")
+	}
+
+}
+
+// Ensure the server correctly detects when there is an admin user.
+func TestServer_AdminUserExists(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+
+	// A server should start up without any admin user.
+	if s.AdminUserExists() {
+		t.Fatalf("admin user unexpectedly exists at start-up")
+	}
+
+	// Create a non-admin user and verify Server agrees there is no admin user.
+	if err := s.CreateUser("bert", "pass", false); err != nil {
+		t.Fatal(err)
+	}
+	s.Restart()
+	if s.AdminUserExists() {
+		t.Fatalf("admin user unexpectedly exists")
+	}
+
+	// Next, create an admin user, and ensure the Server agrees there is an admin user.
+	if err := s.CreateUser("ernie", "pass", true); err != nil {
+		t.Fatal(err)
+	}
+	s.Restart()
+	if !s.AdminUserExists() {
+		t.Fatalf("admin user does not exist")
+	}
+}
+
+// Ensure the server returns an error when creating an user without a name.
+func TestServer_CreateUser_ErrUsernameRequired(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	if err := s.CreateUser("", "pass", false); err != influxdb.ErrUsernameRequired {
+		t.Fatal(err)
+	}
+}
+
+// Ensure the server returns an error when creating a duplicate user.
+func TestServer_CreateUser_ErrUserExists(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	if err := s.CreateUser("susy", "pass", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CreateUser("susy", "pass", false); err != influxdb.ErrUserExists {
+		t.Fatal(err)
+	}
+}
+
+// Ensure the server can delete an existing user.
+func TestServer_DeleteUser(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+
+	// Create a user.
+	if err := s.CreateUser("susy", "pass", false); err != nil {
+		t.Fatal(err)
+	} else if s.User("susy") == nil {
+		t.Fatalf("user not created")
+	}
+
+	// Delete the user.
+	if err := s.DeleteUser("susy"); err != nil {
+		t.Fatal(err)
+	} else if s.User("susy") != nil {
+		t.Fatalf("user not actually deleted")
+	}
+	s.Restart()
+
+	if s.User("susy") != nil {
+		t.Fatalf("user not actually deleted after restart")
+	}
+}
+
+// Ensure the server can return a list of all users.
+func TestServer_Users(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+
+	// Create some users.
+	s.CreateUser("susy", "pass", false)
+	s.CreateUser("john", "pass", false)
+	s.Restart()
+
+	// Return the users.
+	if a := s.Users(); len(a) != 2 {
+		t.Fatalf("unexpected user count: %d", len(a))
+	} else if a[0].Name != "john" {
+		t.Fatalf("unexpected user(0): %s", a[0].Name)
+	} else if a[1].Name != "susy" {
+		t.Fatalf("unexpected user(1): %s", a[1].Name)
+	}
+}
+
+// Ensure the server does not return non-existent users
+func TestServer_NonExistingUsers(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+
+	// Create some users.
+	s.CreateUser("susy", "pass", false)
+	s.CreateUser("john", "pass2", false)
+	s.Restart()
+
+	// Ask for users that should not be returned.
+	u := s.User("bob")
+	if u != nil {
+		t.Fatalf("unexpected user found")
+	}
+	u, err := s.Authenticate("susy", "wrong_password")
+	if err == nil {
+		t.Fatalf("unexpected authenticated user found")
+	}
+}
+
+// Ensure the database can create a new retention policy.
+func TestServer_CreateRetentionPolicy(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+
+	// Create a database.
+	if err := s.CreateDatabase("foo"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Create a retention policy on the database.
+	rp := &influxdb.RetentionPolicy{
+		Name:               "bar",
+		Duration:           time.Hour,
+		ShardGroupDuration: 30 * time.Minute,
+		ReplicaN:           2,
+		Compression:        influxdb.CompressionDeflate,
+	}
+	if err := s.CreateRetentionPolicy("foo", rp); err != nil {
+		t.Fatal(err)
+	}
+	s.Restart()
+
+	// Verify that the policy exists.
+	if o, err := s.RetentionPolicy("foo", "bar"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if o == nil {
+		t.Fatalf("retention policy not found")
+	} else if !reflect.DeepEqual(rp, o) {
+		t.Fatalf("retention policy mismatch: %#v", o)
+	}
+}
+
+// Ensure the server defaults the shard group duration from the retention period.
+func TestServer_CreateRetentionPolicy_ShardGroupDuration(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+
+	for i, tt := range []struct {
+		duration time.Duration
+		exp      time.Duration
+	}{
+		{duration: time.Hour, exp: time.Hour},
+		{duration: 30 * 24 * time.Hour, exp: 24 * time.Hour},
+		{duration: 365 * 24 * time.Hour, exp: 7 * 24 * time.Hour},
+	} {
+		name := fmt.Sprintf("rp%d", i)
+		if err := s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: name, Duration: tt.duration, ReplicaN: 1}); err != nil {
+			t.Fatalf("%d. unexpected error: %s", i, err)
+		} else if rp, _ := s.RetentionPolicy("foo", name); rp.ShardGroupDuration != tt.exp {
+			t.Fatalf("%d. unexpected shard group duration: %s", i, rp.ShardGroupDuration)
+		}
+	}
+
+	// Ensure new shard groups span the shard group duration.
+	if err := s.CreateShardGroupIfNotExists("foo", "rp1", mustParseTime("2000-01-01T12:00:00Z")); err != nil {
+		t.Fatal(err)
+	}
+	if a, err := s.ShardGroups("foo"); err != nil {
+		t.Fatal(err)
+	} else if len(a) != 1 {
+		t.Fatalf("unexpected shard group count: %d", len(a))
+	} else if !a[0].StartTime.Equal(mustParseTime("2000-01-01T00:00:00Z")) || !a[0].EndTime.Equal(mustParseTime("2000-01-02T00:00:00Z")) {
+		t.Fatalf("unexpected shard group range: %s - %s", a[0].StartTime, a[0].EndTime)
+	}
+
+	// Ensure the shard group duration can be altered.
+	results := s.ExecuteQuery(MustParseQuery(`ALTER RETENTION POLICY rp1 ON foo SHARD DURATION 2h`), "foo", nil)
+	if err := results.Error(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if rp, _ := s.RetentionPolicy("foo", "rp1"); rp.ShardGroupDuration != 2*time.Hour || rp.ReplicaN != 1 {
+		t.Fatalf("unexpected policy: %#v", rp)
+	}
+}
+
+// Ensure points written to a compressed retention policy are read back unchanged
+// and that changing the compression only applies to new shard groups.
+func TestServer_CreateRetentionPolicy_Compression(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+
+	results := s.ExecuteQuery(MustParseQuery(`CREATE RETENTION POLICY logs ON foo DURATION 1h REPLICATION 1 COMPRESSION deflate DEFAULT`), "foo", nil)
+	if err := results.Error(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	msg := strings.Repeat("disk full on /var/log ", 20)
+	tags := map[string]string{"host": "servera"}
+	index, err := s.WriteSeries("foo", "logs", []influxdb.Point{{Name: "syslog", Tags: tags, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"msg": msg}}})
+	if err != nil {
+		t.Fatal(err)
+	} else if err = s.Sync(index); err != nil {
+		t.Fatalf("sync error: %s", err)
+	}
+
+	if v, err := s.ReadSeries("foo", "logs", "syslog", tags, mustParseTime("2000-01-01T00:00:00Z")); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(v, map[string]interface{}{"msg": msg}) {
+		t.Fatalf("values mismatch: %#v", v)
+	}
+	results = s.ExecuteQuery(MustParseQuery(`SELECT msg FROM syslog`), "foo", nil)
+	if res := results.Results[0]; res.Err != nil {
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if len(res.Rows) != 1 || len(res.Rows[0].Values) != 1 || res.Rows[0].Values[0][1] != msg {
+		t.Fatalf("unexpected row(s): %s", mustMarshalJSON(res))
+	}
+
+	// Existing shard groups keep their compression.
+	results = s.ExecuteQuery(MustParseQuery(`ALTER RETENTION POLICY logs ON foo COMPRESSION none`), "foo", nil)
+	if err := results.Error(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if rp, _ := s.RetentionPolicy("foo", "logs"); rp.Compression != influxdb.CompressionNone {
+		t.Fatalf("unexpected compression: %s", rp.Compression)
+	}
+	if err := s.CreateShardGroupIfNotExists("foo", "logs", mustParseTime("2000-01-01T02:00:00Z")); err != nil {
+		t.Fatal(err)
+	}
+	if a, err := s.ShardGroups("foo"); err != nil {
+		t.Fatal(err)
+	} else if len(a) != 2 {
+		t.Fatalf("unexpected shard group count: %d", len(a))
+	} else if a[0].Shards[0].Compression != influxdb.CompressionDeflate || a[1].Shards[0].Compression != influxdb.CompressionNone {
+		t.Fatalf("unexpected shard compressions: %s, %s", a[0].Shards[0].Compression, a[1].Shards[0].Compression)
+	}
+
+	results = s.ExecuteQuery(MustParseQuery(`SHOW RETENTION POLICIES foo`), "foo", nil)
+	if res := results.Results[0]; res.Err != nil {
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if s := mustMarshalJSON(res); s != `{"rows":[{"columns":["name","duration","shardGroupDuration","replicaN","compression"],"values":[["logs","1h0m0s","1h0m0s",1,"none"]]}]}` {
+		t.Fatalf("unexpected row(s): %s", s)
+	}
+
+	// Unsupported compressions are rejected.
+	results = s.ExecuteQuery(MustParseQuery(`ALTER RETENTION POLICY logs ON foo COMPRESSION lz4`), "foo", nil)
+	if res := results.Results[0]; res.Err != influxdb.ErrInvalidCompression {
+		t.Fatalf("unexpected error: %s", res.Err)
+	}
+}
+
+// Ensure the server returns an error when creating a retention policy with an invalid db.
+func TestServer_CreateRetentionPolicy_ErrDatabaseNotFound(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	if err := s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "bar"}); err != influxdb.ErrDatabaseNotFound {
+		t.Fatal(err)
+	}
+}
+
+// Ensure the server returns an error when creating a retention policy without a name.
+func TestServer_CreateRetentionPolicy_ErrRetentionPolicyNameRequired(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	if err := s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: ""}); err != influxdb.ErrRetentionPolicyNameRequired {
+		t.Fatal(err)
+	}
+}
+
+// Ensure the server returns an error when creating a duplicate retention policy.
+func TestServer_CreateRetentionPolicy_ErrRetentionPolicyExists(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "bar"})
+	if err := s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "bar"}); err != influxdb.ErrRetentionPolicyExists {
+		t.Fatal(err)
+	}
+}
+
+// Ensure the database can alter an existing retention policy.
+func TestServer_AlterRetentionPolicy(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+
+	// Create a database.
+	if err := s.CreateDatabase("foo"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Create a retention policy on the database.
+	rp := &influxdb.RetentionPolicy{
+		Name:     "bar",
+		Duration: time.Hour,
+		ReplicaN: 2,
+	}
+	if err := s.CreateRetentionPolicy("foo", rp); err != nil {
+		t.Fatal(err)
+	}
+
+	// Alter the retention policy.
+	duration := time.Minute
+	replicaN := uint32(3)
+	rp2 := &influxdb.RetentionPolicyUpdate{
+		Duration:           &duration,
+		ShardGroupDuration: &duration,
+		ReplicaN:           &replicaN,
+	}
+	if err := s.UpdateRetentionPolicy("foo", "bar", rp2); err != nil {
+		t.Fatal(err)
+	}
+
+	// Restart the server to make sure the changes persist afterwards.
+	s.Restart()
+
+	// Verify that the policy exists.
+	if o, err := s.RetentionPolicy("foo", "bar"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if o == nil {
+		t.Fatalf("retention policy not found")
+	} else if o.Duration != *rp2.Duration {
+		t.Fatalf("retention policy mismatch:\n\texp Duration = %s\n\tgot Duration = %s\n", rp2.Duration, o.Duration)
+	} else if o.ReplicaN != *rp2.ReplicaN {
+		t.Fatalf("retention policy mismatch:\n\texp ReplicaN = %d\n\tgot ReplicaN = %d\n", rp2.ReplicaN, o.ReplicaN)
+	}
+}
+
+// Ensure changing a policy's shard group duration cuts the group in progress
+// short and sizes later groups with the new duration.
+func TestServer_AlterRetentionPolicy_ShardGroupDuration(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "bar", Duration: 52 * 7 * 24 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "bar")
+
+	now := time.Now().UTC()
+	if err := s.CreateShardGroupIfNotExists("foo", "bar", now); err != nil {
+		t.Fatal(err)
+	}
+
+	// Write a point at the end of the group in progress.
+	g, _ := s.ShardGroups("foo")
+	future := g[0].EndTime.Add(-time.Second)
+	s.MustWriteSeries("foo", "bar", []influxdb.Point{{Name: "cpu", Timestamp: future, Values: map[string]interface{}{"value": float64(10)}}})
+
+	// Shrink the shard group duration to an hour.
+	d := time.Hour
+	if err := s.UpdateRetentionPolicy("foo", "bar", &influxdb.RetentionPolicyUpdate{ShardGroupDuration: &d}); err != nil {
+		t.Fatal(err)
+	}
+	g, _ = s.ShardGroups("foo")
+	if end := now.Truncate(time.Hour).Add(time.Hour); !g[0].EndTime.Equal(end) {
+		t.Fatalf("unexpected end time: %s, expected %s", g[0].EndTime, end)
+	}
+
+	// The point written past the new end time is still read.
+	q := fmt.Sprintf(`SELECT value FROM cpu WHERE time >= '%s' AND time <= '%s'`, future.Add(-time.Second).Format(time.RFC3339Nano), future.Add(time.Second).Format(time.RFC3339Nano))
+	results := s.ExecuteQuery(MustParseQuery(q), "foo", nil)
+	if res := results.Results[0]; res.Err != nil {
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if len(res.Rows) != 1 || len(res.Rows[0].Values) != 1 {
+		t.Fatalf("unexpected result: %s", mustMarshalJSON(res))
+	}
+
+	// Writes after the truncated group land in an hour long group.
+	if err := s.CreateShardGroupIfNotExists("foo", "bar", now.Add(2*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	g, _ = s.ShardGroups("foo")
+	if len(g) != 2 {
+		t.Fatalf("expected 2 shard groups but found %d", len(g))
+	} else if g[1].Duration() != time.Hour {
+		t.Fatalf("unexpected shard group duration: %s", g[1].Duration())
+	}
+
+	// Growing the duration again creates groups that do not overlap existing ones.
+	d = 7 * 24 * time.Hour
+	if err := s.UpdateRetentionPolicy("foo", "bar", &influxdb.RetentionPolicyUpdate{ShardGroupDuration: &d}); err != nil {
+		t.Fatal(err)
+	} else if err := s.CreateShardGroupIfNotExists("foo", "bar", now.Add(4*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	g, _ = s.ShardGroups("foo")
+	if len(g) != 3 {
+		t.Fatalf("expected 3 shard groups but found %d", len(g))
+	} else if !g[2].StartTime.Equal(g[1].EndTime) {
+		t.Fatalf("unexpected start time: %s, expected %s", g[2].StartTime, g[1].EndTime)
+	}
+}
+
+// Ensure the server rejects retention policy durations below the minimum or
+// shorter than the policy's shard group duration.
+func TestServer_RetentionPolicy_ErrDuration(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.MinRetentionPolicyDuration = time.Hour
+	s.CreateDatabase("foo")
+
+	if err := s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "bar", Duration: time.Minute}); err != influxdb.ErrRetentionPolicyDurationTooLow {
+		t.Fatalf("unexpected error: %v", err)
+	} else if err := s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "bar", Duration: time.Hour, ShardGroupDuration: 2 * time.Hour}); err != influxdb.ErrShardGroupDurationExceedsRetention {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Infinite policies are not subject to the minimum.
+	if err := s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "forever"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Updates are validated against the resulting policy.
+	if err := s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "bar", Duration: 2 * time.Hour}); err != nil {
+		t.Fatal(err)
+	}
+	d := 30 * time.Minute
+	if err := s.UpdateRetentionPolicy("foo", "bar", &influxdb.RetentionPolicyUpdate{Duration: &d}); err != influxdb.ErrRetentionPolicyDurationTooLow {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	d, sgd := 90*time.Minute, 3*time.Hour
+	if err := s.UpdateRetentionPolicy("foo", "bar", &influxdb.RetentionPolicyUpdate{ShardGroupDuration: &sgd}); err != influxdb.ErrShardGroupDurationExceedsRetention {
+		t.Fatalf("unexpected error: %v", err)
+	} else if err := s.UpdateRetentionPolicy("foo", "bar", &influxdb.RetentionPolicyUpdate{Duration: &d}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure the server can delete an existing retention policy.
+func TestServer_DeleteRetentionPolicy(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+
+	// Create a database and retention policy.
+	s.CreateDatabase("foo")
+	if err := s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "bar"}); err != nil {
+		t.Fatal(err)
+	} else if rp, _ := s.RetentionPolicy("foo", "bar"); rp == nil {
+		t.Fatal("retention policy not created")
+	}
+
+	// Remove retention policy from database.
+	if err := s.DeleteRetentionPolicy("foo", "bar"); err != nil {
+		t.Fatal(err)
+	} else if rp, _ := s.RetentionPolicy("foo", "bar"); rp != nil {
+		t.Fatal("retention policy not deleted")
+	}
+	s.Restart()
+
+	if rp, _ := s.RetentionPolicy("foo", "bar"); rp != nil {
+		t.Fatal("retention policy not deleted after restart")
+	}
+}
+
+// Ensure the server returns an error when deleting a retention policy on invalid db.
+func TestServer_DeleteRetentionPolicy_ErrDatabaseNotFound(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	if err := s.DeleteRetentionPolicy("foo", "bar"); err != influxdb.ErrDatabaseNotFound {
+		t.Fatal(err)
+	}
+}
+
+// Ensure the server returns an error when deleting a retention policy without a name.
+func TestServer_DeleteRetentionPolicy_ErrRetentionPolicyNameRequired(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	if err := s.DeleteRetentionPolicy("foo", ""); err != influxdb.ErrRetentionPolicyNameRequired {
+		t.Fatal(err)
+	}
+}
+
+// Ensure the server returns an error when deleting a non-existent retention policy.
+func TestServer_DeleteRetentionPolicy_ErrRetentionPolicyNotFound(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	if err := s.DeleteRetentionPolicy("foo", "no_such_policy"); err != influxdb.ErrRetentionPolicyNotFound {
+		t.Fatal(err)
+	}
+}
+
+// Ensure the server can set the default retention policy
+func TestServer_SetDefaultRetentionPolicy(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+
+	rp := &influxdb.RetentionPolicy{Name: "bar", ShardGroupDuration: time.Hour}
+	if err := s.CreateRetentionPolicy("foo", rp); err != nil {
+		t.Fatal(err)
+	} else if rp, _ := s.RetentionPolicy("foo", "bar"); rp == nil {
+		t.Fatal("retention policy not created")
+	}
+
+	// Set bar as default
+	if err := s.SetDefaultRetentionPolicy("foo", "bar"); err != nil {
+		t.Fatal(err)
+	}
+
+	if o, _ := s.DefaultRetentionPolicy("foo"); o == nil {
+		t.Fatal("default policy not set")
+	} else if !reflect.DeepEqual(rp, o) {
+		t.Fatalf("retention policy mismatch: %#v", o)
+	}
+
+	s.Restart()
+
+	if o, _ := s.DefaultRetentionPolicy("foo"); o == nil {
+		t.Fatal("default policy not kept after restart")
+	} else if !reflect.DeepEqual(rp, o) {
+		t.Fatalf("retention policy mismatch after restart: %#v", o)
+	}
+}
+
+// Ensure the server returns an error when setting the default retention policy to a non-existant one.
+func TestServer_SetDefaultRetentionPolicy_ErrRetentionPolicyNotFound(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	if err := s.SetDefaultRetentionPolicy("foo", "no_such_policy"); err != influxdb.ErrRetentionPolicyNotFound {
+		t.Fatal(err)
+	}
+}
+
+// Ensure the server prohibits a zero check interval for retention policy enforcement.
+func TestServer_StartRetentionPolicyEnforcement_ErrZeroInterval(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	if err := s.StartRetentionPolicyEnforcement(time.Duration(0)); err == nil {
+		t.Fatal("failed to prohibit retention policies zero check interval")
+	}
+}
+
+func TestServer_EnforceRetentionPolices(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "mypolicy", Duration: 30 * time.Minute})
+
+	// Create two shard groups for the the new retention policy -- 1 which will age out immediately
+	// the other in more than an hour.
+	s.CreateShardGroupIfNotExists("foo", "mypolicy", time.Now().Add(-1*time.Hour))
+	s.CreateShardGroupIfNotExists("foo", "mypolicy", time.Now().Add(time.Hour))
+
+	// Check the two shard groups exist.
+	var g []*influxdb.ShardGroup
+	g, err := s.ShardGroups("foo")
+	if err != nil {
+		t.Fatal(err)
+	} else if len(g) != 2 {
+		t.Fatalf("expected 2 shard group but found %d", len(g))
+	}
+
+	// Run retention enforcement.
+	s.EnforceRetentionPolicies()
+
+	// Ensure enforcement is in effect across restarts.
+	s.Restart()
+
+	// First shard group should have been removed.
+	g, err = s.ShardGroups("foo")
+	if err != nil {
+		t.Fatal(err)
+	} else if len(g) != 1 {
+		t.Fatalf("expected 1 shard group but found %d", len(g))
+	}
+}
+
+// Ensure retention enforcement removes all expired shard groups with a single sweep.
+func TestServer_EnforceRetentionPolicies_Sweep(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "mypolicy", Duration: 30 * time.Minute})
+	s.CreateShardGroupIfNotExists("foo", "mypolicy", time.Now().Add(-3*24*time.Hour))
+	s.CreateShardGroupIfNotExists("foo", "mypolicy", time.Now().Add(-2*24*time.Hour))
+	s.CreateShardGroupIfNotExists("foo", "mypolicy", time.Now().Add(time.Hour))
+
+	// Count the messages published by enforcement.
+	var n int
+	c.PublishFunc = func(m *messaging.Message) (uint64, error) {
+		n++
+		return c.send(m)
+	}
+
+	// Both expired groups are removed by one sweep.
+	s.EnforceRetentionPolicies()
+	if g, err := s.ShardGroups("foo"); err != nil {
+		t.Fatal(err)
+	} else if len(g) != 1 {
+		t.Fatalf("expected 1 shard group but found %d", len(g))
+	} else if n != 1 {
+		t.Fatalf("unexpected message count: %d", n)
+	}
+}
+
+// Ensure retention enforcement deletes points older than a measurement's TTL.
+func TestServer_EnforceRetentionPolicies_MeasurementTTL(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", ShardGroupDuration: 24 * time.Hour})
+
+	// Write an old and a recent point to each measurement.
+	now := time.Now().UTC().Truncate(time.Second)
+	old := now.Add(-2 * time.Hour)
+	for _, name := range []string{"debug", "kpi"} {
+		index, err := s.WriteSeries("foo", "raw", []influxdb.Point{
+			{Name: name, Timestamp: old, Values: map[string]interface{}{"value": float64(1)}},
+			{Name: name, Timestamp: now, Values: map[string]interface{}{"value": float64(2)}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		} else if err = s.Sync(index); err != nil {
+			t.Fatalf("sync error: %s", err)
+		}
+	}
+
+	if err := s.SetMeasurementTTL("foo", "debug", time.Hour); err != nil {
+		t.Fatal(err)
+	} else if err := s.SetMeasurementTTL("foo", "debug", -time.Hour); err != influxdb.ErrMeasurementTTLInvalid {
+		t.Fatalf("unexpected error: %v", err)
+	} else if err := s.SetMeasurementTTL("foo", "nosuchmeasurement", time.Hour); err != influxdb.ErrMeasurementNotFound {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	s.Restart()
+	s.EnforceRetentionPolicies()
+
+	// Only the old point of the measurement with a TTL is deleted.
+	if v, err := s.ReadSeries("foo", "raw", "debug", nil, old); err != nil {
+		t.Fatal(err)
+	} else if v != nil {
+		t.Fatalf("expected point to be deleted: %#v", v)
+	} else if v, _ := s.ReadSeries("foo", "raw", "debug", nil, now); v == nil {
+		t.Fatal("expected recent point to be kept")
+	} else if v, _ := s.ReadSeries("foo", "raw", "kpi", nil, old); v == nil {
+		t.Fatal("expected point without ttl to be kept")
+	}
+}
+
+// Ensure series are deleted once the shards holding their points have aged out.
+func TestServer_CollectSeries(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: time.Hour, ShardGroupDuration: time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+
+	// Write a point that ages out and a recent point.
+	now := time.Now().UTC().Truncate(time.Second)
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"host": "servera"}, Timestamp: now.Add(-3 * time.Hour), Values: map[string]interface{}{"value": float64(10)}}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"host": "serverb"}, Timestamp: now, Values: map[string]interface{}{"value": float64(20)}}})
+	s.EnforceRetentionPolicies()
+
+	// The series without points is marked by the first collection and deleted by the second.
+	for i, exp := range []string{
+		`{"rows":[{"name":"cpu","columns":["host"],"values":[["servera"],["serverb"]]}]}`,
+		`{"rows":[{"name":"cpu","columns":["host"],"values":[["serverb"]]}]}`,
+	} {
+		if err := s.CollectSeries(); err != nil {
+			t.Fatal(err)
+		}
+		if res := s.ExecuteQuery(MustParseQuery(`SHOW SERIES`), "foo", nil).Results[0]; res.Err != nil {
+			t.Fatalf("%d. unexpected error: %s", i, res.Err)
+		} else if s := mustMarshalJSON(res); s != exp {
+			t.Fatalf("%d. unexpected result: %s", i, s)
+		}
+	}
+
+	// The series is removed from the metastore.
+	s.Restart()
+	if res := s.ExecuteQuery(MustParseQuery(`SHOW SERIES CARDINALITY`), "foo", nil).Results[0]; res.Err != nil {
+		t.Fatal(res.Err)
+	} else if s := mustMarshalJSON(res); s != `{"rows":[{"name":"cpu","columns":["count"],"values":[[1]]}]}` {
+		t.Fatalf("unexpected result after restart: %s", s)
+	}
+	if st := s.Stats(); st.Get("seriesCollected") != 1 {
+		t.Fatalf("unexpected collected series: %d", st.Get("seriesCollected"))
+	}
+}
+
+// Ensure series whose points were copied into a shard without being written aren't deleted.
+func TestServer_CollectSeries_PointsInStore(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"host": "servera"}, Timestamp: time.Now().UTC(), Values: map[string]interface{}{"value": float64(10)}}})
+
+	// The shards points were written to are forgotten on restart.
+	s.Restart()
+	for i := 0; i < 2; i++ {
+		if err := s.CollectSeries(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if res := s.ExecuteQuery(MustParseQuery(`SHOW SERIES CARDINALITY`), "foo", nil).Results[0]; res.Err != nil {
+		t.Fatal(res.Err)
+	} else if s := mustMarshalJSON(res); s != `{"rows":[{"name":"cpu","columns":["count"],"values":[[1]]}]}` {
+		t.Fatalf("unexpected result: %s", s)
+	}
+}
+
+// Ensure retention policy enforcement stops checking once stopped.
+func TestServer_StopRetentionPolicyEnforcement(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+
+	// Count the sweeps requested by enforcement.
+	var n int
+	c.PublishFunc = func(m *messaging.Message) (uint64, error) {
+		n++
+		return c.send(m)
+	}
+
+	if err := s.StartRetentionPolicyEnforcement(time.Millisecond); err != nil {
+		t.Fatal(err)
+	} else if err := s.StartRetentionPolicyEnforcement(time.Millisecond); err == nil {
+		t.Fatal("expected error starting enforcement twice")
+	}
+	time.Sleep(10 * time.Millisecond)
+	s.StopRetentionPolicyEnforcement()
+
+	// No sweeps are requested after enforcement stops.
+	prev := n
+	time.Sleep(10 * time.Millisecond)
+	if prev == 0 {
+		t.Fatal("expected retention sweeps")
+	} else if n != prev {
+		t.Fatalf("unexpected sweeps after stop: %d", n-prev)
+	}
+}
+
+// Ensure retention policies with an infinite duration never delete data.
+func TestServer_EnforceRetentionPolicies_Infinite(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.InfiniteShardGroupDuration = 30 * 24 * time.Hour
+	s.CreateDatabase("foo")
+	if err := s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "forever"}); err != nil {
+		t.Fatal(err)
+	} else if rp, _ := s.RetentionPolicy("foo", "forever"); rp.ShardGroupDuration != 30*24*time.Hour {
+		t.Fatalf("unexpected shard group duration: %s", rp.ShardGroupDuration)
+	}
+	s.CreateShardGroupIfNotExists("foo", "forever", mustParseTime("2000-01-01T00:00:00Z"))
+
+	s.EnforceRetentionPolicies()
+	if g, err := s.ShardGroups("foo"); err != nil {
+		t.Fatal(err)
+	} else if len(g) != 1 {
+		t.Fatalf("expected 1 shard group but found %d", len(g))
+	} else if d := g[0].Duration(); d != 30*24*time.Hour {
+		t.Fatalf("unexpected shard group duration: %s", d)
+	}
+}
+
+// Ensure the server reports expired shard groups without deleting them.
+func TestServer_RetentionPolicyReport(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "mypolicy", Duration: 30 * time.Minute})
+	s.CreateShardGroupIfNotExists("foo", "mypolicy", time.Now().Add(-3*24*time.Hour))
+	s.CreateShardGroupIfNotExists("foo", "mypolicy", time.Now().Add(time.Hour))
+
+	r, err := s.RetentionPolicyReport()
+	if err != nil {
+		t.Fatal(err)
+	} else if len(r.ShardGroups) != 1 {
+		t.Fatalf("unexpected shard group count: %d", len(r.ShardGroups))
+	} else if e := r.ShardGroups[0]; e.Database != "foo" || e.Policy != "mypolicy" || e.Size <= 0 {
+		t.Fatalf("unexpected shard group: %#v", e)
+	} else if r.Size != r.ShardGroups[0].Size {
+		t.Fatalf("unexpected size: %d", r.Size)
+	}
+
+	// Nothing has been deleted.
+	if g, err := s.ShardGroups("foo"); err != nil {
+		t.Fatal(err)
+	} else if len(g) != 2 {
+		t.Fatalf("expected 2 shard groups but found %d", len(g))
+	}
+}
+
+// Ensure the database can write data to the database.
+func TestServer_WriteSeries(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "mypolicy", Duration: 1 * time.Hour})
+	s.CreateUser("susy", "pass", false)
+
+	// Check if a topic is being subscribed to.
+	var subscribed bool
+	c.SubscribeFunc = func(replicaID, topicID uint64) error {
+		subscribed = true
+		return nil
+	}
+
+	// Write series with one point to the database.
+	tags := map[string]string{"host": "servera.influx.com", "region": "uswest"}
+	index, err := s.WriteSeries("foo", "mypolicy", []influxdb.Point{{Name: "cpu_load", Tags: tags, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(23.2)}}})
+	if err != nil {
+		t.Fatal(err)
+	} else if err = s.Sync(index); err != nil {
+		t.Fatalf("sync error: %s", err)
+	}
+
+	// Write another point 10 seconds later so it goes through "raw series".
+	index, err = s.WriteSeries("foo", "mypolicy", []influxdb.Point{{Name: "cpu_load", Tags: tags, Timestamp: mustParseTime("2000-01-01T00:00:10Z"), Values: map[string]interface{}{"value": float64(100)}}})
+	if err != nil {
+		t.Fatal(err)
+	} else if err = s.Sync(index); err != nil {
+		t.Fatalf("sync error: %s", err)
+	}
+
+	// Verify a subscription was made.
+	if !subscribed {
+		t.Fatal("expected subscription")
+	}
+
+	// Retrieve first series data point.
+	if v, err := s.ReadSeries("foo", "mypolicy", "cpu_load", tags, mustParseTime("2000-01-01T00:00:00Z")); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(v, map[string]interface{}{"value": float64(23.2)}) {
+		t.Fatalf("values mismatch: %#v", v)
+	}
+
+	// Retrieve second series data point.
+	if v, err := s.ReadSeries("foo", "mypolicy", "cpu_load", tags, mustParseTime("2000-01-01T00:00:10Z")); err != nil {
+		t.Fatal(err)
+	} else if mustMarshalJSON(v) != mustMarshalJSON(map[string]interface{}{"value": float64(100)}) {
+		t.Fatalf("values mismatch: %#v", v)
+	}
+
+	// Retrieve non-existent series data point.
+	if v, err := s.ReadSeries("foo", "mypolicy", "cpu_load", tags, mustParseTime("2000-01-01T00:01:00Z")); err != nil {
+		t.Fatal(err)
+	} else if v != nil {
+		t.Fatalf("expected nil values: %#v", v)
+	}
+}
+
+// Ensure the server can execute a query and return the data correctly.
+func TestServer_ExecuteQuery(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.CreateUser("susy", "pass", false)
+
+	// Write series with one point to the database.
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "us-east"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(20)}}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "us-east"}, Timestamp: mustParseTime("2000-01-01T00:00:10Z"), Values: map[string]interface{}{"value": float64(30)}}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "us-west"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(100)}}})
+
+	// Select data from the server.
+	results := s.ExecuteQuery(MustParseQuery(`SELECT sum(value) FROM cpu GROUP BY time(10s), region`), "foo", nil)
+	if res := results.Results[0]; res.Err != nil {
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if len(res.Rows) != 2 {
+		t.Fatalf("unexpected row count: %d", len(res.Rows))
+	} else if s := mustMarshalJSON(res); s != `{"rows":[{"name":"cpu","tags":{"region":"us-east"},"columns":["time","sum"],"values":[["2000-01-01T00:00:00Z",20],["2000-01-01T00:00:10Z",30]]},{"name":"cpu","tags":{"region":"us-west"},"columns":["time","sum"],"values":[["2000-01-01T00:00:00Z",100]]}]}` {
+		t.Fatalf("unexpected row(0): %s", s)
+	}
+
+	// Simple non-aggregation.
+	results = s.ExecuteQuery(MustParseQuery(`SELECT value FROM cpu WHERE time >= '2000-01-01 00:00:05'`), "foo", nil)
+	if res := results.Results[0]; res.Err != nil {
+		t.Fatalf("unexpected error during simple SELECT: %s", res.Err)
+	} else if s := mustMarshalJSON(res); s != `{"rows":[{"name":"cpu","columns":["time","value"],"values":[["2000-01-01T00:00:10Z",30]]}]}` {
+		t.Fatalf("unexpected row(0) during simple SELECT: %s", s)
+	}
+
+	// Sum aggregation.
+	results = s.ExecuteQuery(MustParseQuery(`SELECT sum(value) FROM cpu WHERE time >= '2000-01-01 00:00:05' GROUP BY time(10s), region`), "foo", nil)
+	if res := results.Results[0]; res.Err != nil {
+		t.Fatalf("unexpected error during SUM: %s", res.Err)
+	} else if s := mustMarshalJSON(res); s != `{"rows":[{"name":"cpu","tags":{"region":"us-east"},"columns":["time","sum"],"values":[["2000-01-01T00:00:10Z",30]]}]}` {
+		t.Fatalf("unexpected row(0) during SUM: %s", s)
+	}
+
+	// Aggregation with a null field value
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "us-east"}, Timestamp: mustParseTime("2000-01-01T00:00:03Z"), Values: map[string]interface{}{"otherVal": float64(20)}}})
+	// Sum aggregation.
+	results = s.ExecuteQuery(MustParseQuery(`SELECT sum(value) FROM cpu GROUP BY region`), "foo", nil)
+	if res := results.Results[0]; res.Err != nil {
+		t.Fatalf("unexpected error during SUM: %s", res.Err)
+	} else if s := mustMarshalJSON(res); s != `{"rows":[{"name":"cpu","tags":{"region":"us-east"},"columns":["time","sum"],"values":[["1970-01-01T00:00:00Z",50]]},{"name":"cpu","tags":{"region":"us-west"},"columns":["time","sum"],"values":[["1970-01-01T00:00:00Z",100]]}]}` {
+		t.Fatalf("unexpected row(0) during SUM: %s", s)
+	}
+}
+
+// Ensure the server can execute a wildcard query and return the data correctly.
+func TestServer_ExecuteWildcardQuery(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.CreateUser("susy", "pass", false)
+
+	// Write series with one point to the database.
+	// We deliberatly write one value per insert as we need to create each field in a predicatable order for testing.
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "us-east"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(10)}}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "us-east"}, Timestamp: mustParseTime("2000-01-01T00:00:10Z"), Values: map[string]interface{}{"val-x": 20}}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "us-east"}, Timestamp: mustParseTime("2000-01-01T00:00:20Z"), Values: map[string]interface{}{"value": 30, "val-x": 40}}})
+
+	// Select * (wildcard).
+	results := s.ExecuteQuery(MustParseQuery(`SELECT * FROM cpu`), "foo", nil)
+	if res := results.Results[0]; res.Err != nil {
+		t.Fatalf("unexpected error during SELECT *: %s", res.Err)
+	} else if s := mustMarshalJSON(res); s != `{"rows":[{"name":"cpu","tags":{"region":"us-east"},"columns":["time","value","val-x"],"values":[["2000-01-01T00:00:00Z",10,null],["2000-01-01T00:00:10Z",null,20],["2000-01-01T00:00:20Z",30,40]]}]}` {
+		t.Fatalf("unexpected results during SELECT *: %s", s)
+	}
+
+	// Select * with another field.
+	results = s.ExecuteQuery(MustParseQuery(`SELECT *, value FROM cpu`), "foo", nil)
+	if res := results.Results[0]; res.Err != nil {
+		t.Fatalf("unexpected error during SELECT *, value: %s", res.Err)
+	} else if s := mustMarshalJSON(res); s != `{"rows":[{"name":"cpu","tags":{"region":"us-east"},"columns":["time","value","val-x","value"],"values":[["2000-01-01T00:00:00Z",10,null,10],["2000-01-01T00:00:10Z",null,20,null],["2000-01-01T00:00:20Z",30,40,30]]}]}` {
+		t.Fatalf("unexpected results during SELECT *, value: %s", s)
+	}
+
+	// Group by all tags with a wildcard dimension.
+	results = s.ExecuteQuery(MustParseQuery(`SELECT sum(value) FROM cpu GROUP BY *`), "foo", nil)
+	if res := results.Results[0]; res.Err != nil {
+		t.Fatalf("unexpected error during GROUP BY *: %s", res.Err)
+	} else if s := mustMarshalJSON(res); s != `{"rows":[{"name":"cpu","tags":{"region":"us-east"},"columns":["time","sum"],"values":[["1970-01-01T00:00:00Z",40]]}]}` {
+		t.Fatalf("unexpected results during GROUP BY *: %s", s)
+	}
+}
+
+// Ensure the server can group by all tags with a wildcard dimension.
+func TestServer_ExecuteQuery_GroupByWildcard(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{
+		{Name: "cpu", Tags: map[string]string{"host": "serverA", "region": "us-east"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(10)}},
+		{Name: "cpu", Tags: map[string]string{"host": "serverA", "region": "us-east"}, Timestamp: mustParseTime("2000-01-01T00:00:10Z"), Values: map[string]interface{}{"value": float64(20)}},
+		{Name: "cpu", Tags: map[string]string{"host": "serverB", "region": "us-east"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(30)}},
+	})
+
+	// Group by a time interval and all tags.
+	results := s.ExecuteQuery(MustParseQuery(`SELECT sum(value) FROM cpu WHERE time >= '2000-01-01' AND time < '2000-01-01T00:01:00Z' GROUP BY time(1m), *`), "foo", nil)
+	if res := results.Results[0]; res.Err != nil {
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if s := mustMarshalJSON(res); s != `{"rows":[{"name":"cpu","tags":{"host":"serverA","region":"us-east"},"columns":["time","sum"],"values":[["2000-01-01T00:00:00Z",30]]},{"name":"cpu","tags":{"host":"serverB","region":"us-east"},"columns":["time","sum"],"values":[["2000-01-01T00:00:00Z",30]]}]}` {
+		t.Fatalf("unexpected results: %s", s)
+	}
+
+	// Ensure wildcards are rejected for sources other than a single measurement.
+	results = s.ExecuteQuery(MustParseQuery(`SELECT sum(value) FROM merge(cpu, mem) GROUP BY *`), "foo", nil)
+	if res := results.Results[0]; res.Err == nil || !strings.Contains(res.Err.Error(), "wildcards require a single measurement source") {
+		t.Fatalf("unexpected error: %v", res.Err)
+	}
+}
+
+// Ensure the server can filter points by their field values.
+func TestServer_ExecuteQuery_WhereFields(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{
+		{Name: "cpu", Tags: map[string]string{"host": "serverA"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(95), "load": float64(1)}},
+		{Name: "cpu", Tags: map[string]string{"host": "serverA"}, Timestamp: mustParseTime("2000-01-01T00:00:10Z"), Values: map[string]interface{}{"value": float64(50), "load": float64(2)}},
+		{Name: "cpu", Tags: map[string]string{"host": "serverB"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(99), "load": float64(3)}},
+		{Name: "cpu", Tags: map[string]string{"host": "serverB"}, Timestamp: mustParseTime("2000-01-01T00:00:10Z"), Values: map[string]interface{}{"value": float64(10), "load": float64(4)}},
+	})
+
+	for i, tt := range []struct {
+		q   string
+		exp string
+	}{
+		// A lone field condition.
+		{
+			q:   `SELECT value FROM cpu WHERE value > 96`,
+			exp: `{"rows":[{"name":"cpu","columns":["time","value"],"values":[["2000-01-01T00:00:00Z",99]]}]}`,
+		},
+		{
+			q:   `SELECT sum(value) FROM cpu WHERE value > 90 GROUP BY host`,
+			exp: `{"rows":[{"name":"cpu","tags":{"host":"serverA"},"columns":["time","sum"],"values":[["1970-01-01T00:00:00Z",95]]},{"name":"cpu","tags":{"host":"serverB"},"columns":["time","sum"],"values":[["1970-01-01T00:00:00Z",99]]}]}`,
+		},
+
+		// A condition on a field other than the one selected.
+		{
+			q:   `SELECT load FROM cpu WHERE value > 90 GROUP BY host`,
+			exp: `{"rows":[{"name":"cpu","tags":{"host":"serverA"},"columns":["time","load"],"values":[["2000-01-01T00:00:00Z",1]]},{"name":"cpu","tags":{"host":"serverB"},"columns":["time","load"],"values":[["2000-01-01T00:00:00Z",3]]}]}`,
+		},
+
+		// Field conditions combined with tags and time.
+		{
+			q:   `SELECT value FROM cpu WHERE host = 'serverB' AND value < 50 AND time >= '2000-01-01'`,
+			exp: `{"rows":[{"name":"cpu","columns":["time","value"],"values":[["2000-01-01T00:00:10Z",10]]}]}`,
+		},
+		{
+			q:   `SELECT value FROM cpu WHERE host = 'serverA' OR value < 50 GROUP BY host`,
+			exp: `{"rows":[{"name":"cpu","tags":{"host":"serverA"},"columns":["time","value"],"values":[["2000-01-01T00:00:00Z",95],["2000-01-01T00:00:10Z",50]]},{"name":"cpu","tags":{"host":"serverB"},"columns":["time","value"],"values":[["2000-01-01T00:00:10Z",10]]}]}`,
+		},
+	} {
+		results := s.ExecuteQuery(MustParseQuery(tt.q), "foo", nil)
+		if res := results.Results[0]; res.Err != nil {
+			t.Fatalf("%d. unexpected error: %s", i, res.Err)
+		} else if s := mustMarshalJSON(res); s != tt.exp {
+			t.Fatalf("%d. %s: unexpected results: %s", i, tt.q, s)
+		}
+	}
+}
+
+// Ensure the server can stream query results in chunks.
+func TestServer_ExecuteQueryStream(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "us-east"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(20)}}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "us-west"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(100)}}})
+
+	// Stream results one row at a time.
+	var chunks []string
+	if err := s.ExecuteQueryStream(MustParseQuery(`SELECT sum(value) FROM cpu GROUP BY region; SHOW DATABASES`), "foo", nil, 1, func(i int, res *influxdb.Result) error {
+		chunks = append(chunks, fmt.Sprintf("%d:%s", i, mustMarshalJSON(res)))
+		return nil
+	}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if len(chunks) != 3 {
+		t.Fatalf("unexpected chunk count: %d", len(chunks))
+	} else if chunks[0] != `0:{"rows":[{"name":"cpu","tags":{"region":"us-east"},"columns":["time","sum"],"values":[["1970-01-01T00:00:00Z",20]]}]}` {
+		t.Fatalf("unexpected chunk(0): %s", chunks[0])
+	} else if chunks[1] != `0:{"rows":[{"name":"cpu","tags":{"region":"us-west"},"columns":["time","sum"],"values":[["1970-01-01T00:00:00Z",100]]}]}` {
+		t.Fatalf("unexpected chunk(1): %s", chunks[1])
+	} else if chunks[2] != `1:{"rows":[{"columns":["name"],"values":[["foo"]]}]}` {
+		t.Fatalf("unexpected chunk(2): %s", chunks[2])
+	}
+
+	// Ensure an error from the callback stops execution.
+	errStop := errors.New("stop")
+	var n int
+	if err := s.ExecuteQueryStream(MustParseQuery(`SELECT sum(value) FROM cpu GROUP BY region; SHOW DATABASES`), "foo", nil, 1, func(i int, res *influxdb.Result) error {
+		n++
+		return errStop
+	}); err != errStop {
+		t.Fatalf("unexpected error: %s", err)
+	} else if n != 1 {
+		t.Fatalf("unexpected callback count: %d", n)
+	}
+}
+
+// Ensure a query returns an error if it exceeds its timeout.
+func TestServer_ExecuteQueryTimeout(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(20)}}})
+
+	// Execute with a deadline that has already passed.
+	results := s.ExecuteQueryTimeout(MustParseQuery(`SELECT sum(value) FROM cpu; SELECT sum(value) FROM cpu`), "foo", nil, time.Nanosecond)
+	if err := results.Results[0].Err; err != influxdb.ErrQueryTimeout {
+		t.Fatalf("unexpected error(0): %s", err)
+	} else if err := results.Results[1].Err; err != influxdb.ErrNotExecuted {
+		t.Fatalf("unexpected error(1): %s", err)
+	}
+
+	// Execute with the server's default timeout.
+	s.QueryTimeout = time.Minute
+	results = s.ExecuteQuery(MustParseQuery(`SELECT sum(value) FROM cpu`), "foo", nil)
+	if res := results.Results[0]; res.Err != nil {
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if s := mustMarshalJSON(res); s != `{"rows":[{"name":"cpu","columns":["time","sum"],"values":[["1970-01-01T00:00:00Z",20]]}]}` {
+		t.Fatalf("unexpected result: %s", s)
+	}
+}
+
+// Ensure the server can explain a select statement without executing it.
+func TestServer_ExecuteQuery_Explain(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "us-east"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(20)}}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "us-east"}, Timestamp: mustParseTime("2000-01-01T00:00:10Z"), Values: map[string]interface{}{"value": float64(30)}}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "us-west"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(100)}}})
+
+	results := s.ExecuteQuery(MustParseQuery(`EXPLAIN SELECT sum(value) FROM cpu WHERE region = 'us-east' AND time >= '2000-01-01 00:00:00' AND time < '2000-01-01 00:01:00'`), "foo", nil)
+	if res := results.Results[0]; res.Err != nil {
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if s := mustMarshalJSON(res); s != `{"rows":[{"name":"plan","columns":["measurement","start_time","end_time","shards","series","points"],"values":[["\"foo\".\"raw\".\"cpu\"","2000-01-01T00:00:00Z","2000-01-01T00:00:59.999999Z",1,1,2]]},{"name":"shards","columns":["id","start_time","end_time","points"],"values":[[1,"2000-01-01T00:00:00Z","2000-01-01T01:00:00Z",2]]}]}` {
+		t.Fatalf("unexpected result: %s", s)
+	}
+
+	// Ensure invalid statements are not explained.
+	results = s.ExecuteQuery(MustParseQuery(`EXPLAIN SELECT sum(value) FROM no_such_measurement`), "foo", nil)
+	if res := results.Results[0]; res.Err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+// Ensure the server can execute a SELECT INTO query and write the results to the target.
+func TestServer_ExecuteQuery_SelectInto(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "archive", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "us-east"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(20)}}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "us-east"}, Timestamp: mustParseTime("2000-01-01T00:00:05Z"), Values: map[string]interface{}{"value": float64(30)}}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "us-west"}, Timestamp: mustParseTime("2000-01-01T00:00:10Z"), Values: map[string]interface{}{"value": float64(100)}}})
+
+	// Downsample into a measurement in another retention policy.
+	results := s.ExecuteQuery(MustParseQuery(`SELECT sum(value) INTO "archive"."cpu_sum" FROM cpu GROUP BY time(10s), region`), "foo", nil)
+	if res := results.Results[0]; res.Err != nil {
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if s := mustMarshalJSON(res); s != `{"rows":[{"name":"result","columns":["time","written"],"values":[["1970-01-01T00:00:00Z",2]]}]}` {
+		t.Fatalf("unexpected result: %s", s)
+	}
+
+	// Verify the points were written to the target.
+	results = s.ExecuteQuery(MustParseQuery(`SELECT sum FROM "archive"."cpu_sum" GROUP BY region`), "foo", nil)
+	if res := results.Results[0]; res.Err != nil {
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if s := mustMarshalJSON(res); s != `{"rows":[{"name":"cpu_sum","tags":{"region":"us-east"},"columns":["time","sum"],"values":[["2000-01-01T00:00:00Z",50]]},{"name":"cpu_sum","tags":{"region":"us-west"},"columns":["time","sum"],"values":[["2000-01-01T00:00:10Z",100]]}]}` {
+		t.Fatalf("unexpected rows: %s", s)
+	}
+
+	// Writing into a non-existent retention policy should return an error.
+	results = s.ExecuteQuery(MustParseQuery(`SELECT sum(value) INTO "no_such_rp"."cpu_sum" FROM cpu GROUP BY time(10s)`), "foo", nil)
+	if err := results.Results[0].Err; err == nil || err.Error() != "retention policy does not exist: foo.no_such_rp" {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+// Ensure the server can execute a query in descending time order.
+func TestServer_ExecuteQuery_OrderByTimeDesc(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"host": "servera"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(10)}}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"host": "serverb"}, Timestamp: mustParseTime("2000-01-01T00:00:05Z"), Values: map[string]interface{}{"value": float64(20)}}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"host": "servera"}, Timestamp: mustParseTime("2000-01-01T00:00:10Z"), Values: map[string]interface{}{"value": float64(30)}}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"host": "serverb"}, Timestamp: mustParseTime("2000-01-01T00:00:25Z"), Values: map[string]interface{}{"value": float64(40)}}})
+
+	for i, tt := range []struct {
+		q   string
+		exp string
+	}{
+		{
+			q:   `SELECT value FROM cpu`,
+			exp: `{"rows":[{"name":"cpu","columns":["time","value"],"values":[["2000-01-01T00:00:00Z",10],["2000-01-01T00:00:05Z",20],["2000-01-01T00:00:10Z",30],["2000-01-01T00:00:25Z",40]]}]}`,
+		},
+		{
+			q:   `SELECT value FROM cpu ORDER BY time DESC`,
+			exp: `{"rows":[{"name":"cpu","columns":["time","value"],"values":[["2000-01-01T00:00:25Z",40],["2000-01-01T00:00:10Z",30],["2000-01-01T00:00:05Z",20],["2000-01-01T00:00:00Z",10]]}]}`,
+		},
+		{
+			q:   `SELECT value FROM cpu ORDER BY time DESC LIMIT 1`,
+			exp: `{"rows":[{"name":"cpu","columns":["time","value"],"values":[["2000-01-01T00:00:25Z",40]]}]}`,
+		},
+		{
+			q:   `SELECT value FROM cpu GROUP BY host ORDER BY time DESC LIMIT 1 OFFSET 1`,
+			exp: `{"rows":[{"name":"cpu","tags":{"host":"servera"},"columns":["time","value"],"values":[["2000-01-01T00:00:00Z",10]]},{"name":"cpu","tags":{"host":"serverb"},"columns":["time","value"],"values":[["2000-01-01T00:00:05Z",20]]}]}`,
+		},
+		{
+			q:   `SELECT sum(value) FROM cpu WHERE time >= '2000-01-01T00:00:00Z' AND time < '2000-01-01T00:00:30Z' GROUP BY time(10s) ORDER BY time DESC`,
+			exp: `{"rows":[{"name":"cpu","columns":["time","sum"],"values":[["2000-01-01T00:00:20Z",40],["2000-01-01T00:00:10Z",30],["2000-01-01T00:00:00Z",30]]}]}`,
+		},
+	} {
+		results := s.ExecuteQuery(MustParseQuery(tt.q), "foo", nil)
+		if res := results.Results[0]; res.Err != nil {
+			t.Fatalf("%d. unexpected error: %s", i, res.Err)
+		} else if s := mustMarshalJSON(res); s != tt.exp {
+			t.Fatalf("%d. unexpected result: %s", i, s)
+		}
+	}
+}
+
+// Ensure the server includes points at the end of an inclusive time range and
+// applies LIMIT and OFFSET to the values of each row.
+func TestServer_ExecuteQuery_LimitOffset(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"host": "servera"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(10)}}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"host": "serverb"}, Timestamp: mustParseTime("2000-01-01T00:00:05Z"), Values: map[string]interface{}{"value": float64(20)}}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"host": "servera"}, Timestamp: mustParseTime("2000-01-01T00:00:10Z"), Values: map[string]interface{}{"value": float64(30)}}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"host": "serverb"}, Timestamp: mustParseTime("2000-01-01T00:00:25Z"), Values: map[string]interface{}{"value": float64(40)}}})
+
+	for i, tt := range []struct {
+		q   string
+		exp string
+	}{
+		// A point exactly at the end of the time range is included.
+		{
+			q:   `SELECT value FROM cpu WHERE time <= '2000-01-01T00:00:10Z'`,
+			exp: `{"rows":[{"name":"cpu","columns":["time","value"],"values":[["2000-01-01T00:00:00Z",10],["2000-01-01T00:00:05Z",20],["2000-01-01T00:00:10Z",30]]}]}`,
+		},
+		{
+			q:   `SELECT value FROM cpu WHERE time <= '2000-01-01T00:00:10Z' ORDER BY time DESC`,
+			exp: `{"rows":[{"name":"cpu","columns":["time","value"],"values":[["2000-01-01T00:00:10Z",30],["2000-01-01T00:00:05Z",20],["2000-01-01T00:00:00Z",10]]}]}`,
+		},
+		{
+			q:   `SELECT value FROM cpu WHERE time < '2000-01-01T00:00:10Z'`,
+			exp: `{"rows":[{"name":"cpu","columns":["time","value"],"values":[["2000-01-01T00:00:00Z",10],["2000-01-01T00:00:05Z",20]]}]}`,
+		},
+
+		// LIMIT and OFFSET apply to the values of each row in time order.
+		{
+			q:   `SELECT value FROM cpu LIMIT 2 OFFSET 1`,
+			exp: `{"rows":[{"name":"cpu","columns":["time","value"],"values":[["2000-01-01T00:00:05Z",20],["2000-01-01T00:00:10Z",30]]}]}`,
+		},
+		{
+			q:   `SELECT value FROM cpu GROUP BY host LIMIT 1 OFFSET 1`,
+			exp: `{"rows":[{"name":"cpu","tags":{"host":"servera"},"columns":["time","value"],"values":[["2000-01-01T00:00:10Z",30]]},{"name":"cpu","tags":{"host":"serverb"},"columns":["time","value"],"values":[["2000-01-01T00:00:25Z",40]]}]}`,
+		},
+		{
+			q:   `SELECT value FROM cpu GROUP BY host LIMIT 1 OFFSET 2`,
+			exp: `{}`,
+		},
+	} {
+		results := s.ExecuteQuery(MustParseQuery(tt.q), "foo", nil)
+		if res := results.Results[0]; res.Err != nil {
+			t.Fatalf("%d. unexpected error: %s", i, res.Err)
+		} else if s := mustMarshalJSON(res); s != tt.exp {
+			t.Fatalf("%d. unexpected result: %s", i, s)
+		}
+	}
+}
+
+// Ensure the planner reads a shard group whose time range contains the query's time range.
+func TestServer_ExecuteQuery_WithinShardGroup(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Timestamp: mustParseTime("2000-01-01T00:30:00Z"), Values: map[string]interface{}{"value": float64(10)}}})
+
+	// The group starts before and ends after the queried range.
+	results := s.ExecuteQuery(MustParseQuery(`SELECT value FROM cpu WHERE time >= '2000-01-01T00:10:00Z' AND time <= '2000-01-01T00:50:00Z'`), "foo", nil)
+	if res := results.Results[0]; res.Err != nil {
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if s := mustMarshalJSON(res); s != `{"rows":[{"name":"cpu","columns":["time","value"],"values":[["2000-01-01T00:30:00Z",10]]}]}` {
+		t.Fatalf("unexpected result: %s", s)
+	}
+}
+
+// Ensure the server can filter and paginate tag keys.
+func TestServer_ExecuteQuery_ShowTagKeys(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"host": "servera", "region": "us-east"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(10)}}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"host": "serverb", "rack": "r1"}, Timestamp: mustParseTime("2000-01-01T00:00:10Z"), Values: map[string]interface{}{"value": float64(20)}}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "gpu", Tags: map[string]string{"host": "servera", "zone": "a"}, Timestamp: mustParseTime("2000-01-01T00:00:20Z"), Values: map[string]interface{}{"value": float64(30)}}})
+
+	for i, tt := range []struct {
+		q   string
+		exp string
+	}{
+		{
+			q:   `SHOW TAG KEYS`,
+			exp: `{"rows":[{"name":"cpu","columns":["tagKey"],"values":[["host"],["rack"],["region"]]},{"name":"gpu","columns":["tagKey"],"values":[["host"],["zone"]]}]}`,
+		},
+		{
+			q:   `SHOW TAG KEYS WHERE host = 'servera'`,
+			exp: `{"rows":[{"name":"cpu","columns":["tagKey"],"values":[["host"],["region"]]},{"name":"gpu","columns":["tagKey"],"values":[["host"],["zone"]]}]}`,
+		},
+		{
+			q:   `SHOW TAG KEYS FROM cpu WHERE time >= '2000-01-01T00:00:05Z' AND time <= '2000-01-01T00:00:30Z'`,
+			exp: `{"rows":[{"name":"cpu","columns":["tagKey"],"values":[["host"],["rack"]]}]}`,
+		},
+		{
+			q:   `SHOW TAG KEYS WHERE host = 'servera' AND time > '2000-01-01T00:00:05Z' AND time <= '2000-01-01T00:00:30Z'`,
+			exp: `{"rows":[{"name":"gpu","columns":["tagKey"],"values":[["host"],["zone"]]}]}`,
+		},
+		{
+			q:   `SHOW TAG KEYS LIMIT 1 OFFSET 1`,
+			exp: `{"rows":[{"name":"cpu","columns":["tagKey"],"values":[["rack"]]},{"name":"gpu","columns":["tagKey"],"values":[["zone"]]}]}`,
+		},
+	} {
+		results := s.ExecuteQuery(MustParseQuery(tt.q), "foo", nil)
+		if res := results.Results[0]; res.Err != nil {
+			t.Fatalf("%d. unexpected error: %s", i, res.Err)
+		} else if s := mustMarshalJSON(res); s != tt.exp {
+			t.Fatalf("%d. unexpected result: %s", i, s)
+		}
+	}
+}
+
+// Ensure the server can count the series and measurements in a database.
+func TestServer_ExecuteQuery_ShowCardinality(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"host": "servera", "region": "us-east"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(10)}}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"host": "serverb", "region": "us-west"}, Timestamp: mustParseTime("2000-01-01T00:00:10Z"), Values: map[string]interface{}{"value": float64(20)}}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"host": "serverc", "region": "us-west"}, Timestamp: mustParseTime("2000-01-01T00:00:20Z"), Values: map[string]interface{}{"value": float64(30)}}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "gpu", Tags: map[string]string{"host": "servera"}, Timestamp: mustParseTime("2000-01-01T00:00:30Z"), Values: map[string]interface{}{"value": float64(40)}}})
+
+	for i, tt := range []struct {
+		q   string
+		exp string
+	}{
+		{
+			q:   `SHOW SERIES CARDINALITY`,
+			exp: `{"rows":[{"name":"cpu","columns":["count"],"values":[[3]]},{"name":"gpu","columns":["count"],"values":[[1]]}]}`,
+		},
+		{
+			q:   `SHOW SERIES EXACT CARDINALITY`,
+			exp: `{"rows":[{"name":"cpu","columns":["count"],"values":[[3]]},{"name":"gpu","columns":["count"],"values":[[1]]}]}`,
+		},
+		{
+			q:   `SHOW SERIES CARDINALITY FROM gpu`,
+			exp: `{"rows":[{"name":"gpu","columns":["count"],"values":[[1]]}]}`,
+		},
+		{
+			q:   `SHOW SERIES CARDINALITY WHERE region = 'us-west'`,
+			exp: `{"rows":[{"name":"cpu","columns":["count"],"values":[[2]]}]}`,
+		},
+		{
+			q:   `SHOW MEASUREMENT CARDINALITY`,
+			exp: `{"rows":[{"name":"measurements","columns":["count"],"values":[[2]]}]}`,
+		},
+		{
+			q:   `SHOW MEASUREMENT EXACT CARDINALITY`,
+			exp: `{"rows":[{"name":"measurements","columns":["count"],"values":[[2]]}]}`,
+		},
+		{
+			q:   `SHOW MEASUREMENT CARDINALITY WHERE region = 'us-west'`,
+			exp: `{"rows":[{"name":"measurements","columns":["count"],"values":[[1]]}]}`,
+		},
+	} {
+		results := s.ExecuteQuery(MustParseQuery(tt.q), "foo", nil)
+		if res := results.Results[0]; res.Err != nil {
+			t.Fatalf("%d. unexpected error: %s", i, res.Err)
+		} else if s := mustMarshalJSON(res); s != tt.exp {
+			t.Fatalf("%d. unexpected result: %s", i, s)
+		}
+	}
+}
+
+// Ensure the server can filter series by matching tag values against a regex.
+func TestServer_ExecuteQuery_TagRegex(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"host": "web-01"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(10)}}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"host": "web-02"}, Timestamp: mustParseTime("2000-01-01T00:00:10Z"), Values: map[string]interface{}{"value": float64(20)}}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"host": "db-01"}, Timestamp: mustParseTime("2000-01-01T00:00:20Z"), Values: map[string]interface{}{"value": float64(30)}}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "gpu", Tags: map[string]string{"host": "db-02"}, Timestamp: mustParseTime("2000-01-01T00:00:30Z"), Values: map[string]interface{}{"value": float64(40)}}})
+
+	for i, tt := range []struct {
+		q   string
+		exp string
+	}{
+		{
+			q:   `SELECT sum(value) FROM cpu WHERE host =~ /^web-/ AND time >= '2000-01-01 00:00:00' AND time < '2000-01-01 00:01:00'`,
+			exp: `{"rows":[{"name":"cpu","columns":["time","sum"],"values":[["1970-01-01T00:00:00Z",30]]}]}`,
+		},
+		{
+			q:   `SELECT sum(value) FROM cpu WHERE host !~ /^web-/ AND time >= '2000-01-01 00:00:00' AND time < '2000-01-01 00:01:00'`,
+			exp: `{"rows":[{"name":"cpu","columns":["time","sum"],"values":[["1970-01-01T00:00:00Z",30]]}]}`,
+		},
+		{
+			q:   `SHOW SERIES WHERE host =~ /-01$/`,
+			exp: `{"rows":[{"name":"cpu","columns":["host"],"values":[["web-01"],["db-01"]]}]}`,
+		},
+		{
+			q:   `SHOW MEASUREMENTS WHERE host =~ /^db-/`,
+			exp: `{"rows":[{"name":"measurements","columns":["name"],"values":[["cpu"],["gpu"]]}]}`,
+		},
+	} {
+		results := s.ExecuteQuery(MustParseQuery(tt.q), "foo", nil)
+		if res := results.Results[0]; res.Err != nil {
+			t.Fatalf("%d. unexpected error: %s", i, res.Err)
+		} else if s := mustMarshalJSON(res); s != tt.exp {
+			t.Fatalf("%d. unexpected result: %s", i, s)
+		}
+	}
+}
+
+// Ensure the server returns the same results when series are loaded from the metastore as needed.
+func TestServer_SeriesIndexCacheSize(t *testing.T) {
+	other := OpenServer(NewMessagingClient())
+	defer other.Close()
+	s := NewServer()
+	defer s.Close()
+	s.SeriesIndexCacheSize = 2
+	if err := s.Server.Open(tempfile()); err != nil {
+		t.Fatal(err)
+	} else if err := s.SetClient(NewMessagingClient()); err != nil {
+		t.Fatal(err)
+	} else if err := s.Initialize(&url.URL{Host: "127.0.0.1:8080"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, s := range []*Server{s, other} {
+		s.CreateDatabase("foo")
+		s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+		s.SetDefaultRetentionPolicy("foo", "raw")
+
+		// Write points one at a time so both servers assign the same series ids.
+		for _, p := range []influxdb.Point{
+			{Name: "cpu", Tags: map[string]string{"host": "servera", "region": "us-east"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(10)}},
+			{Name: "cpu", Tags: map[string]string{"host": "serverb", "region": "us-west"}, Timestamp: mustParseTime("2000-01-01T00:00:10Z"), Values: map[string]interface{}{"value": float64(20)}},
+			{Name: "gpu", Tags: map[string]string{"host": "servera"}, Timestamp: mustParseTime("2000-01-01T00:00:20Z"), Values: map[string]interface{}{"value": float64(30)}},
+			{Name: "mem", Tags: map[string]string{"host": "serverc"}, Timestamp: mustParseTime("2000-01-01T00:00:30Z"), Values: map[string]interface{}{"value": float64(40)}},
+		} {
+			s.MustWriteSeries("foo", "raw", []influxdb.Point{p})
+		}
+	}
+
+	// Results must match before and after the indexes are reloaded on restart.
+	for n := 0; n < 2; n++ {
+		if n > 0 {
+			s.Restart()
+		}
+		for i, q := range []string{
+			`SELECT sum(value) FROM cpu GROUP BY host`,
+			`SELECT value FROM gpu`,
+			`SELECT value FROM cpu WHERE region = 'us-west'`,
+			`SHOW SERIES`,
+			`SHOW TAG VALUES FROM mem WITH KEY = host`,
+			`SHOW MEASUREMENTS WHERE host = 'serverc'`,
+		} {
+			exp := mustMarshalJSON(other.ExecuteQuery(MustParseQuery(q), "foo", nil).Results[0])
+			if got := mustMarshalJSON(s.ExecuteQuery(MustParseQuery(q), "foo", nil).Results[0]); got != exp {
+				t.Fatalf("%d.%d. %s: unexpected result: exp=%s, got=%s", n, i, q, exp, got)
+			}
+		}
+	}
+}
+
+// Ensure the server returns the same results when shard stores are closed and
+// reopened as they're used.
+func TestServer_MaxOpenShards(t *testing.T) {
+	other := OpenServer(NewMessagingClient())
+	defer other.Close()
+	s := NewServer()
+	defer s.Close()
+	s.MaxOpenShards = 1
+	if err := s.Server.Open(tempfile()); err != nil {
+		t.Fatal(err)
+	} else if err := s.SetClient(NewMessagingClient()); err != nil {
+		t.Fatal(err)
+	} else if err := s.Initialize(&url.URL{Host: "127.0.0.1:8080"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Write points to three hourly shard groups.
+	for _, s := range []*Server{s, other} {
+		s.CreateDatabase("foo")
+		s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 24 * time.Hour, ShardGroupDuration: time.Hour})
+		s.SetDefaultRetentionPolicy("foo", "raw")
+		s.MustWriteSeries("foo", "raw", []influxdb.Point{
+			{Name: "cpu", Tags: map[string]string{"host": "servera"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(10)}},
+			{Name: "cpu", Tags: map[string]string{"host": "serverb"}, Timestamp: mustParseTime("2000-01-01T01:30:00Z"), Values: map[string]interface{}{"value": float64(20)}},
+			{Name: "cpu", Tags: map[string]string{"host": "servera"}, Timestamp: mustParseTime("2000-01-01T02:30:00Z"), Values: map[string]interface{}{"value": float64(30)}},
+		})
+	}
+
+	// Results must match before and after the shards are lazily opened on restart.
+	for n := 0; n < 2; n++ {
+		if n > 0 {
+			s.Restart()
+		}
+		for i, q := range []string{
+			`SELECT sum(value) FROM cpu GROUP BY host`,
+			`SELECT value FROM cpu`,
+			`SELECT value FROM cpu WHERE host = 'servera' AND time > '2000-01-01T00:30:00Z'`,
+		} {
+			exp := mustMarshalJSON(other.ExecuteQuery(MustParseQuery(q), "foo", nil).Results[0])
+			if got := mustMarshalJSON(s.ExecuteQuery(MustParseQuery(q), "foo", nil).Results[0]); got != exp {
+				t.Fatalf("%d.%d. %s: unexpected result: exp=%s, got=%s", n, i, q, exp, got)
+			}
+		}
+	}
+}
+
+// Ensure a shard that grows past the maximum size is split, that existing
+// series keep writing to it and that its points are still read.
+func TestServer_MaxShardSize(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.MaxShardSize = 1
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+
+	// Wait for the split requested after each write.
+	waitSplitN := func(n int64) {
+		for i := 0; s.Stats().Get("shardSplits") < n; i++ {
+			if i == 100 {
+				t.Fatalf("shard not split: n=%d", n)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	tagsA, tagsB := map[string]string{"host": "servera"}, map[string]string{"host": "serverb"}
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: tagsA, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(10)}}})
+	waitSplitN(1)
+
+	// Overwrite the point in the full shard and write a new series to the new shard.
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: tagsA, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(15)}}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: tagsB, Timestamp: mustParseTime("2000-01-01T00:00:10Z"), Values: map[string]interface{}{"value": float64(20)}}})
+	waitSplitN(2)
+
+	// Each full shard received a single series.
+	groups, _ := s.ShardGroups("foo")
+	if a := groups[0].Shards; len(a) != 3 {
+		t.Fatalf("unexpected shard count: %d", len(a))
+	} else if !a[0].Full || !a[1].Full || a[2].Full {
+		t.Fatalf("unexpected full shards: %v, %v, %v", a[0].Full, a[1].Full, a[2].Full)
+	}
+	if v, err := s.ReadSeries("foo", "raw", "cpu", tagsA, mustParseTime("2000-01-01T00:00:00Z")); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(v, map[string]interface{}{"value": float64(15)}) {
+		t.Fatalf("unexpected value: %#v", v)
+	}
+	if v, err := s.ReadSeries("foo", "raw", "cpu", tagsB, mustParseTime("2000-01-01T00:00:10Z")); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(v, map[string]interface{}{"value": float64(20)}) {
+		t.Fatalf("unexpected value: %#v", v)
+	}
+
+	// Points are read once from every shard of the group, including after a restart.
+	for n := 0; n < 2; n++ {
+		if n > 0 {
+			s.Restart()
+		}
+		results := s.ExecuteQuery(MustParseQuery(`SELECT sum(value) FROM cpu WHERE time >= '2000-01-01'`), "foo", nil)
+		if res := results.Results[0]; res.Err != nil {
+			t.Fatalf("unexpected error: %s", res.Err)
+		} else if s := mustMarshalJSON(res); s != `{"rows":[{"name":"cpu","columns":["time","sum"],"values":[["1970-01-01T00:00:00Z",35]]}]}` {
+			t.Fatalf("%d. unexpected result: %s", n, s)
+		}
 	}
-
 }
 
-// Ensure the server correctly detects when there is an admin user.
-func TestServer_AdminUserExists(t *testing.T) {
+// Ensure the server reports the statistics of each shard and their totals per database.
+func TestServer_ShardStats(t *testing.T) {
 	s := OpenServer(NewMessagingClient())
 	defer s.Close()
-
-	// A server should start up without any admin user.
-	if s.AdminUserExists() {
-		t.Fatalf("admin user unexpectedly exists at start-up")
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 24 * time.Hour, ShardGroupDuration: time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{
+		{Name: "cpu", Tags: map[string]string{"host": "servera"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(10)}},
+		{Name: "cpu", Tags: map[string]string{"host": "serverb"}, Timestamp: mustParseTime("2000-01-01T00:00:10Z"), Values: map[string]interface{}{"value": float64(20)}},
+		{Name: "cpu", Tags: map[string]string{"host": "servera"}, Timestamp: mustParseTime("2000-01-01T01:30:00Z"), Values: map[string]interface{}{"value": float64(30)}},
+	})
+	results := s.ExecuteQuery(MustParseQuery(`SELECT value FROM cpu WHERE time < '2000-01-01T01:00:00Z'`), "foo", nil)
+	if res := results.Results[0]; res.Err != nil {
+		t.Fatalf("unexpected error: %s", res.Err)
 	}
 
-	// Create a non-admin user and verify Server agrees there is no admin user.
-	if err := s.CreateUser("bert", "pass", false); err != nil {
-		t.Fatal(err)
+	// Groups are created in the order points are encoded which may vary.
+	groups, _ := s.ShardGroups("foo")
+	var id uint64
+	for _, g := range groups {
+		if g.StartTime.Equal(mustParseTime("2000-01-01T00:00:00Z")) {
+			id = g.Shards[0].ID
+		}
 	}
-	s.Restart()
-	if s.AdminUserExists() {
-		t.Fatalf("admin user unexpectedly exists")
+	st, err := s.ShardStats(id)
+	if err != nil {
+		t.Fatal(err)
+	} else if st.ShardID != id || st.Database != "foo" || st.RetentionPolicy != "raw" {
+		t.Fatalf("unexpected shard: %#v", st)
+	} else if st.Size <= 0 || st.SeriesN != 2 || st.PointsWritten != 2 || st.LastWrite.IsZero() || st.ReadN == 0 {
+		t.Fatalf("unexpected stats: %#v", st)
+	} else if _, err := s.ShardStats(id + 100); err != influxdb.ErrShardNotFound {
+		t.Fatalf("unexpected error: %v", err)
 	}
 
-	// Next, create an admin user, and ensure the Server agrees there is an admin user.
-	if err := s.CreateUser("ernie", "pass", true); err != nil {
+	if stats, err := s.DatabaseShardStats("foo"); err != nil {
 		t.Fatal(err)
+	} else if len(stats.Shards) != 2 || stats.Shards[0].ShardID > stats.Shards[1].ShardID {
+		t.Fatalf("unexpected shards: %#v", stats.Shards)
+	} else if stats.SeriesN != 3 || stats.PointsWritten != 3 || stats.Size != stats.Shards[0].Size+stats.Shards[1].Size {
+		t.Fatalf("unexpected totals: %#v", stats)
+	} else if stats.LastWrite.Before(st.LastWrite) {
+		t.Fatalf("unexpected last write: %s", stats.LastWrite)
 	}
-	s.Restart()
-	if !s.AdminUserExists() {
-		t.Fatalf("admin user does not exist")
+	if _, err := s.DatabaseShardStats("bar"); err != influxdb.ErrDatabaseNotFound {
+		t.Fatalf("unexpected error: %v", err)
 	}
 }
 
-// Ensure the server returns an error when creating an user without a name.
-func TestServer_CreateUser_ErrUsernameRequired(t *testing.T) {
+// Ensure the server reports the disk space used by each measurement.
+func TestServer_ShowMeasurementSizes(t *testing.T) {
 	s := OpenServer(NewMessagingClient())
 	defer s.Close()
-	if err := s.CreateUser("", "pass", false); err != influxdb.ErrUsernameRequired {
-		t.Fatal(err)
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+
+	var points []influxdb.Point
+	for i := 0; i < 100; i++ {
+		points = append(points, influxdb.Point{Name: "mem", Tags: map[string]string{"host": "servera"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z").Add(time.Duration(i) * time.Second), Values: map[string]interface{}{"value": float64(i)}})
 	}
-}
+	points = append(points, influxdb.Point{Name: "cpu", Tags: map[string]string{"host": "servera"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(10)}})
+	s.MustWriteSeries("foo", "raw", points)
 
-// Ensure the server returns an error when creating a duplicate user.
-func TestServer_CreateUser_ErrUserExists(t *testing.T) {
-	s := OpenServer(NewMessagingClient())
-	defer s.Close()
-	if err := s.CreateUser("susy", "pass", false); err != nil {
+	groups, _ := s.ShardGroups("foo")
+	if st, err := s.ShardStats(groups[0].Shards[0].ID); err != nil {
 		t.Fatal(err)
+	} else if st.Measurements["mem"] <= st.Measurements["cpu"] || st.Measurements["cpu"] <= 0 {
+		t.Fatalf("unexpected measurement sizes: %v", st.Measurements)
 	}
-	if err := s.CreateUser("susy", "pass", false); err != influxdb.ErrUserExists {
-		t.Fatal(err)
+
+	res := s.ExecuteQuery(MustParseQuery(`SHOW MEASUREMENT SIZES`), "foo", nil).Results[0]
+	if res.Err != nil {
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if len(res.Rows) != 1 || len(res.Rows[0].Values) != 2 {
+		t.Fatalf("unexpected rows: %s", mustMarshalJSON(res))
+	} else if values := res.Rows[0].Values; values[0][0] != "mem" || values[1][0] != "cpu" {
+		t.Fatalf("unexpected order: %s", mustMarshalJSON(res))
 	}
 }
 
-// Ensure the server can delete an existing user.
-func TestServer_DeleteUser(t *testing.T) {
+// Ensure the server reclaims the space left in shards by overwritten points.
+func TestServer_CompactShards(t *testing.T) {
 	s := OpenServer(NewMessagingClient())
 	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
 
-	// Create a user.
-	if err := s.CreateUser("susy", "pass", false); err != nil {
+	// Overwrite large values with small ones.
+	tags := map[string]string{"host": "servera"}
+	for _, v := range []string{strings.Repeat("x", 1000), "y"} {
+		for i := 0; i < 200; i++ {
+			s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: tags, Timestamp: mustParseTime("2000-01-01T00:00:00Z").Add(time.Duration(i) * time.Second), Values: map[string]interface{}{"status": v}}})
+		}
+	}
+	groups, _ := s.ShardGroups("foo")
+	id := groups[0].Shards[0].ID
+	before, _ := s.ShardStats(id)
+
+	if n, err := s.CompactShards(); err != nil {
 		t.Fatal(err)
-	} else if s.User("susy") == nil {
-		t.Fatalf("user not created")
+	} else if n <= 0 {
+		t.Fatalf("unexpected reclaimed bytes: %d", n)
+	} else if after, _ := s.ShardStats(id); after.Size >= before.Size {
+		t.Fatalf("shard not compacted: %d >= %d", after.Size, before.Size)
+	} else if n := s.Stats().Get("shardCompactBytes"); n <= 0 {
+		t.Fatalf("unexpected stat: %d", n)
 	}
 
-	// Delete the user.
-	if err := s.DeleteUser("susy"); err != nil {
+	// The shard keeps its points and can still be written to.
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: tags, Timestamp: mustParseTime("2000-01-01T00:10:00Z"), Values: map[string]interface{}{"status": "z"}}})
+	results := s.ExecuteQuery(MustParseQuery(`SELECT count(status) FROM cpu WHERE time >= '2000-01-01'`), "foo", nil)
+	if res := results.Results[0]; res.Err != nil {
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if s := mustMarshalJSON(res); s != `{"rows":[{"name":"cpu","columns":["time","count"],"values":[["1970-01-01T00:00:00Z",201]]}]}` {
+		t.Fatalf("unexpected result: %s", s)
+	}
+	if v, err := s.ReadSeries("foo", "raw", "cpu", tags, mustParseTime("2000-01-01T00:00:00Z")); err != nil {
 		t.Fatal(err)
-	} else if s.User("susy") != nil {
-		t.Fatalf("user not actually deleted")
+	} else if !reflect.DeepEqual(v, map[string]interface{}{"status": "y"}) {
+		t.Fatalf("values mismatch: %#v", v)
 	}
-	s.Restart()
 
-	if s.User("susy") != nil {
-		t.Fatalf("user not actually deleted after restart")
+	// A compacted shard isn't rewritten again.
+	if n, err := s.CompactShards(); err != nil {
+		t.Fatal(err)
+	} else if n != 0 {
+		t.Fatalf("unexpected reclaimed bytes: %d", n)
 	}
 }
 
-// Ensure the server can return a list of all users.
-func TestServer_Users(t *testing.T) {
+// Ensure a shard that fails to open doesn't stop the others from loading and
+// that lazily opened shards are opened when they're read.
+func TestServer_OpenShards(t *testing.T) {
+	for _, lazy := range []bool{false, true} {
+		s := NewServer()
+		s.LazyShardOpen = lazy
+		if err := s.Server.Open(tempfile()); err != nil {
+			t.Fatal(err)
+		} else if err := s.SetClient(NewMessagingClient()); err != nil {
+			t.Fatal(err)
+		} else if err := s.Initialize(&url.URL{Host: "127.0.0.1:8080"}); err != nil {
+			t.Fatal(err)
+		}
+		defer s.Close()
+
+		s.CreateDatabase("foo")
+		s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 24 * time.Hour, ShardGroupDuration: time.Hour})
+		s.SetDefaultRetentionPolicy("foo", "raw")
+		tags := map[string]string{"host": "servera"}
+		s.MustWriteSeries("foo", "raw", []influxdb.Point{
+			{Name: "cpu", Tags: tags, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(10)}},
+			{Name: "cpu", Tags: tags, Timestamp: mustParseTime("2000-01-01T01:30:00Z"), Values: map[string]interface{}{"value": float64(20)}},
+		})
+
+		// Corrupt the second shard's store while the server is closed.
+		path, client := s.Path(), s.Client()
+		var id uint64
+		a, _ := s.ShardGroups("foo")
+		for _, g := range a {
+			if g.StartTime.Equal(mustParseTime("2000-01-01T01:00:00Z")) {
+				id = g.Shards[0].ID
+			}
+		}
+		if err := s.Server.Close(); err != nil {
+			t.Fatal(err)
+		} else if err := ioutil.WriteFile(filepath.Join(path, "shards", fmt.Sprint(id)), make([]byte, 4096), 0600); err != nil {
+			t.Fatal(err)
+		}
+		errN := s.Stats().Get("shardOpenErrors")
+		if err := s.Server.Open(path); err != nil {
+			t.Fatalf("lazy=%v: unexpected error: %s", lazy, err)
+		} else if err := s.Server.SetClient(client); err != nil {
+			t.Fatal(err)
+		}
+
+		// Only eagerly opened shards fail when the server loads.
+		if n := s.Stats().Get("shardOpenErrors") - errN; (lazy && n != 0) || (!lazy && n != 1) {
+			t.Fatalf("lazy=%v: unexpected shard open errors: %d", lazy, n)
+		}
+		if v, err := s.ReadSeries("foo", "raw", "cpu", tags, mustParseTime("2000-01-01T00:00:00Z")); err != nil {
+			t.Fatalf("lazy=%v: unexpected error: %s", lazy, err)
+		} else if !reflect.DeepEqual(v, map[string]interface{}{"value": float64(10)}) {
+			t.Fatalf("lazy=%v: values mismatch: %#v", lazy, v)
+		}
+		if _, err := s.ReadSeries("foo", "raw", "cpu", tags, mustParseTime("2000-01-01T01:30:00Z")); err == nil {
+			t.Fatalf("lazy=%v: expected error reading corrupt shard", lazy)
+		}
+	}
+}
+
+// Ensure the server can return its internal statistics.
+func TestServer_ExecuteQuery_ShowStats(t *testing.T) {
 	s := OpenServer(NewMessagingClient())
 	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.CreateDatabase("bar")
 
-	// Create some users.
-	s.CreateUser("susy", "pass", false)
-	s.CreateUser("john", "pass", false)
-	s.Restart()
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{
+		{Name: "cpu", Tags: map[string]string{"host": "servera"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(10)}},
+		{Name: "cpu", Tags: map[string]string{"host": "serverb"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(20)}},
+	})
+	if st := s.Stats(); st.Get("writeSeriesReq") != 1 || st.Get("pointsWritten") != 2 {
+		t.Fatalf("unexpected write stats: writeSeriesReq=%d, pointsWritten=%d", st.Get("writeSeriesReq"), st.Get("pointsWritten"))
+	}
 
-	// Return the users.
-	if a := s.Users(); len(a) != 2 {
-		t.Fatalf("unexpected user count: %d", len(a))
-	} else if a[0].Name != "john" {
-		t.Fatalf("unexpected user(0): %s", a[0].Name)
-	} else if a[1].Name != "susy" {
-		t.Fatalf("unexpected user(1): %s", a[1].Name)
+	results := s.ExecuteQuery(MustParseQuery(`SHOW STATS`), "foo", nil)
+	if res := results.Results[0]; res.Err != nil {
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if len(res.Rows) != 2 {
+		t.Fatalf("unexpected row count: %d", len(res.Rows))
+	} else if s := mustMarshalJSON(res.Rows[1]); s != `{"name":"databases","columns":["name","measurements","series","shards"],"values":[["bar",0,0,0],["foo",1,2,1]]}` {
+		t.Fatalf("unexpected databases row: %s", s)
+	} else if s := mustMarshalJSON(res.Rows[0].Values); !strings.Contains(s, `["pointsWritten",2],["queryReq",1],["shards",1],["writeSeriesReq",1]`) {
+		t.Fatalf("unexpected server row: %s", s)
 	}
 }
 
-// Ensure the server does not return non-existent users
-func TestServer_NonExistingUsers(t *testing.T) {
+// Ensure the server can list data nodes and their liveness.
+func TestServer_ExecuteQuery_ShowServers(t *testing.T) {
 	s := OpenServer(NewMessagingClient())
 	defer s.Close()
 
-	// Create some users.
-	s.CreateUser("susy", "pass", false)
-	s.CreateUser("john", "pass2", false)
-	s.Restart()
-
-	// Ask for users that should not be returned.
-	u := s.User("bob")
-	if u != nil {
-		t.Fatalf("unexpected user found")
+	// Create a remote node that responds to pings and one that does not.
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/ping" {
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer ts.Close()
+	u, _ := url.Parse(ts.URL)
+	if err := s.CreateDataNode(u); err != nil {
+		t.Fatal(err)
 	}
-	u, err := s.Authenticate("susy", "wrong_password")
-	if err == nil {
-		t.Fatalf("unexpected authenticated user found")
+	if err := s.CreateDataNode(&url.URL{Scheme: "http", Host: "127.0.0.1:0"}); err != nil {
+		t.Fatal(err)
+	}
+
+	results := s.ExecuteQuery(MustParseQuery(`SHOW SERVERS`), "", nil)
+	if res := results.Results[0]; res.Err != nil {
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if s := mustMarshalJSON(res); s != `{"rows":[{"columns":["id","url","alive"],"values":[[1,"//127.0.0.1:8080",true],[2,"`+ts.URL+`",true],[3,"http://127.0.0.1:0",false]]}]}` {
+		t.Fatalf("unexpected result: %s", s)
 	}
 }
 
-// Ensure the database can create a new retention policy.
-func TestServer_CreateRetentionPolicy(t *testing.T) {
+// Ensure the server reports node liveness, shard usage and under-replicated shard groups.
+func TestServer_ExecuteQuery_ShowCluster(t *testing.T) {
 	s := OpenServer(NewMessagingClient())
 	defer s.Close()
 
-	// Create a database.
-	if err := s.CreateDatabase("foo"); err != nil {
+	// Create a remote node that reports its state and one that is down.
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/status" {
+			w.Write([]byte(`{"id":2,"index":3,"size":1000}`))
+		}
+	}))
+	defer ts.Close()
+	u, _ := url.Parse(ts.URL)
+	if err := s.CreateDataNode(u); err != nil {
 		t.Fatal(err)
 	}
-
-	// Create a retention policy on the database.
-	rp := &influxdb.RetentionPolicy{
-		Name:     "bar",
-		Duration: time.Hour,
-		ReplicaN: 2,
+	if err := s.CreateDataNode(&url.URL{Scheme: "http", Host: "127.0.0.1:0"}); err != nil {
+		t.Fatal(err)
 	}
-	if err := s.CreateRetentionPolicy("foo", rp); err != nil {
+
+	// Replicate a shard to every node.
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour, ReplicaN: 3})
+	if err := s.CreateShardGroupIfNotExists("foo", "raw", mustParseTime("2000-01-01T00:00:00Z")); err != nil {
 		t.Fatal(err)
 	}
-	s.Restart()
+	g, _ := s.ShardGroups("foo")
 
-	// Verify that the policy exists.
-	if o, err := s.RetentionPolicy("foo", "bar"); err != nil {
-		t.Fatalf("unexpected error: %s", err)
-	} else if o == nil {
-		t.Fatalf("retention policy not found")
-	} else if !reflect.DeepEqual(rp, o) {
-		t.Fatalf("retention policy mismatch: %#v", o)
+	status, err := s.ClusterStatus()
+	if err != nil {
+		t.Fatal(err)
+	} else if len(status.DataNodes) != 3 {
+		t.Fatalf("unexpected data node count: %d", len(status.DataNodes))
+	} else if n := status.DataNodes[0]; !n.Alive || n.ShardN != 1 || n.Size == 0 || n.Index != s.Index() {
+		t.Fatalf("unexpected local node: %#v", n)
+	} else if n := status.DataNodes[1]; !n.Alive || n.ShardN != 1 || n.Size != 1000 || n.Index != 3 {
+		t.Fatalf("unexpected remote node: %#v", n)
+	} else if n := status.DataNodes[2]; n.Alive || n.ShardN != 1 || n.Size != 0 {
+		t.Fatalf("unexpected down node: %#v", n)
+	} else if !reflect.DeepEqual(status.UnderReplicatedShardGroups, []*influxdb.UnderReplicatedShardGroup{{Database: "foo", Policy: "raw", ID: g[0].ID, ReplicaN: 3, LiveReplicaN: 2}}) {
+		t.Fatalf("unexpected under-replicated shard groups: %s", mustMarshalJSON(status.UnderReplicatedShardGroups))
+	} else if status.BrokerIndex != s.Index() || status.IndexLag != 0 {
+		t.Fatalf("unexpected broker index: %d (lag %d)", status.BrokerIndex, status.IndexLag)
+	}
+
+	results := s.ExecuteQuery(MustParseQuery(`SHOW CLUSTER`), "", nil)
+	if res := results.Results[0]; res.Err != nil {
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if len(res.Rows) != 3 {
+		t.Fatalf("unexpected row count: %d", len(res.Rows))
+	} else if s := mustMarshalJSON(res.Rows[1]); s != fmt.Sprintf(`{"name":"under_replicated_shard_groups","columns":["database","retention_policy","id","replica_n","live_replica_n"],"values":[["foo","raw",%d,3,2]]}`, g[0].ID) {
+		t.Fatalf("unexpected result: %s", s)
 	}
 }
 
-// Ensure the server returns an error when creating a retention policy with an invalid db.
-func TestServer_CreateRetentionPolicy_ErrDatabaseNotFound(t *testing.T) {
+// Ensure the server aborts select statements which read too many points.
+func TestServer_ExecuteQuery_MaxSelectPointN(t *testing.T) {
 	s := OpenServer(NewMessagingClient())
 	defer s.Close()
-	if err := s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "bar"}); err != influxdb.ErrDatabaseNotFound {
-		t.Fatal(err)
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.MaxSelectPointN = 2
+
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{
+		{Name: "cpu", Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(10)}},
+		{Name: "cpu", Timestamp: mustParseTime("2000-01-01T00:00:10Z"), Values: map[string]interface{}{"value": float64(20)}},
+		{Name: "cpu", Timestamp: mustParseTime("2000-01-01T00:00:20Z"), Values: map[string]interface{}{"value": float64(30)}},
+	})
+
+	results := s.ExecuteQuery(MustParseQuery(`SELECT value FROM cpu`), "foo", nil)
+	if err := results.Results[0].Err; err != influxql.ErrMaxSelectPointsLimitExceeded {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	results = s.ExecuteQuery(MustParseQuery(`SELECT value FROM cpu WHERE time >= '2000-01-01T00:00:10Z'`), "foo", nil)
+	if res := results.Results[0]; res.Err != nil {
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if s := mustMarshalJSON(res); s != `{"rows":[{"name":"cpu","columns":["time","value"],"values":[["2000-01-01T00:00:10Z",20],["2000-01-01T00:00:20Z",30]]}]}` {
+		t.Fatalf("unexpected result: %s", s)
 	}
 }
 
-// Ensure the server returns an error when creating a retention policy without a name.
-func TestServer_CreateRetentionPolicy_ErrRetentionPolicyNameRequired(t *testing.T) {
+// Ensure the server caches aggregate results over closed shard groups.
+func TestServer_ExecuteQuery_QueryCache(t *testing.T) {
 	s := OpenServer(NewMessagingClient())
 	defer s.Close()
 	s.CreateDatabase("foo")
-	if err := s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: ""}); err != influxdb.ErrRetentionPolicyNameRequired {
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.QueryCacheSize = 10
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(10)}}})
+
+	q := MustParseQuery(`SELECT sum(value) FROM cpu WHERE time >= '2000-01-01' AND time < '2000-01-02'`)
+	exp := `{"rows":[{"name":"cpu","columns":["time","sum"],"values":[["1970-01-01T00:00:00Z",10]]}]}`
+	if res := s.ExecuteQuery(q, "foo", nil).Results[0]; res.Err != nil {
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if s := mustMarshalJSON(res); s != exp {
+		t.Fatalf("unexpected results: %s", s)
+	}
+
+	// The cached results are returned until a point is backfilled.
+	if res := s.ExecuteQuery(q, "foo", nil).Results[0]; res.Err != nil {
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if s := mustMarshalJSON(res); s != exp {
+		t.Fatalf("unexpected cached results: %s", s)
+	}
+	if n := s.Stats().Get("queryCacheHit"); n != 1 {
+		t.Fatalf("unexpected cache hits: %d", n)
+	}
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Timestamp: mustParseTime("2000-01-01T00:00:10Z"), Values: map[string]interface{}{"value": float64(20)}}})
+	exp = `{"rows":[{"name":"cpu","columns":["time","sum"],"values":[["1970-01-01T00:00:00Z",30]]}]}`
+	if res := s.ExecuteQuery(q, "foo", nil).Results[0]; res.Err != nil {
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if s := mustMarshalJSON(res); s != exp {
+		t.Fatalf("unexpected results after backfill: %s", s)
+	}
+	if n := s.Stats().Get("queryCacheHit"); n != 1 {
+		t.Fatalf("unexpected cache hits: %d", n)
+	}
+
+	// A time range without shard groups is refreshed once points are backfilled into it.
+	q2 := MustParseQuery(`SELECT sum(value) FROM cpu WHERE time >= '2000-02-01' AND time < '2000-02-02'`)
+	if res := s.ExecuteQuery(q2, "foo", nil).Results[0]; res.Err != nil {
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if s := mustMarshalJSON(res); s != `{}` {
+		t.Fatalf("unexpected results: %s", s)
+	}
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Timestamp: mustParseTime("2000-02-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(40)}}})
+	if res := s.ExecuteQuery(q2, "foo", nil).Results[0]; res.Err != nil {
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if s := mustMarshalJSON(res); s != `{"rows":[{"name":"cpu","columns":["time","sum"],"values":[["1970-01-01T00:00:00Z",40]]}]}` {
+		t.Fatalf("unexpected results after backfill: %s", s)
+	}
+
+	// Ensure raw queries are not cached.
+	s.ExecuteQuery(MustParseQuery(`SELECT value FROM cpu WHERE time >= '2000-01-01' AND time < '2000-01-02'`), "foo", nil)
+	s.ExecuteQuery(MustParseQuery(`SELECT value FROM cpu WHERE time >= '2000-01-01' AND time < '2000-01-02'`), "foo", nil)
+	if n := s.Stats().Get("queryCacheHit"); n != 1 {
+		t.Fatalf("unexpected cache hits: %d", n)
+	}
+
+	// Delete the shard group and ensure the cache is invalidated.
+	groups, err := s.ShardGroups("foo")
+	if err != nil {
 		t.Fatal(err)
+	} else if err := s.DeleteShardGroup("foo", "raw", groups[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if res := s.ExecuteQuery(q, "foo", nil).Results[0]; res.Err != nil {
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if s := mustMarshalJSON(res); s != `{}` {
+		t.Fatalf("unexpected results after delete: %s", s)
 	}
 }
 
-// Ensure the server returns an error when creating a duplicate retention policy.
-func TestServer_CreateRetentionPolicy_ErrRetentionPolicyExists(t *testing.T) {
+// Ensure the server enforces per-user query and point quotas.
+func TestServer_ExecuteQuery_UserQuotas(t *testing.T) {
 	s := OpenServer(NewMessagingClient())
 	defer s.Close()
 	s.CreateDatabase("foo")
-	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "bar"})
-	if err := s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "bar"}); err != influxdb.ErrRetentionPolicyExists {
-		t.Fatal(err)
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.CreateUser("susy", "pass", true)
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{
+		{Name: "cpu", Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(10)}},
+		{Name: "cpu", Timestamp: mustParseTime("2000-01-01T00:00:10Z"), Values: map[string]interface{}{"value": float64(20)}},
+	})
+	s.MaxUserQueryN, s.MaxUserSelectPointN = 1, 1
+	u := s.User("susy")
+
+	// Ensure a user cannot run a second query while one is running.
+	var inner influxdb.Results
+	if err := s.ExecuteQueryStream(MustParseQuery(`SHOW DATABASES`), "foo", u, 0, func(i int, res *influxdb.Result) error {
+		inner = s.ExecuteQuery(MustParseQuery(`SHOW DATABASES`), "foo", u)
+		return nil
+	}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	} else if inner.Err != influxdb.ErrUserQueryLimitExceeded {
+		t.Fatalf("unexpected inner error: %v", inner.Err)
+	}
+
+	// Ensure the query slot is released once the query finishes.
+	if results := s.ExecuteQuery(MustParseQuery(`SHOW DATABASES`), "foo", u); results.Error() != nil {
+		t.Fatalf("unexpected error: %s", results.Error())
+	}
+
+	// Ensure the user cannot read more points than their quota.
+	results := s.ExecuteQuery(MustParseQuery(`SELECT value FROM cpu WHERE time >= '2000-01-01'`), "foo", u)
+	if res := results.Results[0]; res.Err != influxdb.ErrUserSelectPointsLimitExceeded {
+		t.Fatalf("unexpected error: %v", res.Err)
+	}
+
+	// Ensure queries without a user are not limited.
+	results = s.ExecuteQuery(MustParseQuery(`SELECT value FROM cpu WHERE time >= '2000-01-01'`), "foo", nil)
+	if res := results.Results[0]; res.Err != nil {
+		t.Fatalf("unexpected error: %s", res.Err)
 	}
 }
 
-// Ensure the database can alter an existing retention policy.
-func TestServer_AlterRetentionPolicy(t *testing.T) {
+// Ensure the server records select statements slower than the threshold.
+func TestServer_ExecuteQuery_SlowQueries(t *testing.T) {
 	s := OpenServer(NewMessagingClient())
 	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.CreateUser("susy", "pass", true)
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(10)}}})
 
-	// Create a database.
-	if err := s.CreateDatabase("foo"); err != nil {
-		t.Fatal(err)
+	// Ensure nothing is recorded while the slow query log is disabled.
+	s.ExecuteQuery(MustParseQuery(`SELECT value FROM cpu`), "foo", nil)
+	if a := s.SlowQueries(); len(a) != 0 {
+		t.Fatalf("unexpected slow queries: %d", len(a))
 	}
 
-	// Create a retention policy on the database.
-	rp := &influxdb.RetentionPolicy{
-		Name:     "bar",
-		Duration: time.Hour,
-		ReplicaN: 2,
+	// Record every select statement.
+	s.SlowQueryThreshold = time.Nanosecond
+	results := s.ExecuteQuery(MustParseQuery(`SELECT value FROM cpu WHERE time >= '2000-01-01'; SHOW DATABASES`), "foo", s.User("susy"))
+	if results.Error() != nil {
+		t.Fatalf("unexpected error: %s", results.Error())
 	}
-	if err := s.CreateRetentionPolicy("foo", rp); err != nil {
-		t.Fatal(err)
+	if a := s.SlowQueries(); len(a) != 1 {
+		t.Fatalf("unexpected slow queries: %d", len(a))
+	} else if a[0].Statement != `SELECT value FROM "foo"."raw"."cpu" WHERE time >= "2000-01-01 00:00:00"` {
+		t.Fatalf("unexpected statement: %s", a[0].Statement)
+	} else if a[0].User != "susy" || a[0].Database != "foo" || a[0].ShardN != 1 || a[0].Duration <= 0 {
+		t.Fatalf("unexpected slow query: %#v", a[0])
 	}
+}
 
-	// Alter the retention policy.
-	duration := time.Minute
-	replicaN := uint32(3)
-	rp2 := &influxdb.RetentionPolicyUpdate{
-		Duration: &duration,
-		ReplicaN: &replicaN,
+// Ensure the slow query log only keeps the most recent queries.
+func TestSlowQueryLog(t *testing.T) {
+	l := influxdb.NewSlowQueryLog(2)
+	for _, stmt := range []string{"a", "b", "c"} {
+		l.Add(&influxdb.SlowQuery{Statement: stmt})
 	}
-	if err := s.UpdateRetentionPolicy("foo", "bar", rp2); err != nil {
-		t.Fatal(err)
+	if a := l.Queries(); len(a) != 2 || a[0].Statement != "b" || a[1].Statement != "c" {
+		t.Fatalf("unexpected queries: %#v", a)
 	}
+}
 
-	// Restart the server to make sure the changes persist afterwards.
-	s.Restart()
+// Ensure the server can send writes directly to the data nodes that own a shard.
+func TestServer_WriteSeries_ForwardWrites(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.ForwardWrites = true
+
+	// Create a remote node that owns every shard with the local node.
+	var paths []string
+	status := http.StatusNoContent
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.Method+" "+r.URL.Path)
+		w.WriteHeader(status)
+	}))
+	defer ts.Close()
+	u, _ := url.Parse(ts.URL)
+	s.CreateDataNode(u)
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour, ReplicaN: 2})
+	s.SetDefaultRetentionPolicy("foo", "raw")
 
-	// Verify that the policy exists.
-	if o, err := s.RetentionPolicy("foo", "bar"); err != nil {
-		t.Fatalf("unexpected error: %s", err)
-	} else if o == nil {
-		t.Fatalf("retention policy not found")
-	} else if o.Duration != *rp2.Duration {
-		t.Fatalf("retention policy mismatch:\n\texp Duration = %s\n\tgot Duration = %s\n", rp2.Duration, o.Duration)
-	} else if o.ReplicaN != *rp2.ReplicaN {
-		t.Fatalf("retention policy mismatch:\n\texp ReplicaN = %d\n\tgot ReplicaN = %d\n", rp2.ReplicaN, o.ReplicaN)
+	// Count the writes published to the broker.
+	var published int
+	c.PublishFunc = func(m *messaging.Message) (uint64, error) {
+		if m.Type == messaging.MessageType(0x80) { // write raw series
+			published++
+		}
+		return c.send(m)
+	}
+
+	// Write to both owners without using the broker.
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{
+		{Name: "cpu", Tags: map[string]string{"host": "serverA"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(10)}},
+		{Name: "cpu", Tags: map[string]string{"host": "serverA"}, Timestamp: mustParseTime("2000-01-01T00:00:10Z"), Values: map[string]interface{}{"value": float64(20)}},
+	})
+	groups, _ := s.ShardGroups("foo")
+	id := groups[0].Shards[0].ID
+	if published != 0 {
+		t.Fatalf("unexpected published writes: %d", published)
+	} else if !reflect.DeepEqual(paths, []string{fmt.Sprintf("POST /shards/%d/write", id)}) {
+		t.Fatalf("unexpected remote requests: %v", paths)
+	}
+	results := s.ExecuteQuery(MustParseQuery(`SELECT sum(value) FROM cpu WHERE time >= '2000-01-01'`), "foo", nil)
+	if res := results.Results[0]; res.Err != nil {
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if s := mustMarshalJSON(res); s != `{"rows":[{"name":"cpu","columns":["time","sum"],"values":[["1970-01-01T00:00:00Z",30]]}]}` {
+		t.Fatalf("unexpected result: %s", s)
+	}
+
+	// Writes fall back to the broker if an owner fails.
+	status = http.StatusInternalServerError
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{
+		{Name: "cpu", Tags: map[string]string{"host": "serverA"}, Timestamp: mustParseTime("2000-01-01T00:00:20Z"), Values: map[string]interface{}{"value": float64(30)}},
+	})
+	if published != 1 {
+		t.Fatalf("unexpected published writes: %d", published)
 	}
 }
 
-// Ensure the server can delete an existing retention policy.
-func TestServer_DeleteRetentionPolicy(t *testing.T) {
+// Ensure the server merges points from shards owned by other data nodes.
+func TestServer_ExecuteQuery_RemoteShard(t *testing.T) {
 	s := OpenServer(NewMessagingClient())
 	defer s.Close()
-
-	// Create a database and retention policy.
 	s.CreateDatabase("foo")
-	if err := s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "bar"}); err != nil {
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+
+	// Create a remote node that serves a single point for any shard.
+	var path string
+	var truncated bool
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		w.Write([]byte(`{"time":946684820000000000,"value":100}` + "\n"))
+		if !truncated {
+			w.Write([]byte(`{"end":true}` + "\n"))
+		}
+	}))
+	defer ts.Close()
+	u, _ := url.Parse(ts.URL)
+	if err := s.CreateDataNode(u); err != nil {
 		t.Fatal(err)
-	} else if rp, _ := s.RetentionPolicy("foo", "bar"); rp == nil {
-		t.Fatal("retention policy not created")
 	}
 
-	// Remove retention policy from database.
-	if err := s.DeleteRetentionPolicy("foo", "bar"); err != nil {
-		t.Fatal(err)
-	} else if rp, _ := s.RetentionPolicy("foo", "bar"); rp != nil {
-		t.Fatal("retention policy not deleted")
+	// Write a point to each series. Only the series in the local shard is stored.
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{
+		{Name: "cpu", Tags: map[string]string{"host": "serverA"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(10)}},
+		{Name: "cpu", Tags: map[string]string{"host": "serverB"}, Timestamp: mustParseTime("2000-01-01T00:00:10Z"), Values: map[string]interface{}{"value": float64(10)}},
+	})
+
+	results := s.ExecuteQuery(MustParseQuery(`SELECT sum(value) FROM cpu WHERE time >= '2000-01-01'`), "foo", nil)
+	if res := results.Results[0]; res.Err != nil {
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if s := mustMarshalJSON(res); s != `{"rows":[{"name":"cpu","columns":["time","sum"],"values":[["1970-01-01T00:00:00Z",110]]}]}` {
+		t.Fatalf("unexpected result: %s", s)
 	}
-	s.Restart()
 
-	if rp, _ := s.RetentionPolicy("foo", "bar"); rp != nil {
-		t.Fatal("retention policy not deleted after restart")
+	// Verify the remote shard was requested.
+	if !strings.HasPrefix(path, "/shards/") || !strings.HasSuffix(path, "/points") {
+		t.Fatalf("unexpected remote path: %s", path)
+	}
+
+	// A stream cut short on every owner fails the query instead of dropping points.
+	truncated = true
+	results = s.ExecuteQuery(MustParseQuery(`SELECT sum(value) FROM cpu WHERE time >= '2000-01-01'`), "foo", nil)
+	if res := results.Results[0]; res.Err == nil {
+		t.Fatalf("expected error: %s", mustMarshalJSON(res))
 	}
 }
 
-// Ensure the server returns an error when deleting a retention policy on invalid db.
-func TestServer_DeleteRetentionPolicy_ErrDatabaseNotFound(t *testing.T) {
+// Ensure a shard can be backed up while it's written to and restored later.
+func TestServer_BackupShard(t *testing.T) {
 	s := OpenServer(NewMessagingClient())
 	defer s.Close()
-	if err := s.DeleteRetentionPolicy("foo", "bar"); err != influxdb.ErrDatabaseNotFound {
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+
+	tags := map[string]string{"host": "servera"}
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: tags, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(10)}}})
+	groups, _ := s.ShardGroups("foo")
+	id := groups[0].Shards[0].ID
+
+	// Back up the shard, then write another point.
+	var buf bytes.Buffer
+	if err := s.BackupShard(id, &buf); err != nil {
+		t.Fatal(err)
+	} else if err := s.BackupShard(id+1, &buf); err != influxdb.ErrShardNotFound {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: tags, Timestamp: mustParseTime("2000-01-01T00:00:10Z"), Values: map[string]interface{}{"value": float64(20)}}})
+
+	// An invalid backup leaves the shard unchanged.
+	if err := s.RestoreShard(id, bytes.NewReader(make([]byte, 4096))); err == nil {
+		t.Fatal("expected error")
+	} else if v, _ := s.ReadSeries("foo", "raw", "cpu", tags, mustParseTime("2000-01-01T00:00:10Z")); v == nil {
+		t.Fatal("expected point after invalid restore")
+	}
+
+	// Restoring drops the point written after the backup.
+	if err := s.RestoreShard(id, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := s.ReadSeries("foo", "raw", "cpu", tags, mustParseTime("2000-01-01T00:00:00Z")); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(v, map[string]interface{}{"value": float64(10)}) {
+		t.Fatalf("values mismatch: %#v", v)
+	}
+	if v, err := s.ReadSeries("foo", "raw", "cpu", tags, mustParseTime("2000-01-01T00:00:10Z")); err != nil {
 		t.Fatal(err)
+	} else if v != nil {
+		t.Fatalf("unexpected values: %#v", v)
 	}
 }
 
-// Ensure the server returns an error when deleting a retention policy without a name.
-func TestServer_DeleteRetentionPolicy_ErrRetentionPolicyNameRequired(t *testing.T) {
+// Ensure the server can export a shard's points as line protocol.
+func TestServer_ExportShard(t *testing.T) {
 	s := OpenServer(NewMessagingClient())
 	defer s.Close()
 	s.CreateDatabase("foo")
-	if err := s.DeleteRetentionPolicy("foo", ""); err != influxdb.ErrRetentionPolicyNameRequired {
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{
+		{Name: "cpu", Tags: map[string]string{"host": "server b", "region": "us,west"}, Timestamp: mustParseTime("2000-01-01T00:00:10Z"), Values: map[string]interface{}{"value": float64(20), "idle": true}},
+		{Name: "cpu", Tags: map[string]string{"host": "servera"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(10.5)}},
+		{Name: "cpu", Tags: map[string]string{"host": "servera"}, Timestamp: mustParseTime("2000-01-01T00:00:20Z"), Values: map[string]interface{}{"value": float64(-3)}},
+		{Name: "disk io", Tags: map[string]string{"path": "/"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"status": `say "hi"`}},
+	})
+	groups, _ := s.ShardGroups("foo")
+	id := groups[0].Shards[0].ID
+
+	var buf bytes.Buffer
+	if err := s.ExportShard(id, &buf); err != nil {
 		t.Fatal(err)
+	} else if err := s.ExportShard(id+1, &buf); err != influxdb.ErrShardNotFound {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if exp := `cpu,host=server\ b,region=us\,west idle=true,value=20 946684810000000000
+cpu,host=servera value=10.5 946684800000000000
+cpu,host=servera value=-3 946684820000000000
+disk\ io,path=/ status="say \"hi\"" 946684800000000000
+`; buf.String() != exp {
+		t.Fatalf("unexpected export:\n%s", buf.String())
 	}
 }
 
-// Ensure the server returns an error when deleting a non-existent retention policy.
-func TestServer_DeleteRetentionPolicy_ErrRetentionPolicyNotFound(t *testing.T) {
+// Ensure the server can copy a shard from another data node and verify its checksum.
+func TestServer_CopyShard(t *testing.T) {
 	s := OpenServer(NewMessagingClient())
 	defer s.Close()
 	s.CreateDatabase("foo")
-	if err := s.DeleteRetentionPolicy("foo", "no_such_policy"); err != influxdb.ErrRetentionPolicyNotFound {
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+
+	// Create a remote node that serves a shard data file.
+	var data []byte
+	var sum string
+	checksum := func(b []byte) string {
+		h := sha256.Sum256(b)
+		return hex.EncodeToString(h[:])
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(influxdb.ShardChecksumHeader, sum)
+		w.Write(data)
+	}))
+	defer ts.Close()
+	u, _ := url.Parse(ts.URL)
+	s.CreateDataNode(u)
+	remote := s.DataNodeByURL(u)
+
+	// Write a point to the local shard and serve a copy of it from the remote node.
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{
+		{Name: "cpu", Tags: map[string]string{"host": "serverA"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(10)}},
+		{Name: "cpu", Tags: map[string]string{"host": "serverB"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(10)}},
+	})
+	groups, _ := s.ShardGroups("foo")
+	var local, other *influxdb.Shard
+	for _, sh := range groups[0].Shards {
+		if sh.HasDataNodeID(remote.ID) {
+			other = sh
+		} else {
+			local = sh
+		}
+	}
+	var buf bytes.Buffer
+	if err := s.WriteShardTo(&buf, local.ID); err != nil {
+		t.Fatal(err)
+	}
+	data, sum = buf.Bytes(), checksum(buf.Bytes())
+
+	// Copying requires the local node to own the shard.
+	if err := s.CopyShard(other.ID, remote.ID); err != influxdb.ErrShardNotOwned {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := s.SetShardOwners(other.ID, []uint64{remote.ID, s.ID()}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A corrupt copy is rejected.
+	sum = checksum([]byte("corrupt"))
+	if err := s.CopyShard(other.ID, remote.ID); err != influxdb.ErrShardChecksumMismatch {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Copy the shard and verify it contains the copied points.
+	sum = checksum(data)
+	if err := s.CopyShard(other.ID, remote.ID); err != nil {
 		t.Fatal(err)
+	} else if n, err := s.MergeShard(other.ID, bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	} else if n != 0 {
+		t.Fatalf("expected copied points to exist: n=%d", n)
 	}
 }
 
-// Ensure the server can set the default retention policy
-func TestServer_SetDefaultRetentionPolicy(t *testing.T) {
-	s := OpenServer(NewMessagingClient())
+// Ensure the server can move a data node's shards to another node before removing it.
+func TestServer_DecommissionDataNode(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
 	defer s.Close()
 	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
 
-	rp := &influxdb.RetentionPolicy{Name: "bar"}
-	if err := s.CreateRetentionPolicy("foo", rp); err != nil {
+	// Create a remote node that serves a copy of the data it owns.
+	var data []byte
+	var path string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		sum := sha256.Sum256(data)
+		w.Header().Set(influxdb.ShardChecksumHeader, hex.EncodeToString(sum[:]))
+		w.Write(data)
+	}))
+	defer ts.Close()
+	u, _ := url.Parse(ts.URL)
+	if err := s.CreateDataNode(u); err != nil {
 		t.Fatal(err)
-	} else if rp, _ := s.RetentionPolicy("foo", "bar"); rp == nil {
-		t.Fatal("retention policy not created")
 	}
+	remote := s.DataNodeByURL(u)
 
-	// Set bar as default
-	if err := s.SetDefaultRetentionPolicy("foo", "bar"); err != nil {
+	// Write a point to each shard and copy the local shard's data.
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{
+		{Name: "cpu", Tags: map[string]string{"host": "serverA"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(10)}},
+		{Name: "cpu", Tags: map[string]string{"host": "serverB"}, Timestamp: mustParseTime("2000-01-01T00:00:10Z"), Values: map[string]interface{}{"value": float64(20)}},
+	})
+	groups, err := s.ShardGroups("foo")
+	if err != nil {
+		t.Fatal(err)
+	} else if len(groups) != 1 || len(groups[0].Shards) != 2 {
+		t.Fatalf("unexpected shard groups: %#v", groups)
+	}
+	var local, moved *influxdb.Shard
+	for _, sh := range groups[0].Shards {
+		if sh.HasDataNodeID(remote.ID) {
+			moved = sh
+		} else {
+			local = sh
+		}
+	}
+	var buf bytes.Buffer
+	if err := s.WriteShardTo(&buf, local.ID); err != nil {
 		t.Fatal(err)
 	}
+	data = buf.Bytes()
 
-	if o, _ := s.DefaultRetentionPolicy("foo"); o == nil {
-		t.Fatal("default policy not set")
-	} else if !reflect.DeepEqual(rp, o) {
-		t.Fatalf("retention policy mismatch: %#v", o)
+	// Track shards that the local node subscribes to.
+	var subscribed []uint64
+	c.SubscribeFunc = func(replicaID, topicID uint64) error {
+		subscribed = append(subscribed, topicID)
+		return nil
 	}
 
-	s.Restart()
+	// Decommission the remote node.
+	if err := s.DecommissionDataNode(remote.ID); err != nil {
+		t.Fatal(err)
+	} else if s.DataNode(remote.ID) != nil {
+		t.Fatal("data node not deleted")
+	} else if path != fmt.Sprintf("/shards/%d/data", moved.ID) {
+		t.Fatalf("unexpected remote path: %s", path)
+	} else if !reflect.DeepEqual(subscribed, []uint64{moved.ID}) {
+		t.Fatalf("unexpected subscriptions: %v", subscribed)
+	}
+
+	// Verify the moved shard is now owned by the local node and has the copied data.
+	groups, _ = s.ShardGroups("foo")
+	for _, sh := range groups[0].Shards {
+		if !reflect.DeepEqual(sh.DataNodeIDs, []uint64{s.ID()}) {
+			t.Fatalf("unexpected owners: shard=%d, owners=%v", sh.ID, sh.DataNodeIDs)
+		}
+	}
+	if n, err := s.MergeShard(moved.ID, bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	} else if n != 0 {
+		t.Fatalf("expected copied points to exist: n=%d", n)
+	}
 
-	if o, _ := s.DefaultRetentionPolicy("foo"); o == nil {
-		t.Fatal("default policy not kept after restart")
-	} else if !reflect.DeepEqual(rp, o) {
-		t.Fatalf("retention policy mismatch after restart: %#v", o)
+	// The last data node cannot be decommissioned.
+	if err := s.DecommissionDataNode(s.ID()); err == nil || !strings.Contains(err.Error(), influxdb.ErrNoReplacementDataNode.Error()) {
+		t.Fatalf("unexpected error: %v", err)
 	}
 }
 
-// Ensure the server returns an error when setting the default retention policy to a non-existant one.
-func TestServer_SetDefaultRetentionPolicy_ErrRetentionPolicyNotFound(t *testing.T) {
+// Ensure the server can force the removal of an unreachable data node.
+func TestServer_RemoveDataNode_Force(t *testing.T) {
 	s := OpenServer(NewMessagingClient())
 	defer s.Close()
+
+	// Create a shard data file to serve from a surviving replica.
+	src := OpenDefaultServer(NewMessagingClient())
+	defer src.Close()
+	src.MustWriteSeries("db", "raw", []influxdb.Point{{Name: "cpu", Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(10)}}})
+	g, _ := src.ShardGroups("db")
+	var buf bytes.Buffer
+	if err := src.WriteShardTo(&buf, g[0].Shards[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(buf.Bytes())
+
+	// Create a surviving remote node and a dead node.
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(influxdb.ShardChecksumHeader, hex.EncodeToString(sum[:]))
+		w.Write(buf.Bytes())
+	}))
+	defer ts.Close()
+	u, _ := url.Parse(ts.URL)
+	s.CreateDataNode(u)
+	s.CreateDataNode(&url.URL{Scheme: "http", Host: "127.0.0.1:0"})
+	remote, dead := s.DataNodeByURL(u), s.DataNodes()[2]
+
+	// Replicate a shard to the remote & dead nodes.
 	s.CreateDatabase("foo")
-	if err := s.SetDefaultRetentionPolicy("foo", "no_such_policy"); err != influxdb.ErrRetentionPolicyNotFound {
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour, ReplicaN: 2})
+	if err := s.CreateShardGroupIfNotExists("foo", "raw", mustParseTime("2000-01-01T00:00:00Z")); err != nil {
+		t.Fatal(err)
+	}
+	groups, _ := s.ShardGroups("foo")
+	sh := groups[0].Shards[0]
+	if err := s.SetShardOwners(sh.ID, []uint64{dead.ID, remote.ID}); err != nil {
 		t.Fatal(err)
 	}
-}
 
-// Ensure the server prohibits a zero check interval for retention policy enforcement.
-func TestServer_StartRetentionPolicyEnforcement_ErrZeroInterval(t *testing.T) {
-	s := OpenServer(NewMessagingClient())
-	defer s.Close()
-	if err := s.StartRetentionPolicyEnforcement(time.Duration(0)); err == nil {
-		t.Fatal("failed to prohibit retention policies zero check interval")
+	// Remove the dead node and wait for the shard to be copied to the local node.
+	if err := s.RemoveDataNode(dead.ID, true); err != nil {
+		t.Fatal(err)
+	} else if s.DataNode(dead.ID) != nil {
+		t.Fatal("data node not removed")
+	}
+	for i := 0; ; i++ {
+		owners, degraded, err := s.ShardOwners(sh.ID)
+		if err != nil {
+			t.Fatal(err)
+		} else if reflect.DeepEqual(owners, []uint64{remote.ID, s.ID()}) && !degraded {
+			break
+		} else if i == 100 {
+			t.Fatalf("shard not replicated: owners=%v, degraded=%v", owners, degraded)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Removing an unknown node returns an error.
+	if err := s.RemoveDataNode(dead.ID, true); err != influxdb.ErrDataNodeNotFound {
+		t.Fatalf("unexpected error: %v", err)
 	}
 }
 
-func TestServer_EnforceRetentionPolices(t *testing.T) {
+// Ensure the server moves existing shards to a data node that joins the cluster.
+func TestServer_RebalanceShards(t *testing.T) {
 	c := NewMessagingClient()
 	s := OpenServer(c)
 	defer s.Close()
 	s.CreateDatabase("foo")
-	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "mypolicy", Duration: 30 * time.Minute})
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
+	s.SetDefaultRetentionPolicy("foo", "raw")
 
-	// Create two shard groups for the the new retention policy -- 1 which will age out immediately
-	// the other in more than an hour.
-	s.CreateShardGroupIfNotExists("foo", "mypolicy", time.Now().Add(-1*time.Hour))
-	s.CreateShardGroupIfNotExists("foo", "mypolicy", time.Now().Add(time.Hour))
+	// Create two shard groups on the local node.
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{
+		{Name: "cpu", Tags: map[string]string{"host": "serverA"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(10)}},
+		{Name: "cpu", Tags: map[string]string{"host": "serverA"}, Timestamp: mustParseTime("2000-01-01T05:00:00Z"), Values: map[string]interface{}{"value": float64(20)}},
+	})
+
+	// Add a remote node that accepts shard data.
+	var paths []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.Method+" "+r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+	u, _ := url.Parse(ts.URL)
+	if err := s.CreateDataNode(u); err != nil {
+		t.Fatal(err)
+	}
+	remote := s.DataNodeByURL(u)
 
-	// Check the two shard groups exist.
-	var g []*influxdb.ShardGroup
-	g, err := s.ShardGroups("foo")
+	// Track shards that the local node unsubscribes from.
+	var unsubscribed []uint64
+	c.UnsubscribeFunc = func(replicaID, topicID uint64) error {
+		unsubscribed = append(unsubscribed, topicID)
+		return nil
+	}
+
+	// Rebalance and verify that the first shard moved to the remote node.
+	groups, err := s.ShardGroups("foo")
 	if err != nil {
 		t.Fatal(err)
-	} else if len(g) != 2 {
-		t.Fatalf("expected 2 shard group but found %d", len(g))
+	} else if len(groups) != 2 {
+		t.Fatalf("unexpected shard group count: %d", len(groups))
+	}
+	id := groups[0].Shards[0].ID
+	if moves, err := s.RebalanceShards(); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(moves, []influxdb.ShardMove{{ShardID: id, From: s.ID(), To: remote.ID}}) {
+		t.Fatalf("unexpected moves: %#v", moves)
+	} else if !reflect.DeepEqual(paths, []string{fmt.Sprintf("POST /shards/%d/data", id)}) {
+		t.Fatalf("unexpected remote requests: %v", paths)
+	} else if !reflect.DeepEqual(unsubscribed, []uint64{id}) {
+		t.Fatalf("unexpected unsubscriptions: %v", unsubscribed)
 	}
 
-	// Run retention enforcement.
-	s.EnforceRetentionPolicies()
-
-	// Ensure enforcement is in effect across restarts.
-	s.Restart()
+	groups, _ = s.ShardGroups("foo")
+	if a := groups[0].Shards[0].DataNodeIDs; !reflect.DeepEqual(a, []uint64{remote.ID}) {
+		t.Fatalf("unexpected owners: %v", a)
+	} else if a := groups[1].Shards[0].DataNodeIDs; !reflect.DeepEqual(a, []uint64{s.ID()}) {
+		t.Fatalf("unexpected owners: %v", a)
+	}
 
-	// First shard group should have been removed.
-	g, err = s.ShardGroups("foo")
-	if err != nil {
+	// A balanced cluster doesn't move shards.
+	if moves, err := s.RebalanceShards(); err != nil {
 		t.Fatal(err)
-	} else if len(g) != 1 {
-		t.Fatalf("expected 1 shard group but found %d", len(g))
+	} else if len(moves) != 0 {
+		t.Fatalf("unexpected moves: %#v", moves)
 	}
 }
 
-// Ensure the database can write data to the database.
-func TestServer_WriteSeries(t *testing.T) {
-	c := NewMessagingClient()
-	s := OpenServer(c)
+// Ensure the server adds replicas to shards created while the cluster was too small.
+func TestServer_ReplicateShards(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
 	defer s.Close()
 	s.CreateDatabase("foo")
-	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "mypolicy", Duration: 1 * time.Hour})
-	s.CreateUser("susy", "pass", false)
-
-	// Check if a topic is being subscribed to.
-	var subscribed bool
-	c.SubscribeFunc = func(replicaID, topicID uint64) error {
-		subscribed = true
-		return nil
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour, ReplicaN: 2})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{
+		{Name: "cpu", Tags: map[string]string{"host": "serverA"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(10)}},
+	})
+
+	// Add a remote node that accepts shard data.
+	var paths []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.Method+" "+r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+	u, _ := url.Parse(ts.URL)
+	s.CreateDataNode(u)
+	remote := s.DataNodeByURL(u)
+
+	// Verify the shard is assigned to and copied to the new node.
+	groups, _ := s.ShardGroups("foo")
+	id := groups[0].Shards[0].ID
+	if n, err := s.ReplicateShards(); err != nil {
+		t.Fatal(err)
+	} else if n != 1 {
+		t.Fatalf("unexpected replica count: %d", n)
+	} else if !reflect.DeepEqual(paths, []string{fmt.Sprintf("POST /shards/%d/data", id)}) {
+		t.Fatalf("unexpected remote requests: %v", paths)
+	}
+	groups, _ = s.ShardGroups("foo")
+	if a := groups[0].Shards[0].DataNodeIDs; !reflect.DeepEqual(a, []uint64{s.ID(), remote.ID}) {
+		t.Fatalf("unexpected owners: %v", a)
 	}
 
-	// Write series with one point to the database.
-	tags := map[string]string{"host": "servera.influx.com", "region": "uswest"}
-	index, err := s.WriteSeries("foo", "mypolicy", []influxdb.Point{{Name: "cpu_load", Tags: tags, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(23.2)}}})
-	if err != nil {
+	// Fully replicated shards are left alone.
+	if n, err := s.ReplicateShards(); err != nil {
 		t.Fatal(err)
-	} else if err = s.Sync(index); err != nil {
-		t.Fatalf("sync error: %s", err)
+	} else if n != 0 {
+		t.Fatalf("unexpected replica count: %d", n)
 	}
+}
 
-	// Write another point 10 seconds later so it goes through "raw series".
-	index, err = s.WriteSeries("foo", "mypolicy", []influxdb.Point{{Name: "cpu_load", Tags: tags, Timestamp: mustParseTime("2000-01-01T00:00:10Z"), Values: map[string]interface{}{"value": float64(100)}}})
-	if err != nil {
+// Ensure the server copies missing points from the other replicas of its shards.
+func TestServer_RepairShards(t *testing.T) {
+	// Create a remote node backed by a second server with the same shards.
+	remote := OpenServer(NewMessagingClient())
+	defer remote.Close()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var id uint64
+		if _, err := fmt.Sscanf(r.URL.Path, "/shards/%d/", &id); err != nil {
+			t.Errorf("unexpected path: %s", r.URL.Path)
+			return
+		}
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/digest"):
+			digest, _ := remote.ShardDigest(id)
+			json.NewEncoder(w).Encode(digest)
+		case r.URL.Query().Get("series") == "1":
+			remote.WriteShardSeriesTo(w, id, []uint32{1})
+		default:
+			t.Errorf("unexpected request: %s", r.URL)
+		}
+	}))
+	defer ts.Close()
+	u, _ := url.Parse(ts.URL)
+
+	// Replicate every shard to both nodes.
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	for _, srv := range []*Server{s, remote} {
+		srv.CreateDataNode(u)
+		srv.CreateDatabase("foo")
+		srv.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour, ReplicaN: 2})
+		srv.SetDefaultRetentionPolicy("foo", "raw")
+	}
+
+	// Write a point that the local server missed to the remote server.
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{
+		{Name: "cpu", Tags: map[string]string{"host": "serverA"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(10)}},
+	})
+	remote.MustWriteSeries("foo", "raw", []influxdb.Point{
+		{Name: "cpu", Tags: map[string]string{"host": "serverA"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(10)}},
+		{Name: "cpu", Tags: map[string]string{"host": "serverA"}, Timestamp: mustParseTime("2000-01-01T00:00:10Z"), Values: map[string]interface{}{"value": float64(20)}},
+	})
+
+	// Repair and verify the missing point was copied.
+	if n, err := s.RepairShards(); err != nil {
 		t.Fatal(err)
-	} else if err = s.Sync(index); err != nil {
-		t.Fatalf("sync error: %s", err)
+	} else if n != 1 {
+		t.Fatalf("unexpected repaired point count: %d", n)
+	}
+	results := s.ExecuteQuery(MustParseQuery(`SELECT sum(value) FROM cpu WHERE time >= '2000-01-01'`), "foo", nil)
+	if res := results.Results[0]; res.Err != nil {
+		t.Fatalf("unexpected error: %s", res.Err)
+	} else if s := mustMarshalJSON(res); s != `{"rows":[{"name":"cpu","columns":["time","sum"],"values":[["1970-01-01T00:00:00Z",30]]}]}` {
+		t.Fatalf("unexpected result: %s", s)
 	}
 
-	// Verify a subscription was made.
-	if !subscribed {
-		t.Fatal("expected subscription")
+	// Replicas that have converged aren't copied again.
+	if n, err := s.RepairShards(); err != nil {
+		t.Fatal(err)
+	} else if n != 0 {
+		t.Fatalf("unexpected repaired point count: %d", n)
 	}
+}
 
-	// Retrieve first series data point.
-	if v, err := s.ReadSeries("foo", "mypolicy", "cpu_load", tags, mustParseTime("2000-01-01T00:00:00Z")); err != nil {
+// Ensure the server can verify a shard and compare its checksums with another owner's.
+func TestServer_VerifyShard(t *testing.T) {
+	remote := OpenServer(NewMessagingClient())
+	defer remote.Close()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var id uint64
+		if _, err := fmt.Sscanf(r.URL.Path, "/shards/%d/checksum", &id); err != nil {
+			t.Errorf("unexpected path: %s", r.URL.Path)
+			return
+		}
+		checksum, _ := remote.VerifyShard(id)
+		json.NewEncoder(w).Encode(checksum)
+	}))
+	defer ts.Close()
+	u, _ := url.Parse(ts.URL)
+
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	for _, srv := range []*Server{s, remote} {
+		srv.CreateDataNode(u)
+		srv.CreateDatabase("foo")
+		srv.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour, ReplicaN: 2})
+		srv.SetDefaultRetentionPolicy("foo", "raw")
+		srv.MustWriteSeries("foo", "raw", []influxdb.Point{
+			{Name: "cpu", Tags: map[string]string{"host": "serverA"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(10)}},
+		})
+	}
+	groups, _ := s.ShardGroups("foo")
+	id := groups[0].Shards[0].ID
+	nodeID := s.DataNodeByURL(u).ID
+
+	if c, err := s.VerifyShard(id); err != nil {
 		t.Fatal(err)
-	} else if !reflect.DeepEqual(v, map[string]interface{}{"value": float64(23.2)}) {
-		t.Fatalf("values mismatch: %#v", v)
+	} else if !c.OK() {
+		t.Fatalf("unexpected problems: %v", c.Problems)
+	} else if len(c.Series) != 1 || c.Checksum == "" {
+		t.Fatalf("unexpected checksum: %#v", c)
+	} else if _, err := s.VerifyShard(id + 1); err != influxdb.ErrShardNotFound {
+		t.Fatalf("unexpected error: %v", err)
 	}
 
-	// Retrieve second series data point.
-	if v, err := s.ReadSeries("foo", "mypolicy", "cpu_load", tags, mustParseTime("2000-01-01T00:00:10Z")); err != nil {
+	// Replicas with the same points match.
+	if diff, err := s.CompareShard(id, nodeID); err != nil {
 		t.Fatal(err)
-	} else if mustMarshalJSON(v) != mustMarshalJSON(map[string]interface{}{"value": float64(100)}) {
-		t.Fatalf("values mismatch: %#v", v)
+	} else if diff != nil {
+		t.Fatalf("unexpected diff: %v", diff)
 	}
 
-	// Retrieve non-existent series data point.
-	if v, err := s.ReadSeries("foo", "mypolicy", "cpu_load", tags, mustParseTime("2000-01-01T00:01:00Z")); err != nil {
+	// A point only written to the remote node is found.
+	remote.MustWriteSeries("foo", "raw", []influxdb.Point{
+		{Name: "cpu", Tags: map[string]string{"host": "serverA"}, Timestamp: mustParseTime("2000-01-01T00:00:10Z"), Values: map[string]interface{}{"value": float64(20)}},
+	})
+	if diff, err := s.CompareShard(id, nodeID); err != nil {
 		t.Fatal(err)
-	} else if v != nil {
-		t.Fatalf("expected nil values: %#v", v)
+	} else if !reflect.DeepEqual(diff, []uint32{1}) {
+		t.Fatalf("unexpected diff: %v", diff)
 	}
 }
 
-// Ensure the server can execute a query and return the data correctly.
-func TestServer_ExecuteQuery(t *testing.T) {
+// Ensure the server reads remote shards from another owner if one is down.
+func TestServer_ExecuteQuery_RemoteShardFailover(t *testing.T) {
 	s := OpenServer(NewMessagingClient())
 	defer s.Close()
 	s.CreateDatabase("foo")
 	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
 	s.SetDefaultRetentionPolicy("foo", "raw")
-	s.CreateUser("susy", "pass", false)
-
-	// Write series with one point to the database.
-	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "us-east"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(20)}}})
-	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "us-east"}, Timestamp: mustParseTime("2000-01-01T00:00:10Z"), Values: map[string]interface{}{"value": float64(30)}}})
-	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "us-west"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(100)}}})
 
-	// Select data from the server.
-	results := s.ExecuteQuery(MustParseQuery(`SELECT sum(value) FROM cpu GROUP BY time(10s), region`), "foo", nil)
-	if res := results.Results[0]; res.Err != nil {
-		t.Fatalf("unexpected error: %s", res.Err)
-	} else if len(res.Rows) != 2 {
-		t.Fatalf("unexpected row count: %d", len(res.Rows))
-	} else if s := mustMarshalJSON(res); s != `{"rows":[{"name":"cpu","tags":{"region":"us-east"},"columns":["time","sum"],"values":[["2000-01-01T00:00:00Z",20],["2000-01-01T00:00:10Z",30]]},{"name":"cpu","tags":{"region":"us-west"},"columns":["time","sum"],"values":[["2000-01-01T00:00:00Z",100]]}]}` {
-		t.Fatalf("unexpected row(0): %s", s)
+	// Create a remote node that is down and one that serves a single point.
+	var downN int
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		downN++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"time":946684800000000000,"value":100}` + "\n" + `{"end":true}` + "\n"))
+	}))
+	defer up.Close()
+	var ids []uint64
+	for _, ts := range []*httptest.Server{down, up} {
+		u, _ := url.Parse(ts.URL)
+		if err := s.CreateDataNode(u); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, s.DataNodeByURL(u).ID)
 	}
 
-	// Simple non-aggregation.
-	results = s.ExecuteQuery(MustParseQuery(`SELECT value FROM cpu WHERE time >= '2000-01-01 00:00:05'`), "foo", nil)
-	if res := results.Results[0]; res.Err != nil {
-		t.Fatalf("unexpected error during simple SELECT: %s", res.Err)
-	} else if s := mustMarshalJSON(res); s != `{"rows":[{"name":"cpu","columns":["time","value"],"values":[["2000-01-01T00:00:10Z",30]]}]}` {
-		t.Fatalf("unexpected row(0) during simple SELECT: %s", s)
+	// Assign the shard to both remote nodes.
+	if err := s.CreateShardGroupIfNotExists("foo", "raw", mustParseTime("2000-01-01T00:00:00Z")); err != nil {
+		t.Fatal(err)
 	}
-
-	// Sum aggregation.
-	results = s.ExecuteQuery(MustParseQuery(`SELECT sum(value) FROM cpu WHERE time >= '2000-01-01 00:00:05' GROUP BY time(10s), region`), "foo", nil)
-	if res := results.Results[0]; res.Err != nil {
-		t.Fatalf("unexpected error during SUM: %s", res.Err)
-	} else if s := mustMarshalJSON(res); s != `{"rows":[{"name":"cpu","tags":{"region":"us-east"},"columns":["time","sum"],"values":[["2000-01-01T00:00:10Z",30]]}]}` {
-		t.Fatalf("unexpected row(0) during SUM: %s", s)
+	groups, _ := s.ShardGroups("foo")
+	for _, sh := range groups[0].Shards {
+		if err := s.SetShardOwners(sh.ID, ids); err != nil {
+			t.Fatal(err)
+		}
 	}
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{
+		{Name: "cpu", Tags: map[string]string{"host": "serverA"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(10)}},
+	})
 
-	// Aggregation with a null field value
-	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "us-east"}, Timestamp: mustParseTime("2000-01-01T00:00:03Z"), Values: map[string]interface{}{"otherVal": float64(20)}}})
-	// Sum aggregation.
-	results = s.ExecuteQuery(MustParseQuery(`SELECT sum(value) FROM cpu GROUP BY region`), "foo", nil)
-	if res := results.Results[0]; res.Err != nil {
-		t.Fatalf("unexpected error during SUM: %s", res.Err)
-	} else if s := mustMarshalJSON(res); s != `{"rows":[{"name":"cpu","tags":{"region":"us-east"},"columns":["time","sum"],"values":[["1970-01-01T00:00:00Z",50]]},{"name":"cpu","tags":{"region":"us-west"},"columns":["time","sum"],"values":[["1970-01-01T00:00:00Z",100]]}]}` {
-		t.Fatalf("unexpected row(0) during SUM: %s", s)
+	// Each shard is read from the node that is up. The failed node isn't
+	// tried first again by later queries.
+	var n int
+	for i := 0; i < 2; i++ {
+		results := s.ExecuteQuery(MustParseQuery(`SELECT sum(value) FROM cpu WHERE time >= '2000-01-01'`), "foo", nil)
+		if res := results.Results[0]; res.Err != nil {
+			t.Fatalf("unexpected error: %s", res.Err)
+		} else if s := mustMarshalJSON(res); s != fmt.Sprintf(`{"rows":[{"name":"cpu","columns":["time","sum"],"values":[["1970-01-01T00:00:00Z",%d]]}]}`, 100*len(groups[0].Shards)) {
+			t.Fatalf("unexpected result: %s", s)
+		}
+
+		if i == 0 {
+			n = downN
+		} else if downN != n {
+			t.Fatalf("unexpected requests to failed node: %d", downN-n)
+		}
 	}
 }
 
-// Ensure the server can execute a wildcard query and return the data correctly.
-func TestServer_ExecuteWildcardQuery(t *testing.T) {
+// Ensure the server can stream the points of a local shard.
+func TestServer_StreamShardPoints(t *testing.T) {
 	s := OpenServer(NewMessagingClient())
 	defer s.Close()
 	s.CreateDatabase("foo")
 	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw", Duration: 1 * time.Hour})
 	s.SetDefaultRetentionPolicy("foo", "raw")
-	s.CreateUser("susy", "pass", false)
 
-	// Write series with one point to the database.
-	// We deliberatly write one value per insert as we need to create each field in a predicatable order for testing.
-	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "us-east"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(10)}}})
-	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "us-east"}, Timestamp: mustParseTime("2000-01-01T00:00:10Z"), Values: map[string]interface{}{"val-x": 20}}})
-	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "us-east"}, Timestamp: mustParseTime("2000-01-01T00:00:20Z"), Values: map[string]interface{}{"value": 30, "val-x": 40}}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{
+		{Name: "cpu", Tags: map[string]string{"host": "serverA"}, Timestamp: mustParseTime("2000-01-01T00:00:00Z"), Values: map[string]interface{}{"value": float64(10)}},
+		{Name: "cpu", Tags: map[string]string{"host": "serverA"}, Timestamp: mustParseTime("2000-01-01T00:00:10Z"), Values: map[string]interface{}{"value": float64(20)}},
+	})
+	groups, err := s.ShardGroups("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
 
-	// Select * (wildcard).
-	results := s.ExecuteQuery(MustParseQuery(`SELECT * FROM cpu`), "foo", nil)
-	if res := results.Results[0]; res.Err != nil {
-		t.Fatalf("unexpected error during SELECT *: %s", res.Err)
-	} else if s := mustMarshalJSON(res); s != `{"rows":[{"name":"cpu","columns":["time","value","val-x"],"values":[["2000-01-01T00:00:00Z",10,null],["2000-01-01T00:00:10Z",null,20],["2000-01-01T00:00:20Z",30,40]]}]}` {
-		t.Fatalf("unexpected results during SELECT *: %s", s)
+	var buf bytes.Buffer
+	stmt := MustParseQuery(`SELECT value FROM "foo"."raw"."cpu"`).Statements[0].(*influxql.SelectStatement)
+	tmin, tmax := mustParseTime("2000-01-01T00:00:05Z").UnixNano(), mustParseTime("2000-01-01T01:00:00Z").UnixNano()
+	if err := s.StreamShardPoints(&buf, groups[0].Shards[0].ID, stmt, "", tmin, tmax); err != nil {
+		t.Fatal(err)
+	} else if buf.String() != `{"time":946684810000000000,"value":20}`+"\n"+`{"end":true}`+"\n" {
+		t.Fatalf("unexpected points: %s", buf.String())
+	}
+
+	// Ensure an unknown shard returns an error.
+	if err := s.StreamShardPoints(&buf, 1000, stmt, "", tmin, tmax); err != influxdb.ErrShardNotFound {
+		t.Fatalf("unexpected error: %v", err)
 	}
 }
 
@@ -963,6 +4743,103 @@ func TestServer_DeleteShardGroup(t *testing.T) {
 	}
 }
 
+// Ensure the server can drop a single shard.
+func TestServer_DeleteShard(t *testing.T) {
+	c := NewMessagingClient()
+	s := OpenServer(c)
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "bar"})
+	if err := s.CreateShardGroupIfNotExists("foo", "bar", time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Track unsubscriptions from the broker.
+	var topicIDs []uint64
+	c.UnsubscribeFunc = func(replicaID, topicID uint64) error {
+		topicIDs = append(topicIDs, topicID)
+		return nil
+	}
+
+	// Drop the only shard in the group.
+	g, _ := s.ShardGroups("foo")
+	id := g[0].Shards[0].ID
+	results := s.ExecuteQuery(MustParseQuery(fmt.Sprintf(`DROP SHARD %d`, id)), "foo", nil)
+	if results.Error() != nil {
+		t.Fatal(results.Error())
+	} else if !reflect.DeepEqual(topicIDs, []uint64{id}) {
+		t.Fatalf("unexpected unsubscriptions: %v", topicIDs)
+	} else if s.Shard(id) != nil {
+		t.Fatal("expected shard to be removed")
+	}
+
+	// Verify the empty group is removed and stays removed after a restart.
+	s.Restart()
+	if g, err := s.ShardGroups("foo"); err != nil {
+		t.Fatal(err)
+	} else if len(g) != 0 {
+		t.Fatalf("expected 0 shard group but found %d", len(g))
+	}
+
+	// Dropping again returns an error.
+	if err := s.DeleteShard(id); err != influxdb.ErrShardNotFound {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure the server moves the shards of deleted shard groups into the archive path.
+func TestServer_DeleteShardGroup_Archive(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.ShardArchivePath = tempfile()
+	defer os.RemoveAll(s.ShardArchivePath)
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "bar"})
+	if err := s.CreateShardGroupIfNotExists("foo", "bar", time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+
+	g, _ := s.ShardGroups("foo")
+	id := g[0].Shards[0].ID
+	if err := s.DeleteShardGroup("foo", "bar", g[0].ID); err != nil {
+		t.Fatal(err)
+	}
+
+	// Verify the shard moved out of the data directory.
+	if _, err := os.Stat(filepath.Join(s.Path(), "shards", fmt.Sprint(id))); !os.IsNotExist(err) {
+		t.Fatalf("expected shard to be removed: %v", err)
+	} else if _, err := os.Stat(filepath.Join(s.ShardArchivePath, "foo", "bar", fmt.Sprint(id))); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure the server hands the shards of deleted shard groups to the archive function.
+func TestServer_DeleteShardGroup_ArchiveFunc(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "bar"})
+	if err := s.CreateShardGroupIfNotExists("foo", "bar", time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+
+	var ids []uint64
+	s.ShardArchiveFunc = func(database, policy string, sh *influxdb.Shard, path string) error {
+		if database != "foo" || policy != "bar" {
+			t.Fatalf("unexpected shard location: %s.%s", database, policy)
+		}
+		ids = append(ids, sh.ID)
+		return os.Remove(path)
+	}
+
+	g, _ := s.ShardGroups("foo")
+	if err := s.DeleteShardGroup("foo", "bar", g[0].ID); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(ids, []uint64{g[0].Shards[0].ID}) {
+		t.Fatalf("unexpected archived shards: %v", ids)
+	}
+}
+
 /* TODO(benbjohnson): Change test to not expose underlying series ids directly.
 func TestServer_Measurements(t *testing.T) {
 	s := OpenServer(NewMessagingClient())
@@ -1132,7 +5009,8 @@ func TestServer_CreateContinuousQuery(t *testing.T) {
 	}
 	s.Restart()
 
-	// check again
+	// check again, the reloaded queries must match the ones created
+	expected = queries
 	queries = s.ContinuousQueries("foo")
 	if !reflect.DeepEqual(queries, expected) {
 		t.Fatalf("query not saved:\n\texp: %s\ngot: %s", mustMarshalJSON(expected), mustMarshalJSON(queries))
@@ -1158,6 +5036,51 @@ func TestServer_CreateContinuousQuery_ErrInfinteLoop(t *testing.T) {
 	t.Skip("pending")
 }
 
+// Ensure the server refuses to drop a retention policy or database that a continuous query writes into.
+func TestServer_ContinuousQueryTarget(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateDatabase("bar")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw"})
+	s.CreateRetentionPolicy("bar", &influxdb.RetentionPolicy{Name: "1h"})
+	s.CreateRetentionPolicy("bar", &influxdb.RetentionPolicy{Name: "1d"})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.SetDefaultRetentionPolicy("bar", "1h")
+
+	q := `CREATE CONTINUOUS QUERY myquery ON foo BEGIN SELECT count(value) INTO "bar"."1d".cpu FROM cpu GROUP BY time(1h) END`
+	stmt, err := influxql.NewParser(strings.NewReader(q)).ParseStatement()
+	if err != nil {
+		t.Fatal(err)
+	} else if err := s.CreateContinuousQuery(stmt.(*influxql.CreateContinuousQueryStatement)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the target policy and database are protected.
+	if err := s.DeleteRetentionPolicy("bar", "1d"); err == nil || err.Error() != "retention policy is the target of continuous query myquery on foo" {
+		t.Fatalf("unexpected error: %v", err)
+	} else if err := s.DeleteDatabase("bar"); err == nil || err.Error() != "database is the target of continuous query myquery on foo" {
+		t.Fatalf("unexpected error: %v", err)
+	} else if err := s.DeleteRetentionPolicy("bar", "1h"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Dropping the continuous query releases the target.
+	results := s.ExecuteQuery(MustParseQuery(`DROP CONTINUOUS QUERY myquery`), "foo", nil)
+	if results.Error() != nil {
+		t.Fatal(results.Error())
+	} else if a := s.ContinuousQueries("foo"); len(a) != 0 {
+		t.Fatalf("unexpected continuous queries: %d", len(a))
+	} else if err := s.DeleteDatabase("bar"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Dropping again returns an error.
+	if err := s.DeleteContinuousQuery("foo", "myquery"); err != influxdb.ErrContinuousQueryNotFound {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
 // Ensure
 func TestServer_RunContinuousQueries(t *testing.T) {
 	s := OpenServer(NewMessagingClient())
@@ -1236,6 +5159,305 @@ func TestServer_RunContinuousQueries(t *testing.T) {
 	verify(3, `{"rows":[{"name":"cpu_region","tags":{"region":"us-east"},"columns":["time","mean"],"values":[["1970-01-01T00:00:00Z",25]]},{"name":"cpu_region","tags":{"region":"us-west"},"columns":["time","mean"],"values":[["1970-01-01T00:00:00Z",75]]}]}`)
 }
 
+// Ensure the results of continuous query runs are recorded and persisted.
+func TestServer_RunContinuousQueries_Stats(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw"})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.ComputeRunsPerInterval = 1
+	s.ComputeNoMoreThan = time.Millisecond
+
+	// Write a point into the current interval and run the query.
+	if err := s.CreateContinuousQuery(MustParseQuery(`CREATE CONTINUOUS QUERY myquery ON foo BEGIN SELECT count(value) INTO cpu_count FROM cpu GROUP BY time(1h), region END`).Statements[0].(*influxql.CreateContinuousQueryStatement)); err != nil {
+		t.Fatal(err)
+	}
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "us-east"}, Timestamp: time.Now().UTC(), Values: map[string]interface{}{"value": float64(30)}}})
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Tags: map[string]string{"region": "us-west"}, Timestamp: time.Now().UTC(), Values: map[string]interface{}{"value": float64(20)}}})
+	s.RunContinuousQueries()
+	time.Sleep(100 * time.Millisecond)
+
+	verify := func(num int) {
+		results := s.ExecuteQuery(MustParseQuery(`SHOW CONTINUOUS QUERY STATS`), "foo", nil)
+		if res := results.Results[0]; res.Err != nil {
+			t.Fatalf("unexpected error verify %d: %s", num, res.Err)
+		} else if len(res.Rows) != 1 || len(res.Rows[0].Values) != 1 {
+			t.Fatalf("unexpected rows on verify %d: %s", num, mustMarshalJSON(res))
+		} else if v := res.Rows[0].Values[0]; v[0] != "myquery" {
+			t.Fatalf("unexpected name on verify %d: %v", num, v[0])
+		} else if lastRun, ok := v[1].(time.Time); !ok || lastRun.IsZero() {
+			t.Fatalf("unexpected last run on verify %d: %v", num, v[1])
+		} else if v[3] != 2 {
+			t.Fatalf("unexpected points written on verify %d: %v", num, v[3])
+		} else if v[4] != "" {
+			t.Fatalf("unexpected last error on verify %d: %v", num, v[4])
+		}
+	}
+	verify(1)
+
+	// Restart server and ensure the stats were persisted.
+	s.Restart()
+	verify(2)
+}
+
+// Ensure paused continuous queries are not run until they are resumed.
+func TestServer_SetContinuousQueryEnabled(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw"})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.ComputeRunsPerInterval = 1
+	s.ComputeNoMoreThan = time.Millisecond
+
+	if err := s.CreateContinuousQuery(MustParseQuery(`CREATE CONTINUOUS QUERY myquery ON foo BEGIN SELECT count(value) INTO cpu_count FROM cpu GROUP BY time(1h) END`).Statements[0].(*influxql.CreateContinuousQueryStatement)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Pause the query.
+	if err := s.SetContinuousQueryEnabled("foo", "myquery", false); err != nil {
+		t.Fatal(err)
+	} else if err := s.SetContinuousQueryEnabled("foo", "no_such_query", false); err != influxdb.ErrContinuousQueryNotFound {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	lastRun := func() interface{} {
+		results := s.ExecuteQuery(MustParseQuery(`SHOW CONTINUOUS QUERY STATS`), "foo", nil)
+		if res := results.Results[0]; res.Err != nil {
+			t.Fatalf("unexpected error: %s", res.Err)
+		}
+		return results.Results[0].Rows[0].Values[0][1]
+	}
+
+	// Ensure the query isn't run, even after a restart.
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Timestamp: time.Now().UTC(), Values: map[string]interface{}{"value": float64(30)}}})
+	s.Restart()
+	s.RunContinuousQueries()
+	time.Sleep(100 * time.Millisecond)
+	if v := lastRun(); v != nil {
+		t.Fatalf("unexpected last run: %v", v)
+	}
+
+	// Resume the query and ensure it runs.
+	if err := s.SetContinuousQueryEnabled("foo", "myquery", true); err != nil {
+		t.Fatal(err)
+	}
+	s.RunContinuousQueries()
+	time.Sleep(100 * time.Millisecond)
+	if v := lastRun(); v == nil {
+		t.Fatal("expected query to run")
+	}
+}
+
+// Ensure a restarted server doesn't rerun continuous queries before they are due.
+func TestServer_RunContinuousQueries_Restart(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw"})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.ComputeRunsPerInterval = 1
+	s.ComputeNoMoreThan = time.Hour
+
+	if err := s.CreateContinuousQuery(MustParseQuery(`CREATE CONTINUOUS QUERY myquery ON foo BEGIN SELECT count(value) INTO cpu_count FROM cpu GROUP BY time(1h) END`).Statements[0].(*influxql.CreateContinuousQueryStatement)); err != nil {
+		t.Fatal(err)
+	}
+
+	lastRun := func() time.Time {
+		results := s.ExecuteQuery(MustParseQuery(`SHOW CONTINUOUS QUERY STATS`), "foo", nil)
+		if res := results.Results[0]; res.Err != nil {
+			t.Fatalf("unexpected error: %s", res.Err)
+		} else if v := res.Rows[0].Values[0]; v[4] != "" {
+			t.Fatalf("unexpected error: %s", v[4])
+		} else if v[1] != nil {
+			return v[1].(time.Time)
+		}
+		return time.Time{}
+	}
+
+	// waitForRun waits for the query to run after prev.
+	waitForRun := func(prev time.Time) time.Time {
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			if v := lastRun(); !v.Equal(prev) {
+				return v
+			}
+		}
+		t.Fatal("expected query to run")
+		return time.Time{}
+	}
+
+	// Run the query once.
+	s.RunContinuousQueries()
+	exp := waitForRun(time.Time{})
+
+	// Restart and ensure the query isn't due yet. A query that isn't due
+	// isn't queued so the last run can be checked right away.
+	s.Restart()
+	s.RunContinuousQueries()
+	if v := lastRun(); !v.Equal(exp) {
+		t.Fatalf("unexpected last run: %v, expected %v", v, exp)
+	}
+
+	// Ensure the reloaded query runs once it is due.
+	s.ComputeRunsPerInterval = int(time.Hour / time.Millisecond)
+	s.ComputeNoMoreThan = time.Millisecond
+	s.RunContinuousQueries()
+	waitForRun(exp)
+}
+
+// Ensure continuous query results can be sent to a remote server.
+func TestServer_RunContinuousQueries_RemoteWriter(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw"})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.CreateDatabase("archive")
+	s.CreateRetentionPolicy("archive", &influxdb.RetentionPolicy{Name: "raw"})
+	s.ComputeRunsPerInterval = 1
+	s.ComputeNoMoreThan = time.Millisecond
+
+	// Create a remote server that records writes.
+	ch := make(chan influxdb.BatchPoints, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var bp influxdb.BatchPoints
+		if r.URL.Path != "/write" {
+			t.Errorf("unexpected path: %s", r.URL.Path)
+		} else if u, p, _ := r.BasicAuth(); u != "susy" || p != "pass" {
+			t.Errorf("unexpected credentials: %s/%s", u, p)
+		} else if err := json.NewDecoder(r.Body).Decode(&bp); err != nil {
+			t.Errorf("decode: %s", err)
+		}
+		ch <- bp
+	}))
+	defer ts.Close()
+	u, _ := url.Parse(ts.URL)
+	w := influxdb.NewRemoteWriter(u)
+	w.Database = "longterm"
+	w.Username, w.Password = "susy", "pass"
+	s.ContinuousQueryWriters = map[string]influxdb.PointsWriter{"archive": w}
+
+	if err := s.CreateContinuousQuery(MustParseQuery(`CREATE CONTINUOUS QUERY myquery ON foo BEGIN SELECT count(value) INTO "archive"."raw".cpu_count FROM cpu GROUP BY time(1h) END`).Statements[0].(*influxql.CreateContinuousQueryStatement)); err != nil {
+		t.Fatal(err)
+	}
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Timestamp: time.Now().UTC(), Values: map[string]interface{}{"value": float64(30)}}})
+	s.RunContinuousQueries()
+
+	// Ensure the result was sent to the remote server.
+	select {
+	case bp := <-ch:
+		if bp.Database != "longterm" || bp.RetentionPolicy != "raw" {
+			t.Fatalf("unexpected target: %s.%s", bp.Database, bp.RetentionPolicy)
+		} else if len(bp.Points) != 1 || bp.Points[0].Name != "cpu_count" || bp.Points[0].Values["count"] != float64(1) {
+			t.Fatalf("unexpected points: %#v", bp.Points)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for remote write")
+	}
+
+	// Ensure nothing was written locally.
+	time.Sleep(100 * time.Millisecond)
+	if names := s.MeasurementNames("archive"); len(names) != 0 {
+		t.Fatalf("unexpected local measurements: %v", names)
+	}
+}
+
+// Ensure continuous query failures are passed to the error handler.
+func TestServer_RunContinuousQueries_ErrorHandler(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw"})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.ComputeRunsPerInterval = 1
+	s.ComputeNoMoreThan = time.Millisecond
+
+	// Fail all writes of the query results.
+	s.ContinuousQueryWriters = map[string]influxdb.PointsWriter{"foo": PointsWriterFunc(func(database, retentionPolicy string, points []influxdb.Point) error {
+		return errors.New("marker")
+	})}
+
+	ch := make(chan string, 1)
+	s.SetContinuousQueryErrorHandler(influxdb.ContinuousQueryErrorHandlerFunc(func(database, name string, err error) {
+		ch <- fmt.Sprintf("%s.%s: %s", database, name, err)
+	}))
+
+	if err := s.CreateContinuousQuery(MustParseQuery(`CREATE CONTINUOUS QUERY myquery ON foo BEGIN SELECT count(value) INTO cpu_count FROM cpu GROUP BY time(1h) END`).Statements[0].(*influxql.CreateContinuousQueryStatement)); err != nil {
+		t.Fatal(err)
+	}
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Timestamp: time.Now().UTC(), Values: map[string]interface{}{"value": float64(30)}}})
+	s.RunContinuousQueries()
+
+	select {
+	case msg := <-ch:
+		if msg != "foo.myquery: marker" {
+			t.Fatalf("unexpected error: %s", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for error")
+	}
+}
+
+// Ensure the server limits the number of continuous queries running at the same time.
+func TestServer_RunContinuousQueries_MaxConcurrent(t *testing.T) {
+	s := OpenServer(NewMessagingClient())
+	defer s.Close()
+	s.CreateDatabase("foo")
+	s.CreateRetentionPolicy("foo", &influxdb.RetentionPolicy{Name: "raw"})
+	s.SetDefaultRetentionPolicy("foo", "raw")
+	s.ComputeRunsPerInterval = 1
+	s.ComputeNoMoreThan = time.Millisecond
+	s.MaxConcurrentContinuousQueries = 1
+
+	// Record how many query results are being written at once.
+	var mu sync.Mutex
+	var running, maxRunning, writeN int
+	s.ContinuousQueryWriters = map[string]influxdb.PointsWriter{"foo": PointsWriterFunc(func(database, retentionPolicy string, points []influxdb.Point) error {
+		mu.Lock()
+		running++
+		writeN++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mu.Unlock()
+
+		time.Sleep(20 * time.Millisecond)
+
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return nil
+	})}
+
+	for _, name := range []string{"cq0", "cq1", "cq2"} {
+		if err := s.CreateContinuousQuery(MustParseQuery(`CREATE CONTINUOUS QUERY ` + name + ` ON foo BEGIN SELECT count(value) INTO cpu_count FROM cpu GROUP BY time(1h) END`).Statements[0].(*influxql.CreateContinuousQueryStatement)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.MustWriteSeries("foo", "raw", []influxdb.Point{{Name: "cpu", Timestamp: time.Now().UTC(), Values: map[string]interface{}{"value": float64(30)}}})
+
+	// Queries that are still waiting are not queued again.
+	s.RunContinuousQueries()
+	s.RunContinuousQueries()
+	time.Sleep(200 * time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if maxRunning != 1 {
+		t.Fatalf("unexpected concurrent queries: %d", maxRunning)
+	} else if writeN != 3 {
+		t.Fatalf("unexpected write count: %d", writeN)
+	}
+}
+
+// PointsWriterFunc is an adapter to allow a function to be used as a PointsWriter.
+type PointsWriterFunc func(database, retentionPolicy string, points []influxdb.Point) error
+
+func (fn PointsWriterFunc) WritePoints(database, retentionPolicy string, points []influxdb.Point) error {
+	return fn(database, retentionPolicy, points)
+}
+
 func mustMarshalJSON(v interface{}) string {
 	b, err := json.Marshal(v)
 	if err != nil {
@@ -1361,6 +5583,142 @@ func (s *Server) Close() {
 	s.Server.Close()
 }
 
+// MemMetaStore is an in-memory metastore backend. Its data is saved as JSON
+// to the store's path when it's closed and read back when it's opened.
+type MemMetaStore struct {
+	mu   sync.RWMutex
+	path string
+	root *MemMetaBucket
+}
+
+// Open reads the store from path if it exists.
+func (m *MemMetaStore) Open(path string) error {
+	m.path, m.root = path, NewMemMetaBucket()
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, &m.root)
+}
+
+// Close saves the store to its path.
+func (m *MemMetaStore) Close() error {
+	if m.root == nil {
+		return nil
+	}
+	b, _ := json.Marshal(m.root)
+	return ioutil.WriteFile(m.path, b, 0600)
+}
+
+func (m *MemMetaStore) View(fn func(influxdb.MetaTx) error) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return fn(&MemMetaTx{m.root})
+}
+
+// Update applies changes to a copy of the store, which replaces the store if fn succeeds.
+func (m *MemMetaStore) Update(fn func(influxdb.MetaTx) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	root := m.root.clone()
+	if err := fn(&MemMetaTx{root}); err != nil {
+		return err
+	}
+	m.root = root
+	return nil
+}
+
+// MemMetaTx is a transaction on a MemMetaStore.
+type MemMetaTx struct {
+	root *MemMetaBucket
+}
+
+func (tx *MemMetaTx) Bucket(name []byte) influxdb.MetaBucket { return tx.root.Bucket(name) }
+func (tx *MemMetaTx) CreateBucketIfNotExists(name []byte) (influxdb.MetaBucket, error) {
+	return tx.root.CreateBucketIfNotExists(name)
+}
+func (tx *MemMetaTx) ForEach(fn func(name []byte, b influxdb.MetaBucket) error) error {
+	return tx.root.ForEach(func(k, _ []byte) error { return fn(k, tx.root.Buckets[string(k)]) })
+}
+func (tx *MemMetaTx) Size() int64 { b, _ := json.Marshal(tx.root); return int64(len(b)) }
+func (tx *MemMetaTx) WriteTo(w io.Writer) (int64, error) {
+	b, _ := json.Marshal(tx.root)
+	n, err := w.Write(b)
+	return int64(n), err
+}
+
+// MemMetaBucket is a bucket in a MemMetaStore.
+type MemMetaBucket struct {
+	Seq     uint64                    `json:"sequence"`
+	Values  map[string][]byte         `json:"values"`
+	Buckets map[string]*MemMetaBucket `json:"buckets"`
+}
+
+// NewMemMetaBucket returns a new, empty instance of MemMetaBucket.
+func NewMemMetaBucket() *MemMetaBucket {
+	return &MemMetaBucket{Values: make(map[string][]byte), Buckets: make(map[string]*MemMetaBucket)}
+}
+
+func (b *MemMetaBucket) Get(key []byte) []byte { return b.Values[string(key)] }
+func (b *MemMetaBucket) Put(key, value []byte) error {
+	b.Values[string(key)] = append([]byte{}, value...)
+	return nil
+}
+func (b *MemMetaBucket) Delete(key []byte) error { delete(b.Values, string(key)); return nil }
+func (b *MemMetaBucket) DeleteBucket(name []byte) error {
+	delete(b.Buckets, string(name))
+	return nil
+}
+func (b *MemMetaBucket) NextSequence() (uint64, error) { b.Seq++; return b.Seq, nil }
+func (b *MemMetaBucket) Sequence() uint64              { return b.Seq }
+
+func (b *MemMetaBucket) Bucket(name []byte) influxdb.MetaBucket {
+	if other := b.Buckets[string(name)]; other != nil {
+		return other
+	}
+	return nil
+}
+
+func (b *MemMetaBucket) CreateBucketIfNotExists(name []byte) (influxdb.MetaBucket, error) {
+	if b.Buckets[string(name)] == nil {
+		b.Buckets[string(name)] = NewMemMetaBucket()
+	}
+	return b.Buckets[string(name)], nil
+}
+
+// ForEach calls fn for each key & nested bucket in key order.
+func (b *MemMetaBucket) ForEach(fn func(k, v []byte) error) error {
+	var keys []string
+	for k := range b.Values {
+		keys = append(keys, k)
+	}
+	for k := range b.Buckets {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if err := fn([]byte(k), b.Values[k]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// clone returns a deep copy of the bucket.
+func (b *MemMetaBucket) clone() *MemMetaBucket {
+	other := NewMemMetaBucket()
+	other.Seq = b.Seq
+	for k, v := range b.Values {
+		other.Values[k] = v
+	}
+	for k, v := range b.Buckets {
+		other.Buckets[k] = v.clone()
+	}
+	return other
+}
+
 // MustWriteSeries writes series data and waits for the data to be applied.
 // Returns the messaging index for the write.
 func (s *Server) MustWriteSeries(database, retentionPolicy string, points []influxdb.Point) uint64 {
@@ -1375,6 +5733,7 @@ func (s *Server) MustWriteSeries(database, retentionPolicy string, points []infl
 
 // MessagingClient represents a test client for the messaging broker.
 type MessagingClient struct {
+	mu    sync.Mutex
 	index uint64
 	c     chan *messaging.Message
 
@@ -1383,6 +5742,7 @@ type MessagingClient struct {
 	DeleteReplicaFunc func(replicaID uint64) error
 	SubscribeFunc     func(replicaID, topicID uint64) error
 	UnsubscribeFunc   func(replicaID, topicID uint64) error
+	CloseFunc         func() error
 }
 
 // NewMessagingClient returns a new instance of MessagingClient.
@@ -1393,12 +5753,15 @@ func NewMessagingClient() *MessagingClient {
 	c.DeleteReplicaFunc = func(replicaID uint64) error { return nil }
 	c.SubscribeFunc = func(replicaID, topicID uint64) error { return nil }
 	c.UnsubscribeFunc = func(replicaID, topicID uint64) error { return nil }
+	c.CloseFunc = func() error { return nil }
 	return c
 }
 
 // Publish attaches an autoincrementing index to the message.
 // This function also execute's the client's PublishFunc mock function.
 func (c *MessagingClient) Publish(m *messaging.Message) (uint64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	c.index++
 	m.Index = c.index
 	return c.PublishFunc(m)
@@ -1434,6 +5797,9 @@ func (c *MessagingClient) Unsubscribe(replicaID, topicID uint64) error {
 // C returns a channel for streaming message.
 func (c *MessagingClient) C() <-chan *messaging.Message { return c.c }
 
+// Close executes the client's CloseFunc mock function.
+func (c *MessagingClient) Close() error { return c.CloseFunc() }
+
 // tempfile returns a temporary path.
 func tempfile() string {
 	f, _ := ioutil.TempFile("", "influxdb-")
@@ -1443,6 +5809,13 @@ func tempfile() string {
 	return path
 }
 
+// u64tob converts a uint64 into an 8-byte slice.
+func u64tob(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
+}
+
 // mustParseTime parses an IS0-8601 string. Panic on error.
 func mustParseTime(s string) time.Time {
 	t, err := time.Parse(time.RFC3339, s)
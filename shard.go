@@ -1,9 +1,18 @@
 package influxdb
 
 import (
+	"bytes"
+	"compress/flate"
+	"crypto/sha256"
 	"encoding/binary"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/boltdb/bolt"
@@ -15,6 +24,10 @@ type ShardGroup struct {
 	StartTime time.Time `json:"startTime,omitempty"`
 	EndTime   time.Time `json:"endTime,omitempty"`
 	Shards    []*Shard  `json:"shards,omitempty"`
+
+	// The end time the group was created with if it was cut short. Points
+	// written before then may be later than EndTime so reads still use it.
+	MaxTime time.Time `json:"maxTime,omitempty"`
 }
 
 // close closes all shards.
@@ -25,8 +38,32 @@ func (g *ShardGroup) close() {
 }
 
 // ShardBySeriesID returns the shard that a series is assigned to in the group.
+// A series is assigned among the shards that took new series when it was
+// created, so a series keeps writing to the same shard after a split.
 func (g *ShardGroup) ShardBySeriesID(seriesID uint32) *Shard {
-	return g.Shards[int(seriesID)%len(g.Shards)]
+	shards := g.Shards
+	for _, sh := range g.Shards {
+		if sh.Full || sh.MinSeriesID > 0 {
+			shards = g.shardsBySeriesID(seriesID)
+			break
+		}
+	}
+	return shards[int(seriesID)%len(shards)]
+}
+
+// shardsBySeriesID returns the shards of a split group that took new series
+// when a series was created. Returns every shard if none did.
+func (g *ShardGroup) shardsBySeriesID(seriesID uint32) []*Shard {
+	var a []*Shard
+	for _, sh := range g.Shards {
+		if seriesID > sh.MinSeriesID && (!sh.Full || seriesID <= sh.MaxSeriesID) {
+			a = append(a, sh)
+		}
+	}
+	if len(a) == 0 {
+		return g.Shards
+	}
+	return a
 }
 
 // Shard represents the logical storage for a given time range.
@@ -36,50 +73,537 @@ type Shard struct {
 	ID          uint64   `json:"id,omitempty"`
 	DataNodeIDs []uint64 `json:"nodeIDs,omitempty"` // owners
 
-	store *bolt.DB
+	// Set when an owner was forcibly removed from the cluster, until a new
+	// replica has been copied from a surviving owner.
+	Degraded bool `json:"degraded,omitempty"`
+
+	// Compression applied to points written to the shard. Copied from the
+	// retention policy when the shard's group is created.
+	Compression string `json:"compression,omitempty"`
+
+	// Set once the shard was split for exceeding the maximum shard size.
+	// Series created up to MaxSeriesID keep writing to the shard so that a
+	// point is never held by two shards. Newer series are written to the
+	// group's other shards.
+	Full        bool   `json:"full,omitempty"`
+	MaxSeriesID uint32 `json:"maxSeriesID,omitempty"`
+
+	// Set on a shard added by a split. Only series created after it are
+	// written to the shard.
+	MinSeriesID uint32 `json:"minSeriesID,omitempty"`
+
+	mu    sync.Mutex
+	path  string            // set while the shard is stored on this node
+	opt   ShardStoreOptions // tuning of the store, set when it's opened
+	store *bolt.DB          // nil until used if the shard has a cache
+	refs  int               // users of the open store
+	idle  *sync.Cond        // signaled when refs drops to zero, nil until needed
+	cache *shardStoreCache  // closes the store when it's not recently used
+
+	// Held by writers and locked by compaction so writes wait while the
+	// store is rewritten.
+	writeMu sync.RWMutex
+
+	// Activity since the shard was loaded.
+	pointN    int64     // points written
+	lastWrite time.Time // time of the last write
+	readN     int64     // read transactions
+	deadSize  int64     // bytes of values overwritten or deleted since the last compaction
+
+	sizeChecked time.Time // last check of the store's size for a split
+
+	gen uint64 // changes whenever the stored points may have changed
+}
+
+// ShardStoreOptions tunes the bolt stores that hold the points of shards.
+// Zero values use bolt's defaults.
+type ShardStoreOptions struct {
+	// The initial size of a store's memory map, in bytes. A map that is
+	// large enough for the store isn't remapped as it grows, which blocks
+	// writes.
+	MmapSize int
+
+	// If set, writes aren't synced to disk as they're made. This speeds up
+	// bulk imports but stores may be corrupted if the host crashes. Stores
+	// are still synced before the broker index of their writes is saved so
+	// that unsynced writes are replayed after a restart.
+	NoSync bool
+
+	// How full pages are left when they split, between 0.1 and 1. Points
+	// are mostly written in time order so a high value packs them tighter.
+	FillPercent float64
+}
+
+// Compressions of the points stored in a shard.
+const (
+	CompressionNone    = "none"
+	CompressionDeflate = "deflate"
+)
+
+// validCompression returns true if name is a supported compression.
+// An empty name leaves points uncompressed.
+func validCompression(name string) bool {
+	return name == "" || name == CompressionNone || name == CompressionDeflate
+}
+
+// Compressed point values start with a zero byte followed by the codec used.
+// Encoded values start with their field count which is only zero for points
+// without fields, and those encode to a single byte.
+const (
+	compressedValuesMarker  = 0
+	compressedValuesDeflate = 1
+)
+
+// flateWriters reuses DEFLATE writers since each one allocates large tables.
+var flateWriters = sync.Pool{
+	New: func() interface{} {
+		w, _ := flate.NewWriter(nil, flate.DefaultCompression)
+		return w
+	},
 }
 
+// compressValues returns encoded point values compressed with a compression.
+// The values are returned unchanged if compressing doesn't make them smaller.
+func compressValues(compression string, values []byte) []byte {
+	if compression != CompressionDeflate || len(values) < 2 {
+		return values
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte(compressedValuesMarker)
+	buf.WriteByte(compressedValuesDeflate)
+
+	w := flateWriters.Get().(*flate.Writer)
+	defer flateWriters.Put(w)
+	w.Reset(&buf)
+	if _, err := w.Write(values); err != nil {
+		return values
+	} else if err := w.Close(); err != nil {
+		return values
+	}
+
+	if buf.Len() >= len(values) {
+		return values
+	}
+	return buf.Bytes()
+}
+
+// decompressValues returns the encoded point values of stored values.
+// Values that weren't compressed are returned unchanged.
+func decompressValues(values []byte) ([]byte, error) {
+	if len(values) < 2 || values[0] != compressedValuesMarker {
+		return values, nil
+	}
+
+	switch values[1] {
+	case compressedValuesDeflate:
+		return ioutil.ReadAll(flate.NewReader(bytes.NewReader(values[2:])))
+	default:
+		return nil, fmt.Errorf("unknown values compression: %d", values[1])
+	}
+}
+
+// shardsByID represents a list of shards sortable by id.
+type shardsByID []*Shard
+
+func (a shardsByID) Len() int           { return len(a) }
+func (a shardsByID) Less(i, j int) bool { return a[i].ID < a[j].ID }
+func (a shardsByID) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+
 // newShardGroup returns a new initialized ShardGroup instance.
 func newShardGroup() *ShardGroup { return &ShardGroup{} }
 
 // Duration returns the duration between the shard group's start and end time.
 func (g *ShardGroup) Duration() time.Duration { return g.EndTime.Sub(g.StartTime) }
 
+// maxTime returns the latest time of the points in the group.
+func (g *ShardGroup) maxTime() time.Time {
+	if g.MaxTime.After(g.EndTime) {
+		return g.MaxTime
+	}
+	return g.EndTime
+}
+
+// overlaps returns true if the shard group's time range overlaps min and max, inclusive.
+func (g *ShardGroup) overlaps(min, max time.Time) bool {
+	return !g.StartTime.After(max) && !g.maxTime().Before(min)
+}
+
 // newShard returns a new initialized Shard instance.
 func newShard() *Shard { return &Shard{} }
 
-// open initializes and opens the shard's store.
-func (s *Shard) open(path string) error {
+// shardGen is the last generation given to a shard, updated atomically.
+// Generations are unique across shards so a shard that is replaced never
+// reuses the generation of the shard it replaced.
+var shardGen uint64
+
+// generation returns a value that changes whenever the points stored in the
+// shard may have changed. Returns zero if the shard is not stored locally.
+func (s *Shard) generation() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.path == "" {
+		return 0
+	}
+	return s.gen
+}
+
+// open initializes and opens the shard's store. A shard with a cache only
+// opens its store when it's first used. Bolt's defaults are used if opt is nil.
+func (s *Shard) open(path string, opt *ShardStoreOptions) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	// Return an error if the shard is already open.
-	if s.store != nil {
+	if s.path != "" {
 		return errors.New("shard already open")
 	}
 
-	// Open store on shard.
-	store, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 1 * time.Second})
+	s.opt = ShardStoreOptions{}
+	if opt != nil {
+		s.opt = *opt
+	}
+
+	if s.cache == nil {
+		if err := s.openStore(path); err != nil {
+			return err
+		}
+	}
+	s.path = path
+	s.gen = atomic.AddUint64(&shardGen, 1)
+	return nil
+}
+
+// openStore opens and initializes the store at path. Lock must be held.
+func (s *Shard) openStore(path string) error {
+	store, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 1 * time.Second, InitialMmapSize: s.opt.MmapSize})
 	if err != nil {
 		return err
 	}
-	s.store = store
+	store.NoSync = s.opt.NoSync
 
 	// Initialize store.
-	if err := s.store.Update(func(tx *bolt.Tx) error {
+	if err := store.Update(func(tx *bolt.Tx) error {
 		_, _ = tx.CreateBucketIfNotExists([]byte("values"))
 		return nil
 	}); err != nil {
-		_ = s.close()
+		_ = store.Close()
 		return fmt.Errorf("init: %s", err)
 	}
 
+	s.store = store
 	return nil
 }
 
-// close shuts down the shard's store.
+// close shuts down the shard's store. The shard is no longer stored locally
+// until it is opened again.
 func (s *Shard) close() error {
-	if s.store == nil {
+	s.mu.Lock()
+	store := s.store
+	s.path, s.store = "", nil
+	s.mu.Unlock()
+
+	if s.cache != nil {
+		s.cache.remove(s)
+	}
+	if store == nil {
+		return nil
+	}
+	return closeStore(store)
+}
+
+// closeStore closes a shard's store. A store that doesn't sync its writes is
+// synced first so that writes are on disk once it's closed.
+func closeStore(store *bolt.DB) error {
+	if store.NoSync {
+		if err := store.Sync(); err != nil {
+			_ = store.Close()
+			return err
+		}
+	}
+	return store.Close()
+}
+
+// sync flushes the writes to the shard's store to disk if the store doesn't
+// sync them itself. A closed store was synced when it was closed.
+func (s *Shard) sync() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.store == nil || !s.store.NoSync {
 		return nil
 	}
-	return s.store.Close()
+	return s.store.Sync()
+}
+
+// closeIdle closes the shard's store if nothing is using it.
+// Returns false if the store is in use.
+func (s *Shard) closeIdle() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.refs > 0 {
+		return false
+	}
+	if s.store != nil {
+		_ = closeStore(s.store)
+		s.store = nil
+	}
+	return true
+}
+
+// hasStore returns true if the shard is stored locally. Its store may be
+// closed until the shard is next used.
+func (s *Shard) hasStore() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.path != ""
+}
+
+// storePath returns the path of the shard's store.
+// Returns a blank path if the shard is not stored locally.
+func (s *Shard) storePath() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.path
+}
+
+// acquire returns the shard's store, opening it if it isn't open. The store
+// is kept open until release is called.
+func (s *Shard) acquire() (*bolt.DB, error) {
+	s.mu.Lock()
+	if s.path == "" {
+		s.mu.Unlock()
+		return nil, ErrShardNotFound
+	} else if s.store == nil {
+		if err := s.openStore(s.path); err != nil {
+			s.mu.Unlock()
+			return nil, err
+		}
+	}
+	s.refs++
+	store := s.store
+	s.mu.Unlock()
+
+	// Closing stores that haven't been used recently may take a while so
+	// it's done after unlocking.
+	if s.cache != nil {
+		s.cache.touch(s)
+	}
+	return store, nil
+}
+
+// release marks the store returned by acquire as no longer used.
+func (s *Shard) release() {
+	s.mu.Lock()
+	s.refs--
+	if s.refs == 0 && s.idle != nil {
+		s.idle.Broadcast()
+	}
+	s.mu.Unlock()
+}
+
+// view executes fn within a read-only transaction on the shard's store.
+func (s *Shard) view(fn func(*bolt.Tx) error) error {
+	store, err := s.acquire()
+	if err != nil {
+		return err
+	}
+	defer s.release()
+	s.recordRead()
+	return store.View(fn)
+}
+
+// recordRead counts a read transaction on the shard's store.
+func (s *Shard) recordRead() {
+	s.mu.Lock()
+	s.readN++
+	s.mu.Unlock()
+}
+
+// recordWrite counts points written to the shard.
+func (s *Shard) recordWrite(n int) {
+	s.mu.Lock()
+	s.pointN += int64(n)
+	s.lastWrite = time.Now().UTC()
+	s.mu.Unlock()
+}
+
+// sizeCheckDue returns true and marks the shard as checked if its size
+// hasn't been checked within interval.
+func (s *Shard) sizeCheckDue(now time.Time, interval time.Duration) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now.Sub(s.sizeChecked) < interval {
+		return false
+	}
+	s.sizeChecked = now
+	return true
+}
+
+// recordDead counts bytes of values that were overwritten or deleted.
+func (s *Shard) recordDead(n int) {
+	if n == 0 {
+		return
+	}
+	s.mu.Lock()
+	s.deadSize += int64(n)
+	s.mu.Unlock()
+}
+
+// stats returns the size, series count and activity of the shard and the
+// bytes used by each series. The shard's store is opened if it was closed.
+func (s *Shard) stats() (*ShardStats, map[uint32]int64, error) {
+	store, err := s.acquire()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer s.release()
+
+	st := &ShardStats{ShardID: s.ID}
+	sizes := make(map[uint32]int64)
+	if err := store.View(func(tx *bolt.Tx) error {
+		st.Size = tx.Size()
+		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			// Only series buckets are named by a series id.
+			if len(name) == 4 {
+				st.SeriesN++
+				bs := b.Stats()
+				sizes[btou32(name)] = int64(bs.BranchInuse + bs.LeafInuse + bs.InlineBucketInuse)
+			}
+			return nil
+		})
+	}); err != nil {
+		return nil, nil, err
+	}
+
+	s.mu.Lock()
+	st.PointsWritten, st.LastWrite, st.ReadN = s.pointN, s.lastWrite, s.readN
+	s.mu.Unlock()
+	return st, sizes, nil
+}
+
+// update executes fn within a read-write transaction on the shard's store.
+func (s *Shard) update(fn func(*bolt.Tx) error) error {
+	s.writeMu.RLock()
+	defer s.writeMu.RUnlock()
+
+	store, err := s.acquire()
+	if err != nil {
+		return err
+	}
+	defer s.release()
+	err = store.Update(fn)
+
+	s.mu.Lock()
+	s.gen = atomic.AddUint64(&shardGen, 1)
+	s.mu.Unlock()
+	return err
+}
+
+// size returns the size of the shard's store, in bytes. A closed store's
+// size is read from its file. Returns zero if the shard is not stored locally.
+func (s *Shard) size() (n int64, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.path == "" {
+		return 0, nil
+	} else if s.store == nil {
+		fi, err := os.Stat(s.path)
+		if os.IsNotExist(err) {
+			return 0, nil
+		} else if err != nil {
+			return 0, err
+		}
+		return fi.Size(), nil
+	}
+	err = s.store.View(func(tx *bolt.Tx) error {
+		n = tx.Size()
+		return nil
+	})
+	return
+}
+
+// reclaimableSize estimates the space, in bytes, that compacting the shard
+// would reclaim: the free pages of its store plus the values overwritten or
+// deleted since it was last compacted. Values replaced before the shard was
+// loaded aren't counted.
+func (s *Shard) reclaimableSize() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := s.deadSize
+	if s.store != nil {
+		stats := s.store.Stats()
+		n += int64(stats.FreePageN+stats.PendingPageN) * int64(s.store.Info().PageSize)
+	}
+	return n
+}
+
+// compact rewrites the shard's store into a new file, which drops the space
+// left by overwritten and deleted points, and swaps it in if it's smaller.
+// Writes wait until it finishes and the swap waits for reads to finish.
+// Returns the size of the store before and after.
+func (s *Shard) compact() (before, after int64, err error) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	store, err := s.acquire()
+	if err != nil {
+		return 0, 0, err
+	}
+	path := store.Path()
+	tmpPath := path + ".compact"
+	_ = os.Remove(tmpPath) // left by an interrupted compaction
+	defer os.Remove(tmpPath)
+	err = writeCompactedBolt(store, tmpPath)
+	s.release()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	before = fi.Size()
+	if fi, err = os.Stat(tmpPath); err != nil {
+		return 0, 0, err
+	}
+	after = fi.Size()
+	if after >= before {
+		s.mu.Lock()
+		s.deadSize = 0
+		s.mu.Unlock()
+		return before, before, nil
+	}
+
+	// Swap in the compacted file once the store isn't used.
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.idle == nil {
+		s.idle = sync.NewCond(&s.mu)
+	}
+	for s.refs > 0 {
+		s.idle.Wait()
+	}
+	if s.path != path {
+		return 0, 0, ErrShardNotFound // closed while compacting
+	}
+	open := s.store != nil
+	if open {
+		if err := closeStore(s.store); err != nil {
+			return 0, 0, err
+		}
+		s.store = nil
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return 0, 0, err
+	}
+	if open {
+		if err := s.openStore(path); err != nil {
+			return 0, 0, err
+		}
+	}
+	s.deadSize = 0
+	return before, after, nil
 }
 
 // HasDataNodeID return true if the data node owns the shard.
@@ -94,7 +618,7 @@ func (s *Shard) HasDataNodeID(id uint64) bool {
 
 // readSeries reads encoded series data from a shard.
 func (s *Shard) readSeries(seriesID uint32, timestamp int64) (values []byte, err error) {
-	err = s.store.View(func(tx *bolt.Tx) error {
+	err = s.view(func(tx *bolt.Tx) error {
 		// Find series bucket.
 		b := tx.Bucket(u32tob(seriesID))
 		if b == nil {
@@ -102,15 +626,86 @@ func (s *Shard) readSeries(seriesID uint32, timestamp int64) (values []byte, err
 		}
 
 		// Retrieve encoded series data.
-		values = b.Get(u64tob(uint64(timestamp)))
-		return nil
+		values, err = decompressValues(b.Get(u64tob(uint64(timestamp))))
+		return err
 	})
 	return
 }
 
-// writeSeries writes series data to a shard.
+// ShardCursor iterates over the points of a series stored in a shard within
+// a time range, oldest first. The shard's store is kept open in a read-only
+// transaction until the cursor is closed.
+type ShardCursor struct {
+	shard *Shard
+	tx    *bolt.Tx
+	cur   *bolt.Cursor // nil if the series has no points in the shard
+	seek  []byte       // key of the first point, until Next is first called
+	end   int64
+}
+
+// Cursor returns a cursor over the points of a series with timestamps between
+// start and end, in nanoseconds and inclusive. The cursor must be closed.
+func (s *Shard) Cursor(seriesID uint32, start, end int64) (*ShardCursor, error) {
+	store, err := s.acquire()
+	if err != nil {
+		return nil, err
+	}
+	s.recordRead()
+	tx, err := store.Begin(false)
+	if err != nil {
+		s.release()
+		return nil, err
+	}
+
+	c := &ShardCursor{shard: s, tx: tx, seek: u64tob(uint64(start)), end: end}
+	if b := tx.Bucket(u32tob(seriesID)); b != nil {
+		c.cur = b.Cursor()
+	}
+	return c, nil
+}
+
+// Next returns the timestamp and encoded values of the next point. The values
+// are nil once there are no more points in the range and remain valid until
+// the cursor is closed.
+func (c *ShardCursor) Next() (timestamp int64, values []byte, err error) {
+	if c.cur == nil {
+		return 0, nil, nil
+	}
+
+	var k, v []byte
+	if c.seek != nil {
+		k, v = c.cur.Seek(c.seek)
+		c.seek = nil
+	} else {
+		k, v = c.cur.Next()
+	}
+	if k == nil || int64(btou64(k)) > c.end {
+		c.cur = nil
+		return 0, nil, nil
+	}
+
+	if values, err = decompressValues(v); err != nil {
+		return 0, nil, err
+	}
+	return int64(btou64(k)), values, nil
+}
+
+// Close ends the cursor's transaction and releases the shard's store.
+func (c *ShardCursor) Close() error {
+	if c.tx == nil {
+		return nil
+	}
+	err := c.tx.Rollback()
+	c.tx, c.cur = nil, nil
+	c.shard.release()
+	return err
+}
+
+// writeSeries writes series data to a shard, compressed if the shard is.
 func (s *Shard) writeSeries(seriesID uint32, timestamp int64, values []byte, overwrite bool) error {
-	return s.store.Update(func(tx *bolt.Tx) error {
+	values = compressValues(s.Compression, values)
+	var dead int
+	if err := s.update(func(tx *bolt.Tx) error {
 		// Create a bucket for the series.
 		b, err := tx.CreateBucketIfNotExists(u32tob(seriesID))
 		if err != nil {
@@ -118,12 +713,268 @@ func (s *Shard) writeSeries(seriesID uint32, timestamp int64, values []byte, ove
 		}
 
 		// Insert the values by timestamp.
-		if err := b.Put(u64tob(uint64(timestamp)), values); err != nil {
+		if s.opt.FillPercent > 0 {
+			b.FillPercent = s.opt.FillPercent
+		}
+		key := u64tob(uint64(timestamp))
+		dead = len(b.Get(key))
+		if err := b.Put(key, values); err != nil {
 			return err
 		}
 
 		return nil
+	}); err != nil {
+		return err
+	}
+	s.recordWrite(1)
+	s.recordDead(dead)
+	return nil
+}
+
+// hasPoints returns true if the shard holds any points for a series.
+func (s *Shard) hasPoints(seriesID uint32) (ok bool, err error) {
+	err = s.view(func(tx *bolt.Tx) error {
+		if b := tx.Bucket(u32tob(seriesID)); b != nil {
+			k, _ := b.Cursor().First()
+			ok = k != nil
+		}
+		return nil
+	})
+	return
+}
+
+// countPoints returns the number of points stored for a set of series
+// between tmin and tmax, inclusive.
+func (s *Shard) countPoints(seriesIDs []uint32, tmin, tmax int64) (n int, err error) {
+	err = s.view(func(tx *bolt.Tx) error {
+		for _, id := range seriesIDs {
+			// Skip series that have no data in this shard.
+			b := tx.Bucket(u32tob(id))
+			if b == nil {
+				continue
+			}
+
+			// Count keys within the time range.
+			c := b.Cursor()
+			for k, _ := c.Seek(u64tob(uint64(tmin))); k != nil && int64(btou64(k)) <= tmax; k, _ = c.Next() {
+				n++
+			}
+		}
+		return nil
+	})
+	return
+}
+
+// seriesIDsWithPoints returns the series that have at least one point stored
+// between tmin and tmax, inclusive.
+func (s *Shard) seriesIDsWithPoints(seriesIDs []uint32, tmin, tmax int64) (a []uint32, err error) {
+	err = s.view(func(tx *bolt.Tx) error {
+		for _, id := range seriesIDs {
+			b := tx.Bucket(u32tob(id))
+			if b == nil {
+				continue
+			}
+
+			if k, _ := b.Cursor().Seek(u64tob(uint64(tmin))); k != nil && int64(btou64(k)) <= tmax {
+				a = append(a, id)
+			}
+		}
+		return nil
+	})
+	return
+}
+
+// deletePointsBefore removes the points stored for a set of series with a
+// timestamp earlier than tmax. Returns the number of points removed.
+func (s *Shard) deletePointsBefore(seriesIDs []uint32, tmax int64) (n int, err error) {
+	var dead int
+	err = s.update(func(tx *bolt.Tx) error {
+		for _, id := range seriesIDs {
+			b := tx.Bucket(u32tob(id))
+			if b == nil {
+				continue
+			}
+
+			// Deleting with the cursor moves it to the next key.
+			c := b.Cursor()
+			for k, v := c.First(); k != nil && int64(btou64(k)) < tmax; k, v = c.First() {
+				dead += len(k) + len(v)
+				if err := c.Delete(); err != nil {
+					return err
+				}
+				n++
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		s.recordDead(dead)
+	}
+	return
+}
+
+// copy writes a consistent copy of the shard's store to w.
+func (s *Shard) copy(w io.Writer) error {
+	return s.view(func(tx *bolt.Tx) error {
+		return tx.Copy(w)
+	})
+}
+
+// copySeries writes a copy of the shard's store to w that only contains the
+// data for a set of series.
+func (s *Shard) copySeries(w io.Writer, seriesIDs []uint32) error {
+	if !s.hasStore() {
+		return ErrShardNotFound
+	}
+
+	// Build the partial copy in a temporary store.
+	f, err := ioutil.TempFile("", "influxdb-shard-")
+	if err != nil {
+		return err
+	}
+	path := f.Name()
+	_ = f.Close()
+	defer os.Remove(path)
+
+	dst, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	if err := s.view(func(tx *bolt.Tx) error {
+		return dst.Update(func(dstTx *bolt.Tx) error {
+			for _, id := range seriesIDs {
+				b := tx.Bucket(u32tob(id))
+				if b == nil {
+					continue
+				}
+				dstBucket, err := dstTx.CreateBucketIfNotExists(u32tob(id))
+				if err != nil {
+					return err
+				}
+				if err := b.ForEach(func(k, v []byte) error { return dstBucket.Put(k, v) }); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}); err != nil {
+		return err
+	}
+
+	return dst.View(func(tx *bolt.Tx) error {
+		return tx.Copy(w)
+	})
+}
+
+// digest returns a hash of the timestamps stored for each series in the shard.
+// Replicas that return the same hash for a series have the same points.
+func (s *Shard) digest() (map[uint32]string, error) {
+	m := make(map[uint32]string)
+	err := s.view(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			// Only series buckets are named by a series id.
+			if len(name) != 4 {
+				return nil
+			}
+
+			h := sha256.New()
+			_ = b.ForEach(func(k, _ []byte) error {
+				h.Write(k)
+				return nil
+			})
+			m[btou32(name)] = hex.EncodeToString(h.Sum(nil))
+			return nil
+		})
+	})
+	return m, err
+}
+
+// verify returns checksums of the points stored in the shard and the problems
+// found in its store. Each series' checksum covers its timestamps and
+// uncompressed values so replicas with the same points have the same
+// checksums. The values of the series in codecs are checked to be complete.
+func (s *Shard) verify(codecs map[uint32]*FieldCodec) (*ShardChecksum, error) {
+	c := &ShardChecksum{ShardID: s.ID, Series: make(map[uint32]string)}
+	shardHash := sha256.New()
+	err := s.view(func(tx *bolt.Tx) error {
+		for err := range tx.Check() {
+			c.Problems = append(c.Problems, err.Error())
+		}
+
+		// Buckets are iterated in series id order.
+		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			// Only series buckets are named by a series id.
+			if len(name) != 4 {
+				return nil
+			}
+			seriesID := btou32(name)
+			codec := codecs[seriesID]
+
+			h := sha256.New()
+			_ = b.ForEach(func(k, v []byte) error {
+				if len(k) != 8 {
+					c.Problems = append(c.Problems, fmt.Sprintf("series %d: invalid timestamp key %x", seriesID, k))
+					return nil
+				}
+				v, err := decompressValues(v)
+				if err != nil {
+					c.Problems = append(c.Problems, fmt.Sprintf("series %d at %d: %s", seriesID, btou64(k), err))
+					return nil
+				} else if codec != nil {
+					if err := codec.ValidateFields(v); err != nil {
+						c.Problems = append(c.Problems, fmt.Sprintf("series %d at %d: %s", seriesID, btou64(k), err))
+					}
+				}
+				h.Write(k)
+				h.Write(u32tob(uint32(len(v))))
+				h.Write(v)
+				return nil
+			})
+
+			sum := h.Sum(nil)
+			c.Series[seriesID] = hex.EncodeToString(sum)
+			shardHash.Write(name)
+			shardHash.Write(sum)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	c.Checksum = hex.EncodeToString(shardHash.Sum(nil))
+	return c, nil
+}
+
+// merge copies the series data from another shard store into the shard.
+// Points that already exist in the shard are kept as newer writes may have
+// replaced them. Returns the number of points copied.
+func (s *Shard) merge(src *bolt.DB) (n int, err error) {
+	err = src.View(func(srcTx *bolt.Tx) error {
+		return s.update(func(tx *bolt.Tx) error {
+			return srcTx.ForEach(func(name []byte, srcBucket *bolt.Bucket) error {
+				b, err := tx.CreateBucketIfNotExists(name)
+				if err != nil {
+					return err
+				}
+				if s.opt.FillPercent > 0 {
+					b.FillPercent = s.opt.FillPercent
+				}
+				return srcBucket.ForEach(func(k, v []byte) error {
+					if b.Get(k) != nil {
+						return nil
+					}
+					n++
+					return b.Put(k, v)
+				})
+			})
+		})
 	})
+	if err == nil && n > 0 {
+		s.recordWrite(n)
+	}
+	return
 }
 
 func (s *Shard) deleteSeries(name string) error {
@@ -0,0 +1,68 @@
+package influxdb
+
+import (
+	"container/list"
+	"sync"
+)
+
+// shardStoreCache limits the number of shard stores open at once. Stores are
+// opened when their shard is used and once the limit is exceeded the stores
+// of the least recently used shards are closed, unless they are in use. It is
+// safe for concurrent use.
+type shardStoreCache struct {
+	mu      sync.Mutex
+	limit   int                      // maximum stores kept open, unlimited if zero
+	lru     *list.List               // most recently used at the front
+	entries map[*Shard]*list.Element // entries by shard
+}
+
+// newShardStoreCache returns a new instance of shardStoreCache.
+func newShardStoreCache(limit int) *shardStoreCache {
+	return &shardStoreCache{
+		limit:   limit,
+		lru:     list.New(),
+		entries: make(map[*Shard]*list.Element),
+	}
+}
+
+// touch marks a shard's store as the most recently used. The stores of the
+// least recently used shards that aren't in use are closed until the limit
+// is met.
+func (c *shardStoreCache) touch(sh *Shard) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e := c.entries[sh]; e != nil {
+		c.lru.MoveToFront(e)
+	} else {
+		c.entries[sh] = c.lru.PushFront(sh)
+	}
+
+	if c.limit <= 0 {
+		return
+	}
+	for e := c.lru.Back(); e != nil && c.lru.Len() > c.limit; {
+		prev := e.Prev()
+		if other := e.Value.(*Shard); other.closeIdle() {
+			c.lru.Remove(e)
+			delete(c.entries, other)
+		}
+		e = prev
+	}
+}
+
+// remove forgets a shard whose store was closed.
+func (c *shardStoreCache) remove(sh *Shard) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e := c.entries[sh]; e != nil {
+		c.lru.Remove(e)
+		delete(c.entries, sh)
+	}
+}
+
+// size returns the number of stores kept open.
+func (c *shardStoreCache) size() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
@@ -0,0 +1,117 @@
+package influxdb
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"time"
+)
+
+// ShardCompactMinReclaimable is the fraction of a shard's store that must be
+// reclaimable before CompactShards rewrites it.
+const ShardCompactMinReclaimable = 0.1
+
+// CompactShard rewrites a local shard's store to reclaim the space left by
+// overwritten and deleted points. Writes to the shard wait until it finishes.
+// Returns the size of the store before and after.
+func (s *Server) CompactShard(id uint64) (before, after int64, err error) {
+	s.mu.RLock()
+	sh := s.shards[id]
+	s.mu.RUnlock()
+	if sh == nil || !sh.hasStore() {
+		return 0, 0, ErrShardNotFound
+	}
+
+	if before, after, err = sh.compact(); err != nil {
+		return 0, 0, err
+	}
+	s.stats.Inc("shardCompact")
+	s.stats.Add("shardCompactBytes", before-after)
+	return before, after, nil
+}
+
+// CompactShards compacts the local shards where at least
+// ShardCompactMinReclaimable of their store is free pages or values that were
+// overwritten or deleted. Returns the number of bytes reclaimed. Shards that
+// fail to compact are skipped and the last error is returned.
+func (s *Server) CompactShards() (n int64, err error) {
+	s.mu.RLock()
+	var ids []uint64
+	for id, sh := range s.shards {
+		if sh.hasStore() {
+			ids = append(ids, id)
+		}
+	}
+	s.mu.RUnlock()
+	sort.Sort(uint64Slice(ids))
+
+	for _, id := range ids {
+		sh := s.Shard(id)
+		if sh == nil {
+			continue
+		}
+		size, e := sh.size()
+		if e != nil || size == 0 || float64(sh.reclaimableSize()) < float64(size)*ShardCompactMinReclaimable {
+			continue
+		}
+
+		before, after, e := s.CompactShard(id)
+		if e == ErrShardNotFound {
+			continue
+		} else if e != nil {
+			err = fmt.Errorf("shard %d: %s", id, e)
+			continue
+		}
+		if before > after {
+			log.Printf("compacted shard %d from %d to %d bytes", id, before, after)
+		}
+		n += before - after
+	}
+	return n, err
+}
+
+// StartShardCompaction launches a background service that periodically
+// compacts the local shards.
+func (s *Server) StartShardCompaction(checkInterval time.Duration) error {
+	if checkInterval == 0 {
+		return fmt.Errorf("shard compaction check interval must be non-zero")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.shardCompactDone != nil {
+		return fmt.Errorf("shard compaction already started")
+	}
+
+	shardCompactDone := make(chan struct{}, 0)
+	s.shardCompactDone = shardCompactDone
+	s.shardCompactWG.Add(1)
+	go func() {
+		defer s.shardCompactWG.Done()
+		for {
+			select {
+			case <-shardCompactDone:
+				return
+			case <-time.After(checkInterval):
+				if _, err := s.CompactShards(); err != nil {
+					log.Printf("shard compaction: %s", err)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// StopShardCompaction stops the shard compaction service. It waits for an
+// in-flight compaction to finish before returning.
+func (s *Server) StopShardCompaction() {
+	s.mu.Lock()
+	shardCompactDone := s.shardCompactDone
+	s.shardCompactDone = nil
+	s.mu.Unlock()
+
+	if shardCompactDone != nil {
+		close(shardCompactDone)
+	}
+	s.shardCompactWG.Wait()
+}
@@ -0,0 +1,145 @@
+package influxdb
+
+import (
+	"sort"
+	"time"
+)
+
+// ShardStats describes the size and activity of a local shard. Activity is
+// counted from when the shard was loaded by the server.
+type ShardStats struct {
+	ShardID         uint64    `json:"shardID"`
+	Database        string    `json:"database"`
+	RetentionPolicy string    `json:"retentionPolicy"`
+	Size            int64     `json:"size"`          // bytes on disk
+	SeriesN         int       `json:"seriesN"`       // series with points in the shard
+	PointsWritten   int64     `json:"pointsWritten"` // including points copied from replicas
+	LastWrite       time.Time `json:"lastWrite"`     // zero if no points were written
+	ReadN           int64     `json:"readN"`         // read transactions
+
+	// Approximate bytes used by the points of each measurement, by name.
+	Measurements map[string]int64 `json:"measurements"`
+}
+
+// DatabaseShardStats totals the statistics of a database's local shards.
+type DatabaseShardStats struct {
+	Database      string           `json:"database"`
+	Size          int64            `json:"size"`
+	SeriesN       int              `json:"seriesN"` // a series is counted once per shard
+	PointsWritten int64            `json:"pointsWritten"`
+	LastWrite     time.Time        `json:"lastWrite"`
+	ReadN         int64            `json:"readN"`
+	Measurements  map[string]int64 `json:"measurements"`
+	Shards        []*ShardStats    `json:"shards"`
+}
+
+// ShardStats returns the statistics of a local shard.
+func (s *Server) ShardStats(id uint64) (*ShardStats, error) {
+	s.mu.RLock()
+	sh := s.shards[id]
+	var database, policy string
+	var names map[uint32]string
+	if db, rp := s.shardPolicy(id); db != nil {
+		database, policy = db.name, rp.Name
+		names = db.measurementNamesBySeriesID()
+	}
+	s.mu.RUnlock()
+	if sh == nil || !sh.hasStore() {
+		return nil, ErrShardNotFound
+	}
+
+	st, sizes, err := sh.stats()
+	if err != nil {
+		return nil, err
+	}
+	st.Database, st.RetentionPolicy = database, policy
+
+	// Points of series that have since been dropped aren't counted.
+	st.Measurements = make(map[string]int64)
+	for seriesID, n := range sizes {
+		if name, ok := names[seriesID]; ok {
+			st.Measurements[name] += n
+		}
+	}
+	return st, nil
+}
+
+// DatabaseShardStats returns the statistics of each of a database's local
+// shards, ordered by id, and their totals.
+func (s *Server) DatabaseShardStats(database string) (*DatabaseShardStats, error) {
+	s.mu.RLock()
+	db := s.databases[database]
+	if db == nil {
+		s.mu.RUnlock()
+		return nil, ErrDatabaseNotFound
+	}
+	var ids []uint64
+	for _, rp := range db.policies {
+		for _, g := range rp.shardGroups {
+			for _, sh := range g.Shards {
+				if sh.hasStore() {
+					ids = append(ids, sh.ID)
+				}
+			}
+		}
+	}
+	s.mu.RUnlock()
+	sort.Sort(uint64Slice(ids))
+
+	stats := &DatabaseShardStats{
+		Database:     database,
+		Measurements: make(map[string]int64),
+		Shards:       []*ShardStats{},
+	}
+	for _, id := range ids {
+		st, err := s.ShardStats(id)
+		if err == ErrShardNotFound {
+			continue // deleted or moved since it was listed
+		} else if err != nil {
+			return nil, err
+		}
+
+		stats.Size += st.Size
+		stats.SeriesN += st.SeriesN
+		stats.PointsWritten += st.PointsWritten
+		stats.ReadN += st.ReadN
+		for name, n := range st.Measurements {
+			stats.Measurements[name] += n
+		}
+		if st.LastWrite.After(stats.LastWrite) {
+			stats.LastWrite = st.LastWrite
+		}
+		stats.Shards = append(stats.Shards, st)
+	}
+	return stats, nil
+}
+
+// measurementNamesBySeriesID returns the name of the measurement of each
+// series in the database's index.
+func (db *database) measurementNamesBySeriesID() map[uint32]string {
+	names := make(map[uint32]string)
+	for name, m := range db.measurements {
+		for seriesID := range m.index().seriesByID {
+			names[seriesID] = name
+		}
+	}
+	return names
+}
+
+// measurementSize is the disk space used by a measurement.
+type measurementSize struct {
+	name string
+	size int64
+}
+
+// measurementSizes sorts measurements by size, largest first, then by name.
+type measurementSizes []measurementSize
+
+func (a measurementSizes) Len() int      { return len(a) }
+func (a measurementSizes) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a measurementSizes) Less(i, j int) bool {
+	if a[i].size != a[j].size {
+		return a[i].size > a[j].size
+	}
+	return a[i].name < a[j].name
+}
@@ -0,0 +1,93 @@
+package influxdb
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// ShardChecksum holds the checksums of the points stored in a shard and the
+// problems found in its store by VerifyShard.
+type ShardChecksum struct {
+	ShardID  uint64            `json:"shardID"`
+	Checksum string            `json:"checksum"`           // hex encoded SHA-256 of the series checksums
+	Series   map[uint32]string `json:"series"`             // hex encoded SHA-256 of each series' points
+	Problems []string          `json:"problems,omitempty"` // corrupted pages or values
+}
+
+// OK returns true if no problems were found.
+func (c *ShardChecksum) OK() bool { return len(c.Problems) == 0 }
+
+// Diff returns the ids of the series whose points differ from another
+// replica's, including series only stored by one of them, in sorted order.
+func (c *ShardChecksum) Diff(other *ShardChecksum) []uint32 {
+	if c.Checksum == other.Checksum {
+		return nil
+	}
+
+	var a []uint32
+	for id, sum := range c.Series {
+		if other.Series[id] != sum {
+			a = append(a, id)
+		}
+	}
+	for id := range other.Series {
+		if _, ok := c.Series[id]; !ok {
+			a = append(a, id)
+		}
+	}
+	sort.Sort(seriesIDs(a))
+	return a
+}
+
+// VerifyShard computes the checksums of a local shard's series and of the
+// whole shard and checks its store for corruption, such as damaged pages or
+// the truncated values of a torn write. Values are checked against the fields
+// of their measurement.
+func (s *Server) VerifyShard(id uint64) (*ShardChecksum, error) {
+	s.mu.RLock()
+	sh := s.shards[id]
+	codecs := make(map[uint32]*FieldCodec)
+	if db, _ := s.shardPolicy(id); db != nil {
+		for _, m := range db.measurements {
+			codec := NewFieldCodec(m)
+			for seriesID := range m.index().seriesByID {
+				codecs[seriesID] = codec
+			}
+		}
+	}
+	s.mu.RUnlock()
+	if sh == nil || !sh.hasStore() {
+		return nil, ErrShardNotFound
+	}
+
+	c, err := sh.verify(codecs)
+	if err != nil {
+		return nil, err
+	}
+	s.stats.Inc("shardVerifyReq")
+	s.stats.Add("shardVerifyProblems", int64(len(c.Problems)))
+	return c, nil
+}
+
+// CompareShard compares the checksums of a local shard with those of another
+// data node that owns the shard. Returns the ids of the series that differ.
+func (s *Server) CompareShard(shardID, nodeID uint64) ([]uint32, error) {
+	n := s.DataNode(nodeID)
+	if n == nil {
+		return nil, ErrDataNodeNotFound
+	}
+
+	local, err := s.VerifyShard(shardID)
+	if err != nil {
+		return nil, err
+	}
+	var remote ShardChecksum
+	if err := s.getShardRepairData(n.URL, "/shards/"+strconv.FormatUint(shardID, 10)+"/checksum", nil, func(r io.Reader) error {
+		return json.NewDecoder(r).Decode(&remote)
+	}); err != nil {
+		return nil, err
+	}
+	return local.Diff(&remote), nil
+}
@@ -0,0 +1,58 @@
+package influxdb
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultSlowQueryLogSize is the number of slow queries kept by the server.
+const DefaultSlowQueryLogSize = 100
+
+// SlowQuery represents a statement that ran longer than the slow query threshold.
+type SlowQuery struct {
+	Statement string        // statement text
+	User      string        // user name, if authenticated
+	Database  string        // default database
+	Duration  time.Duration // execution time
+	ShardN    int           // shards overlapping the time range
+	Time      time.Time     // start time
+}
+
+// SlowQueryLog represents a fixed size ring buffer of the most recent slow queries.
+// It is safe for concurrent use.
+type SlowQueryLog struct {
+	mu      sync.Mutex
+	queries []*SlowQuery
+	next    int // index of the next query to overwrite
+}
+
+// NewSlowQueryLog returns a new instance of SlowQueryLog that holds up to n queries.
+func NewSlowQueryLog(n int) *SlowQueryLog {
+	return &SlowQueryLog{queries: make([]*SlowQuery, 0, n)}
+}
+
+// Add records a slow query. The oldest query is dropped if the log is full.
+func (l *SlowQueryLog) Add(q *SlowQuery) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if cap(l.queries) == 0 {
+		return
+	} else if len(l.queries) < cap(l.queries) {
+		l.queries = append(l.queries, q)
+		return
+	}
+	l.queries[l.next] = q
+	l.next = (l.next + 1) % len(l.queries)
+}
+
+// Queries returns the recorded queries from oldest to newest.
+func (l *SlowQueryLog) Queries() []*SlowQuery {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	a := make([]*SlowQuery, 0, len(l.queries))
+	a = append(a, l.queries[l.next:]...)
+	a = append(a, l.queries[:l.next]...)
+	return a
+}
@@ -0,0 +1,233 @@
+package influxdb
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/influxdb/influxdb/messaging"
+)
+
+// SnapshotManifest describes a snapshot taken by every data node in the
+// cluster at the same broker index. A restore tool copies each data node's
+// files from the node's snapshot directory back into its data directory.
+type SnapshotManifest struct {
+	ID        string              `json:"id"`
+	Index     uint64              `json:"index"`
+	Time      time.Time           `json:"time"`
+	DataNodes []*DataNodeSnapshot `json:"dataNodes"`
+}
+
+// DataNodeSnapshot describes the files written by a single data node.
+type DataNodeSnapshot struct {
+	ID    uint64          `json:"id"`
+	URL   string          `json:"url"`
+	Path  string          `json:"path"` // snapshot directory on the data node
+	Index uint64          `json:"index"`
+	Files []*SnapshotFile `json:"files"`
+}
+
+// SnapshotFile describes a file in a data node's snapshot directory.
+type SnapshotFile struct {
+	Name     string `json:"name"`              // path relative to the snapshot directory
+	ShardID  uint64 `json:"shardID,omitempty"` // zero for the metastore
+	Size     int64  `json:"size"`
+	Checksum string `json:"checksum"` // hex encoded sha256 of the file
+}
+
+type createSnapshotCommand struct {
+	ID   string    `json:"id"`
+	Time time.Time `json:"time"`
+}
+
+// snapshotDir returns the directory that a snapshot is written to.
+func (s *Server) snapshotDir(id string) string {
+	if s.SnapshotPath != "" {
+		return filepath.Join(s.SnapshotPath, id)
+	}
+	return filepath.Join(s.path, "snapshots", id)
+}
+
+// CreateClusterSnapshot snapshots the metastore and shards of every data node
+// at the same broker index. The snapshot is requested through the broker so
+// each node takes it after applying every message before it and applies no
+// further messages until it is written. Writes sent directly to shards with
+// ForwardWrites bypass the broker and may be missing from some replicas.
+// Returns an error if any data node fails to take the snapshot.
+func (s *Server) CreateClusterSnapshot() (*SnapshotManifest, error) {
+	now := time.Now().UTC()
+	c := &createSnapshotCommand{ID: now.Format("20060102T150405Z"), Time: now}
+	index, err := s.broadcast(createSnapshotMessageType, c)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &SnapshotManifest{ID: c.ID, Index: index, Time: c.Time}
+	id := s.ID()
+	for _, n := range s.DataNodes() {
+		var ns *DataNodeSnapshot
+		if n.ID == id {
+			ns, err = s.SnapshotManifest(c.ID)
+		} else {
+			ns, err = s.getSnapshotManifest(n.URL, c.ID, index)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("data node %d: %s", n.ID, err)
+		}
+		ns.ID, ns.URL = n.ID, n.URL.String()
+		m.DataNodes = append(m.DataNodes, ns)
+	}
+
+	// Keep the cluster manifest alongside the local node's snapshot.
+	if err := writeSnapshotManifest(filepath.Join(s.snapshotDir(c.ID), "cluster.json"), m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// SnapshotManifest returns the files this data node wrote for a snapshot.
+func (s *Server) SnapshotManifest(id string) (*DataNodeSnapshot, error) {
+	s.mu.RLock()
+	path := filepath.Join(s.snapshotDir(id), "manifest.json")
+	s.mu.RUnlock()
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, ErrSnapshotNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var ns DataNodeSnapshot
+	if err := json.NewDecoder(f).Decode(&ns); err != nil {
+		return nil, err
+	}
+	return &ns, nil
+}
+
+// getSnapshotManifest requests the files another data node wrote for a
+// snapshot. The node waits until it has applied the snapshot's index.
+func (s *Server) getSnapshotManifest(nodeURL *url.URL, id string, index uint64) (*DataNodeSnapshot, error) {
+	u := copyURL(nodeURL)
+	u.Path = "/snapshots/" + id
+	u.RawQuery = url.Values{"index": {strconv.FormatUint(index, 10)}}.Encode()
+	resp, err := s.doNodeRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("snapshot manifest: %s", resp.Status)
+	}
+
+	var ns DataNodeSnapshot
+	if err := json.NewDecoder(resp.Body).Decode(&ns); err != nil {
+		return nil, err
+	}
+	return &ns, nil
+}
+
+// applyCreateSnapshot writes copies of the metastore and the local shards to
+// the snapshot directory. Messages are applied one at a time so no other
+// changes are applied while the snapshot is taken.
+func (s *Server) applyCreateSnapshot(m *messaging.Message) error {
+	var c createSnapshotCommand
+	mustDecodeCommand(m.Data, &c)
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	dir := s.snapshotDir(c.ID)
+	if _, err := os.Stat(dir); err == nil {
+		return ErrSnapshotExists
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "shards"), 0700); err != nil {
+		return err
+	}
+
+	ns := &DataNodeSnapshot{ID: s.id, Path: dir, Index: m.Index}
+
+	// Copy the metastore.
+	f, err := writeSnapshotFile(dir, "meta", func(w io.Writer) error {
+		return s.meta.view(func(tx *metatx) error {
+			_, err := tx.WriteTo(w)
+			return err
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("snapshot meta: %s", err)
+	}
+	ns.Files = append(ns.Files, f)
+
+	// Copy the local shards in a consistent order.
+	var shardIDs []uint64
+	for id, sh := range s.shards {
+		if sh.hasStore() {
+			shardIDs = append(shardIDs, id)
+		}
+	}
+	sort.Sort(uint64Slice(shardIDs))
+	for _, id := range shardIDs {
+		sh := s.shards[id]
+		f, err := writeSnapshotFile(dir, filepath.Join("shards", strconv.FormatUint(id, 10)), sh.copy)
+		if err != nil {
+			return fmt.Errorf("snapshot shard %d: %s", id, err)
+		}
+		f.ShardID = id
+		ns.Files = append(ns.Files, f)
+	}
+
+	// The manifest is written last so its presence marks a complete snapshot.
+	return writeSnapshotManifest(filepath.Join(dir, "manifest.json"), ns)
+}
+
+// writeSnapshotFile creates a file in a snapshot directory from the data
+// written by fn and returns its size and checksum.
+func writeSnapshotFile(dir, name string, fn func(io.Writer) error) (*SnapshotFile, error) {
+	f, err := os.Create(filepath.Join(dir, name))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	cw := &countingWriter{w: io.MultiWriter(f, h)}
+	if err := fn(cw); err != nil {
+		return nil, err
+	} else if err := f.Sync(); err != nil {
+		return nil, err
+	}
+	return &SnapshotFile{Name: name, Size: cw.n, Checksum: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+// writeSnapshotManifest encodes a manifest as JSON to path.
+func writeSnapshotManifest(path string, v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, b, 0600)
+}
+
+// countingWriter counts the bytes written to the underlying writer.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,108 @@
+package influxdb
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Stats represents a collection of named counters.
+// It is safe for concurrent use.
+type Stats struct {
+	mu     sync.RWMutex
+	name   string
+	values map[string]int64
+}
+
+// NewStats returns a new instance of Stats with the given name.
+func NewStats(name string) *Stats {
+	return &Stats{
+		name:   name,
+		values: make(map[string]int64),
+	}
+}
+
+// Name returns the name of the stats collection.
+func (s *Stats) Name() string { return s.name }
+
+// Add adds delta to the counter for key.
+func (s *Stats) Add(key string, delta int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[key] += delta
+}
+
+// Inc increments the counter for key by one.
+func (s *Stats) Inc(key string) { s.Add(key, 1) }
+
+// Set sets the counter for key to value.
+func (s *Stats) Set(key string, value int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[key] = value
+}
+
+// durationBuckets are the upper bounds of the buckets counting observed
+// durations, with the suffixes of the buckets' counter keys.
+var durationBuckets = []struct {
+	max    time.Duration
+	suffix string
+}{
+	{time.Millisecond, "Lt1ms"},
+	{10 * time.Millisecond, "Lt10ms"},
+	{100 * time.Millisecond, "Lt100ms"},
+	{time.Second, "Lt1s"},
+}
+
+// Observe records a duration as a histogram of counters for key: the number
+// of observations (keyCount), their total in nanoseconds (keyNs), and the
+// number of observations in each bucket (keyLt1ms, keyLt10ms, keyLt100ms,
+// keyLt1s & keyGe1s).
+func (s *Stats) Observe(key string, d time.Duration) {
+	bucket := key + "Ge1s"
+	for _, b := range durationBuckets {
+		if d < b.max {
+			bucket = key + b.suffix
+			break
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[key+"Count"]++
+	s.values[key+"Ns"] += int64(d)
+	s.values[bucket]++
+}
+
+// Get returns the value of the counter for key. Returns zero if not set.
+func (s *Stats) Get(key string) int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.values[key]
+}
+
+// Walk calls fn for each counter in key order.
+func (s *Stats) Walk(fn func(key string, value int64)) {
+	s.mu.RLock()
+	keys := make([]string, 0, len(s.values))
+	for k := range s.values {
+		keys = append(keys, k)
+	}
+	values := make(map[string]int64, len(s.values))
+	for k, v := range s.values {
+		values[k] = v
+	}
+	s.mu.RUnlock()
+
+	sort.Strings(keys)
+	for _, k := range keys {
+		fn(k, values[k])
+	}
+}
+
+// Snapshot returns a copy of the stats.
+func (s *Stats) Snapshot() *Stats {
+	other := NewStats(s.name)
+	s.Walk(func(k string, v int64) { other.values[k] = v })
+	return other
+}
@@ -0,0 +1,205 @@
+package influxdb
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultSubscriptionRetryDelay is the time to wait before retrying broker
+	// subscription changes that failed.
+	DefaultSubscriptionRetryDelay = 1 * time.Second
+
+	// DefaultMaxSubscriptionRetryDelay is the longest time to wait between
+	// retries of failed subscription changes.
+	DefaultMaxSubscriptionRetryDelay = 1 * time.Minute
+)
+
+// subscriptionSet tracks the shard topics the local replica is subscribed to
+// on the broker through the current messaging client. It is safe for
+// concurrent use.
+type subscriptionSet struct {
+	mu     sync.Mutex
+	gen    int             // incremented when the messaging client changes
+	topics map[uint64]bool // subscribed topics
+	notify chan struct{}   // wakes the subscription manager
+}
+
+// newSubscriptionSet returns a new instance of subscriptionSet.
+func newSubscriptionSet() *subscriptionSet {
+	return &subscriptionSet{
+		topics: make(map[uint64]bool),
+		notify: make(chan struct{}, 1),
+	}
+}
+
+// reset forgets every subscription when a new messaging client is attached
+// since the broker's subscriptions for the replica are unknown. Returns the
+// new generation and the channel that wakes its subscription manager.
+func (a *subscriptionSet) reset() (int, chan struct{}) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.gen++
+	a.topics = make(map[uint64]bool)
+	a.notify = make(chan struct{}, 1)
+	return a.gen, a.notify
+}
+
+// set records whether the replica is subscribed to a topic. Changes made
+// through the client of an earlier generation are ignored.
+func (a *subscriptionSet) set(gen int, topicID uint64, subscribed bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if gen != a.gen {
+		return
+	} else if subscribed {
+		a.topics[topicID] = true
+	} else {
+		delete(a.topics, topicID)
+	}
+}
+
+// diff returns the current generation, the topics in want that aren't
+// subscribed and the subscribed topics not in want. Topics are sorted.
+func (a *subscriptionSet) diff(want map[uint64]bool) (gen int, add, remove []uint64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for id := range want {
+		if !a.topics[id] {
+			add = append(add, id)
+		}
+	}
+	for id := range a.topics {
+		if !want[id] {
+			remove = append(remove, id)
+		}
+	}
+	sort.Sort(uint64Slice(add))
+	sort.Sort(uint64Slice(remove))
+	return a.gen, add, remove
+}
+
+// generation returns the current generation.
+func (a *subscriptionSet) generation() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.gen
+}
+
+// wake signals the subscription manager to reconcile. It never blocks.
+func (a *subscriptionSet) wake() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	select {
+	case a.notify <- struct{}{}:
+	default:
+	}
+}
+
+// subscribe subscribes the local replica to a shard's topic. A failure is
+// retried by the subscription manager. Lock must be held.
+func (s *Server) subscribe(topicID uint64) {
+	gen := s.subscriptions.generation()
+	if err := s.client.Subscribe(s.id, topicID); err != nil {
+		log.Printf("unable to subscribe: replica=%d, topic=%d, err=%s", s.id, topicID, err)
+		s.subscriptions.wake()
+		return
+	}
+	s.subscriptions.set(gen, topicID, true)
+}
+
+// unsubscribe removes the local replica's subscription to a shard's topic.
+// A failure is retried by the subscription manager. Lock must be held.
+func (s *Server) unsubscribe(topicID uint64) {
+	gen := s.subscriptions.generation()
+	if err := s.client.Unsubscribe(s.id, topicID); err != nil {
+		log.Printf("unable to unsubscribe: replica=%d, topic=%d, err=%s", s.id, topicID, err)
+		s.subscriptions.wake()
+		return
+	}
+	s.subscriptions.set(gen, topicID, false)
+}
+
+// ownedShardTopics returns the topics of the shards this server owns.
+// Lock must be held.
+func (s *Server) ownedShardTopics() map[uint64]bool {
+	topics := make(map[uint64]bool)
+	for _, db := range s.databases {
+		for _, rp := range db.policies {
+			for _, g := range rp.shardGroups {
+				for _, sh := range g.Shards {
+					if sh.HasDataNodeID(s.id) {
+						topics[sh.ID] = true
+					}
+				}
+			}
+		}
+	}
+	return topics
+}
+
+// reconcileSubscriptions subscribes to the topics of owned shards and
+// unsubscribes from the topics of shards that are no longer owned. The broker
+// is called outside the lock since it streams a topic's unread messages to the
+// processor as the subscription is added. Ownership that changes meanwhile is
+// picked up by reconciling again until nothing differs.
+func (s *Server) reconcileSubscriptions() error {
+	for {
+		s.mu.RLock()
+		client, id := s.client, s.id
+		gen, add, remove := s.subscriptions.diff(s.ownedShardTopics())
+		s.mu.RUnlock()
+		if client == nil || id == 0 || (len(add) == 0 && len(remove) == 0) {
+			return nil
+		}
+
+		var err error
+		for _, topicID := range add {
+			if e := client.Subscribe(id, topicID); e != nil {
+				err = fmt.Errorf("subscribe: topic=%d: %s", topicID, e)
+				continue
+			}
+			s.subscriptions.set(gen, topicID, true)
+		}
+		for _, topicID := range remove {
+			if e := client.Unsubscribe(id, topicID); e != nil {
+				err = fmt.Errorf("unsubscribe: topic=%d: %s", topicID, e)
+				continue
+			}
+			s.subscriptions.set(gen, topicID, false)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// manageSubscriptions runs in a separate goroutine for each messaging client.
+// It reconciles the client's subscriptions when it starts and whenever a
+// subscription change fails, retrying failures with a doubling delay.
+func (s *Server) manageSubscriptions(notify chan struct{}, done chan struct{}) {
+	delay := s.SubscriptionRetryDelay
+	for {
+		var retry <-chan time.Time
+		if err := s.reconcileSubscriptions(); err != nil {
+			log.Printf("reconcile subscriptions: %s", err)
+			s.stats.Inc("subscriptionRetry")
+			retry = time.After(delay)
+			if delay *= 2; delay > DefaultMaxSubscriptionRetryDelay {
+				delay = DefaultMaxSubscriptionRetryDelay
+			}
+		} else {
+			delay = s.SubscriptionRetryDelay
+		}
+
+		select {
+		case <-done:
+			return
+		case <-notify:
+		case <-retry:
+		}
+	}
+}
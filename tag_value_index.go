@@ -0,0 +1,152 @@
+package influxdb
+
+import (
+	"regexp"
+	"regexp/syntax"
+	"sort"
+	"strings"
+)
+
+// tagValueIndex indexes the values of a tag key so that a regex is only
+// matched against the values that can contain its literal parts. Values are
+// kept sorted to find those starting with the prefix of an anchored regex and
+// each trigram lists the values containing it to find those containing the
+// literals of an unanchored regex.
+type tagValueIndex struct {
+	values   []string         // sorted tag values
+	trigrams map[string][]int // trigram to the sorted positions of values containing it
+}
+
+// newTagValueIndex returns an index of the values of a tag key.
+func newTagValueIndex(values map[string]seriesIDs) *tagValueIndex {
+	idx := &tagValueIndex{
+		values:   make([]string, 0, len(values)),
+		trigrams: make(map[string][]int),
+	}
+	for v := range values {
+		idx.values = append(idx.values, v)
+	}
+	sort.Strings(idx.values)
+
+	for i, v := range idx.values {
+		for j := 0; j+3 <= len(v); j++ {
+			t := v[j : j+3]
+
+			// Positions are appended in order so a value containing the
+			// same trigram twice is already the last position.
+			if a := idx.trigrams[t]; len(a) == 0 || a[len(a)-1] != i {
+				idx.trigrams[t] = append(a, i)
+			}
+		}
+	}
+	return idx
+}
+
+// match returns the values matching re in sorted order.
+func (idx *tagValueIndex) match(re *regexp.Regexp) []string {
+	var a []string
+	for _, v := range idx.candidates(re) {
+		if re.MatchString(v) {
+			a = append(a, v)
+		}
+	}
+	return a
+}
+
+// candidates returns the values that may match re. Returns all values if the
+// regex has no literal parts that every match must contain.
+func (idx *tagValueIndex) candidates(re *regexp.Regexp) []string {
+	prefix, literals := regexLiterals(re)
+
+	// Values matching an anchored regex are in the range sharing its prefix.
+	if prefix != "" {
+		i := sort.SearchStrings(idx.values, prefix)
+		j := i
+		for j < len(idx.values) && strings.HasPrefix(idx.values[j], prefix) {
+			j++
+		}
+		return idx.values[i:j]
+	}
+
+	// Otherwise values must contain every trigram of the literals.
+	var positions []int
+	var found bool
+	for _, lit := range literals {
+		for j := 0; j+3 <= len(lit); j++ {
+			p := idx.trigrams[lit[j:j+3]]
+			if !found {
+				positions, found = p, true
+			} else {
+				positions = intersectPositions(positions, p)
+			}
+			if len(positions) == 0 {
+				return nil
+			}
+		}
+	}
+	if !found {
+		return idx.values
+	}
+
+	a := make([]string, len(positions))
+	for i, p := range positions {
+		a[i] = idx.values[p]
+	}
+	return a
+}
+
+// regexLiterals returns the literal prefix of every match of an anchored regex
+// and the literals of at least three bytes that every match must contain.
+// Case-insensitive literals are ignored.
+func regexLiterals(re *regexp.Regexp) (prefix string, literals []string) {
+	r, err := syntax.Parse(re.String(), syntax.Perl)
+	if err != nil {
+		return "", nil
+	}
+	r = r.Simplify()
+
+	// A capturing group around the whole regex doesn't change what it matches.
+	for r.Op == syntax.OpCapture {
+		r = r.Sub[0]
+	}
+
+	subs := []*syntax.Regexp{r}
+	if r.Op == syntax.OpConcat {
+		subs = r.Sub
+	}
+
+	if len(subs) > 1 && subs[0].Op == syntax.OpBeginText {
+		for _, sub := range subs[1:] {
+			if sub.Op != syntax.OpLiteral || sub.Flags&syntax.FoldCase != 0 {
+				break
+			}
+			prefix += string(sub.Rune)
+		}
+	}
+
+	for _, sub := range subs {
+		if sub.Op != syntax.OpLiteral || sub.Flags&syntax.FoldCase != 0 {
+			continue
+		}
+		if lit := string(sub.Rune); len(lit) >= 3 {
+			literals = append(literals, lit)
+		}
+	}
+	return prefix, literals
+}
+
+// intersectPositions returns the positions in both sorted lists.
+func intersectPositions(a, b []int) []int {
+	var other []int
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		if a[i] < b[j] {
+			i++
+		} else if a[i] > b[j] {
+			j++
+		} else {
+			other = append(other, a[i])
+			i, j = i+1, j+1
+		}
+	}
+	return other
+}
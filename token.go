@@ -0,0 +1,70 @@
+package influxdb
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+)
+
+// tokenHeader is the encoded header of every token. Tokens are JSON Web Tokens
+// signed with HMAC SHA-256.
+var tokenHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// TokenClaims represents the claims of a signed bearer token.
+type TokenClaims struct {
+	// The name of the user the token authenticates.
+	Username string `json:"sub"`
+
+	// The time the token expires, in seconds since the epoch.
+	// A zero value never expires.
+	ExpiresAt int64 `json:"exp,omitempty"`
+
+	// The databases the token is limited to. A token without databases
+	// grants all of the user's privileges.
+	Databases []string `json:"dbs,omitempty"`
+}
+
+// SignToken returns a token for the claims signed with secret.
+func SignToken(claims *TokenClaims, secret []byte) (string, error) {
+	b, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	payload := tokenHeader + "." + base64.RawURLEncoding.EncodeToString(b)
+	return payload + "." + tokenSignature(payload, secret), nil
+}
+
+// ParseToken verifies a token's signature and returns its claims.
+// Expiry is not checked.
+func ParseToken(token string, secret []byte) (*TokenClaims, error) {
+	// Split into header, claims & signature.
+	a := strings.Split(token, ".")
+	if len(a) != 3 || a[0] != tokenHeader {
+		return nil, ErrInvalidToken
+	}
+
+	// Verify the signature before looking at the claims.
+	sig := tokenSignature(a[0]+"."+a[1], secret)
+	if !hmac.Equal([]byte(sig), []byte(a[2])) {
+		return nil, ErrInvalidToken
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(a[1])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	var claims TokenClaims
+	if err := json.Unmarshal(b, &claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+	return &claims, nil
+}
+
+// tokenSignature returns the encoded signature of a token's header and claims.
+func tokenSignature(payload string, secret []byte) string {
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
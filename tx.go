@@ -1,7 +1,13 @@
 package influxdb
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
 	"sync"
 	"time"
 
@@ -16,8 +22,21 @@ type tx struct {
 	server *Server
 	opened bool
 	now    time.Time
+	user   *User // restricts the measurements read, if set
 
-	itrs []*shardIterator // shard iterators
+	itrs []txIterator // local & remote shard iterators
+
+	// Read transactions on local shards, shared by the shard's iterators.
+	// Bolt can deadlock if a goroutine begins a second read transaction on
+	// a store while a write transaction is waiting to remap it.
+	txns map[*Shard]*bolt.Tx
+}
+
+// txIterator represents an iterator that is opened and closed with the transaction.
+type txIterator interface {
+	influxql.Iterator
+	open() error
+	close() error
 }
 
 // newTx return a new initialized Tx.
@@ -28,6 +47,20 @@ func newTx(server *Server) *tx {
 	}
 }
 
+// userDB represents a database whose transactions only read the measurements
+// a user is authorized to read.
+type userDB struct {
+	server *Server
+	user   *User
+}
+
+// Begin returns a new transaction restricted to the user's measurements.
+func (db *userDB) Begin() (influxql.Tx, error) {
+	tx := newTx(db.server)
+	tx.user = db.user
+	return tx, nil
+}
+
 // SetNow sets the current time for the transaction.
 func (tx *tx) SetNow(now time.Time) { tx.now = now }
 
@@ -41,6 +74,14 @@ func (tx *tx) Open() error {
 
 	// Open each iterator individually. If any fail close the transaction and error out
 	for _, itr := range tx.itrs {
+		if itr, ok := itr.(*shardIterator); ok {
+			txn, err := tx.shardTx(itr.shard)
+			if err != nil {
+				_ = tx.close()
+				return err
+			}
+			itr.txn = txn
+		}
 		if err := itr.open(); err != nil {
 			_ = tx.close()
 			return err
@@ -65,26 +106,67 @@ func (tx *tx) close() error {
 		_ = itr.close()
 	}
 
+	for sh, txn := range tx.txns {
+		_ = txn.Rollback()
+		sh.release()
+	}
+	tx.txns = nil
+
 	return nil
 }
 
-// CreateIterators returns an iterator for a simple select statement.
-func (tx *tx) CreateIterators(stmt *influxql.SelectStatement) ([]influxql.Iterator, error) {
-	// Parse the source segments.
-	database, policyName, measurement, err := splitIdent(stmt.Source.(*influxql.Measurement).Name)
+// shardTx returns the read transaction on a local shard's store, beginning
+// one if the shard hasn't been read by the transaction yet.
+func (tx *tx) shardTx(sh *Shard) (*bolt.Tx, error) {
+	if txn := tx.txns[sh]; txn != nil {
+		return txn, nil
+	}
+
+	// Open the data store. It is kept open until the transaction is closed.
+	db, err := sh.acquire()
 	if err != nil {
 		return nil, err
 	}
+	txn, err := db.Begin(false)
+	if err != nil {
+		sh.release()
+		return nil, err
+	}
 
-	// Grab time range from statement.
-	tmin, tmax := influxql.TimeRange(stmt.Condition)
+	if tx.txns == nil {
+		tx.txns = make(map[*Shard]*bolt.Tx)
+	}
+	tx.txns[sh] = txn
+	return txn, nil
+}
+
+// queryTimeRange returns the time range of the points read by a select
+// statement with a condition, along with the condition with now() replaced.
+// Without a lower bound the range starts at the first point and without an
+// upper bound it ends at now.
+func queryTimeRange(condition influxql.Expr, now time.Time) (influxql.Expr, time.Time, time.Time) {
+	condition = influxql.Reduce(condition, &influxql.NowValuer{Now: now})
+	tmin, tmax := influxql.TimeRange(condition)
 	if tmin.IsZero() {
 		tmin = time.Unix(0, 1)
 	}
 	if tmax.IsZero() {
-		tmax = tx.now
+		tmax = now
+	}
+	return condition, tmin, tmax
+}
+
+// CreateIterators returns an iterator for a simple select statement.
+func (tx *tx) CreateIterators(stmt *influxql.SelectStatement) ([]influxql.Iterator, error) {
+	// Parse the source segments.
+	database, policyName, measurement, err := splitIdent(stmt.Source.(*influxql.Measurement).Name)
+	if err != nil {
+		return nil, err
 	}
 
+	// Grab time range from statement.
+	_, tmin, tmax := queryTimeRange(stmt.Condition, tx.now)
+
 	// Find database and retention policy.
 	db := tx.server.databases[database]
 	if db == nil {
@@ -98,7 +180,7 @@ func (tx *tx) CreateIterators(stmt *influxql.SelectStatement) ([]influxql.Iterat
 	// Find shard groups within time range.
 	var shardGroups []*ShardGroup
 	for _, group := range rp.shardGroups {
-		if timeBetweenInclusive(group.StartTime, tmin, tmax) || timeBetweenInclusive(group.EndTime, tmin, tmax) {
+		if group.overlaps(tmin, tmax) {
 			shardGroups = append(shardGroups, group)
 		}
 	}
@@ -112,6 +194,11 @@ func (tx *tx) CreateIterators(stmt *influxql.SelectStatement) ([]influxql.Iterat
 		return nil, err
 	}
 
+	// Ensure the user can read the measurement.
+	if tx.user != nil && !tx.user.AuthorizeMeasurement(influxql.ReadPrivilege, database, measurement) {
+		return nil, ErrAuthorize{text: fmt.Sprintf("%s not authorized to read %s", tx.user.Name, measurement)}
+	}
+
 	// Find measurement.
 	m, err := tx.server.measurement(database, measurement)
 	if err != nil {
@@ -138,23 +225,23 @@ func (tx *tx) CreateIterators(stmt *influxql.SelectStatement) ([]influxql.Iterat
 		for _, group := range shardGroups {
 			// TODO: only create iterators for the shards we actually have to hit in a group
 			for _, sh := range group.Shards {
-
-				// create a series cursor for each unique series id
-				cursors := make([]*seriesCursor, 0, len(set))
-				for id, cond := range set {
-					cursors = append(cursors, &seriesCursor{id: id, condition: cond, decoder: d})
-				}
-
-				// create the shard iterator that will map over all series for the shard
-				itr := &shardIterator{
-					measurement: m,
-					fieldName:   f.Name,
-					fieldID:     f.ID,
-					tags:        tag,
-					db:          sh.store,
-					cursors:     cursors,
-					tmin:        tmin.UnixNano(),
-					tmax:        tmax.UnixNano(),
+				// Shards are only opened on the data nodes that own them so
+				// read any other shard from its owners over HTTP.
+				var itr txIterator
+				if sh.hasStore() {
+					itr = newShardIterator(m, f, tag, set, d, sh, tmin.UnixNano(), tmax.UnixNano(), stmt.TimeAscending())
+				} else {
+					itr = &remoteShardIterator{
+						server:    tx.server,
+						nodes:     tx.server.shardReplicas(sh),
+						replicas:  tx.server.replicas,
+						shardID:   sh.ID,
+						stmt:      stmt.String(),
+						tags:      tag,
+						tmin:      tmin.UnixNano(),
+						tmax:      tmax.UnixNano(),
+						ascending: stmt.TimeAscending(),
+					}
 				}
 
 				// Add to tx so the bolt transaction can be opened/closed.
@@ -187,18 +274,52 @@ type shardIterator struct {
 	tags        string // encoded dimensional tag values
 	cursors     []*seriesCursor
 	keyValues   []keyValue
-	db          *bolt.DB // data stores by shard id
-	txn         *bolt.Tx // read transactions by shard id
+	shard       *Shard
+	txn         *bolt.Tx // read transaction on the shard's store
+	ownsTxn     bool     // txn was begun by the iterator rather than its tx
 	tmin, tmax  int64
+	ascending   bool // iterate in chronological order
 }
 
+// newShardIterator returns an iterator over a set of series in a local shard.
+func newShardIterator(m *Measurement, f *Field, tags string, set map[uint32]influxql.Expr, d fieldDecoder, sh *Shard, tmin, tmax int64, ascending bool) *shardIterator {
+	// create a series cursor for each unique series id
+	cursors := make([]*seriesCursor, 0, len(set))
+	for id, cond := range set {
+		cursors = append(cursors, &seriesCursor{id: id, condition: cond, decoder: d, ascending: ascending})
+	}
+
+	// create the shard iterator that will map over all series for the shard
+	return &shardIterator{
+		measurement: m,
+		fieldName:   f.Name,
+		fieldID:     f.ID,
+		tags:        tags,
+		shard:       sh,
+		cursors:     cursors,
+		tmin:        tmin,
+		tmax:        tmax,
+		ascending:   ascending,
+	}
+}
+
+// open opens cursors for the iterator's series. If a read transaction hasn't
+// been set by the iterator's tx then one is begun on the shard's store.
 func (i *shardIterator) open() error {
-	// Open the data store
-	txn, err := i.db.Begin(false)
-	if err != nil {
-		return err
+	if i.txn == nil {
+		// Open the data store. It is kept open until the iterator is closed.
+		db, err := i.shard.acquire()
+		if err != nil {
+			return err
+		}
+		txn, err := db.Begin(false)
+		if err != nil {
+			i.shard.release()
+			return err
+		}
+		i.txn, i.ownsTxn = txn, true
 	}
-	i.txn = txn
+	i.shard.recordRead()
 
 	// Open cursors for each series id
 	for _, c := range i.cursors {
@@ -219,17 +340,28 @@ func (i *shardIterator) open() error {
 }
 
 func (i *shardIterator) close() error {
-	_ = i.txn.Rollback()
+	if i.txn != nil && i.ownsTxn {
+		_ = i.txn.Rollback()
+		i.shard.release()
+	}
+	i.txn, i.ownsTxn = nil, false
 	return nil
 }
 
 func (i *shardIterator) Tags() string { return i.tags }
 
+// Next returns the next point across all series cursors. Points are returned
+// oldest first, or newest first if the iterator is descending. The time range
+// is inclusive at both ends, like the cursors' range, so that a point at
+// exactly "time <= tmax" is returned.
 func (i *shardIterator) Next() (key int64, data []byte, value interface{}) {
 	min := -1
 
 	for ind, kv := range i.keyValues {
-		if kv.key != 0 && kv.key < i.tmax {
+		if kv.key == 0 || kv.key > i.tmax {
+			continue
+		}
+		if min == -1 || (i.ascending && kv.key < i.keyValues[min].key) || (!i.ascending && kv.key > i.keyValues[min].key) {
 			min = ind
 		}
 	}
@@ -248,6 +380,133 @@ func (i *shardIterator) Next() (key int64, data []byte, value interface{}) {
 	return key, data, value
 }
 
+// remoteShardClient requests points from other data nodes. Only the wait for
+// the response headers is bounded so that long streams are not cut off.
+var remoteShardClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		ResponseHeaderTimeout: DefaultRemoteShardTimeout,
+	},
+}
+
+// remoteShardIterator represents an iterator over a single tagset in a shard
+// owned by other data nodes. Points are streamed from the first owner that
+// responds. If an owner fails mid-stream, the remaining points are read from
+// the next owner. The iterator fails if every owner fails.
+type remoteShardIterator struct {
+	server     *Server
+	nodes      []*DataNode // owners, in the order to try them
+	replicas   *replicaSelector
+	shardID    uint64
+	stmt       string // simple select statement
+	tags       string // encoded dimensional tag values
+	tmin, tmax int64
+	ascending  bool
+
+	resp *http.Response
+	dec  *json.Decoder
+	err  error // set if the stream failed on every owner
+}
+
+func (i *remoteShardIterator) open() error {
+	if len(i.nodes) == 0 {
+		return ErrDataNodeNotFound
+	}
+
+	// Try each owner in turn until one serves the shard.
+	var err error
+	for len(i.nodes) > 0 {
+		n := i.nodes[0]
+		if i.resp, err = i.get(n.URL); err == nil {
+			i.replicas.succeed(n.ID)
+			i.dec = json.NewDecoder(i.resp.Body)
+			return nil
+		}
+		i.replicas.fail(n.ID, time.Now())
+		i.nodes = i.nodes[1:]
+	}
+	return err
+}
+
+// get requests the shard's points from a data node.
+func (i *remoteShardIterator) get(u *url.URL) (*http.Response, error) {
+	other := *u
+	other.Path = fmt.Sprintf("/shards/%d/points", i.shardID)
+	other.RawQuery = url.Values{
+		"q":    {i.stmt},
+		"tags": {i.tags},
+		"tmin": {strconv.FormatInt(i.tmin, 10)},
+		"tmax": {strconv.FormatInt(i.tmax, 10)},
+	}.Encode()
+
+	resp, err := i.server.doNodeRequestClient(remoteShardClient, "GET", &other, nil)
+	if err != nil {
+		return nil, err
+	} else if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("remote shard %d: unexpected status: %s", i.shardID, resp.Status)
+	}
+	return resp, nil
+}
+
+func (i *remoteShardIterator) close() error {
+	if i.resp != nil {
+		_ = i.resp.Body.Close()
+	}
+	return nil
+}
+
+func (i *remoteShardIterator) Tags() string { return i.tags }
+
+// Err returns the error that stopped the iterator if no owner could stream
+// all of the shard's points.
+func (i *remoteShardIterator) Err() error { return i.err }
+
+// Next returns the next point streamed from the remote data node.
+func (i *remoteShardIterator) Next() (key int64, data []byte, value interface{}) {
+	for i.dec != nil {
+		var p remotePoint
+		err := i.dec.Decode(&p)
+		if err == nil && p.End {
+			i.close()
+			i.resp, i.dec = nil, nil
+			break
+		} else if err == nil && p.Time == 0 {
+			err = errors.New("invalid point")
+		} else if err == nil {
+			// Narrow the time range so a failover resumes after this point.
+			if i.ascending {
+				i.tmin = p.Time + 1
+			} else {
+				i.tmax = p.Time - 1
+			}
+			return p.Time, nil, p.Value
+		} else if err == io.EOF {
+			err = io.ErrUnexpectedEOF // the stream ended without an end record
+		}
+
+		// The stream broke so continue from the next owner.
+		i.close()
+		i.resp, i.dec = nil, nil
+		i.replicas.fail(i.nodes[0].ID, time.Now())
+		i.nodes = i.nodes[1:]
+		if e := i.open(); e != nil {
+			i.err = fmt.Errorf("remote shard %d: %s", i.shardID, err)
+			break
+		}
+	}
+	return 0, nil, nil
+}
+
+// remotePoint is the wire format of a point streamed between data nodes.
+// A complete stream ends with a record marked as the end so that a stream
+// that was cut short isn't mistaken for the end of the shard's points.
+type remotePoint struct {
+	Time  int64       `json:"time"`
+	Value interface{} `json:"value"`
+	End   bool        `json:"end,omitempty"`
+}
+
 type keyValue struct {
 	key   int64
 	data  []byte
@@ -256,6 +515,7 @@ type keyValue struct {
 
 type fieldDecoder interface {
 	DecodeByID(fieldID uint8, b []byte) (interface{}, error)
+	DecodeFieldsWithNames(b []byte) map[string]interface{}
 }
 
 type seriesCursor struct {
@@ -264,6 +524,7 @@ type seriesCursor struct {
 	cur         *bolt.Cursor
 	initialized bool
 	decoder     fieldDecoder
+	ascending   bool
 }
 
 func (c *seriesCursor) Next(fieldName string, fieldID uint8, tmin, tmax int64) (key int64, data []byte, value interface{}) {
@@ -277,10 +538,12 @@ func (c *seriesCursor) Next(fieldName string, fieldID uint8, tmin, tmax int64) (
 	for {
 		var k, v []byte
 		if !c.initialized {
-			k, v = c.cur.Seek(u64tob(uint64(tmin)))
+			k, v = c.seek(tmin, tmax)
 			c.initialized = true
-		} else {
+		} else if c.ascending {
 			k, v = c.cur.Next()
+		} else {
+			k, v = c.cur.Prev()
 		}
 
 		// Exit if there is no more data.
@@ -290,12 +553,16 @@ func (c *seriesCursor) Next(fieldName string, fieldID uint8, tmin, tmax int64) (
 
 		// Marshal key & value.
 		key := int64(btou64(k))
+		v, err := decompressValues(v)
+		if err != nil {
+			continue
+		}
 		value, err := c.decoder.DecodeByID(fieldID, v)
 		if err != nil {
 			continue
 		}
 
-		if key > tmax {
+		if key > tmax || key < tmin {
 			return 0, nil, nil
 		}
 
@@ -304,9 +571,9 @@ func (c *seriesCursor) Next(fieldName string, fieldID uint8, tmin, tmax int64) (
 			continue
 		}
 
-		// Evaluate condition. Move to next key/value if non-true.
+		// Evaluate condition against all of the point's fields. Move to next key/value if non-true.
 		if c.condition != nil {
-			if ok, _ := influxql.Eval(c.condition, map[string]interface{}{fieldName: value}).(bool); !ok {
+			if ok, _ := influxql.Eval(c.condition, c.decoder.DecodeFieldsWithNames(v)).(bool); !ok {
 				continue
 			}
 		}
@@ -314,3 +581,20 @@ func (c *seriesCursor) Next(fieldName string, fieldID uint8, tmin, tmax int64) (
 		return key, v, value
 	}
 }
+
+// seek moves the cursor to the first point within the time range.
+// This is the oldest point if ascending and the newest point if descending.
+func (c *seriesCursor) seek(tmin, tmax int64) (k, v []byte) {
+	if c.ascending {
+		return c.cur.Seek(u64tob(uint64(tmin)))
+	}
+
+	// Move to the first point after tmax and step back.
+	k, v = c.cur.Seek(u64tob(uint64(tmax)))
+	if k == nil {
+		return c.cur.Last()
+	} else if int64(btou64(k)) > tmax {
+		return c.cur.Prev()
+	}
+	return k, v
+}
@@ -94,3 +94,24 @@ func mapStrUint32KeyList(m map[string]uint32) []string {
 	}
 	return l
 }
+
+// removeUint64 returns a copy of a with every occurrence of v removed.
+func removeUint64(a []uint64, v uint64) []uint64 {
+	other := make([]uint64, 0, len(a))
+	for _, x := range a {
+		if x != v {
+			other = append(other, x)
+		}
+	}
+	return other
+}
+
+// containsUint64 returns true if a contains v.
+func containsUint64(a []uint64, v uint64) bool {
+	for _, x := range a {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}